@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/config"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink/audit"
+	"github.com/force-c/pg-data-listener/sink/search"
+)
+
+// defaultDrainTimeout bounds POST /admin/drain without a timeout parameter.
+const defaultDrainTimeout = 30 * time.Second
+
+// adminStatus is the response of GET /admin/status.
+type adminStatus struct {
+	Listener listener.Status `json:"listener"`
+	// Sources holds the status of the listeners of the sources besides
+	// the default one.
+	Sources map[string]listener.Status `json:"sources,omitempty"`
+	Tables  []adminTable               `json:"tables"`
+	Sinks   []string                   `json:"sinks"`
+}
+
+type adminTable struct {
+	Name    string   `json:"name"`
+	Source  string   `json:"source,omitempty"`
+	Channel string   `json:"channel,omitempty"`
+	Tenant  string   `json:"tenant,omitempty"`
+	Sinks   []string `json:"sinks"`
+}
+
+type adminResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// registerAdmin adds the admin API to mux. Every request must carry
+// "Authorization: Bearer <token>". The actions apply to the listeners of
+// all sources, or to one with ?source=<name>.
+func (s *service) registerAdmin(mux *http.ServeMux, token string) {
+	routes := map[string]http.HandlerFunc{
+		"GET /admin/status":     s.serveStatus,
+		"GET /admin/handlers":   s.serveHandlers,
+		"GET /admin/log-level":  s.serveLogLevel,
+		"PUT /admin/log-level":  s.serveSetLogLevel,
+		"POST /admin/reload":    s.serveReload,
+		"POST /admin/pause":     s.serveAction("paused", (*listener.DataListener).Pause),
+		"POST /admin/resume":    s.serveAction("resumed", (*listener.DataListener).Resume),
+		"POST /admin/reconnect": s.serveAction("reconnecting", (*listener.DataListener).Reconnect),
+		"POST /admin/flush":     s.serveAction("flushed", (*listener.DataListener).Flush),
+		"POST /admin/drain":     s.serveDrain,
+		"GET /admin/history":    s.serveHistory,
+		"GET /admin/search":     s.serveSearch,
+	}
+	for pattern, h := range routes {
+		mux.Handle(pattern, requireToken(token, h))
+	}
+}
+
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, adminResult{Status: "error", Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveStatus reports the listener status and the applied routing.
+func (s *service) serveStatus(w http.ResponseWriter, r *http.Request) {
+	dl, _ := s.listener(config.DefaultSource)
+	resp := adminStatus{Listener: dl.Status(), Tables: []adminTable{}, Sinks: []string{}}
+	for _, name := range s.names[1:] {
+		if resp.Sources == nil {
+			resp.Sources = make(map[string]listener.Status)
+		}
+		resp.Sources[name] = s.sources[name].dl.Status()
+	}
+
+	s.mu.Lock()
+	if s.cfg != nil {
+		for _, name := range s.names {
+			src := name
+			if name == config.DefaultSource {
+				src = ""
+			}
+			for _, t := range s.cfg.Source(name).Tables {
+				resp.Tables = append(resp.Tables, adminTable{Name: t.Name, Source: src, Channel: t.Channel, Tenant: t.Tenant, Sinks: t.Sinks})
+			}
+		}
+	}
+	for name := range s.sinks {
+		resp.Sinks = append(resp.Sinks, name)
+	}
+	s.mu.Unlock()
+	sort.Strings(resp.Sinks)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// serveHandlers lists the registered handlers of each channel with their
+// stats, of the default source or the one named by ?source=.
+func (s *service) serveHandlers(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("source")
+	if name == "" {
+		name = config.DefaultSource
+	}
+	dl, ok := s.listener(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, adminResult{Status: "error", Error: "unknown source " + name})
+		return
+	}
+	writeJSON(w, http.StatusOK, dl.HandlerStats())
+}
+
+func (s *service) serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"level": strings.ToLower(s.level.Level().String())})
+}
+
+// serveSetLogLevel changes the log level until the next reload, from a
+// body such as {"level": "debug"}.
+func (s *service) serveSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminResult{Status: "error", Error: err.Error()})
+		return
+	}
+	level, err := logLevel(req.Level)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, adminResult{Status: "error", Error: err.Error()})
+		return
+	}
+	s.level.Set(level)
+	s.logger.Info("log level changed", "level", level)
+	s.serveLogLevel(w, r)
+}
+
+// serveReload reloads the config.
+func (s *service) serveReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.reload(); err != nil {
+		s.logger.Error("reload failed", "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, adminResult{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, adminResult{Status: "ok"})
+}
+
+// serveDrain pauses processing and waits for queued and in-flight
+// notifications, up to the timeout parameter, e.g. ?timeout=1m.
+func (s *service) serveDrain(w http.ResponseWriter, r *http.Request) {
+	targets, ok := s.targets(w, r)
+	if !ok {
+		return
+	}
+	timeout := defaultDrainTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, adminResult{Status: "error", Error: err.Error()})
+			return
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	for _, dl := range targets {
+		if err := dl.Drain(ctx); err != nil {
+			writeJSON(w, http.StatusGatewayTimeout, adminResult{Status: "error", Error: err.Error()})
+			return
+		}
+	}
+	s.logger.Info("drained")
+	writeJSON(w, http.StatusOK, adminResult{Status: "drained"})
+}
+
+func (s *service) serveAction(status string, action func(*listener.DataListener)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		targets, ok := s.targets(w, r)
+		if !ok {
+			return
+		}
+		for _, dl := range targets {
+			action(dl)
+		}
+		s.logger.Info("admin action", "result", status, "source", r.URL.Query().Get("source"))
+		writeJSON(w, http.StatusOK, adminResult{Status: status})
+	}
+}
+
+// targets returns the listener of the source named by ?source=, or those
+// of all sources without it. An unknown source is answered with 404.
+func (s *service) targets(w http.ResponseWriter, r *http.Request) ([]*listener.DataListener, bool) {
+	name := r.URL.Query().Get("source")
+	if name == "" {
+		targets := make([]*listener.DataListener, len(s.names))
+		for i, name := range s.names {
+			targets[i] = s.sources[name].dl
+		}
+		return targets, true
+	}
+	dl, ok := s.listener(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, adminResult{Status: "error", Error: "unknown source " + name})
+		return nil, false
+	}
+	return []*listener.DataListener{dl}, true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// defaultHistoryLimit bounds the rows of GET /admin/history without a
+// limit parameter.
+const defaultHistoryLimit = 1000
+
+// serveHistory reconstructs from the history of an audit sink the row of
+// ?table= with the primary key ?key= (a JSON object), or without it the
+// rows of the table, as of ?at= (now by default). ?sink= names the audit
+// sink when there are several; ?limit= bounds the rows.
+func (s *service) serveHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	table := q.Get("table")
+	if table == "" {
+		writeJSON(w, http.StatusBadRequest, adminResult{Status: "error", Error: "table is required"})
+		return
+	}
+	at := time.Now()
+	if v := q.Get("at"); v != "" && v != "now" {
+		t, err := parseTime(v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, adminResult{Status: "error", Error: err.Error()})
+			return
+		}
+		at = t
+	}
+	history, err := s.history(q.Get("sink"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, adminResult{Status: "error", Error: err.Error()})
+		return
+	}
+
+	if key := q.Get("key"); key != "" {
+		if !json.Valid([]byte(key)) || !strings.HasPrefix(strings.TrimSpace(key), "{") {
+			writeJSON(w, http.StatusBadRequest, adminResult{Status: "error", Error: "key must be a JSON object"})
+			return
+		}
+		st, err := history.RowAt(r.Context(), table, json.RawMessage(key), at)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, adminResult{Status: "error", Error: err.Error()})
+			return
+		}
+		if st == nil {
+			writeJSON(w, http.StatusNotFound, adminResult{Status: "error", Error: "no history of the row up to " + at.Format(time.RFC3339)})
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeJSON(w, http.StatusBadRequest, adminResult{Status: "error", Error: "invalid limit " + v})
+			return
+		}
+		limit = n
+	}
+	rows, err := history.TableAt(r.Context(), table, at, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminResult{Status: "error", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"table": table, "at": at, "rows": rows})
+}
+
+// history returns the named audit sink, or the only one without a name.
+func (s *service) history(name string) (*audit.Audit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name != "" {
+		b, ok := s.sinks[name]
+		if !ok || b.audit == nil {
+			return nil, fmt.Errorf("%s is not an audit sink", name)
+		}
+		return b.audit, nil
+	}
+	var found *audit.Audit
+	for _, b := range s.sinks {
+		if b.audit == nil {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("several audit sinks, choose one with sink")
+		}
+		found = b.audit
+	}
+	if found == nil {
+		return nil, errors.New("no audit sink configured")
+	}
+	return found, nil
+}
+
+// serveSearch answers ?q= from the index of a search sink, see
+// search.Handler; ?sink= names the sink when there are several.
+func (s *service) serveSearch(w http.ResponseWriter, r *http.Request) {
+	searcher, err := s.searcher(r.URL.Query().Get("sink"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, adminResult{Status: "error", Error: err.Error()})
+		return
+	}
+	search.Handler(searcher).ServeHTTP(w, r)
+}
+
+// searcher returns the index of the named search sink, or of the only one
+// without a name.
+func (s *service) searcher(name string) (search.Searcher, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name != "" {
+		b, ok := s.sinks[name]
+		if !ok || b.search == nil {
+			return nil, fmt.Errorf("%s is not a search sink", name)
+		}
+		return b.search, nil
+	}
+	var found search.Searcher
+	for _, b := range s.sinks {
+		if b.search == nil {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("several search sinks, choose one with sink")
+		}
+		found = b.search
+	}
+	if found == nil {
+		return nil, errors.New("no search sink configured")
+	}
+	return found, nil
+}
@@ -0,0 +1,201 @@
+// Package graphql bridges a broadcast.Hub to GraphQL subscriptions over
+// WebSocket, using the graphql-transport-ws protocol spoken by graphql-ws,
+// Apollo and most gateways.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/graph-gophers/graphql-go"
+
+	"github.com/force-c/pg-data-listener/broadcast"
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// Schema is the GraphQL schema served by the bridge.
+const Schema = `
+schema {
+  query: Query
+  subscription: Subscription
+}
+
+scalar JSON
+scalar Time
+
+enum Operation {
+  INSERT
+  UPDATE
+  DELETE
+}
+
+type Query {
+  # Number of live subscriptions.
+  subscribers: Int!
+}
+
+type Subscription {
+  # Streams the changes to the given tables (names or glob patterns) and
+  # operations; omitted filters match everything. afterId replays outbox
+  # events after that id first.
+  changes(tables: [String!], operations: [Operation!], afterId: ID): Change!
+}
+
+type Change {
+  # Outbox id, null without an outbox.
+  id: ID
+  schema: String!
+  table: String!
+  operation: Operation!
+  key: JSON
+  old: JSON
+  new: JSON
+  timestamp: Time!
+}
+`
+
+const replayPage = 500
+
+type resolver struct {
+	hub      *broadcast.Hub
+	replayer broadcast.Replayer
+}
+
+func (r *resolver) Subscribers() int32 {
+	return int32(r.hub.Subscribers())
+}
+
+type changesArgs struct {
+	Tables     *[]string
+	Operations *[]string
+	AfterId    *graphql.ID
+}
+
+func (r *resolver) Changes(ctx context.Context, args changesArgs) (<-chan *change, error) {
+	var filter broadcast.Filter
+	if args.Tables != nil {
+		filter.Tables = *args.Tables
+	}
+	if args.Operations != nil {
+		filter.Operations = *args.Operations
+	}
+	var afterID int64
+	if args.AfterId != nil {
+		id, err := strconv.ParseInt(string(*args.AfterId), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid afterId %q", *args.AfterId)
+		}
+		if r.replayer == nil {
+			return nil, fmt.Errorf("replay is not enabled")
+		}
+		afterID = id
+	}
+
+	sub := r.hub.Subscribe(filter)
+	out := make(chan *change)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+
+		send := func(n *listener.ChangeNotification) bool {
+			select {
+			case out <- &change{n: n}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		lastID := afterID
+		for lastID > 0 {
+			events, err := r.replayer.ReadOutbox(ctx, lastID, replayPage)
+			if err != nil {
+				return
+			}
+			for _, n := range events {
+				lastID = n.ID
+				if filter.Match(n) && !send(n) {
+					return
+				}
+			}
+			if len(events) < replayPage {
+				break
+			}
+		}
+
+		for {
+			select {
+			case n := <-sub.C():
+				if n.ID != 0 && n.ID <= lastID {
+					continue
+				}
+				if !send(n) {
+					return
+				}
+			case <-sub.Done():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+type change struct {
+	n *listener.ChangeNotification
+}
+
+func (c *change) ID() *graphql.ID {
+	if c.n.ID == 0 {
+		return nil
+	}
+	id := graphql.ID(strconv.FormatInt(c.n.ID, 10))
+	return &id
+}
+
+func (c *change) Schema() string {
+	if c.n.Schema == "" {
+		return listener.DefaultSchema
+	}
+	return c.n.Schema
+}
+
+func (c *change) Table() string           { return c.n.Table }
+func (c *change) Operation() string       { return c.n.Operation }
+func (c *change) Key() *JSON              { return newJSON(c.n.Key) }
+func (c *change) Old() *JSON              { return newJSON(c.n.Old) }
+func (c *change) New() *JSON              { return newJSON(c.n.New) }
+func (c *change) Timestamp() graphql.Time { return graphql.Time{Time: c.n.Timestamp} }
+
+// JSON passes row images through as JSON values.
+type JSON struct {
+	raw json.RawMessage
+}
+
+func newJSON(raw json.RawMessage) *JSON {
+	if raw == nil {
+		return nil
+	}
+	return &JSON{raw: raw}
+}
+
+func (JSON) ImplementsGraphQLType(name string) bool { return name == "JSON" }
+
+func (j *JSON) UnmarshalGraphQL(input any) error {
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	j.raw = raw
+	return nil
+}
+
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if j.raw == nil {
+		return []byte("null"), nil
+	}
+	return j.raw, nil
+}
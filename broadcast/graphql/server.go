@@ -0,0 +1,253 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/graph-gophers/graphql-go"
+
+	"github.com/force-c/pg-data-listener/broadcast"
+)
+
+const (
+	// Subprotocol is the graphql-transport-ws protocol name.
+	Subprotocol = "graphql-transport-ws"
+
+	DefaultInitTimeout = 10 * time.Second
+	writeTimeout       = 10 * time.Second
+)
+
+// Close codes defined by the protocol.
+const (
+	closeBadRequest        websocket.StatusCode = 4400
+	closeUnauthorized      websocket.StatusCode = 4401
+	closeInitTimeout       websocket.StatusCode = 4408
+	closeSubscriberExists  websocket.StatusCode = 4409
+	closeTooManyInitialise websocket.StatusCode = 4429
+)
+
+type Server struct {
+	hub         *broadcast.Hub
+	replayer    broadcast.Replayer
+	schema      *graphql.Schema
+	accept      websocket.AcceptOptions
+	initTimeout time.Duration
+}
+
+type Option func(*Server)
+
+// WithReplay serves the afterId argument from the outbox.
+func WithReplay(r broadcast.Replayer) Option {
+	return func(s *Server) {
+		s.replayer = r
+	}
+}
+
+// WithOriginPatterns allows cross-origin connections from hosts matching
+// the patterns.
+func WithOriginPatterns(patterns ...string) Option {
+	return func(s *Server) {
+		s.accept.OriginPatterns = patterns
+	}
+}
+
+func NewServer(hub *broadcast.Hub, opts ...Option) *Server {
+	s := &Server{
+		hub:         hub,
+		accept:      websocket.AcceptOptions{Subprotocols: []string{Subprotocol}},
+		initTimeout: DefaultInitTimeout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.schema = graphql.MustParseSchema(Schema, &resolver{hub: s.hub, replayer: s.replayer})
+	return s
+}
+
+type message struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type subscribePayload struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &s.accept)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	if conn.Subprotocol() != Subprotocol {
+		conn.Close(websocket.StatusPolicyViolation, "unsupported subprotocol")
+		return
+	}
+
+	c := &connection{conn: conn, subs: make(map[string]context.CancelFunc)}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	defer c.cancelAll()
+
+	initDeadline := time.AfterFunc(s.initTimeout, func() {
+		if !c.isAcked() {
+			conn.Close(closeInitTimeout, "connection initialisation timeout")
+		}
+	})
+	defer initDeadline.Stop()
+
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			conn.Close(closeBadRequest, "invalid message")
+			return
+		}
+
+		switch msg.Type {
+		case "connection_init":
+			if c.isAcked() {
+				conn.Close(closeTooManyInitialise, "too many initialisation requests")
+				return
+			}
+			c.setAcked()
+			c.write(ctx, message{Type: "connection_ack"})
+		case "ping":
+			c.write(ctx, message{Type: "pong"})
+		case "pong":
+		case "subscribe":
+			if !c.isAcked() {
+				conn.Close(closeUnauthorized, "unauthorized")
+				return
+			}
+			var payload subscribePayload
+			if msg.ID == "" || json.Unmarshal(msg.Payload, &payload) != nil {
+				conn.Close(closeBadRequest, "invalid subscribe message")
+				return
+			}
+			subCtx, ok := c.add(ctx, msg.ID)
+			if !ok {
+				conn.Close(closeSubscriberExists, "subscriber for "+msg.ID+" already exists")
+				return
+			}
+			go s.run(subCtx, c, msg.ID, payload)
+		case "complete":
+			c.remove(msg.ID)
+		default:
+			conn.Close(closeBadRequest, "unknown message type "+msg.Type)
+			return
+		}
+	}
+}
+
+// run executes one operation, sending its results until it ends or the
+// client completes it.
+func (s *Server) run(ctx context.Context, c *connection, id string, p subscribePayload) {
+	results, err := s.schema.Subscribe(ctx, p.Query, p.OperationName, p.Variables)
+	if err != nil {
+		errs, _ := json.Marshal([]map[string]string{{"message": err.Error()}})
+		c.write(ctx, message{ID: id, Type: "error", Payload: errs})
+		c.remove(id)
+		return
+	}
+
+	for res := range results {
+		resp, ok := res.(*graphql.Response)
+		if !ok {
+			continue
+		}
+		// Validation failures are reported as error messages, execution
+		// errors as part of a result.
+		if resp.Data == nil && len(resp.Errors) > 0 {
+			errs, _ := json.Marshal(resp.Errors)
+			c.write(ctx, message{ID: id, Type: "error", Payload: errs})
+			c.remove(id)
+			return
+		}
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			continue
+		}
+		if err := c.write(ctx, message{ID: id, Type: "next", Payload: payload}); err != nil {
+			return
+		}
+	}
+	if c.remove(id) {
+		c.write(context.Background(), message{ID: id, Type: "complete"})
+	}
+}
+
+type connection struct {
+	conn *websocket.Conn
+
+	mu    sync.Mutex
+	acked bool
+	subs  map[string]context.CancelFunc
+	wmu   sync.Mutex
+}
+
+func (c *connection) isAcked() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.acked
+}
+
+func (c *connection) setAcked() {
+	c.mu.Lock()
+	c.acked = true
+	c.mu.Unlock()
+}
+
+func (c *connection) add(ctx context.Context, id string) (context.Context, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subs[id]; ok {
+		return nil, false
+	}
+	subCtx, cancel := context.WithCancel(ctx)
+	c.subs[id] = cancel
+	return subCtx, true
+}
+
+// remove cancels an operation, reporting whether it was still running.
+func (c *connection) remove(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cancel, ok := c.subs[id]
+	if ok {
+		cancel()
+		delete(c.subs, id)
+	}
+	return ok
+}
+
+func (c *connection) cancelAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, cancel := range c.subs {
+		cancel()
+		delete(c.subs, id)
+	}
+}
+
+func (c *connection) write(ctx context.Context, msg message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, writeTimeout)
+	defer cancel()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.conn.Write(ctx, websocket.MessageText, data)
+}
@@ -0,0 +1,335 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: changes.proto
+
+package changespb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Operation int32
+
+const (
+	Operation_OPERATION_UNSPECIFIED Operation = 0
+	Operation_OPERATION_INSERT      Operation = 1
+	Operation_OPERATION_UPDATE      Operation = 2
+	Operation_OPERATION_DELETE      Operation = 3
+)
+
+// Enum value maps for Operation.
+var (
+	Operation_name = map[int32]string{
+		0: "OPERATION_UNSPECIFIED",
+		1: "OPERATION_INSERT",
+		2: "OPERATION_UPDATE",
+		3: "OPERATION_DELETE",
+	}
+	Operation_value = map[string]int32{
+		"OPERATION_UNSPECIFIED": 0,
+		"OPERATION_INSERT":      1,
+		"OPERATION_UPDATE":      2,
+		"OPERATION_DELETE":      3,
+	}
+)
+
+func (x Operation) Enum() *Operation {
+	p := new(Operation)
+	*p = x
+	return p
+}
+
+func (x Operation) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Operation) Descriptor() protoreflect.EnumDescriptor {
+	return file_changes_proto_enumTypes[0].Descriptor()
+}
+
+func (Operation) Type() protoreflect.EnumType {
+	return &file_changes_proto_enumTypes[0]
+}
+
+func (x Operation) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Operation.Descriptor instead.
+func (Operation) EnumDescriptor() ([]byte, []int) {
+	return file_changes_proto_rawDescGZIP(), []int{0}
+}
+
+type SubscribeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Table names or glob patterns, optionally schema qualified. Empty
+	// matches every table.
+	Tables []string `protobuf:"bytes,1,rep,name=tables,proto3" json:"tables,omitempty"`
+	// Empty matches every operation.
+	Operations []Operation `protobuf:"varint,2,rep,packed,name=operations,proto3,enum=pgdatalistener.changes.v1.Operation" json:"operations,omitempty"`
+	// Replays outbox events after this id before streaming live changes.
+	// Requires the server to have the outbox enabled; 0 disables replay.
+	AfterId       int64 `protobuf:"varint,3,opt,name=after_id,json=afterId,proto3" json:"after_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_changes_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_changes_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_changes_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubscribeRequest) GetTables() []string {
+	if x != nil {
+		return x.Tables
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetOperations() []Operation {
+	if x != nil {
+		return x.Operations
+	}
+	return nil
+}
+
+func (x *SubscribeRequest) GetAfterId() int64 {
+	if x != nil {
+		return x.AfterId
+	}
+	return 0
+}
+
+type ChangeEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Outbox id, 0 when the trigger does not write to an outbox.
+	Id        int64     `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Schema    string    `protobuf:"bytes,2,opt,name=schema,proto3" json:"schema,omitempty"`
+	Table     string    `protobuf:"bytes,3,opt,name=table,proto3" json:"table,omitempty"`
+	Operation Operation `protobuf:"varint,4,opt,name=operation,proto3,enum=pgdatalistener.changes.v1.Operation" json:"operation,omitempty"`
+	// Primary key columns, when the trigger was installed with them.
+	Key *structpb.Struct `protobuf:"bytes,5,opt,name=key,proto3" json:"key,omitempty"`
+	// Row images; old is unset for inserts, new for deletes. Numbers are
+	// doubles, as in JSON.
+	Old           *structpb.Struct       `protobuf:"bytes,6,opt,name=old,proto3" json:"old,omitempty"`
+	New           *structpb.Struct       `protobuf:"bytes,7,opt,name=new,proto3" json:"new,omitempty"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChangeEvent) Reset() {
+	*x = ChangeEvent{}
+	mi := &file_changes_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChangeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangeEvent) ProtoMessage() {}
+
+func (x *ChangeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_changes_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangeEvent.ProtoReflect.Descriptor instead.
+func (*ChangeEvent) Descriptor() ([]byte, []int) {
+	return file_changes_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ChangeEvent) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *ChangeEvent) GetSchema() string {
+	if x != nil {
+		return x.Schema
+	}
+	return ""
+}
+
+func (x *ChangeEvent) GetTable() string {
+	if x != nil {
+		return x.Table
+	}
+	return ""
+}
+
+func (x *ChangeEvent) GetOperation() Operation {
+	if x != nil {
+		return x.Operation
+	}
+	return Operation_OPERATION_UNSPECIFIED
+}
+
+func (x *ChangeEvent) GetKey() *structpb.Struct {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *ChangeEvent) GetOld() *structpb.Struct {
+	if x != nil {
+		return x.Old
+	}
+	return nil
+}
+
+func (x *ChangeEvent) GetNew() *structpb.Struct {
+	if x != nil {
+		return x.New
+	}
+	return nil
+}
+
+func (x *ChangeEvent) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+var File_changes_proto protoreflect.FileDescriptor
+
+const file_changes_proto_rawDesc = "" +
+	"\n" +
+	"\rchanges.proto\x12\x19pgdatalistener.changes.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8b\x01\n" +
+	"\x10SubscribeRequest\x12\x16\n" +
+	"\x06tables\x18\x01 \x03(\tR\x06tables\x12D\n" +
+	"\n" +
+	"operations\x18\x02 \x03(\x0e2$.pgdatalistener.changes.v1.OperationR\n" +
+	"operations\x12\x19\n" +
+	"\bafter_id\x18\x03 \x01(\x03R\aafterId\"\xca\x02\n" +
+	"\vChangeEvent\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x16\n" +
+	"\x06schema\x18\x02 \x01(\tR\x06schema\x12\x14\n" +
+	"\x05table\x18\x03 \x01(\tR\x05table\x12B\n" +
+	"\toperation\x18\x04 \x01(\x0e2$.pgdatalistener.changes.v1.OperationR\toperation\x12)\n" +
+	"\x03key\x18\x05 \x01(\v2\x17.google.protobuf.StructR\x03key\x12)\n" +
+	"\x03old\x18\x06 \x01(\v2\x17.google.protobuf.StructR\x03old\x12)\n" +
+	"\x03new\x18\a \x01(\v2\x17.google.protobuf.StructR\x03new\x128\n" +
+	"\ttimestamp\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp*h\n" +
+	"\tOperation\x12\x19\n" +
+	"\x15OPERATION_UNSPECIFIED\x10\x00\x12\x14\n" +
+	"\x10OPERATION_INSERT\x10\x01\x12\x14\n" +
+	"\x10OPERATION_UPDATE\x10\x02\x12\x14\n" +
+	"\x10OPERATION_DELETE\x10\x032r\n" +
+	"\fChangeStream\x12b\n" +
+	"\tSubscribe\x12+.pgdatalistener.changes.v1.SubscribeRequest\x1a&.pgdatalistener.changes.v1.ChangeEvent0\x01B>Z<github.com/force-c/pg-data-listener/broadcast/grpc/changespbb\x06proto3"
+
+var (
+	file_changes_proto_rawDescOnce sync.Once
+	file_changes_proto_rawDescData []byte
+)
+
+func file_changes_proto_rawDescGZIP() []byte {
+	file_changes_proto_rawDescOnce.Do(func() {
+		file_changes_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_changes_proto_rawDesc), len(file_changes_proto_rawDesc)))
+	})
+	return file_changes_proto_rawDescData
+}
+
+var file_changes_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_changes_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_changes_proto_goTypes = []any{
+	(Operation)(0),                // 0: pgdatalistener.changes.v1.Operation
+	(*SubscribeRequest)(nil),      // 1: pgdatalistener.changes.v1.SubscribeRequest
+	(*ChangeEvent)(nil),           // 2: pgdatalistener.changes.v1.ChangeEvent
+	(*structpb.Struct)(nil),       // 3: google.protobuf.Struct
+	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+}
+var file_changes_proto_depIdxs = []int32{
+	0, // 0: pgdatalistener.changes.v1.SubscribeRequest.operations:type_name -> pgdatalistener.changes.v1.Operation
+	0, // 1: pgdatalistener.changes.v1.ChangeEvent.operation:type_name -> pgdatalistener.changes.v1.Operation
+	3, // 2: pgdatalistener.changes.v1.ChangeEvent.key:type_name -> google.protobuf.Struct
+	3, // 3: pgdatalistener.changes.v1.ChangeEvent.old:type_name -> google.protobuf.Struct
+	3, // 4: pgdatalistener.changes.v1.ChangeEvent.new:type_name -> google.protobuf.Struct
+	4, // 5: pgdatalistener.changes.v1.ChangeEvent.timestamp:type_name -> google.protobuf.Timestamp
+	1, // 6: pgdatalistener.changes.v1.ChangeStream.Subscribe:input_type -> pgdatalistener.changes.v1.SubscribeRequest
+	2, // 7: pgdatalistener.changes.v1.ChangeStream.Subscribe:output_type -> pgdatalistener.changes.v1.ChangeEvent
+	7, // [7:8] is the sub-list for method output_type
+	6, // [6:7] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_changes_proto_init() }
+func file_changes_proto_init() {
+	if File_changes_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_changes_proto_rawDesc), len(file_changes_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_changes_proto_goTypes,
+		DependencyIndexes: file_changes_proto_depIdxs,
+		EnumInfos:         file_changes_proto_enumTypes,
+		MessageInfos:      file_changes_proto_msgTypes,
+	}.Build()
+	File_changes_proto = out.File
+	file_changes_proto_goTypes = nil
+	file_changes_proto_depIdxs = nil
+}
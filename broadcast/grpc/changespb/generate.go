@@ -0,0 +1,5 @@
+// Package changespb holds the protobuf types and gRPC stubs of the
+// ChangeStream service.
+package changespb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative changes.proto
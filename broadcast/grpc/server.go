@@ -0,0 +1,165 @@
+// Package grpc serves the ChangeStream gRPC service, streaming change
+// notifications from a broadcast.Hub as protobuf events.
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/force-c/pg-data-listener/broadcast"
+	"github.com/force-c/pg-data-listener/broadcast/grpc/changespb"
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const replayPage = 500
+
+type Server struct {
+	changespb.UnimplementedChangeStreamServer
+
+	hub      *broadcast.Hub
+	replayer broadcast.Replayer
+}
+
+type Option func(*Server)
+
+// WithReplay serves SubscribeRequest.after_id from the outbox.
+func WithReplay(r broadcast.Replayer) Option {
+	return func(s *Server) {
+		s.replayer = r
+	}
+}
+
+func NewServer(hub *broadcast.Hub, opts ...Option) *Server {
+	s := &Server{hub: hub}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register adds the service to a gRPC server.
+func (s *Server) Register(gs grpclib.ServiceRegistrar) {
+	changespb.RegisterChangeStreamServer(gs, s)
+}
+
+func (s *Server) Subscribe(req *changespb.SubscribeRequest, stream grpclib.ServerStreamingServer[changespb.ChangeEvent]) error {
+	filter := broadcast.Filter{Tables: req.GetTables()}
+	for _, op := range req.GetOperations() {
+		if op == changespb.Operation_OPERATION_UNSPECIFIED {
+			return status.Error(codes.InvalidArgument, "unspecified operation in filter")
+		}
+		filter.Operations = append(filter.Operations, operationName(op))
+	}
+	if req.GetAfterId() > 0 && s.replayer == nil {
+		return status.Error(codes.FailedPrecondition, "replay is not enabled")
+	}
+
+	sub := s.hub.Subscribe(filter)
+	defer sub.Close()
+
+	ctx := stream.Context()
+	lastID := req.GetAfterId()
+	if lastID > 0 {
+		for {
+			events, err := s.replayer.ReadOutbox(ctx, lastID, replayPage)
+			if err != nil {
+				return status.Errorf(codes.Unavailable, "failed to replay: %v", err)
+			}
+			for _, n := range events {
+				lastID = n.ID
+				if !filter.Match(n) {
+					continue
+				}
+				if err := send(stream, n); err != nil {
+					return err
+				}
+			}
+			if len(events) < replayPage {
+				break
+			}
+		}
+	}
+
+	for {
+		select {
+		case n := <-sub.C():
+			if n.ID != 0 && n.ID <= lastID {
+				continue
+			}
+			if err := send(stream, n); err != nil {
+				return err
+			}
+		case <-sub.Done():
+			if errors.Is(sub.Err(), broadcast.ErrSlowConsumer) {
+				return status.Error(codes.ResourceExhausted, "subscriber too slow")
+			}
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func send(stream grpclib.ServerStreamingServer[changespb.ChangeEvent], n *listener.ChangeNotification) error {
+	ev, err := Event(n)
+	if err != nil {
+		// A row that doesn't map to a Struct is skipped rather than
+		// ending the stream.
+		return nil
+	}
+	return stream.Send(ev)
+}
+
+// Event converts a notification to its protobuf form.
+func Event(n *listener.ChangeNotification) (*changespb.ChangeEvent, error) {
+	ev := &changespb.ChangeEvent{
+		Id:        n.ID,
+		Schema:    n.Schema,
+		Table:     n.Table,
+		Operation: operation(n.Operation),
+		Timestamp: timestamppb.New(n.Timestamp),
+	}
+	if ev.Schema == "" {
+		ev.Schema = listener.DefaultSchema
+	}
+	var err error
+	if ev.Key, err = toStruct(n.Key); err != nil {
+		return nil, err
+	}
+	if ev.Old, err = toStruct(n.Old); err != nil {
+		return nil, err
+	}
+	if ev.New, err = toStruct(n.New); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+func toStruct(row json.RawMessage) (*structpb.Struct, error) {
+	if len(row) == 0 {
+		return nil, nil
+	}
+	var s structpb.Struct
+	if err := s.UnmarshalJSON(row); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func operation(op string) changespb.Operation {
+	if v, ok := changespb.Operation_value["OPERATION_"+op]; ok {
+		return changespb.Operation(v)
+	}
+	return changespb.Operation_OPERATION_UNSPECIFIED
+}
+
+func operationName(op changespb.Operation) string {
+	return strings.TrimPrefix(op.String(), "OPERATION_")
+}
@@ -0,0 +1,223 @@
+// Package broadcast fans change notifications out to live subscribers, such
+// as browser connections. The transports live in subpackages.
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const DefaultBuffer = 256
+
+// ErrSlowConsumer ends subscriptions that fell more than their buffer
+// behind. Clients are expected to reconnect and resume.
+var ErrSlowConsumer = errors.New("subscriber too slow")
+
+// ErrHubClosed ends subscriptions when the hub is closed.
+var ErrHubClosed = errors.New("hub closed")
+
+// Replayer reads outbox events after an id, letting transports resume
+// clients from the last event they saw; *listener.DataListener implements
+// it.
+type Replayer interface {
+	ReadOutbox(ctx context.Context, afterID int64, limit int) ([]*listener.ChangeNotification, error)
+}
+
+// Filter selects notifications by table and operation. Tables accept the
+// same names and glob patterns as handler registration; empty fields match
+// everything.
+type Filter struct {
+	Tables     []string `json:"tables,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+}
+
+// ParseFilter reads a filter from the "table" and "op" query parameters,
+// which may be repeated or comma separated: ?table=s_user,s_order&op=DELETE.
+func ParseFilter(q url.Values) Filter {
+	return Filter{Tables: splitValues(q["table"]), Operations: splitValues(q["op"])}
+}
+
+func splitValues(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+	return out
+}
+
+func (f Filter) Match(n *listener.ChangeNotification) bool {
+	return f.matchTable(n) && f.matchOperation(n)
+}
+
+func (f Filter) matchTable(n *listener.ChangeNotification) bool {
+	if len(f.Tables) == 0 {
+		return true
+	}
+	qualified := n.QualifiedTable()
+	bare := n.Schema == "" || n.Schema == listener.DefaultSchema
+	for _, pattern := range f.Tables {
+		if pattern == listener.CatchAll {
+			return true
+		}
+		if ok, _ := path.Match(pattern, qualified); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, n.Table); ok && bare {
+			return true
+		}
+	}
+	return false
+}
+
+func (f Filter) matchOperation(n *listener.ChangeNotification) bool {
+	if len(f.Operations) == 0 {
+		return true
+	}
+	for _, op := range f.Operations {
+		if strings.EqualFold(op, n.Operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hub is a NotificationHandler that delivers each notification to every
+// matching subscription without blocking the listener.
+type Hub struct {
+	buffer int
+
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+type Option func(*Hub)
+
+// WithBuffer sets how many notifications a subscription may lag behind
+// before it is ended with ErrSlowConsumer.
+func WithBuffer(n int) Option {
+	return func(h *Hub) {
+		h.buffer = n
+	}
+}
+
+func NewHub(opts ...Option) *Hub {
+	h := &Hub{buffer: DefaultBuffer, subs: make(map[*Subscription]struct{})}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	c      chan *listener.ChangeNotification
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// Subscribe registers a subscription; callers must Close it.
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	s := &Subscription{
+		hub:    h,
+		filter: f,
+		c:      make(chan *listener.ChangeNotification, h.buffer),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.end(ErrHubClosed)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// C delivers the matching notifications. They are shared between
+// subscriptions and must not be modified.
+func (s *Subscription) C() <-chan *listener.ChangeNotification { return s.c }
+
+// Done is closed when the subscription ends; Err then tells why.
+func (s *Subscription) Done() <-chan struct{} { return s.done }
+
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+	s.end(nil)
+}
+
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.done)
+	})
+}
+
+// Subscribers returns the number of active subscriptions.
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+func (h *Hub) HandleNotification(_ context.Context, n *listener.ChangeNotification) error {
+	var slow []*Subscription
+
+	h.mu.RLock()
+	for s := range h.subs {
+		if !s.filter.Match(n) {
+			continue
+		}
+		select {
+		case s.c <- n:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	if len(slow) > 0 {
+		h.mu.Lock()
+		for _, s := range slow {
+			delete(h.subs, s)
+			s.end(ErrSlowConsumer)
+		}
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+// Close ends all subscriptions with ErrHubClosed.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		s.end(ErrHubClosed)
+	}
+}
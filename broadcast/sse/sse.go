@@ -0,0 +1,166 @@
+// Package sse streams change notifications from a broadcast.Hub as
+// Server-Sent Events:
+//
+//	GET /events?table=s_user&op=UPDATE
+//
+// Each event carries the outbox id as its id when triggers write to an
+// outbox, so reconnecting clients send Last-Event-ID and resume where they
+// left off.
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/force-c/pg-data-listener/broadcast"
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const (
+	DefaultHeartbeat = 15 * time.Second
+	// DefaultRetry is the reconnect delay suggested to clients.
+	DefaultRetry = 3 * time.Second
+	replayPage   = 500
+)
+
+type Handler struct {
+	hub       *broadcast.Hub
+	replayer  broadcast.Replayer
+	heartbeat time.Duration
+	retry     time.Duration
+}
+
+type Option func(*Handler)
+
+// WithReplay resumes clients sending Last-Event-ID from the outbox.
+func WithReplay(r broadcast.Replayer) Option {
+	return func(h *Handler) {
+		h.replayer = r
+	}
+}
+
+func WithHeartbeat(d time.Duration) Option {
+	return func(h *Handler) {
+		h.heartbeat = d
+	}
+}
+
+func NewHandler(hub *broadcast.Hub, opts ...Option) *Handler {
+	h := &Handler{hub: hub, heartbeat: DefaultHeartbeat, retry: DefaultRetry}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	filter := broadcast.ParseFilter(r.URL.Query())
+	lastID, resume := lastEventID(r)
+
+	// Subscribe before replaying so nothing committed in between is
+	// missed; live events already replayed are skipped by id.
+	sub := h.hub.Subscribe(filter)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", h.retry.Milliseconds())
+	flusher.Flush()
+
+	ctx := r.Context()
+	if resume && h.replayer != nil {
+		var err error
+		if lastID, err = h.replay(ctx, w, filter, lastID); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case n := <-sub.C():
+			if n.ID != 0 && n.ID <= lastID {
+				continue
+			}
+			if err := writeEvent(w, n); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-sub.Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replay writes the outbox events after lastID matching filter and returns
+// the last id read.
+func (h *Handler) replay(ctx context.Context, w http.ResponseWriter, filter broadcast.Filter, lastID int64) (int64, error) {
+	for {
+		events, err := h.replayer.ReadOutbox(ctx, lastID, replayPage)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strconv.Quote(err.Error()))
+			return lastID, err
+		}
+		for _, n := range events {
+			lastID = n.ID
+			if !filter.Match(n) {
+				continue
+			}
+			if err := writeEvent(w, n); err != nil {
+				return lastID, err
+			}
+		}
+		if len(events) < replayPage {
+			return lastID, nil
+		}
+	}
+}
+
+func lastEventID(r *http.Request) (int64, bool) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		// EventSource cannot set headers on the first connection.
+		v = r.URL.Query().Get("lastEventId")
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+func writeEvent(w http.ResponseWriter, n *listener.ChangeNotification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if n.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", n.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+	return err
+}
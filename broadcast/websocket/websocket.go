@@ -0,0 +1,168 @@
+// Package websocket streams change notifications from a broadcast.Hub to
+// WebSocket clients.
+//
+// Clients pick the initial subscription with the table and op query
+// parameters (see broadcast.ParseFilter) and may replace it at any time by
+// sending
+//
+//	{"type": "subscribe", "tables": ["s_user"], "operations": ["UPDATE"]}
+//
+// The server sends {"type": "change", "data": <notification>} for each
+// matching change and {"type": "error", "message": "..."} for bad requests.
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"github.com/force-c/pg-data-listener/broadcast"
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const (
+	DefaultPingInterval = 30 * time.Second
+	writeTimeout        = 10 * time.Second
+)
+
+type Server struct {
+	hub          *broadcast.Hub
+	accept       websocket.AcceptOptions
+	pingInterval time.Duration
+}
+
+type Option func(*Server)
+
+// WithOriginPatterns allows cross-origin connections from hosts matching
+// the patterns, e.g. "app.example.com" or "*.example.com".
+func WithOriginPatterns(patterns ...string) Option {
+	return func(s *Server) {
+		s.accept.OriginPatterns = patterns
+	}
+}
+
+func WithPingInterval(d time.Duration) Option {
+	return func(s *Server) {
+		s.pingInterval = d
+	}
+}
+
+func NewServer(hub *broadcast.Hub, opts ...Option) *Server {
+	s := &Server{hub: hub, pingInterval: DefaultPingInterval}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+type clientMessage struct {
+	Type string `json:"type"`
+	broadcast.Filter
+}
+
+type serverMessage struct {
+	Type    string                       `json:"type"`
+	Data    *listener.ChangeNotification `json:"data,omitempty"`
+	Message string                       `json:"message,omitempty"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &s.accept)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	filters := make(chan broadcast.Filter)
+	errs := make(chan string)
+	go s.read(ctx, cancel, conn, filters, errs)
+
+	sub := s.hub.Subscribe(broadcast.ParseFilter(r.URL.Query()))
+	defer func() { sub.Close() }()
+
+	ping := time.NewTicker(s.pingInterval)
+	defer ping.Stop()
+
+	for {
+		var msg serverMessage
+		select {
+		case n := <-sub.C():
+			msg = serverMessage{Type: "change", Data: n}
+		case f := <-filters:
+			sub.Close()
+			sub = s.hub.Subscribe(f)
+			continue
+		case m := <-errs:
+			msg = serverMessage{Type: "error", Message: m}
+		case <-sub.Done():
+			if errors.Is(sub.Err(), broadcast.ErrSlowConsumer) {
+				conn.Close(websocket.StatusTryAgainLater, "too slow")
+			} else {
+				conn.Close(websocket.StatusGoingAway, "server shutting down")
+			}
+			return
+		case <-ping.C:
+			pctx, pcancel := context.WithTimeout(ctx, writeTimeout)
+			err := conn.Ping(pctx)
+			pcancel()
+			if err != nil {
+				return
+			}
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		wctx, wcancel := context.WithTimeout(ctx, writeTimeout)
+		err := wsjson.Write(wctx, conn, msg)
+		wcancel()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// read handles client messages until the connection closes.
+func (s *Server) read(ctx context.Context, cancel context.CancelFunc, conn *websocket.Conn, filters chan<- broadcast.Filter, errs chan<- string) {
+	defer cancel()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		var msg clientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			if !send(ctx, errs, "invalid message: "+err.Error()) {
+				return
+			}
+			continue
+		}
+
+		if msg.Type != "subscribe" {
+			if !send(ctx, errs, "unknown message type "+msg.Type) {
+				return
+			}
+			continue
+		}
+		if !send(ctx, filters, msg.Filter) {
+			return
+		}
+	}
+}
+
+func send[T any](ctx context.Context, c chan<- T, v T) bool {
+	select {
+	case c <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,1184 @@
+// Package config loads a listener deployment from a YAML or TOML file:
+// the database connection, listener settings, sinks and the tables routed
+// to them.
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/force-c/pg-data-listener/encrypt"
+	"github.com/force-c/pg-data-listener/incident"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/rdsiam"
+	"github.com/force-c/pg-data-listener/script"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+type Config struct {
+	Database Database        `yaml:"database" toml:"database"`
+	Listener Listener        `yaml:"listener" toml:"listener"`
+	Retry    *Retry          `yaml:"retry" toml:"retry"`
+	Log      Log             `yaml:"log" toml:"log"`
+	HTTP     HTTP            `yaml:"http" toml:"http"`
+	Secrets  Secrets         `yaml:"secrets" toml:"secrets"`
+	Sinks    map[string]Sink `yaml:"sinks" toml:"sinks"`
+	Tables   []Table         `yaml:"tables" toml:"tables"`
+	// Quarantine names the sink receiving the notifications failing the
+	// schema of their table; they are dropped when empty.
+	Quarantine string `yaml:"quarantine" toml:"quarantine"`
+	// Alerts reports tables whose change rate spikes or drops to zero.
+	Alerts *Alerts `yaml:"alerts" toml:"alerts"`
+	// Failures names the sink told about the notifications given up on
+	// after their retries, e.g. a slack sink; they are only logged when
+	// empty.
+	Failures string `yaml:"failures" toml:"failures"`
+	// Incidents opens incidents in PagerDuty or Opsgenie while a source
+	// is unhealthy.
+	Incidents *Incidents `yaml:"incidents" toml:"incidents"`
+	// Sources are further databases watched by the same process.
+	Sources map[string]Source `yaml:"sources" toml:"sources"`
+}
+
+// Alerts configures the change rate alerts of every source, see
+// listener.WithRateAlerts. Zero values keep the listener defaults.
+type Alerts struct {
+	// Sink names the sink receiving the alerts.
+	Sink     string   `yaml:"sink" toml:"sink"`
+	Interval Duration `yaml:"interval" toml:"interval"`
+	// Spike is the factor over its baseline a table's rate alerts at.
+	Spike      float64 `yaml:"spike" toml:"spike"`
+	MinChanges int64   `yaml:"min_changes" toml:"min_changes"`
+	// Silence alerts on tables without changes for that long; zero
+	// disables it.
+	Silence Duration `yaml:"silence" toml:"silence"`
+}
+
+// RateAlerts returns the listener config of a, sending the alerts to h.
+func (a *Alerts) RateAlerts(h listener.NotificationHandler) *listener.RateAlertConfig {
+	return &listener.RateAlertConfig{
+		Handler:    h,
+		Interval:   time.Duration(a.Interval),
+		Spike:      a.Spike,
+		MinChanges: a.MinChanges,
+		Silence:    time.Duration(a.Silence),
+	}
+}
+
+// Incidents configures the incidents of every source, see the incident
+// package. Exactly one service is set; zero thresholds disable their
+// rule.
+type Incidents struct {
+	PagerDuty *PagerDuty `yaml:"pagerduty" toml:"pagerduty"`
+	Opsgenie  *Opsgenie  `yaml:"opsgenie" toml:"opsgenie"`
+	// Interval is how often the sources are checked.
+	Interval     Duration `yaml:"interval" toml:"interval"`
+	Disconnected Duration `yaml:"disconnected" toml:"disconnected"`
+	DeadLetters  int64    `yaml:"dead_letters" toml:"dead_letters"`
+	LagBytes     int64    `yaml:"lag_bytes" toml:"lag_bytes"`
+}
+
+type PagerDuty struct {
+	RoutingKey string `yaml:"routing_key" toml:"routing_key"`
+}
+
+type Opsgenie struct {
+	APIKey string `yaml:"api_key" toml:"api_key"`
+	// URL is the API of the instance, incident.DefaultOpsgenieURL by
+	// default.
+	URL string `yaml:"url" toml:"url"`
+}
+
+// Notifier returns the service of i.
+func (i *Incidents) Notifier() incident.Notifier {
+	if i.PagerDuty != nil {
+		return &incident.PagerDuty{RoutingKey: i.PagerDuty.RoutingKey}
+	}
+	return &incident.Opsgenie{APIKey: i.Opsgenie.APIKey, URL: i.Opsgenie.URL}
+}
+
+// Rules returns the thresholds of i.
+func (i *Incidents) Rules() incident.Rules {
+	return incident.Rules{
+		Disconnected: time.Duration(i.Disconnected),
+		DeadLetters:  i.DeadLetters,
+		LagBytes:     i.LagBytes,
+	}
+}
+
+func (i *Incidents) validate() error {
+	switch {
+	case (i.PagerDuty == nil) == (i.Opsgenie == nil):
+		return errors.New("exactly one of pagerduty and opsgenie is required")
+	case i.PagerDuty != nil && i.PagerDuty.RoutingKey == "":
+		return errors.New("pagerduty.routing_key is required")
+	case i.Opsgenie != nil && i.Opsgenie.APIKey == "":
+		return errors.New("opsgenie.api_key is required")
+	case i.Interval < 0 || i.Disconnected < 0 || i.DeadLetters < 0 || i.LagBytes < 0:
+		return errors.New("interval and thresholds must not be negative")
+	case i.Disconnected == 0 && i.DeadLetters == 0 && i.LagBytes == 0:
+		return errors.New("one of disconnected, dead_letters and lag_bytes is required")
+	}
+	return nil
+}
+
+// DefaultSource names the database of the top-level database, listener
+// and tables settings among the Sources.
+const DefaultSource = "default"
+
+// Source is a database with its own connection, listener settings and
+// tables. Sinks and the retry policy are shared by every source; settings
+// from the environment and the command line only apply to the top-level
+// database.
+type Source struct {
+	Database Database `yaml:"database" toml:"database"`
+	Listener Listener `yaml:"listener" toml:"listener"`
+	Tables   []Table  `yaml:"tables" toml:"tables"`
+}
+
+// Database holds either a full connection string in URL or its parts.
+// Host may list several hosts separated by commas, e.g. the members of an
+// HA cluster; TargetSessionAttrs selects the server among them, read-write
+// when empty.
+type Database struct {
+	URL                string `yaml:"url" toml:"url"`
+	Host               string `yaml:"host" toml:"host"`
+	Port               int    `yaml:"port" toml:"port"`
+	User               string `yaml:"user" toml:"user"`
+	Password           string `yaml:"password" toml:"password"`
+	Name               string `yaml:"name" toml:"name"`
+	SSLMode            string `yaml:"sslmode" toml:"sslmode"`
+	TargetSessionAttrs string `yaml:"target_session_attrs" toml:"target_session_attrs"`
+
+	TLS    TLS    `yaml:"tls" toml:"tls"`
+	IAM    IAM    `yaml:"iam" toml:"iam"`
+	Pool   Pool   `yaml:"pool" toml:"pool"`
+	Listen Listen `yaml:"listen" toml:"listen"`
+}
+
+// TLS configures the certificates of every connection, replacing the ssl
+// settings of the connection strings. The mode is sslmode, verify-full when
+// empty. Certificates and the key are PEM data or the path of a PEM file.
+type TLS struct {
+	RootCert string `yaml:"root_cert" toml:"root_cert"`
+	Cert     string `yaml:"cert" toml:"cert"`
+	Key      string `yaml:"key" toml:"key"`
+	// ServerName is verified instead of the host name.
+	ServerName string `yaml:"server_name" toml:"server_name"`
+}
+
+// IAM authenticates with AWS RDS IAM tokens, created for every connection
+// from the credentials in the AWS_* environment variables, instead of a
+// password. Region defaults to the one in the RDS host name.
+type IAM struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Region  string `yaml:"region" toml:"region"`
+}
+
+// Pool sizes the query connections. Zero values keep the database/sql
+// defaults.
+type Pool struct {
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+}
+
+// Listen configures the dedicated LISTEN connection. URL defaults to the
+// query connection string.
+type Listen struct {
+	URL          string   `yaml:"url" toml:"url"`
+	Driver       string   `yaml:"driver" toml:"driver"`
+	MinReconnect Duration `yaml:"min_reconnect" toml:"min_reconnect"`
+	MaxReconnect Duration `yaml:"max_reconnect" toml:"max_reconnect"`
+}
+
+type Listener struct {
+	Channels       []string `yaml:"channels" toml:"channels"`
+	PingInterval   Duration `yaml:"ping_interval" toml:"ping_interval"`
+	DrainTimeout   Duration `yaml:"drain_timeout" toml:"drain_timeout"`
+	Workers        int      `yaml:"workers" toml:"workers"`
+	QueueSize      int      `yaml:"queue_size" toml:"queue_size"`
+	Overflow       string   `yaml:"overflow" toml:"overflow"`
+	HandlerTimeout Duration `yaml:"handler_timeout" toml:"handler_timeout"`
+	// Capture is listen (default) or polling, which tails Polling.Table
+	// instead, e.g. behind a transaction pooling PgBouncer.
+	Capture string  `yaml:"capture" toml:"capture"`
+	Polling Polling `yaml:"polling" toml:"polling"`
+	Leader  Leader  `yaml:"leader" toml:"leader"`
+	// Sharding spreads the tables across the instances when Shards is
+	// set, see listener.ShardConfig.
+	Sharding Sharding `yaml:"sharding" toml:"sharding"`
+	// TenantColumn is the column holding the tenant id of the rows of a
+	// multi-tenant database, see listener.WithTenants.
+	TenantColumn string `yaml:"tenant_column" toml:"tenant_column"`
+	// Codecs selects the codec, json, msgpack or protobuf, of the
+	// payloads of a channel, see listener.WithChannelCodec.
+	Codecs map[string]string `yaml:"codecs" toml:"codecs"`
+	// Priority sizes the queue lane of the tables with priority high.
+	Priority Priority `yaml:"priority" toml:"priority"`
+}
+
+// Priority configures the high priority lane of the worker queues, see
+// listener.WithPriorityQueue. Zero values keep the listener defaults.
+type Priority struct {
+	QueueSize int `yaml:"queue_size" toml:"queue_size"`
+	// Burst is how many high priority changes a worker processes in a row
+	// while normal ones wait.
+	Burst int `yaml:"burst" toml:"burst"`
+}
+
+type Sharding struct {
+	Shards int `yaml:"shards" toml:"shards"`
+	// By is table (default) or channel.
+	By       string         `yaml:"by" toml:"by"`
+	Assign   map[string]int `yaml:"assign" toml:"assign"`
+	Claim    []int          `yaml:"claim" toml:"claim"`
+	Instance string         `yaml:"instance" toml:"instance"`
+	Table    string         `yaml:"table" toml:"table"`
+	Interval Duration       `yaml:"interval" toml:"interval"`
+	TTL      Duration       `yaml:"ttl" toml:"ttl"`
+}
+
+// Leader elects one of several instances to process the changes, through
+// an advisory lock on Key. Zero values keep the listener defaults.
+type Leader struct {
+	Enabled  bool     `yaml:"enabled" toml:"enabled"`
+	Key      int64    `yaml:"key" toml:"key"`
+	Interval Duration `yaml:"interval" toml:"interval"`
+}
+
+// Polling configures the polling capture. Zero values keep the listener
+// defaults.
+type Polling struct {
+	Table         string   `yaml:"table" toml:"table"`
+	IDColumn      string   `yaml:"id_column" toml:"id_column"`
+	ChannelColumn string   `yaml:"channel_column" toml:"channel_column"`
+	PayloadColumn string   `yaml:"payload_column" toml:"payload_column"`
+	Interval      Duration `yaml:"interval" toml:"interval"`
+	BatchSize     int      `yaml:"batch_size" toml:"batch_size"`
+}
+
+type Retry struct {
+	MaxAttempts    int      `yaml:"max_attempts" toml:"max_attempts"`
+	InitialBackoff Duration `yaml:"initial_backoff" toml:"initial_backoff"`
+	MaxBackoff     Duration `yaml:"max_backoff" toml:"max_backoff"`
+	Multiplier     float64  `yaml:"multiplier" toml:"multiplier"`
+	Jitter         float64  `yaml:"jitter" toml:"jitter"`
+}
+
+type Log struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level" toml:"level"`
+	// Format is text or json.
+	Format string `yaml:"format" toml:"format"`
+}
+
+type HTTP struct {
+	// Addr serves /metrics, /healthz and /readyz when set.
+	Addr string `yaml:"addr" toml:"addr"`
+	// AdminToken enables the /admin API for requests carrying it as a
+	// bearer token.
+	AdminToken string `yaml:"admin_token" toml:"admin_token"`
+}
+
+// Sink configures one named destination. Type is a built-in or
+// registered sink type (see sink.Register) and which fields apply depends
+// on it; Target is the topic, subject, routing key, index, table or Redis
+// channel pattern.
+type Sink struct {
+	Type     string   `yaml:"type" toml:"type"`
+	URL      string   `yaml:"url" toml:"url"`
+	Brokers  []string `yaml:"brokers" toml:"brokers"`
+	Target   string   `yaml:"target" toml:"target"`
+	Exchange string   `yaml:"exchange" toml:"exchange"`
+	Secret   string   `yaml:"secret" toml:"secret"`
+	// Encoding is json (default), cloudevents, debezium, msgpack or
+	// protobuf.
+	Encoding string `yaml:"encoding" toml:"encoding"`
+	// Options holds the settings of sink types registered by plugins, see
+	// sink.Register.
+	Options map[string]string `yaml:"options" toml:"options"`
+	// Template renders the messages instead of Encoding.
+	Template *Template `yaml:"template" toml:"template"`
+	Encrypt  *Encrypt  `yaml:"encrypt" toml:"encrypt"`
+	// Batch hands the changes to sinks with bulk writes, elasticsearch
+	// and clickhouse, in batches, see listener.NewBatching.
+	Batch *Batch `yaml:"batch" toml:"batch"`
+}
+
+// Batch bounds the batches of a sink; zero values keep the listener
+// defaults. Failed changes are retried per Retry and then logged.
+type Batch struct {
+	Size   int      `yaml:"size" toml:"size"`
+	Linger Duration `yaml:"linger" toml:"linger"`
+	Retry  *Retry   `yaml:"retry" toml:"retry"`
+}
+
+// Template renders the messages of a sink with a text/template, see
+// sink.Render. Text is the template itself, File the path of one.
+type Template struct {
+	Text string `yaml:"text" toml:"text"`
+	File string `yaml:"file" toml:"file"`
+	// ContentType defaults to sink.DefaultRenderContentType; JSON content
+	// types require the output to be valid JSON.
+	ContentType string `yaml:"content_type" toml:"content_type"`
+}
+
+// Encrypt encrypts the messages of a sink, or only some columns, before
+// delivery, see the encrypt package.
+type Encrypt struct {
+	// Cipher is aes-gcm, age or aws-kms.
+	Cipher string `yaml:"cipher" toml:"cipher"`
+	// Key is the base64 encoded 16, 24 or 32 byte key of aes-gcm.
+	Key        string   `yaml:"key" toml:"key"`
+	Recipients []string `yaml:"recipients" toml:"recipients"`
+	// KMSKey is the id, ARN or alias of the aws-kms key, used in Region
+	// with the credentials in the AWS_* environment variables.
+	KMSKey string `yaml:"kms_key" toml:"kms_key"`
+	Region string `yaml:"region" toml:"region"`
+	// Fields are the columns to encrypt. Without them the whole message is
+	// encrypted, which the log, elasticsearch and clickhouse sinks do not
+	// support.
+	Fields []string `yaml:"fields" toml:"fields"`
+}
+
+// Table routes a table, glob pattern or listener.CatchAll to sinks.
+type Table struct {
+	Name    string `yaml:"name" toml:"name"`
+	Channel string `yaml:"channel" toml:"channel"`
+	// Tenant routes only the changes of one tenant to Sinks, ahead of the
+	// tables without a tenant.
+	Tenant string   `yaml:"tenant" toml:"tenant"`
+	Sinks  []string `yaml:"sinks" toml:"sinks"`
+	// Schema is the path of a JSON Schema file the rows are validated
+	// against before they reach Sinks, see Config.Quarantine.
+	Schema    string     `yaml:"schema" toml:"schema"`
+	Retry     *Retry     `yaml:"retry" toml:"retry"`
+	Timeout   Duration   `yaml:"timeout" toml:"timeout"`
+	RateLimit *RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Redact    *Redact    `yaml:"redact" toml:"redact"`
+	// Filter is an expression only the changes matching reach Sinks,
+	// e.g. `data.status == "active"`, see listener.Filter.
+	Filter    string     `yaml:"filter" toml:"filter"`
+	Transform *Transform `yaml:"transform" toml:"transform"`
+	// Route is an expression choosing the names of Sinks each change is
+	// delivered to, see listener.RouteHandler. Without it every change
+	// reaches all Sinks.
+	Route string `yaml:"route" toml:"route"`
+	// Script is a Lua script filtering, transforming and routing each
+	// change before it reaches Sinks, see package script. It replaces
+	// Route.
+	Script      string       `yaml:"script" toml:"script"`
+	Concurrency *Concurrency `yaml:"concurrency" toml:"concurrency"`
+	// Priority is normal (default) or high, which queues the changes
+	// ahead of normal tables, see listener.WithPriority.
+	Priority string `yaml:"priority" toml:"priority"`
+	// Aggregate delivers per-window summaries of the changes to Sinks
+	// instead of the changes.
+	Aggregate *Aggregate `yaml:"aggregate" toml:"aggregate"`
+}
+
+// Aggregate counts a table's changes per operation over tumbling windows,
+// see listener.Aggregator.
+type Aggregate struct {
+	// Window is one minute by default.
+	Window Duration `yaml:"window" toml:"window"`
+	// Sum lists numeric columns summed per window.
+	Sum []string `yaml:"sum" toml:"sum"`
+}
+
+// Concurrency sets how a table's changes are spread over the listener's
+// workers, see listener.WithConcurrency.
+type Concurrency struct {
+	// Mode is serial, keyed (in order per row) or parallel.
+	Mode string `yaml:"mode" toml:"mode"`
+	// Columns are the key columns of keyed, id by default.
+	Columns []string `yaml:"columns" toml:"columns"`
+	// Workers is how many workers parallel uses at most.
+	Workers int `yaml:"workers" toml:"workers"`
+}
+
+// Transform reshapes a table's rows before they reach its sinks, see
+// listener.Transform.
+type Transform struct {
+	Rename map[string]string `yaml:"rename" toml:"rename"`
+	Drop   []string          `yaml:"drop" toml:"drop"`
+	// Compute maps derived columns to expressions, e.g.
+	// `row.price * row.quantity`.
+	Compute map[string]string `yaml:"compute" toml:"compute"`
+}
+
+// Redact drops or pseudonymizes columns of a table before they reach its
+// sinks, see listener.Redaction.
+type Redact struct {
+	// Columns maps column names to drop, mask, hash or tokenize.
+	Columns map[string]string `yaml:"columns" toml:"columns"`
+	// Key keys hashes and tokens; tokenize requires it.
+	Key string `yaml:"key" toml:"key"`
+}
+
+// RateLimit caps the notifications per second a table's sinks receive,
+// see listener.RateLimit.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate" toml:"rate"`
+	Burst int     `yaml:"burst" toml:"burst"`
+	// Policy is buffer (default), drop or coalesce.
+	Policy    string `yaml:"policy" toml:"policy"`
+	PerTable  bool   `yaml:"per_table" toml:"per_table"`
+	PerTenant bool   `yaml:"per_tenant" toml:"per_tenant"`
+}
+
+var (
+	logLevels    = []string{"debug", "info", "warn", "error"}
+	logFormats   = []string{"text", "json"}
+	captures     = []string{"listen", "polling"}
+	shardKeys    = map[string]listener.ShardKey{"table": listener.ShardByTable, "channel": listener.ShardByChannel}
+	tlsModes     = []string{listener.SSLModeRequire, listener.SSLModeVerifyCA, listener.SSLModeVerifyFull}
+	encodings    = []string{"json", "cloudevents", "debezium", "msgpack", "protobuf"}
+	ratePolicies = map[string]listener.RatePolicy{
+		"buffer":   listener.RateBuffer,
+		"drop":     listener.RateDrop,
+		"coalesce": listener.RateCoalesce,
+	}
+	redactActions = map[string]listener.RedactAction{
+		"drop":     listener.RedactDrop,
+		"mask":     listener.RedactMask,
+		"hash":     listener.RedactHash,
+		"tokenize": listener.RedactTokenize,
+	}
+	priorities = map[string]listener.Priority{"normal": listener.PriorityNormal, "high": listener.PriorityHigh}
+	overflows  = map[string]listener.OverflowPolicy{
+		"block":       listener.OverflowBlock,
+		"drop-oldest": listener.OverflowDropOldest,
+		"drop-newest": listener.OverflowDropNewest,
+	}
+	drivers = map[string]listener.Driver{
+		"pgx": listener.DriverPGX,
+		"pq":  listener.DriverPQ,
+	}
+)
+
+// Duration accepts Go duration strings such as "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Load reads and validates a config file, choosing the format by its
+// extension (.yaml, .yml or .toml). Unknown keys are rejected.
+func Load(path string) (*Config, error) {
+	cfg, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func read(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var cfg Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("failed to parse %s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config format %q", ext)
+	}
+	return &cfg, nil
+}
+
+// Validate reports every problem found in the config.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Database.URL == "" && c.Database.Host == "" {
+		add("database: url or host is required (set database.host, %sDATABASE_HOST, PGHOST or -database-host)", EnvPrefix)
+	}
+	c.validateSource("", c.Source(DefaultSource), add)
+	for name, src := range c.Sources {
+		prefix := "sources." + name + "."
+		if name == DefaultSource {
+			add("sources.%s: name is reserved for the top-level database", name)
+		}
+		if src.Database.URL == "" && src.Database.Host == "" {
+			add("%sdatabase: url or host is required", prefix)
+		}
+		c.validateSource(prefix, src, add)
+	}
+	if c.Log.Level != "" && !slices.Contains(logLevels, c.Log.Level) {
+		add("log.level: unknown level %q", c.Log.Level)
+	}
+	if c.Log.Format != "" && !slices.Contains(logFormats, c.Log.Format) {
+		add("log.format: unknown format %q", c.Log.Format)
+	}
+	if err := c.Retry.validate(); err != nil {
+		add("retry: %w", err)
+	}
+	if _, ok := c.Sinks[c.Quarantine]; c.Quarantine != "" && !ok {
+		add("quarantine: unknown sink %q", c.Quarantine)
+	}
+	if _, ok := c.Sinks[c.Failures]; c.Failures != "" && !ok {
+		add("failures: unknown sink %q", c.Failures)
+	}
+	if c.Incidents != nil {
+		if err := c.Incidents.validate(); err != nil {
+			add("incidents: %w", err)
+		}
+	}
+	if c.Secrets.Refresh < 0 {
+		add("secrets.refresh: must not be negative")
+	}
+	if a := c.Alerts; a != nil {
+		if _, ok := c.Sinks[a.Sink]; !ok {
+			add("alerts.sink: unknown sink %q", a.Sink)
+		}
+		if a.Interval < 0 || a.Silence < 0 || a.MinChanges < 0 {
+			add("alerts: interval, silence and min_changes must not be negative")
+		}
+		if a.Spike != 0 && a.Spike <= 1 {
+			add("alerts.spike: must be greater than 1")
+		}
+	}
+
+	for name, s := range c.Sinks {
+		if err := s.validate(); err != nil {
+			add("sinks.%s: %w", name, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateSource reports the problems of a source's database, listener
+// and tables, prefixing their keys with prefix.
+func (c *Config) validateSource(prefix string, src Source, add func(format string, args ...any)) {
+	d, l := src.Database, src.Listener
+	if a := d.TargetSessionAttrs; a != "" && !slices.Contains(listener.TargetSessionAttrs, a) {
+		add("%sdatabase.target_session_attrs: unknown value %q", prefix, a)
+	}
+	if t := d.TLS; t != (TLS{}) {
+		if m := d.SSLMode; m != "" && !slices.Contains(tlsModes, m) {
+			add("%sdatabase.tls: sslmode must be require, verify-ca or verify-full, not %q", prefix, m)
+		}
+		if (t.Cert == "") != (t.Key == "") {
+			add("%sdatabase.tls: cert and key must be set together", prefix)
+		}
+	}
+	if d.IAM.Enabled && d.Password != "" {
+		add("%sdatabase.iam: password must not be set with IAM authentication", prefix)
+	}
+	if p := d.Pool; p.MaxOpenConns < 0 || p.MaxIdleConns < 0 || p.ConnMaxLifetime < 0 || p.ConnMaxIdleTime < 0 {
+		add("%sdatabase.pool: settings must not be negative", prefix)
+	}
+	if dl := d.Listen; dl.MinReconnect < 0 || dl.MaxReconnect < 0 {
+		add("%sdatabase.listen: reconnect intervals must not be negative", prefix)
+	} else if dl.MinReconnect > 0 && dl.MaxReconnect > 0 && dl.MinReconnect > dl.MaxReconnect {
+		add("%sdatabase.listen: min_reconnect %s exceeds max_reconnect %s", prefix, time.Duration(dl.MinReconnect), time.Duration(dl.MaxReconnect))
+	}
+	if drv := d.Listen.Driver; drv != "" {
+		if _, ok := drivers[drv]; !ok {
+			add("%sdatabase.listen.driver: unknown driver %q", prefix, drv)
+		}
+	}
+	if l.Overflow != "" {
+		if _, ok := overflows[l.Overflow]; !ok {
+			add("%slistener.overflow: unknown policy %q", prefix, l.Overflow)
+		}
+	}
+	if sh := l.Sharding; sh.Shards < 0 || sh.Interval < 0 || sh.TTL < 0 {
+		add("%slistener.sharding: settings must not be negative", prefix)
+	} else if sh.Shards > 0 {
+		if _, ok := shardKeys[sh.By]; sh.By != "" && !ok {
+			add("%slistener.sharding.by: must be table or channel, not %q", prefix, sh.By)
+		}
+		for name, shard := range sh.Assign {
+			if shard < 0 || shard >= sh.Shards {
+				add("%slistener.sharding.assign: %s assigned to shard %d of %d", prefix, name, shard, sh.Shards)
+			}
+		}
+		for _, shard := range sh.Claim {
+			if shard < 0 || shard >= sh.Shards {
+				add("%slistener.sharding.claim: shard %d out of %d", prefix, shard, sh.Shards)
+			}
+		}
+	}
+	for ch, name := range l.Codecs {
+		if _, ok := listener.LookupCodec(name); !ok {
+			add("%slistener.codecs.%s: unknown codec %q", prefix, ch, name)
+		}
+	}
+	if l.Leader.Interval < 0 {
+		add("%slistener.leader.interval: must not be negative", prefix)
+	}
+	if l.Workers < 0 || l.QueueSize < 0 {
+		add("%slistener: workers and queue_size must not be negative", prefix)
+	}
+	if l.Priority.QueueSize < 0 || l.Priority.Burst < 0 {
+		add("%slistener.priority: queue_size and burst must not be negative", prefix)
+	}
+	if l.Capture != "" && !slices.Contains(captures, l.Capture) {
+		add("%slistener.capture: unknown capture %q", prefix, l.Capture)
+	}
+	if p := l.Polling; p.Interval < 0 || p.BatchSize < 0 {
+		add("%slistener.polling: interval and batch_size must not be negative", prefix)
+	}
+
+	for i, t := range src.Tables {
+		if t.Name == "" {
+			add("%stables[%d]: name is required", prefix, i)
+		}
+		if len(t.Sinks) == 0 {
+			add("%stables[%d]: at least one sink is required", prefix, i)
+		}
+		for _, s := range t.Sinks {
+			if _, ok := c.Sinks[s]; !ok {
+				add("%stables[%d]: unknown sink %q", prefix, i, s)
+			}
+		}
+		if t.Channel != "" && len(l.Channels) > 0 && !slices.Contains(l.Channels, t.Channel) {
+			add("%stables[%d]: channel %q is not in listener.channels", prefix, i, t.Channel)
+		}
+		if t.Channel != "" && t.Tenant != "" {
+			add("%stables[%d]: channel and tenant are exclusive", prefix, i)
+		}
+		if err := t.Retry.validate(); err != nil {
+			add("%stables[%d].retry: %w", prefix, i, err)
+		}
+		if t.Schema != "" {
+			if _, err := t.compileSchema(); err != nil {
+				add("%stables[%d].schema: %w", prefix, i, err)
+			}
+		}
+		if t.Filter != "" {
+			if _, err := listener.CompileFilter(t.Filter); err != nil {
+				add("%stables[%d].filter: %w", prefix, i, err)
+			}
+		}
+		if _, ok := priorities[t.Priority]; t.Priority != "" && !ok {
+			add("%stables[%d].priority: unknown priority %q", prefix, i, t.Priority)
+		}
+		if c := t.Concurrency; c != nil {
+			switch c.Mode {
+			case "serial", "keyed":
+			case "parallel":
+				if c.Workers < 1 {
+					add("%stables[%d].concurrency.workers: parallel requires workers", prefix, i)
+				}
+			default:
+				add("%stables[%d].concurrency.mode: unknown mode %q", prefix, i, c.Mode)
+			}
+		}
+		if t.Route != "" {
+			if _, err := listener.CompileExpr(t.Route); err != nil {
+				add("%stables[%d].route: %w", prefix, i, err)
+			}
+		}
+		if t.Script != "" {
+			if t.Route != "" {
+				add("%stables[%d]: route and script are exclusive", prefix, i)
+			}
+			if _, err := script.Compile(t.Script); err != nil {
+				add("%stables[%d].script: %w", prefix, i, err)
+			}
+		}
+		if a := t.Aggregate; a != nil && a.Window < 0 {
+			add("%stables[%d].aggregate.window: must not be negative", prefix, i)
+		}
+		if tr := t.Transform; tr != nil {
+			targets := make(map[string]string)
+			for from, to := range tr.Rename {
+				if to == "" {
+					add("%stables[%d].transform.rename.%s: new name is required", prefix, i, from)
+				} else if other, ok := targets[to]; ok {
+					add("%stables[%d].transform.rename: %s and %s both renamed to %s", prefix, i, other, from, to)
+				}
+				targets[to] = from
+			}
+			for col, expr := range tr.Compute {
+				if _, err := listener.CompileTransformExpr(expr); err != nil {
+					add("%stables[%d].transform.compute.%s: %w", prefix, i, col, err)
+				}
+			}
+		}
+		if r := t.RateLimit; r != nil {
+			if r.Rate <= 0 || r.Burst < 0 {
+				add("%stables[%d].rate_limit: rate must be positive and burst not negative", prefix, i)
+			}
+			if _, ok := ratePolicies[r.Policy]; r.Policy != "" && !ok {
+				add("%stables[%d].rate_limit.policy: unknown policy %q", prefix, i, r.Policy)
+			}
+		}
+		if r := t.Redact; r != nil {
+			for col, action := range r.Columns {
+				a, ok := redactActions[action]
+				if !ok {
+					add("%stables[%d].redact.columns.%s: unknown action %q", prefix, i, col, action)
+				}
+				if a == listener.RedactTokenize && r.Key == "" {
+					add("%stables[%d].redact.columns.%s: tokenize requires redact.key", prefix, i, col)
+				}
+			}
+		}
+	}
+}
+
+// Source returns the named source; DefaultSource is the top-level
+// database, listener and tables.
+func (c *Config) Source(name string) Source {
+	if name == DefaultSource {
+		return Source{Database: c.Database, Listener: c.Listener, Tables: c.Tables}
+	}
+	return c.Sources[name]
+}
+
+// SourceNames returns DefaultSource followed by the names of Sources in
+// order.
+func (c *Config) SourceNames() []string {
+	return append([]string{DefaultSource}, slices.Sorted(maps.Keys(c.Sources))...)
+}
+
+func (s Sink) validate() error {
+	if _, ok := sink.Lookup(s.Type); !ok {
+		return fmt.Errorf("unknown type %q, the registered types are %s", s.Type, strings.Join(sink.Types(), ", "))
+	}
+	if s.Encoding != "" && !slices.Contains(encodings, s.Encoding) {
+		return fmt.Errorf("unknown encoding %q", s.Encoding)
+	}
+	switch s.Type {
+	case "kafka":
+		if len(s.Brokers) == 0 {
+			return errors.New("brokers is required")
+		}
+	case "rabbitmq":
+		if s.URL == "" || s.Exchange == "" {
+			return errors.New("url and exchange are required")
+		}
+	case "webhook", "nats", "redis", "elasticsearch", "clickhouse", "slack", "teams":
+		if s.URL == "" {
+			return errors.New("url is required")
+		}
+	case "invalidate":
+		if !strings.HasPrefix(s.URL, "redis://") && !strings.HasPrefix(s.URL, "rediss://") && !strings.HasPrefix(s.URL, "memcached://") {
+			return errors.New("url must be a redis://, rediss:// or memcached:// url")
+		}
+	case "audit":
+		if s.URL == "" {
+			return errors.New("url is required")
+		}
+		switch s.Options["partition"] {
+		case "", "day", "month":
+		default:
+			return fmt.Errorf("unknown partition %q, want day or month", s.Options["partition"])
+		}
+		if v := s.Options["retention_days"]; v != "" {
+			if days, err := strconv.Atoi(v); err != nil || days < 0 {
+				return fmt.Errorf("invalid retention_days %q", v)
+			}
+		}
+	case "email":
+		if s.Options["addr"] == "" || s.Options["from"] == "" || s.Options["to"] == "" {
+			return errors.New("options addr, from and to are required")
+		}
+	case "wasm":
+		if s.Options["path"] == "" {
+			return errors.New("option path is required")
+		}
+		for _, opt := range []string{"instances", "memory_mb"} {
+			if v := s.Options[opt]; v != "" {
+				if n, err := strconv.Atoi(v); err != nil || n < 0 {
+					return fmt.Errorf("invalid %s %q", opt, v)
+				}
+			}
+		}
+	}
+	if b := s.Batch; b != nil {
+		if b.Size < 0 || b.Linger < 0 {
+			return errors.New("batch: size and linger must not be negative")
+		}
+		if err := b.Retry.validate(); err != nil {
+			return fmt.Errorf("batch.retry: %w", err)
+		}
+	}
+	if t := s.Template; t != nil {
+		if s.Encoding != "" {
+			return errors.New("encoding and template are exclusive")
+		}
+		if slices.Contains([]string{"log", "elasticsearch", "clickhouse"}, s.Type) {
+			return fmt.Errorf("template: %s sinks do not support templates", s.Type)
+		}
+		if _, err := t.NewRender(); err != nil {
+			return fmt.Errorf("template: %w", err)
+		}
+	}
+	if e := s.Encrypt; e != nil {
+		switch e.Cipher {
+		case "aes-gcm":
+			if e.Key == "" {
+				return errors.New("encrypt.key is required")
+			}
+		case "age":
+			if len(e.Recipients) == 0 {
+				return errors.New("encrypt.recipients is required")
+			}
+		case "aws-kms":
+			if e.KMSKey == "" {
+				return errors.New("encrypt.kms_key is required")
+			}
+		default:
+			return fmt.Errorf("encrypt.cipher: unknown cipher %q", e.Cipher)
+		}
+		if len(e.Fields) == 0 && slices.Contains([]string{"log", "elasticsearch", "clickhouse"}, s.Type) {
+			return fmt.Errorf("encrypt: %s sinks require fields", s.Type)
+		}
+	}
+	return nil
+}
+
+// NewCipher returns the cipher of e, whose key must be resolved.
+func (e *Encrypt) NewCipher() (encrypt.Cipher, error) {
+	switch e.Cipher {
+	case "aes-gcm":
+		key, err := base64.StdEncoding.DecodeString(e.Key)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt.key: %w", err)
+		}
+		return encrypt.NewAESGCM(key)
+	case "age":
+		return encrypt.NewAge(e.Recipients, nil)
+	case "aws-kms":
+		return encrypt.NewAWSKMS(context.Background(), e.KMSKey, e.Region)
+	}
+	return nil, fmt.Errorf("unknown cipher %q", e.Cipher)
+}
+
+// NewRender parses the template, reading File when set.
+func (t *Template) NewRender() (*sink.Render, error) {
+	if (t.Text == "") == (t.File == "") {
+		return nil, errors.New("one of text and file is required")
+	}
+	text := t.Text
+	if t.File != "" {
+		data, err := os.ReadFile(t.File)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return sink.NewRender(text, t.ContentType)
+}
+
+func (r *Retry) validate() error {
+	if r == nil {
+		return nil
+	}
+	if r.MaxAttempts < 1 {
+		return errors.New("max_attempts must be at least 1")
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return errors.New("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// Policy returns the retry policy.
+func (r *Retry) Policy() listener.RetryPolicy {
+	return listener.RetryPolicy{
+		MaxAttempts:    r.MaxAttempts,
+		InitialBackoff: time.Duration(r.InitialBackoff),
+		MaxBackoff:     time.Duration(r.MaxBackoff),
+		Multiplier:     r.Multiplier,
+		Jitter:         r.Jitter,
+	}
+}
+
+// ConnString returns the connection string, built from the parts when URL
+// is empty.
+func (d Database) ConnString() string {
+	if d.URL != "" {
+		return d.URL
+	}
+	var parts []string
+	set := func(key, value string) {
+		if value == "" {
+			return
+		}
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `'`, `\'`)
+		parts = append(parts, key+"='"+value+"'")
+	}
+	set("host", d.Host)
+	if d.Port != 0 {
+		set("port", strconv.Itoa(d.Port))
+	}
+	set("user", d.User)
+	set("password", d.Password)
+	set("dbname", d.Name)
+	set("sslmode", d.SSLMode)
+	set("target_session_attrs", d.TargetSessionAttrs)
+	return strings.Join(parts, " ")
+}
+
+// ConnConfig returns the TLS and password settings of the connections;
+// unset ones are left to the connection strings.
+func (d Database) ConnConfig() listener.ConnConfig {
+	var c listener.ConnConfig
+	if d.TLS != (TLS{}) {
+		c.TLS = &listener.TLSConfig{
+			Mode:       d.SSLMode,
+			RootCert:   d.TLS.RootCert,
+			Cert:       d.TLS.Cert,
+			Key:        d.TLS.Key,
+			ServerName: d.TLS.ServerName,
+		}
+	}
+	if d.IAM.Enabled {
+		c.Password = rdsiam.Password(rdsiam.Config{Region: d.IAM.Region})
+	}
+	return c
+}
+
+// Options returns the listener options the config describes for the
+// top-level database. Handlers are registered separately, see Tables.
+func (c *Config) Options() []listener.Option {
+	return c.SourceOptions(DefaultSource)
+}
+
+// SourceOptions returns the listener options of the named source.
+func (c *Config) SourceOptions(name string) []listener.Option {
+	src := c.Source(name)
+	opts := src.Database.options()
+	l := src.Listener
+	if len(l.Channels) > 0 {
+		opts = append(opts, listener.WithChannels(l.Channels...))
+	}
+	if l.PingInterval > 0 {
+		opts = append(opts, listener.WithPingInterval(time.Duration(l.PingInterval)))
+	}
+	if l.DrainTimeout > 0 {
+		opts = append(opts, listener.WithDrainTimeout(time.Duration(l.DrainTimeout)))
+	}
+	if l.Workers > 0 {
+		opts = append(opts, listener.WithWorkers(l.Workers))
+	}
+	if l.QueueSize > 0 {
+		opts = append(opts, listener.WithQueueSize(l.QueueSize))
+	}
+	if l.Overflow != "" {
+		opts = append(opts, listener.WithOverflow(overflows[l.Overflow]))
+	}
+	if p := l.Priority; p.QueueSize > 0 || p.Burst > 0 {
+		size, burst := p.QueueSize, p.Burst
+		if size == 0 {
+			size = listener.DefaultPriorityQueueSize
+		}
+		if burst == 0 {
+			burst = listener.DefaultPriorityBurst
+		}
+		opts = append(opts, listener.WithPriorityQueue(size, burst))
+	}
+	if l.HandlerTimeout > 0 {
+		opts = append(opts, listener.WithHandlerTimeout(time.Duration(l.HandlerTimeout)))
+	}
+	if l.Capture == "polling" {
+		opts = append(opts, listener.WithPolling(listener.PollingConfig{
+			Table:         l.Polling.Table,
+			IDColumn:      l.Polling.IDColumn,
+			ChannelColumn: l.Polling.ChannelColumn,
+			PayloadColumn: l.Polling.PayloadColumn,
+			Interval:      time.Duration(l.Polling.Interval),
+			BatchSize:     l.Polling.BatchSize,
+		}))
+	}
+	if l.Leader.Enabled {
+		opts = append(opts, listener.WithLeaderElection(listener.LeaderConfig{
+			Key:      l.Leader.Key,
+			Interval: time.Duration(l.Leader.Interval),
+		}))
+	}
+	if sh := l.Sharding; sh.Shards > 0 {
+		opts = append(opts, listener.WithSharding(listener.ShardConfig{
+			Shards:   sh.Shards,
+			By:       shardKeys[sh.By],
+			Assign:   sh.Assign,
+			Claim:    sh.Claim,
+			Instance: sh.Instance,
+			Table:    sh.Table,
+			Interval: time.Duration(sh.Interval),
+			TTL:      time.Duration(sh.TTL),
+		}))
+	}
+	if l.TenantColumn != "" {
+		opts = append(opts, listener.WithTenants(l.TenantColumn))
+	}
+	for ch, name := range l.Codecs {
+		if codec, ok := listener.LookupCodec(name); ok {
+			opts = append(opts, listener.WithChannelCodec(ch, codec))
+		}
+	}
+	if c.Retry != nil {
+		opts = append(opts, listener.WithRetryPolicy(c.Retry.Policy()))
+	}
+	return opts
+}
+
+// options returns the connection options: the query pool and the LISTEN
+// connection settings. The query connection string is passed to
+// listener.New, see ConnString.
+func (d Database) options() []listener.Option {
+	var opts []listener.Option
+	conn := d.ConnConfig()
+	if conn.TLS != nil {
+		opts = append(opts, listener.WithTLS(*conn.TLS))
+	}
+	if conn.Password != nil {
+		opts = append(opts, listener.WithPassword(conn.Password))
+	}
+	if d.Pool != (Pool{}) {
+		opts = append(opts, listener.WithQueryPool(listener.PoolConfig{
+			MaxOpenConns:    d.Pool.MaxOpenConns,
+			MaxIdleConns:    d.Pool.MaxIdleConns,
+			ConnMaxLifetime: time.Duration(d.Pool.ConnMaxLifetime),
+			ConnMaxIdleTime: time.Duration(d.Pool.ConnMaxIdleTime),
+		}))
+	}
+	if d.Listen.URL != "" {
+		opts = append(opts, listener.WithListenConnString(d.Listen.URL))
+	}
+	if d.Listen.Driver != "" {
+		opts = append(opts, listener.WithDriver(drivers[d.Listen.Driver]))
+	}
+	if d.Listen.MinReconnect > 0 || d.Listen.MaxReconnect > 0 {
+		min, max := time.Duration(d.Listen.MinReconnect), time.Duration(d.Listen.MaxReconnect)
+		if min == 0 {
+			min = listener.DefaultMinReconnectInterval
+		}
+		if max == 0 {
+			max = listener.DefaultMaxReconnectInterval
+		}
+		opts = append(opts, listener.WithReconnectInterval(min, max))
+	}
+	return opts
+}
+
+// HandlerOptions returns the options of a table's handler registration,
+// reading its schema.
+func (t Table) HandlerOptions() ([]listener.HandlerOption, error) {
+	var opts []listener.HandlerOption
+	if t.Schema != "" {
+		schema, err := t.compileSchema()
+		if err != nil {
+			return nil, fmt.Errorf("schema of table %s: %w", t.Name, err)
+		}
+		opts = append(opts, listener.WithSchema(schema))
+	}
+	if t.Retry != nil {
+		opts = append(opts, listener.WithRetry(t.Retry.Policy()))
+	}
+	if t.Timeout > 0 {
+		opts = append(opts, listener.WithTimeout(time.Duration(t.Timeout)))
+	}
+	if r := t.RateLimit; r != nil {
+		opts = append(opts, listener.WithRateLimit(listener.RateLimit{
+			Rate:      r.Rate,
+			Burst:     r.Burst,
+			Policy:    ratePolicies[r.Policy],
+			PerTable:  r.PerTable,
+			PerTenant: r.PerTenant,
+		}))
+	}
+	if r := t.Redact; r != nil && len(r.Columns) > 0 {
+		columns := make(map[string]listener.RedactAction, len(r.Columns))
+		for col, action := range r.Columns {
+			columns[col] = redactActions[action]
+		}
+		opts = append(opts, listener.WithRedaction(listener.Redaction{Columns: columns, Key: []byte(r.Key)}))
+	}
+	if t.Filter != "" {
+		filter, err := listener.CompileFilter(t.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("filter of table %s: %w", t.Name, err)
+		}
+		opts = append(opts, listener.WithFilter(filter))
+	}
+	if t.Priority != "" {
+		opts = append(opts, listener.WithPriority(priorities[t.Priority]))
+	}
+	if c := t.Concurrency; c != nil {
+		switch c.Mode {
+		case "serial":
+			opts = append(opts, listener.WithConcurrency(listener.ByTable))
+		case "keyed":
+			opts = append(opts, listener.WithConcurrency(listener.ByKey(c.Columns...)))
+		case "parallel":
+			opts = append(opts, listener.WithConcurrency(listener.Parallel(c.Workers)))
+		}
+	}
+	if tr := t.Transform; tr != nil {
+		transform := listener.Transform{Rename: tr.Rename, Drop: tr.Drop}
+		if len(tr.Compute) > 0 {
+			transform.Compute = make(map[string]*listener.Expr, len(tr.Compute))
+		}
+		for col, src := range tr.Compute {
+			expr, err := listener.CompileTransformExpr(src)
+			if err != nil {
+				return nil, fmt.Errorf("transform of table %s: %s: %w", t.Name, col, err)
+			}
+			transform.Compute[col] = expr
+		}
+		opts = append(opts, listener.WithTransform(transform))
+	}
+	return opts, nil
+}
+
+func (t Table) compileSchema() (*listener.Schema, error) {
+	data, err := os.ReadFile(t.Schema)
+	if err != nil {
+		return nil, err
+	}
+	return listener.CompileSchema(data)
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// EnvPrefix prefixes the environment variables that override the file.
+const EnvPrefix = "PGDL_"
+
+// setting is one scalar option settable from the environment and the
+// command line. Sinks and tables are only read from the file.
+type setting struct {
+	flag  string
+	env   string
+	pgEnv string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"database-url", "DATABASE_URL", "", "connection string or postgres:// URL; takes precedence over the other database settings", setString(func(c *Config) *string { return &c.Database.URL })},
+	{"database-host", "DATABASE_HOST", "PGHOST", "database host, or several separated by commas", setString(func(c *Config) *string { return &c.Database.Host })},
+	{"database-port", "DATABASE_PORT", "PGPORT", "database port", setInt(func(c *Config) *int { return &c.Database.Port })},
+	{"database-user", "DATABASE_USER", "PGUSER", "database user", setString(func(c *Config) *string { return &c.Database.User })},
+	{"database-password", "DATABASE_PASSWORD", "PGPASSWORD", "database password; prefer the environment, flags are visible to other users", setString(func(c *Config) *string { return &c.Database.Password })},
+	{"database-name", "DATABASE_NAME", "PGDATABASE", "database name", setString(func(c *Config) *string { return &c.Database.Name })},
+	{"database-sslmode", "DATABASE_SSLMODE", "PGSSLMODE", "sslmode: disable, require, verify-ca or verify-full", setString(func(c *Config) *string { return &c.Database.SSLMode })},
+	{"database-target-session-attrs", "DATABASE_TARGET_SESSION_ATTRS", "PGTARGETSESSIONATTRS", "server to connect to among several hosts: read-write (default), primary, any, read-only, standby or prefer-standby", setString(func(c *Config) *string { return &c.Database.TargetSessionAttrs })},
+	{"database-sslrootcert", "DATABASE_SSLROOTCERT", "", "CA certificates verifying the server, a PEM file or inline PEM", setString(func(c *Config) *string { return &c.Database.TLS.RootCert })},
+	{"database-sslcert", "DATABASE_SSLCERT", "", "client certificate, a PEM file or inline PEM", setString(func(c *Config) *string { return &c.Database.TLS.Cert })},
+	{"database-sslkey", "DATABASE_SSLKEY", "", "client key, a PEM file or inline PEM; prefer the environment", setString(func(c *Config) *string { return &c.Database.TLS.Key })},
+	{"database-ssl-server-name", "DATABASE_SSL_SERVER_NAME", "", "server name verified in verify-full mode instead of the host", setString(func(c *Config) *string { return &c.Database.TLS.ServerName })},
+	{"database-iam", "DATABASE_IAM", "", "authenticate with AWS RDS IAM tokens instead of a password: true or false", setBool(func(c *Config) *bool { return &c.Database.IAM.Enabled })},
+	{"database-iam-region", "DATABASE_IAM_REGION", "AWS_REGION", "AWS region of the database; taken from the RDS host name when empty", setString(func(c *Config) *string { return &c.Database.IAM.Region })},
+	{"database-max-open-conns", "DATABASE_MAX_OPEN_CONNS", "", "maximum open query connections", setInt(func(c *Config) *int { return &c.Database.Pool.MaxOpenConns })},
+	{"database-max-idle-conns", "DATABASE_MAX_IDLE_CONNS", "", "maximum idle query connections", setInt(func(c *Config) *int { return &c.Database.Pool.MaxIdleConns })},
+	{"database-conn-max-lifetime", "DATABASE_CONN_MAX_LIFETIME", "", "maximum lifetime of a query connection, e.g. 30m", setDuration(func(c *Config) *Duration { return &c.Database.Pool.ConnMaxLifetime })},
+	{"database-conn-max-idle-time", "DATABASE_CONN_MAX_IDLE_TIME", "", "maximum idle time of a query connection, e.g. 5m", setDuration(func(c *Config) *Duration { return &c.Database.Pool.ConnMaxIdleTime })},
+	{"database-listen-url", "DATABASE_LISTEN_URL", "", "connection string of the LISTEN connection, e.g. to bypass a pooler; defaults to the query connection", setString(func(c *Config) *string { return &c.Database.Listen.URL })},
+	{"database-listen-driver", "DATABASE_LISTEN_DRIVER", "", "client library of the LISTEN connection: pgx or pq", setString(func(c *Config) *string { return &c.Database.Listen.Driver })},
+	{"database-listen-min-reconnect", "DATABASE_LISTEN_MIN_RECONNECT", "", "initial reconnect backoff of the LISTEN connection", setDuration(func(c *Config) *Duration { return &c.Database.Listen.MinReconnect })},
+	{"database-listen-max-reconnect", "DATABASE_LISTEN_MAX_RECONNECT", "", "upper bound of the LISTEN connection reconnect backoff", setDuration(func(c *Config) *Duration { return &c.Database.Listen.MaxReconnect })},
+	{"channels", "CHANNELS", "", "comma-separated channels to LISTEN on", func(c *Config, v string) error {
+		c.Listener.Channels = splitList(v)
+		return nil
+	}},
+	{"ping-interval", "PING_INTERVAL", "", "interval between connection pings, e.g. 90s", setDuration(func(c *Config) *Duration { return &c.Listener.PingInterval })},
+	{"drain-timeout", "DRAIN_TIMEOUT", "", "how long shutdown waits for in-flight handlers", setDuration(func(c *Config) *Duration { return &c.Listener.DrainTimeout })},
+	{"workers", "WORKERS", "", "number of concurrent handler workers", setInt(func(c *Config) *int { return &c.Listener.Workers })},
+	{"queue-size", "QUEUE_SIZE", "", "bound of the worker queue", setInt(func(c *Config) *int { return &c.Listener.QueueSize })},
+	{"overflow", "OVERFLOW", "", "full queue policy: block, drop-oldest or drop-newest", setString(func(c *Config) *string { return &c.Listener.Overflow })},
+	{"handler-timeout", "HANDLER_TIMEOUT", "", "default handler timeout, e.g. 5s", setDuration(func(c *Config) *Duration { return &c.Listener.HandlerTimeout })},
+	{"capture", "CAPTURE", "", "capture mode: listen or polling, e.g. behind a transaction pooling PgBouncer", setString(func(c *Config) *string { return &c.Listener.Capture })},
+	{"polling-table", "POLLING_TABLE", "", "table tailed in polling mode, data_listener_outbox by default", setString(func(c *Config) *string { return &c.Listener.Polling.Table })},
+	{"polling-interval", "POLLING_INTERVAL", "", "interval between polls, e.g. 1s", setDuration(func(c *Config) *Duration { return &c.Listener.Polling.Interval })},
+	{"polling-batch-size", "POLLING_BATCH_SIZE", "", "rows read per poll query", setInt(func(c *Config) *int { return &c.Listener.Polling.BatchSize })},
+	{"leader-election", "LEADER_ELECTION", "", "process changes on one instance at a time, elected through an advisory lock: true or false", setBool(func(c *Config) *bool { return &c.Listener.Leader.Enabled })},
+	{"leader-key", "LEADER_KEY", "", "advisory lock key shared by the instances of a deployment", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		c.Listener.Leader.Key = n
+		return nil
+	}},
+	{"leader-interval", "LEADER_INTERVAL", "", "interval between attempts to take, or checks of, the leader lock", setDuration(func(c *Config) *Duration { return &c.Listener.Leader.Interval })},
+	{"shards", "SHARDS", "", "number of shards the tables are spread across instances in", setInt(func(c *Config) *int { return &c.Listener.Sharding.Shards })},
+	{"shard-by", "SHARD_BY", "", "what is sharded: table or channel", setString(func(c *Config) *string { return &c.Listener.Sharding.By })},
+	{"shard-instance", "SHARD_INSTANCE", "", "name of this instance among the shard members, e.g. the pod name; host name and pid by default", setString(func(c *Config) *string { return &c.Listener.Sharding.Instance })},
+	{"shard-claim", "SHARD_CLAIM", "", "comma-separated shards this instance processes, instead of claiming a share", func(c *Config, v string) error {
+		c.Listener.Sharding.Claim = []int{}
+		for _, s := range splitList(v) {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("invalid integer %q", s)
+			}
+			c.Listener.Sharding.Claim = append(c.Listener.Sharding.Claim, n)
+		}
+		return nil
+	}},
+	{"tenant-column", "TENANT_COLUMN", "", "column holding the tenant id of the rows", setString(func(c *Config) *string { return &c.Listener.TenantColumn })},
+	{"retry-max-attempts", "RETRY_MAX_ATTEMPTS", "", "handler attempts before dead-lettering", setInt(func(c *Config) *int { return &c.retry().MaxAttempts })},
+	{"retry-initial-backoff", "RETRY_INITIAL_BACKOFF", "", "backoff before the first retry", setDuration(func(c *Config) *Duration { return &c.retry().InitialBackoff })},
+	{"retry-max-backoff", "RETRY_MAX_BACKOFF", "", "upper bound of the retry backoff", setDuration(func(c *Config) *Duration { return &c.retry().MaxBackoff })},
+	{"retry-multiplier", "RETRY_MULTIPLIER", "", "backoff growth factor", setFloat(func(c *Config) *float64 { return &c.retry().Multiplier })},
+	{"retry-jitter", "RETRY_JITTER", "", "random backoff fraction between 0 and 1", setFloat(func(c *Config) *float64 { return &c.retry().Jitter })},
+	{"log-level", "LOG_LEVEL", "", "log level: debug, info, warn or error", setString(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "LOG_FORMAT", "", "log format: text or json", setString(func(c *Config) *string { return &c.Log.Format })},
+	{"http-addr", "HTTP_ADDR", "", "address to serve /metrics, /healthz and /readyz on, e.g. :9090", setString(func(c *Config) *string { return &c.HTTP.Addr })},
+	{"admin-token", "ADMIN_TOKEN", "", "bearer token enabling the /admin API; prefer the environment", setString(func(c *Config) *string { return &c.HTTP.AdminToken })},
+	{"secrets-refresh", "SECRETS_REFRESH", "", "interval between fetches of referenced secrets, e.g. 5m", setDuration(func(c *Config) *Duration { return &c.Secrets.Refresh })},
+	{"secrets-vault-addr", "SECRETS_VAULT_ADDR", "VAULT_ADDR", "address of the Vault server", setString(func(c *Config) *string { return &c.Secrets.Vault.Addr })},
+	{"secrets-vault-token", "SECRETS_VAULT_TOKEN", "VAULT_TOKEN", "Vault token; prefer the environment", setString(func(c *Config) *string { return &c.Secrets.Vault.Token })},
+	{"secrets-aws-region", "SECRETS_AWS_REGION", "AWS_REGION", "AWS region of Secrets Manager", setString(func(c *Config) *string { return &c.Secrets.AWS.Region })},
+	{"secrets-gcp-project", "SECRETS_GCP_PROJECT", "GOOGLE_CLOUD_PROJECT", "GCP project of Secret Manager secrets", setString(func(c *Config) *string { return &c.Secrets.GCP.Project })},
+}
+
+// Flags records the settings given on the command line.
+type Flags struct {
+	values []flagValue
+}
+
+type flagValue struct {
+	setting *setting
+	value   string
+}
+
+// RegisterFlags defines a flag per setting on fs. The values are applied
+// by Resolve after the file and the environment.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	for i := range settings {
+		s := &settings[i]
+		fs.Func(s.flag, s.usage, func(v string) error {
+			f.values = append(f.values, flagValue{setting: s, value: v})
+			return nil
+		})
+	}
+	return f
+}
+
+// Resolve builds the effective config from the file at path, if any, the
+// environment and the flags, each overriding the previous, and validates
+// the result. PGDL_* variables take precedence over the standard PG* ones.
+func Resolve(path string, flags *Flags) (*Config, error) {
+	cfg := &Config{}
+	if path != "" {
+		var err error
+		if cfg, err = read(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.applyFlags(flags); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the config with the environment variables lookup
+// returns.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	for _, s := range settings {
+		name := EnvPrefix + s.env
+		v, ok := lookup(name)
+		if !ok && s.pgEnv != "" {
+			name = s.pgEnv
+			v, ok = lookup(name)
+		}
+		if !ok || v == "" {
+			continue
+		}
+		if err := s.set(c, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Config) applyFlags(f *Flags) error {
+	if f == nil {
+		return nil
+	}
+	var errs []error
+	for _, fv := range f.values {
+		if err := fv.setting.set(c, fv.value); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", fv.setting.flag, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retry returns the global retry settings, starting from
+// listener.DefaultRetryPolicy when the file has none.
+func (c *Config) retry() *Retry {
+	if c.Retry == nil {
+		p := listener.DefaultRetryPolicy
+		c.Retry = &Retry{
+			MaxAttempts:    p.MaxAttempts,
+			InitialBackoff: Duration(p.InitialBackoff),
+			MaxBackoff:     Duration(p.MaxBackoff),
+			Multiplier:     p.Multiplier,
+			Jitter:         p.Jitter,
+		}
+	}
+	return c.Retry
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setInt(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func setBool(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func setFloat(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func setDuration(field func(*Config) *Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*field(c) = Duration(d)
+		return nil
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/force-c/pg-data-listener/secrets"
+)
+
+// DefaultSecretsRefresh is how often referenced secrets are fetched again
+// when Secrets.Refresh is zero.
+const DefaultSecretsRefresh = 5 * time.Minute
+
+// Secrets configures the backends of secret references, which the
+// database passwords and the url and secret of sinks may hold instead of
+// the value, e.g. vault://secret/data/pgdl#password. Only the referenced
+// backends need settings.
+type Secrets struct {
+	// Refresh is the interval between fetches of the referenced secrets,
+	// picking up rotated ones.
+	Refresh Duration     `yaml:"refresh" toml:"refresh"`
+	Vault   VaultSecrets `yaml:"vault" toml:"vault"`
+	AWS     AWSSecrets   `yaml:"aws" toml:"aws"`
+	GCP     GCPSecrets   `yaml:"gcp" toml:"gcp"`
+}
+
+type VaultSecrets struct {
+	Addr  string `yaml:"addr" toml:"addr"`
+	Token string `yaml:"token" toml:"token"`
+}
+
+// AWSSecrets configures Secrets Manager, signing with the credentials of
+// the AWS SDK's default chain.
+type AWSSecrets struct {
+	Region string `yaml:"region" toml:"region"`
+}
+
+// GCPSecrets configures Secret Manager, authenticating with the
+// application default credentials.
+type GCPSecrets struct {
+	Project string `yaml:"project" toml:"project"`
+}
+
+// RefreshInterval returns Refresh or DefaultSecretsRefresh.
+func (s Secrets) RefreshInterval() time.Duration {
+	if s.Refresh > 0 {
+		return time.Duration(s.Refresh)
+	}
+	return DefaultSecretsRefresh
+}
+
+// Resolver returns a resolver for the vault://, awssm:// and gcpsm://
+// references.
+func (s Secrets) Resolver() *secrets.Resolver {
+	r := secrets.NewResolver()
+	r.Register(secrets.SchemeVault, func() (secrets.Backend, error) {
+		return secrets.NewVault(s.Vault.Addr, s.Vault.Token, nil)
+	})
+	r.Register(secrets.SchemeAWS, func() (secrets.Backend, error) {
+		return secrets.NewAWSSecretsManager(context.Background(), s.AWS.Region)
+	})
+	r.Register(secrets.SchemeGCP, func() (secrets.Backend, error) {
+		return secrets.NewGCPSecretManager(context.Background(), s.GCP.Project)
+	})
+	return r
+}
+
+// UsesSecrets reports whether the config holds references r resolves.
+func (c *Config) UsesSecrets(r *secrets.Resolver) bool {
+	if r.IsRef(c.Database.Password) {
+		return true
+	}
+	for _, src := range c.Sources {
+		if r.IsRef(src.Database.Password) {
+			return true
+		}
+	}
+	for _, s := range c.Sinks {
+		if r.IsRef(s.URL) || r.IsRef(s.Secret) || s.Encrypt != nil && r.IsRef(s.Encrypt.Key) {
+			return true
+		}
+	}
+	for _, name := range c.SourceNames() {
+		for _, t := range c.Source(name).Tables {
+			if t.Redact != nil && r.IsRef(t.Redact.Key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ResolveSecrets returns a copy of the config with the references
+// replaced by the secrets' current values.
+func (c *Config) ResolveSecrets(ctx context.Context, r *secrets.Resolver) (*Config, error) {
+	out := *c
+	var err error
+	if out.Database, err = c.Database.ResolveSecrets(ctx, r); err != nil {
+		return nil, err
+	}
+	if out.Tables, err = resolveTables(ctx, r, c.Tables); err != nil {
+		return nil, err
+	}
+	out.Sources = make(map[string]Source, len(c.Sources))
+	for name, src := range c.Sources {
+		if src.Database, err = src.Database.ResolveSecrets(ctx, r); err != nil {
+			return nil, fmt.Errorf("sources.%s.%w", name, err)
+		}
+		if src.Tables, err = resolveTables(ctx, r, src.Tables); err != nil {
+			return nil, fmt.Errorf("sources.%s.%w", name, err)
+		}
+		out.Sources[name] = src
+	}
+	if c.Incidents != nil {
+		inc, err := c.Incidents.ResolveSecrets(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		out.Incidents = &inc
+	}
+	out.Sinks = make(map[string]Sink, len(c.Sinks))
+	for name, s := range c.Sinks {
+		if out.Sinks[name], err = s.ResolveSecrets(ctx, r); err != nil {
+			return nil, fmt.Errorf("sinks.%s: %w", name, err)
+		}
+	}
+	return &out, nil
+}
+
+// ResolveSecrets returns a copy of i with a referenced routing or API key
+// resolved.
+func (i Incidents) ResolveSecrets(ctx context.Context, r *secrets.Resolver) (Incidents, error) {
+	var err error
+	if p := i.PagerDuty; p != nil {
+		resolved := *p
+		if resolved.RoutingKey, err = r.Resolve(ctx, p.RoutingKey); err != nil {
+			return i, fmt.Errorf("incidents.pagerduty.routing_key: %w", err)
+		}
+		i.PagerDuty = &resolved
+	}
+	if o := i.Opsgenie; o != nil {
+		resolved := *o
+		if resolved.APIKey, err = r.Resolve(ctx, o.APIKey); err != nil {
+			return i, fmt.Errorf("incidents.opsgenie.api_key: %w", err)
+		}
+		i.Opsgenie = &resolved
+	}
+	return i, nil
+}
+
+// ResolveSecrets returns d with a referenced password resolved.
+func (d Database) ResolveSecrets(ctx context.Context, r *secrets.Resolver) (Database, error) {
+	var err error
+	if d.Password, err = r.Resolve(ctx, d.Password); err != nil {
+		return d, fmt.Errorf("database.password: %w", err)
+	}
+	return d, nil
+}
+
+// resolveTables returns a copy of tables with the redact keys resolved.
+func resolveTables(ctx context.Context, r *secrets.Resolver, tables []Table) ([]Table, error) {
+	if tables == nil {
+		return nil, nil
+	}
+	out := make([]Table, len(tables))
+	for i, t := range tables {
+		var err error
+		if out[i], err = t.ResolveSecrets(ctx, r); err != nil {
+			return nil, fmt.Errorf("tables[%d].%w", i, err)
+		}
+	}
+	return out, nil
+}
+
+// ResolveSecrets returns t with a referenced redact key resolved.
+func (t Table) ResolveSecrets(ctx context.Context, r *secrets.Resolver) (Table, error) {
+	if t.Redact == nil {
+		return t, nil
+	}
+	redact := *t.Redact
+	var err error
+	if redact.Key, err = r.Resolve(ctx, redact.Key); err != nil {
+		return t, fmt.Errorf("redact.key: %w", err)
+	}
+	t.Redact = &redact
+	return t, nil
+}
+
+// ResolveSecrets returns s with a referenced url, secret and encryption
+// key resolved.
+func (s Sink) ResolveSecrets(ctx context.Context, r *secrets.Resolver) (Sink, error) {
+	var err error
+	if s.URL, err = r.Resolve(ctx, s.URL); err != nil {
+		return s, fmt.Errorf("url: %w", err)
+	}
+	if s.Secret, err = r.Resolve(ctx, s.Secret); err != nil {
+		return s, fmt.Errorf("secret: %w", err)
+	}
+	if s.Encrypt != nil {
+		e := *s.Encrypt
+		if e.Key, err = r.Resolve(ctx, e.Key); err != nil {
+			return s, fmt.Errorf("encrypt.key: %w", err)
+		}
+		s.Encrypt = &e
+	}
+	return s, nil
+}
@@ -0,0 +1,85 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+)
+
+// Age encrypts to age X25519 recipients (age1...) in the age v1 file
+// format, so the files can be decrypted with the age tool. Decrypting
+// requires an identity (AGE-SECRET-KEY-1...) of one of the recipients.
+type Age struct {
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+// NewAge returns an age cipher encrypting to recipients and decrypting
+// with identities; either may be empty when only the other direction is
+// needed.
+func NewAge(recipients, identities []string) (*Age, error) {
+	a := &Age{}
+	for _, r := range recipients {
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", r, err)
+		}
+		a.recipients = append(a.recipients, recipient)
+	}
+	for _, id := range identities {
+		identity, err := age.ParseX25519Identity(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity: %w", err)
+		}
+		a.identities = append(a.identities, identity)
+	}
+	return a, nil
+}
+
+// GenerateAgeIdentity returns a new identity and its recipient.
+func GenerateAgeIdentity() (identity, recipient string, err error) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", "", err
+	}
+	return id.String(), id.Recipient().String(), nil
+}
+
+func (a *Age) Extension() string { return ".age" }
+
+func (a *Age) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	if len(a.recipients) == 0 {
+		return nil, errors.New("age: no recipients")
+	}
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, a.recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (a *Age) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	if len(a.identities) == 0 {
+		return nil, errors.New("age: no identities")
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), a.identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
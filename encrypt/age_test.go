@@ -0,0 +1,157 @@
+package encrypt
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	agetest "c2sp.org/CCTV/age"
+	"filippo.io/age"
+)
+
+// TestAgeVectors decrypts the age test vectors of the C2SP test suite
+// with X25519 identities, see c2sp.org/CCTV/age.
+func TestAgeVectors(t *testing.T) {
+	entries, err := fs.ReadDir(agetest.Vectors, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Run(e.Name(), func(t *testing.T) {
+			data, err := fs.ReadFile(agetest.Vectors, e.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, ciphertext, _ := bytes.Cut(data, []byte("\n\n"))
+			var expect, payload, compressed string
+			var identities []string
+			for line := range strings.Lines(string(header)) {
+				key, value, _ := strings.Cut(strings.TrimSpace(line), ": ")
+				switch key {
+				case "expect":
+					expect = value
+				case "payload":
+					payload = value
+				case "identity":
+					identities = append(identities, value)
+				case "compressed":
+					compressed = value
+				case "armored", "passphrase":
+					t.Skip("armor and passphrases are not used")
+				}
+			}
+			a, err := NewAge(nil, identities)
+			if err != nil || len(identities) == 0 {
+				t.Skip("no X25519 identities")
+			}
+			if compressed == "zlib" {
+				r, err := zlib.NewReader(bytes.NewReader(ciphertext))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ciphertext, err = io.ReadAll(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			plaintext, err := a.Decrypt(context.Background(), ciphertext)
+			if expect != "success" {
+				if err == nil {
+					t.Fatalf("decrypted, want %s", expect)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sum := sha256.Sum256(plaintext); hex.EncodeToString(sum[:]) != payload {
+				t.Errorf("payload hash %x, want %s", sum, payload)
+			}
+		})
+	}
+}
+
+func TestAgeWritesAgeFiles(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAge([]string{identity.Recipient().String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("written for the age tool")
+	ciphertext, err := a.Encrypt(context.Background(), plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("age decrypted %q, want %q", got, plaintext)
+	}
+}
+
+func TestAgeKeys(t *testing.T) {
+	identity, recipient, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name                   string
+		recipients, identities []string
+		wantNew                bool
+		wantEncrypt            bool
+		wantDecrypt            bool
+	}{
+		{name: "matching", recipients: []string{recipient}, identities: []string{identity}, wantNew: true, wantEncrypt: true, wantDecrypt: true},
+		{name: "one of several recipients", recipients: []string{other, recipient}, identities: []string{identity}, wantNew: true, wantEncrypt: true, wantDecrypt: true},
+		{name: "other recipient", recipients: []string{other}, identities: []string{identity}, wantNew: true, wantEncrypt: true},
+		{name: "encrypt only", recipients: []string{recipient}, wantNew: true, wantEncrypt: true},
+		{name: "decrypt only", identities: []string{identity}, wantNew: true, wantDecrypt: true},
+		{name: "invalid recipient", recipients: []string{"age1invalid"}},
+		{name: "identity as recipient", recipients: []string{identity}},
+		{name: "invalid identity", identities: []string{"AGE-SECRET-KEY-1INVALID"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAge(tt.recipients, tt.identities)
+			if (err == nil) != tt.wantNew {
+				t.Fatalf("NewAge error = %v, want success %v", err, tt.wantNew)
+			}
+			if err != nil {
+				return
+			}
+			ciphertext, err := a.Encrypt(ctx, []byte("payload"))
+			if (err == nil) != tt.wantEncrypt {
+				t.Fatalf("Encrypt error = %v, want success %v", err, tt.wantEncrypt)
+			}
+			if err != nil {
+				// Decrypt something for the identities anyway.
+				b, _ := NewAge([]string{recipient}, nil)
+				ciphertext, _ = b.Encrypt(ctx, []byte("payload"))
+			}
+			if _, err := a.Decrypt(ctx, ciphertext); (err == nil) != tt.wantDecrypt {
+				t.Errorf("Decrypt error = %v, want success %v", err, tt.wantDecrypt)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// DeadLetterStore wraps store so the payloads of dead letters are
+// encrypted before they are written and decrypted when listed. With
+// fields only those columns are encrypted, so the payloads stay
+// queryable; otherwise the payload is stored as {"encrypted": "<base64>"}.
+// The channel, table, operation and error stay in the clear.
+func DeadLetterStore(store listener.DeadLetterStore, c Cipher, fields ...string) listener.DeadLetterStore {
+	return &deadLetterStore{store: store, c: c, fields: fields}
+}
+
+type deadLetterStore struct {
+	store  listener.DeadLetterStore
+	c      Cipher
+	fields []string
+}
+
+type encryptedPayload struct {
+	Encrypted []byte `json:"encrypted"`
+}
+
+func (s *deadLetterStore) Put(ctx context.Context, d *listener.DeadLetter) error {
+	payload, err := s.encrypt(ctx, d)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt dead letter: %w", err)
+	}
+	plain := d.Payload
+	d.Payload = payload
+	err = s.store.Put(ctx, d)
+	d.Payload = plain
+	return err
+}
+
+func (s *deadLetterStore) encrypt(ctx context.Context, d *listener.DeadLetter) (json.RawMessage, error) {
+	if len(s.fields) == 0 {
+		ciphertext, err := s.c.Encrypt(ctx, d.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(encryptedPayload{Encrypted: ciphertext})
+	}
+	n, err := d.Notification()
+	if err != nil {
+		return nil, err
+	}
+	if n, err = Fields(ctx, s.c, n, s.fields...); err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
+func (s *deadLetterStore) List(ctx context.Context, q listener.DeadLetterQuery) ([]*listener.DeadLetter, error) {
+	letters, err := s.store.List(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range letters {
+		if d.Payload, err = s.decrypt(ctx, d); err != nil {
+			return nil, fmt.Errorf("failed to decrypt dead letter %d: %w", d.ID, err)
+		}
+	}
+	return letters, nil
+}
+
+func (s *deadLetterStore) decrypt(ctx context.Context, d *listener.DeadLetter) (json.RawMessage, error) {
+	var enc encryptedPayload
+	if json.Unmarshal(d.Payload, &enc) == nil && enc.Encrypted != nil {
+		return s.c.Decrypt(ctx, enc.Encrypted)
+	}
+	if len(s.fields) == 0 {
+		// Written before encryption was enabled.
+		return d.Payload, nil
+	}
+	n, err := d.Notification()
+	if err != nil {
+		return nil, err
+	}
+	if n, err = DecryptFields(ctx, s.c, n, s.fields...); err != nil {
+		return nil, err
+	}
+	return json.Marshal(n)
+}
+
+func (s *deadLetterStore) Delete(ctx context.Context, ids ...int64) error {
+	return s.store.Delete(ctx, ids...)
+}
+
+// Count counts the dead letters of the wrapped store if it can.
+func (s *deadLetterStore) Count(ctx context.Context) (int64, error) {
+	if c, ok := s.store.(listener.DeadLetterCounter); ok {
+		return c.Count(ctx)
+	}
+	return 0, listener.ErrNoDeadLetterCount
+}
+
+// Init initializes the wrapped store if it needs it, such as the table of
+// a listener.PostgresDeadLetterStore.
+func (s *deadLetterStore) Init(ctx context.Context) error {
+	if init, ok := s.store.(interface{ Init(context.Context) error }); ok {
+		return init.Init(ctx)
+	}
+	return nil
+}
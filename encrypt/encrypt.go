@@ -0,0 +1,225 @@
+// Package encrypt encrypts notifications before sinks and stores persist
+// them, whole or only selected columns, so stored change history does not
+// hold sensitive values in the clear:
+//
+//	c, err := encrypt.NewAESGCM(key)
+//	dl.Handle(listener.CatchAll, encrypt.Handler(archiveSink, c, "email", "phone"))
+//
+// Ciphers are AES-GCM with a local key, age with X25519 recipients and
+// AWS KMS envelope encryption.
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// Cipher encrypts and decrypts the values and payloads to persist.
+type Cipher interface {
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+	// Extension is appended to the names of encrypted files, e.g. ".age".
+	Extension() string
+}
+
+// The first byte of the ciphertexts of AESGCM and AWSKMS.
+const (
+	versionAESGCM = 1
+	versionKMS    = 2
+)
+
+// AESGCM encrypts with AES-GCM under a local key; ciphertexts are a
+// version byte, the random nonce and the sealed data.
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns an AES-GCM cipher; key is 16, 24 or 32 bytes.
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *AESGCM) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	return seal(c.aead, []byte{versionAESGCM}, plaintext)
+}
+
+func (c *AESGCM) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || ciphertext[0] != versionAESGCM {
+		return nil, errors.New("not an AES-GCM ciphertext")
+	}
+	return open(c.aead, ciphertext[1:])
+}
+
+func (c *AESGCM) Extension() string { return ".enc" }
+
+// seal appends a random nonce and the sealed plaintext to prefix.
+func seal(aead cipher.AEAD, prefix, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(append(prefix, nonce...), nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, data []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// valuePrefix starts the strings replacing encrypted column values,
+// followed by the base64 ciphertext of the value's JSON.
+const valuePrefix = "enc:"
+
+// Fields returns a copy of n with the named columns of its key and row
+// images encrypted. The values become strings holding the ciphertext of
+// their JSON; null values stay null.
+func Fields(ctx context.Context, c Cipher, n *listener.ChangeNotification, fields ...string) (*listener.ChangeNotification, error) {
+	return rewriteRows(n, fields, func(v json.RawMessage) (json.RawMessage, error) {
+		ciphertext, err := c.Encrypt(ctx, v)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(valuePrefix + base64.StdEncoding.EncodeToString(ciphertext))
+	})
+}
+
+// DecryptFields reverses Fields, returning a copy of n with the named
+// columns decrypted.
+func DecryptFields(ctx context.Context, c Cipher, n *listener.ChangeNotification, fields ...string) (*listener.ChangeNotification, error) {
+	return rewriteRows(n, fields, func(v json.RawMessage) (json.RawMessage, error) {
+		var s string
+		if json.Unmarshal(v, &s) != nil || !strings.HasPrefix(s, valuePrefix) {
+			return v, nil
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(s[len(valuePrefix):])
+		if err != nil {
+			return nil, err
+		}
+		return c.Decrypt(ctx, ciphertext)
+	})
+}
+
+func rewriteRows(n *listener.ChangeNotification, fields []string, fn func(json.RawMessage) (json.RawMessage, error)) (*listener.ChangeNotification, error) {
+	out := *n
+	var err error
+	for _, row := range []*json.RawMessage{&out.Key, &out.Data, &out.Old, &out.New} {
+		if len(*row) == 0 || bytes.Equal(*row, []byte("null")) {
+			continue
+		}
+		if *row, err = rewriteRow(*row, fields, fn); err != nil {
+			return nil, fmt.Errorf("%s: %w", n.QualifiedTable(), err)
+		}
+	}
+	return &out, nil
+}
+
+// rewriteRow replaces the non-null values of the named columns of row by
+// fn of them, keeping the column order.
+func rewriteRow(row json.RawMessage, fields []string, fn func(json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	var cols map[string]json.RawMessage
+	if err := json.Unmarshal(row, &cols); err != nil {
+		return nil, errors.New("row is not a JSON object")
+	}
+	touched := false
+	for _, f := range fields {
+		if v, ok := cols[f]; ok && !bytes.Equal(v, []byte("null")) {
+			touched = true
+		}
+	}
+	if !touched {
+		return row, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(row))
+	dec.Token()
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		col := tok.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		if slices.Contains(fields, col) && !bytes.Equal(v, []byte("null")) {
+			if v, err = fn(v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col, err)
+			}
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Handler passes next a copy of each notification with the named columns
+// encrypted; the original is left as it is for other handlers.
+func Handler(next listener.NotificationHandler, c Cipher, fields ...string) listener.NotificationHandler {
+	return listener.HandlerFunc(func(ctx context.Context, n *listener.ChangeNotification) error {
+		enc, err := Fields(ctx, c, n, fields...)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt notification: %w", err)
+		}
+		return next.HandleNotification(ctx, enc)
+	})
+}
+
+// Encoder wraps e so the whole message body is encrypted. Encoders have
+// no context, so ciphers calling a key service use a background one.
+func Encoder(e sink.Encoder, c Cipher) sink.Encoder {
+	return encoder{e: e, c: c}
+}
+
+type encoder struct {
+	e sink.Encoder
+	c Cipher
+}
+
+func (e encoder) Encode(n *listener.ChangeNotification) ([]byte, error) {
+	body, err := e.e.Encode(n)
+	if err != nil {
+		return nil, err
+	}
+	return e.c.Encrypt(context.Background(), body)
+}
+
+func (encoder) ContentType() string { return "application/octet-stream" }
@@ -0,0 +1,99 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+)
+
+// testCiphers returns a cipher of each kind, the KMS one on a fake key
+// service.
+func testCiphers(t *testing.T) map[string]Cipher {
+	t.Helper()
+	key := make([]byte, 32)
+	rand.Read(key)
+	aesgcm, err := NewAESGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, recipient, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAge([]string{recipient}, []string{identity})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Cipher{
+		"aes-gcm": aesgcm,
+		"age":     a,
+		"aws-kms": NewAWSKMSWithClient(newFakeKMS(), "alias/test"),
+	}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	sizes := map[string]int{
+		"empty": 0,
+		"small": 19,
+		// age splits the payload into chunks of 64 KiB.
+		"one chunk":        64 << 10,
+		"beyond one chunk": 64<<10 + 1,
+		"several chunks":   200 << 10,
+	}
+	for name, c := range testCiphers(t) {
+		for size, n := range sizes {
+			t.Run(name+"/"+size, func(t *testing.T) {
+				ctx := context.Background()
+				plaintext := make([]byte, n)
+				rand.Read(plaintext)
+				ciphertext, err := c.Encrypt(ctx, plaintext)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n > 0 && bytes.Contains(ciphertext, plaintext) {
+					t.Fatal("ciphertext holds the plaintext")
+				}
+				got, err := c.Decrypt(ctx, ciphertext)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, plaintext) {
+					t.Error("decrypted plaintext differs")
+				}
+			})
+		}
+	}
+}
+
+func TestCipherTamper(t *testing.T) {
+	plaintext := bytes.Repeat([]byte("secret "), 20000)
+	tests := []struct {
+		name string
+		// change returns a modified copy of the ciphertext.
+		change func(ciphertext []byte) []byte
+	}{
+		{name: "first byte flipped", change: func(c []byte) []byte { c[0] ^= 1; return c }},
+		{name: "middle byte flipped", change: func(c []byte) []byte { c[len(c)/2] ^= 1; return c }},
+		{name: "last byte flipped", change: func(c []byte) []byte { c[len(c)-1] ^= 1; return c }},
+		{name: "truncated by a byte", change: func(c []byte) []byte { return c[:len(c)-1] }},
+		{name: "truncated to half", change: func(c []byte) []byte { return c[:len(c)/2] }},
+		{name: "truncated to the header", change: func(c []byte) []byte { return c[:min(len(c), 40)] }},
+		{name: "empty", change: func([]byte) []byte { return nil }},
+		{name: "appended", change: func(c []byte) []byte { return append(c, 0) }},
+	}
+	for name, c := range testCiphers(t) {
+		ctx := context.Background()
+		ciphertext, err := c.Encrypt(ctx, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				if _, err := c.Decrypt(ctx, tt.change(bytes.Clone(ciphertext))); err == nil {
+					t.Error("decrypted a modified ciphertext")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package encrypt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// DefaultDataKeyLifetime is how long AWSKMS encrypts with one data key
+// before generating the next.
+const DefaultDataKeyLifetime = 5 * time.Minute
+
+// maxCachedDataKeys bounds the decrypted data keys AWSKMS keeps.
+const maxCachedDataKeys = 1024
+
+// KMSAPI is the part of *kms.Client the cipher uses.
+type KMSAPI interface {
+	GenerateDataKey(ctx context.Context, in *kms.GenerateDataKeyInput, opts ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, in *kms.DecryptInput, opts ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// AWSKMS encrypts with envelope encryption: a data key generated by AWS
+// KMS under a KMS key encrypts the data with AES-256-GCM, and the
+// ciphertext carries the data key encrypted by KMS. A data key is reused
+// for DefaultDataKeyLifetime, so KMS is not called for every value.
+type AWSKMS struct {
+	keyID  string
+	client KMSAPI
+
+	mu      sync.Mutex
+	current *dataKey
+	keys    map[string][]byte
+}
+
+type dataKey struct {
+	plaintext []byte
+	encrypted []byte
+	expires   time.Time
+}
+
+// NewAWSKMS encrypts under the KMS key keyID (id, ARN or alias) in region
+// with the SDK's default config: credentials from its default chain,
+// refreshed before they expire, and its region, e.g. AWS_REGION, when
+// region is empty.
+func NewAWSKMS(ctx context.Context, keyID, region string) (*AWSKMS, error) {
+	if keyID == "" {
+		return nil, errors.New("key id is required")
+	}
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("region is required (set AWS_REGION)")
+	}
+	return NewAWSKMSWithClient(kms.NewFromConfig(cfg), keyID), nil
+}
+
+// NewAWSKMSWithClient encrypts under the KMS key keyID with client.
+func NewAWSKMSWithClient(client KMSAPI, keyID string) *AWSKMS {
+	return &AWSKMS{keyID: keyID, client: client, keys: make(map[string][]byte)}
+}
+
+func (k *AWSKMS) Extension() string { return ".enc" }
+
+func (k *AWSKMS) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	key, err := k.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key.plaintext)
+	if err != nil {
+		return nil, err
+	}
+	prefix := binary.BigEndian.AppendUint16([]byte{versionKMS}, uint16(len(key.encrypted)))
+	return seal(aead, append(prefix, key.encrypted...), plaintext)
+}
+
+func (k *AWSKMS) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 3 || ciphertext[0] != versionKMS {
+		return nil, errors.New("not a KMS ciphertext")
+	}
+	n := int(binary.BigEndian.Uint16(ciphertext[1:3]))
+	if len(ciphertext) < 3+n {
+		return nil, errors.New("ciphertext too short")
+	}
+	key, err := k.decryptKey(ctx, ciphertext[3:3+n])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ciphertext[3+n:])
+}
+
+func (k *AWSKMS) dataKey(ctx context.Context) (*dataKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.current != nil && time.Now().Before(k.current.expires) {
+		return k.current, nil
+	}
+	out, err := k.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{KeyId: aws.String(k.keyID), KeySpec: types.DataKeySpecAes256})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	k.current = &dataKey{plaintext: out.Plaintext, encrypted: out.CiphertextBlob, expires: time.Now().Add(DefaultDataKeyLifetime)}
+	k.cache(out.CiphertextBlob, out.Plaintext)
+	return k.current, nil
+}
+
+func (k *AWSKMS) decryptKey(ctx context.Context, encrypted []byte) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.keys[string(encrypted)]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	out, err := k.client.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: encrypted, KeyId: aws.String(k.keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+	k.mu.Lock()
+	k.cache(encrypted, out.Plaintext)
+	k.mu.Unlock()
+	return out.Plaintext, nil
+}
+
+// cache remembers a decrypted data key; called with k.mu held.
+func (k *AWSKMS) cache(encrypted, plaintext []byte) {
+	if len(k.keys) >= maxCachedDataKeys {
+		clear(k.keys)
+	}
+	k.keys[string(encrypted)] = plaintext
+}
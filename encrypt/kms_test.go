@@ -0,0 +1,94 @@
+package encrypt
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// fakeKMS generates data keys whose ciphertext blobs name them, and
+// counts the calls.
+type fakeKMS struct {
+	mu                  sync.Mutex
+	keys                map[string][]byte
+	generated, decrypts int
+}
+
+func newFakeKMS() *fakeKMS {
+	return &fakeKMS{keys: make(map[string][]byte)}
+}
+
+func (f *fakeKMS) GenerateDataKey(_ context.Context, in *kms.GenerateDataKeyInput, _ ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.generated++
+	key := make([]byte, 32)
+	rand.Read(key)
+	blob := fmt.Sprintf("%s/%d", *in.KeyId, f.generated)
+	f.keys[blob] = key
+	return &kms.GenerateDataKeyOutput{Plaintext: key, CiphertextBlob: []byte(blob)}, nil
+}
+
+func (f *fakeKMS) Decrypt(_ context.Context, in *kms.DecryptInput, _ ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.decrypts++
+	key, ok := f.keys[string(in.CiphertextBlob)]
+	if !ok {
+		return nil, errors.New("InvalidCiphertextException")
+	}
+	return &kms.DecryptOutput{Plaintext: key}, nil
+}
+
+func TestAWSKMSDataKeys(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeKMS()
+	k := NewAWSKMSWithClient(fake, "alias/test")
+
+	first, err := k.Encrypt(ctx, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Encrypt(ctx, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if fake.generated != 1 {
+		t.Errorf("generated %d data keys within the lifetime, want 1", fake.generated)
+	}
+
+	// Once the data key expired the next is generated.
+	k.current.expires = time.Now().Add(-time.Second)
+	second, err := k.Encrypt(ctx, []byte("c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.generated != 2 {
+		t.Errorf("generated %d data keys after expiry, want 2", fake.generated)
+	}
+
+	// Another instance decrypts the data keys with KMS once each.
+	other := NewAWSKMSWithClient(fake, "alias/test")
+	for _, ciphertext := range [][]byte{first, first, second} {
+		if _, err := other.Decrypt(ctx, ciphertext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.decrypts != 2 {
+		t.Errorf("decrypted %d data keys, want 2", fake.decrypts)
+	}
+
+	// A data key KMS does not know fails.
+	foreign, err := NewAWSKMSWithClient(newFakeKMS(), "alias/other").Encrypt(ctx, []byte("d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAWSKMSWithClient(newFakeKMS(), "alias/test").Decrypt(ctx, foreign); err == nil {
+		t.Error("decrypted with an unknown data key")
+	}
+}
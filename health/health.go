@@ -0,0 +1,134 @@
+// Package health serves Kubernetes-style liveness and readiness probes for a
+// DataListener.
+package health
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const DefaultMaxPingAge = time.Minute
+
+type Checker struct {
+	dl         *listener.DataListener
+	others     map[string]*listener.DataListener
+	maxPingAge time.Duration
+	maxBacklog int
+}
+
+type Option func(*Checker)
+
+// WithMaxPingAge marks the listener unready when the last successful ping is
+// older than d.
+func WithMaxPingAge(d time.Duration) Option {
+	return func(c *Checker) {
+		c.maxPingAge = d
+	}
+}
+
+// WithMaxBacklog marks the listener unready while more than n notifications
+// are queued. Zero disables the check.
+func WithMaxBacklog(n int) Option {
+	return func(c *Checker) {
+		c.maxBacklog = n
+	}
+}
+
+// WithListener also checks dl, e.g. the listener of another database run
+// by a listener.Supervisor. Its problems are reported prefixed with name.
+func WithListener(name string, dl *listener.DataListener) Option {
+	return func(c *Checker) {
+		if c.others == nil {
+			c.others = make(map[string]*listener.DataListener)
+		}
+		c.others[name] = dl
+	}
+}
+
+func New(dl *listener.DataListener, opts ...Option) *Checker {
+	c := &Checker{dl: dl, maxPingAge: DefaultMaxPingAge}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+type response struct {
+	Status   string          `json:"status"`
+	Problems []string        `json:"problems,omitempty"`
+	Listener listener.Status `json:"listener"`
+	// Listeners holds the listeners added with WithListener.
+	Listeners map[string]listener.Status `json:"listeners,omitempty"`
+}
+
+// Live reports problems that warrant restarting the process.
+func (c *Checker) Live(st listener.Status) []string {
+	if !st.Running && !st.Standby {
+		return []string{"listener is not running"}
+	}
+	return nil
+}
+
+// Ready reports problems that should take the instance out of rotation.
+func (c *Checker) Ready(st listener.Status) []string {
+	problems := c.Live(st)
+	if st.Standby {
+		// A standby waiting for the leader lock is healthy.
+		return problems
+	}
+	if !st.Connected {
+		problems = append(problems, "not connected")
+	}
+	if c.maxPingAge > 0 && time.Since(st.LastPing) > c.maxPingAge {
+		problems = append(problems, "last successful ping too old")
+	}
+	if c.maxBacklog > 0 && st.QueueDepth > c.maxBacklog {
+		problems = append(problems, "backlog too large")
+	}
+	return problems
+}
+
+// Handler serves /healthz and /readyz.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	c.Register(mux)
+	return mux
+}
+
+// Register adds /healthz and /readyz to mux.
+func (c *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", c.serve(c.Live))
+	mux.HandleFunc("/readyz", c.serve(c.Ready))
+}
+
+func (c *Checker) serve(check func(listener.Status) []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := c.dl.Status()
+		resp := response{Status: "ok", Problems: check(st), Listener: st}
+		for _, name := range slices.Sorted(maps.Keys(c.others)) {
+			st := c.others[name].Status()
+			if resp.Listeners == nil {
+				resp.Listeners = make(map[string]listener.Status, len(c.others))
+			}
+			resp.Listeners[name] = st
+			for _, p := range check(st) {
+				resp.Problems = append(resp.Problems, name+": "+p)
+			}
+		}
+
+		code := http.StatusOK
+		if len(resp.Problems) > 0 {
+			resp.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	}
+}
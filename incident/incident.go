@@ -0,0 +1,235 @@
+// Package incident opens incidents in an on-call service such as
+// PagerDuty or Opsgenie while a DataListener is unhealthy: its connection
+// is down for too long, its dead letter queue grows beyond a threshold or
+// its replication slot lags too far behind. Incidents are resolved once
+// the problem is gone.
+package incident
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const DefaultInterval = 30 * time.Second
+
+// The severities of an Incident, in the terms of PagerDuty.
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+)
+
+// Incident is a problem of one listener. Key identifies it across checks,
+// so a service deduplicates repeated triggers and resolves the right one.
+type Incident struct {
+	Key      string
+	Summary  string
+	Severity string
+	// Source names the listener, "default" for the one given to New.
+	Source  string
+	Details map[string]any
+}
+
+// Notifier is an on-call service.
+type Notifier interface {
+	Trigger(ctx context.Context, inc Incident) error
+	Resolve(ctx context.Context, key string) error
+}
+
+// Rules are the thresholds of a Monitor; zero disables a rule.
+type Rules struct {
+	// Disconnected is how long a listener may be disconnected.
+	Disconnected time.Duration
+	// DeadLetters is how many dead letters a listener may hold. Stores
+	// without listener.DeadLetterCounter are not checked.
+	DeadLetters int64
+	// LagBytes is how far the replication slot may trail the WAL.
+	LagBytes int64
+}
+
+// The kinds of incidents, the last part of their keys.
+const (
+	KindDisconnected = "disconnected"
+	KindDeadLetters  = "dead-letters"
+	KindLag          = "replication-lag"
+)
+
+// Monitor checks listeners against Rules.
+type Monitor struct {
+	notifier  Notifier
+	rules     Rules
+	interval  time.Duration
+	keyPrefix string
+	listeners map[string]*listener.DataListener
+	logger    listener.Logger
+
+	// down holds since when each listener is disconnected, open the keys
+	// of the triggered incidents.
+	down map[string]time.Time
+	open map[string]bool
+}
+
+type Option func(*Monitor)
+
+// WithInterval sets how often the listeners are checked (DefaultInterval).
+func WithInterval(d time.Duration) Option {
+	return func(m *Monitor) {
+		m.interval = d
+	}
+}
+
+// WithListener also checks dl, e.g. the listener of another database run
+// by a listener.Supervisor, as the source name.
+func WithListener(name string, dl *listener.DataListener) Option {
+	return func(m *Monitor) {
+		m.listeners[name] = dl
+	}
+}
+
+// WithKeyPrefix prefixes the incident keys ("pg-data-listener"), telling
+// apart several deployments reporting to the same service.
+func WithKeyPrefix(prefix string) Option {
+	return func(m *Monitor) {
+		m.keyPrefix = prefix
+	}
+}
+
+// WithLogger sets the logger, slog.Default by default.
+func WithLogger(l listener.Logger) Option {
+	return func(m *Monitor) {
+		m.logger = l
+	}
+}
+
+func New(dl *listener.DataListener, n Notifier, rules Rules, opts ...Option) *Monitor {
+	m := &Monitor{
+		notifier:  n,
+		rules:     rules,
+		interval:  DefaultInterval,
+		keyPrefix: "pg-data-listener",
+		listeners: map[string]*listener.DataListener{"default": dl},
+		logger:    slog.Default(),
+		down:      make(map[string]time.Time),
+		open:      make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Run checks the listeners every interval until ctx is canceled.
+func (m *Monitor) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+// Check checks the listeners once, triggering the incidents of new
+// problems and resolving those of problems gone. Triggers and resolves
+// that fail are logged and retried on the next check. Check is not safe
+// for concurrent use.
+func (m *Monitor) Check(ctx context.Context) {
+	now := time.Now()
+	active := make(map[string]Incident)
+	// unknown holds the keys whose check failed, which stay as they are.
+	unknown := make(map[string]bool)
+	for _, name := range slices.Sorted(maps.Keys(m.listeners)) {
+		incs, failed := m.problems(ctx, name, m.listeners[name], now)
+		for _, inc := range incs {
+			active[inc.Key] = inc
+		}
+		for _, key := range failed {
+			unknown[key] = true
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(active)) {
+		if m.open[key] {
+			continue
+		}
+		if err := m.notifier.Trigger(ctx, active[key]); err != nil {
+			m.logger.Error("failed to trigger incident", "key", key, "error", err)
+			continue
+		}
+		m.logger.Warn("triggered incident", "key", key, "summary", active[key].Summary)
+		m.open[key] = true
+	}
+	for _, key := range slices.Sorted(maps.Keys(m.open)) {
+		if _, ok := active[key]; ok || unknown[key] {
+			continue
+		}
+		if err := m.notifier.Resolve(ctx, key); err != nil {
+			m.logger.Error("failed to resolve incident", "key", key, "error", err)
+			continue
+		}
+		m.logger.Info("resolved incident", "key", key)
+		delete(m.open, key)
+	}
+}
+
+// problems returns the incidents of the listener name, and the keys of
+// those that could not be checked.
+func (m *Monitor) problems(ctx context.Context, name string, dl *listener.DataListener, now time.Time) ([]Incident, []string) {
+	st := dl.Status()
+	key := func(kind string) string {
+		return m.keyPrefix + ":" + name + ":" + kind
+	}
+	incident := func(kind, severity, summary string, details map[string]any) Incident {
+		return Incident{
+			Key:      key(kind),
+			Summary:  summary,
+			Severity: severity,
+			Source:   name,
+			Details:  details,
+		}
+	}
+	var incs []Incident
+	var failed []string
+
+	if st.Connected || st.Standby {
+		delete(m.down, name)
+	} else if _, ok := m.down[name]; !ok {
+		m.down[name] = now
+	}
+	if since, ok := m.down[name]; ok && m.rules.Disconnected > 0 && now.Sub(since) >= m.rules.Disconnected {
+		incs = append(incs, incident(KindDisconnected, SeverityCritical,
+			fmt.Sprintf("listener %s disconnected for %s", name, now.Sub(since).Round(time.Second)),
+			map[string]any{"since": since, "last_ping": st.LastPing, "running": st.Running}))
+	}
+
+	if m.rules.DeadLetters > 0 {
+		n, err := dl.DeadLetterCount(ctx)
+		switch {
+		case errors.Is(err, listener.ErrNoDeadLetterCount):
+		case err != nil:
+			m.logger.Error("failed to count dead letters", "source", name, "error", err)
+			failed = append(failed, key(KindDeadLetters))
+		case n > m.rules.DeadLetters:
+			incs = append(incs, incident(KindDeadLetters, SeverityError,
+				fmt.Sprintf("listener %s holds %d dead letters", name, n),
+				map[string]any{"dead_letters": n, "threshold": m.rules.DeadLetters}))
+		}
+	}
+
+	if s := st.Slot; s != nil && m.rules.LagBytes > 0 && s.LagBytes > m.rules.LagBytes {
+		incs = append(incs, incident(KindLag, SeverityError,
+			fmt.Sprintf("replication slot %s of listener %s lags %d bytes", s.Name, name, s.LagBytes),
+			map[string]any{"slot": s.Name, "lag_bytes": s.LagBytes, "retained_bytes": s.RetainedBytes, "wal_status": s.WALStatus, "threshold": m.rules.LagBytes}))
+	}
+	return incs, failed
+}
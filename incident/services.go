@@ -0,0 +1,145 @@
+package incident
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	DefaultOpsgenieURL  = "https://api.opsgenie.com"
+	// DefaultTimeout bounds each request to a service.
+	DefaultTimeout = 10 * time.Second
+)
+
+// PagerDuty opens incidents with the Events API v2 of a PagerDuty service
+// integration.
+type PagerDuty struct {
+	RoutingKey string
+	// URL is DefaultPagerDutyURL when empty.
+	URL    string
+	Client *http.Client
+}
+
+func (p *PagerDuty) Trigger(ctx context.Context, inc Incident) error {
+	return p.send(ctx, map[string]any{
+		"routing_key":  p.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    inc.Key,
+		"payload": map[string]any{
+			"summary":        inc.Summary,
+			"source":         inc.Source,
+			"severity":       inc.Severity,
+			"custom_details": inc.Details,
+		},
+	})
+}
+
+func (p *PagerDuty) Resolve(ctx context.Context, key string) error {
+	return p.send(ctx, map[string]any{
+		"routing_key":  p.RoutingKey,
+		"event_action": "resolve",
+		"dedup_key":    key,
+	})
+}
+
+func (p *PagerDuty) send(ctx context.Context, event map[string]any) error {
+	u := p.URL
+	if u == "" {
+		u = DefaultPagerDutyURL
+	}
+	return post(ctx, p.Client, u, nil, event)
+}
+
+// Opsgenie opens alerts with the Alert API of Opsgenie, aliased by the
+// incident key.
+type Opsgenie struct {
+	APIKey string
+	// URL is DefaultOpsgenieURL when empty; use https://api.eu.opsgenie.com
+	// for the EU instance.
+	URL    string
+	Client *http.Client
+}
+
+// opsgeniePriorities maps the severities to Opsgenie priorities.
+var opsgeniePriorities = map[string]string{
+	SeverityCritical: "P1",
+	SeverityError:    "P2",
+	SeverityWarning:  "P3",
+}
+
+func (o *Opsgenie) Trigger(ctx context.Context, inc Incident) error {
+	details := make(map[string]string, len(inc.Details))
+	for k, v := range inc.Details {
+		details[k] = fmt.Sprint(v)
+	}
+	message := inc.Summary
+	if len(message) > 130 {
+		message = message[:127] + "..."
+	}
+	priority, ok := opsgeniePriorities[inc.Severity]
+	if !ok {
+		priority = "P3"
+	}
+	return post(ctx, o.Client, o.url("/v2/alerts"), o.header(), map[string]any{
+		"message":     message,
+		"alias":       inc.Key,
+		"description": inc.Summary,
+		"source":      inc.Source,
+		"priority":    priority,
+		"details":     details,
+	})
+}
+
+func (o *Opsgenie) Resolve(ctx context.Context, key string) error {
+	return post(ctx, o.Client, o.url("/v2/alerts/"+url.PathEscape(key)+"/close?identifierType=alias"), o.header(), map[string]any{})
+}
+
+func (o *Opsgenie) url(path string) string {
+	base := o.URL
+	if base == "" {
+		base = DefaultOpsgenieURL
+	}
+	return base + path
+}
+
+func (o *Opsgenie) header() http.Header {
+	return http.Header{"Authorization": {"GenieKey " + o.APIKey}}
+}
+
+func post(ctx context.Context, client *http.Client, u string, header http.Header, body any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(detail))
+}
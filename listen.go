@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/force-c/pg-data-listener/config"
+	"github.com/force-c/pg-data-listener/health"
+	"github.com/force-c/pg-data-listener/incident"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/metrics"
+	"github.com/force-c/pg-data-listener/secrets"
+)
+
+// listen runs the service until SIGINT or SIGTERM.
+func listen(args []string) int {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "listen [flags]")
+	configPath, flags := configFlags(fs)
+	demo := fs.Bool("demo", false, "log changes to s_config and s_user with the example handlers")
+	fs.Parse(args)
+
+	cfg, logger, levelVar, ok := loadConfig(*configPath, flags)
+	if !ok {
+		return exitConfig
+	}
+
+	resolver := cfg.Secrets.Resolver()
+	svc := newService(*configPath, flags, logger, levelVar, resolver)
+	sup := listener.NewSupervisor()
+	defer sup.Close()
+	for _, name := range cfg.SourceNames() {
+		src := cfg.Source(name)
+		db, err := resolveDatabase(src.Database, resolver)
+		if err != nil {
+			logger.Error("failed to resolve secrets", "source", name, "error", err)
+			svc.Close()
+			return exitConfig
+		}
+		// A referenced password is served to every new connection, so a
+		// rotated one is used from the next refresh on.
+		var password *rotatingPassword
+		if resolver.IsRef(src.Database.Password) {
+			password = newRotatingPassword(db.Password)
+		}
+		dl, err := newListener(cfg, name, db, password, logger)
+		if err != nil {
+			logger.Error("failed to create listener", "source", name, "error", err)
+			svc.Close()
+			return exitFailure
+		}
+		sup.Add(name, dl)
+		svc.addSource(name, dl, password)
+	}
+	dl, _ := sup.Listener(config.DefaultSource)
+
+	if err := svc.apply(cfg); err != nil {
+		logger.Error("failed to apply config", "error", err)
+		svc.Close()
+		return exitConfig
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			if err := svc.reload(); err != nil {
+				logger.Error("reload failed", "error", err)
+			}
+		}
+	}()
+
+	// The first SIGINT or SIGTERM stops listening and drains in-flight
+	// notifications; a second one kills the process.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	go func() {
+		t := time.NewTicker(cfg.Secrets.RefreshInterval())
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := svc.refreshSecrets(); err != nil {
+					logger.Error("failed to refresh secrets", "error", err)
+				}
+			}
+		}
+	}()
+
+	if inc := cfg.Incidents; inc != nil {
+		monitor, err := newIncidentMonitor(*inc, sup, resolver, logger)
+		if err != nil {
+			logger.Error("failed to resolve secrets", "error", err)
+			svc.Close()
+			return exitConfig
+		}
+		go monitor.Run(ctx)
+	}
+
+	var srv *http.Server
+	var httpFailed atomic.Bool
+	if cfg.HTTP.Addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		var checks []health.Option
+		for _, name := range sup.Names()[1:] {
+			other, _ := sup.Listener(name)
+			checks = append(checks, health.WithListener(name, other))
+		}
+		health.New(dl, checks...).Register(mux)
+		if cfg.HTTP.AdminToken != "" {
+			svc.registerAdmin(mux, cfg.HTTP.AdminToken)
+		} else {
+			logger.Warn("admin API disabled, set http.admin_token to enable it")
+		}
+		srv = &http.Server{Addr: cfg.HTTP.Addr, Handler: mux}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("HTTP server failed", "error", err)
+				httpFailed.Store(true)
+				cancel()
+			}
+		}()
+	}
+
+	if *demo {
+		dl.RegisterHandler("s_config", &ConfigManager{})
+		dl.RegisterHandler("s_user", &UserManager{})
+	}
+
+	code := exitOK
+	logger.Info("starting listener", "sources", sup.Names())
+	if err := sup.Start(ctx); err != nil {
+		logger.Error("listener stopped", "error", err)
+		code = exitFailure
+	}
+
+	// Start has drained in-flight notifications and saved their
+	// checkpoints; closing the sinks flushes what they still buffer.
+	if err := svc.Close(); err != nil {
+		logger.Error("failed to close sinks", "error", err)
+		code = exitFailure
+	}
+	if httpFailed.Load() {
+		code = exitFailure
+	}
+	if srv != nil {
+		shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second)
+		defer done()
+		srv.Shutdown(shutdownCtx)
+	}
+	logger.Info("stopped", "exit_code", code)
+	return code
+}
+
+// newListener creates the listener of the named source, connecting to db,
+// its database with the secrets resolved. With several sources the logs
+// and metrics of each carry its name.
+func newListener(cfg *config.Config, name string, db config.Database, password *rotatingPassword, logger *slog.Logger) (*listener.DataListener, error) {
+	var reg prometheus.Registerer
+	if len(cfg.Sources) > 0 {
+		logger = logger.With("source", name)
+		reg = prometheus.WrapRegistererWith(prometheus.Labels{"source": name}, prometheus.DefaultRegisterer)
+	}
+
+	opts := []listener.Option{listener.WithLogger(logger)}
+	opts = append(opts, cfg.SourceOptions(name)...)
+	if cfg.HTTP.Addr != "" {
+		opts = append(opts, listener.WithMetrics(metrics.NewPrometheus(reg)))
+	}
+	if password != nil {
+		opts = append(opts, listener.WithPassword(password.get))
+	}
+	return listener.New(db.ConnString(), opts...)
+}
+
+// newIncidentMonitor checks the listeners of sup for the rules of inc.
+// Its keys are resolved once, so rotating them takes a restart.
+func newIncidentMonitor(inc config.Incidents, sup *listener.Supervisor, r *secrets.Resolver, logger *slog.Logger) (*incident.Monitor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+	inc, err := inc.ResolveSecrets(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	opts := []incident.Option{incident.WithLogger(logger.With("component", "incidents"))}
+	if inc.Interval > 0 {
+		opts = append(opts, incident.WithInterval(time.Duration(inc.Interval)))
+	}
+	for _, name := range sup.Names()[1:] {
+		other, _ := sup.Listener(name)
+		opts = append(opts, incident.WithListener(name, other))
+	}
+	dl, _ := sup.Listener(config.DefaultSource)
+	return incident.New(dl, inc.Notifier(), inc.Rules(), opts...), nil
+}
@@ -0,0 +1,337 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNacked fails notifications a handler nacked without an error.
+var ErrNacked = errors.New("notification nacked")
+
+type ackKey struct{}
+
+// ackState lets a handler take over completion of its notification.
+type ackState struct {
+	mu       sync.Mutex
+	deferred bool
+	acked    bool
+	nacked   *nack
+	complete func()
+	onNack   func(*nack)
+}
+
+// DeferAck tells the listener that the handler finishes the notification
+// in ctx asynchronously, e.g. after a producer confirms delivery. The
+// notification does not count as processed for checkpointing until the
+// returned function is called; calling it again has no effect. Outside a
+// handler it returns a no-op.
+func DeferAck(ctx context.Context) (ack func()) {
+	st, ok := ctx.Value(ackKey{}).(*ackState)
+	if !ok {
+		return func() {}
+	}
+	st.deferAck()
+	return st.ack
+}
+
+// Acknowledgement settles the notification of a handler explicitly, see
+// Acknowledge.
+type Acknowledgement struct {
+	st *ackState
+}
+
+// Acknowledge tells the listener that the handler settles the notification
+// in ctx itself, now or after the handler returned: Ack finishes it like
+// DeferAck, Nack fails it. Only the first of the calls counts. An error the
+// handler returns takes precedence, and a later Nack then only finishes the
+// notification. Outside a handler the calls have no effect.
+func Acknowledge(ctx context.Context) *Acknowledgement {
+	st, ok := ctx.Value(ackKey{}).(*ackState)
+	if !ok {
+		return &Acknowledgement{}
+	}
+	st.deferAck()
+	return &Acknowledgement{st: st}
+}
+
+// Ack marks the notification as processed.
+func (a *Acknowledgement) Ack() {
+	if a.st != nil {
+		a.st.ack()
+	}
+}
+
+// Nack fails the notification with err. By default it is delivered to the
+// handler again per its retry policy, as if the handler had returned err,
+// and fails once the attempts are exhausted.
+func (a *Acknowledgement) Nack(err error, opts ...NackOption) {
+	if a.st == nil {
+		return
+	}
+	if err == nil {
+		err = ErrNacked
+	}
+	nk := &nack{err: err}
+	for _, opt := range opts {
+		opt(nk)
+	}
+	a.st.nack(nk)
+}
+
+// Parts splits the settlement of the notification in ctx across n
+// handlers it is passed on to, e.g. one per sink: it is acknowledged once
+// each of them has, and nacked as the first of them nacks. Call handler i
+// with parts[i] and done(i) once it returned. Outside a handler every part
+// is ctx.
+func Parts(ctx context.Context, n int) (parts []context.Context, done func(i int)) {
+	parts = make([]context.Context, n)
+	parent, ok := ctx.Value(ackKey{}).(*ackState)
+	if !ok || n == 0 {
+		for i := range parts {
+			parts[i] = ctx
+		}
+		return parts, func(int) {}
+	}
+	parent.deferAck()
+
+	var (
+		mu     sync.Mutex
+		left   = n
+		failed *nack
+	)
+	settled := func(nk *nack) {
+		mu.Lock()
+		if failed == nil {
+			failed = nk
+		}
+		left--
+		last, first := left == 0, failed
+		mu.Unlock()
+		switch {
+		case !last:
+		case first != nil:
+			parent.nack(first)
+		default:
+			parent.ack()
+		}
+	}
+	states := make([]*ackState, n)
+	for i := range parts {
+		states[i] = &ackState{}
+		parts[i] = context.WithValue(ctx, ackKey{}, states[i])
+	}
+	return parts, func(i int) {
+		states[i].settle(func() { settled(nil) }, settled)
+	}
+}
+
+// NackOption configures how a nacked notification is handled.
+type NackOption func(*nack)
+
+// RetryAfter delivers the notification again after d, e.g. the delay a
+// rate-limited destination asked for, instead of after the retry policy's
+// backoff. The attempts the policy allows still apply.
+func RetryAfter(d time.Duration) NackOption {
+	return func(nk *nack) {
+		nk.retryAfter = d
+		nk.retry = true
+	}
+}
+
+// RouteToDeadLetters fails the notification without further attempts, like
+// a Permanent error: it goes to the dead-letter store if the listener has
+// one, and to the error handler.
+func RouteToDeadLetters() NackOption {
+	return func(nk *nack) {
+		nk.deadLetter = true
+	}
+}
+
+type nack struct {
+	err        error
+	retry      bool
+	retryAfter time.Duration
+	deadLetter bool
+}
+
+// next returns the delay before delivering a notification nacked for the
+// attempts-th time again, or false when it fails instead.
+func (nk *nack) next(p RetryPolicy, attempts int) (time.Duration, bool) {
+	switch {
+	case nk.deadLetter, attempts >= p.MaxAttempts, isPermanent(nk.err):
+		return 0, false
+	case nk.retry:
+		return nk.retryAfter, true
+	}
+	return p.Backoff(attempts), true
+}
+
+type nackKey struct{}
+
+// nackHistory counts the deliveries of a notification that were nacked.
+type nackHistory struct {
+	first    time.Time
+	attempts int
+}
+
+// nackedBefore returns the nack history of the notification in ctx.
+func nackedBefore(ctx context.Context) nackHistory {
+	h, _ := ctx.Value(nackKey{}).(nackHistory)
+	return h
+}
+
+func (st *ackState) deferAck() {
+	st.mu.Lock()
+	st.deferred = true
+	st.mu.Unlock()
+}
+
+func (st *ackState) ack() {
+	st.mu.Lock()
+	if st.acked || st.nacked != nil {
+		st.mu.Unlock()
+		return
+	}
+	st.acked = true
+	complete := st.complete
+	st.mu.Unlock()
+	if complete != nil {
+		complete()
+	}
+}
+
+func (st *ackState) nack(nk *nack) {
+	st.mu.Lock()
+	if st.acked || st.nacked != nil {
+		st.mu.Unlock()
+		return
+	}
+	st.nacked = nk
+	complete, onNack := st.complete, st.onNack
+	st.mu.Unlock()
+	switch {
+	case onNack != nil:
+		onNack(nk)
+	case complete != nil:
+		complete()
+	}
+}
+
+// finish runs complete now, unless the handler deferred its ack and has
+// not acknowledged yet, in which case the ack runs it. A nack counts as an
+// ack.
+func (st *ackState) finish(complete func()) {
+	st.settle(complete, nil)
+}
+
+// settle is finish, except that a nack runs nacked instead of complete
+// if it is set.
+func (st *ackState) settle(complete func(), nacked func(*nack)) {
+	st.mu.Lock()
+	if nk := st.nacked; nk != nil {
+		st.mu.Unlock()
+		if nacked != nil {
+			nacked(nk)
+		} else {
+			complete()
+		}
+		return
+	}
+	if st.deferred && !st.acked {
+		st.complete, st.onNack = complete, nacked
+		st.mu.Unlock()
+		return
+	}
+	st.mu.Unlock()
+	complete()
+}
+
+// acknowledge hands the settlement of a handler that deferred its ack on
+// to the notification in ctx. Nacks deliver the notification again or fail
+// it, unless the handler already failed.
+func (dl *DataListener) acknowledge(ctx context.Context, n *ChangeNotification, reg *registration, policy RetryPolicy, st *ackState, first time.Time, failed bool) {
+	st.mu.Lock()
+	deferred := st.deferred
+	st.mu.Unlock()
+	if !deferred {
+		return
+	}
+	ack := DeferAck(ctx)
+	if failed {
+		st.finish(ack)
+		return
+	}
+	st.settle(ack, func(nk *nack) {
+		giveUp := func(f *Failure) {
+			dl.fail(ctx, f)
+			ack()
+		}
+		dl.nacked(ctx, n, nk, policy, first, giveUp, func(ctx context.Context) {
+			h := heldNotification{ctx: ctx, n: n, ack: ack}
+			if failure := h.redeliver(func(ctx context.Context) *Failure { return dl.call(ctx, n, reg) }); failure != nil {
+				dl.fail(ctx, failure)
+			}
+		})
+	})
+}
+
+// nacked calls redeliver after the delay nk asks for, with the nack
+// counted in its ctx, or else giveUp with the failure. The waiting
+// notification counts as in flight, and is redelivered where its ordering
+// key is processed, see schedule. A notification still waiting when the
+// listener stops is left unacknowledged, so it is delivered again after a
+// restart.
+func (dl *DataListener) nacked(ctx context.Context, n *ChangeNotification, nk *nack, policy RetryPolicy, first time.Time, giveUp func(*Failure), redeliver func(ctx context.Context)) {
+	h := nackedBefore(ctx)
+	if h.attempts == 0 {
+		h.first = first
+	}
+	h.attempts++
+	delay, retry := nk.next(policy, h.attempts)
+	if !retry {
+		giveUp(&Failure{Notification: n, Err: nk.err, Attempts: h.attempts, FirstAttempt: h.first, LastAttempt: time.Now()})
+		return
+	}
+	dl.logger.Warn("handler nacked notification",
+		"channel", n.Channel, "table", n.Table, "operation", n.Operation,
+		"attempts", h.attempts, "retry_in", delay, "error", nk.err)
+	ctx = context.WithValue(ctx, nackKey{}, h)
+	dl.inflight.Add(1)
+	time.AfterFunc(delay, func() {
+		scheduled := dl.schedule(ctx, n, func(ctx context.Context) {
+			defer dl.inflight.Done()
+			redeliver(ctx)
+		})
+		if !scheduled {
+			dl.inflight.Done()
+		}
+	})
+}
+
+// schedule runs fn on the worker owning n's ordering key, or on the
+// receive loop without workers, so it does not race the notifications
+// sharing the key. It reports false if the listener stopped first.
+func (dl *DataListener) schedule(ctx context.Context, n *ChangeNotification, fn func(context.Context)) bool {
+	select {
+	case <-dl.stop:
+		return false
+	case <-dl.done:
+		return false
+	default:
+	}
+	dl.mu.Lock()
+	pool := dl.pool
+	dl.mu.Unlock()
+	if pool != nil {
+		return pool.run(ctx, dl.stop, n, fn) == nil
+	}
+	select {
+	case dl.scheduled <- func() { fn(ctx) }:
+		return true
+	case <-dl.stop:
+	case <-dl.done:
+	}
+	return false
+}
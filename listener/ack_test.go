@@ -0,0 +1,292 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ackOutcome records how an ackState settled.
+type ackOutcome struct {
+	completed int
+	nacked    *nack
+}
+
+func (o *ackOutcome) complete() { o.completed++ }
+
+func (o *ackOutcome) onNack(nk *nack) { o.nacked = nk }
+
+func (o *ackOutcome) settled() bool { return o.completed > 0 || o.nacked != nil }
+
+func TestAckState(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name string
+		// before runs in the handler, after once it returned.
+		before, after func(ctx context.Context)
+		// nacked sets the settle callback for nacks, finish is used without.
+		nacked        bool
+		wantSettled   bool
+		wantCompleted int
+		wantNack      error
+	}{
+		{
+			name:          "handler without ack",
+			wantSettled:   true,
+			wantCompleted: 1,
+		},
+		{
+			name:   "deferred ack pending",
+			before: func(ctx context.Context) { DeferAck(ctx) },
+		},
+		{
+			name:          "deferred ack called later",
+			before:        func(ctx context.Context) { DeferAck(ctx) },
+			after:         func(ctx context.Context) { ack := DeferAck(ctx); ack(); ack() },
+			wantSettled:   true,
+			wantCompleted: 1,
+		},
+		{
+			name:          "acked within the handler",
+			before:        func(ctx context.Context) { Acknowledge(ctx).Ack() },
+			wantSettled:   true,
+			wantCompleted: 1,
+		},
+		{
+			name:        "nacked within the handler",
+			before:      func(ctx context.Context) { Acknowledge(ctx).Nack(errFailed) },
+			nacked:      true,
+			wantSettled: true,
+			wantNack:    errFailed,
+		},
+		{
+			name:        "nacked later",
+			before:      func(ctx context.Context) { Acknowledge(ctx) },
+			after:       func(ctx context.Context) { Acknowledge(ctx).Nack(nil) },
+			nacked:      true,
+			wantSettled: true,
+			wantNack:    ErrNacked,
+		},
+		{
+			name:          "nack counts as ack without callback",
+			before:        func(ctx context.Context) { Acknowledge(ctx).Nack(errFailed) },
+			wantSettled:   true,
+			wantCompleted: 1,
+		},
+		{
+			name: "first settlement counts",
+			before: func(ctx context.Context) {
+				a := Acknowledge(ctx)
+				a.Ack()
+				a.Nack(errFailed)
+			},
+			nacked:        true,
+			wantSettled:   true,
+			wantCompleted: 1,
+		},
+		{
+			name:   "ack after nack ignored",
+			before: func(ctx context.Context) { Acknowledge(ctx) },
+			after: func(ctx context.Context) {
+				a := Acknowledge(ctx)
+				a.Nack(errFailed)
+				a.Ack()
+			},
+			nacked:      true,
+			wantSettled: true,
+			wantNack:    errFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &ackState{}
+			ctx := context.WithValue(context.Background(), ackKey{}, st)
+			if tt.before != nil {
+				tt.before(ctx)
+			}
+			var o ackOutcome
+			if tt.nacked {
+				st.settle(o.complete, o.onNack)
+			} else {
+				st.finish(o.complete)
+			}
+			if tt.after != nil {
+				tt.after(ctx)
+			}
+			if o.settled() != tt.wantSettled || o.completed != tt.wantCompleted {
+				t.Fatalf("settled = %v with %d completions, want %v with %d", o.settled(), o.completed, tt.wantSettled, tt.wantCompleted)
+			}
+			switch {
+			case tt.wantNack == nil && o.nacked != nil:
+				t.Errorf("nacked with %v", o.nacked.err)
+			case tt.wantNack != nil && (o.nacked == nil || !errors.Is(o.nacked.err, tt.wantNack)):
+				t.Errorf("nack = %v, want %v", o.nacked, tt.wantNack)
+			}
+		})
+	}
+}
+
+func TestAckOutsideHandler(t *testing.T) {
+	ctx := context.Background()
+	DeferAck(ctx)()
+	a := Acknowledge(ctx)
+	a.Ack()
+	a.Nack(errors.New("ignored"))
+	parts, done := Parts(ctx, 2)
+	for i, part := range parts {
+		if part != ctx {
+			t.Errorf("part %d is not ctx", i)
+		}
+		done(i)
+	}
+}
+
+func TestParts(t *testing.T) {
+	errFailed := errors.New("failed")
+	settle := map[string]func(ctx context.Context){
+		"return": func(context.Context) {},
+		"ack":    func(ctx context.Context) { Acknowledge(ctx).Ack() },
+		"nack":   func(ctx context.Context) { Acknowledge(ctx).Nack(errFailed) },
+		"defer":  func(ctx context.Context) { Acknowledge(ctx) },
+	}
+	tests := []struct {
+		name     string
+		parts    []string
+		wantNack bool
+		// wantSettled is whether the parent settled.
+		wantSettled bool
+	}{
+		{name: "all return", parts: []string{"return", "return"}, wantSettled: true},
+		{name: "all ack", parts: []string{"ack", "ack", "return"}, wantSettled: true},
+		{name: "one pending", parts: []string{"ack", "defer"}},
+		{name: "one nacks", parts: []string{"ack", "nack"}, wantNack: true, wantSettled: true},
+		{name: "nack waits for the others", parts: []string{"nack", "defer"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := &ackState{}
+			ctx := context.WithValue(context.Background(), ackKey{}, parent)
+			parts, done := Parts(ctx, len(tt.parts))
+			for i, part := range parts {
+				settle[tt.parts[i]](part)
+				done(i)
+			}
+			var o ackOutcome
+			parent.settle(o.complete, o.onNack)
+			if o.settled() != tt.wantSettled || (o.nacked != nil) != tt.wantNack {
+				t.Fatalf("settled = %v, nacked = %v, want %v, %v", o.settled(), o.nacked != nil, tt.wantSettled, tt.wantNack)
+			}
+			if o.nacked != nil && !errors.Is(o.nacked.err, errFailed) {
+				t.Errorf("nack = %v, want %v", o.nacked.err, errFailed)
+			}
+		})
+	}
+}
+
+func TestNackNext(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Second, Multiplier: 2}
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name      string
+		err       error
+		opts      []NackOption
+		attempts  int
+		wantDelay time.Duration
+		wantRetry bool
+	}{
+		{name: "backoff", err: errFailed, attempts: 1, wantDelay: time.Second, wantRetry: true},
+		{name: "second backoff", err: errFailed, attempts: 2, wantDelay: 2 * time.Second, wantRetry: true},
+		{name: "attempts exhausted", err: errFailed, attempts: 3},
+		{name: "retry after", err: errFailed, opts: []NackOption{RetryAfter(time.Minute)}, attempts: 1, wantDelay: time.Minute, wantRetry: true},
+		{name: "retry after exhausted", err: errFailed, opts: []NackOption{RetryAfter(time.Minute)}, attempts: 3},
+		{name: "dead letters", err: errFailed, opts: []NackOption{RouteToDeadLetters()}, attempts: 1},
+		{name: "permanent", err: Permanent(errFailed), attempts: 1},
+		{name: "permanent with retry after", err: Permanent(errFailed), opts: []NackOption{RetryAfter(time.Minute)}, attempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nk := &nack{err: tt.err}
+			for _, opt := range tt.opts {
+				opt(nk)
+			}
+			delay, retry := nk.next(policy, tt.attempts)
+			if delay != tt.wantDelay || retry != tt.wantRetry {
+				t.Errorf("next = %v, %v, want %v, %v", delay, retry, tt.wantDelay, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestNackRedelivery(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		// nacks is how often the handler nacks before acking.
+		nacks     int
+		retry     RetryPolicy
+		wantCalls int
+	}{
+		{name: "redelivered on the pool", workers: 2, nacks: 1, retry: RetryPolicy{MaxAttempts: 3}, wantCalls: 2},
+		{name: "attempts exhausted", workers: 2, nacks: 5, retry: RetryPolicy{MaxAttempts: 3}, wantCalls: 3},
+		{name: "retry after capped by attempts", workers: 1, nacks: 5, retry: RetryPolicy{MaxAttempts: 2}, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl, capture := newTestListener(t)
+			startTestPool(t, dl, tt.workers)
+
+			var (
+				mu    sync.Mutex
+				calls int
+			)
+			dl.HandleFunc("users", func(ctx context.Context, n *ChangeNotification) error {
+				mu.Lock()
+				calls++
+				nack := calls <= tt.nacks
+				mu.Unlock()
+				if nack {
+					Acknowledge(ctx).Nack(nil, RetryAfter(time.Millisecond))
+				}
+				return nil
+			}, WithRetry(tt.retry))
+
+			if err := dl.handleNotification(context.Background(), DefaultChannel, payload(OpInsert, 1, ""), 7); err != nil {
+				t.Fatal(err)
+			}
+			if !idle(dl, 5*time.Second) {
+				t.Fatal("notification still in flight")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if calls != tt.wantCalls {
+				t.Errorf("handler called %d times, want %d", calls, tt.wantCalls)
+			}
+			if got := capture.ids(); !slices.Equal(got, []int64{7}) {
+				t.Errorf("acked %v, want [7]", got)
+			}
+		})
+	}
+}
+
+func TestNackAfterStop(t *testing.T) {
+	dl, capture := newTestListener(t)
+	startTestPool(t, dl, 2)
+	dl.HandleFunc("users", func(ctx context.Context, n *ChangeNotification) error {
+		Acknowledge(ctx).Nack(nil, RetryAfter(20*time.Millisecond))
+		return nil
+	}, WithRetry(RetryPolicy{MaxAttempts: 3}))
+
+	if err := dl.handleNotification(context.Background(), DefaultChannel, payload(OpInsert, 1, ""), 7); err != nil {
+		t.Fatal(err)
+	}
+	dl.stopOnce.Do(func() { close(dl.stop) })
+	if !idle(dl, 5*time.Second) {
+		t.Fatal("notification still in flight after stop")
+	}
+	if got := capture.ids(); len(got) != 0 {
+		t.Errorf("acked %v, want the nacked notification left unacknowledged", got)
+	}
+}
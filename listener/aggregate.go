@@ -0,0 +1,207 @@
+package listener
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+// OpSummary is the operation of the summaries an Aggregator emits.
+const OpSummary = "SUMMARY"
+
+// DefaultAggregateWindow is the window of an Aggregator without one.
+const DefaultAggregateWindow = time.Minute
+
+// AggregateConfig configures an Aggregator.
+type AggregateConfig struct {
+	// Window is the length of the tumbling windows, aligned to multiples
+	// of it since the zero time.
+	Window time.Duration
+	// Sum lists numeric columns whose values are summed per window.
+	Sum []string
+	// OnError is called for summaries the handler failed to take. By
+	// default they are logged.
+	OnError ErrorHandler
+}
+
+// Summary is the data of a summary notification: the changes of one
+// table and operation in the window [WindowStart, WindowEnd).
+type Summary struct {
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Operation   string    `json:"operation"`
+	Count       int64     `json:"count"`
+	// Rate is Count per second.
+	Rate float64            `json:"rate"`
+	Sums map[string]float64 `json:"sums,omitempty"`
+}
+
+// Aggregator counts the notifications per table and operation over
+// tumbling windows and passes next one notification per table and
+// operation when a window ends, with Operation OpSummary and a Summary as
+// Data. Windows follow the time notifications arrive, not their
+// Timestamp; windows without changes emit nothing.
+type Aggregator struct {
+	next NotificationHandler
+	cfg  AggregateConfig
+
+	mu     sync.Mutex
+	start  time.Time
+	groups map[aggregateKey]*aggregateGroup
+}
+
+type aggregateKey struct {
+	schema, table, operation string
+}
+
+type aggregateGroup struct {
+	channel string
+	count   int64
+	sums    map[string]float64
+}
+
+func NewAggregator(next NotificationHandler, cfg AggregateConfig) *Aggregator {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultAggregateWindow
+	}
+	return &Aggregator{next: next, cfg: cfg}
+}
+
+func (a *Aggregator) HandleNotification(ctx context.Context, n *ChangeNotification) error {
+	now := time.Now()
+	start := now.Truncate(a.cfg.Window)
+
+	a.mu.Lock()
+	var ended map[aggregateKey]*aggregateGroup
+	endedStart := a.start
+	if a.groups != nil && !a.start.Equal(start) {
+		ended = a.take()
+	}
+	if a.groups == nil {
+		a.start = start
+		a.groups = make(map[aggregateKey]*aggregateGroup)
+		time.AfterFunc(start.Add(a.cfg.Window).Sub(now), func() { a.end(start) })
+	}
+	schema := n.Schema
+	if schema == "" {
+		schema = DefaultSchema
+	}
+	key := aggregateKey{schema, n.Table, n.Operation}
+	g, ok := a.groups[key]
+	if !ok {
+		g = &aggregateGroup{channel: n.Channel}
+		a.groups[key] = g
+	}
+	g.count++
+	if len(a.cfg.Sum) > 0 {
+		a.sum(g, n)
+	}
+	a.mu.Unlock()
+
+	if ended != nil {
+		// Not ctx: a handler deferring the ack of a summary would defer
+		// the ack of n.
+		a.emit(context.Background(), endedStart, ended)
+	}
+	return nil
+}
+
+// sum adds the summed columns of n's row to g; values that are not
+// numbers are skipped.
+func (a *Aggregator) sum(g *aggregateGroup, n *ChangeNotification) {
+	row := n.Data
+	if isNull(row) {
+		row = n.New
+	}
+	var cols map[string]json.RawMessage
+	if isNull(row) || json.Unmarshal(row, &cols) != nil {
+		return
+	}
+	for _, col := range a.cfg.Sum {
+		var v float64
+		if raw, ok := cols[col]; ok && json.Unmarshal(raw, &v) == nil {
+			if g.sums == nil {
+				g.sums = make(map[string]float64, len(a.cfg.Sum))
+			}
+			g.sums[col] += v
+		}
+	}
+}
+
+// take returns the groups of the current window; called with a.mu held.
+func (a *Aggregator) take() map[aggregateKey]*aggregateGroup {
+	groups := a.groups
+	a.groups = nil
+	return groups
+}
+
+// end emits the window starting at start unless a notification of a
+// later window already did.
+func (a *Aggregator) end(start time.Time) {
+	a.mu.Lock()
+	if a.groups == nil || !a.start.Equal(start) {
+		a.mu.Unlock()
+		return
+	}
+	groups := a.take()
+	a.mu.Unlock()
+	a.emit(context.Background(), start, groups)
+}
+
+func (a *Aggregator) emit(ctx context.Context, start time.Time, groups map[aggregateKey]*aggregateGroup) {
+	keys := make([]aggregateKey, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(x, y aggregateKey) int {
+		return cmp.Or(cmp.Compare(x.schema, y.schema), cmp.Compare(x.table, y.table), cmp.Compare(x.operation, y.operation))
+	})
+
+	end := start.Add(a.cfg.Window)
+	for _, k := range keys {
+		g := groups[k]
+		data, err := json.Marshal(Summary{
+			WindowStart: start,
+			WindowEnd:   end,
+			Operation:   k.operation,
+			Count:       g.count,
+			Rate:        float64(g.count) / a.cfg.Window.Seconds(),
+			Sums:        g.sums,
+		})
+		if err != nil {
+			// Sums overflowing to infinity.
+			data, _ = json.Marshal(Summary{WindowStart: start, WindowEnd: end, Operation: k.operation, Count: g.count})
+		}
+		n := &ChangeNotification{
+			Channel:   g.channel,
+			Schema:    k.schema,
+			Table:     k.table,
+			Operation: OpSummary,
+			Data:      data,
+			Timestamp: end,
+		}
+		if err := a.next.HandleNotification(ctx, n); err != nil {
+			if a.cfg.OnError != nil {
+				a.cfg.OnError(ctx, &Failure{Notification: n, Err: err, Attempts: 1, FirstAttempt: end, LastAttempt: time.Now()})
+				continue
+			}
+			defaultLogger{}.Error("failed to handle summary",
+				"channel", n.Channel, "table", n.Table, "window_start", start, "error", err)
+		}
+	}
+}
+
+// Close emits the current window early.
+func (a *Aggregator) Close() error {
+	a.mu.Lock()
+	start := a.start
+	groups := a.take()
+	a.mu.Unlock()
+	if groups != nil {
+		a.emit(context.Background(), start, groups)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OpAlert is the operation of the alerts of WithRateAlerts.
+const OpAlert = "ALERT"
+
+const (
+	DefaultRateInterval = time.Minute
+	// DefaultSpikeFactor is how many times its baseline a table's rate
+	// must reach to alert.
+	DefaultSpikeFactor = 5
+	// DefaultSpikeMinChanges is how many changes an interval needs at
+	// least to alert a spike, so quiet tables do not alert on a handful.
+	DefaultSpikeMinChanges = 10
+)
+
+// The kinds of RateAlert.
+const (
+	AlertSpike     = "spike"
+	AlertSilence   = "silence"
+	AlertRecovered = "recovered"
+)
+
+// rateSmoothing weighs the last interval in a table's baseline, and
+// rateWarmup is how many intervals a baseline needs before it is alerted
+// on.
+const (
+	rateSmoothing = 0.2
+	rateWarmup    = 3
+)
+
+// RateAlertConfig configures WithRateAlerts.
+type RateAlertConfig struct {
+	// Handler receives the alerts.
+	Handler NotificationHandler
+	// Interval is how often the rates are sampled.
+	Interval time.Duration
+	// Spike alerts when a table's rate reaches Spike times its baseline,
+	// with at least MinChanges changes in the interval.
+	Spike      float64
+	MinChanges int64
+	// Silence alerts when a table received no changes for that long.
+	// Zero disables silence alerts.
+	Silence time.Duration
+}
+
+// RateAlert is the data of an alert. Rates are changes per second in the
+// last interval; Baseline is the table's smoothed rate before it.
+type RateAlert struct {
+	Kind       string    `json:"kind"`
+	Rate       float64   `json:"rate"`
+	Baseline   float64   `json:"baseline"`
+	LastChange time.Time `json:"last_change"`
+}
+
+// WithRateAlerts tracks a baseline change rate per table and passes
+// cfg.Handler a notification with Operation OpAlert and a RateAlert as
+// Data when a table's rate spikes or it stays silent for cfg.Silence,
+// catching broken triggers and runaway jobs, and another of kind
+// AlertRecovered once the rate is back to normal. Tables are tracked from
+// their first change.
+func WithRateAlerts(cfg RateAlertConfig) Option {
+	return func(dl *DataListener) {
+		dl.rates.set(&cfg)
+	}
+}
+
+// SetRateAlerts replaces the config of WithRateAlerts, e.g. on a
+// configuration reload, keeping the baselines; nil disables the alerts.
+func (dl *DataListener) SetRateAlerts(cfg *RateAlertConfig) {
+	dl.rates.set(cfg)
+}
+
+type rateMonitor struct {
+	enabled atomic.Bool
+
+	mu     sync.Mutex
+	cfg    RateAlertConfig
+	tables map[rateKey]*tableRate
+}
+
+type rateKey struct {
+	channel, schema, table string
+}
+
+type tableRate struct {
+	count      int64
+	baseline   float64
+	samples    int
+	lastChange time.Time
+	spiking    bool
+	silent     bool
+}
+
+func newRateMonitor() *rateMonitor {
+	return &rateMonitor{tables: make(map[rateKey]*tableRate)}
+}
+
+func (m *rateMonitor) set(cfg *RateAlertConfig) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cfg == nil || cfg.Handler == nil {
+		m.cfg = RateAlertConfig{}
+		m.enabled.Store(false)
+		return
+	}
+	m.cfg = *cfg
+	if m.cfg.Interval <= 0 {
+		m.cfg.Interval = DefaultRateInterval
+	}
+	if m.cfg.Spike <= 0 {
+		m.cfg.Spike = DefaultSpikeFactor
+	}
+	if m.cfg.MinChanges <= 0 {
+		m.cfg.MinChanges = DefaultSpikeMinChanges
+	}
+	m.enabled.Store(true)
+}
+
+// observe counts n in the current interval of its table.
+func (m *rateMonitor) observe(n *ChangeNotification) {
+	if !m.enabled.Load() {
+		return
+	}
+	schema := n.Schema
+	if schema == "" {
+		schema = DefaultSchema
+	}
+	key := rateKey{n.Channel, schema, n.Table}
+	m.mu.Lock()
+	t, ok := m.tables[key]
+	if !ok {
+		t = &tableRate{}
+		m.tables[key] = t
+	}
+	t.count++
+	t.lastChange = time.Now()
+	m.mu.Unlock()
+}
+
+// interval returns the sampling interval, DefaultRateInterval while the
+// alerts are disabled.
+func (m *rateMonitor) interval() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cfg.Interval <= 0 {
+		return DefaultRateInterval
+	}
+	return m.cfg.Interval
+}
+
+// sample closes the interval of elapsed length, updating the baselines,
+// and returns the alerts to send.
+func (m *rateMonitor) sample(now time.Time, elapsed time.Duration) (NotificationHandler, []*ChangeNotification) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.enabled.Load() {
+		return nil, nil
+	}
+	cfg := m.cfg
+	var alerts []*ChangeNotification
+	alert := func(key rateKey, t *tableRate, kind string, rate float64) {
+		data, _ := json.Marshal(RateAlert{Kind: kind, Rate: rate, Baseline: t.baseline, LastChange: t.lastChange})
+		alerts = append(alerts, &ChangeNotification{
+			Channel:   key.channel,
+			Schema:    key.schema,
+			Table:     key.table,
+			Operation: OpAlert,
+			Data:      data,
+			Timestamp: now,
+		})
+	}
+
+	for key, t := range m.tables {
+		rate := float64(t.count) / elapsed.Seconds()
+		switch {
+		case t.samples >= rateWarmup && t.count >= cfg.MinChanges && rate >= cfg.Spike*t.baseline:
+			if !t.spiking {
+				alert(key, t, AlertSpike, rate)
+				t.spiking = true
+			}
+		case t.spiking:
+			alert(key, t, AlertRecovered, rate)
+			t.spiking = false
+		}
+		switch {
+		case cfg.Silence > 0 && t.count == 0 && now.Sub(t.lastChange) >= cfg.Silence:
+			if !t.silent {
+				alert(key, t, AlertSilence, rate)
+				t.silent = true
+			}
+		case t.silent && t.count > 0:
+			alert(key, t, AlertRecovered, rate)
+			t.silent = false
+		}
+
+		// A spike stays out of the baseline, so it lasts until the rate
+		// is back down.
+		switch {
+		case t.samples == 0:
+			t.baseline = rate
+		case !t.spiking:
+			t.baseline += rateSmoothing * (rate - t.baseline)
+		}
+		t.samples++
+		t.count = 0
+	}
+	return cfg.Handler, alerts
+}
+
+// watchRates samples the rates until the returned func is called.
+func (dl *DataListener) watchRates() (stop func()) {
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		last := time.Now()
+		for {
+			timer := time.NewTimer(dl.rates.interval())
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case now := <-timer.C:
+				h, alerts := dl.rates.sample(now, now.Sub(last))
+				last = now
+				for _, n := range alerts {
+					if err := h.HandleNotification(context.Background(), n); err != nil {
+						dl.logger.Error("failed to send rate alert",
+							"channel", n.Channel, "table", n.Table, "error", err)
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
@@ -0,0 +1,242 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBatchSize   = 100
+	DefaultBatchLinger = 100 * time.Millisecond
+)
+
+// ErrBatchingClosed is returned for notifications handed to a closed
+// Batching.
+var ErrBatchingClosed = errors.New("batching handler closed")
+
+// BatchHandler is implemented by handlers that process notifications in
+// bulk, e.g. sinks with a bulk write API. Wrap one with NewBatching to
+// register it.
+type BatchHandler interface {
+	HandleBatch(ctx context.Context, batch []*ChangeNotification) error
+}
+
+type BatchHandlerFunc func(ctx context.Context, batch []*ChangeNotification) error
+
+func (f BatchHandlerFunc) HandleBatch(ctx context.Context, batch []*ChangeNotification) error {
+	return f(ctx, batch)
+}
+
+// BatchErrors reports which notifications of a batch failed: HandleBatch
+// returns one error per notification, nil for those it delivered. Other
+// errors fail the whole batch.
+type BatchErrors []error
+
+func (e BatchErrors) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d of %d notifications failed: %v", failed, len(e), first)
+}
+
+// BatchConfig bounds the batches of Batching: a batch is handed over when
+// it holds Size notifications or its first one waited Linger.
+type BatchConfig struct {
+	Size   int
+	Linger time.Duration
+	// Retry retries the failed notifications of a batch, together as the
+	// next batch. By default they are not retried.
+	Retry RetryPolicy
+	// OnError is called for each notification that failed for good, e.g.
+	// DeadLetterErrors to keep them. By default they are dropped.
+	OnError ErrorHandler
+}
+
+// Batching adapts a BatchHandler to a NotificationHandler. It returns
+// from HandleNotification once the notification is queued, deferring its
+// acknowledgement (see DeferAck) until its batch was handled, so batches
+// fill up even when the listener processes notifications serially, and
+// checkpoints only move past delivered notifications. A full batch is
+// handled by the call adding the last notification, which holds back the
+// next notifications meanwhile. Batches are handled one at a time, in
+// order.
+//
+// The listener's retries and dead letter queue do not apply because the
+// calls already succeeded; BatchConfig has its own.
+type Batching struct {
+	h   BatchHandler
+	cfg BatchConfig
+
+	mu      sync.Mutex
+	pending []batchEntry
+	timer   *time.Timer
+	closed  bool
+
+	// flushing serializes taking and handling the batches; it is
+	// acquired before mu.
+	flushing sync.Mutex
+}
+
+type batchEntry struct {
+	n     *ChangeNotification
+	ack   func()
+	first time.Time
+}
+
+func NewBatching(h BatchHandler, cfg BatchConfig) *Batching {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultBatchSize
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = DefaultBatchLinger
+	}
+	if cfg.Retry.MaxAttempts < 1 {
+		cfg.Retry = NoRetry
+	}
+	return &Batching{h: h, cfg: cfg}
+}
+
+func (b *Batching) HandleNotification(ctx context.Context, n *ChangeNotification) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBatchingClosed
+	}
+	b.pending = append(b.pending, batchEntry{n: n, ack: DeferAck(ctx), first: time.Now()})
+	if len(b.pending) == 1 {
+		b.timer = time.AfterFunc(b.cfg.Linger, b.lingered)
+	}
+	full := len(b.pending) >= b.cfg.Size
+	b.mu.Unlock()
+
+	if full {
+		b.flushPending(context.WithoutCancel(ctx), false)
+	}
+	return nil
+}
+
+// flushPending hands the pending notifications to the handler in batches
+// of at most Size, leaving fewer than Size to linger unless all is set.
+// Batches are taken and handled under flushing, so they are handled in
+// the order they filled up.
+func (b *Batching) flushPending(ctx context.Context, all bool) {
+	b.flushing.Lock()
+	defer b.flushing.Unlock()
+	for {
+		b.mu.Lock()
+		if len(b.pending) == 0 || !all && len(b.pending) < b.cfg.Size {
+			b.mu.Unlock()
+			return
+		}
+		batch := b.take()
+		b.mu.Unlock()
+		b.flush(ctx, batch)
+	}
+}
+
+// take returns the oldest pending notifications, at most Size, and lets
+// the rest linger from the first of them; called with b.mu held.
+func (b *Batching) take() []batchEntry {
+	n := min(len(b.pending), b.cfg.Size)
+	batch := b.pending[:n:n]
+	b.pending = b.pending[n:]
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) > 0 {
+		b.timer = time.AfterFunc(b.cfg.Linger-time.Since(b.pending[0].first), b.lingered)
+	}
+	return batch
+}
+
+func (b *Batching) lingered() {
+	b.flushPending(context.Background(), true)
+}
+
+// flush hands batch to the handler, retrying the failed notifications,
+// and acknowledges each notification once it was delivered or given up;
+// called with b.flushing held.
+func (b *Batching) flush(ctx context.Context, batch []batchEntry) {
+	for attempt := 1; len(batch) > 0; attempt++ {
+		ns := make([]*ChangeNotification, len(batch))
+		for i, e := range batch {
+			ns[i] = e.n
+		}
+		err := b.h.HandleBatch(ctx, ns)
+
+		var retry []batchEntry
+		for i, e := range batch {
+			err := entryError(err, i, len(batch))
+			if err == nil {
+				e.ack()
+				continue
+			}
+			if attempt < b.cfg.Retry.MaxAttempts && !isPermanent(err) {
+				retry = append(retry, e)
+				continue
+			}
+			if b.cfg.OnError != nil {
+				b.cfg.OnError(ctx, &Failure{
+					Notification: e.n,
+					Err:          err,
+					Attempts:     attempt,
+					FirstAttempt: e.first,
+					LastAttempt:  time.Now(),
+				})
+			}
+			e.ack()
+		}
+		batch = retry
+		if len(batch) > 0 {
+			time.Sleep(b.cfg.Retry.Backoff(attempt))
+		}
+	}
+}
+
+// entryError returns the error of the i-th of n notifications.
+func entryError(err error, i, n int) error {
+	if err == nil {
+		return nil
+	}
+	var errs BatchErrors
+	if errors.As(err, &errs) && len(errs) == n {
+		return errs[i]
+	}
+	return err
+}
+
+// Close handles the pending batch. Notifications handed over later fail
+// with ErrBatchingClosed.
+func (b *Batching) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.flushPending(context.Background(), true)
+	return nil
+}
+
+// DeadLetterErrors returns an ErrorHandler putting the failures in store,
+// for handlers reporting failures themselves such as Batching.
+func DeadLetterErrors(store DeadLetterStore) ErrorHandler {
+	return func(ctx context.Context, f *Failure) {
+		d, err := newDeadLetter(f)
+		if err == nil {
+			err = store.Put(ctx, d)
+		}
+		if err != nil {
+			defaultLogger{}.Error("failed to dead-letter notification",
+				"channel", f.Notification.Channel, "table", f.Notification.Table, "error", err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// batches records the batches a BatchHandler received, by ID.
+type batches struct {
+	mu  sync.Mutex
+	got [][]int64
+}
+
+func (b *batches) HandleBatch(_ context.Context, batch []*ChangeNotification) error {
+	ids := make([]int64, len(batch))
+	for i, n := range batch {
+		ids[i] = n.ID
+	}
+	// Give a lingering batch the chance to overtake this one.
+	time.Sleep(time.Millisecond)
+	b.mu.Lock()
+	b.got = append(b.got, ids)
+	b.mu.Unlock()
+	return nil
+}
+
+func TestBatchingOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     BatchConfig
+		senders int
+		n       int
+	}{
+		{name: "full batches", cfg: BatchConfig{Size: 3, Linger: time.Hour}, senders: 1, n: 9},
+		{name: "lingering batches", cfg: BatchConfig{Size: 100, Linger: time.Millisecond}, senders: 1, n: 50},
+		{name: "linger racing size", cfg: BatchConfig{Size: 4, Linger: 100 * time.Microsecond}, senders: 1, n: 200},
+		{name: "concurrent senders", cfg: BatchConfig{Size: 4, Linger: 100 * time.Microsecond}, senders: 4, n: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &batches{}
+			b := NewBatching(h, tt.cfg)
+
+			// Each sender hands over its own IDs in order, so the batches
+			// must keep every sender's IDs ascending.
+			var wg sync.WaitGroup
+			for s := range tt.senders {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := s; i < tt.n; i += tt.senders {
+						if err := b.HandleNotification(context.Background(), &ChangeNotification{ID: int64(i)}); err != nil {
+							t.Error(err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			b.Close()
+
+			last := make(map[int64]int64)
+			total := 0
+			for _, batch := range h.got {
+				if len(batch) > tt.cfg.Size {
+					t.Errorf("batch of %d, want at most %d", len(batch), tt.cfg.Size)
+				}
+				for _, id := range batch {
+					s := id % int64(tt.senders)
+					if prev, ok := last[s]; ok && id < prev {
+						t.Fatalf("got %d after %d in batches %v", id, prev, h.got)
+					}
+					last[s] = id
+					total++
+				}
+			}
+			if total != tt.n {
+				t.Errorf("got %d notifications, want %d", total, tt.n)
+			}
+		})
+	}
+}
+
+func TestBatchingClosed(t *testing.T) {
+	h := &batches{}
+	b := NewBatching(h, BatchConfig{Size: 10, Linger: time.Hour})
+	ctx := context.Background()
+	b.HandleNotification(ctx, &ChangeNotification{ID: 1})
+	b.Close()
+	if len(h.got) != 1 || len(h.got[0]) != 1 {
+		t.Errorf("got batches %v on close, want [[1]]", h.got)
+	}
+	if err := b.HandleNotification(ctx, &ChangeNotification{ID: 2}); !errors.Is(err, ErrBatchingClosed) {
+		t.Errorf("got %v, want ErrBatchingClosed", err)
+	}
+}
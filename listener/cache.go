@@ -0,0 +1,258 @@
+package listener
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DefaultCacheInterval is how often a Cache run by Run reloads its table.
+const DefaultCacheInterval = 5 * time.Minute
+
+// CacheConfig configures NewCache.
+type CacheConfig struct {
+	// Interval is how often Run reloads the table to correct what changes
+	// missed, e.g. while the listener was disconnected without an outbox.
+	Interval time.Duration
+	// Logger receives reload failures and corrections, the default logger
+	// when nil.
+	Logger Logger
+}
+
+// Cache holds the current rows of a small table, such as a configuration
+// table, decoded into T and keyed by the key function. Register it as the
+// table's handler to keep it up to date, and Reload it before use. Get and
+// List are safe for concurrent use.
+type Cache[K cmp.Ordered, T any] struct {
+	db    *sql.DB
+	table string
+	key   func(T) K
+	cfg   CacheConfig
+
+	// reloading serializes reloads.
+	reloading sync.Mutex
+
+	mu   sync.RWMutex
+	rows map[K]T
+	// touched holds the keys changed during a reload, whose rows are kept
+	// over the reloaded ones; nil when not reloading.
+	touched map[K]bool
+	loaded  time.Time
+}
+
+// NewCache creates a cache of table ("schema.table" or a table in the
+// default schema) read from db.
+func NewCache[K cmp.Ordered, T any](db *sql.DB, table string, key func(T) K, cfg CacheConfig) *Cache[K, T] {
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultCacheInterval
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = defaultLogger{}
+	}
+	return &Cache[K, T]{db: db, table: table, key: key, cfg: cfg, rows: make(map[K]T)}
+}
+
+// Get returns the row with key k.
+func (c *Cache[K, T]) Get(k K) (T, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	row, ok := c.rows[k]
+	return row, ok
+}
+
+// List returns the rows ordered by key.
+func (c *Cache[K, T]) List() []T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	rows := make([]T, 0, len(c.rows))
+	for _, k := range slices.Sorted(maps.Keys(c.rows)) {
+		rows = append(rows, c.rows[k])
+	}
+	return rows
+}
+
+func (c *Cache[K, T]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.rows)
+}
+
+// Loaded returns when the table was last reloaded, zero before the first
+// reload.
+func (c *Cache[K, T]) Loaded() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.loaded
+}
+
+// HandleNotification applies an inserted, updated or deleted row. An
+// update changing the key moves the row.
+func (c *Cache[K, T]) HandleNotification(_ context.Context, n *ChangeNotification) error {
+	switch n.Operation {
+	case OpInsert, OpUpdate:
+		row, err := c.decode(n, n.New)
+		if err != nil {
+			return err
+		}
+		k := c.key(row)
+		var oldKey *K
+		if n.Operation == OpUpdate && n.Old != nil {
+			if old, err := c.decode(n, n.Old); err == nil && c.key(old) != k {
+				ok := c.key(old)
+				oldKey = &ok
+			}
+		}
+		c.mu.Lock()
+		if oldKey != nil {
+			delete(c.rows, *oldKey)
+			c.touch(*oldKey)
+		}
+		c.rows[k] = row
+		c.touch(k)
+		c.mu.Unlock()
+	case OpDelete:
+		row, err := c.decode(n, n.Old)
+		if err != nil {
+			return err
+		}
+		k := c.key(row)
+		c.mu.Lock()
+		delete(c.rows, k)
+		c.touch(k)
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// touch records a change during a reload; c.mu is held.
+func (c *Cache[K, T]) touch(k K) {
+	if c.touched != nil {
+		c.touched[k] = true
+	}
+}
+
+func (c *Cache[K, T]) decode(n *ChangeNotification, data json.RawMessage) (T, error) {
+	var row T
+	if data == nil {
+		data = n.Data
+	}
+	if err := json.Unmarshal(data, &row); err != nil {
+		return row, &DecodeError{
+			Table:     n.Table,
+			Operation: n.Operation,
+			Type:      reflect.TypeOf(&row).Elem().String(),
+			Data:      data,
+			Err:       err,
+		}
+	}
+	return row, nil
+}
+
+// Reload reads the whole table and replaces the cached rows, keeping the
+// rows of changes handled meanwhile. It returns how many cached rows the
+// reload added, changed or removed, zero for the first one.
+func (c *Cache[K, T]) Reload(ctx context.Context) (int, error) {
+	c.reloading.Lock()
+	defer c.reloading.Unlock()
+	c.mu.Lock()
+	c.touched = make(map[K]bool)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.touched = nil
+		c.mu.Unlock()
+	}()
+
+	loaded, err := c.read(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.touched {
+		if row, ok := c.rows[k]; ok {
+			loaded[k] = row
+		} else {
+			delete(loaded, k)
+		}
+	}
+	corrected := 0
+	if c.loaded.IsZero() {
+		c.rows, c.loaded = loaded, time.Now()
+		return 0, nil
+	}
+	for k := range c.rows {
+		if _, ok := loaded[k]; !ok {
+			corrected++
+		}
+	}
+	for k, row := range loaded {
+		if old, ok := c.rows[k]; !ok || !sameRow(old, row) {
+			corrected++
+		}
+	}
+	c.rows, c.loaded = loaded, time.Now()
+	return corrected, nil
+}
+
+func (c *Cache[K, T]) read(ctx context.Context) (map[K]T, error) {
+	rows, err := c.db.QueryContext(ctx, fmt.Sprintf("SELECT row_to_json(t) FROM %s AS t", quoteName(c.table)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.table, err)
+	}
+	defer rows.Close()
+	loaded := make(map[K]T)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", c.table, err)
+		}
+		var row T
+		if err := json.Unmarshal(data, &row); err != nil {
+			return nil, fmt.Errorf("failed to decode row of %s: %w", c.table, err)
+		}
+		loaded[c.key(row)] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", c.table, err)
+	}
+	return loaded, nil
+}
+
+// Run reloads the table every interval until ctx is canceled, logging
+// failures and corrections, which point at missed notifications.
+func (c *Cache[K, T]) Run(ctx context.Context) {
+	t := time.NewTicker(c.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			corrected, err := c.Reload(ctx)
+			switch {
+			case err != nil:
+				c.cfg.Logger.Error("failed to reload cache", "table", c.table, "error", err)
+			case corrected > 0:
+				c.cfg.Logger.Warn("reload corrected cached rows", "table", c.table, "rows", corrected)
+			}
+		}
+	}
+}
+
+// sameRow compares rows by their encoding, which also holds for row types
+// that are not comparable.
+func sameRow[T any](a, b T) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(x, y)
+}
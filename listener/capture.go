@@ -0,0 +1,302 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errSourceClosed = errors.New("capture source closed")
+
+// Event is a change captured by a CaptureSource: either a notification
+// payload as sent by the installed triggers, possibly one chunk of a
+// larger payload, or a change the source decoded itself.
+type Event struct {
+	Channel string
+	Payload string
+	// Notification is the decoded change, used instead of Payload.
+	Notification *ChangeNotification
+	// ID is the event id, when the payload does not carry it.
+	ID int64
+	// Reconnected marks an event without a change, sent after the source
+	// re-established its connection: events sent meanwhile may be missing
+	// and are recovered from the outbox.
+	Reconnected bool
+}
+
+// CaptureSource captures the changes a DataListener dispatches, so the
+// capture strategy can be swapped without changing handler code; see
+// WithCapture. Start begins capturing on channels and returns once the
+// source is set up, after which Notifications delivers events until Stop.
+// Stop is called once the handlers have drained.
+//
+// A source may also implement any of:
+//
+//	Listen(channel string) error   // channels added while running
+//	Unlisten(channel string) error // channels removed while running
+//	Ping() error                   // checked every ping interval
+//	Reconnect() error              // serves DataListener.Reconnect
+//	Connected() bool               // reported by Status
+//	Ack(id int64)                  // called once the event id is processed
+type CaptureSource interface {
+	Start(ctx context.Context, channels []string) error
+	Notifications() <-chan Event
+	Stop() error
+}
+
+type channelSource interface {
+	Listen(channel string) error
+	Unlisten(channel string) error
+}
+
+// attacher is implemented by the built-in sources to share the listener's
+// logger, metrics and settings.
+type attacher interface {
+	attach(dl *DataListener)
+}
+
+// WithCapture captures changes from src instead of a LISTEN connection
+// opened from the connection string passed to New.
+func WithCapture(src CaptureSource) Option {
+	return func(dl *DataListener) {
+		dl.capture = src
+	}
+}
+
+// ListenConfig configures a ListenSource.
+type ListenConfig struct {
+	ConnString string
+	Driver     Driver
+	// TLS replaces the ssl settings of ConnString when set.
+	TLS *TLSConfig
+	// Password, when set, is called for the password of every connection;
+	// it requires DriverPGX.
+	Password PasswordFunc
+	// MinReconnect and MaxReconnect bound the reconnect backoff,
+	// DefaultMinReconnectInterval and DefaultMaxReconnectInterval when 0.
+	MinReconnect time.Duration
+	MaxReconnect time.Duration
+}
+
+func (c ListenConfig) withDefaults() ListenConfig {
+	if c.MinReconnect <= 0 {
+		c.MinReconnect = DefaultMinReconnectInterval
+	}
+	if c.MaxReconnect <= 0 {
+		c.MaxReconnect = DefaultMaxReconnectInterval
+	}
+	return c
+}
+
+// ListenSource captures the notifications sent by the installed triggers
+// on a dedicated LISTEN connection, using pgx or lib/pq. It is the
+// listener's default source.
+type ListenSource struct {
+	cfg       ListenConfig
+	opts      connOptions
+	logger    Logger
+	hooks     *connHooks
+	events    chan Event
+	connected atomic.Bool
+
+	mu sync.Mutex
+	// running is set from Start until Stop; conn is nil while a
+	// reconnect failed.
+	running  bool
+	conn     notifyConn
+	quit     chan struct{}
+	channels map[string]struct{}
+}
+
+// NewListenSource validates cfg.ConnString for the driver; connecting
+// happens in Start.
+func NewListenSource(cfg ListenConfig) (*ListenSource, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.Driver.validate(cfg.ConnString); err != nil {
+		return nil, err
+	}
+	if cfg.Password != nil && cfg.Driver != DriverPGX {
+		return nil, fmt.Errorf("a password function is not supported by the %s driver", cfg.Driver)
+	}
+	opts := connOptions{password: cfg.Password}
+	if cfg.TLS != nil {
+		var err error
+		if opts.tls, err = cfg.TLS.build(); err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+	return &ListenSource{
+		cfg:      cfg,
+		opts:     opts,
+		logger:   defaultLogger{},
+		events:   make(chan Event),
+		channels: make(map[string]struct{}),
+	}, nil
+}
+
+func (s *ListenSource) attach(dl *DataListener) {
+	s.logger = dl.logger
+	s.hooks = dl.hooks
+}
+
+func (s *ListenSource) Start(_ context.Context, channels []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, channel := range channels {
+		s.channels[channel] = struct{}{}
+	}
+	if err := s.open(false); err != nil {
+		return err
+	}
+	s.running = true
+	return nil
+}
+
+// open connects and listens on every channel. It is called with s.mu held.
+func (s *ListenSource) open(reconnected bool) error {
+	conn, err := s.cfg.Driver.open(s.cfg.ConnString, s.opts, s.cfg.MinReconnect, s.cfg.MaxReconnect, s.event)
+	if err != nil {
+		return err
+	}
+	for channel := range s.channels {
+		if err := conn.Listen(channel); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed to listen on channel %s: %w", channel, err)
+		}
+		s.logger.Info("listening", "channel", channel)
+	}
+	s.conn = conn
+	s.quit = make(chan struct{})
+	go s.forward(conn, s.quit, reconnected)
+	return nil
+}
+
+func (s *ListenSource) event(ev connEvent, err error) {
+	switch ev {
+	case eventConnected, eventReconnected:
+		s.connected.Store(true)
+		s.hooks.connect()
+	case eventDisconnected, eventConnectionAttemptFailed:
+		s.connected.Store(false)
+		s.hooks.disconnect(err)
+	}
+	if err != nil {
+		s.logger.Warn("listener connection event", "event", ev.String(), "driver", s.cfg.Driver.String(), "error", err)
+	} else {
+		s.logger.Info("listener connection event", "event", ev.String(), "driver", s.cfg.Driver.String())
+	}
+}
+
+// forward turns the notifications of conn into events until quit is
+// closed.
+func (s *ListenSource) forward(conn notifyConn, quit <-chan struct{}, reconnected bool) {
+	if reconnected && !s.send(Event{Reconnected: true}, quit) {
+		return
+	}
+	for {
+		select {
+		case n := <-conn.Notifications():
+			ev := Event{Reconnected: true}
+			if n != nil {
+				ev = Event{Channel: n.channel, Payload: n.payload}
+			}
+			if !s.send(ev, quit) {
+				return
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (s *ListenSource) send(ev Event, quit <-chan struct{}) bool {
+	select {
+	case s.events <- ev:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+func (s *ListenSource) Notifications() <-chan Event {
+	return s.events
+}
+
+func (s *ListenSource) Listen(channel string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if err := s.conn.Listen(channel); err != nil {
+			return err
+		}
+	}
+	s.channels[channel] = struct{}{}
+	return nil
+}
+
+func (s *ListenSource) Unlisten(channel string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if err := s.conn.Unlisten(channel); err != nil {
+			return err
+		}
+	}
+	delete(s.channels, channel)
+	return nil
+}
+
+func (s *ListenSource) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return errConnClosed
+	}
+	return s.conn.Ping()
+}
+
+// Reconnect replaces the LISTEN connection with a new one. Notifications
+// sent meanwhile are only recovered from the outbox. After it failed, the
+// source has no connection until a later call succeeds.
+func (s *ListenSource) Reconnect() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return nil
+	}
+	if s.conn != nil {
+		s.hooks.disconnect(nil)
+		s.close()
+	}
+	return s.open(true)
+}
+
+func (s *ListenSource) Connected() bool {
+	return s.connected.Load()
+}
+
+func (s *ListenSource) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = false
+	if s.conn == nil {
+		return nil
+	}
+	if err := s.conn.UnlistenAll(); err != nil {
+		s.logger.Warn("failed to unlisten", "error", err)
+	}
+	s.close()
+	return nil
+}
+
+// close closes the connection. It is called with s.mu held.
+func (s *ListenSource) close() {
+	s.hooks.stop()
+	close(s.quit)
+	s.conn.Close()
+	s.conn = nil
+	s.connected.Store(false)
+}
@@ -0,0 +1,150 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CheckpointStore persists the id of the last processed event per consumer,
+// so a restarted listener resumes after it. Saved ids only ever increase.
+type CheckpointStore interface {
+	// Load returns the saved id, or 0 when there is none.
+	Load(ctx context.Context, consumer string) (int64, error)
+	Save(ctx context.Context, consumer string, id int64) error
+}
+
+// TableCheckpoints keeps checkpoints in a Postgres table, one row per
+// consumer. It is the outbox's default store.
+type TableCheckpoints struct {
+	db    *sql.DB
+	table string
+}
+
+func NewTableCheckpoints(db *sql.DB, table string) *TableCheckpoints {
+	if table == "" {
+		table = DefaultOffsetTable
+	}
+	return &TableCheckpoints{db: db, table: table}
+}
+
+// Init creates the table if it does not exist.
+func (s *TableCheckpoints) Init(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    consumer TEXT PRIMARY KEY,
+    last_id BIGINT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, quoteName(s.table)))
+	if err != nil {
+		return fmt.Errorf("failed to create offset table %s: %w", s.table, err)
+	}
+	return nil
+}
+
+func (s *TableCheckpoints) Load(ctx context.Context, consumer string) (int64, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COALESCE(MAX(last_id), 0) FROM %s WHERE consumer = $1", quoteName(s.table)),
+		consumer).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load offset for %s: %w", consumer, err)
+	}
+	return id, nil
+}
+
+func (s *TableCheckpoints) Save(ctx context.Context, consumer string, id int64) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s (consumer, last_id) VALUES ($1, $2)
+ON CONFLICT (consumer) DO UPDATE
+SET last_id = GREATEST(%[1]s.last_id, EXCLUDED.last_id), updated_at = CURRENT_TIMESTAMP`,
+		quoteName(s.table)), consumer, id)
+	if err != nil {
+		return fmt.Errorf("failed to save offset for %s: %w", consumer, err)
+	}
+	return nil
+}
+
+// FileCheckpoints keeps checkpoints in a local JSON file, replaced
+// atomically on every save.
+type FileCheckpoints struct {
+	path string
+
+	mu     sync.Mutex
+	loaded bool
+	ids    map[string]int64
+}
+
+func NewFileCheckpoints(path string) *FileCheckpoints {
+	return &FileCheckpoints{path: path}
+}
+
+func (s *FileCheckpoints) load() error {
+	if s.loaded {
+		return nil
+	}
+	s.ids = make(map[string]int64)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.ids); err != nil {
+		return fmt.Errorf("failed to parse checkpoints %s: %w", s.path, err)
+	}
+	s.loaded = true
+	return nil
+}
+
+func (s *FileCheckpoints) Load(_ context.Context, consumer string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+	return s.ids[consumer], nil
+}
+
+func (s *FileCheckpoints) Save(_ context.Context, consumer string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	if id <= s.ids[consumer] {
+		return nil
+	}
+	s.ids[consumer] = id
+
+	data, err := json.Marshal(s.ids)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoints: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save checkpoints: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save checkpoints: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save checkpoints: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save checkpoints: %w", err)
+	}
+	return nil
+}
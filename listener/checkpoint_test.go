@@ -0,0 +1,58 @@
+package listener
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileCheckpoints(t *testing.T) {
+	tests := []struct {
+		name  string
+		saves map[string][]int64
+		want  map[string]int64
+	}{
+		{name: "none", want: map[string]int64{"a": 0}},
+		{name: "latest", saves: map[string][]int64{"a": {1, 5, 9}}, want: map[string]int64{"a": 9}},
+		{name: "never moves back", saves: map[string][]int64{"a": {9, 5}}, want: map[string]int64{"a": 9}},
+		{name: "per consumer", saves: map[string][]int64{"a": {3}, "b": {7}}, want: map[string]int64{"a": 3, "b": 7, "c": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(t.TempDir(), "checkpoints.json")
+			s := NewFileCheckpoints(path)
+			for consumer, ids := range tt.saves {
+				for _, id := range ids {
+					if err := s.Save(ctx, consumer, id); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			// A new store reads what the first one saved.
+			reloaded := NewFileCheckpoints(path)
+			for consumer, want := range tt.want {
+				for _, store := range []*FileCheckpoints{s, reloaded} {
+					got, err := store.Load(ctx, consumer)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got != want {
+						t.Errorf("Load(%s) = %d, want %d", consumer, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFileCheckpointsCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileCheckpoints(path).Load(context.Background(), "a"); err == nil {
+		t.Error("Load of a corrupt file succeeded")
+	}
+}
@@ -0,0 +1,174 @@
+package listener
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/force-c/pg-data-listener/trigger"
+)
+
+const (
+	DefaultChunkTimeout = time.Minute
+	DropIncomplete      = "incomplete"
+	// MaxIncompleteChunked bounds the chunked payloads being reassembled
+	// per channel; chunks starting more are dropped as malformed.
+	MaxIncompleteChunked = 256
+)
+
+// maxChunks is the number of chunks the base64 encoding of a payload of
+// MaxDecompressedPayload bytes is split into; chunk headers announcing
+// more are malformed.
+var maxChunks = (base64.StdEncoding.EncodedLen(MaxDecompressedPayload) + trigger.ChunkSize - 1) / trigger.ChunkSize
+
+// chunkEnvelope is one part of a payload split by triggers installed with
+// trigger.PayloadChunked. The parts, concatenated in index order, are the
+// base64 encoding of the original payload.
+type chunkEnvelope struct {
+	Chunk *struct {
+		ID    string `json:"id"`
+		Index int    `json:"index"`
+		Total int    `json:"total"`
+	} `json:"chunk"`
+	Part string `json:"part"`
+}
+
+// isChunk reports whether payload is a chunk envelope. json_build_object
+// emits keys in order, so the "chunk" key leads the object.
+func isChunk(payload string) bool {
+	head := payload
+	if len(head) > 16 {
+		head = head[:16]
+	}
+	return strings.Contains(head, `"chunk"`)
+}
+
+type partialPayload struct {
+	channel  string
+	parts    []string
+	received int
+	started  time.Time
+	// ids are the event ids of the chunks received, when the capture
+	// source reports them.
+	ids []int64
+}
+
+// reassembler collects chunk notifications until their payload is complete.
+// Chunks are only added from the receive loop.
+type reassembler struct {
+	timeout  time.Duration
+	partials map[string]*partialPayload
+	// open counts the partials per channel.
+	open map[string]int
+
+	mu sync.Mutex
+	// held maps the id of a reassembled notification to the ids of the
+	// other chunks it was reassembled from, which complete along with it.
+	held map[int64][]int64
+}
+
+func newReassembler(timeout time.Duration) *reassembler {
+	return &reassembler{
+		timeout:  timeout,
+		partials: make(map[string]*partialPayload),
+		open:     make(map[string]int),
+		held:     make(map[int64][]int64),
+	}
+}
+
+// add records a chunk with event id and returns the decoded payload once
+// every chunk of it has arrived. The ids of the chunks of a payload that
+// completed or had to be dropped are returned along with it.
+func (r *reassembler) add(channel, payload string, id int64) ([]byte, bool, []int64, error) {
+	ids := appendID(nil, id)
+	var env chunkEnvelope
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		return nil, false, ids, fmt.Errorf("failed to parse chunk: %w", err)
+	}
+	c := env.Chunk
+	if c == nil || c.ID == "" || c.Total <= 0 || c.Total > maxChunks || c.Index < 0 || c.Index >= c.Total {
+		return nil, false, ids, fmt.Errorf("invalid chunk header")
+	}
+
+	key := channel + "\x00" + c.ID
+	p, ok := r.partials[key]
+	if !ok {
+		if r.open[channel] >= MaxIncompleteChunked {
+			return nil, false, ids, fmt.Errorf("chunk %s: %d chunked payloads incomplete already", c.ID, MaxIncompleteChunked)
+		}
+		p = &partialPayload{channel: channel, parts: make([]string, c.Total), started: time.Now()}
+		r.partials[key] = p
+		r.open[channel]++
+	}
+	p.ids = appendID(p.ids, id)
+	if len(p.parts) != c.Total {
+		r.remove(key, p)
+		return nil, false, p.ids, fmt.Errorf("chunk %s: inconsistent total %d", c.ID, c.Total)
+	}
+	if p.parts[c.Index] == "" {
+		p.received++
+	}
+	p.parts[c.Index] = env.Part
+	if p.received < c.Total {
+		return nil, false, nil, nil
+	}
+
+	r.remove(key, p)
+	data, err := base64.StdEncoding.DecodeString(strings.Join(p.parts, ""))
+	if err != nil {
+		return nil, false, p.ids, fmt.Errorf("chunk %s: failed to decode: %w", c.ID, err)
+	}
+	return data, true, p.ids, nil
+}
+
+// expire drops payloads still incomplete after the timeout and returns
+// them.
+func (r *reassembler) expire(now time.Time) []*partialPayload {
+	var dropped []*partialPayload
+	for key, p := range r.partials {
+		if now.Sub(p.started) > r.timeout {
+			r.remove(key, p)
+			dropped = append(dropped, p)
+		}
+	}
+	return dropped
+}
+
+func (r *reassembler) remove(key string, p *partialPayload) {
+	delete(r.partials, key)
+	if r.open[p.channel]--; r.open[p.channel] == 0 {
+		delete(r.open, p.channel)
+	}
+}
+
+// hold links the ids of the chunks a notification was reassembled from,
+// other than its own, to the notification's id.
+func (r *reassembler) hold(id int64, ids []int64) {
+	ids = slices.DeleteFunc(slices.Clone(ids), func(part int64) bool { return part == id })
+	if len(ids) == 0 {
+		return
+	}
+	r.mu.Lock()
+	r.held[id] = append(r.held[id], ids...)
+	r.mu.Unlock()
+}
+
+// release returns the chunk ids held for id and forgets them.
+func (r *reassembler) release(id int64) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids := r.held[id]
+	delete(r.held, id)
+	return ids
+}
+
+func appendID(ids []int64, id int64) []int64 {
+	if id == 0 {
+		return ids
+	}
+	return append(ids, id)
+}
@@ -0,0 +1,218 @@
+package listener
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// chunkPayloads splits payload into total chunk envelopes with chunk id.
+func chunkPayloads(id, payload string, total int) []string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(payload))
+	size := (len(encoded) + total - 1) / total
+	chunks := make([]string, total)
+	for i := range chunks {
+		part := encoded[min(i*size, len(encoded)):min((i+1)*size, len(encoded))]
+		chunks[i] = fmt.Sprintf(`{"chunk":{"id":%q,"index":%d,"total":%d},"part":%q}`, id, i, total, part)
+	}
+	return chunks
+}
+
+func TestReassembler(t *testing.T) {
+	const payload = `{"table":"users","operation":"INSERT","data":{"id":1}}`
+	chunks := chunkPayloads("c1", payload, 3)
+
+	type add struct {
+		payload string
+		id      int64
+	}
+	tests := []struct {
+		name     string
+		adds     []add
+		wantData string
+		wantIDs  []int64
+		wantErr  bool
+	}{
+		{
+			name:     "in order",
+			adds:     []add{{chunks[0], 1}, {chunks[1], 2}, {chunks[2], 3}},
+			wantData: payload,
+			wantIDs:  []int64{1, 2, 3},
+		},
+		{
+			name:     "out of order",
+			adds:     []add{{chunks[2], 3}, {chunks[0], 1}, {chunks[1], 2}},
+			wantData: payload,
+			wantIDs:  []int64{3, 1, 2},
+		},
+		{
+			name:     "repeated chunk",
+			adds:     []add{{chunks[0], 1}, {chunks[0], 2}, {chunks[1], 3}, {chunks[2], 4}},
+			wantData: payload,
+			wantIDs:  []int64{1, 2, 3, 4},
+		},
+		{
+			name:     "without event ids",
+			adds:     []add{{chunks[0], 0}, {chunks[1], 0}, {chunks[2], 0}},
+			wantData: payload,
+		},
+		{
+			name:    "invalid json",
+			adds:    []add{{`{"chunk":`, 7}},
+			wantIDs: []int64{7},
+			wantErr: true,
+		},
+		{
+			name:    "invalid header",
+			adds:    []add{{`{"chunk":{"id":"c1","index":3,"total":3},"part":"x"}`, 7}},
+			wantIDs: []int64{7},
+			wantErr: true,
+		},
+		{
+			name:    "zero total",
+			adds:    []add{{`{"chunk":{"id":"c1","index":0,"total":0},"part":"x"}`, 7}},
+			wantIDs: []int64{7},
+			wantErr: true,
+		},
+		{
+			name:    "negative total",
+			adds:    []add{{`{"chunk":{"id":"c1","index":0,"total":-1},"part":"x"}`, 7}},
+			wantIDs: []int64{7},
+			wantErr: true,
+		},
+		{
+			name:    "total beyond the maximum payload",
+			adds:    []add{{fmt.Sprintf(`{"chunk":{"id":"c1","index":0,"total":%d},"part":"x"}`, maxChunks+1), 7}},
+			wantIDs: []int64{7},
+			wantErr: true,
+		},
+		{
+			name:    "inconsistent total",
+			adds:    []add{{chunks[0], 1}, {`{"chunk":{"id":"c1","index":1,"total":4},"part":"x"}`, 2}},
+			wantIDs: []int64{1, 2},
+			wantErr: true,
+		},
+		{
+			name: "invalid base64",
+			adds: []add{
+				{`{"chunk":{"id":"c1","index":0,"total":2},"part":"!!"}`, 1},
+				{`{"chunk":{"id":"c1","index":1,"total":2},"part":"!!"}`, 2},
+			},
+			wantIDs: []int64{1, 2},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(time.Minute)
+			for i, a := range tt.adds {
+				data, complete, ids, err := r.add("events", a.payload, a.id)
+				last := i == len(tt.adds)-1
+				if !last {
+					if err != nil || complete || ids != nil {
+						t.Fatalf("chunk %d: add = %v, %v, %v", i, complete, ids, err)
+					}
+					continue
+				}
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("chunk %d: err = %v, want error %v", i, err, tt.wantErr)
+				}
+				if complete != !tt.wantErr || string(data) != tt.wantData {
+					t.Errorf("chunk %d: add = %q, %v, want %q", i, data, complete, tt.wantData)
+				}
+				if !slices.Equal(ids, tt.wantIDs) {
+					t.Errorf("chunk %d: ids = %v, want %v", i, ids, tt.wantIDs)
+				}
+			}
+			if len(r.partials) != 0 || len(r.open) != 0 {
+				t.Errorf("%d partial payloads left, %d channels counted", len(r.partials), len(r.open))
+			}
+		})
+	}
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	r := newReassembler(time.Minute)
+	a := chunkPayloads("a", `{"table":"a"}`, 2)
+	b := chunkPayloads("b", `{"table":"b"}`, 2)
+	r.add("events", a[0], 1)
+	r.add("other", b[0], 2)
+	r.add("other", b[1], 3)
+	r.add("events", chunkPayloads("c", `{"table":"c"}`, 2)[0], 0)
+
+	if dropped := r.expire(time.Now()); len(dropped) != 0 {
+		t.Fatalf("expired %d payloads before the timeout", len(dropped))
+	}
+	dropped := r.expire(time.Now().Add(2 * time.Minute))
+	var ids []int64
+	for _, p := range dropped {
+		if p.channel != "events" {
+			t.Errorf("expired payload of channel %q", p.channel)
+		}
+		ids = append(ids, p.ids...)
+	}
+	if len(dropped) != 2 || !slices.Equal(ids, []int64{1}) {
+		t.Errorf("expired %d payloads with ids %v, want 2 with [1]", len(dropped), ids)
+	}
+}
+
+func TestReassemblerLimit(t *testing.T) {
+	r := newReassembler(time.Minute)
+	var first []string
+	for i := range MaxIncompleteChunked {
+		chunks := chunkPayloads(fmt.Sprint(i), `{"table":"users"}`, 2)
+		if i == 0 {
+			first = chunks
+		}
+		if _, _, _, err := r.add("events", chunks[0], int64(i+1)); err != nil {
+			t.Fatalf("chunk %d: %v", i, err)
+		}
+	}
+
+	extra := chunkPayloads("extra", `{"table":"users"}`, 2)
+	if _, _, ids, err := r.add("events", extra[0], 1000); err == nil || !slices.Equal(ids, []int64{1000}) {
+		t.Fatalf("add beyond the limit = %v, %v, want an error with [1000]", ids, err)
+	}
+	if _, _, _, err := r.add("other", extra[0], 1001); err != nil {
+		t.Fatalf("add on another channel: %v", err)
+	}
+	// Chunks of incomplete payloads are still added, and completing one
+	// makes room for another.
+	if _, complete, _, err := r.add("events", first[1], 1002); err != nil || !complete {
+		t.Fatalf("completing add = %v, %v", complete, err)
+	}
+	if _, _, _, err := r.add("events", extra[0], 1003); err != nil {
+		t.Fatalf("add after completing one: %v", err)
+	}
+	if got := r.open["events"]; got != MaxIncompleteChunked {
+		t.Errorf("%d incomplete payloads counted, want %d", got, MaxIncompleteChunked)
+	}
+}
+
+func TestReassemblerHold(t *testing.T) {
+	tests := []struct {
+		name  string
+		id    int64
+		parts []int64
+		want  []int64
+	}{
+		{name: "other chunks", id: 3, parts: []int64{1, 2, 3}, want: []int64{1, 2}},
+		{name: "own id only", id: 3, parts: []int64{3}},
+		{name: "id from payload", id: 10, parts: []int64{1, 2}, want: []int64{1, 2}},
+		{name: "no ids", id: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler(time.Minute)
+			r.hold(tt.id, tt.parts)
+			if got := r.release(tt.id); !slices.Equal(got, tt.want) {
+				t.Errorf("release = %v, want %v", got, tt.want)
+			}
+			if got := r.release(tt.id); got != nil {
+				t.Errorf("second release = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCircuitFailures    = 5
+	DefaultCircuitOpenTimeout = 30 * time.Second
+)
+
+// ErrCircuitOpen fails notifications whose handler's circuit is open and
+// whose buffer is full or disabled.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreaker stops calling a handler that keeps failing, e.g. a sink
+// whose destination is down. After Failures consecutive failed
+// notifications the circuit opens: notifications are held in a buffer of
+// up to Buffer entries, or fail with ErrCircuitOpen and are dead-lettered
+// once it is full. After OpenTimeout one notification probes the handler;
+// success closes the circuit and delivers the buffered notifications,
+// failure keeps it open for another OpenTimeout.
+//
+// Buffered notifications are acknowledged once delivered, so checkpoints
+// don't pass them and they are replayed after a restart.
+type CircuitBreaker struct {
+	Failures    int
+	OpenTimeout time.Duration
+	Buffer      int
+}
+
+func (c CircuitBreaker) withDefaults() CircuitBreaker {
+	if c.Failures <= 0 {
+		c.Failures = DefaultCircuitFailures
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = DefaultCircuitOpenTimeout
+	}
+	return c
+}
+
+// WithCircuitBreaker guards one handler with a circuit breaker.
+func WithCircuitBreaker(cfg CircuitBreaker) HandlerOption {
+	return func(r *registration) {
+		r.circuit = &circuit{cfg: cfg.withDefaults()}
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// heldNotification is a notification whose completion was deferred while
+// it is held back.
+type heldNotification struct {
+	ctx context.Context
+	n   *ChangeNotification
+	ack func()
+}
+
+// redeliver runs fn with a fresh ack state, so a handler or limiter can
+// defer the ack again, and acknowledges the held notification once that
+// completes.
+func (h heldNotification) redeliver(fn func(ctx context.Context) *Failure) *Failure {
+	st := &ackState{}
+	failure := fn(context.WithValue(h.ctx, ackKey{}, st))
+	st.finish(h.ack)
+	return failure
+}
+
+// circuit is the breaker state of one handler.
+type circuit struct {
+	cfg CircuitBreaker
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	held     []heldNotification
+}
+
+// acquire reports whether a notification may call the handler now, and
+// whether that call is the probe of an open circuit.
+func (c *circuit) acquire(now time.Time) (allowed, probe bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.state {
+	case circuitClosed:
+		return true, false
+	case circuitOpen:
+		if now.Sub(c.openedAt) >= c.cfg.OpenTimeout {
+			c.state = circuitHalfOpen
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// hold buffers a notification while the circuit is open, reporting false
+// when the buffer is full.
+func (c *circuit) hold(h heldNotification) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.held) >= c.cfg.Buffer {
+		return false
+	}
+	c.held = append(c.held, h)
+	return true
+}
+
+// record counts the outcome of a call. It reports whether the circuit
+// opened and, when it closed, returns the buffered notifications.
+func (c *circuit) record(ok, probe bool, now time.Time) (opened bool, released []heldNotification) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok {
+		c.failures = 0
+		if probe {
+			c.state = circuitClosed
+			released, c.held = c.held, nil
+		}
+		return false, released
+	}
+	if probe || c.state == circuitClosed {
+		c.failures++
+		if probe || c.failures >= c.cfg.Failures {
+			c.state = circuitOpen
+			c.openedAt = now
+			c.failures = 0
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// takeProbe removes the oldest buffered notification to probe with, once
+// the open timeout has passed.
+func (c *circuit) takeProbe(now time.Time) (heldNotification, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state != circuitOpen || len(c.held) == 0 || now.Sub(c.openedAt) < c.cfg.OpenTimeout {
+		return heldNotification{}, false
+	}
+	c.state = circuitHalfOpen
+	h := c.held[0]
+	c.held = c.held[1:]
+	return h, true
+}
+
+// unshift puts notifications back at the head of the buffer.
+func (c *circuit) unshift(hs ...heldNotification) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = append(hs[:len(hs):len(hs)], c.held...)
+}
+
+func (c *circuit) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state == circuitClosed
+}
+
+// dispatchCircuit calls a handler guarded by a circuit breaker.
+func (dl *DataListener) dispatchCircuit(ctx context.Context, n *ChangeNotification, reg *registration) *Failure {
+	c := reg.circuit
+	allowed, probe := c.acquire(time.Now())
+	if !allowed {
+		if c.cfg.Buffer > 0 {
+			h := heldNotification{ctx: ctx, n: n, ack: DeferAck(ctx)}
+			if c.hold(h) {
+				return nil
+			}
+			// Not held after all; completing follows the failure.
+			h.ack()
+		}
+		now := time.Now()
+		return &Failure{Notification: n, Err: ErrCircuitOpen, FirstAttempt: now, LastAttempt: now}
+	}
+
+	failure := dl.invoke(ctx, n, reg)
+	opened, released := c.record(failure == nil, probe, time.Now())
+	dl.circuitChanged(n, reg, opened, probe && failure == nil)
+	dl.release(reg, released)
+	return failure
+}
+
+// circuitChanged reports state transitions and schedules the probe of an
+// opened circuit that has notifications buffered.
+func (dl *DataListener) circuitChanged(n *ChangeNotification, reg *registration, opened, closed bool) {
+	switch {
+	case opened:
+		dl.logger.Warn("circuit opened", "channel", n.Channel, "table", n.Table, "retry_in", reg.circuit.cfg.OpenTimeout)
+		dl.metrics.CircuitChanged(n, true)
+		if reg.circuit.cfg.Buffer > 0 {
+			time.AfterFunc(reg.circuit.cfg.OpenTimeout, func() { dl.probe(reg) })
+		}
+	case closed:
+		dl.logger.Info("circuit closed", "channel", n.Channel, "table", n.Table)
+		dl.metrics.CircuitChanged(n, false)
+	}
+}
+
+// probe retries the oldest buffered notification of an open circuit, so
+// it closes without waiting for new notifications.
+func (dl *DataListener) probe(reg *registration) {
+	select {
+	case <-dl.stop:
+		return
+	default:
+	}
+	c := reg.circuit
+	h, ok := c.takeProbe(time.Now())
+	if !ok {
+		return
+	}
+	st := &ackState{}
+	failure := dl.invoke(context.WithValue(h.ctx, ackKey{}, st), h.n, reg)
+	opened, released := c.record(failure == nil, true, time.Now())
+	if failure != nil {
+		c.unshift(h)
+	} else {
+		st.finish(h.ack)
+	}
+	dl.circuitChanged(h.n, reg, opened, failure == nil)
+	dl.release(reg, released)
+}
+
+// release delivers the notifications buffered while the circuit was open,
+// in order. If the circuit opens again the rest stay buffered.
+func (dl *DataListener) release(reg *registration, held []heldNotification) {
+	c := reg.circuit
+	for i, h := range held {
+		if !c.closed() {
+			c.unshift(held[i:]...)
+			return
+		}
+		failure := h.redeliver(func(ctx context.Context) *Failure { return dl.invoke(ctx, h.n, reg) })
+		opened, _ := c.record(failure == nil, false, time.Now())
+		if failure != nil {
+			dl.fail(h.ctx, failure)
+		}
+		dl.circuitChanged(h.n, reg, opened, false)
+	}
+}
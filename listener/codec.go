@@ -0,0 +1,118 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes notifications into message bodies and back, so producers
+// and sinks can use more compact encodings than JSON. Every Codec is also
+// a sink.Encoder.
+type Codec interface {
+	// Name identifies the codec in configs, e.g. "msgpack".
+	Name() string
+	ContentType() string
+	Encode(n *ChangeNotification) ([]byte, error)
+	// Decode returns the notification of data; the listener sets its
+	// channel.
+	Decode(data []byte) (*ChangeNotification, error)
+}
+
+// The built-in codecs.
+var (
+	JSONCodec        Codec = jsonCodec{}
+	MessagePackCodec Codec = msgpackCodec{}
+	ProtobufCodec    Codec = protobufCodec{}
+)
+
+// LookupCodec returns the built-in codec named name.
+func LookupCodec(name string) (Codec, bool) {
+	for _, c := range []Codec{JSONCodec, MessagePackCodec, ProtobufCodec} {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// WithChannelCodec decodes the payloads of channel with c instead of JSON.
+// NOTIFY payloads are text, so binary payloads are sent base64 encoded,
+// see EncodePayload. Payloads starting with "{" are still read as JSON,
+// such as those of the trigger function and the outbox.
+func WithChannelCodec(channel string, c Codec) Option {
+	return func(dl *DataListener) {
+		dl.codecs[channel] = c
+	}
+}
+
+// EncodePayload returns the NOTIFY payload of n encoded with c, for
+// producers sending notifications themselves.
+func EncodePayload(c Codec, n *ChangeNotification) (string, error) {
+	data, err := c.Encode(n)
+	if err != nil {
+		return "", err
+	}
+	if c.Name() == JSONCodec.Name() {
+		return string(data), nil
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// decode parses a payload received on channel with the channel's codec,
+// decompressing it first if needed.
+func (dl *DataListener) decode(channel string, payload []byte) (*ChangeNotification, error) {
+	if isCompressed(payload) {
+		var err error
+		if payload, err = decompress(payload); err != nil {
+			return nil, err
+		}
+	}
+	c, ok := dl.codecs[channel]
+	if !ok || c.Name() == JSONCodec.Name() || bytes.HasPrefix(bytes.TrimSpace(payload), []byte("{")) {
+		return decodeNotification(channel, payload)
+	}
+	data, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(payload)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s notification: %w", c.Name(), err)
+	}
+	n, err := c.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s notification: %w", c.Name(), err)
+	}
+	n.Channel = channel
+	n.fillImages()
+	return n, nil
+}
+
+// compacted returns a copy of n without the row images fillImages derives
+// from Data, for the codecs to leave out.
+func (n *ChangeNotification) compacted() *ChangeNotification {
+	c := *n
+	c.tx = nil
+	switch n.Operation {
+	case OpInsert, OpUpdate:
+		if bytes.Equal(c.New, c.Data) {
+			c.New = nil
+		}
+	case OpDelete:
+		if bytes.Equal(c.Old, c.Data) {
+			c.Old = nil
+		}
+	}
+	return &c
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return "json" }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Encode(n *ChangeNotification) ([]byte, error) {
+	return json.Marshal(n)
+}
+
+func (jsonCodec) Decode(data []byte) (*ChangeNotification, error) {
+	return decodeNotification("", data)
+}
@@ -0,0 +1,110 @@
+package listener
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The compression algorithms of compressed payloads.
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// MaxDecompressedPayload bounds the size of a decompressed payload, so a
+// small notification cannot expand without limit.
+const MaxDecompressedPayload = 16 << 20
+
+// compressedPrefix starts compressed payloads, as sent by triggers installed
+// with trigger.WithCompression: "z:", the algorithm, ":" and the base64
+// encoding of the compressed payload.
+const compressedPrefix = "z:"
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxDecompressedPayload))
+	})
+)
+
+// CompressPayload returns payload compressed with algorithm, CompressGzip
+// or CompressZstd, in the form the listener decompresses, for producers
+// sending notifications themselves.
+func CompressPayload(algorithm string, payload []byte) (string, error) {
+	var data []byte
+	switch algorithm {
+	case CompressGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	case CompressZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return "", err
+		}
+		data = enc.EncodeAll(payload, nil)
+	default:
+		return "", fmt.Errorf("unknown compression %q", algorithm)
+	}
+	return compressedPrefix + algorithm + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// isCompressed reports whether payload carries the compressed header.
+func isCompressed(payload []byte) bool {
+	return bytes.HasPrefix(payload, []byte(compressedPrefix))
+}
+
+// decompress returns the payload compressed in a payload with the
+// compressed header.
+func decompress(payload []byte) ([]byte, error) {
+	algorithm, encoded, ok := strings.Cut(string(payload[len(compressedPrefix):]), ":")
+	if !ok {
+		return nil, errors.New("invalid compressed payload header")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %w", algorithm, err)
+	}
+
+	var out []byte
+	switch algorithm {
+	case CompressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip payload: %w", err)
+		}
+		out, err = io.ReadAll(io.LimitReader(r, MaxDecompressedPayload+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress gzip payload: %w", err)
+		}
+	case CompressZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		out, err = dec.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress zstd payload: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown payload compression %q", algorithm)
+	}
+	if len(out) > MaxDecompressedPayload {
+		return nil, fmt.Errorf("decompressed %s payload exceeds %d bytes", algorithm, MaxDecompressedPayload)
+	}
+	return out, nil
+}
@@ -0,0 +1,204 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// PoolConfig sizes the query connection pool, which serves DB() and the
+// listener's own queries: outbox reads, checkpoints, reference fetches,
+// dead letters and slot monitoring. Zero values keep the database/sql
+// defaults. The LISTEN connection is not part of the pool.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+func (c PoolConfig) apply(db *sql.DB) {
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+	if c.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(c.ConnMaxIdleTime)
+	}
+}
+
+// WithQueryPool sizes the query connection pool.
+func WithQueryPool(cfg PoolConfig) Option {
+	return func(dl *DataListener) {
+		dl.queryPool = cfg
+	}
+}
+
+// WithListenConnString makes the dedicated LISTEN connection, or the
+// replication stream, connect with connStr instead of the connection
+// string passed to New, e.g. to reach the primary directly while queries
+// go through a transaction-pooling PgBouncer, which does not support
+// LISTEN. New validates it.
+func WithListenConnString(connStr string) Option {
+	return func(dl *DataListener) {
+		dl.listenConnStr = connStr
+	}
+}
+
+// ConnConfig holds the TLS and password settings of the connections
+// opened by OpenDB, see WithTLS and WithPassword.
+type ConnConfig struct {
+	TLS      *TLSConfig
+	Password PasswordFunc
+}
+
+// OpenDB opens a connection pool on connStr as New does for its queries.
+func OpenDB(connStr string, cfg ConnConfig) (*sql.DB, error) {
+	queries, err := newQueryConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	opts := connOptions{password: cfg.Password}
+	if cfg.TLS != nil {
+		if opts.tls, err = cfg.TLS.build(); err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+	if err := queries.configure(connStr, opts); err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(queries), nil
+}
+
+// queryConnector opens the query connections. New configures it once the
+// options are applied, e.g. to connect over TLS. With several hosts, they
+// are tried in order until one satisfies target_session_attrs, so the
+// pool follows the primary after a failover.
+type queryConnector struct {
+	hosts []*hostConnector
+	attrs string
+	opts  connOptions
+	user  string
+}
+
+// hostConnector opens connections to one host.
+type hostConnector struct {
+	connector *pq.Connector
+	// addr names the host in errors when there are several.
+	addr string
+	// With a password function, every connection gets a connector of
+	// its own with a new password.
+	connStr string
+	host    string
+	port    uint16
+}
+
+// newQueryConnector validates connStr without connecting.
+func newQueryConnector(connStr string) (*queryConnector, error) {
+	c := &queryConnector{}
+	if err := c.configure(connStr, connOptions{}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// configure applies opts to the connections opened from connStr.
+func (c *queryConnector) configure(connStr string, opts connOptions) error {
+	conns, attrs, err := hostConnStrings(connStr)
+	if err != nil {
+		return err
+	}
+	hosts := make([]*hostConnector, len(conns))
+	var user string
+	for i, cs := range conns {
+		kv, err := opts.pqConnString(cs)
+		if err != nil {
+			return err
+		}
+		h := &hostConnector{}
+		if len(conns) > 1 {
+			h.addr = hostAddr(kv)
+		}
+		if h.connector, err = opts.pqConnector(kv); err != nil {
+			return err
+		}
+		if opts.password != nil {
+			cfg, err := pgconn.ParseConfig(cs)
+			if err != nil {
+				return fmt.Errorf("invalid connection string: %w", err)
+			}
+			h.connStr, h.host, h.port, user = kv, cfg.Host, cfg.Port, cfg.User
+		}
+		hosts[i] = h
+	}
+	c.hosts, c.attrs, c.opts, c.user = hosts, attrs, opts, user
+	return nil
+}
+
+func (c *queryConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if len(c.hosts) == 1 && c.attrs == "" {
+		return c.connect(ctx, c.hosts[0])
+	}
+	var errs []error
+	for _, attrs := range sessionPasses(c.attrs) {
+		for _, h := range c.hosts {
+			conn, err := c.connect(ctx, h)
+			if err == nil {
+				if err = checkSessionAttrs(ctx, conn, attrs); err != nil {
+					conn.Close()
+				}
+			}
+			if err == nil {
+				return conn, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if h.addr != "" {
+				err = fmt.Errorf("%s: %w", h.addr, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (c *queryConnector) connect(ctx context.Context, h *hostConnector) (driver.Conn, error) {
+	if c.opts.password == nil {
+		return h.connector.Connect(ctx)
+	}
+	password, err := c.opts.password(ctx, h.host, h.port, c.user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get password: %w", err)
+	}
+	connector, err := c.opts.pqConnector(h.connStr + " password=" + quoteValue(password))
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *queryConnector) Driver() driver.Driver {
+	return c.hosts[0].connector.Driver()
+}
+
+// parseConnString validates a URL or key/value connection string without
+// connecting.
+func parseConnString(connStr string) (*pq.Connector, error) {
+	connector, err := pq.NewConnector(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	return connector, nil
+}
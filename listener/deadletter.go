@@ -0,0 +1,194 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const DefaultDeadLetterTable = "data_listener_dead_letters"
+
+// DeadLetter is a notification whose handler failed permanently.
+type DeadLetter struct {
+	ID           int64
+	Channel      string
+	Table        string
+	Operation    string
+	Payload      json.RawMessage
+	Error        string
+	Attempts     int
+	FirstAttempt time.Time
+	LastAttempt  time.Time
+	CreatedAt    time.Time
+}
+
+// Notification decodes the dead-lettered payload.
+func (d *DeadLetter) Notification() (*ChangeNotification, error) {
+	return decodeNotification(d.Channel, d.Payload)
+}
+
+func newDeadLetter(f *Failure) (*DeadLetter, error) {
+	payload, err := json.Marshal(f.Notification)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetter{
+		Channel:      f.Notification.Channel,
+		Table:        f.Notification.Table,
+		Operation:    f.Notification.Operation,
+		Payload:      payload,
+		Error:        f.Err.Error(),
+		Attempts:     f.Attempts,
+		FirstAttempt: f.FirstAttempt,
+		LastAttempt:  f.LastAttempt,
+	}, nil
+}
+
+// DeadLetterQuery selects dead letters. Zero fields match everything.
+type DeadLetterQuery struct {
+	IDs     []int64
+	Table   string
+	AfterID int64
+	Limit   int
+}
+
+// DeadLetterStore persists dead letters.
+type DeadLetterStore interface {
+	Put(ctx context.Context, d *DeadLetter) error
+	List(ctx context.Context, q DeadLetterQuery) ([]*DeadLetter, error)
+	Delete(ctx context.Context, ids ...int64) error
+}
+
+// DeadLetterCounter is implemented by stores that count their dead
+// letters, see DataListener.DeadLetterCount.
+type DeadLetterCounter interface {
+	Count(ctx context.Context) (int64, error)
+}
+
+// ErrNoDeadLetterCount is returned by DeadLetterCount for stores without a
+// DeadLetterCounter.
+var ErrNoDeadLetterCount = errors.New("dead letter store cannot count")
+
+// PostgresDeadLetterStore keeps dead letters in a Postgres table, created
+// by Init.
+type PostgresDeadLetterStore struct {
+	db    *sql.DB
+	table string
+}
+
+func NewPostgresDeadLetterStore(db *sql.DB, table string) *PostgresDeadLetterStore {
+	if table == "" {
+		table = DefaultDeadLetterTable
+	}
+	return &PostgresDeadLetterStore{db: db, table: table}
+}
+
+func (s *PostgresDeadLetterStore) Init(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id BIGSERIAL PRIMARY KEY,
+    channel TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    error TEXT NOT NULL,
+    attempts INT NOT NULL,
+    first_attempt_at TIMESTAMPTZ NOT NULL,
+    last_attempt_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, quoteName(s.table)))
+	if err != nil {
+		return fmt.Errorf("failed to create dead letter table %s: %w", s.table, err)
+	}
+	return nil
+}
+
+func (s *PostgresDeadLetterStore) Put(ctx context.Context, d *DeadLetter) error {
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`INSERT INTO %s
+    (channel, table_name, operation, payload, error, attempts, first_attempt_at, last_attempt_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+RETURNING id, created_at`, quoteName(s.table)),
+		d.Channel, d.Table, d.Operation, []byte(d.Payload), d.Error, d.Attempts, d.FirstAttempt, d.LastAttempt,
+	).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+func (s *PostgresDeadLetterStore) List(ctx context.Context, q DeadLetterQuery) ([]*DeadLetter, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if len(q.IDs) > 0 {
+		args = append(args, pq.Array(q.IDs))
+		where = append(where, fmt.Sprintf("id = ANY($%d)", len(args)))
+	}
+	if q.Table != "" {
+		args = append(args, q.Table)
+		where = append(where, fmt.Sprintf("table_name = $%d", len(args)))
+	}
+	if q.AfterID > 0 {
+		args = append(args, q.AfterID)
+		where = append(where, fmt.Sprintf("id > $%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`SELECT id, channel, table_name, operation, payload, error, attempts,
+    first_attempt_at, last_attempt_at, created_at
+FROM %s`, quoteName(s.table))
+	if len(where) > 0 {
+		query += "\nWHERE " + strings.Join(where, " AND ")
+	}
+	query += "\nORDER BY id"
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		query += fmt.Sprintf("\nLIMIT $%d", len(args))
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	defer rows.Close()
+
+	var letters []*DeadLetter
+	for rows.Next() {
+		var (
+			d       DeadLetter
+			payload []byte
+		)
+		if err := rows.Scan(&d.ID, &d.Channel, &d.Table, &d.Operation, &payload, &d.Error, &d.Attempts,
+			&d.FirstAttempt, &d.LastAttempt, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = payload
+		letters = append(letters, &d)
+	}
+	return letters, rows.Err()
+}
+
+func (s *PostgresDeadLetterStore) Count(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT count(*) FROM %s", quoteName(s.table))).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+	return n, nil
+}
+
+func (s *PostgresDeadLetterStore) Delete(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = ANY($1)", quoteName(s.table)), pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to delete dead letters: %w", err)
+	}
+	return nil
+}
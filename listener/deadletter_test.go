@@ -0,0 +1,168 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryDeadLetters is a DeadLetterStore in memory.
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	nextID  int64
+	letters []*DeadLetter
+}
+
+func (m *memoryDeadLetters) Put(_ context.Context, d *DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	d.ID = m.nextID
+	m.letters = append(m.letters, d)
+	return nil
+}
+
+func (m *memoryDeadLetters) List(_ context.Context, q DeadLetterQuery) ([]*DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var letters []*DeadLetter
+	for _, d := range m.letters {
+		if len(q.IDs) == 0 || slices.Contains(q.IDs, d.ID) {
+			letters = append(letters, d)
+		}
+	}
+	return letters, nil
+}
+
+func (m *memoryDeadLetters) Delete(_ context.Context, ids ...int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.letters = slices.DeleteFunc(m.letters, func(d *DeadLetter) bool { return slices.Contains(ids, d.ID) })
+	return nil
+}
+
+func (m *memoryDeadLetters) ids() []int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []int64
+	for _, d := range m.letters {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestRequeue(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		name string
+		opts []Option
+		// pool starts a worker pool of one worker, whose first call blocks
+		// until every letter was requeued.
+		pool  bool
+		pause bool
+		fail  bool
+		// letters are requeued, one at a time.
+		letters      int
+		wantRequeued int
+		// wantKept are the dead letters kept once requeued, and
+		// wantLeft those left after processing ended.
+		wantKept, wantLeft []int64
+	}{
+		{
+			name:         "processed",
+			letters:      2,
+			wantRequeued: 2,
+		},
+		{
+			name:         "failing again",
+			fail:         true,
+			letters:      1,
+			wantRequeued: 1,
+			wantLeft:     []int64{2},
+		},
+		{
+			name:         "held while paused",
+			pause:        true,
+			letters:      2,
+			wantRequeued: 2,
+			wantKept:     []int64{1, 2},
+		},
+		{
+			name:         "dropped from a full queue",
+			opts:         []Option{WithQueueSize(1), WithOverflow(OverflowDropNewest)},
+			pool:         true,
+			letters:      3,
+			wantRequeued: 3,
+			wantLeft:     []int64{3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memoryDeadLetters{}
+			dl, _ := newTestListener(t, append([]Option{WithDeadLetterStore(store)}, tt.opts...)...)
+			if tt.pool {
+				startTestPool(t, dl, 1)
+			}
+			started, release := make(chan struct{}), make(chan struct{})
+			var once sync.Once
+			dl.HandleFunc("users", func(ctx context.Context, n *ChangeNotification) error {
+				if tt.pool {
+					once.Do(func() {
+						close(started)
+						<-release
+					})
+				}
+				if tt.fail {
+					return errFailed
+				}
+				return nil
+			})
+
+			ctx := context.Background()
+			for i := range tt.letters {
+				store.Put(ctx, &DeadLetter{Channel: DefaultChannel, Payload: []byte(payload(OpInsert, i+1, ""))})
+			}
+			if tt.pause {
+				dl.Pause()
+			}
+			requeued := 0
+			for _, id := range store.ids() {
+				n, err := dl.Requeue(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				requeued += n
+				if tt.pool && id == 1 {
+					<-started
+				}
+			}
+			if requeued != tt.wantRequeued {
+				t.Errorf("requeued %d, want %d", requeued, tt.wantRequeued)
+			}
+			if tt.pause {
+				if got := store.ids(); !slices.Equal(got, tt.wantKept) {
+					t.Errorf("kept %v while paused, want %v", got, tt.wantKept)
+				}
+				dl.Resume()
+				// Held notifications count as in flight once released.
+				for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+					if _, held := dl.Paused(); held == 0 || time.Now().After(deadline) {
+						break
+					}
+				}
+			}
+			if tt.pool {
+				close(release)
+			}
+			if !idle(dl, 5*time.Second) {
+				t.Fatal("notifications still in flight")
+			}
+			if got := store.ids(); !slices.Equal(got, tt.wantLeft) {
+				t.Errorf("left %v, want %v", got, tt.wantLeft)
+			}
+		})
+	}
+}
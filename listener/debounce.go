@@ -0,0 +1,144 @@
+package listener
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// WithDebounce coalesces the changes each row (by table and primary key)
+// receives within window of its first change into one, delivered when the
+// window ends with the row's latest state. The merged operation is the net
+// effect: an INSERT followed by updates is an INSERT of the latest row, an
+// INSERT followed by a DELETE is dropped entirely, and any other sequence
+// ending in an INSERT or UPDATE is an UPDATE from the first old row.
+// Notifications without a key are not debounced.
+func WithDebounce(window time.Duration) HandlerOption {
+	return func(r *registration) {
+		r.debouncer = &debouncer{window: window, pending: make(map[string]*debounced)}
+	}
+}
+
+// debouncer is the debounce state of one handler.
+type debouncer struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*debounced
+}
+
+type debounced struct {
+	first  *ChangeNotification
+	latest *heldNotification
+	timer  *time.Timer
+}
+
+// debounce holds back n until its row's window ends, reporting whether it
+// did.
+func (dl *DataListener) debounce(ctx context.Context, n *ChangeNotification, reg *registration) bool {
+	d := reg.debouncer
+	key := rowKey(n)
+	if key == "" || d.window <= 0 {
+		return false
+	}
+
+	held := dl.hold(ctx, n)
+	d.mu.Lock()
+	p, ok := d.pending[key]
+	if !ok {
+		p = &debounced{first: n, latest: held}
+		d.pending[key] = p
+		p.timer = time.AfterFunc(d.window, func() { dl.flushDebounced(reg, key, p) })
+		d.mu.Unlock()
+		return true
+	}
+	prev := p.latest
+	p.latest = held
+	d.mu.Unlock()
+
+	dl.metrics.Dropped(prev.n.Channel, DropCoalesced)
+	dl.tenantDropped(prev.n, DropCoalesced)
+	prev.ack()
+	dl.inflight.Done()
+	return true
+}
+
+// flushDebounced delivers the merged change of a row whose window p ended,
+// unless it was already flushed, where the row's ordering key is
+// processed, see schedule. A change still held when the listener stops is
+// left unacknowledged.
+func (dl *DataListener) flushDebounced(reg *registration, key string, p *debounced) {
+	d := reg.debouncer
+	d.mu.Lock()
+	if d.pending[key] != p {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.pending, key)
+	p.timer.Stop()
+	d.mu.Unlock()
+
+	h := p.latest
+	merged := mergeChanges(p.first, h.n)
+	if merged == nil {
+		dl.metrics.Dropped(h.n.Channel, DropCoalesced)
+		dl.tenantDropped(h.n, DropCoalesced)
+		h.ack()
+		dl.inflight.Done()
+		return
+	}
+	scheduled := dl.schedule(h.ctx, h.n, func(context.Context) {
+		defer dl.inflight.Done()
+		failure := h.redeliver(func(ctx context.Context) *Failure { return dl.deliver(ctx, merged, reg) })
+		if failure != nil {
+			dl.fail(h.ctx, failure)
+		}
+	})
+	if !scheduled {
+		dl.inflight.Done()
+	}
+}
+
+// Flush ends every debounce window now, handing the merged changes over
+// for delivery instead of waiting for the windows to end. Without workers
+// they are delivered on the receive loop, so a handler must not call it
+// then.
+func (dl *DataListener) Flush() {
+	for _, set := range dl.handlerSets() {
+		for _, reg := range set.named() {
+			d := reg.debouncer
+			if d == nil {
+				continue
+			}
+			d.mu.Lock()
+			pending := make(map[string]*debounced, len(d.pending))
+			for key, p := range d.pending {
+				pending[key] = p
+			}
+			d.mu.Unlock()
+			for key, p := range pending {
+				dl.flushDebounced(reg, key, p)
+			}
+		}
+	}
+}
+
+// mergeChanges returns the net change of a row from its first and latest
+// change, or nil when there is none.
+func mergeChanges(first, latest *ChangeNotification) *ChangeNotification {
+	if first == latest {
+		return latest
+	}
+	merged := *latest
+	switch {
+	case first.Operation == OpInsert && latest.Operation == OpDelete:
+		return nil
+	case first.Operation == OpInsert:
+		merged.Operation = OpInsert
+		merged.Old = nil
+	case latest.Operation != OpDelete:
+		merged.Operation = OpUpdate
+		merged.Old = first.Old
+	}
+	return &merged
+}
@@ -0,0 +1,115 @@
+package listener
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		// want are the operation and v of the merged change, if any.
+		wantOp string
+		wantV  float64
+	}{
+		{
+			name:   "single change",
+			events: []string{payload(OpInsert, 1, `,"v":1`)},
+			wantOp: OpInsert,
+			wantV:  1,
+		},
+		{
+			name:   "insert then updates",
+			events: []string{payload(OpInsert, 1, `,"v":1`), payload(OpUpdate, 1, `,"v":2`), payload(OpUpdate, 1, `,"v":3`)},
+			wantOp: OpInsert,
+			wantV:  3,
+		},
+		{
+			name:   "updates",
+			events: []string{payload(OpUpdate, 1, `,"v":1`), payload(OpUpdate, 1, `,"v":2`)},
+			wantOp: OpUpdate,
+			wantV:  2,
+		},
+		{
+			name:   "update then delete",
+			events: []string{payload(OpUpdate, 1, `,"v":1`), payload(OpDelete, 1, `,"v":2`)},
+			wantOp: OpDelete,
+			wantV:  2,
+		},
+		{
+			name:   "insert then delete",
+			events: []string{payload(OpInsert, 1, `,"v":1`), payload(OpDelete, 1, `,"v":1`)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl, capture := newTestListener(t)
+			runScheduled(t, dl)
+			h := &handled{}
+			dl.Handle("users", h, WithDebounce(time.Hour))
+
+			ctx := context.Background()
+			var ids []int64
+			for i, ev := range tt.events {
+				id := int64(i + 1)
+				ids = append(ids, id)
+				if err := dl.handleNotification(ctx, DefaultChannel, ev, id); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The replaced changes are acknowledged, the latest is held
+			// in flight until the window ends.
+			if got := capture.ids(); !slices.Equal(got, ids[:len(ids)-1]) {
+				t.Errorf("acked %v before the window ended, want %v", got, ids[:len(ids)-1])
+			}
+			if idle(dl, 20*time.Millisecond) {
+				t.Fatal("debounced notification not in flight")
+			}
+
+			dl.Flush()
+			if !idle(dl, time.Second) {
+				t.Fatal("notification still in flight after flush")
+			}
+			if got := capture.ids(); !slices.Equal(got, ids) {
+				t.Errorf("acked %v, want %v", got, ids)
+			}
+			ops := h.operations()
+			if tt.wantOp == "" {
+				if len(ops) != 0 {
+					t.Errorf("handled %v, want nothing", ops)
+				}
+				return
+			}
+			if !slices.Equal(ops, []string{tt.wantOp}) {
+				t.Fatalf("handled %v, want [%s]", ops, tt.wantOp)
+			}
+			if v := decodeData(t, h.ns[0])["v"]; v != tt.wantV {
+				t.Errorf("handled v = %v, want %v", v, tt.wantV)
+			}
+		})
+	}
+}
+
+func TestDebounceAfterStop(t *testing.T) {
+	dl, capture := newTestListener(t)
+	h := &handled{}
+	dl.Handle("users", h, WithDebounce(time.Hour))
+
+	if err := dl.handleNotification(context.Background(), DefaultChannel, payload(OpUpdate, 1, `,"v":1`), 1); err != nil {
+		t.Fatal(err)
+	}
+	dl.stopOnce.Do(func() { close(dl.stop) })
+	dl.Flush()
+	if !idle(dl, time.Second) {
+		t.Fatal("notification still in flight after stop")
+	}
+	if got := capture.ids(); len(got) != 0 {
+		t.Errorf("acked %v, want the held change left unacknowledged", got)
+	}
+	if ops := h.operations(); len(ops) != 0 {
+		t.Errorf("handled %v after stop", ops)
+	}
+}
@@ -0,0 +1,116 @@
+package listener
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDedupWindow = 5 * time.Minute
+	DefaultDedupSize   = 10000
+
+	DropDuplicate = "duplicate"
+)
+
+// DedupConfig drops notifications whose idempotency key was already seen
+// within the window. At most Size keys are remembered.
+type DedupConfig struct {
+	Window time.Duration
+	Size   int
+	// Key defaults to IdempotencyKey.
+	Key func(n *ChangeNotification) string
+}
+
+// IdempotencyKey identifies a change: by its event id or sequence number
+// when it has one, and otherwise by table, operation, key, transaction id,
+// timestamp and a digest of the row images.
+func IdempotencyKey(n *ChangeNotification) string {
+	if n.ID != 0 {
+		return "id:" + strconv.FormatInt(n.ID, 10)
+	}
+	if n.Seq != 0 {
+		return "seq:" + n.Channel + ":" + strconv.FormatInt(n.Seq, 10)
+	}
+	h := sha256.New()
+	h.Write(n.Data)
+	h.Write([]byte{0})
+	h.Write(n.Old)
+	return n.QualifiedTable() + ":" + n.Operation + ":" + string(n.Key) + ":" +
+		strconv.FormatInt(n.TxID, 10) + ":" + n.Timestamp.Format(time.RFC3339Nano) + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+type dedupEntry struct {
+	key  string
+	seen time.Time
+}
+
+// dedup remembers recently seen keys, oldest first.
+type dedup struct {
+	cfg DedupConfig
+
+	mu    sync.Mutex
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+func newDedup(cfg DedupConfig) *dedup {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultDedupWindow
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultDedupSize
+	}
+	if cfg.Key == nil {
+		cfg.Key = IdempotencyKey
+	}
+	return &dedup{cfg: cfg, order: list.New(), keys: make(map[string]*list.Element)}
+}
+
+// seen records n and reports whether its key was already recorded.
+func (d *dedup) seen(n *ChangeNotification) bool {
+	key := d.cfg.Key(n)
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for e := d.order.Front(); e != nil; e = d.order.Front() {
+		if entry := e.Value.(*dedupEntry); now.Sub(entry.seen) < d.cfg.Window {
+			break
+		}
+		d.evict(e)
+	}
+
+	if _, ok := d.keys[key]; ok {
+		return true
+	}
+	// Only make room once key is known to be new, so the Size latest keys
+	// are all remembered.
+	for d.order.Len() >= d.cfg.Size {
+		d.evict(d.order.Front())
+	}
+	d.keys[key] = d.order.PushBack(&dedupEntry{key: key, seen: now})
+	return false
+}
+
+func (d *dedup) evict(e *list.Element) {
+	d.order.Remove(e)
+	delete(d.keys, e.Value.(*dedupEntry).key)
+}
+
+// duplicate reports whether n was already delivered within the dedup
+// window, counting it as dropped.
+func (dl *DataListener) duplicate(n *ChangeNotification) bool {
+	if dl.dedup == nil || !dl.dedup.seen(n) {
+		return false
+	}
+	dl.logger.Debug("dropping duplicate notification",
+		"channel", n.Channel, "table", n.Table, "operation", n.Operation)
+	dl.metrics.Dropped(n.Channel, DropDuplicate)
+	dl.tenantDropped(n, DropDuplicate)
+	return true
+}
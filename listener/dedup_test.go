@@ -0,0 +1,83 @@
+package listener
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	ts := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	base := ChangeNotification{Channel: "events", Schema: "public", Table: "users", Operation: OpUpdate,
+		Key: json.RawMessage(`{"id":1}`), Data: json.RawMessage(`{"id":1,"v":1}`), TxID: 9, Timestamp: ts}
+	with := func(fn func(n *ChangeNotification)) ChangeNotification {
+		n := base
+		fn(&n)
+		return n
+	}
+	tests := []struct {
+		name string
+		a, b ChangeNotification
+		same bool
+	}{
+		{name: "identical", a: base, b: base, same: true},
+		{name: "same id", a: with(func(n *ChangeNotification) { n.ID = 5 }), b: with(func(n *ChangeNotification) { n.ID = 5; n.Table = "other" }), same: true},
+		{name: "different id", a: with(func(n *ChangeNotification) { n.ID = 5 }), b: with(func(n *ChangeNotification) { n.ID = 6 })},
+		{name: "same seq", a: with(func(n *ChangeNotification) { n.Seq = 3 }), b: with(func(n *ChangeNotification) { n.Seq = 3; n.TxID = 10 }), same: true},
+		{name: "seq of other channel", a: with(func(n *ChangeNotification) { n.Seq = 3 }), b: with(func(n *ChangeNotification) { n.Seq = 3; n.Channel = "other" })},
+		{name: "different data", a: base, b: with(func(n *ChangeNotification) { n.Data = json.RawMessage(`{"id":1,"v":2}`) })},
+		{name: "different transaction", a: base, b: with(func(n *ChangeNotification) { n.TxID = 10 })},
+		{name: "different operation", a: base, b: with(func(n *ChangeNotification) { n.Operation = OpDelete })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := IdempotencyKey(&tt.a) == IdempotencyKey(&tt.b); same != tt.same {
+				t.Errorf("same key = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func TestDedup(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  DedupConfig
+		// keys are seen in order, sleeping for the gap before each.
+		keys []string
+		gap  time.Duration
+		want []bool
+	}{
+		{
+			name: "repeated key",
+			keys: []string{"a", "b", "a", "b", "c"},
+			want: []bool{false, false, true, true, false},
+		},
+		{
+			name: "size evicts oldest",
+			cfg:  DedupConfig{Size: 2},
+			keys: []string{"a", "b", "c", "a", "c"},
+			want: []bool{false, false, false, false, true},
+		},
+		{
+			name: "window expires",
+			cfg:  DedupConfig{Window: 5 * time.Millisecond},
+			keys: []string{"a", "a"},
+			gap:  20 * time.Millisecond,
+			want: []bool{false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Key = func(n *ChangeNotification) string { return n.Table }
+			d := newDedup(tt.cfg)
+			for i, key := range tt.keys {
+				if i > 0 {
+					time.Sleep(tt.gap)
+				}
+				if got := d.seen(&ChangeNotification{Table: key}); got != tt.want[i] {
+					t.Errorf("seen %d (%s) = %v, want %v", i, key, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lib/pq"
+)
+
+// Driver selects the client library of the LISTEN connection. The query
+// connection pool and the replication stream are not affected.
+type Driver int
+
+const (
+	// DriverPGX listens with pgx, waiting on Conn.WaitForNotification.
+	DriverPGX Driver = iota
+	// DriverPQ listens with lib/pq's Listener.
+	DriverPQ
+)
+
+func (d Driver) String() string {
+	switch d {
+	case DriverPGX:
+		return "pgx"
+	case DriverPQ:
+		return "pq"
+	default:
+		return fmt.Sprintf("Driver(%d)", int(d))
+	}
+}
+
+// WithDriver selects the client library of the LISTEN connection;
+// DriverPGX by default.
+func WithDriver(d Driver) Option {
+	return func(dl *DataListener) {
+		dl.driver = d
+	}
+}
+
+var errConnClosed = errors.New("listen connection closed")
+
+// notifyConn is a LISTEN connection that reconnects by itself, with a
+// backoff between the minimum and maximum reconnect intervals. Listening
+// on a channel already listened on, or unlistening one that is not, is not
+// an error. Notifications delivers nil after the connection was
+// re-established, since anything sent meanwhile was lost.
+type notifyConn interface {
+	Listen(channel string) error
+	Unlisten(channel string) error
+	UnlistenAll() error
+	Ping() error
+	Notifications() <-chan *notification
+	Close() error
+}
+
+type notification struct {
+	channel string
+	payload string
+}
+
+// connEvent is a change in the state of the LISTEN connection.
+type connEvent int
+
+const (
+	eventConnected connEvent = iota
+	eventDisconnected
+	eventReconnected
+	eventConnectionAttemptFailed
+)
+
+func (ev connEvent) String() string {
+	switch ev {
+	case eventConnected:
+		return "connected"
+	case eventDisconnected:
+		return "disconnected"
+	case eventReconnected:
+		return "reconnected"
+	case eventConnectionAttemptFailed:
+		return "connection attempt failed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(ev))
+	}
+}
+
+type eventFunc func(ev connEvent, err error)
+
+// validate checks connStr the way the driver will parse it.
+func (d Driver) validate(connStr string) error {
+	switch d {
+	case DriverPGX:
+		if _, err := pgx.ParseConfig(connStr); err != nil {
+			return fmt.Errorf("invalid connection string: %w", err)
+		}
+		return nil
+	case DriverPQ:
+		_, err := pqListenConnString(connStr)
+		return err
+	default:
+		return fmt.Errorf("unknown driver %s", d)
+	}
+}
+
+// open starts connecting a notifyConn with the driver.
+func (d Driver) open(connStr string, opts connOptions, minReconnect, maxReconnect time.Duration, event eventFunc) (notifyConn, error) {
+	switch d {
+	case DriverPGX:
+		return newPGXConn(connStr, opts, minReconnect, maxReconnect, event)
+	case DriverPQ:
+		return newPQConn(connStr, opts.tls, minReconnect, maxReconnect, event)
+	default:
+		return nil, fmt.Errorf("unknown driver %s", d)
+	}
+}
+
+// pqListenConnString validates connStr for lib/pq's Listener, which
+// reconnects by itself to a single host.
+func pqListenConnString(connStr string) (string, error) {
+	conns, attrs, err := hostConnStrings(connStr)
+	if err != nil {
+		return "", err
+	}
+	if len(conns) > 1 || (attrs != "" && attrs != TargetSessionAny) {
+		return "", fmt.Errorf("several hosts and target_session_attrs require the %s driver", DriverPGX)
+	}
+	if _, err := parseConnString(conns[0]); err != nil {
+		return "", err
+	}
+	return conns[0], nil
+}
+
+// pqConn adapts a pq.Listener to notifyConn.
+type pqConn struct {
+	listener  *pq.Listener
+	notify    chan *notification
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var pqEvents = map[pq.ListenerEventType]connEvent{
+	pq.ListenerEventConnected:               eventConnected,
+	pq.ListenerEventDisconnected:            eventDisconnected,
+	pq.ListenerEventReconnected:             eventReconnected,
+	pq.ListenerEventConnectionAttemptFailed: eventConnectionAttemptFailed,
+}
+
+func newPQConn(connStr string, t *clientTLS, minReconnect, maxReconnect time.Duration, event eventFunc) (*pqConn, error) {
+	callback := func(ev pq.ListenerEventType, err error) {
+		event(pqEvents[ev], err)
+	}
+	connStr, err := pqListenConnString(connStr)
+	if err != nil {
+		return nil, err
+	}
+	var listener *pq.Listener
+	if t != nil {
+		if connStr, err = (connOptions{tls: t}).pqConnString(connStr); err != nil {
+			return nil, err
+		}
+		listener = pq.NewDialListener(tlsDialer{tls: t}, connStr, minReconnect, maxReconnect, callback)
+	} else {
+		listener = pq.NewListener(connStr, minReconnect, maxReconnect, callback)
+	}
+	c := &pqConn{
+		listener: listener,
+		notify:   make(chan *notification, 32),
+		closed:   make(chan struct{}),
+	}
+	go c.forward()
+	return c, nil
+}
+
+// forward converts the pq notifications until the listener is closed.
+func (c *pqConn) forward() {
+	for n := range c.listener.Notify {
+		var out *notification
+		if n != nil {
+			out = &notification{channel: n.Channel, payload: n.Extra}
+		}
+		select {
+		case c.notify <- out:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *pqConn) Listen(channel string) error {
+	if err := c.listener.Listen(channel); err != nil && !errors.Is(err, pq.ErrChannelAlreadyOpen) {
+		return err
+	}
+	return nil
+}
+
+func (c *pqConn) Unlisten(channel string) error {
+	if err := c.listener.Unlisten(channel); err != nil && !errors.Is(err, pq.ErrChannelNotOpen) {
+		return err
+	}
+	return nil
+}
+
+func (c *pqConn) UnlistenAll() error {
+	if err := c.listener.UnlistenAll(); err != nil && !errors.Is(err, pq.ErrChannelNotOpen) {
+		return err
+	}
+	return nil
+}
+
+func (c *pqConn) Ping() error {
+	return c.listener.Ping()
+}
+
+func (c *pqConn) Notifications() <-chan *notification {
+	return c.notify
+}
+
+func (c *pqConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.listener.Close()
+}
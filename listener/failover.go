@@ -0,0 +1,261 @@
+package listener
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// The target_session_attrs values of a connection string, which selects
+// the server among several hosts, e.g.
+// postgres://pg-a,pg-b/app?target_session_attrs=read-write.
+const (
+	TargetSessionAny           = "any"
+	TargetSessionReadWrite     = "read-write"
+	TargetSessionReadOnly      = "read-only"
+	TargetSessionPrimary       = "primary"
+	TargetSessionStandby       = "standby"
+	TargetSessionPreferStandby = "prefer-standby"
+)
+
+// TargetSessionAttrs lists the supported target_session_attrs values.
+var TargetSessionAttrs = []string{
+	TargetSessionAny,
+	TargetSessionReadWrite,
+	TargetSessionReadOnly,
+	TargetSessionPrimary,
+	TargetSessionStandby,
+	TargetSessionPreferStandby,
+}
+
+// sessionChecks holds the query telling whether a server satisfies a
+// target_session_attrs value and the result it must return, as libpq
+// checks them.
+var sessionChecks = map[string]struct{ query, want string }{
+	TargetSessionReadWrite: {"SHOW transaction_read_only", "off"},
+	TargetSessionReadOnly:  {"SHOW transaction_read_only", "on"},
+	TargetSessionPrimary:   {"SELECT pg_is_in_recovery()::text", "false"},
+	TargetSessionStandby:   {"SELECT pg_is_in_recovery()::text", "true"},
+}
+
+// hostConnStrings returns a connection string per host of connStr, in
+// order, with target_session_attrs removed and returned, for lib/pq, which
+// connects to a single host and does not know the setting. Without several
+// hosts or the setting, connStr is returned as is. With several hosts and
+// no target_session_attrs, the connections require a read-write server:
+// the LISTEN connection, the replication stream and the checkpoints all
+// need the primary, and it is the one left after a failover.
+func hostConnStrings(connStr string) ([]string, string, error) {
+	var conns []string
+	var attrs string
+	var err error
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		conns, attrs, err = urlHosts(connStr)
+	} else {
+		conns, attrs, err = keyValueHosts(connStr)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid connection string: %w", err)
+	}
+	if attrs != "" && !slices.Contains(TargetSessionAttrs, attrs) {
+		return nil, "", fmt.Errorf("invalid connection string: unknown target_session_attrs %q", attrs)
+	}
+	if attrs == "" && len(conns) > 1 {
+		attrs = TargetSessionReadWrite
+	}
+	return conns, attrs, nil
+}
+
+func urlHosts(connStr string) ([]string, string, error) {
+	u, err := url.Parse(connStr)
+	if err != nil {
+		return nil, "", err
+	}
+	query := u.Query()
+	attrs := query.Get("target_session_attrs")
+	hosts := strings.Split(u.Host, ",")
+	if len(hosts) == 1 && !query.Has("target_session_attrs") {
+		return []string{connStr}, "", nil
+	}
+	query.Del("target_session_attrs")
+
+	conns := make([]string, len(hosts))
+	for i, host := range hosts {
+		hu := *u
+		hu.Host = host
+		hu.RawQuery = query.Encode()
+		conns[i] = hu.String()
+	}
+	return conns, attrs, nil
+}
+
+func keyValueHosts(connStr string) ([]string, string, error) {
+	pairs, err := parseKeyValues(connStr)
+	if err != nil {
+		return nil, "", err
+	}
+	var hosts, ports, rest []string
+	var attrs string
+	hasAttrs := false
+	for _, p := range pairs {
+		switch p[0] {
+		case "host":
+			hosts = strings.Split(p[1], ",")
+		case "port":
+			ports = strings.Split(p[1], ",")
+		case "target_session_attrs":
+			attrs, hasAttrs = p[1], true
+		default:
+			rest = append(rest, p[0]+"="+quoteValue(p[1]))
+		}
+	}
+	if len(hosts) <= 1 && len(ports) <= 1 && !hasAttrs {
+		return []string{connStr}, "", nil
+	}
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	if len(ports) > 1 && len(ports) != len(hosts) {
+		return nil, "", fmt.Errorf("%d ports given for %d hosts", len(ports), len(hosts))
+	}
+
+	conns := make([]string, len(hosts))
+	for i, host := range hosts {
+		kv := slices.Clone(rest)
+		if host != "" {
+			kv = append(kv, "host="+quoteValue(host))
+		}
+		switch {
+		case len(ports) == 1:
+			kv = append(kv, "port="+quoteValue(ports[0]))
+		case len(ports) > 1:
+			kv = append(kv, "port="+quoteValue(ports[i]))
+		}
+		conns[i] = strings.Join(kv, " ")
+	}
+	return conns, attrs, nil
+}
+
+// parseKeyValues splits a key/value connection string into its settings,
+// unquoting and unescaping the values as libpq does.
+func parseKeyValues(s string) ([][2]string, error) {
+	const space = " \t\n\r\v\f"
+	var pairs [][2]string
+	for {
+		s = strings.TrimLeft(s, space)
+		if s == "" {
+			return pairs, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("missing \"=\" after %q", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], space)
+
+		var value strings.Builder
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			s = s[1:]
+		}
+		for {
+			if s == "" {
+				if quoted {
+					return nil, errors.New("unterminated quoted string")
+				}
+				break
+			}
+			c := s[0]
+			if !quoted && strings.IndexByte(space, c) >= 0 {
+				break
+			}
+			s = s[1:]
+			if quoted && c == '\'' {
+				break
+			}
+			if c == '\\' && s != "" {
+				c, s = s[0], s[1:]
+			}
+			value.WriteByte(c)
+		}
+		pairs = append(pairs, [2]string{key, value.String()})
+	}
+}
+
+// hostAddr returns the host and port of a key/value connection string.
+func hostAddr(kv string) string {
+	pairs, _ := parseKeyValues(kv)
+	host, port := "localhost", "5432"
+	for _, p := range pairs {
+		switch p[0] {
+		case "host":
+			host = p[1]
+		case "port":
+			port = p[1]
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// checkSessionAttrs reports an error when the server of conn does not
+// satisfy the target_session_attrs value attrs.
+func checkSessionAttrs(ctx context.Context, conn driver.Conn, attrs string) error {
+	check, ok := sessionChecks[attrs]
+	if !ok {
+		return nil
+	}
+	q, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return fmt.Errorf("target_session_attrs %s cannot be checked", attrs)
+	}
+	rows, err := q.QueryContext(ctx, check.query, nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return err
+	}
+	var got string
+	switch v := dest[0].(type) {
+	case string:
+		got = v
+	case []byte:
+		got = string(v)
+	}
+	if got != check.want {
+		return fmt.Errorf("server is not %s", attrs)
+	}
+	return nil
+}
+
+// sessionPasses returns the target_session_attrs of the passes over the
+// hosts: prefer-standby tries the standbys first, then any server.
+func sessionPasses(attrs string) []string {
+	if attrs == TargetSessionPreferStandby {
+		return []string{TargetSessionStandby, TargetSessionAny}
+	}
+	return []string{attrs}
+}
+
+// requireSessionAttrs makes a pgx connection to several hosts require a
+// read-write server unless connStr sets target_session_attrs, as
+// hostConnStrings does for lib/pq.
+func requireSessionAttrs(cfg *pgconn.Config, connStr string) error {
+	conns, attrs, err := hostConnStrings(connStr)
+	if err != nil {
+		return err
+	}
+	if len(conns) > 1 && attrs == TargetSessionReadWrite && cfg.ValidateConnect == nil {
+		cfg.ValidateConnect = pgconn.ValidateConnectTargetSessionAttrsReadWrite
+	}
+	return nil
+}
@@ -0,0 +1,1296 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// DropFiltered counts notifications a handler's filter did not match.
+const DropFiltered = "filtered"
+
+// WithFilter only passes the handler the notifications f matches. The
+// others are acknowledged without calling it.
+func WithFilter(f *Filter) HandlerOption {
+	return func(r *registration) {
+		r.filter = f
+	}
+}
+
+// filter reports whether the handler's filter matches n. An expression
+// failing to evaluate, e.g. on a column missing from the row, does not
+// match.
+func (dl *DataListener) filter(n *ChangeNotification, reg *registration) bool {
+	ok, err := reg.filter.Match(n)
+	if err != nil {
+		dl.logger.Debug("filter failed to evaluate",
+			"channel", n.Channel, "table", n.Table, "filter", reg.filter.String(), "error", err)
+	}
+	if ok {
+		return true
+	}
+	dl.metrics.Dropped(n.Channel, DropFiltered)
+	dl.tenantDropped(n, DropFiltered)
+	return false
+}
+
+// Filter is a filter expression, an Expr evaluating to a bool:
+//
+//	data.status == "active" && operation != "DELETE"
+//	old.price != new.price || table in ["orders", "refunds"]
+//	has(data.email) && data.email.endsWith("@example.com")
+type Filter struct {
+	expr *Expr
+}
+
+// CompileFilter parses a filter expression.
+func CompileFilter(expr string) (*Filter, error) {
+	e, err := CompileExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{expr: e}, nil
+}
+
+func (f *Filter) String() string { return f.expr.String() }
+
+// Match evaluates the filter on n.
+func (f *Filter) Match(n *ChangeNotification) (bool, error) {
+	v, err := f.expr.Eval(n)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter evaluates to %s, not bool", celType(v))
+	}
+	return b, nil
+}
+
+// Expr is a compiled expression in a subset of CEL, the Common Expression
+// Language. The variables are operation, schema, table, channel, tenant,
+// id, seq, txid, ref and snapshot, and the rows data, old, new and key as
+// maps. It supports the literals, the logical, relational (including in)
+// and arithmetic operators, ?:, field and index access, has(), size(),
+// int(), double(), string(), the string methods contains, startsWith,
+// endsWith, matches, lowerAscii and upperAscii, and the macros all,
+// exists, exists_one, map and filter on lists and maps.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// exprVars are the variables of expressions.
+var exprVars = []string{"operation", "schema", "table", "channel", "tenant", "actor", "id", "seq", "txid", "ref", "snapshot", "data", "old", "new", "key"}
+
+// CompileExpr parses an expression.
+func CompileExpr(expr string) (*Expr, error) {
+	return compileExpr(expr)
+}
+
+// compileExpr parses an expression that may also refer to vars, which
+// the caller binds with evalWith.
+func compileExpr(expr string, vars ...string) (*Expr, error) {
+	p := &exprParser{lex: exprLexer{src: expr}, bound: vars}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return &Expr{src: expr, root: root}, nil
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Eval evaluates the expression on n. Integers are int64, other numbers
+// float64, lists []any and maps map[string]any.
+func (e *Expr) Eval(n *ChangeNotification) (any, error) {
+	return e.root.eval(&exprEnv{n: n})
+}
+
+func (e *Expr) evalWith(env *exprEnv) (any, error) {
+	return e.root.eval(env)
+}
+
+// exprEnv resolves variables, decoding the rows on first use.
+type exprEnv struct {
+	n     *ChangeNotification
+	rows  map[string]any
+	scope []exprBinding
+}
+
+type exprBinding struct {
+	name  string
+	value any
+}
+
+func (e *exprEnv) lookup(name string) (any, error) {
+	for i := len(e.scope) - 1; i >= 0; i-- {
+		if e.scope[i].name == name {
+			return e.scope[i].value, nil
+		}
+	}
+	n := e.n
+	switch name {
+	case "operation":
+		return n.Operation, nil
+	case "schema":
+		if n.Schema == "" {
+			return DefaultSchema, nil
+		}
+		return n.Schema, nil
+	case "table":
+		return n.Table, nil
+	case "channel":
+		return n.Channel, nil
+	case "tenant":
+		return n.Tenant, nil
+	case "actor":
+		return n.Actor, nil
+	case "id":
+		return n.ID, nil
+	case "seq":
+		return n.Seq, nil
+	case "txid":
+		return n.TxID, nil
+	case "ref":
+		return n.Ref, nil
+	case "snapshot":
+		return n.Snapshot, nil
+	}
+
+	if v, ok := e.rows[name]; ok {
+		return v, nil
+	}
+	var raw json.RawMessage
+	switch name {
+	case "data":
+		raw = n.Data
+	case "old":
+		raw = n.Old
+	case "new":
+		raw = n.New
+	case "key":
+		raw = n.Key
+	default:
+		return nil, fmt.Errorf("undeclared reference to %q", name)
+	}
+	var v any
+	if !isNull(raw) {
+		doc, err := decodeJSON(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		v = exprValue(doc)
+	}
+	if e.rows == nil {
+		e.rows = make(map[string]any)
+	}
+	e.rows[name] = v
+	return v, nil
+}
+
+// exprValue converts the numbers of a decoded JSON document to int64
+// where they are integers and float64 otherwise.
+func exprValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i := range v {
+			v[i] = exprValue(v[i])
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = exprValue(v[k])
+		}
+	}
+	return v
+}
+
+func celType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	pos  int
+	val  any
+}
+
+type exprLexer struct {
+	src string
+	pos int
+}
+
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "?", ":", ".", "(", ")", "[", "]", ","}
+
+func (l *exprLexer) next() (exprToken, error) {
+	for l.pos < len(l.src) && strings.ContainsRune(" \t\r\n", rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return exprToken{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for l.pos < len(l.src) && isIdentByte(l.src[l.pos]) {
+			l.pos++
+		}
+		return exprToken{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	case c >= '0' && c <= '9':
+		return l.number()
+	case c == '"' || c == '\'':
+		return l.string()
+	}
+	for _, op := range exprOps {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return exprToken{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return exprToken{}, fmt.Errorf("filter: unexpected character %q at %d", c, start)
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (l *exprLexer) number() (exprToken, error) {
+	start := l.pos
+	digits := func() {
+		for l.pos < len(l.src) && l.src[l.pos] >= '0' && l.src[l.pos] <= '9' {
+			l.pos++
+		}
+	}
+	digits()
+	float := false
+	if l.pos+1 < len(l.src) && l.src[l.pos] == '.' && l.src[l.pos+1] >= '0' && l.src[l.pos+1] <= '9' {
+		float = true
+		l.pos++
+		digits()
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		float = true
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		digits()
+	}
+	text := l.src[start:l.pos]
+	if float {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return exprToken{}, fmt.Errorf("filter: invalid number %q", text)
+		}
+		return exprToken{kind: tokFloat, text: text, pos: start, val: f}, nil
+	}
+	i, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return exprToken{}, fmt.Errorf("filter: invalid number %q", text)
+	}
+	return exprToken{kind: tokInt, text: text, pos: start, val: i}, nil
+}
+
+func (l *exprLexer) string() (exprToken, error) {
+	start := l.pos
+	quote := l.src[l.pos]
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return exprToken{}, fmt.Errorf("filter: unterminated string at %d", start)
+		}
+		c := l.src[l.pos]
+		l.pos++
+		switch {
+		case c == quote:
+			return exprToken{kind: tokString, text: l.src[start:l.pos], pos: start, val: b.String()}, nil
+		case c == '\\':
+			if l.pos >= len(l.src) {
+				return exprToken{}, fmt.Errorf("filter: unterminated string at %d", start)
+			}
+			e := l.src[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			default:
+				return exprToken{}, fmt.Errorf("filter: invalid escape \\%c at %d", e, l.pos-2)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// exprMaxDepth bounds the nesting of parsed expressions.
+const exprMaxDepth = 100
+
+type exprParser struct {
+	lex   exprLexer
+	tok   exprToken
+	depth int
+	// bound are the comprehension variables in scope.
+	bound []string
+}
+
+func (p *exprParser) next() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("filter: %s at %d", fmt.Sprintf(format, args...), p.tok.pos)
+}
+
+func (p *exprParser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *exprParser) expect(op string) error {
+	if !p.isOp(op) {
+		if p.tok.kind == tokEOF {
+			return p.errorf("expected %q", op)
+		}
+		return p.errorf("expected %q, got %q", op, p.tok.text)
+	}
+	return p.next()
+}
+
+func (p *exprParser) expr() (exprNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > exprMaxDepth {
+		return nil, p.errorf("expression nested too deeply")
+	}
+	c, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp("?") {
+		return c, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	t, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	f, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{c, t, f}, nil
+}
+
+// exprLevels are the binary operators by increasing precedence.
+var exprLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (exprNode, error) {
+	if level == len(exprLevels) {
+		return p.unary()
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.tok.text
+		if !(p.tok.kind == tokOp || p.tok.kind == tokIdent && op == "in") || !slices.Contains(exprLevels[level], op) {
+			return l, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op, l, r}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if p.isOp("!") || p.isOp("-") {
+		op := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > exprMaxDepth {
+			return nil, p.errorf("expression nested too deeply")
+		}
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := x.(*literalNode); ok && op == "-" {
+			switch v := lit.v.(type) {
+			case int64:
+				return &literalNode{-v}, nil
+			case float64:
+				return &literalNode{-v}, nil
+			}
+		}
+		return &unaryNode{op, x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *exprParser) postfix() (exprNode, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokIdent {
+				return nil, p.errorf("expected field name")
+			}
+			name := p.tok.text
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.isOp("(") {
+				if x, err = p.method(x, name); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			x = &memberNode{x, name}
+		case p.isOp("["):
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			i, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexNode{x, i}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt, tokFloat, tokString:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return &literalNode{tok.val}, nil
+	case tokIdent:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		switch tok.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "null":
+			return &literalNode{nil}, nil
+		}
+		if p.isOp("(") {
+			return p.function(tok)
+		}
+		if !slices.Contains(p.bound, tok.text) && !slices.Contains(exprVars, tok.text) {
+			return nil, fmt.Errorf("filter: undeclared reference to %q at %d", tok.text, tok.pos)
+		}
+		return &identNode{tok.text}, nil
+	case tokOp:
+		switch tok.text {
+		case "(":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			items, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items}, nil
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+// list parses comma-separated expressions up to end, which it consumes.
+func (p *exprParser) list(end string) ([]exprNode, error) {
+	var items []exprNode
+	for !p.isOp(end) {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, x)
+		if !p.isOp(",") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	return items, p.expect(end)
+}
+
+var filterFunctions = map[string]int{"size": 1, "int": 1, "double": 1, "string": 1}
+
+func (p *exprParser) function(name exprToken) (exprNode, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	args, err := p.list(")")
+	if err != nil {
+		return nil, err
+	}
+	if name.text == "has" {
+		m, ok := singleMember(args)
+		if !ok {
+			return nil, fmt.Errorf("filter: has() requires a field selection at %d", name.pos)
+		}
+		return &hasNode{m.x, m.name}, nil
+	}
+	n, ok := filterFunctions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("filter: undeclared function %q at %d", name.text, name.pos)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("filter: %s() takes %d argument(s) at %d", name.text, n, name.pos)
+	}
+	return &callNode{name.text, nil, args, nil}, nil
+}
+
+func singleMember(args []exprNode) (*memberNode, bool) {
+	if len(args) != 1 {
+		return nil, false
+	}
+	m, ok := args[0].(*memberNode)
+	return m, ok
+}
+
+var filterMethods = map[string]int{
+	"contains": 1, "startsWith": 1, "endsWith": 1, "matches": 1,
+	"lowerAscii": 0, "upperAscii": 0, "size": 0,
+}
+
+var filterMacros = []string{"all", "exists", "exists_one", "map", "filter"}
+
+func (p *exprParser) method(recv exprNode, name string) (exprNode, error) {
+	pos := p.tok.pos
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if slices.Contains(filterMacros, name) {
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf("%s() requires a variable name", name)
+		}
+		v := p.tok.text
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		p.bound = append(p.bound, v)
+		body, err := p.expr()
+		p.bound = p.bound[:len(p.bound)-1]
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &comprehensionNode{name, recv, v, body}, nil
+	}
+
+	args, err := p.list(")")
+	if err != nil {
+		return nil, err
+	}
+	n, ok := filterMethods[name]
+	if !ok {
+		return nil, fmt.Errorf("filter: undeclared method %q at %d", name, pos)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("filter: %s() takes %d argument(s) at %d", name, n, pos)
+	}
+	c := &callNode{name, recv, args, nil}
+	if name == "matches" {
+		if lit, ok := args[0].(*literalNode); ok {
+			s, ok := lit.v.(string)
+			if !ok {
+				return nil, fmt.Errorf("filter: matches() requires a string at %d", pos)
+			}
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, fmt.Errorf("filter: invalid regular expression at %d: %w", pos, err)
+			}
+			c.re = re
+		}
+	}
+	return c, nil
+}
+
+type exprNode interface {
+	eval(env *exprEnv) (any, error)
+}
+
+type literalNode struct{ v any }
+
+func (n *literalNode) eval(*exprEnv) (any, error) { return n.v, nil }
+
+type identNode struct{ name string }
+
+func (n *identNode) eval(env *exprEnv) (any, error) { return env.lookup(n.name) }
+
+type listNode struct{ items []exprNode }
+
+func (n *listNode) eval(env *exprEnv) (any, error) {
+	out := make([]any, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+type memberNode struct {
+	x    exprNode
+	name string
+}
+
+func (n *memberNode) eval(env *exprEnv) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := x.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot select field %q of %s", n.name, celType(x))
+	}
+	v, ok := m[n.name]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", n.name)
+	}
+	return v, nil
+}
+
+type hasNode struct {
+	x    exprNode
+	name string
+}
+
+func (n *hasNode) eval(env *exprEnv) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case map[string]any:
+		_, ok := x[n.name]
+		return ok, nil
+	case nil:
+		return false, nil
+	}
+	return nil, fmt.Errorf("cannot select field %q of %s", n.name, celType(x))
+}
+
+type indexNode struct{ x, i exprNode }
+
+func (n *indexNode) eval(env *exprEnv) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.i.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case map[string]any:
+		k, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot index map with %s", celType(i))
+		}
+		v, ok := x[k]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", k)
+		}
+		return v, nil
+	case []any:
+		idx, ok := toInt(i)
+		if !ok {
+			return nil, fmt.Errorf("cannot index list with %s", celType(i))
+		}
+		if idx < 0 || idx >= int64(len(x)) {
+			return nil, fmt.Errorf("index %d out of range", idx)
+		}
+		return x[idx], nil
+	}
+	return nil, fmt.Errorf("cannot index %s", celType(x))
+}
+
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+
+func (n *unaryNode) eval(env *exprEnv) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	case "-":
+		switch x := x.(type) {
+		case int64:
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %s%s", n.op, celType(x))
+}
+
+type condNode struct{ c, t, f exprNode }
+
+func (n *condNode) eval(env *exprEnv) (any, error) {
+	c, err := n.c.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition is %s, not bool", celType(c))
+	}
+	if b {
+		return n.t.eval(env)
+	}
+	return n.f.eval(env)
+}
+
+type binaryNode struct {
+	op   string
+	l, r exprNode
+}
+
+func (n *binaryNode) eval(env *exprEnv) (any, error) {
+	if n.op == "&&" || n.op == "||" {
+		return n.logical(env)
+	}
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return exprEqual(l, r), nil
+	case "!=":
+		return !exprEqual(l, r), nil
+	case "<", "<=", ">", ">=":
+		c, err := exprCompare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		switch r := r.(type) {
+		case []any:
+			for _, v := range r {
+				if exprEqual(l, v) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			k, ok := l.(string)
+			if !ok {
+				return false, nil
+			}
+			_, found := r[k]
+			return found, nil
+		}
+		return nil, fmt.Errorf("no such overload: %s in %s", celType(l), celType(r))
+	}
+	return exprArithmetic(n.op, l, r)
+}
+
+// logical evaluates && and || as CEL does: an error on one side is
+// ignored when the other side decides the result.
+func (n *binaryNode) logical(env *exprEnv) (any, error) {
+	decisive := n.op == "||"
+	l, lerr := n.l.eval(env)
+	lb, lok := l.(bool)
+	if lerr == nil && !lok {
+		lerr = fmt.Errorf("no such overload: %s %s", celType(l), n.op)
+	}
+	if lerr == nil && lb == decisive {
+		return decisive, nil
+	}
+	r, rerr := n.r.eval(env)
+	rb, rok := r.(bool)
+	if rerr == nil && !rok {
+		rerr = fmt.Errorf("no such overload: %s %s", n.op, celType(r))
+	}
+	if rerr == nil && rb == decisive {
+		return decisive, nil
+	}
+	if lerr != nil {
+		return nil, lerr
+	}
+	if rerr != nil {
+		return nil, rerr
+	}
+	return !decisive, nil
+}
+
+func toInt(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+func toNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func exprEqual(a, b any) bool {
+	if fa, ok := toNumber(a); ok {
+		fb, ok := toNumber(b)
+		return ok && fa == fb
+	}
+	switch a := a.(type) {
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !exprEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !exprEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func exprCompare(a, b any) (int, error) {
+	if fa, ok := toNumber(a); ok {
+		if fb, ok := toNumber(b); ok {
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, nil
+			case b:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, fmt.Errorf("no such overload: %s < %s", celType(a), celType(b))
+}
+
+func exprArithmetic(op string, l, r any) (any, error) {
+	li, lInt := l.(int64)
+	ri, rInt := r.(int64)
+	if lInt && rInt {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, errors.New("division by zero")
+			}
+			if op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+	if lf, ok := toNumber(l); ok {
+		if rf, ok := toNumber(r); ok {
+			switch op {
+			case "+":
+				return lf + rf, nil
+			case "-":
+				return lf - rf, nil
+			case "*":
+				return lf * rf, nil
+			case "/":
+				return lf / rf, nil
+			}
+		}
+	}
+	if op == "+" {
+		switch l := l.(type) {
+		case string:
+			if r, ok := r.(string); ok {
+				return l + r, nil
+			}
+		case []any:
+			if r, ok := r.([]any); ok {
+				return append(append([]any{}, l...), r...), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %s %s %s", celType(l), op, celType(r))
+}
+
+type callNode struct {
+	fn   string
+	recv exprNode
+	args []exprNode
+	// re is the compiled pattern of matches with a literal pattern.
+	re *regexp.Regexp
+}
+
+func (n *callNode) eval(env *exprEnv) (any, error) {
+	args := make([]any, 0, len(n.args)+1)
+	if n.recv != nil {
+		v, err := n.recv.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	for _, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	switch n.fn {
+	case "size":
+		switch x := args[0].(type) {
+		case string:
+			return int64(utf8.RuneCountInString(x)), nil
+		case []any:
+			return int64(len(x)), nil
+		case map[string]any:
+			return int64(len(x)), nil
+		}
+	case "int":
+		switch x := args[0].(type) {
+		case int64:
+			return x, nil
+		case float64:
+			if math.IsNaN(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+				return nil, errors.New("int() out of range")
+			}
+			return int64(x), nil
+		case string:
+			i, err := strconv.ParseInt(x, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("int(): %w", err)
+			}
+			return i, nil
+		}
+	case "double":
+		switch x := args[0].(type) {
+		case int64:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		case string:
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				return nil, fmt.Errorf("double(): %w", err)
+			}
+			return f, nil
+		}
+	case "string":
+		switch x := args[0].(type) {
+		case string:
+			return x, nil
+		case int64:
+			return strconv.FormatInt(x, 10), nil
+		case float64:
+			return strconv.FormatFloat(x, 'g', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(x), nil
+		}
+	default:
+		s, ok := args[0].(string)
+		if !ok {
+			break
+		}
+		switch n.fn {
+		case "lowerAscii":
+			return strings.ToLower(s), nil
+		case "upperAscii":
+			return strings.ToUpper(s), nil
+		}
+		arg, ok := args[1].(string)
+		if !ok {
+			break
+		}
+		switch n.fn {
+		case "contains":
+			return strings.Contains(s, arg), nil
+		case "startsWith":
+			return strings.HasPrefix(s, arg), nil
+		case "endsWith":
+			return strings.HasSuffix(s, arg), nil
+		case "matches":
+			re := n.re
+			if re == nil {
+				var err error
+				if re, err = regexp.Compile(arg); err != nil {
+					return nil, fmt.Errorf("invalid regular expression: %w", err)
+				}
+			}
+			return re.MatchString(s), nil
+		}
+	}
+	types := make([]string, len(args))
+	for i, a := range args {
+		types[i] = celType(a)
+	}
+	return nil, fmt.Errorf("no such overload: %s(%s)", n.fn, strings.Join(types, ", "))
+}
+
+type comprehensionNode struct {
+	macro string
+	rng   exprNode
+	v     string
+	body  exprNode
+}
+
+func (n *comprehensionNode) eval(env *exprEnv) (any, error) {
+	x, err := n.rng.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	var items []any
+	switch x := x.(type) {
+	case []any:
+		items = x
+	case map[string]any:
+		for k := range x {
+			items = append(items, k)
+		}
+	default:
+		return nil, fmt.Errorf("cannot range over %s", celType(x))
+	}
+
+	var (
+		matched  int
+		out      []any
+		firstErr error
+	)
+	for _, item := range items {
+		env.scope = append(env.scope, exprBinding{n.v, item})
+		v, err := n.body.eval(env)
+		env.scope = env.scope[:len(env.scope)-1]
+		if n.macro == "map" {
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+			continue
+		}
+		b, ok := v.(bool)
+		if err == nil && !ok {
+			err = fmt.Errorf("%s() predicate is %s, not bool", n.macro, celType(v))
+		}
+		if err != nil {
+			if n.macro == "filter" || n.macro == "exists_one" {
+				return nil, err
+			}
+			// As with && and ||, a deciding element outweighs errors.
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		switch n.macro {
+		case "all":
+			if !b {
+				return false, nil
+			}
+		case "exists":
+			if b {
+				return true, nil
+			}
+		case "exists_one":
+			if b {
+				matched++
+			}
+		case "filter":
+			if b {
+				out = append(out, item)
+			}
+		}
+	}
+	switch n.macro {
+	case "all":
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return true, nil
+	case "exists":
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return false, nil
+	case "exists_one":
+		return matched == 1, nil
+	}
+	if out == nil {
+		out = []any{}
+	}
+	return out, nil
+}
@@ -0,0 +1,289 @@
+package listener
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// filterNotification is an UPDATE of an order, with a null column in data
+// and no key.
+func filterNotification() *ChangeNotification {
+	return &ChangeNotification{
+		ID:        7,
+		Seq:       3,
+		Channel:   DefaultChannel,
+		Table:     "orders",
+		Operation: OpUpdate,
+		Tenant:    "acme",
+		Data:      json.RawMessage(`{"id":1,"status":"active","email":"Ann@Example.com","total":12.5,"qty":3,"tags":["vip","new"],"address":{"city":"Berlin"},"deleted_at":null}`),
+		Old:       json.RawMessage(`{"id":1,"status":"pending","total":10}`),
+		New:       json.RawMessage(`{"id":1,"status":"active","total":12.5}`),
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    any
+		wantErr string
+	}{
+		// Variables.
+		{expr: `operation`, want: OpUpdate},
+		{expr: `schema`, want: DefaultSchema},
+		{expr: `table + "/" + tenant`, want: "orders/acme"},
+		{expr: `id + seq`, want: int64(10)},
+		{expr: `txid`, want: int64(0)},
+		{expr: `ref || snapshot`, want: false},
+		{expr: `data.qty`, want: int64(3)},
+		{expr: `data.total`, want: 12.5},
+		{expr: `data["status"]`, want: "active"},
+		{expr: `data.address.city`, want: "Berlin"},
+		{expr: `data.tags[0]`, want: "vip"},
+		{expr: `[1, "a", true]`, want: []any{int64(1), "a", true}},
+
+		// Precedence and associativity.
+		{expr: `1 + 2 * 3`, want: int64(7)},
+		{expr: `(1 + 2) * 3`, want: int64(9)},
+		{expr: `10 - 4 - 3`, want: int64(3)},
+		{expr: `7 % 4 * 2`, want: int64(6)},
+		{expr: `-2 * 3`, want: int64(-6)},
+		{expr: `- -2`, want: int64(2)},
+		{expr: `true || false && false`, want: true},
+		{expr: `(true || false) && false`, want: false},
+		{expr: `!true || true`, want: true},
+		{expr: `!(true || true)`, want: false},
+		{expr: `1 + 1 == 2 && 2 < 3`, want: true},
+		{expr: `1 < 2 == true`, want: true},
+		{expr: `"a" in ["a"] && true`, want: true},
+		{expr: `true ? 1 : 2 + 3`, want: int64(1)},
+		{expr: `false ? 1 : true ? 2 : 3`, want: int64(2)},
+		{expr: `false || true ? "y" : "n"`, want: "y"},
+
+		// Arithmetic and comparison across int and double.
+		{expr: `7 / 2`, want: int64(3)},
+		{expr: `7.0 / 2`, want: 3.5},
+		{expr: `data.total * 2`, want: 25.0},
+		{expr: `data.qty == 3.0`, want: true},
+		{expr: `old.total < new.total`, want: true},
+		{expr: `1e3`, want: 1000.0},
+		{expr: `"b" > "a"`, want: true},
+		{expr: `false < true`, want: true},
+		{expr: `[1] + [2]`, want: []any{int64(1), int64(2)}},
+		{expr: `[1, [2]] == [1.0, [2]]`, want: true},
+
+		// in.
+		{expr: `table in ["orders", "refunds"]`, want: true},
+		{expr: `operation in ["INSERT", "DELETE"]`, want: false},
+		{expr: `3 in [1.0, 3.0]`, want: true},
+		{expr: `"email" in data`, want: true},
+		{expr: `"phone" in data`, want: false},
+		{expr: `1 in data`, want: false},
+		{expr: `"vip" in data.tags`, want: true},
+		{expr: `null in [null]`, want: true},
+		{expr: `"a" in "abc"`, wantErr: "no such overload: string in string"},
+
+		// Nulls.
+		{expr: `data.deleted_at == null`, want: true},
+		{expr: `data.deleted_at != null`, want: false},
+		{expr: `key == null`, want: true},
+		{expr: `has(data.deleted_at)`, want: true},
+		{expr: `has(data.phone)`, want: false},
+		{expr: `has(key.id)`, want: false},
+		{expr: `key.id`, wantErr: `cannot select field "id" of null`},
+		{expr: `data.deleted_at < 1`, wantErr: "no such overload: null < int"},
+		{expr: `size(data.deleted_at)`, wantErr: "no such overload: size(null)"},
+		{expr: `data.phone`, wantErr: "no such key: phone"},
+		{expr: `data["phone"]`, wantErr: "no such key: phone"},
+
+		// Functions and string methods.
+		{expr: `size("héllo")`, want: int64(5)},
+		{expr: `size(data.tags)`, want: int64(2)},
+		{expr: `data.tags.size()`, want: int64(2)},
+		{expr: `size(data)`, want: int64(8)},
+		{expr: `int("42") + int(2.0)`, want: int64(44)},
+		{expr: `double(1) + double("0.5")`, want: 1.5},
+		{expr: `string(data.qty) + string(1.5) + string(true)`, want: "31.5true"},
+		{expr: `data.email.contains("@")`, want: true},
+		{expr: `data.email.startsWith("Ann")`, want: true},
+		{expr: `data.email.endsWith("@example.com")`, want: false},
+		{expr: `data.email.lowerAscii().endsWith("@example.com")`, want: true},
+		{expr: `data.status.upperAscii()`, want: "ACTIVE"},
+		{expr: `data.email.matches("^[A-Z][a-z]+@")`, want: true},
+		{expr: `data.email.matches(data.status)`, want: false},
+		{expr: `data.email.matches(data.status + "(")`, wantErr: "invalid regular expression"},
+		{expr: `"a\"b\n".size()`, want: int64(4)},
+		{expr: `'it\'s'`, want: "it's"},
+
+		// Macros.
+		{expr: `data.tags.exists(t, t == "vip")`, want: true},
+		{expr: `data.tags.all(t, t.size() == 3)`, want: true},
+		{expr: `data.tags.exists_one(t, t.startsWith("v"))`, want: true},
+		{expr: `data.tags.map(t, t.upperAscii())`, want: []any{"VIP", "NEW"}},
+		{expr: `data.tags.filter(t, t != "vip")`, want: []any{"new"}},
+		{expr: `data.tags.filter(t, false)`, want: []any{}},
+		{expr: `old.all(k, k in new)`, want: true},
+		{expr: `[1, "a"].exists(x, x == 1)`, want: true},
+		{expr: `[1, "a"].exists(x, x > 0)`, want: true},
+		{expr: `["a", 1].all(x, x > 0)`, wantErr: "no such overload: string < int"},
+		{expr: `[1, 2].all(x, x)`, wantErr: "all() predicate is int, not bool"},
+		{expr: `data.qty.exists(x, true)`, wantErr: "cannot range over int"},
+
+		// Type errors.
+		{expr: `1 + "a"`, wantErr: "no such overload: int + string"},
+		{expr: `"a" - "b"`, wantErr: "no such overload: string - string"},
+		{expr: `"a" < 1`, wantErr: "no such overload: string < int"},
+		{expr: `!1`, wantErr: "no such overload: !int"},
+		{expr: `-"a"`, wantErr: "no such overload: -string"},
+		{expr: `1 / 0`, wantErr: "division by zero"},
+		{expr: `1 % 0`, wantErr: "division by zero"},
+		{expr: `1.5 % 1`, wantErr: "no such overload: double % int"},
+		{expr: `1 ? 2 : 3`, wantErr: "condition is int, not bool"},
+		{expr: `size(1)`, wantErr: "no such overload: size(int)"},
+		{expr: `int("x")`, wantErr: "int(): "},
+		{expr: `int(1e19)`, wantErr: "int() out of range"},
+		{expr: `data.qty.contains("3")`, wantErr: "no such overload: contains(int, string)"},
+		{expr: `data.status.contains(1)`, wantErr: "no such overload: contains(string, int)"},
+		{expr: `data.qty.city`, wantErr: `cannot select field "city" of int`},
+		{expr: `data.tags["a"]`, wantErr: "cannot index list with string"},
+		{expr: `data.tags[2]`, wantErr: "index 2 out of range"},
+		{expr: `data[0]`, wantErr: "cannot index map with int"},
+		{expr: `data.qty[0]`, wantErr: "cannot index int"},
+
+		// && and || ignore errors the other side outweighs.
+		{expr: `false && data.phone == 1`, want: false},
+		{expr: `data.phone == 1 && false`, want: false},
+		{expr: `true || data.phone == 1`, want: true},
+		{expr: `data.phone == 1 || true`, want: true},
+		{expr: `data.phone == 1 || false`, wantErr: "no such key: phone"},
+		{expr: `true && 1`, wantErr: "no such overload: && int"},
+		{expr: `1 || false`, wantErr: "no such overload: int ||"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := CompileExpr(tt.expr)
+			if err == nil {
+				var v any
+				v, err = e.Eval(filterNotification())
+				if err == nil && tt.wantErr == "" && !reflect.DeepEqual(v, tt.want) {
+					t.Errorf("got %#v, want %#v", v, tt.want)
+				}
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		filter  string
+		want    bool
+		wantErr string
+	}{
+		{filter: `data.status == "active" && operation != "DELETE"`, want: true},
+		{filter: `operation == "UPDATE" && old.price != new.price`, wantErr: "no such key: price"},
+		{filter: `operation == "UPDATE" && old.total != new.total`, want: true},
+		{filter: `has(data.email) && data.email.endsWith("@example.com")`, want: false},
+		{filter: `table in ["orders", "refunds"] || data.phone == 1`, want: true},
+		{filter: `tenant != "acme"`, want: false},
+		{filter: `data.status`, wantErr: "filter evaluates to string, not bool"},
+		{filter: `data.deleted_at`, wantErr: "filter evaluates to null, not bool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := CompileFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.String() != tt.filter {
+				t.Errorf("String() = %q, want %q", f.String(), tt.filter)
+			}
+			got, err := f.Match(filterNotification())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				if got {
+					t.Error("matched despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterNotMatching(t *testing.T) {
+	dl, _ := newTestListener(t)
+	f, err := CompileFilter(`data.phone == "1"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.filter(filterNotification(), &registration{filter: f}) {
+		t.Error("filter failing to evaluate matched")
+	}
+}
+
+func TestCompileExprErrors(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: ``, wantErr: "unexpected end of expression at 0"},
+		{expr: `1 +`, wantErr: "unexpected end of expression at 3"},
+		{expr: `(1`, wantErr: `expected ")" at 2`},
+		{expr: `[1, 2`, wantErr: `expected "]" at 5`},
+		{expr: `data["a"`, wantErr: `expected "]" at 8`},
+		{expr: `1 2`, wantErr: `unexpected "2" at 2`},
+		{expr: `true ? 1`, wantErr: `expected ":" at 8`},
+		{expr: `true ? 1 , 2`, wantErr: `expected ":", got "," at 9`},
+		{expr: `)`, wantErr: `unexpected ")" at 0`},
+		{expr: `data.`, wantErr: "expected field name at 5"},
+		{expr: `data.1`, wantErr: "expected field name at 5"},
+		{expr: `a == 1`, wantErr: `undeclared reference to "a" at 0`},
+		{expr: `operation = "INSERT"`, wantErr: `unexpected character '=' at 10`},
+		{expr: `data.x & 1`, wantErr: `unexpected character '&' at 7`},
+		{expr: `{"a": 1}`, wantErr: "unexpected character '{' at 0"},
+		{expr: `"abc`, wantErr: "unterminated string at 0"},
+		{expr: `"abc\`, wantErr: "unterminated string at 0"},
+		{expr: `"\d"`, wantErr: `invalid escape \d at 1`},
+		{expr: `99999999999999999999`, wantErr: `invalid number "99999999999999999999"`},
+		{expr: `-9223372036854775808`, wantErr: "invalid number"},
+		{expr: `now()`, wantErr: `undeclared function "now" at 0`},
+		{expr: `size(1, 2)`, wantErr: "size() takes 1 argument(s) at 0"},
+		{expr: `has(data)`, wantErr: "has() requires a field selection at 0"},
+		{expr: `has(data["a"])`, wantErr: "has() requires a field selection"},
+		{expr: `data.email.trim()`, wantErr: `undeclared method "trim"`},
+		{expr: `data.email.contains()`, wantErr: "contains() takes 1 argument(s)"},
+		{expr: `data.email.matches(1)`, wantErr: "matches() requires a string"},
+		{expr: `data.email.matches("(")`, wantErr: "invalid regular expression"},
+		{expr: `data.tags.exists(1, true)`, wantErr: "exists() requires a variable name"},
+		{expr: `data.tags.exists(t t)`, wantErr: `expected ",", got "t"`},
+		{expr: `data.tags.exists(t, t`, wantErr: `expected ")"`},
+		{expr: `data.tags.exists(t, true) && t`, wantErr: `undeclared reference to "t"`},
+		{expr: strings.Repeat("(", exprMaxDepth+1) + "1" + strings.Repeat(")", exprMaxDepth+1), wantErr: "nested too deeply"},
+		{expr: strings.Repeat("!", exprMaxDepth+1) + "true", wantErr: "nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := CompileExpr(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+			if _, err := CompileFilter(tt.expr); err == nil {
+				t.Error("CompileFilter succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// TableChangeHandler receives the operation and row of each change. ctx is
+// canceled when the handler's timeout expires.
+type TableChangeHandler interface {
+	HandleChange(ctx context.Context, operation string, data json.RawMessage) error
+}
+
+// NotificationHandler receives the whole notification, including the Old
+// and New row images. TableChangeHandlers that also implement it are called
+// through HandleNotification instead of HandleChange.
+type NotificationHandler interface {
+	HandleNotification(ctx context.Context, n *ChangeNotification) error
+}
+
+// OperationHandler has one method per operation, so handlers don't have to
+// switch on the operation string. TableChangeHandlers that also implement
+// it are called through these methods; HandleChange is then only used for
+// other operations. ctx is canceled when the handler's timeout expires.
+type OperationHandler interface {
+	HandleInsert(ctx context.Context, data json.RawMessage) error
+	HandleUpdate(ctx context.Context, old, new json.RawMessage) error
+	HandleDelete(ctx context.Context, data json.RawMessage) error
+}
+
+// Operations adapts an OperationHandler that doesn't implement HandleChange.
+// Notifications for other operations are ignored.
+func Operations(handler OperationHandler) NotificationHandler {
+	return operationAdapter{ops: handler}
+}
+
+type changeHandlerAdapter struct {
+	h TableChangeHandler
+}
+
+func (a changeHandlerAdapter) HandleNotification(ctx context.Context, n *ChangeNotification) error {
+	return a.h.HandleChange(ctx, n.Operation, n.Data)
+}
+
+type operationAdapter struct {
+	ops      OperationHandler
+	fallback TableChangeHandler
+}
+
+func (a operationAdapter) HandleNotification(ctx context.Context, n *ChangeNotification) error {
+	switch n.Operation {
+	case OpInsert:
+		return a.ops.HandleInsert(ctx, n.New)
+	case OpUpdate:
+		return a.ops.HandleUpdate(ctx, n.Old, n.New)
+	case OpDelete:
+		return a.ops.HandleDelete(ctx, n.Old)
+	}
+	if a.fallback != nil {
+		return a.fallback.HandleChange(ctx, n.Operation, n.Data)
+	}
+	return nil
+}
+
+func adaptHandler(handler TableChangeHandler) NotificationHandler {
+	switch h := handler.(type) {
+	case NotificationHandler:
+		return h
+	case OperationHandler:
+		return operationAdapter{ops: h, fallback: handler}
+	default:
+		return changeHandlerAdapter{handler}
+	}
+}
+
+// HandlerOption configures a single handler registration.
+type HandlerOption func(*registration)
+
+// WithRetry overrides the listener's retry policy for one handler.
+func WithRetry(p RetryPolicy) HandlerOption {
+	return func(r *registration) {
+		r.retry = &p
+	}
+}
+
+// WithTimeout overrides the listener's handler timeout for one handler.
+func WithTimeout(d time.Duration) HandlerOption {
+	return func(r *registration) {
+		r.timeout = &d
+	}
+}
+
+type registration struct {
+	handler   NotificationHandler
+	retry     *RetryPolicy
+	timeout   *time.Duration
+	panics    panicState
+	circuit   *circuit
+	limiter   *rateLimiter
+	debouncer *debouncer
+	schema    *Schema
+	redaction *Redaction
+	transform *Transform
+	filter    *Filter
+	ordering  OrderingFunc
+	priority  Priority
+	stats     handlerStats
+}
+
+func newRegistration(handler NotificationHandler, opts []HandlerOption) *registration {
+	reg := &registration{handler: handler}
+	for _, opt := range opts {
+		opt(reg)
+	}
+	return reg
+}
@@ -0,0 +1,226 @@
+package listener
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CatchAll registers a handler for every table without a more specific
+// match.
+const CatchAll = "*"
+
+// HandlerSet routes notifications to handlers by table name. One set can
+// serve several channels.
+//
+// Names may be exact table names, glob patterns ("s_*", "audit_?"), regular
+// expressions registered with HandleRegexp, or CatchAll. Notifications are
+// matched by their "schema.table" name; tables in DefaultSchema also match
+// by their bare name, so "s_user" and "public.s_user" are equivalent while
+// "sales.orders" only matches the sales schema. When several match, the
+// first of these wins:
+//
+//  1. the exact name
+//  2. glob patterns, most literal characters first, then in registration order
+//  3. regular expressions, in registration order
+//  4. the catch-all handler
+type HandlerSet struct {
+	mu       sync.RWMutex
+	handlers map[string]*registration
+	globs    []*patternRegistration
+	regexps  []*patternRegistration
+	catchAll *registration
+	seq      int
+}
+
+type patternRegistration struct {
+	source      string
+	match       func(string) bool
+	specificity int
+	seq         int
+	reg         *registration
+}
+
+func NewHandlerSet() *HandlerSet {
+	return &HandlerSet{handlers: make(map[string]*registration)}
+}
+
+func (hs *HandlerSet) RegisterHandler(tableName string, handler TableChangeHandler, opts ...HandlerOption) {
+	hs.Handle(tableName, adaptHandler(handler), opts...)
+}
+
+// Handle registers handler for a table name, glob pattern or CatchAll. It
+// panics if the glob pattern is malformed.
+func (hs *HandlerSet) Handle(tableName string, handler NotificationHandler, opts ...HandlerOption) {
+	reg := newRegistration(handler, opts)
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	switch {
+	case tableName == CatchAll:
+		hs.catchAll = reg
+	case isGlob(tableName):
+		if _, err := path.Match(tableName, ""); err != nil {
+			panic(fmt.Sprintf("listener: invalid pattern %q: %v", tableName, err))
+		}
+		pattern := tableName
+		hs.globs = hs.addPattern(hs.globs, &patternRegistration{
+			source:      pattern,
+			match:       func(table string) bool { ok, _ := path.Match(pattern, table); return ok },
+			specificity: literalChars(pattern),
+			reg:         reg,
+		})
+		sort.SliceStable(hs.globs, func(i, j int) bool {
+			if hs.globs[i].specificity != hs.globs[j].specificity {
+				return hs.globs[i].specificity > hs.globs[j].specificity
+			}
+			return hs.globs[i].seq < hs.globs[j].seq
+		})
+	default:
+		hs.handlers[tableName] = reg
+	}
+}
+
+func (hs *HandlerSet) RegisterRegexp(re *regexp.Regexp, handler TableChangeHandler, opts ...HandlerOption) {
+	hs.HandleRegexp(re, adaptHandler(handler), opts...)
+}
+
+// HandleRegexp registers handler for tables matching re. Anchor the
+// expression to match whole names.
+func (hs *HandlerSet) HandleRegexp(re *regexp.Regexp, handler NotificationHandler, opts ...HandlerOption) {
+	reg := newRegistration(handler, opts)
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.regexps = hs.addPattern(hs.regexps, &patternRegistration{
+		source: re.String(),
+		match:  re.MatchString,
+		reg:    reg,
+	})
+}
+
+// addPattern replaces a registration with the same source, keeping its
+// position, or appends a new one.
+func (hs *HandlerSet) addPattern(list []*patternRegistration, p *patternRegistration) []*patternRegistration {
+	for i, existing := range list {
+		if existing.source == p.source {
+			p.seq = existing.seq
+			list[i] = p
+			return list
+		}
+	}
+	hs.seq++
+	p.seq = hs.seq
+	return append(list, p)
+}
+
+// UnregisterHandler removes the registration for a table name, glob
+// pattern, regular expression source or CatchAll.
+func (hs *HandlerSet) UnregisterHandler(tableName string) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	if tableName == CatchAll {
+		hs.catchAll = nil
+		return
+	}
+	delete(hs.handlers, tableName)
+	hs.globs = removePattern(hs.globs, tableName)
+	hs.regexps = removePattern(hs.regexps, tableName)
+}
+
+func removePattern(list []*patternRegistration, source string) []*patternRegistration {
+	out := list[:0]
+	for _, p := range list {
+		if p.source != source {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Tables returns the sorted table names registered by exact name.
+func (hs *HandlerSet) Tables() []string {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	tables := make([]string, 0, len(hs.handlers))
+	for name := range hs.handlers {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Handler returns the handler a notification for tableName, optionally
+// schema-qualified, is routed to.
+func (hs *HandlerSet) Handler(tableName string) (NotificationHandler, bool) {
+	n := &ChangeNotification{Table: tableName}
+	if i := strings.LastIndexByte(tableName, '.'); i >= 0 {
+		n.Schema, n.Table = tableName[:i], tableName[i+1:]
+	}
+
+	reg, ok := hs.registration(n)
+	if !ok {
+		return nil, false
+	}
+	return reg.handler, true
+}
+
+func (hs *HandlerSet) registration(n *ChangeNotification) (*registration, bool) {
+	names := n.routingNames()
+
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	for _, name := range names {
+		if reg, ok := hs.handlers[name]; ok {
+			return reg, true
+		}
+	}
+	for _, list := range [][]*patternRegistration{hs.globs, hs.regexps} {
+		for _, p := range list {
+			for _, name := range names {
+				if p.match(name) {
+					return p.reg, true
+				}
+			}
+		}
+	}
+	if hs.catchAll != nil {
+		return hs.catchAll, true
+	}
+	return nil, false
+}
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+func literalChars(pattern string) int {
+	n := 0
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			if !inClass {
+				n++
+			}
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '*' || c == '?':
+		default:
+			if !inClass {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,83 @@
+package listener
+
+import "sync"
+
+// ConnectionHooks are called when the connection of the capture source
+// changes, e.g. to alert or to mark the service unready. The built-in
+// sources report their LISTEN connection, replication stream or polling
+// queries. A hook runs on the goroutine that observed the change and
+// should return quickly.
+type ConnectionHooks struct {
+	// OnConnect is called when the first connection is established.
+	OnConnect func()
+	// OnDisconnect is called when the connection is lost, with the cause
+	// when known. Stopping the listener does not call it.
+	OnDisconnect func(err error)
+	// OnReconnect is called when a connection is established again.
+	// Changes sent meanwhile by NOTIFY are caught up from the outbox,
+	// when configured, after it returns.
+	OnReconnect func()
+}
+
+// WithConnectionHooks sets the callbacks for connection changes of the
+// capture source.
+func WithConnectionHooks(h ConnectionHooks) Option {
+	return func(dl *DataListener) {
+		dl.hooks.ConnectionHooks = h
+	}
+}
+
+// connHooks tracks the connection state a source reports, calling the
+// hooks on changes only. A nil *connHooks ignores the reports.
+type connHooks struct {
+	ConnectionHooks
+
+	mu          sync.Mutex
+	connected   bool
+	established bool
+}
+
+func (h *connHooks) connect() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.connected {
+		return
+	}
+	h.connected = true
+	fn := h.OnConnect
+	if h.established {
+		fn = h.OnReconnect
+	}
+	h.established = true
+	if fn != nil {
+		fn()
+	}
+}
+
+func (h *connHooks) disconnect(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.connected {
+		return
+	}
+	h.connected = false
+	if h.OnDisconnect != nil {
+		h.OnDisconnect(err)
+	}
+}
+
+// stop records that the source was stopped, without calling a hook.
+func (h *connHooks) stop() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.connected = false
+	h.mu.Unlock()
+}
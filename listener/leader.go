@@ -0,0 +1,243 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultLeaderKey is the advisory lock key used when
+	// LeaderConfig.Key is zero; "pglisten" in ASCII.
+	DefaultLeaderKey int64 = 0x70676c697374656e
+	// DefaultLeaderInterval is how often the lock is tried or checked
+	// when LeaderConfig.Interval is zero.
+	DefaultLeaderInterval = 5 * time.Second
+)
+
+// ErrLeadershipLost is returned by Start when the leader lock can no
+// longer be confirmed. Processing stops, so another instance can take
+// over without both handling the same changes.
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// LeaderLock is held by at most one instance at a time, see
+// WithLeaderElection. Implementations other than AdvisoryLock can use
+// e.g. a Kubernetes lease or etcd.
+type LeaderLock interface {
+	// TryAcquire takes the lock if it is free, reporting whether it is
+	// now held.
+	TryAcquire(ctx context.Context) (bool, error)
+	// Check reports an error when the lock may no longer be held.
+	Check(ctx context.Context) error
+	// Release gives up the lock.
+	Release(ctx context.Context) error
+}
+
+// LeaderConfig configures leader election among several instances.
+type LeaderConfig struct {
+	// Lock is the lock to hold; an AdvisoryLock on Key in the listener's
+	// database when nil.
+	Lock LeaderLock
+	// Key is the advisory lock key, shared by the instances of one
+	// deployment; DefaultLeaderKey when zero.
+	Key int64
+	// Interval is how often a standby tries to take the lock and the
+	// leader confirms it still holds it.
+	Interval time.Duration
+}
+
+func (c LeaderConfig) withDefaults() LeaderConfig {
+	if c.Key == 0 {
+		c.Key = DefaultLeaderKey
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultLeaderInterval
+	}
+	return c
+}
+
+// WithLeaderElection makes Start wait until the instance holds the leader
+// lock before capturing changes, so of several replicas only one
+// processes them. A standby takes over when the leader releases the lock
+// or dies; with the outbox, it first catches up on what the leader left.
+// A leader that loses the lock stops and Start returns ErrLeadershipLost.
+func WithLeaderElection(cfg LeaderConfig) Option {
+	return func(dl *DataListener) {
+		dl.leader = &leader{cfg: cfg.withDefaults()}
+	}
+}
+
+// leader runs the election of a listener.
+type leader struct {
+	cfg     LeaderConfig
+	standby atomic.Bool
+	// lost is closed when the lock held by the running listener fails.
+	lost chan struct{}
+}
+
+// lostLeadership returns the channel closed when the leader lock is lost;
+// without leader election it is nil and never ready.
+func (l *leader) lostLeadership() <-chan struct{} {
+	if l == nil {
+		return nil
+	}
+	return l.lost
+}
+
+// acquireLeadership waits until the lock is held, returning false when
+// ctx is done or the listener is stopped first.
+func (dl *DataListener) acquireLeadership(ctx context.Context) (bool, error) {
+	l := dl.leader
+	if l.cfg.Lock == nil {
+		l.cfg.Lock = NewAdvisoryLock(dl.db, l.cfg.Key)
+	}
+	l.standby.Store(true)
+	defer l.standby.Store(false)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	waiting := false
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-dl.stop:
+			return false, nil
+		case <-timer.C:
+		}
+		ok, err := l.cfg.Lock.TryAcquire(ctx)
+		if err != nil {
+			dl.logger.Warn("failed to acquire leader lock", "error", err)
+		} else if ok {
+			dl.logger.Info("became leader")
+			return true, nil
+		} else if !waiting {
+			dl.logger.Info("waiting for leadership")
+			waiting = true
+		}
+		timer.Reset(l.cfg.Interval)
+	}
+}
+
+// watchLeadership confirms the lock until the returned function is
+// called, which then releases it. A failed check closes l.lost.
+func (dl *DataListener) watchLeadership() (release func()) {
+	l := dl.leader
+	l.lost = make(chan struct{})
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(l.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), l.cfg.Interval)
+			err := l.cfg.Lock.Check(ctx)
+			cancel()
+			if err != nil {
+				dl.logger.Error("leader lock lost", "error", err)
+				close(l.lost)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		dl.releaseLeadership()
+	}
+}
+
+// releaseLeadership gives up the lock after processing stopped.
+func (dl *DataListener) releaseLeadership() {
+	ctx, cancel := context.WithTimeout(context.Background(), dl.leader.cfg.Interval)
+	defer cancel()
+	if err := dl.leader.cfg.Lock.Release(ctx); err != nil {
+		dl.logger.Warn("failed to release leader lock", "error", err)
+	}
+}
+
+// AdvisoryLock is a LeaderLock on a Postgres session-level advisory
+// lock, held on a connection of db. The server releases it when that
+// session ends, e.g. when the instance dies, so a standby can take over.
+// It requires session pooling when db goes through PgBouncer.
+type AdvisoryLock struct {
+	db  *sql.DB
+	key int64
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+func NewAdvisoryLock(db *sql.DB, key int64) *AdvisoryLock {
+	return &AdvisoryLock{db: db, key: key}
+}
+
+func (l *AdvisoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		return true, nil
+	}
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&ok); err != nil {
+		discard(conn)
+		return false, err
+	}
+	if !ok {
+		conn.Close()
+		return false, nil
+	}
+	l.conn = conn
+	return true, nil
+}
+
+// Check confirms that the session holding the lock is alive.
+func (l *AdvisoryLock) Check(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return errors.New("advisory lock not held")
+	}
+	if err := l.conn.PingContext(ctx); err != nil {
+		discard(l.conn)
+		l.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (l *AdvisoryLock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return nil
+	}
+	conn := l.conn
+	l.conn = nil
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		// Closing the session releases the lock too.
+		discard(conn)
+		return err
+	}
+	return conn.Close()
+}
+
+// discard closes the session of conn instead of returning it to the pool,
+// so a lock it may hold is released.
+func discard(conn *sql.Conn) {
+	conn.Raw(func(any) error { return driver.ErrBadConn })
+	conn.Close()
+}
@@ -0,0 +1,1196 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrAlreadyStarted = errors.New("listener already started")
+
+var errStopped = errors.New("listener stopped")
+
+var errQueueFull = errors.New("queue full")
+
+var errReconnect = errors.New("reconnect requested")
+
+type DataListener struct {
+	db            *sql.DB
+	listenConnStr string
+	queryPool     PoolConfig
+	driver        Driver
+	tlsCfg        *TLSConfig
+	tls           *clientTLS
+	password      PasswordFunc
+
+	defaultSet  *HandlerSet
+	channels    map[string]*HandlerSet
+	outbox      *outbox
+	replication *ReplicationConfig
+	polling     *PollingConfig
+	snapshotCfg *SnapshotConfig
+	// capture is the configured source; source is set while it runs.
+	capture CaptureSource
+	source  CaptureSource
+	pool    *workerPool
+
+	minReconnect time.Duration
+	maxReconnect time.Duration
+	pingInterval time.Duration
+	drainTimeout time.Duration
+	workers      int
+	queueSize    int
+	lanes        priorityLanes
+	queued       bool
+	overflow     OverflowPolicy
+	ordering     OrderingFunc
+	retry        RetryPolicy
+	onError      ErrorHandler
+	deadLetters  DeadLetterStore
+	metrics      Metrics
+	logger       Logger
+	tracer       trace.Tracer
+	middleware   []Middleware
+	chunkTimeout time.Duration
+	chunks       *reassembler
+	sequences    *sequenceTracker
+	dedup        *dedup
+	transactions TransactionHandler
+	txTimeout    time.Duration
+	txs          *txBuffer
+	onGap        GapHandler
+	hooks        *connHooks
+	leader       *leader
+	shards       *sharder
+	tenantColumn string
+	tenants      map[string]*HandlerSet
+	quarantine   NotificationHandler
+	rates        *rateMonitor
+	codecs       map[string]Codec
+	panicBreaker *PanicBreaker
+	timeout      time.Duration
+	gapCatchUp   bool
+	conn         connState
+	pause        pauser
+	reconnect    chan struct{}
+	// scheduled runs redeliveries on the receive loop when there are no
+	// workers, see schedule.
+	scheduled chan func()
+
+	mu       sync.Mutex
+	running  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	inflight sync.WaitGroup
+}
+
+// New validates connStr, opens the query connection pool and checks that
+// the database is reachable. Unless WithCapture is given, changes are
+// captured from a LISTEN connection, or the replication stream, opened by
+// Start with the same connection string unless WithListenConnString is
+// given.
+func New(connStr string, opts ...Option) (*DataListener, error) {
+	queries, err := newQueryConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(queries)
+
+	dl := newListener(db, connStr, opts...)
+	if dl.tlsCfg != nil {
+		if dl.tls, err = dl.tlsCfg.build(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+	}
+	if err := queries.configure(connStr, dl.connOptions()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if dl.capture == nil {
+		if err := dl.defaultCapture(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("listen connection: %w", err)
+		}
+	}
+	dl.queryPool.apply(db)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return dl, nil
+}
+
+// newListener returns a listener with the defaults and opts applied,
+// querying db, without connecting anywhere.
+func newListener(db *sql.DB, connStr string, opts ...Option) *DataListener {
+	dl := &DataListener{
+		db:            db,
+		listenConnStr: connStr,
+		defaultSet:    NewHandlerSet(),
+		channels:      make(map[string]*HandlerSet),
+		tenants:       make(map[string]*HandlerSet),
+		codecs:        make(map[string]Codec),
+		minReconnect:  DefaultMinReconnectInterval,
+		maxReconnect:  DefaultMaxReconnectInterval,
+		hooks:         &connHooks{},
+		pingInterval:  DefaultPingInterval,
+		drainTimeout:  DefaultDrainTimeout,
+		workers:       1,
+		queueSize:     DefaultQueueSize,
+		lanes:         priorityLanes{size: DefaultPriorityQueueSize, burst: DefaultPriorityBurst},
+		ordering:      ByTable,
+		retry:         NoRetry,
+		metrics:       NopMetrics{},
+		logger:        defaultLogger{},
+		tracer:        newTracer(nil),
+		chunkTimeout:  DefaultChunkTimeout,
+		txTimeout:     DefaultTransactionTimeout,
+		sequences:     newSequenceTracker(),
+		rates:         newRateMonitor(),
+		reconnect:     make(chan struct{}, 1),
+		scheduled:     make(chan func()),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(dl)
+	}
+	if len(dl.channels) == 0 {
+		dl.channels[DefaultChannel] = dl.defaultSet
+	}
+	dl.chunks = newReassembler(dl.chunkTimeout)
+	dl.txs = newTxBuffer(dl.txTimeout)
+	return dl
+}
+
+// DB returns the query connection pool so handlers can load related data.
+func (dl *DataListener) DB() *sql.DB {
+	return dl.db
+}
+
+// RegisterHandler registers handler in the default handler set, which
+// serves every channel not configured with its own set.
+func (dl *DataListener) RegisterHandler(tableName string, handler TableChangeHandler, opts ...HandlerOption) {
+	dl.defaultSet.RegisterHandler(tableName, handler, opts...)
+}
+
+// Handle registers a NotificationHandler in the default handler set.
+func (dl *DataListener) Handle(tableName string, handler NotificationHandler, opts ...HandlerOption) {
+	dl.defaultSet.Handle(tableName, handler, opts...)
+}
+
+// HandleFunc registers a handler function in the default handler set.
+func (dl *DataListener) HandleFunc(tableName string, fn func(ctx context.Context, n *ChangeNotification) error, opts ...HandlerOption) {
+	dl.defaultSet.Handle(tableName, HandlerFunc(fn), opts...)
+}
+
+// RegisterRegexp registers handler in the default handler set for tables
+// matching re.
+func (dl *DataListener) RegisterRegexp(re *regexp.Regexp, handler TableChangeHandler, opts ...HandlerOption) {
+	dl.defaultSet.RegisterRegexp(re, handler, opts...)
+}
+
+// HandleRegexp registers a NotificationHandler in the default handler set
+// for tables matching re.
+func (dl *DataListener) HandleRegexp(re *regexp.Regexp, handler NotificationHandler, opts ...HandlerOption) {
+	dl.defaultSet.HandleRegexp(re, handler, opts...)
+}
+
+// Handlers returns the default handler set.
+func (dl *DataListener) Handlers() *HandlerSet {
+	return dl.defaultSet
+}
+
+// AddChannel starts listening on channel, routing its notifications to set
+// (or the default set when nil). It may be called while Start is running.
+func (dl *DataListener) AddChannel(channel string, set *HandlerSet) error {
+	if set == nil {
+		set = dl.defaultSet
+	}
+
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	_, exists := dl.channels[channel]
+	dl.channels[channel] = set
+	if exists || dl.source == nil {
+		return nil
+	}
+
+	src, ok := dl.source.(channelSource)
+	if !ok {
+		delete(dl.channels, channel)
+		return fmt.Errorf("failed to listen on channel %s: capture source does not support adding channels", channel)
+	}
+	if err := src.Listen(channel); err != nil {
+		delete(dl.channels, channel)
+		return fmt.Errorf("failed to listen on channel %s: %w", channel, err)
+	}
+	dl.logger.Info("listening", "channel", channel)
+	return nil
+}
+
+// RemoveChannel stops listening on channel. It may be called while Start is
+// running.
+func (dl *DataListener) RemoveChannel(channel string) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	if _, ok := dl.channels[channel]; !ok {
+		return nil
+	}
+	delete(dl.channels, channel)
+	src, ok := dl.source.(channelSource)
+	if !ok {
+		return nil
+	}
+
+	if err := src.Unlisten(channel); err != nil {
+		return fmt.Errorf("failed to unlisten channel %s: %w", channel, err)
+	}
+	dl.logger.Info("stopped listening", "channel", channel)
+	return nil
+}
+
+// Channels returns the channels currently configured.
+func (dl *DataListener) Channels() []string {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	channels := make([]string, 0, len(dl.channels))
+	for ch := range dl.channels {
+		channels = append(channels, ch)
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// handlerSets returns the default set and every channel's set.
+func (dl *DataListener) handlerSets() []*HandlerSet {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+
+	sets := []*HandlerSet{dl.defaultSet}
+	for _, set := range dl.channels {
+		if !slices.Contains(sets, set) {
+			sets = append(sets, set)
+		}
+	}
+	for _, set := range dl.tenants {
+		if !slices.Contains(sets, set) {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
+
+// HandlerStats returns the stats of the registrations serving each
+// channel.
+func (dl *DataListener) HandlerStats() map[string][]HandlerStats {
+	dl.mu.Lock()
+	channels := maps.Clone(dl.channels)
+	dl.mu.Unlock()
+
+	stats := make(map[string][]HandlerStats, len(channels))
+	for channel, set := range channels {
+		stats[channel] = set.Stats()
+	}
+	return stats
+}
+
+func (dl *DataListener) handlerSet(channel string) (*HandlerSet, bool) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	set, ok := dl.channels[channel]
+	return set, ok
+}
+
+// handleNotification handles a notification payload; id is the event id
+// when the payload does not carry it.
+func (dl *DataListener) handleNotification(ctx context.Context, channel, payload string, id int64) error {
+	raw, ids := []byte(payload), appendID(nil, id)
+	if isChunk(payload) {
+		assembled, complete, parts, err := dl.chunks.add(channel, payload, id)
+		if err != nil {
+			dl.metrics.Dropped(channel, DropMalformed)
+			dl.skip(ctx, parts...)
+			return err
+		}
+		if !complete {
+			return nil
+		}
+		raw, ids = assembled, parts
+	}
+
+	ctx, span := dl.startNotificationSpan(ctx, "receive "+channel, channel)
+
+	_, decodeSpan := dl.tracer.Start(ctx, "decode")
+	notification, err := dl.decode(channel, raw)
+	endSpan(decodeSpan, err)
+	if err != nil {
+		dl.metrics.Dropped(channel, DropMalformed)
+		endSpan(span, err)
+		dl.skip(ctx, ids...)
+		return err
+	}
+	if notification.ID == 0 {
+		notification.ID = id
+	}
+	dl.chunks.hold(notification.ID, ids)
+	dl.fillTenant(notification)
+	setNotificationAttributes(span, notification)
+	dl.metrics.NotificationReceived(notification)
+	dl.tenantReceived(notification)
+	dl.rates.observe(notification)
+	dl.checkSequence(ctx, notification)
+
+	if dl.outbox != nil && notification.ID != 0 {
+		if dl.outbox.seen(notification.ID) {
+			span.End()
+			dl.ack(notification.ID)
+			return nil
+		}
+		dl.outbox.track(notification.ID)
+	}
+	if !dl.shards.owns(notification) || dl.duplicate(notification) {
+		span.End()
+		dl.skip(ctx, notification.ID)
+		return nil
+	}
+	if grouped, err := dl.group(ctx, notification); grouped {
+		return err
+	}
+	return dl.enqueue(ctx, notification)
+}
+
+// enqueue hands notification to the worker pool, or processes it inline
+// when no pool is configured. Handlers run with a context that is not
+// canceled along with Start's, so their work can drain on shutdown. The
+// notification span in ctx is ended once processing completes.
+func (dl *DataListener) enqueue(ctx context.Context, notification *ChangeNotification) error {
+	if !dl.admit(ctx, notification) {
+		return nil
+	}
+	return dl.submit(ctx, notification)
+}
+
+// submit enqueues an admitted notification.
+func (dl *DataListener) submit(ctx context.Context, notification *ChangeNotification) error {
+	dl.mu.Lock()
+	pool := dl.pool
+	dl.mu.Unlock()
+
+	if pool == nil {
+		dl.process(context.WithoutCancel(ctx), notification)
+		return nil
+	}
+	if err := pool.submit(ctx, context.WithoutCancel(ctx), dl.stop, notification); err != nil {
+		dl.inflight.Done()
+		endSpan(trace.SpanFromContext(ctx), err)
+		return err
+	}
+	dl.metrics.QueueDepth(pool.depth())
+	return nil
+}
+
+// overflowed accounts for a notification dropped from a full queue. It
+// counts as processed, so checkpoints move past it.
+func (dl *DataListener) overflowed(ctx context.Context, n *ChangeNotification) {
+	defer dl.inflight.Done()
+	dl.logger.Warn("queue full, dropping notification",
+		"channel", n.Channel, "table", n.Table, "operation", n.Operation)
+	dl.metrics.Dropped(n.Channel, DropOverflow)
+	dl.tenantDropped(n, DropOverflow)
+	endSpan(trace.SpanFromContext(ctx), errQueueFull)
+	if n.tx != nil {
+		for _, c := range n.tx.Changes {
+			if c.ID != 0 {
+				dl.complete(ctx, c.ID)
+			}
+		}
+	}
+	if n.ID != 0 {
+		dl.complete(ctx, n.ID)
+	}
+}
+
+func (dl *DataListener) process(ctx context.Context, notification *ChangeNotification) {
+	defer dl.inflight.Done()
+	if dl.workers > 1 {
+		dl.metrics.QueueDepth(dl.QueueDepth())
+	}
+
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	if notification.tx != nil {
+		dl.processTransaction(ctx, notification)
+		return
+	}
+	if notification.Ref {
+		if !dl.resolve(ctx, notification) {
+			return
+		}
+	}
+
+	ack := &ackState{}
+	if failure := dl.dispatch(context.WithValue(ctx, ackKey{}, ack), notification); failure != nil {
+		span.SetStatus(codes.Error, failure.Err.Error())
+		dl.fail(ctx, failure)
+	}
+	if notification.ID != 0 || notification.deadLetter != 0 {
+		ack.finish(func() {
+			if notification.ID != 0 {
+				dl.complete(ctx, notification.ID)
+			}
+			dl.requeued(ctx, notification)
+		})
+	}
+}
+
+// requeued deletes the dead letter a processed notification was requeued
+// from. A notification failing again was dead-lettered anew by then.
+func (dl *DataListener) requeued(ctx context.Context, n *ChangeNotification) {
+	if n.deadLetter == 0 {
+		return
+	}
+	if err := dl.deadLetters.Delete(ctx, n.deadLetter); err != nil {
+		dl.logger.Error("failed to delete requeued dead letter", "id", n.deadLetter, "error", err)
+	}
+}
+
+// complete records the event id as processed for checkpointing.
+func (dl *DataListener) complete(ctx context.Context, id int64) {
+	if dl.outbox != nil {
+		if err := dl.outbox.complete(ctx, id); err != nil {
+			dl.logger.Error("failed to save outbox offset", "id", id, "error", err)
+		}
+	}
+	dl.ack(id)
+}
+
+// ack reports the event id processed to the capture source, along with the
+// chunks it was reassembled from.
+func (dl *DataListener) ack(id int64) {
+	parts := dl.chunks.release(id)
+	if a, ok := dl.capture.(interface{ Ack(int64) }); ok {
+		for _, part := range parts {
+			a.Ack(part)
+		}
+		a.Ack(id)
+	}
+}
+
+// skip records events that are not processed, e.g. malformed or
+// duplicate ones, as processed for checkpointing.
+func (dl *DataListener) skip(ctx context.Context, ids ...int64) {
+	for _, id := range ids {
+		if id != 0 {
+			dl.complete(ctx, id)
+		}
+	}
+}
+
+// registration returns the registration handling n, routed by tenant or
+// by channel.
+func (dl *DataListener) registration(n *ChangeNotification) (*registration, bool) {
+	if reg, ok := dl.tenantRegistration(n); ok {
+		return reg, true
+	}
+	set, ok := dl.handlerSet(n.Channel)
+	if !ok {
+		return nil, false
+	}
+	return set.registration(n)
+}
+
+// dispatch calls the handler registered for the notification's table,
+// by its tenant or else its channel, retrying per its policy, and reports
+// a permanent failure.
+func (dl *DataListener) dispatch(ctx context.Context, notification *ChangeNotification) *Failure {
+	reg, ok := dl.registration(notification)
+	if !ok {
+		return nil
+	}
+	if reg.filter != nil && !dl.filter(notification, reg) {
+		return nil
+	}
+	if reg.redaction != nil && !dl.redact(notification, reg) {
+		return nil
+	}
+	if reg.transform != nil && !dl.transform(notification, reg) {
+		return nil
+	}
+	if reg.schema != nil {
+		if ok, failure := dl.validate(ctx, notification, reg); !ok {
+			return failure
+		}
+	}
+	if reg.debouncer != nil && dl.debounce(ctx, notification, reg) {
+		return nil
+	}
+	return dl.deliver(ctx, notification, reg)
+}
+
+// deliver calls a handler within its rate limit.
+func (dl *DataListener) deliver(ctx context.Context, notification *ChangeNotification, reg *registration) *Failure {
+	if reg.limiter != nil {
+		if limited, failure := dl.limit(ctx, notification, reg); limited {
+			return failure
+		}
+	}
+	return dl.call(ctx, notification, reg)
+}
+
+// call invokes a handler, through its circuit breaker if it has one.
+func (dl *DataListener) call(ctx context.Context, notification *ChangeNotification, reg *registration) *Failure {
+	if reg.circuit != nil {
+		return dl.dispatchCircuit(ctx, notification, reg)
+	}
+	return dl.invoke(ctx, notification, reg)
+}
+
+// invoke calls a registered handler through the middleware chain, with its
+// retry policy and timeout.
+func (dl *DataListener) invoke(ctx context.Context, notification *ChangeNotification, reg *registration) *Failure {
+	policy := dl.retryPolicy()
+	if reg.retry != nil {
+		policy = *reg.retry
+	}
+	timeout := dl.timeout
+	if reg.timeout != nil {
+		timeout = *reg.timeout
+	}
+
+	if dl.panicBreaker != nil && reg.panics.disabled(time.Now()) {
+		now := time.Now()
+		failure := &Failure{Notification: notification, Err: ErrHandlerDisabled, FirstAttempt: now, LastAttempt: now}
+		reg.stats.record(failure)
+		return failure
+	}
+
+	handler := dl.chain(reg.handler)
+	parent, first := ctx, time.Now()
+	st := &ackState{}
+	ctx, span := dl.tracer.Start(context.WithValue(ctx, ackKey{}, st), "handle "+notification.Table)
+	attempt := 0
+	failure := callWithRetry(ctx, policy, notification, func() error {
+		attempt++
+		span.SetAttributes(attrAttempt.Int(attempt))
+		start := time.Now()
+		err := callWithTimeout(ctx, timeout, func(ctx context.Context) error { return handler(ctx, notification) })
+		elapsed := time.Since(start)
+		var pe *PanicError
+		panicked := errors.As(err, &pe)
+		if panicked {
+			dl.handlerPanicked(notification, pe)
+		}
+		if errors.Is(err, ErrHandlerTimeout) {
+			dl.metrics.HandlerTimedOut(notification)
+		}
+		if dl.panicBreaker != nil && reg.panics.record(dl.panicBreaker, panicked, time.Now()) {
+			dl.logger.Error("disabling handler after repeated panics",
+				"channel", notification.Channel, "table", notification.Table, "cooldown", dl.panicBreaker.Cooldown)
+		}
+		dl.metrics.HandlerCompleted(notification, elapsed, err)
+		dl.tenantHandled(notification, err)
+		if err != nil {
+			dl.logger.Warn("handler failed",
+				"channel", notification.Channel, "table", notification.Table,
+				"operation", notification.Operation, "duration", elapsed, "error", err)
+		} else {
+			dl.logger.Debug("handled notification",
+				"channel", notification.Channel, "table", notification.Table,
+				"operation", notification.Operation, "duration", elapsed)
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+		return err
+	})
+	reg.stats.record(failure)
+	if failure != nil {
+		span.SetStatus(codes.Error, failure.Err.Error())
+	}
+	span.End()
+	dl.acknowledge(parent, notification, reg, policy, st, first, failure != nil)
+	return failure
+}
+
+// resolve fetches the row of a by-reference notification, reporting whether
+// it should be dispatched.
+func (dl *DataListener) resolve(ctx context.Context, n *ChangeNotification) bool {
+	ctx, span := dl.tracer.Start(ctx, "fetch "+n.Table)
+	failure := callWithRetry(ctx, dl.retryPolicy(), n, func() error {
+		return dl.resolveReference(ctx, n)
+	})
+	if failure == nil {
+		span.End()
+		n.Ref = false
+		return true
+	}
+	endSpan(span, failure.Err)
+
+	if errors.Is(failure.Err, errRowGone) {
+		dl.logger.Debug("skipping reference to deleted row",
+			"channel", n.Channel, "table", n.Table, "operation", n.Operation)
+		dl.metrics.Dropped(n.Channel, DropRowGone)
+		dl.tenantDropped(n, DropRowGone)
+		return false
+	}
+	dl.fail(ctx, failure)
+	return false
+}
+
+func (dl *DataListener) fail(ctx context.Context, f *Failure) {
+	n := f.Notification
+	if dl.deadLetters != nil {
+		if err := dl.deadLetter(ctx, f); err != nil {
+			dl.logger.Error("failed to dead-letter notification",
+				"channel", n.Channel, "table", n.Table, "operation", n.Operation, "error", err)
+		}
+	} else {
+		dl.metrics.Dropped(n.Channel, DropFailed)
+		dl.tenantDropped(n, DropFailed)
+	}
+	if onError := dl.errorHandler(); onError != nil {
+		onError(ctx, f)
+		return
+	}
+	dl.logger.Error("giving up on notification",
+		"channel", n.Channel, "table", n.Table, "operation", n.Operation,
+		"attempts", f.Attempts, "duration", f.LastAttempt.Sub(f.FirstAttempt), "error", f.Err)
+}
+
+func (dl *DataListener) deadLetter(ctx context.Context, f *Failure) error {
+	d, err := newDeadLetter(f)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	return dl.deadLetters.Put(ctx, d)
+}
+
+// DeadLetters lists dead-lettered notifications.
+func (dl *DataListener) DeadLetters(ctx context.Context, q DeadLetterQuery) ([]*DeadLetter, error) {
+	if dl.deadLetters == nil {
+		return nil, nil
+	}
+	return dl.deadLetters.List(ctx, q)
+}
+
+// DeadLetterCount returns how many notifications are dead-lettered, zero
+// without a store.
+func (dl *DataListener) DeadLetterCount(ctx context.Context) (int64, error) {
+	if dl.deadLetters == nil {
+		return 0, nil
+	}
+	c, ok := dl.deadLetters.(DeadLetterCounter)
+	if !ok {
+		return 0, ErrNoDeadLetterCount
+	}
+	return c.Count(ctx)
+}
+
+// Requeue processes the given dead letters again and returns how many were
+// handed to the queue. Each is removed from the store once processed, so
+// one held while paused, dropped from a full queue or still queued when the
+// listener stops is kept. Notifications failing again are dead-lettered
+// anew.
+func (dl *DataListener) Requeue(ctx context.Context, ids ...int64) (int, error) {
+	if dl.deadLetters == nil || len(ids) == 0 {
+		return 0, nil
+	}
+
+	letters, err := dl.deadLetters.List(ctx, DeadLetterQuery{IDs: ids})
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, d := range letters {
+		n, err := d.Notification()
+		if err != nil {
+			return requeued, fmt.Errorf("failed to decode dead letter %d: %w", d.ID, err)
+		}
+		ctx, span := dl.startNotificationSpan(ctx, "requeue "+n.Channel, n.Channel)
+		setNotificationAttributes(span, n)
+		n.deadLetter = d.ID
+		if err := dl.enqueue(ctx, n); err != nil {
+			return requeued, err
+		}
+		requeued++
+	}
+	return requeued, nil
+}
+
+func (dl *DataListener) catchUp(ctx context.Context) error {
+	if dl.outbox == nil {
+		return nil
+	}
+	return dl.outbox.catchUp(ctx, dl.Channels(), dl.deliverOutbox(ctx))
+}
+
+// sweepOutbox delivers outbox events whose transaction committed late and
+// whose notifications have not arrived yet, and moves the checkpoint.
+func (dl *DataListener) sweepOutbox(ctx context.Context) {
+	if dl.outbox == nil {
+		return
+	}
+	if err := dl.outbox.sweep(ctx, dl.Channels(), dl.deliverOutbox(ctx)); err != nil {
+		dl.logger.Warn("outbox sweep failed", "error", err)
+	}
+}
+
+// deliverOutbox returns the function handing events read from the outbox
+// to the pipeline.
+func (dl *DataListener) deliverOutbox(ctx context.Context) func(*ChangeNotification) error {
+	return func(n *ChangeNotification) error {
+		dl.fillTenant(n)
+		dl.metrics.NotificationReceived(n)
+		dl.tenantReceived(n)
+		dl.rates.observe(n)
+		if n.Seq != 0 {
+			dl.sequences.observe(n.Channel, n.Seq)
+		}
+		if dl.duplicate(n) {
+			dl.complete(ctx, n.ID)
+			return nil
+		}
+		ctx, span := dl.startNotificationSpan(ctx, "catch-up "+n.Channel, n.Channel)
+		setNotificationAttributes(span, n)
+		if grouped, err := dl.group(ctx, n); grouped {
+			return err
+		}
+		return dl.enqueue(ctx, n)
+	}
+}
+
+// QueueCapacity returns how many notifications the queue holds, or 0 when
+// notifications are processed on the receive loop.
+func (dl *DataListener) QueueCapacity() int {
+	dl.mu.Lock()
+	pool := dl.pool
+	dl.mu.Unlock()
+
+	if pool == nil {
+		return 0
+	}
+	return pool.capacity()
+}
+
+// QueueDepth returns the number of notifications waiting for a worker.
+func (dl *DataListener) QueueDepth() int {
+	dl.mu.Lock()
+	pool := dl.pool
+	dl.mu.Unlock()
+
+	if pool == nil {
+		return 0
+	}
+	return pool.depth()
+}
+
+// ReadOutbox returns up to limit outbox events after afterID on the
+// listened channels, e.g. to let clients resume from the last event they
+// saw. It does not affect the listener's own offset.
+func (dl *DataListener) ReadOutbox(ctx context.Context, afterID int64, limit int) ([]*ChangeNotification, error) {
+	if dl.outbox == nil {
+		return nil, nil
+	}
+	return dl.outbox.read(ctx, dl.Channels(), afterID, limit)
+}
+
+// PruneOutbox deletes outbox events already processed by every consumer.
+func (dl *DataListener) PruneOutbox(ctx context.Context) (int64, error) {
+	if dl.outbox == nil {
+		return 0, nil
+	}
+	return dl.outbox.prune(ctx)
+}
+
+// Start listens for notifications until ctx is canceled or Shutdown is
+// called. In-flight handler calls are drained before it returns.
+func (dl *DataListener) Start(ctx context.Context) error {
+	dl.mu.Lock()
+	if dl.running {
+		dl.mu.Unlock()
+		return ErrAlreadyStarted
+	}
+	dl.running = true
+	dl.mu.Unlock()
+	defer close(dl.done)
+
+	if init, ok := dl.deadLetters.(interface{ Init(context.Context) error }); ok {
+		if err := init.Init(ctx); err != nil {
+			return err
+		}
+	}
+
+	if dl.workers > 1 || dl.queued {
+		pool := newWorkerPool(dl.workers, dl.queueSize, dl.lanes, dl.poolRoute, dl.process)
+		pool.overflow = dl.overflow
+		pool.drop = dl.overflowed
+		pool.blocked = dl.metrics.QueueBlocked
+		dl.mu.Lock()
+		dl.pool = pool
+		dl.mu.Unlock()
+		defer func() {
+			pool.close()
+			dl.mu.Lock()
+			dl.pool = nil
+			dl.mu.Unlock()
+		}()
+	}
+
+	if dl.leader != nil {
+		if ok, err := dl.acquireLeadership(ctx); !ok {
+			return err
+		}
+		defer dl.watchLeadership()()
+	}
+	defer dl.watchRates()()
+
+	// A reconnect requested before Start has nothing to replace.
+	select {
+	case <-dl.reconnect:
+	default:
+	}
+	return dl.run(ctx, dl.capture)
+}
+
+func (dl *DataListener) connOptions() connOptions {
+	return connOptions{tls: dl.tls, password: dl.password}
+}
+
+// defaultCapture sets up the source used without WithCapture.
+func (dl *DataListener) defaultCapture() error {
+	if dl.replication != nil {
+		if dl.polling != nil {
+			return errors.New("replication and polling cannot be combined")
+		}
+		dl.capture = NewReplicationSource(dl.db, dl.listenConnStr, *dl.replication)
+		return nil
+	}
+	if dl.polling != nil {
+		dl.capture = NewPollingSource(dl.db, *dl.polling)
+		return nil
+	}
+	src, err := NewListenSource(ListenConfig{
+		ConnString:   dl.listenConnStr,
+		Driver:       dl.driver,
+		TLS:          dl.tlsCfg,
+		Password:     dl.password,
+		MinReconnect: dl.minReconnect,
+		MaxReconnect: dl.maxReconnect,
+	})
+	if err != nil {
+		return err
+	}
+	dl.capture = src
+	return nil
+}
+
+// run captures changes from src until ctx is canceled or Shutdown is
+// called.
+func (dl *DataListener) run(ctx context.Context, src CaptureSource) error {
+	if _, ok := src.(*ReplicationSource); ok && dl.outbox != nil {
+		return errors.New("outbox delivery is not supported in replication mode")
+	}
+	if dl.shards != nil {
+		// Every instance would share the polling checkpoint, and move it
+		// past the changes of the shards it skips.
+		_, polling := src.(*PollingSource)
+		if _, ok := src.(*ReplicationSource); ok || polling || dl.outbox != nil || dl.transactions != nil {
+			return errors.New("sharding is not supported with the outbox, polling, replication or transaction grouping")
+		}
+		if err := dl.shards.start(ctx, dl.db, dl.logger); err != nil {
+			return err
+		}
+		defer dl.shards.close()
+	}
+	if a, ok := src.(attacher); ok {
+		a.attach(dl)
+	}
+	if err := src.Start(ctx, dl.Channels()); err != nil {
+		return err
+	}
+	dl.mu.Lock()
+	dl.source = src
+	dl.mu.Unlock()
+	dl.conn.pinged()
+	defer func() {
+		dl.mu.Lock()
+		dl.source = nil
+		dl.mu.Unlock()
+		if err := src.Stop(); err != nil {
+			dl.logger.Warn("failed to stop capture", "error", err)
+		}
+	}()
+
+	// LISTEN starts, or the replication slot exists, before the snapshot
+	// reads, so changes made during the snapshot are handled afterwards.
+	if dl.snapshotCfg != nil {
+		if err := dl.snapshot(ctx); err != nil {
+			if errors.Is(err, errStopped) {
+				return dl.shutdown()
+			}
+			return err
+		}
+	}
+
+	if dl.outbox != nil {
+		dl.outbox.logger = dl.logger
+		if err := dl.outbox.init(ctx); err != nil {
+			return err
+		}
+		if err := dl.catchUp(ctx); err != nil {
+			return err
+		}
+	}
+
+	ping := time.NewTimer(dl.pingInterval)
+	defer ping.Stop()
+	// The ping timer restarts with every notification; the outbox sweep
+	// runs regardless.
+	var sweep <-chan time.Time
+	if dl.outbox != nil {
+		t := time.NewTicker(dl.pingInterval)
+		defer t.Stop()
+		sweep = t.C
+	}
+
+	// A failed reconnect is retried with the reconnect backoff rather than
+	// stopping, e.g. while the primary is briefly down during a failover.
+	var (
+		retry      <-chan time.Time
+		retryDelay = dl.minReconnect
+	)
+	reconnect := func(r interface{ Reconnect() error }) {
+		if err := r.Reconnect(); err != nil {
+			dl.logger.Error("reconnect failed", "error", err, "retry_in", retryDelay)
+			retry = time.After(retryDelay)
+			retryDelay = min(2*retryDelay, dl.maxReconnect)
+			return
+		}
+		retry, retryDelay = nil, dl.minReconnect
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return dl.shutdown()
+		case <-dl.stop:
+			return dl.shutdown()
+		case <-dl.leader.lostLeadership():
+			return errors.Join(ErrLeadershipLost, dl.shutdown())
+		case ev, ok := <-src.Notifications():
+			if !ok {
+				return errSourceClosed
+			}
+			dl.receive(ctx, ev)
+			resetTimer(ping, dl.pingInterval)
+		case <-dl.reconnect:
+			r, ok := src.(interface{ Reconnect() error })
+			if !ok {
+				dl.logger.Warn("capture source does not support reconnecting")
+				continue
+			}
+			dl.logger.Info("reconnecting on request")
+			reconnect(r)
+			resetTimer(ping, dl.pingInterval)
+		case <-retry:
+			dl.logger.Info("retrying reconnect")
+			reconnect(src.(interface{ Reconnect() error }))
+			resetTimer(ping, dl.pingInterval)
+		case <-ping.C:
+			// There is no connection to ping until the retried reconnect
+			// succeeds.
+			p, ok := src.(interface{ Ping() error })
+			if ok && retry == nil {
+				if err := p.Ping(); err != nil {
+					return err
+				}
+				dl.conn.pinged()
+			} else if !ok && dl.sourceConnected(src) {
+				dl.conn.pinged()
+			}
+			dl.expireChunks(ctx)
+			dl.expireTransactions(ctx)
+			ping.Reset(dl.pingInterval)
+		case <-sweep:
+			dl.sweepOutbox(ctx)
+		case fn := <-dl.scheduled:
+			fn()
+		}
+	}
+}
+
+// receive handles an event from the capture source.
+func (dl *DataListener) receive(ctx context.Context, ev Event) {
+	if ev.Reconnected {
+		dl.metrics.Reconnected()
+		dl.resume(ctx)
+		return
+	}
+
+	dl.conn.notified()
+	var err error
+	if ev.Notification != nil {
+		err = dl.handleChange(ctx, ev.Notification)
+	} else {
+		err = dl.handleNotification(ctx, ev.Channel, ev.Payload, ev.ID)
+	}
+	if err != nil {
+		dl.logger.Error("failed to handle notification", "channel", ev.Channel, "error", err)
+	}
+}
+
+// resume catches up from the outbox after the source reconnected, since
+// anything sent while it was disconnected is only there. Live notifications
+// wait until the catch-up succeeded: handling them first would move the
+// read position past the missed events. A failed catch-up is retried with
+// the reconnect backoff and continues after the last event it delivered.
+func (dl *DataListener) resume(ctx context.Context) {
+	if dl.outbox == nil {
+		return
+	}
+	delay := dl.minReconnect
+	for {
+		err := dl.catchUp(ctx)
+		if err == nil {
+			return
+		}
+		dl.logger.Error("outbox catch-up failed", "error", err, "retry_in", delay)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-dl.stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		delay = min(2*delay, dl.maxReconnect)
+	}
+}
+
+// handleChange handles a change the capture source decoded itself.
+func (dl *DataListener) handleChange(ctx context.Context, n *ChangeNotification) error {
+	dl.fillTenant(n)
+	ctx, span := dl.startNotificationSpan(ctx, "receive "+n.Channel, n.Channel)
+	setNotificationAttributes(span, n)
+	if !n.Commit {
+		dl.metrics.NotificationReceived(n)
+		dl.tenantReceived(n)
+		dl.rates.observe(n)
+		if dl.duplicate(n) {
+			span.End()
+			if n.ID != 0 {
+				dl.complete(ctx, n.ID)
+			}
+			return nil
+		}
+	}
+	if grouped, err := dl.group(ctx, n); grouped {
+		return err
+	}
+	return dl.enqueue(ctx, n)
+}
+
+// sourceConnected reports whether src is connected, assuming it is when
+// it does not say.
+func (dl *DataListener) sourceConnected(src CaptureSource) bool {
+	if c, ok := src.(interface{ Connected() bool }); ok {
+		return c.Connected()
+	}
+	return true
+}
+
+// Reconnect replaces the capture source's connection, the LISTEN
+// connection or the replication stream, with a new one, e.g. after a
+// failover left it on a read-only node. With LISTEN, notifications sent
+// while reconnecting are only recovered from the outbox. It has no effect
+// unless Start is running.
+func (dl *DataListener) Reconnect() {
+	select {
+	case dl.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+func (dl *DataListener) expireChunks(ctx context.Context) {
+	for _, p := range dl.chunks.expire(time.Now()) {
+		dl.logger.Warn("dropping incomplete chunked payload", "channel", p.channel)
+		dl.metrics.Dropped(p.channel, DropIncomplete)
+		dl.skip(ctx, p.ids...)
+	}
+}
+
+func (dl *DataListener) shutdown() error {
+	dl.logger.Info("stopping listener")
+	return dl.drain()
+}
+
+// drain waits for in-flight handler calls, up to the drain timeout.
+func (dl *DataListener) drain() error {
+	drained := make(chan struct{})
+	go func() {
+		dl.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-time.After(dl.drainTimeout):
+		return fmt.Errorf("drain timeout after %s", dl.drainTimeout)
+	}
+}
+
+// Shutdown stops a running Start loop and waits for it to drain, giving up
+// when ctx is done.
+func (dl *DataListener) Shutdown(ctx context.Context) error {
+	dl.stopOnce.Do(func() { close(dl.stop) })
+
+	dl.mu.Lock()
+	running := dl.running
+	dl.mu.Unlock()
+	if !running {
+		return nil
+	}
+
+	select {
+	case <-dl.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (dl *DataListener) Close() error {
+	return dl.db.Close()
+}
+
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
@@ -0,0 +1,223 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCapture is a capture source recording the event ids acknowledged.
+type fakeCapture struct {
+	mu    sync.Mutex
+	acked []int64
+}
+
+func (c *fakeCapture) Start(context.Context, []string) error { return nil }
+
+func (c *fakeCapture) Notifications() <-chan Event { return nil }
+
+func (c *fakeCapture) Stop() error { return nil }
+
+func (c *fakeCapture) Ack(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acked = append(c.acked, id)
+}
+
+func (c *fakeCapture) ids() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Sorted(slices.Values(c.acked))
+}
+
+// newTestListener returns a listener without a database that captures
+// from the returned source. Tests feed it notifications with
+// handleNotification.
+func newTestListener(t *testing.T, opts ...Option) (*DataListener, *fakeCapture) {
+	t.Helper()
+	capture := &fakeCapture{}
+	dl := newListener(nil, "", append([]Option{WithCapture(capture)}, opts...)...)
+	return dl, capture
+}
+
+// startTestPool starts the worker pool Start would, closing it at the end
+// of the test.
+func startTestPool(t *testing.T, dl *DataListener, workers int) {
+	t.Helper()
+	dl.workers = workers
+	pool := newWorkerPool(dl.workers, dl.queueSize, dl.lanes, dl.poolRoute, dl.process)
+	pool.overflow = dl.overflow
+	pool.drop = dl.overflowed
+	pool.blocked = dl.metrics.QueueBlocked
+	dl.pool = pool
+	t.Cleanup(func() {
+		dl.stopOnce.Do(func() { close(dl.stop) })
+		pool.close()
+	})
+}
+
+// runScheduled runs the functions scheduled on the receive loop, as run
+// does, until the end of the test.
+func runScheduled(t *testing.T, dl *DataListener) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		for {
+			select {
+			case fn := <-dl.scheduled:
+				fn()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// idle reports whether every notification in flight completed within d.
+func idle(dl *DataListener, d time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		dl.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// payload returns a notification payload of table users for row id.
+func payload(op string, id int, fields string) string {
+	return fmt.Sprintf(`{"table":"users","operation":%q,"key":{"id":%d},"data":{"id":%d%s}}`, op, id, id, fields)
+}
+
+// handled records the notifications a handler received.
+type handled struct {
+	mu sync.Mutex
+	ns []*ChangeNotification
+}
+
+func (h *handled) HandleNotification(_ context.Context, n *ChangeNotification) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ns = append(h.ns, n)
+	return nil
+}
+
+func (h *handled) operations() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var ops []string
+	for _, n := range h.ns {
+		ops = append(ops, n.Operation)
+	}
+	return ops
+}
+
+func TestSkippedEventsComplete(t *testing.T) {
+	chunks := chunkPayloads("c1", payload(OpInsert, 1, ""), 2)
+	type event struct {
+		payload string
+		id      int64
+	}
+	tests := []struct {
+		name       string
+		opts       []Option
+		events     []event
+		expire     bool
+		wantAcked  []int64
+		wantHandle int
+	}{
+		{
+			name:      "malformed",
+			events:    []event{{`{"table":`, 1}},
+			wantAcked: []int64{1},
+		},
+		{
+			name:       "handled",
+			events:     []event{{payload(OpInsert, 1, ""), 1}},
+			wantAcked:  []int64{1},
+			wantHandle: 1,
+		},
+		{
+			name:       "unhandled table",
+			events:     []event{{`{"table":"other","operation":"INSERT","data":{}}`, 1}},
+			wantAcked:  []int64{1},
+			wantHandle: 0,
+		},
+		{
+			name:       "duplicate",
+			opts:       []Option{WithDedup(DedupConfig{Key: func(n *ChangeNotification) string { return string(n.Data) }})},
+			events:     []event{{payload(OpInsert, 1, ""), 1}, {payload(OpInsert, 1, ""), 2}},
+			wantAcked:  []int64{1, 2},
+			wantHandle: 1,
+		},
+		{
+			name:       "reassembled chunks",
+			events:     []event{{chunks[0], 1}, {chunks[1], 2}},
+			wantAcked:  []int64{1, 2},
+			wantHandle: 1,
+		},
+		{
+			name:      "malformed chunk",
+			events:    []event{{`{"chunk":{"id":"c1","index":5,"total":2},"part":""}`, 1}},
+			wantAcked: []int64{1},
+		},
+		{
+			name:      "malformed reassembled payload",
+			events:    []event{{chunkPayloads("c2", `{"table":`, 2)[0], 1}, {chunkPayloads("c2", `{"table":`, 2)[1], 2}},
+			wantAcked: []int64{1, 2},
+		},
+		{
+			name:      "incomplete chunks expire",
+			events:    []event{{chunks[0], 1}},
+			expire:    true,
+			wantAcked: []int64{1},
+		},
+		{
+			name:      "incomplete chunks wait",
+			events:    []event{{chunks[0], 1}},
+			wantAcked: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl, capture := newTestListener(t, tt.opts...)
+			h := &handled{}
+			dl.Handle("users", h)
+
+			ctx := context.Background()
+			for _, ev := range tt.events {
+				dl.handleNotification(ctx, DefaultChannel, ev.payload, ev.id)
+			}
+			if tt.expire {
+				dl.chunks.timeout = -1
+				dl.expireChunks(ctx)
+			}
+			if !idle(dl, time.Second) {
+				t.Fatal("notifications still in flight")
+			}
+			if got := capture.ids(); !slices.Equal(got, tt.wantAcked) {
+				t.Errorf("acked %v, want %v", got, tt.wantAcked)
+			}
+			if got := len(h.operations()); got != tt.wantHandle {
+				t.Errorf("handled %d notifications, want %d", got, tt.wantHandle)
+			}
+		})
+	}
+}
+
+func decodeData(t *testing.T, n *ChangeNotification) map[string]any {
+	t.Helper()
+	var row map[string]any
+	if err := json.Unmarshal(n.Data, &row); err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
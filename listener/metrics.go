@@ -0,0 +1,40 @@
+package listener
+
+import "time"
+
+const (
+	DropMalformed = "malformed"
+	DropFailed    = "failed"
+)
+
+// Metrics receives instrumentation events from the listener. Implementations
+// should embed NopMetrics so they keep compiling when events are added.
+type Metrics interface {
+	NotificationReceived(n *ChangeNotification)
+	HandlerCompleted(n *ChangeNotification, d time.Duration, err error)
+	Reconnected()
+	QueueDepth(depth int)
+	Dropped(channel, reason string)
+	ReplicationSlot(s *SlotInfo)
+	SequenceGap(channel string, missed int64)
+	HandlerPanicked(n *ChangeNotification)
+	HandlerTimedOut(n *ChangeNotification)
+	CircuitChanged(n *ChangeNotification, open bool)
+	QueueBlocked(d time.Duration)
+	Paused(paused bool)
+}
+
+type NopMetrics struct{}
+
+func (NopMetrics) NotificationReceived(*ChangeNotification)                   {}
+func (NopMetrics) HandlerCompleted(*ChangeNotification, time.Duration, error) {}
+func (NopMetrics) Reconnected()                                               {}
+func (NopMetrics) QueueDepth(int)                                             {}
+func (NopMetrics) Dropped(string, string)                                     {}
+func (NopMetrics) ReplicationSlot(*SlotInfo)                                  {}
+func (NopMetrics) SequenceGap(string, int64)                                  {}
+func (NopMetrics) HandlerPanicked(*ChangeNotification)                        {}
+func (NopMetrics) HandlerTimedOut(*ChangeNotification)                        {}
+func (NopMetrics) CircuitChanged(*ChangeNotification, bool)                   {}
+func (NopMetrics) QueueBlocked(time.Duration)                                 {}
+func (NopMetrics) Paused(bool)                                                {}
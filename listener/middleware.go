@@ -0,0 +1,35 @@
+package listener
+
+import "context"
+
+// HandlerFunc adapts a function to NotificationHandler.
+type HandlerFunc func(ctx context.Context, n *ChangeNotification) error
+
+func (f HandlerFunc) HandleNotification(ctx context.Context, n *ChangeNotification) error {
+	return f(ctx, n)
+}
+
+// Middleware wraps every handler call, e.g. for logging, metrics or
+// validation. Returning an error without calling next rejects the
+// notification; it is retried like any handler error.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use appends middleware to the chain. The first middleware added is the
+// outermost, and each retry attempt goes through the whole chain.
+func (dl *DataListener) Use(mw ...Middleware) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.middleware = append(dl.middleware[:len(dl.middleware):len(dl.middleware)], mw...)
+}
+
+func (dl *DataListener) chain(handler NotificationHandler) HandlerFunc {
+	dl.mu.Lock()
+	mws := dl.middleware
+	dl.mu.Unlock()
+
+	h := HandlerFunc(handler.HandleNotification)
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
@@ -0,0 +1,161 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	OpInsert = "INSERT"
+	OpUpdate = "UPDATE"
+	OpDelete = "DELETE"
+)
+
+// DefaultSchema is assumed for notifications without a schema and for
+// handlers registered with unqualified table names.
+const DefaultSchema = "public"
+
+type ChangeNotification struct {
+	ID int64 `json:"id,omitempty"`
+	// Seq numbers the notifications of a channel consecutively when the
+	// trigger is installed with trigger.WithSequence.
+	Seq       int64           `json:"seq,omitempty"`
+	Channel   string          `json:"-"`
+	Schema    string          `json:"schema,omitempty"`
+	Table     string          `json:"table"`
+	Operation string          `json:"operation"`
+	Key       json.RawMessage `json:"key,omitempty"`
+	Data      json.RawMessage `json:"data"`
+	Old       json.RawMessage `json:"old,omitempty"`
+	New       json.RawMessage `json:"new,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	// Tenant is the tenant the change belongs to, sent by the trigger or
+	// read from the row with WithTenants.
+	Tenant string `json:"tenant,omitempty"`
+	// Actor is who made the change, sent by triggers installed with
+	// trigger.WithActor.
+	Actor string `json:"actor,omitempty"`
+	// Ref marks a payload carrying only the key. The listener fetches
+	// the row before dispatching, so handlers never see Ref set.
+	Ref bool `json:"ref,omitempty"`
+	// Snapshot marks rows read by the initial snapshot rather than changes.
+	Snapshot bool `json:"snapshot,omitempty"`
+	// TxID is the id of the writing transaction, sent by triggers
+	// installed with trigger.WithTransactions and by logical replication.
+	TxID int64 `json:"txid,omitempty"`
+	// Commit marks the commit marker that follows the Changes changes of
+	// transaction TxID. Markers never reach handlers.
+	Commit  bool `json:"commit,omitempty"`
+	Changes int  `json:"changes,omitempty"`
+	// ValidationErrors are the reasons a notification passed to the
+	// quarantine failed its schema, see WithSchema.
+	ValidationErrors []string `json:"validation_errors,omitempty"`
+
+	tx *Transaction
+	// deadLetter is the id of the dead letter the notification was
+	// requeued from, deleted once it is processed.
+	deadLetter int64
+	// redacted is set once WithRedaction applied, so a notification
+	// dispatched again is not redacted twice.
+	redacted bool
+	// transformed is set once WithTransform applied.
+	transformed bool
+}
+
+func decodeNotification(channel string, payload []byte) (*ChangeNotification, error) {
+	var n ChangeNotification
+	if err := json.Unmarshal(payload, &n); err != nil {
+		return nil, fmt.Errorf("failed to parse notification: %w", err)
+	}
+	n.Channel = channel
+	n.fillImages()
+	return &n, nil
+}
+
+// QualifiedTable returns "schema.table", using DefaultSchema when the
+// payload carried no schema.
+func (n *ChangeNotification) QualifiedTable() string {
+	schema := n.Schema
+	if schema == "" {
+		schema = DefaultSchema
+	}
+	return schema + "." + n.Table
+}
+
+// routingNames returns the names a notification can be routed by: the
+// qualified name, plus the bare table name for the default schema.
+func (n *ChangeNotification) routingNames() []string {
+	if n.Schema == "" || n.Schema == DefaultSchema {
+		return []string{n.QualifiedTable(), n.Table}
+	}
+	return []string{n.QualifiedTable()}
+}
+
+// fillImages derives Old and New from Data so handlers can rely on them
+// regardless of operation: INSERT has only New, DELETE only Old.
+func (n *ChangeNotification) fillImages() {
+	if isNull(n.Old) {
+		n.Old = nil
+	}
+	if isNull(n.New) {
+		n.New = nil
+	}
+	if isNull(n.Key) {
+		n.Key = nil
+	}
+	switch n.Operation {
+	case OpInsert, OpUpdate:
+		if n.New == nil {
+			n.New = n.Data
+		}
+	case OpDelete:
+		if n.Old == nil {
+			n.Old = n.Data
+		}
+	}
+}
+
+// ChangedColumns returns the sorted names of columns whose value differs
+// between Old and New. Columns only present in one image count as changed.
+func (n *ChangeNotification) ChangedColumns() ([]string, error) {
+	oldRow, err := decodeRow(n.Old)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode old row: %w", err)
+	}
+	newRow, err := decodeRow(n.New)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode new row: %w", err)
+	}
+
+	var changed []string
+	for col, nv := range newRow {
+		if ov, ok := oldRow[col]; !ok || !bytes.Equal(ov, nv) {
+			changed = append(changed, col)
+		}
+	}
+	for col := range oldRow {
+		if _, ok := newRow[col]; !ok {
+			changed = append(changed, col)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func decodeRow(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	row := make(map[string]json.RawMessage)
+	if isNull(raw) {
+		return row, nil
+	}
+	if err := json.Unmarshal(raw, &row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+}
@@ -0,0 +1,270 @@
+package listener
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	DefaultChannel              = "data_changes"
+	DefaultMinReconnectInterval = 10 * time.Second
+	DefaultMaxReconnectInterval = time.Minute
+	DefaultPingInterval         = 15 * time.Second
+	DefaultDrainTimeout         = 30 * time.Second
+)
+
+type Option func(*DataListener)
+
+// WithChannel adds a NOTIFY channel served by the default handler set.
+// DefaultChannel is only used when no channel is configured.
+func WithChannel(channel string) Option {
+	return func(dl *DataListener) {
+		dl.channels[channel] = dl.defaultSet
+	}
+}
+
+// WithChannels adds several channels served by the default handler set.
+func WithChannels(channels ...string) Option {
+	return func(dl *DataListener) {
+		for _, ch := range channels {
+			dl.channels[ch] = dl.defaultSet
+		}
+	}
+}
+
+// WithChannelHandlers adds a channel whose notifications are routed to set
+// instead of the default handler set.
+func WithChannelHandlers(channel string, set *HandlerSet) Option {
+	return func(dl *DataListener) {
+		dl.channels[channel] = set
+	}
+}
+
+// WithReconnectInterval sets the backoff bounds used to re-establish the
+// LISTEN connection or the replication stream when it is lost.
+func WithReconnectInterval(min, max time.Duration) Option {
+	return func(dl *DataListener) {
+		dl.minReconnect = min
+		dl.maxReconnect = max
+	}
+}
+
+// WithPingInterval sets how long the listener waits without notifications
+// before pinging the server to check the connection.
+func WithPingInterval(d time.Duration) Option {
+	return func(dl *DataListener) {
+		dl.pingInterval = d
+	}
+}
+
+// WithDrainTimeout bounds how long shutdown waits for in-flight handler
+// calls to finish.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(dl *DataListener) {
+		dl.drainTimeout = d
+	}
+}
+
+// WithOutbox enables at-least-once delivery from the trigger outbox table.
+func WithOutbox(cfg OutboxConfig) Option {
+	return func(dl *DataListener) {
+		dl.outbox = newOutbox(dl.db, cfg)
+	}
+}
+
+// WithDedup drops notifications already delivered within a time and size
+// window, e.g. those replayed by a catch-up after a reconnect.
+func WithDedup(cfg DedupConfig) Option {
+	return func(dl *DataListener) {
+		dl.dedup = newDedup(cfg)
+	}
+}
+
+// WithGapHandler sets a callback for gaps in notification sequence numbers,
+// which are also logged and counted.
+func WithGapHandler(fn GapHandler) Option {
+	return func(dl *DataListener) {
+		dl.onGap = fn
+	}
+}
+
+// WithGapCatchUp replays missed notifications from the outbox when a gap is
+// detected. It requires WithOutbox.
+func WithGapCatchUp() Option {
+	return func(dl *DataListener) {
+		dl.gapCatchUp = true
+	}
+}
+
+// WithReplication captures changes from a logical replication slot instead
+// of LISTEN/NOTIFY, with a ReplicationSource on the listen connection
+// string. It cannot be combined with WithOutbox.
+func WithReplication(cfg ReplicationConfig) Option {
+	return func(dl *DataListener) {
+		dl.replication = &cfg
+	}
+}
+
+// WithPolling captures changes by polling a table with a PollingSource
+// instead of LISTEN/NOTIFY, e.g. behind a PgBouncer in transaction pooling
+// mode, where notifications are not delivered. No listen connection is
+// opened. Combined with WithOutbox on the same table, events written while
+// the listener was stopped are replayed on start.
+func WithPolling(cfg PollingConfig) Option {
+	return func(dl *DataListener) {
+		dl.polling = &cfg
+	}
+}
+
+// WithSnapshot streams the current rows of the listened tables through the
+// handlers before live changes on the first start.
+func WithSnapshot(cfg SnapshotConfig) Option {
+	return func(dl *DataListener) {
+		dl.snapshotCfg = &cfg
+	}
+}
+
+// WithWorkers processes notifications on n concurrent workers. Ordering is
+// still preserved for notifications sharing a key (see WithOrdering). The
+// default of 1 processes everything serially on the receive loop, unless a
+// queue is configured with WithQueueSize or WithOverflow.
+func WithWorkers(n int) Option {
+	return func(dl *DataListener) {
+		dl.workers = n
+	}
+}
+
+// WithQueueSize bounds the number of notifications queued for the workers.
+// When a worker's share of the queue is full the overflow policy applies.
+func WithQueueSize(n int) Option {
+	return func(dl *DataListener) {
+		dl.queueSize = n
+		dl.queued = true
+	}
+}
+
+// WithPriorityQueue bounds the high priority lane of the queue, see
+// WithPriority, to size notifications and lets workers process burst
+// high priority notifications in a row before a waiting normal one.
+func WithPriorityQueue(size, burst int) Option {
+	return func(dl *DataListener) {
+		dl.lanes = priorityLanes{size: size, burst: burst}
+	}
+}
+
+// WithOverflow sets what happens when the queue is full; the default is
+// OverflowBlock. Dropped notifications count as processed.
+func WithOverflow(p OverflowPolicy) Option {
+	return func(dl *DataListener) {
+		dl.overflow = p
+		dl.queued = true
+	}
+}
+
+// WithOrdering sets which notifications must be processed in order when
+// using several workers. The default is ByTable.
+func WithOrdering(fn OrderingFunc) Option {
+	return func(dl *DataListener) {
+		dl.ordering = fn
+	}
+}
+
+// WithRetryPolicy sets the retry policy for handlers registered without
+// WithRetry. By default failed handler calls are not retried.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(dl *DataListener) {
+		dl.retry = p
+	}
+}
+
+// WithErrorHandler sets the callback receiving notifications whose handler
+// failed permanently. Without one, failures are logged.
+func WithErrorHandler(fn ErrorHandler) Option {
+	return func(dl *DataListener) {
+		dl.onError = fn
+	}
+}
+
+// WithDeadLetterTable stores permanently failed notifications in a Postgres
+// table (DefaultDeadLetterTable when empty), created on Start.
+func WithDeadLetterTable(table string) Option {
+	return func(dl *DataListener) {
+		dl.deadLetters = NewPostgresDeadLetterStore(dl.db, table)
+	}
+}
+
+// WithDeadLetterStore stores permanently failed notifications in store.
+func WithDeadLetterStore(store DeadLetterStore) Option {
+	return func(dl *DataListener) {
+		dl.deadLetters = store
+	}
+}
+
+// WithMetrics reports instrumentation events to m, e.g. a
+// metrics.Prometheus.
+func WithMetrics(m Metrics) Option {
+	return func(dl *DataListener) {
+		dl.metrics = m
+	}
+}
+
+// WithLogger sets the logger, slog.Default() by default.
+func WithLogger(l Logger) Option {
+	return func(dl *DataListener) {
+		dl.logger = l
+	}
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider, the global one
+// by default. Each notification gets a root span covering decoding and
+// handling; handlers receive its context.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(dl *DataListener) {
+		dl.tracer = newTracer(tp)
+	}
+}
+
+// WithHandlerTimeout bounds every handler call; WithTimeout overrides it
+// per handler. A call exceeding it has its context canceled and fails with
+// ErrHandlerTimeout. It is disabled by default.
+func WithHandlerTimeout(d time.Duration) Option {
+	return func(dl *DataListener) {
+		dl.timeout = d
+	}
+}
+
+// WithPanicBreaker disables handlers that keep panicking for a cooldown.
+// Panics are always recovered and fail the notification.
+func WithPanicBreaker(b PanicBreaker) Option {
+	if b.Cooldown <= 0 {
+		b.Cooldown = DefaultPanicCooldown
+	}
+	return func(dl *DataListener) {
+		dl.panicBreaker = &b
+	}
+}
+
+// WithChunkTimeout sets how long chunks of a split payload are kept waiting
+// for the rest before being dropped.
+func WithChunkTimeout(d time.Duration) Option {
+	return func(dl *DataListener) {
+		dl.chunkTimeout = d
+	}
+}
+
+// WithTransactionHandler groups the changes of each transaction and passes
+// them to h together instead of to the table handlers.
+func WithTransactionHandler(h TransactionHandler) Option {
+	return func(dl *DataListener) {
+		dl.transactions = h
+	}
+}
+
+// WithTransactionTimeout sets how long changes wait for their commit marker
+// before being handed over without it.
+func WithTransactionTimeout(d time.Duration) Option {
+	return func(dl *DataListener) {
+		dl.txTimeout = d
+	}
+}
@@ -0,0 +1,231 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultOutboxTable     = "data_listener_outbox"
+	DefaultOffsetTable     = "data_listener_offsets"
+	DefaultConsumerName    = "default"
+	DefaultOutboxBatchSize = 500
+)
+
+// OutboxConfig enables at-least-once delivery backed by the outbox table
+// written by triggers installed with trigger.WithOutbox. The id of the last
+// processed event is persisted per consumer, and events missed while the
+// listener was disconnected or stopped are replayed from the outbox.
+type OutboxConfig struct {
+	Table       string
+	OffsetTable string
+	Consumer    string
+	BatchSize   int
+	// Checkpoints stores the offsets, by default a TableCheckpoints on
+	// OffsetTable.
+	Checkpoints CheckpointStore
+}
+
+func (c OutboxConfig) withDefaults() OutboxConfig {
+	if c.Table == "" {
+		c.Table = DefaultOutboxTable
+	}
+	if c.OffsetTable == "" {
+		c.OffsetTable = DefaultOffsetTable
+	}
+	if c.Consumer == "" {
+		c.Consumer = DefaultConsumerName
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultOutboxBatchSize
+	}
+	return c
+}
+
+type outbox struct {
+	db     *sql.DB
+	cfg    OutboxConfig
+	logger Logger
+	*tail
+}
+
+func newOutbox(db *sql.DB, cfg OutboxConfig) *outbox {
+	cfg = cfg.withDefaults()
+	if cfg.Checkpoints == nil {
+		cfg.Checkpoints = NewTableCheckpoints(db, cfg.OffsetTable)
+	}
+	return &outbox{
+		db:     db,
+		cfg:    cfg,
+		logger: defaultLogger{},
+		tail:   newTail(db, cfg.Table, "id", cfg.Checkpoints, cfg.Consumer),
+	}
+}
+
+// catchUp delivers every outbox event after the checkpoint on the given
+// channels that was not delivered yet, in id order, e.g. those sent while
+// the listener was disconnected. Delivered events must be reported back
+// through complete.
+func (ob *outbox) catchUp(ctx context.Context, channels []string, deliver func(*ChangeNotification) error) error {
+	_, next, err := ob.frontier(ctx)
+	if err != nil {
+		return err
+	}
+	replayed, err := ob.scan(ctx, channels, ob.checkpoint(), math.MaxInt64, deliver)
+	if err != nil {
+		return err
+	}
+	if replayed > 0 {
+		ob.logger.Info("caught up from outbox", "table", ob.cfg.Table, "events", replayed)
+	}
+	return ob.settle(ctx, next)
+}
+
+// sweep delivers the events up to the next settled frontier that were not
+// delivered yet, because their transaction committed after later ones and
+// their notifications have not arrived, and lets the checkpoint move up to
+// the frontier.
+func (ob *outbox) sweep(ctx context.Context, channels []string, deliver func(*ChangeNotification) error) error {
+	settled, next, err := ob.frontier(ctx)
+	if err != nil || next <= settled {
+		return err
+	}
+	swept, err := ob.scan(ctx, channels, settled, next, deliver)
+	if err != nil {
+		return err
+	}
+	if swept > 0 {
+		ob.logger.Debug("delivered outbox events ahead of their notifications", "table", ob.cfg.Table, "events", swept)
+	}
+	return ob.settle(ctx, next)
+}
+
+// scan delivers the events after after and up to upTo on the given
+// channels that were not delivered yet, in id order, and returns how many
+// it delivered.
+func (ob *outbox) scan(ctx context.Context, channels []string, after, upTo int64, deliver func(*ChangeNotification) error) (int, error) {
+	query := fmt.Sprintf(`SELECT id, channel, payload FROM %s
+WHERE id > $1 AND id <= $2 AND channel = ANY($3)
+ORDER BY id
+LIMIT $4`, quoteName(ob.cfg.Table))
+
+	delivered := 0
+	for {
+		rows, err := ob.db.QueryContext(ctx, query, after, upTo, pq.Array(channels), ob.cfg.BatchSize)
+		if err != nil {
+			return delivered, fmt.Errorf("failed to read outbox %s: %w", ob.cfg.Table, err)
+		}
+
+		var (
+			batch   []*ChangeNotification
+			scanned int
+		)
+		for rows.Next() {
+			var (
+				id      int64
+				channel string
+				payload []byte
+			)
+			if err := rows.Scan(&id, &channel, &payload); err != nil {
+				rows.Close()
+				return delivered, err
+			}
+			scanned++
+			after = id
+			if ob.seen(id) {
+				continue
+			}
+
+			n, err := decodeNotification(channel, payload)
+			if err != nil {
+				ob.logger.Warn("skipping malformed outbox event", "table", ob.cfg.Table, "id", id, "error", err)
+				ob.track(id)
+				ob.complete(ctx, id)
+				continue
+			}
+			n.ID = id
+			batch = append(batch, n)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return delivered, err
+		}
+
+		for _, n := range batch {
+			ob.track(n.ID)
+			if err := deliver(n); err != nil {
+				return delivered, err
+			}
+			delivered++
+		}
+
+		if scanned < ob.cfg.BatchSize {
+			return delivered, nil
+		}
+	}
+}
+
+// read returns up to limit outbox events after afterID on the given
+// channels, skipping malformed ones. It does not affect the offset.
+func (ob *outbox) read(ctx context.Context, channels []string, afterID int64, limit int) ([]*ChangeNotification, error) {
+	rows, err := ob.db.QueryContext(ctx, fmt.Sprintf(`SELECT id, channel, payload FROM %s
+WHERE id > $1 AND channel = ANY($2)
+ORDER BY id
+LIMIT $3`, quoteName(ob.cfg.Table)), afterID, pq.Array(channels), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox %s: %w", ob.cfg.Table, err)
+	}
+	defer rows.Close()
+
+	var events []*ChangeNotification
+	for rows.Next() {
+		var (
+			id      int64
+			channel string
+			payload []byte
+		)
+		if err := rows.Scan(&id, &channel, &payload); err != nil {
+			return nil, err
+		}
+		n, err := decodeNotification(channel, payload)
+		if err != nil {
+			continue
+		}
+		n.ID = id
+		events = append(events, n)
+	}
+	return events, rows.Err()
+}
+
+// prune deletes outbox events every consumer has already processed. With
+// checkpoints outside the database only this consumer's are known.
+func (ob *outbox) prune(ctx context.Context) (int64, error) {
+	var (
+		mark string
+		args []any
+	)
+	if ts, ok := ob.cfg.Checkpoints.(*TableCheckpoints); ok {
+		mark = "(SELECT COALESCE(MIN(last_id), 0) FROM " + quoteName(ts.table) + ")"
+	} else {
+		args = append(args, ob.checkpoint())
+		mark = "$1"
+	}
+	res, err := ob.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id <= %s`, quoteName(ob.cfg.Table), mark), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox %s: %w", ob.cfg.Table, err)
+	}
+	return res.RowsAffected()
+}
+
+func quoteName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
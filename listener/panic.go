@@ -0,0 +1,81 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const DefaultPanicCooldown = time.Minute
+
+// ErrHandlerDisabled fails notifications whose handler the panic breaker
+// has disabled.
+var ErrHandlerDisabled = errors.New("handler disabled after repeated panics")
+
+// PanicError is a recovered handler panic. It is not retried.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// PanicBreaker disables a handler after Threshold consecutive panics. Its
+// notifications fail with ErrHandlerDisabled until Cooldown has passed;
+// the next one then tries the handler again.
+type PanicBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+}
+
+// panicState counts a handler's consecutive panics.
+type panicState struct {
+	mu            sync.Mutex
+	consecutive   int
+	disabledUntil time.Time
+}
+
+func (s *panicState) disabled(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return now.Before(s.disabledUntil)
+}
+
+// record counts a call and reports whether it tripped the breaker.
+func (s *panicState) record(b *PanicBreaker, panicked bool, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !panicked {
+		s.consecutive = 0
+		return false
+	}
+	s.consecutive++
+	if b.Threshold <= 0 || s.consecutive < b.Threshold {
+		return false
+	}
+	s.consecutive = 0
+	s.disabledUntil = now.Add(b.Cooldown)
+	return true
+}
+
+// recoverCall runs fn, turning a panic into a *PanicError.
+func recoverCall(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
+
+// handlerPanicked reports a recovered panic.
+func (dl *DataListener) handlerPanicked(n *ChangeNotification, pe *PanicError) {
+	dl.metrics.HandlerPanicked(n)
+	dl.logger.Error("handler panicked",
+		"channel", n.Channel, "table", n.Table, "operation", n.Operation,
+		"panic", fmt.Sprint(pe.Value), "stack", string(pe.Stack))
+}
@@ -0,0 +1,81 @@
+package listener
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// PasswordFunc returns the password of a new connection to host and port
+// as user, e.g. a short-lived authentication token such as an RDS IAM
+// token. It is called for every connection the listener opens, including
+// reconnects, so expired tokens are never reused.
+type PasswordFunc func(ctx context.Context, host string, port uint16, user string) (string, error)
+
+// WithPassword authenticates every connection with a password from fn
+// instead of the one in the connection string. It requires the pgx LISTEN
+// driver, as lib/pq reconnects its listener with a fixed password.
+func WithPassword(fn PasswordFunc) Option {
+	return func(dl *DataListener) {
+		dl.password = fn
+	}
+}
+
+// connOptions are the settings shared by the connections the listener
+// opens.
+type connOptions struct {
+	tls      *clientTLS
+	password PasswordFunc
+}
+
+// configure sets the password of a connection about to be opened with cfg,
+// which must be a copy when it is reused.
+func (o connOptions) configure(ctx context.Context, cfg *pgconn.Config) error {
+	if o.password == nil {
+		return nil
+	}
+	password, err := o.password(ctx, cfg.Host, cfg.Port, cfg.User)
+	if err != nil {
+		return fmt.Errorf("failed to get password: %w", err)
+	}
+	cfg.Password = password
+	return nil
+}
+
+// pqConnString converts connStr to the key/value format so settings can
+// be appended, disabling the TLS negotiation of lib/pq, which the dialer
+// does instead.
+func (o connOptions) pqConnString(connStr string) (string, error) {
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		var err error
+		if connStr, err = pq.ParseURL(connStr); err != nil {
+			return "", fmt.Errorf("invalid connection string: %w", err)
+		}
+	}
+	if o.tls != nil {
+		connStr += " sslmode=disable"
+	}
+	return connStr, nil
+}
+
+// pqConnector returns a lib/pq connector for a connStr returned by
+// pqConnString.
+func (o connOptions) pqConnector(connStr string) (*pq.Connector, error) {
+	connector, err := parseConnString(connStr)
+	if err != nil {
+		return nil, err
+	}
+	if o.tls != nil {
+		connector.Dialer(tlsDialer{tls: o.tls})
+	}
+	return connector, nil
+}
+
+// quoteValue quotes a connection string value.
+func quoteValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	return "'" + strings.ReplaceAll(v, `'`, `\'`) + "'"
+}
@@ -0,0 +1,164 @@
+package listener
+
+import (
+	"context"
+	"sync"
+)
+
+// pauser holds notifications back while the listener is paused.
+type pauser struct {
+	mu       sync.Mutex
+	paused   bool
+	resuming bool
+	pending  []pendingNotification
+	// room is closed when a held notification is released, waking
+	// receivers blocked on a full backlog.
+	room chan struct{}
+}
+
+type pendingNotification struct {
+	ctx context.Context
+	n   *ChangeNotification
+}
+
+// Pause stops handing notifications to handlers. Notifications keep being
+// received, so the connection stays healthy, and are held in memory in
+// order until Resume; they are not checkpointed meanwhile. At most the
+// queue size are held, beyond that the overflow policy applies: receiving
+// blocks until Resume, or the oldest or newest held notification is
+// dropped. Notifications already queued or being handled are still
+// processed, see Drain.
+func (dl *DataListener) Pause() {
+	p := &dl.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		p.paused = true
+		dl.metrics.Paused(true)
+		dl.logger.Info("paused")
+	}
+}
+
+// Resume delivers the notifications held while paused, in order, and then
+// resumes normal processing.
+func (dl *DataListener) Resume() {
+	p := &dl.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return
+	}
+	p.paused = false
+	dl.metrics.Paused(false)
+	dl.logger.Info("resumed", "held", len(p.pending))
+	if len(p.pending) > 0 && !p.resuming {
+		p.resuming = true
+		go dl.releasePaused()
+	}
+}
+
+// Paused reports whether the listener is paused, and how many
+// notifications it holds until Resume delivers them.
+func (dl *DataListener) Paused() (paused bool, held int) {
+	p := &dl.pause
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused, len(p.pending)
+}
+
+// Drain pauses the listener and waits until the notifications already
+// queued or being handled have been processed, or ctx is done. The
+// listener stays paused until Resume.
+func (dl *DataListener) Drain(ctx context.Context) error {
+	dl.Pause()
+	drained := make(chan struct{})
+	go func() {
+		dl.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// admit reports whether n may be processed now, counting it as in flight,
+// or holds it while paused. Admission and pausing are serialized, so no
+// notification enters processing once Pause has returned. When the held
+// notifications fill the queue size the overflow policy applies.
+func (dl *DataListener) admit(ctx context.Context, n *ChangeNotification) bool {
+	p := &dl.pause
+	for {
+		p.mu.Lock()
+		if !p.paused && !p.resuming {
+			dl.inflight.Add(1)
+			p.mu.Unlock()
+			return true
+		}
+		if len(p.pending) < max(dl.queueSize, 1) {
+			p.pending = append(p.pending, pendingNotification{ctx: ctx, n: n})
+			p.mu.Unlock()
+			return false
+		}
+
+		switch dl.overflow {
+		case OverflowDropNewest:
+			dl.inflight.Add(1)
+			p.mu.Unlock()
+			dl.overflowed(ctx, n)
+			return false
+		case OverflowDropOldest:
+			old := p.pending[0]
+			p.pending[0] = pendingNotification{}
+			p.pending = append(p.pending[1:], pendingNotification{ctx: ctx, n: n})
+			dl.inflight.Add(1)
+			p.mu.Unlock()
+			dl.overflowed(old.ctx, old.n)
+			return false
+		}
+
+		if p.room == nil {
+			p.room = make(chan struct{})
+		}
+		room := p.room
+		p.mu.Unlock()
+		select {
+		case <-room:
+		case <-ctx.Done():
+			return false
+		case <-dl.stop:
+			return false
+		}
+	}
+}
+
+// releasePaused submits the held notifications, including those arriving
+// meanwhile, until none are left or the listener is paused again.
+func (dl *DataListener) releasePaused() {
+	p := &dl.pause
+	for {
+		p.mu.Lock()
+		if p.paused || len(p.pending) == 0 {
+			p.resuming = false
+			p.mu.Unlock()
+			return
+		}
+		held := p.pending[0]
+		p.pending[0] = pendingNotification{}
+		p.pending = p.pending[1:]
+		dl.inflight.Add(1)
+		if p.room != nil {
+			close(p.room)
+			p.room = nil
+		}
+		p.mu.Unlock()
+
+		if err := dl.submit(held.ctx, held.n); err != nil {
+			dl.logger.Error("failed to enqueue held notification",
+				"channel", held.n.Channel, "table", held.n.Table, "error", err)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// walDecoder turns the messages of a logical decoding plugin into
+// notifications. decode returns a notification for row changes and the LSN
+// up to which the stream may be confirmed for commits.
+type walDecoder interface {
+	pluginArgs() []string
+	decode(lsn uint64, data []byte) (n *ChangeNotification, commit uint64, err error)
+	// inTransaction reports whether a transaction is being decoded.
+	inTransaction() bool
+}
+
+var errShortMessage = errors.New("short message")
+
+// pgEpoch is the origin of timestamps in the replication protocol.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func pgTime(micros int64) time.Time {
+	return pgEpoch.Add(time.Duration(micros) * time.Microsecond)
+}
+
+type relation struct {
+	schema  string
+	table   string
+	columns []relationColumn
+}
+
+type relationColumn struct {
+	name string
+	key  bool
+	oid  uint32
+}
+
+// pgoutput decodes the built-in pgoutput plugin, protocol version 1.
+type pgoutput struct {
+	publication string
+	relations   map[uint32]*relation
+	commitTime  time.Time
+	xid         int64
+	inTx        bool
+}
+
+func newPgoutput(publication string) *pgoutput {
+	return &pgoutput{publication: publication, relations: make(map[uint32]*relation)}
+}
+
+func (d *pgoutput) pluginArgs() []string {
+	return []string{"proto_version '1'", "publication_names " + pq.QuoteLiteral(d.publication)}
+}
+
+func (d *pgoutput) inTransaction() bool { return d.inTx }
+
+func (d *pgoutput) decode(lsn uint64, data []byte) (*ChangeNotification, uint64, error) {
+	if len(data) == 0 {
+		return nil, 0, errShortMessage
+	}
+	r := &msgReader{buf: data[1:]}
+	switch data[0] {
+	case 'B':
+		r.uint64() // final LSN
+		d.commitTime = pgTime(int64(r.uint64()))
+		d.xid = int64(r.uint32())
+		d.inTx = true
+	case 'C':
+		r.byte()   // flags
+		r.uint64() // commit LSN
+		end := r.uint64()
+		d.inTx = false
+		return nil, end, r.err
+	case 'R':
+		rel := &relation{}
+		id := r.uint32()
+		rel.schema = r.string()
+		rel.table = r.string()
+		r.byte() // replica identity
+		n := int(r.uint16())
+		for i := 0; i < n && r.err == nil; i++ {
+			flags := r.byte()
+			col := relationColumn{name: r.string(), key: flags&1 != 0, oid: r.uint32()}
+			r.uint32() // type modifier
+			rel.columns = append(rel.columns, col)
+		}
+		if r.err == nil {
+			d.relations[id] = rel
+		}
+	case 'I', 'U', 'D':
+		return d.change(data[0], r)
+	}
+	// Type, origin, truncate and logical messages are not delivered.
+	return nil, 0, r.err
+}
+
+func (d *pgoutput) change(kind byte, r *msgReader) (*ChangeNotification, uint64, error) {
+	rel, ok := d.relations[r.uint32()]
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+	if !ok {
+		return nil, 0, errors.New("change for unknown relation")
+	}
+
+	n := &ChangeNotification{Schema: rel.schema, Table: rel.table, Timestamp: d.commitTime, TxID: d.xid}
+	var oldTuple, newTuple []tupleValue
+	var oldFull bool
+	for r.err == nil && len(r.buf) > 0 {
+		switch tag := r.byte(); tag {
+		case 'K', 'O':
+			oldTuple = r.tuple()
+			oldFull = tag == 'O'
+		case 'N':
+			newTuple = r.tuple()
+		default:
+			return nil, 0, fmt.Errorf("unexpected tuple type %q", tag)
+		}
+	}
+	if r.err != nil {
+		return nil, 0, r.err
+	}
+
+	switch kind {
+	case 'I':
+		n.Operation = OpInsert
+		n.New = rel.row(newTuple, nil, false)
+		n.Key = rel.row(newTuple, nil, true)
+		n.Data = n.New
+	case 'U':
+		n.Operation = OpUpdate
+		if oldFull {
+			n.Old = rel.row(oldTuple, nil, false)
+		}
+		n.New = rel.row(newTuple, oldTuple, false)
+		n.Key = rel.row(newTuple, oldTuple, true)
+		n.Data = n.New
+	case 'D':
+		n.Operation = OpDelete
+		// Without REPLICA IDENTITY FULL only the key columns are sent.
+		n.Old = rel.row(oldTuple, nil, !oldFull)
+		n.Key = rel.row(oldTuple, nil, true)
+		n.Data = n.Old
+	}
+	return n, 0, nil
+}
+
+type tupleValue struct {
+	kind byte // 'n' null, 'u' unchanged TOAST value, 't' text
+	data []byte
+}
+
+// row encodes a tuple as a JSON object, taking unchanged TOAST values from
+// fallback. It returns nil for an empty tuple.
+func (rel *relation) row(tuple, fallback []tupleValue, keyOnly bool) json.RawMessage {
+	if len(tuple) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	first := true
+	for i, col := range rel.columns {
+		if i >= len(tuple) || (keyOnly && !col.key) {
+			continue
+		}
+		v := tuple[i]
+		if v.kind == 'u' {
+			if i >= len(fallback) || fallback[i].kind != 't' {
+				continue
+			}
+			v = fallback[i]
+		}
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(col.name)
+		b.Write(name)
+		b.WriteByte(':')
+		if v.kind == 'n' {
+			b.WriteString("null")
+		} else {
+			b.Write(textValue(col.oid, v.data))
+		}
+	}
+	if first && keyOnly {
+		return nil
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
+
+// textValue converts a value in text output format to JSON, keeping
+// numbers, booleans and JSON columns unquoted like row_to_json does.
+func textValue(oid uint32, text []byte) json.RawMessage {
+	switch oid {
+	case 16: // bool
+		if len(text) == 1 && text[0] == 't' {
+			return json.RawMessage("true")
+		}
+		return json.RawMessage("false")
+	case 20, 21, 23, 26, 700, 701, 1700: // int8, int2, int4, oid, float4, float8, numeric
+		if json.Valid(text) {
+			return text
+		}
+	case 114, 3802: // json, jsonb
+		return text
+	}
+	s, _ := json.Marshal(string(text))
+	return s
+}
+
+// msgReader reads big-endian protocol fields, recording the first error.
+type msgReader struct {
+	buf []byte
+	err error
+}
+
+func (r *msgReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = errShortMessage
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *msgReader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *msgReader) uint16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *msgReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *msgReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *msgReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		r.err = errShortMessage
+		return ""
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+func (r *msgReader) tuple() []tupleValue {
+	n := int(r.uint16())
+	values := make([]tupleValue, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		v := tupleValue{kind: r.byte()}
+		if v.kind == 't' {
+			v.data = r.next(int(r.uint32()))
+		}
+		values = append(values, v)
+	}
+	return values
+}
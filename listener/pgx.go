@@ -0,0 +1,282 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// pgxConn is a notifyConn on a single pgx connection. One goroutine owns
+// the connection: it waits for notifications and, in between, runs the
+// LISTEN, UNLISTEN and ping requests of other goroutines, interrupting the
+// wait for them. A lost connection is re-established with backoff and
+// listens on the same channels again.
+type pgxConn struct {
+	config       *pgx.ConnConfig
+	opts         connOptions
+	minReconnect time.Duration
+	maxReconnect time.Duration
+	event        eventFunc
+
+	notify    chan *notification
+	requests  chan *pgxRequest
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// channels is only used by run.
+	channels map[string]struct{}
+}
+
+// pgxRequest runs op on the connection, which is nil while disconnected.
+type pgxRequest struct {
+	op    func(ctx context.Context, conn *pgx.Conn) error
+	reply chan error
+}
+
+var errNotConnected = errors.New("no connection")
+
+func newPGXConn(connStr string, opts connOptions, minReconnect, maxReconnect time.Duration, event eventFunc) (*pgxConn, error) {
+	config, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	if err := requireSessionAttrs(&config.Config, connStr); err != nil {
+		return nil, err
+	}
+	opts.tls.configure(&config.Config)
+	c := &pgxConn{
+		config:       config,
+		opts:         opts,
+		minReconnect: minReconnect,
+		maxReconnect: maxReconnect,
+		event:        event,
+		notify:       make(chan *notification, 32),
+		requests:     make(chan *pgxRequest),
+		closing:      make(chan struct{}),
+		done:         make(chan struct{}),
+		channels:     make(map[string]struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *pgxConn) Listen(channel string) error {
+	return c.do(func(ctx context.Context, conn *pgx.Conn) error {
+		if _, ok := c.channels[channel]; ok {
+			return nil
+		}
+		// A connection lost meanwhile listens on channel when it is
+		// re-established.
+		if conn != nil {
+			if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil && !conn.IsClosed() {
+				return err
+			}
+		}
+		c.channels[channel] = struct{}{}
+		return nil
+	})
+}
+
+func (c *pgxConn) Unlisten(channel string) error {
+	return c.do(func(ctx context.Context, conn *pgx.Conn) error {
+		if _, ok := c.channels[channel]; !ok {
+			return nil
+		}
+		if conn != nil {
+			if _, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil && !conn.IsClosed() {
+				return err
+			}
+		}
+		delete(c.channels, channel)
+		return nil
+	})
+}
+
+func (c *pgxConn) UnlistenAll() error {
+	return c.do(func(ctx context.Context, conn *pgx.Conn) error {
+		if conn != nil {
+			if _, err := conn.Exec(ctx, "UNLISTEN *"); err != nil && !conn.IsClosed() {
+				return err
+			}
+		}
+		clear(c.channels)
+		return nil
+	})
+}
+
+func (c *pgxConn) Ping() error {
+	return c.do(func(ctx context.Context, conn *pgx.Conn) error {
+		if conn == nil {
+			return errNotConnected
+		}
+		return conn.Ping(ctx)
+	})
+}
+
+func (c *pgxConn) Notifications() <-chan *notification {
+	return c.notify
+}
+
+// Close closes the connection, interrupting any request in progress.
+func (c *pgxConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closing) })
+	<-c.done
+	return nil
+}
+
+// do runs op on the goroutine owning the connection.
+func (c *pgxConn) do(op func(ctx context.Context, conn *pgx.Conn) error) error {
+	r := &pgxRequest{op: op, reply: make(chan error, 1)}
+	select {
+	case c.requests <- r:
+	case <-c.done:
+		return errConnClosed
+	}
+	return <-r.reply
+}
+
+func (c *pgxConn) run() {
+	defer close(c.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := c.minReconnect
+	reconnecting := false
+	for {
+		conn, err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.event(eventConnectionAttemptFailed, err)
+			if !c.wait(ctx, backoff) {
+				return
+			}
+			backoff = min(backoff*2, c.maxReconnect)
+			continue
+		}
+		backoff = c.minReconnect
+
+		if reconnecting {
+			c.event(eventReconnected, nil)
+			if c.deliver(ctx, conn, nil) {
+				err = c.serve(ctx, conn)
+			}
+		} else {
+			c.event(eventConnected, nil)
+			err = c.serve(ctx, conn)
+		}
+		closeCtx, done := context.WithTimeout(context.Background(), time.Second)
+		conn.Close(closeCtx)
+		done()
+		if ctx.Err() != nil {
+			return
+		}
+		c.event(eventDisconnected, err)
+		reconnecting = true
+	}
+}
+
+// connect opens a connection listening on every channel.
+func (c *pgxConn) connect(ctx context.Context) (*pgx.Conn, error) {
+	config := c.config
+	if c.opts.password != nil {
+		config = c.config.Copy()
+		if err := c.opts.configure(ctx, &config.Config); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	for channel := range c.channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			conn.Close(context.Background())
+			return nil, fmt.Errorf("failed to listen on channel %s: %w", channel, err)
+		}
+	}
+	return conn, nil
+}
+
+// wait sleeps for d while serving requests without a connection,
+// reporting false when closed.
+func (c *pgxConn) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case r := <-c.requests:
+			r.reply <- r.op(ctx, nil)
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// serve waits for notifications on conn until it fails or ctx is done.
+func (c *pgxConn) serve(ctx context.Context, conn *pgx.Conn) error {
+	for {
+		waitCtx, cancel := context.WithCancel(ctx)
+		var req *pgxRequest
+		watched := make(chan struct{})
+		go func() {
+			defer close(watched)
+			select {
+			case req = <-c.requests:
+				cancel()
+			case <-waitCtx.Done():
+			}
+		}()
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+		<-watched
+
+		if req != nil {
+			req.reply <- req.op(ctx, conn)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if req != nil && !conn.IsClosed() {
+				// The wait was interrupted for the request.
+				continue
+			}
+			return err
+		}
+		if !c.deliver(ctx, conn, &notification{channel: n.Channel, payload: n.Payload}) {
+			return ctx.Err()
+		}
+	}
+}
+
+// deliver sends n to Notifications, serving requests while the receiver
+// is busy so callers holding its locks cannot deadlock with it.
+func (c *pgxConn) deliver(ctx context.Context, conn *pgx.Conn, n *notification) bool {
+	for {
+		select {
+		case c.notify <- n:
+			return true
+		case r := <-c.requests:
+			r.reply <- r.op(ctx, conn)
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
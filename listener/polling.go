@@ -0,0 +1,285 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const DefaultPollInterval = time.Second
+
+// PollingConfig configures a PollingSource, see also WithPolling.
+type PollingConfig struct {
+	// Table is the outbox table written by triggers installed with
+	// trigger.WithOutbox, DefaultOutboxTable when empty. Any table with an
+	// id drawn from a sequence, a channel and a payload in the trigger's
+	// format can be polled, e.g. an audit table.
+	Table string
+	// IDColumn, ChannelColumn and PayloadColumn name its columns, id,
+	// channel and payload when empty.
+	IDColumn      string
+	ChannelColumn string
+	PayloadColumn string
+	Interval      time.Duration
+	BatchSize     int
+	// Checkpoints stores the id processed up to by Consumer, by default a
+	// TableCheckpoints on DefaultOffsetTable, so polling resumes there.
+	Checkpoints CheckpointStore
+	Consumer    string
+}
+
+func (c PollingConfig) withDefaults() PollingConfig {
+	if c.Table == "" {
+		c.Table = DefaultOutboxTable
+	}
+	if c.IDColumn == "" {
+		c.IDColumn = "id"
+	}
+	if c.ChannelColumn == "" {
+		c.ChannelColumn = "channel"
+	}
+	if c.PayloadColumn == "" {
+		c.PayloadColumn = "payload"
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultPollInterval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultOutboxBatchSize
+	}
+	if c.Consumer == "" {
+		c.Consumer = DefaultConsumerName
+	}
+	return c
+}
+
+// PollingSource captures changes by tailing the outbox table every
+// interval instead of listening for notifications, so it works where
+// LISTEN does not. Events are read in id order, a batch at a time,
+// starting after the checkpoint. The ids must be drawn from a sequence:
+// rows whose transaction commits after rows with higher ids were read are
+// delivered once no transaction can commit lower ids anymore, and the
+// checkpoint does not move past them before.
+type PollingSource struct {
+	db        *sql.DB
+	cfg       PollingConfig
+	pos       *tail
+	logger    Logger
+	hooks     *connHooks
+	events    chan Event
+	started   atomic.Bool
+	connected atomic.Bool
+
+	mu       sync.Mutex
+	channels map[string]struct{}
+	stopping chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewPollingSource(db *sql.DB, cfg PollingConfig) *PollingSource {
+	cfg = cfg.withDefaults()
+	if cfg.Checkpoints == nil {
+		cfg.Checkpoints = NewTableCheckpoints(db, DefaultOffsetTable)
+	}
+	return &PollingSource{
+		db:       db,
+		cfg:      cfg,
+		pos:      newTail(db, cfg.Table, cfg.IDColumn, cfg.Checkpoints, cfg.Consumer),
+		logger:   defaultLogger{},
+		events:   make(chan Event),
+		channels: make(map[string]struct{}),
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *PollingSource) attach(dl *DataListener) {
+	s.logger = dl.logger
+	s.hooks = dl.hooks
+}
+
+func (s *PollingSource) Start(ctx context.Context, channels []string) error {
+	s.mu.Lock()
+	for _, channel := range channels {
+		s.channels[channel] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	if err := s.pos.init(ctx); err != nil {
+		return fmt.Errorf("failed to start polling %s: %w", s.cfg.Table, err)
+	}
+	s.connected.Store(true)
+	s.hooks.connect()
+	s.started.Store(true)
+	go s.run(s.pos.checkpoint())
+	return nil
+}
+
+func (s *PollingSource) run(pos int64) {
+	defer close(s.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	t := time.NewTicker(s.cfg.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-s.stopping:
+			return
+		}
+		next, err := s.poll(ctx, pos)
+		pos = next
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if s.connected.Swap(false) {
+				s.logger.Warn("failed to poll outbox", "table", s.cfg.Table, "error", err)
+				s.hooks.disconnect(err)
+			}
+			continue
+		}
+		if !s.connected.Swap(true) {
+			s.hooks.connect()
+		}
+	}
+}
+
+// poll delivers every event after pos, a batch at a time, and returns the
+// id of the last one read. Events up to the next settled frontier that were
+// skipped by earlier polls because they committed late are delivered
+// first.
+func (s *PollingSource) poll(ctx context.Context, pos int64) (int64, error) {
+	settled, next, err := s.pos.frontier(ctx)
+	if err != nil {
+		return pos, err
+	}
+	if next > settled {
+		if _, err := s.scan(ctx, settled, next); err != nil {
+			return pos, err
+		}
+	}
+	pos, err = s.scan(ctx, pos, math.MaxInt64)
+	if err != nil {
+		return pos, err
+	}
+	return pos, s.pos.settle(ctx, next)
+}
+
+// scan delivers the events after after and up to upTo that were not
+// delivered yet, and returns the id of the last one read.
+func (s *PollingSource) scan(ctx context.Context, after, upTo int64) (int64, error) {
+	id, channel := pq.QuoteIdentifier(s.cfg.IDColumn), pq.QuoteIdentifier(s.cfg.ChannelColumn)
+	query := fmt.Sprintf(`SELECT %[1]s, %[2]s, %[3]s FROM %[4]s
+WHERE %[1]s > $1 AND %[1]s <= $2 AND %[2]s = ANY($3)
+ORDER BY %[1]s
+LIMIT $4`, id, channel, pq.QuoteIdentifier(s.cfg.PayloadColumn), quoteName(s.cfg.Table))
+
+	for {
+		s.mu.Lock()
+		channels := slices.Sorted(maps.Keys(s.channels))
+		s.mu.Unlock()
+
+		rows, err := s.db.QueryContext(ctx, query, after, upTo, pq.Array(channels), s.cfg.BatchSize)
+		if err != nil {
+			return after, err
+		}
+		var (
+			batch []Event
+			read  int
+			last  = after
+		)
+		for rows.Next() {
+			var (
+				ev      Event
+				payload []byte
+			)
+			if err := rows.Scan(&ev.ID, &ev.Channel, &payload); err != nil {
+				rows.Close()
+				return after, err
+			}
+			read++
+			last = ev.ID
+			if s.pos.seen(ev.ID) {
+				continue
+			}
+			ev.Payload = string(payload)
+			batch = append(batch, ev)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return after, err
+		}
+
+		for _, ev := range batch {
+			s.pos.track(ev.ID)
+			select {
+			case s.events <- ev:
+			case <-s.stopping:
+				return after, ctx.Err()
+			}
+		}
+		after = last
+		if read < s.cfg.BatchSize {
+			return after, nil
+		}
+	}
+}
+
+func (s *PollingSource) Notifications() <-chan Event {
+	return s.events
+}
+
+// Ack marks the event id processed, moving the checkpoint once every
+// earlier event was processed too.
+func (s *PollingSource) Ack(id int64) {
+	if err := s.pos.complete(context.Background(), id); err != nil {
+		s.logger.Error("failed to save polling offset", "table", s.cfg.Table, "id", id, "error", err)
+	}
+}
+
+func (s *PollingSource) Listen(channel string) error {
+	s.mu.Lock()
+	s.channels[channel] = struct{}{}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *PollingSource) Unlisten(channel string) error {
+	s.mu.Lock()
+	delete(s.channels, channel)
+	s.mu.Unlock()
+	return nil
+}
+
+// Connected reports whether the last poll succeeded.
+func (s *PollingSource) Connected() bool {
+	return s.connected.Load()
+}
+
+func (s *PollingSource) Stop() error {
+	s.stopOnce.Do(func() { close(s.stopping) })
+	if s.started.Load() {
+		<-s.done
+	}
+	s.hooks.stop()
+	s.connected.Store(false)
+	return nil
+}
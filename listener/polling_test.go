@@ -0,0 +1,134 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+)
+
+// pollEvents runs one poll of s from pos and returns the ids it delivered.
+func pollEvents(t *testing.T, s *PollingSource, pos int64) (int64, []int64) {
+	t.Helper()
+	type result struct {
+		pos int64
+		err error
+	}
+	polled := make(chan result, 1)
+	go func() {
+		pos, err := s.poll(context.Background(), pos)
+		polled <- result{pos, err}
+	}()
+	var ids []int64
+	for {
+		select {
+		case ev := <-s.events:
+			ids = append(ids, ev.ID)
+		case r := <-polled:
+			if r.err != nil {
+				t.Fatal(r.err)
+			}
+			return r.pos, ids
+		}
+	}
+}
+
+func TestPollingSource(t *testing.T) {
+	type poll struct {
+		// commit are the rows that became visible before the poll.
+		commit             []Event
+		lastID, xmin, xmax int64
+		want               []int64
+		// ack are acknowledged after the poll, moving the checkpoint to
+		// checkpoint.
+		ack        []int64
+		checkpoint int64
+	}
+	tests := []struct {
+		name  string
+		batch int
+		polls []poll
+	}{
+		{
+			name: "listened channels in order",
+			polls: []poll{
+				{
+					commit: []Event{{ID: 3, Channel: "events"}, {ID: 1, Channel: "events"}, {ID: 2, Channel: "other"}},
+					lastID: 3, xmin: 10, xmax: 11,
+					want: []int64{1, 3},
+				},
+				{lastID: 3, xmin: 11, xmax: 11},
+			},
+		},
+		{
+			name:  "batches",
+			batch: 2,
+			polls: []poll{
+				{
+					commit: []Event{{ID: 1, Channel: "events"}, {ID: 2, Channel: "events"}, {ID: 3, Channel: "events"}, {ID: 4, Channel: "events"}, {ID: 5, Channel: "events"}},
+					lastID: 5, xmin: 10, xmax: 11,
+					want: []int64{1, 2, 3, 4, 5},
+				},
+			},
+		},
+		{
+			name: "late commit",
+			polls: []poll{
+				{
+					commit: []Event{{ID: 1, Channel: "events"}, {ID: 3, Channel: "events"}},
+					lastID: 3, xmin: 10, xmax: 12,
+					want: []int64{1, 3},
+					// The frontier did not settle, 2 may still commit.
+					ack: []int64{1, 3},
+				},
+				{
+					commit: []Event{{ID: 2, Channel: "events"}},
+					lastID: 3, xmin: 12, xmax: 13,
+					want: []int64{2},
+					ack:  []int64{2}, checkpoint: 3,
+				},
+				{lastID: 3, xmin: 13, xmax: 13, checkpoint: 3},
+			},
+		},
+		{
+			name: "checkpoint waits for earlier events",
+			polls: []poll{
+				{
+					commit: []Event{{ID: 1, Channel: "events"}, {ID: 2, Channel: "events"}},
+					lastID: 2, xmin: 10, xmax: 10,
+					want: []int64{1, 2},
+				},
+				{lastID: 2, xmin: 10, xmax: 10, ack: []int64{2}},
+				{lastID: 2, xmin: 10, xmax: 10, ack: []int64{1}, checkpoint: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fdb := &fakeDB{}
+			db := sql.OpenDB(fdb)
+			defer db.Close()
+			checkpoints := &memoryCheckpoints{}
+			s := NewPollingSource(db, PollingConfig{BatchSize: tt.batch, Checkpoints: checkpoints})
+			s.pos.sequence = "outbox_id_seq"
+			s.Listen("events")
+
+			var pos int64
+			for i, p := range tt.polls {
+				fdb.commit(p.commit...)
+				fdb.set(p.lastID, p.xmin, p.xmax)
+				var got []int64
+				pos, got = pollEvents(t, s, pos)
+				if !slices.Equal(got, p.want) {
+					t.Fatalf("poll %d delivered %v, want %v", i, got, p.want)
+				}
+				for _, id := range p.ack {
+					s.Ack(id)
+				}
+				if got, _ := checkpoints.Load(context.Background(), ""); got != p.checkpoint {
+					t.Fatalf("poll %d: checkpoint = %d, want %d", i, got, p.checkpoint)
+				}
+			}
+		})
+	}
+}
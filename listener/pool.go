@@ -0,0 +1,339 @@
+package listener
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultQueueSize = 1024
+	DropOverflow     = "overflow"
+)
+
+// OverflowPolicy selects what happens when a notification arrives while
+// its worker's queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes the receive loop wait for room (backpressure).
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the longest-queued notification.
+	OverflowDropOldest
+	// OverflowDropNewest drops the arriving notification.
+	OverflowDropNewest
+)
+
+// OrderingFunc returns the key whose notifications must be processed in
+// order. Notifications with different keys may be processed concurrently.
+type OrderingFunc func(n *ChangeNotification) string
+
+// ByTable preserves ordering per table.
+func ByTable(n *ChangeNotification) string {
+	return n.QualifiedTable()
+}
+
+// ByKey preserves ordering per row, identified by the given primary key
+// columns ("id" when none are given). Rows missing a key column fall back to
+// per-table ordering.
+func ByKey(columns ...string) OrderingFunc {
+	if len(columns) == 0 {
+		columns = []string{"id"}
+	}
+	return func(n *ChangeNotification) string {
+		row := n.New
+		if row == nil {
+			row = n.Old
+		}
+		if row == nil {
+			row = n.Data
+		}
+		if row == nil {
+			row = n.Key
+		}
+		values, err := decodeRow(row)
+		if err != nil {
+			return ByTable(n)
+		}
+
+		var b strings.Builder
+		b.WriteString(ByTable(n))
+		for _, col := range columns {
+			v, ok := values[col]
+			if !ok {
+				return ByTable(n)
+			}
+			b.WriteByte(0)
+			b.Write(v)
+		}
+		return b.String()
+	}
+}
+
+// Parallel spreads the notifications of each table over n workers
+// without ordering between them, for tables whose changes are
+// independent.
+func Parallel(n int) OrderingFunc {
+	if n < 1 {
+		n = 1
+	}
+	var next atomic.Uint64
+	return func(c *ChangeNotification) string {
+		return ByTable(c) + "\x00" + strconv.FormatUint(next.Add(1)%uint64(n), 10)
+	}
+}
+
+// WithConcurrency sets how the notifications of one handler are spread
+// over the workers of WithWorkers, overriding WithOrdering: ByTable
+// processes them serially, ByKey in order per row and Parallel on up to
+// n workers at once.
+func WithConcurrency(fn OrderingFunc) HandlerOption {
+	return func(r *registration) {
+		r.ordering = fn
+	}
+}
+
+// Priority is the lane of the worker queues a handler's notifications
+// wait in, see WithPriority.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	// PriorityHigh notifications are processed ahead of the normal ones
+	// queued on the same worker.
+	PriorityHigh
+)
+
+const (
+	DefaultPriorityQueueSize = 256
+	// DefaultPriorityBurst is how many high priority notifications a
+	// worker processes in a row while normal ones wait.
+	DefaultPriorityBurst = 8
+)
+
+// WithPriority queues the notifications of one handler in the lane of p,
+// so those of critical tables jump ahead of bulk tables waiting for the
+// same worker. Ordering holds within a lane. Priorities need the queue of
+// WithWorkers or WithQueueSize; the lanes are sized by WithPriorityQueue.
+func WithPriority(p Priority) HandlerOption {
+	return func(r *registration) {
+		r.priority = p
+	}
+}
+
+// priorityLanes sizes the high priority lane, see WithPriorityQueue.
+type priorityLanes struct {
+	size, burst int
+}
+
+// poolRoute returns the ordering key and lane of n, from its handler or
+// the listener.
+func (dl *DataListener) poolRoute(n *ChangeNotification) (string, Priority) {
+	if n.tx == nil {
+		if reg, ok := dl.registration(n); ok {
+			if reg.ordering != nil {
+				return reg.ordering(n), reg.priority
+			}
+			return dl.ordering(n), reg.priority
+		}
+	}
+	return dl.ordering(n), PriorityNormal
+}
+
+type poolItem struct {
+	ctx context.Context
+	n   *ChangeNotification
+	// run, if set, is called instead of processing n.
+	run func(context.Context)
+}
+
+// workerPool processes notifications on a fixed number of workers. Each
+// ordering key is pinned to one worker, whose queues are processed
+// serially, the high priority lane first.
+type workerPool struct {
+	workers  []poolWorker
+	route    func(n *ChangeNotification) (string, Priority)
+	process  func(context.Context, *ChangeNotification)
+	overflow OverflowPolicy
+	// burst bounds the high priority items processed in a row while
+	// normal ones wait.
+	burst int
+	// drop is called for notifications dropped by the overflow policy,
+	// blocked with the time submit waited for room.
+	drop    func(context.Context, *ChangeNotification)
+	blocked func(time.Duration)
+
+	// mu guards closed against queueing redeliveries, which may come in
+	// after the receive loop stopped.
+	mu     sync.RWMutex
+	closed bool
+}
+
+type poolWorker struct {
+	high, normal chan poolItem
+}
+
+func newWorkerPool(workers, queueSize int, lanes priorityLanes, route func(*ChangeNotification) (string, Priority), process func(context.Context, *ChangeNotification)) *workerPool {
+	perWorker := max(queueSize/workers, 1)
+	highPerWorker := max(lanes.size/workers, 1)
+
+	p := &workerPool{
+		workers: make([]poolWorker, workers),
+		route:   route,
+		process: process,
+		burst:   max(lanes.burst, 1),
+	}
+	for i := range p.workers {
+		w := poolWorker{high: make(chan poolItem, highPerWorker), normal: make(chan poolItem, perWorker)}
+		p.workers[i] = w
+		go p.work(w)
+	}
+	return p
+}
+
+// work processes the worker's queues until both are closed. After burst
+// high priority items it takes a waiting normal one, so bulk tables are
+// not starved by a busy critical table.
+func (p *workerPool) work(w poolWorker) {
+	high, normal := w.high, w.normal
+	burst := 0
+	take := func(item poolItem, ok bool, lane *chan poolItem, isHigh bool) {
+		if !ok {
+			*lane = nil
+			return
+		}
+		if isHigh {
+			burst++
+		} else {
+			burst = 0
+		}
+		if item.run != nil {
+			item.run(item.ctx)
+			return
+		}
+		p.process(item.ctx, item.n)
+	}
+	for high != nil || normal != nil {
+		if burst >= p.burst && normal != nil {
+			select {
+			case item, ok := <-normal:
+				take(item, ok, &normal, false)
+				continue
+			default:
+				burst = 0
+			}
+		}
+		select {
+		case item, ok := <-high:
+			take(item, ok, &high, true)
+			continue
+		default:
+		}
+		select {
+		case item, ok := <-high:
+			take(item, ok, &high, true)
+		case item, ok := <-normal:
+			take(item, ok, &normal, false)
+		}
+	}
+}
+
+// submit queues n in its lane of the worker owning its key. While that
+// queue is full it blocks or drops per the overflow policy. The worker processes
+// n with procCtx.
+func (p *workerPool) submit(ctx, procCtx context.Context, stop <-chan struct{}, n *ChangeNotification) error {
+	queue := p.queue(n)
+	item := poolItem{ctx: procCtx, n: n}
+
+	select {
+	case queue <- item:
+		return nil
+	default:
+	}
+
+	switch p.overflow {
+	case OverflowDropNewest:
+		p.drop(item.ctx, item.n)
+		return nil
+	case OverflowDropOldest:
+		for {
+			select {
+			case old := <-queue:
+				p.drop(old.ctx, old.n)
+			default:
+			}
+			select {
+			case queue <- item:
+				return nil
+			default:
+			}
+		}
+	}
+
+	start := time.Now()
+	defer func() { p.blocked(time.Since(start)) }()
+	select {
+	case queue <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-stop:
+		return errStopped
+	}
+}
+
+// run queues fn in the lane of the worker owning n's key, so it runs in
+// order with the notifications sharing the key. It waits for room
+// regardless of the overflow policy.
+func (p *workerPool) run(ctx context.Context, stop <-chan struct{}, n *ChangeNotification, fn func(context.Context)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errStopped
+	}
+	select {
+	case p.queue(n) <- poolItem{ctx: ctx, n: n, run: fn}:
+		return nil
+	case <-stop:
+		return errStopped
+	}
+}
+
+// queue returns the lane of the worker owning n's key.
+func (p *workerPool) queue(n *ChangeNotification) chan poolItem {
+	key, priority := p.route(n)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	w := p.workers[h.Sum32()%uint32(len(p.workers))]
+	if priority == PriorityHigh {
+		return w.high
+	}
+	return w.normal
+}
+
+// close stops the workers once their queued items are processed.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, w := range p.workers {
+		close(w.high)
+		close(w.normal)
+	}
+}
+
+func (p *workerPool) capacity() int {
+	return len(p.workers) * (cap(p.workers[0].high) + cap(p.workers[0].normal))
+}
+
+func (p *workerPool) depth() int {
+	depth := 0
+	for _, w := range p.workers {
+		depth += len(w.high) + len(w.normal)
+	}
+	return depth
+}
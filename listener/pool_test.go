@@ -0,0 +1,296 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolRecorder processes notifications for a test pool, recording their
+// tables in order. The notification of table "block" waits for release.
+type poolRecorder struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu        sync.Mutex
+	processed []string
+	dropped   []string
+	done      chan struct{}
+	want      int
+}
+
+func newPoolRecorder(want int) *poolRecorder {
+	return &poolRecorder{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		done:    make(chan struct{}),
+		want:    want,
+	}
+}
+
+func (r *poolRecorder) process(_ context.Context, n *ChangeNotification) {
+	if n.Table == "block" {
+		close(r.started)
+		<-r.release
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.processed = append(r.processed, n.Table)
+	if len(r.processed) == r.want {
+		close(r.done)
+	}
+}
+
+func (r *poolRecorder) drop(_ context.Context, n *ChangeNotification) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dropped = append(r.dropped, n.Table)
+}
+
+func (r *poolRecorder) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-r.done:
+	case <-time.After(5 * time.Second):
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		t.Fatalf("processed %v, want %d notifications", r.processed, r.want)
+	}
+}
+
+// routeByPrefix routes tables starting with "high" to the high priority
+// lane, all of them on one key.
+func routeByPrefix(n *ChangeNotification) (string, Priority) {
+	if len(n.Table) >= 4 && n.Table[:4] == "high" {
+		return "", PriorityHigh
+	}
+	return "", PriorityNormal
+}
+
+func TestWorkerPoolPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		burst  int
+		submit []string
+		want   []string
+	}{
+		{
+			name:   "normal only",
+			burst:  2,
+			submit: []string{"n1", "n2", "n3"},
+			want:   []string{"n1", "n2", "n3"},
+		},
+		{
+			name:   "high first",
+			burst:  8,
+			submit: []string{"n1", "n2", "high1", "high2"},
+			want:   []string{"high1", "high2", "n1", "n2"},
+		},
+		{
+			name:   "burst lets normal through",
+			burst:  2,
+			submit: []string{"n1", "n2", "n3", "high1", "high2", "high3"},
+			want:   []string{"high1", "high2", "n1", "high3", "n2", "n3"},
+		},
+		{
+			name:   "burst of one alternates",
+			burst:  1,
+			submit: []string{"n1", "n2", "high1", "high2", "high3"},
+			want:   []string{"high1", "n1", "high2", "n2", "high3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPoolRecorder(len(tt.want))
+			p := newWorkerPool(1, 16, priorityLanes{size: 16, burst: tt.burst}, routeByPrefix, r.process)
+			defer p.close()
+
+			ctx := context.Background()
+			if err := p.submit(ctx, ctx, nil, &ChangeNotification{Table: "block"}); err != nil {
+				t.Fatal(err)
+			}
+			<-r.started
+			for _, table := range tt.submit {
+				if err := p.submit(ctx, ctx, nil, &ChangeNotification{Table: table}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			close(r.release)
+			r.wait(t)
+			if !slices.Equal(r.processed, tt.want) {
+				t.Errorf("processed %v, want %v", r.processed, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolOrdering(t *testing.T) {
+	const perTable = 50
+	tables := []string{"a", "b", "c", "d", "e"}
+	var (
+		mu   sync.Mutex
+		seen = make(map[string][]int64)
+		wg   sync.WaitGroup
+	)
+	process := func(_ context.Context, n *ChangeNotification) {
+		defer wg.Done()
+		mu.Lock()
+		seen[n.Table] = append(seen[n.Table], n.ID)
+		mu.Unlock()
+	}
+	route := func(n *ChangeNotification) (string, Priority) { return ByTable(n), PriorityNormal }
+	p := newWorkerPool(4, 64, priorityLanes{size: 16, burst: 1}, route, process)
+	p.blocked = func(time.Duration) {}
+	defer p.close()
+
+	ctx := context.Background()
+	for i := range int64(perTable) {
+		for _, table := range tables {
+			wg.Add(1)
+			if err := p.submit(ctx, ctx, nil, &ChangeNotification{Schema: "public", Table: table, ID: i + 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	wg.Wait()
+	for _, table := range tables {
+		if ids := seen[table]; len(ids) != perTable || !slices.IsSorted(ids) {
+			t.Errorf("table %s processed in order %v", table, ids)
+		}
+	}
+}
+
+func TestWorkerPoolOverflow(t *testing.T) {
+	tests := []struct {
+		name          string
+		overflow      OverflowPolicy
+		wantErr       error
+		wantProcessed []string
+		wantDropped   []string
+	}{
+		{
+			name:          "drop newest",
+			overflow:      OverflowDropNewest,
+			wantProcessed: []string{"first"},
+			wantDropped:   []string{"second"},
+		},
+		{
+			name:          "drop oldest",
+			overflow:      OverflowDropOldest,
+			wantProcessed: []string{"second"},
+			wantDropped:   []string{"first"},
+		},
+		{
+			name:          "block",
+			overflow:      OverflowBlock,
+			wantErr:       context.Canceled,
+			wantProcessed: []string{"first"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newPoolRecorder(1)
+			p := newWorkerPool(1, 1, priorityLanes{size: 1, burst: 1}, routeByPrefix, r.process)
+			p.overflow = tt.overflow
+			p.drop = r.drop
+			p.blocked = func(time.Duration) {}
+			defer p.close()
+
+			ctx := context.Background()
+			if err := p.submit(ctx, ctx, nil, &ChangeNotification{Table: "block"}); err != nil {
+				t.Fatal(err)
+			}
+			<-r.started
+			if err := p.submit(ctx, ctx, nil, &ChangeNotification{Table: "first"}); err != nil {
+				t.Fatal(err)
+			}
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			err := p.submit(canceled, ctx, nil, &ChangeNotification{Table: "second"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("submit = %v, want %v", err, tt.wantErr)
+			}
+			close(r.release)
+			r.wait(t)
+			if !slices.Equal(r.processed, tt.wantProcessed) || !slices.Equal(r.dropped, tt.wantDropped) {
+				t.Errorf("processed %v, dropped %v, want %v, %v", r.processed, r.dropped, tt.wantProcessed, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolRun(t *testing.T) {
+	r := newPoolRecorder(2)
+	p := newWorkerPool(1, 1, priorityLanes{size: 1, burst: 1}, routeByPrefix, r.process)
+
+	ctx := context.Background()
+	if err := p.submit(ctx, ctx, nil, &ChangeNotification{Table: "block"}); err != nil {
+		t.Fatal(err)
+	}
+	<-r.started
+	if err := p.submit(ctx, ctx, nil, &ChangeNotification{Table: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	// run waits for room in the full queue instead of dropping.
+	ran := make(chan error, 1)
+	go func() {
+		ran <- p.run(ctx, nil, &ChangeNotification{Table: "redelivery"}, func(ctx context.Context) {
+			r.process(ctx, &ChangeNotification{Table: "redelivered"})
+		})
+	}()
+	close(r.release)
+	if err := <-ran; err != nil {
+		t.Fatalf("run = %v", err)
+	}
+	r.wait(t)
+	if want := []string{"first", "redelivered"}; !slices.Equal(r.processed, want) {
+		t.Errorf("processed %v, want %v", r.processed, want)
+	}
+
+	p.close()
+	if err := p.run(ctx, nil, &ChangeNotification{Table: "late"}, func(context.Context) {}); !errors.Is(err, errStopped) {
+		t.Errorf("run after close = %v, want %v", err, errStopped)
+	}
+}
+
+func TestByKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		n       ChangeNotification
+		want    string
+	}{
+		{
+			name: "default id column",
+			n:    ChangeNotification{Schema: "public", Table: "users", Data: []byte(`{"id":7,"name":"a"}`)},
+			want: "public.users\x007",
+		},
+		{
+			name:    "composite key",
+			columns: []string{"tenant", "id"},
+			n:       ChangeNotification{Schema: "public", Table: "users", Data: []byte(`{"id":7,"tenant":"t1"}`)},
+			want:    "public.users\x00\"t1\"\x007",
+		},
+		{
+			name: "missing column",
+			n:    ChangeNotification{Schema: "public", Table: "users", Data: []byte(`{"name":"a"}`)},
+			want: "public.users",
+		},
+		{
+			name: "old row of a delete",
+			n:    ChangeNotification{Schema: "public", Table: "users", Old: []byte(`{"id":3}`)},
+			want: "public.users\x003",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ByKey(tt.columns...)(&tt.n); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The fields of the ChangeNotification message in notification.proto.
+const (
+	pbID        protowire.Number = 1
+	pbSeq       protowire.Number = 2
+	pbSchema    protowire.Number = 3
+	pbTable     protowire.Number = 4
+	pbOperation protowire.Number = 5
+	pbKey       protowire.Number = 6
+	pbData      protowire.Number = 7
+	pbOld       protowire.Number = 8
+	pbNew       protowire.Number = 9
+	pbTimestamp protowire.Number = 10
+	pbRef       protowire.Number = 11
+	pbSnapshot  protowire.Number = 12
+	pbTxID      protowire.Number = 13
+	pbCommit    protowire.Number = 14
+	pbChanges   protowire.Number = 15
+	pbTenant    protowire.Number = 16
+	pbActor     protowire.Number = 17
+)
+
+// protobufCodec encodes the notification as the ChangeNotification message
+// of notification.proto, the rows as JSON.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return "protobuf" }
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Encode(n *ChangeNotification) ([]byte, error) {
+	n = n.compacted()
+	var b []byte
+	varint := func(num protowire.Number, v int64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(v))
+		}
+	}
+	flag := func(num protowire.Number, v bool) {
+		if v {
+			varint(num, 1)
+		}
+	}
+	str := func(num protowire.Number, v string) {
+		if v != "" {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		}
+	}
+	row := func(num protowire.Number, v json.RawMessage) {
+		if !isNull(v) {
+			var buf bytes.Buffer
+			if json.Compact(&buf, v) == nil {
+				v = buf.Bytes()
+			}
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendBytes(b, v)
+		}
+	}
+
+	varint(pbID, n.ID)
+	varint(pbSeq, n.Seq)
+	str(pbSchema, n.Schema)
+	str(pbTable, n.Table)
+	str(pbOperation, n.Operation)
+	row(pbKey, n.Key)
+	row(pbData, n.Data)
+	row(pbOld, n.Old)
+	row(pbNew, n.New)
+	if !n.Timestamp.IsZero() {
+		// A google.protobuf.Timestamp.
+		var ts []byte
+		if s := n.Timestamp.Unix(); s != 0 {
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(s))
+		}
+		if ns := n.Timestamp.Nanosecond(); ns != 0 {
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(ns))
+		}
+		b = protowire.AppendTag(b, pbTimestamp, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	flag(pbRef, n.Ref)
+	flag(pbSnapshot, n.Snapshot)
+	varint(pbTxID, n.TxID)
+	flag(pbCommit, n.Commit)
+	varint(pbChanges, int64(n.Changes))
+	str(pbTenant, n.Tenant)
+	str(pbActor, n.Actor)
+	return b, nil
+}
+
+func (protobufCodec) Decode(data []byte) (*ChangeNotification, error) {
+	n := &ChangeNotification{}
+	for len(data) > 0 {
+		num, typ, l := protowire.ConsumeTag(data)
+		if l < 0 {
+			return nil, fmt.Errorf("protobuf: %w", protowire.ParseError(l))
+		}
+		data = data[l:]
+
+		var v uint64
+		var raw []byte
+		switch typ {
+		case protowire.VarintType:
+			v, l = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			raw, l = protowire.ConsumeBytes(data)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if l < 0 {
+			return nil, fmt.Errorf("protobuf: field %d: %w", num, protowire.ParseError(l))
+		}
+		data = data[l:]
+
+		switch num {
+		case pbID:
+			n.ID = int64(v)
+		case pbSeq:
+			n.Seq = int64(v)
+		case pbSchema:
+			n.Schema = string(raw)
+		case pbTable:
+			n.Table = string(raw)
+		case pbOperation:
+			n.Operation = string(raw)
+		case pbKey:
+			n.Key = bytes.Clone(raw)
+		case pbData:
+			n.Data = bytes.Clone(raw)
+		case pbOld:
+			n.Old = bytes.Clone(raw)
+		case pbNew:
+			n.New = bytes.Clone(raw)
+		case pbTimestamp:
+			t, err := decodeTimestamp(raw)
+			if err != nil {
+				return nil, err
+			}
+			n.Timestamp = t
+		case pbRef:
+			n.Ref = v != 0
+		case pbSnapshot:
+			n.Snapshot = v != 0
+		case pbTxID:
+			n.TxID = int64(v)
+		case pbCommit:
+			n.Commit = v != 0
+		case pbChanges:
+			n.Changes = int(int64(v))
+		case pbTenant:
+			n.Tenant = string(raw)
+		case pbActor:
+			n.Actor = string(raw)
+		}
+	}
+	for _, row := range []json.RawMessage{n.Key, n.Data, n.Old, n.New} {
+		if row != nil && !json.Valid(row) {
+			return nil, fmt.Errorf("protobuf: row of %s is not valid JSON", n.Table)
+		}
+	}
+	return n, nil
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp.
+func decodeTimestamp(data []byte) (time.Time, error) {
+	var secs, nanos int64
+	for len(data) > 0 {
+		num, typ, l := protowire.ConsumeTag(data)
+		if l < 0 {
+			return time.Time{}, fmt.Errorf("protobuf: timestamp: %w", protowire.ParseError(l))
+		}
+		data = data[l:]
+		if typ != protowire.VarintType {
+			l = protowire.ConsumeFieldValue(num, typ, data)
+			if l < 0 {
+				return time.Time{}, fmt.Errorf("protobuf: timestamp: %w", protowire.ParseError(l))
+			}
+			data = data[l:]
+			continue
+		}
+		v, l := protowire.ConsumeVarint(data)
+		if l < 0 {
+			return time.Time{}, fmt.Errorf("protobuf: timestamp: %w", protowire.ParseError(l))
+		}
+		data = data[l:]
+		switch num {
+		case 1:
+			secs = int64(v)
+		case 2:
+			nanos = int64(v)
+		}
+	}
+	return time.Unix(secs, nanos).UTC(), nil
+}
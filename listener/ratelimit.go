@@ -0,0 +1,222 @@
+package listener
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DropRateLimited = "rate_limited"
+	DropCoalesced   = "coalesced"
+)
+
+// RatePolicy selects what happens to notifications exceeding a rate limit.
+type RatePolicy int
+
+const (
+	// RateBuffer waits for capacity, so the excess queues up before the
+	// handler.
+	RateBuffer RatePolicy = iota
+	// RateDrop drops the excess, counting it as dropped.
+	RateDrop
+	// RateCoalesce holds back the excess, keeping only the latest change
+	// of each row, and delivers it once there is capacity. Notifications
+	// without a key are buffered.
+	RateCoalesce
+)
+
+// RateLimit caps how many notifications per second a handler receives,
+// allowing bursts of up to Burst (at least 1 and by default the rate).
+// With PerTable every table matched by the handler has its own limit, with
+// PerTenant every tenant, so a busy tenant cannot use up the others' share.
+type RateLimit struct {
+	Rate      float64
+	Burst     int
+	Policy    RatePolicy
+	PerTable  bool
+	PerTenant bool
+}
+
+// WithRateLimit rate-limits one handler.
+func WithRateLimit(l RateLimit) HandlerOption {
+	if l.Burst <= 0 {
+		l.Burst = int(math.Max(1, math.Ceil(l.Rate)))
+	}
+	return func(r *registration) {
+		r.limiter = &rateLimiter{
+			cfg:     l,
+			buckets: make(map[string]*tokenBucket),
+			held:    make(map[string]*heldNotification),
+		}
+	}
+}
+
+// tokenBucket refills at rate tokens per second, up to burst.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take consumes a token, or returns how long until one is available.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter is the rate limit state of one handler.
+type rateLimiter struct {
+	cfg RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	// held are the coalesced notifications by row, delivered in order.
+	held     map[string]*heldNotification
+	order    []string
+	flushing bool
+}
+
+func (l *rateLimiter) bucket(n *ChangeNotification, now time.Time) *tokenBucket {
+	key := ""
+	if l.cfg.PerTable {
+		key = n.QualifiedTable()
+	}
+	if l.cfg.PerTenant {
+		key += "\x00" + n.Tenant
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{rate: l.cfg.Rate, burst: float64(l.cfg.Burst), tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+func rowKey(n *ChangeNotification) string {
+	if len(n.Key) == 0 {
+		return ""
+	}
+	return n.QualifiedTable() + "\x00" + string(n.Key)
+}
+
+// limit applies the handler's rate limit, reporting whether it took care
+// of n itself.
+func (dl *DataListener) limit(ctx context.Context, n *ChangeNotification, reg *registration) (bool, *Failure) {
+	l := reg.limiter
+	if l.cfg.Rate <= 0 {
+		return false, nil
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		key := ""
+		if l.cfg.Policy == RateCoalesce {
+			key = rowKey(n)
+		}
+		// A row already held back is replaced even when there is
+		// capacity, so an older change is never delivered after it.
+		if prev, ok := l.held[key]; ok && key != "" {
+			l.held[key] = dl.hold(ctx, n)
+			l.mu.Unlock()
+			dl.metrics.Dropped(prev.n.Channel, DropCoalesced)
+			dl.tenantDropped(prev.n, DropCoalesced)
+			prev.ack()
+			dl.inflight.Done()
+			return true, nil
+		}
+		ok, wait := l.bucket(n, now).take(now)
+		if ok {
+			l.mu.Unlock()
+			return false, nil
+		}
+
+		switch {
+		case l.cfg.Policy == RateDrop:
+			l.mu.Unlock()
+			dl.logger.Debug("dropping rate-limited notification",
+				"channel", n.Channel, "table", n.Table, "operation", n.Operation)
+			dl.metrics.Dropped(n.Channel, DropRateLimited)
+			dl.tenantDropped(n, DropRateLimited)
+			return true, nil
+		case key != "":
+			l.held[key] = dl.hold(ctx, n)
+			l.order = append(l.order, key)
+			if !l.flushing {
+				l.flushing = true
+				time.AfterFunc(wait, func() { dl.flushLimited(reg) })
+			}
+			l.mu.Unlock()
+			return true, nil
+		}
+		l.mu.Unlock()
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-dl.stop:
+			// Left unacknowledged, so it is delivered again after a
+			// restart rather than past the limit.
+			t.Stop()
+			DeferAck(ctx)
+			return true, nil
+		}
+	}
+}
+
+// flushLimited delivers coalesced notifications as capacity frees up,
+// each where its ordering key is processed, see schedule. One still held
+// when the listener stops is left unacknowledged.
+func (dl *DataListener) flushLimited(reg *registration) {
+	l := reg.limiter
+	for {
+		l.mu.Lock()
+		if len(l.order) == 0 {
+			l.flushing = false
+			l.mu.Unlock()
+			return
+		}
+		key := l.order[0]
+		h := l.held[key]
+		now := time.Now()
+		ok, wait := l.bucket(h.n, now).take(now)
+		if !ok {
+			l.mu.Unlock()
+			time.AfterFunc(wait, func() { dl.flushLimited(reg) })
+			return
+		}
+		l.order = l.order[1:]
+		delete(l.held, key)
+		l.mu.Unlock()
+
+		scheduled := dl.schedule(h.ctx, h.n, func(context.Context) {
+			defer dl.inflight.Done()
+			failure := h.redeliver(func(ctx context.Context) *Failure { return dl.call(ctx, h.n, reg) })
+			if failure != nil {
+				dl.fail(h.ctx, failure)
+			}
+		})
+		if !scheduled {
+			dl.inflight.Done()
+		}
+	}
+}
+
+// hold defers the ack of n to deliver it later, counting it as in flight
+// until then so Drain and Shutdown wait for it. The caller calls
+// dl.inflight.Done once it is delivered or dropped.
+func (dl *DataListener) hold(ctx context.Context, n *ChangeNotification) *heldNotification {
+	dl.inflight.Add(1)
+	return &heldNotification{ctx: ctx, n: n, ack: DeferAck(ctx)}
+}
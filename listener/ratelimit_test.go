@@ -0,0 +1,138 @@
+package listener
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    float64
+		takes    []time.Duration
+		wantOK   []bool
+		wantWait time.Duration
+	}{
+		{name: "burst", rate: 1, burst: 3, takes: []time.Duration{0, 0, 0, 0}, wantOK: []bool{true, true, true, false}, wantWait: time.Second},
+		{name: "refill", rate: 10, burst: 1, takes: []time.Duration{0, 0, 100 * time.Millisecond}, wantOK: []bool{true, false, true}},
+		{name: "partial refill", rate: 2, burst: 1, takes: []time.Duration{0, 250 * time.Millisecond}, wantOK: []bool{true, false}, wantWait: 250 * time.Millisecond},
+		{name: "capped by burst", rate: 100, burst: 2, takes: []time.Duration{time.Hour, time.Hour, time.Hour}, wantOK: []bool{true, true, false}, wantWait: 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tokenBucket{rate: tt.rate, burst: tt.burst, tokens: tt.burst, last: start}
+			var wait time.Duration
+			for i, at := range tt.takes {
+				var ok bool
+				ok, wait = b.take(start.Add(at))
+				if ok != tt.wantOK[i] {
+					t.Fatalf("take %d = %v, want %v", i, ok, tt.wantOK[i])
+				}
+			}
+			if wait != tt.wantWait {
+				t.Errorf("wait = %v, want %v", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  RateLimit
+		events []string
+		// stop stops the listener while the last event waits or is
+		// held.
+		stop      bool
+		wantV     []float64
+		wantAcked []int64
+	}{
+		{
+			name:      "drop",
+			limit:     RateLimit{Rate: 0.001, Policy: RateDrop},
+			events:    []string{payload(OpInsert, 1, `,"v":1`), payload(OpInsert, 2, `,"v":2`), payload(OpInsert, 3, `,"v":3`)},
+			wantV:     []float64{1},
+			wantAcked: []int64{1, 2, 3},
+		},
+		{
+			name:      "buffer",
+			limit:     RateLimit{Rate: 50, Policy: RateBuffer},
+			events:    []string{payload(OpInsert, 1, `,"v":1`), payload(OpInsert, 2, `,"v":2`), payload(OpInsert, 3, `,"v":3`)},
+			wantV:     []float64{1, 2, 3},
+			wantAcked: []int64{1, 2, 3},
+		},
+		{
+			name:      "buffer on stop",
+			limit:     RateLimit{Rate: 0.001, Policy: RateBuffer},
+			events:    []string{payload(OpInsert, 1, `,"v":1`), payload(OpInsert, 2, `,"v":2`)},
+			stop:      true,
+			wantV:     []float64{1},
+			wantAcked: []int64{1},
+		},
+		{
+			name:  "coalesce",
+			limit: RateLimit{Rate: 10, Burst: 1, Policy: RateCoalesce},
+			events: []string{
+				payload(OpUpdate, 1, `,"v":1`),
+				payload(OpUpdate, 1, `,"v":2`),
+				payload(OpUpdate, 2, `,"v":3`),
+				payload(OpUpdate, 1, `,"v":4`),
+			},
+			wantV:     []float64{1, 4, 3},
+			wantAcked: []int64{1, 2, 3, 4},
+		},
+		{
+			name:      "coalesce on stop",
+			limit:     RateLimit{Rate: 10, Burst: 1, Policy: RateCoalesce},
+			events:    []string{payload(OpUpdate, 1, `,"v":1`), payload(OpUpdate, 2, `,"v":2`)},
+			stop:      true,
+			wantV:     []float64{1},
+			wantAcked: []int64{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dl, capture := newTestListener(t)
+			runScheduled(t, dl)
+			h := &handled{}
+			dl.Handle("users", h, WithRateLimit(tt.limit))
+
+			ctx := context.Background()
+			for i, ev := range tt.events {
+				id := int64(i + 1)
+				if !tt.stop || i < len(tt.events)-1 {
+					if err := dl.handleNotification(ctx, DefaultChannel, ev, id); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					dl.handleNotification(ctx, DefaultChannel, ev, id)
+				}()
+				time.Sleep(20 * time.Millisecond)
+				close(dl.stop)
+				<-done
+			}
+			if !idle(dl, 5*time.Second) {
+				t.Fatal("notifications still in flight")
+			}
+
+			var vs []float64
+			for _, n := range h.ns {
+				vs = append(vs, decodeData(t, n)["v"].(float64))
+			}
+			if !slices.Equal(vs, tt.wantV) {
+				t.Errorf("handled v %v, want %v", vs, tt.wantV)
+			}
+			if got := capture.ids(); !slices.Equal(got, tt.wantAcked) {
+				t.Errorf("acked %v, want %v", got, tt.wantAcked)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package listener
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RedactAction is what Redaction does with a column.
+type RedactAction int
+
+const (
+	// RedactDrop removes the column from the row.
+	RedactDrop RedactAction = iota
+	// RedactMask replaces the value with MaskValue.
+	RedactMask
+	// RedactHash replaces the value with the hex SHA-256 of it, an
+	// HMAC when the Redaction has a key. Unkeyed hashes of low-entropy
+	// values such as phone numbers are easily reversed.
+	RedactHash
+	// RedactTokenize replaces the value with a token that is the same for
+	// equal values and that Detokenize turns back into the value with the
+	// key. It requires a key.
+	RedactTokenize
+)
+
+// MaskValue replaces the values of masked columns.
+const MaskValue = "***"
+
+// tokenPrefix starts the tokens of RedactTokenize.
+const tokenPrefix = "tok_"
+
+// Redaction drops or pseudonymizes sensitive columns of a table's rows
+// before they reach the handler, see WithRedaction. Null values are left
+// as they are except by RedactDrop.
+type Redaction struct {
+	Columns map[string]RedactAction
+	// Key keys hashes and tokens; tokens are only reversible with it.
+	Key []byte
+}
+
+// WithRedaction applies r to the key and row images of the notifications
+// of one handler before it is called, and before the rows are validated,
+// so quarantined and dead-lettered notifications are redacted too.
+// Notifications whose rows cannot be redacted are dropped as malformed.
+func WithRedaction(r Redaction) HandlerOption {
+	return func(reg *registration) {
+		reg.redaction = &r
+	}
+}
+
+// redact applies the handler's redaction to n.
+func (dl *DataListener) redact(n *ChangeNotification, reg *registration) bool {
+	if n.redacted {
+		return true
+	}
+	var err error
+	for _, row := range []*json.RawMessage{&n.Key, &n.Data, &n.Old, &n.New} {
+		if isNull(*row) {
+			continue
+		}
+		if *row, err = reg.redaction.apply(*row); err != nil {
+			dl.logger.Error("failed to redact notification",
+				"channel", n.Channel, "table", n.Table, "error", err)
+			dl.metrics.Dropped(n.Channel, DropMalformed)
+			dl.tenantDropped(n, DropMalformed)
+			return false
+		}
+	}
+	n.redacted = true
+	return true
+}
+
+// apply returns row with the columns redacted, keeping the column order.
+func (r *Redaction) apply(row json.RawMessage) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(row))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("row is not a JSON object")
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		col := tok.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		action, ok := r.Columns[col]
+		if ok && action == RedactDrop {
+			continue
+		}
+		if ok && !isNull(v) {
+			if v, err = r.redactValue(action, v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col, err)
+			}
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func (r *Redaction) redactValue(action RedactAction, v json.RawMessage) (json.RawMessage, error) {
+	var value string
+	switch action {
+	case RedactMask:
+		value = MaskValue
+	case RedactHash:
+		value = r.hash(v)
+	case RedactTokenize:
+		token, err := r.tokenize(v)
+		if err != nil {
+			return nil, err
+		}
+		value = token
+	default:
+		return nil, fmt.Errorf("unknown redact action %d", action)
+	}
+	return json.Marshal(value)
+}
+
+// hash hashes the text of strings and the JSON of other values, so hashes
+// of strings match those computed elsewhere.
+func (r *Redaction) hash(v json.RawMessage) string {
+	data := []byte(v)
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		data = []byte(s)
+	}
+	if len(r.Key) == 0 {
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, r.Key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// tokenize encrypts v with AES-GCM under a nonce derived from v, so equal
+// values give equal tokens.
+func (r *Redaction) tokenize(v json.RawMessage) (string, error) {
+	if len(r.Key) == 0 {
+		return "", errors.New("tokenizing requires a key")
+	}
+	aead, err := tokenCipher(r.Key)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, deriveKey(r.Key, "nonce"))
+	mac.Write(v)
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+	sealed := aead.Seal(nonce, nonce, v, nil)
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Detokenize returns the JSON value a token of RedactTokenize stands for.
+func Detokenize(key []byte, token string) (json.RawMessage, error) {
+	raw, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return nil, errors.New("not a token")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	aead, err := tokenCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("invalid token")
+	}
+	v, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return v, nil
+}
+
+func tokenCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(key, "token"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives a 256 bit key for purpose from key.
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("pg-data-listener redact " + purpose))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,68 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const DropRowGone = "row_gone"
+
+// errRowGone is returned when the row referenced by an INSERT or UPDATE no
+// longer exists; the DELETE that removed it follows.
+var errRowGone = errors.New("referenced row no longer exists")
+
+// resolveReference loads the row of a by-reference notification into Data
+// and New. The row reflects its current state, which may be newer than the
+// change that was notified. DELETEs keep the key as their data.
+func (dl *DataListener) resolveReference(ctx context.Context, n *ChangeNotification) error {
+	if n.Operation == OpDelete {
+		n.Data = n.Key
+		n.fillImages()
+		return nil
+	}
+
+	key, err := decodeRow(n.Key)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to decode key of %s: %w", n.QualifiedTable(), err))
+	}
+	if len(key) == 0 {
+		return Permanent(fmt.Errorf("reference notification for %s has no key", n.QualifiedTable()))
+	}
+
+	cols := make([]string, 0, len(key))
+	for col := range key {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+
+	conds := make([]string, len(cols))
+	for i, col := range cols {
+		conds[i] = fmt.Sprintf("t.%[1]s = k.%[1]s", pq.QuoteIdentifier(col))
+	}
+
+	// jsonb_populate_record gives the key values the column types, so the
+	// lookup can use the primary key index.
+	table := quoteName(n.QualifiedTable())
+	query := fmt.Sprintf(`SELECT row_to_json(t) FROM %[1]s AS t, jsonb_populate_record(NULL::%[1]s, $1::jsonb) AS k WHERE %[2]s`,
+		table, strings.Join(conds, " AND "))
+
+	var row []byte
+	err = dl.db.QueryRowContext(ctx, query, []byte(n.Key)).Scan(&row)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return errRowGone
+	case err != nil:
+		return fmt.Errorf("failed to fetch %s row: %w", n.QualifiedTable(), err)
+	}
+
+	n.Data = row
+	n.New = nil
+	n.fillImages()
+	return nil
+}
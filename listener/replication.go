@@ -0,0 +1,661 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/lib/pq"
+)
+
+const (
+	PluginPgoutput = "pgoutput"
+	PluginWal2JSON = "wal2json"
+
+	DefaultSlotName              = "pg_data_listener"
+	DefaultPublication           = "pg_data_listener"
+	DefaultStandbyStatusInterval = 10 * time.Second
+)
+
+// ReplicationConfig configures a ReplicationSource, see WithReplication.
+// Handlers receive the same notifications as with LISTEN/NOTIFY; their id
+// is the LSN of the change.
+type ReplicationConfig struct {
+	// Slot is created with Plugin when it does not exist.
+	Slot   string
+	Plugin string
+	// Publication selects the tables streamed by pgoutput. It is created
+	// FOR ALL TABLES when it does not exist.
+	Publication    string
+	StatusInterval time.Duration
+	// The slot is checked every SlotCheckInterval; a warning is logged
+	// while it retains more than MaxRetainedBytes of WAL (0 disables it).
+	SlotCheckInterval time.Duration
+	MaxRetainedBytes  int64
+	// Checkpoints additionally saves the confirmed LSN under the slot's
+	// name with every status update, e.g. to resume a recreated slot.
+	Checkpoints CheckpointStore
+}
+
+func (c ReplicationConfig) withDefaults() ReplicationConfig {
+	if c.Slot == "" {
+		c.Slot = DefaultSlotName
+	}
+	if c.Plugin == "" {
+		c.Plugin = PluginPgoutput
+	}
+	if c.Publication == "" {
+		c.Publication = DefaultPublication
+	}
+	if c.StatusInterval <= 0 {
+		c.StatusInterval = DefaultStandbyStatusInterval
+	}
+	if c.SlotCheckInterval <= 0 {
+		c.SlotCheckInterval = DefaultSlotCheckInterval
+	}
+	return c
+}
+
+// replication tracks the stream position. The confirmed LSN only moves past
+// changes whose processing has completed, so a restart resumes from the
+// oldest unprocessed change.
+type replication struct {
+	cfg      ReplicationConfig
+	progress *watermark
+	// txid is the transaction being streamed; only used by the stream.
+	txid int64
+
+	mu        sync.Mutex
+	confirmed uint64
+	saved     uint64
+	slot      *SlotInfo
+}
+
+func newReplication(cfg ReplicationConfig) *replication {
+	return &replication{cfg: cfg.withDefaults(), progress: newWatermark()}
+}
+
+func (r *replication) decoder() (walDecoder, error) {
+	switch r.cfg.Plugin {
+	case PluginPgoutput:
+		return newPgoutput(r.cfg.Publication), nil
+	case PluginWal2JSON:
+		return &wal2json{}, nil
+	}
+	return nil, fmt.Errorf("unsupported replication plugin %q", r.cfg.Plugin)
+}
+
+func (r *replication) init(ctx context.Context, db *sql.DB) error {
+	if r.cfg.Plugin == PluginPgoutput {
+		var exists bool
+		err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)`, r.cfg.Publication).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to look up publication: %w", err)
+		}
+		if !exists {
+			if _, err := db.ExecContext(ctx, "CREATE PUBLICATION "+pq.QuoteIdentifier(r.cfg.Publication)+" FOR ALL TABLES"); err != nil {
+				return fmt.Errorf("failed to create publication: %w", err)
+			}
+		}
+	}
+
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`, r.cfg.Slot).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to look up replication slot: %w", err)
+	}
+	if !exists {
+		if _, err := db.ExecContext(ctx, `SELECT pg_create_logical_replication_slot($1, $2)`, r.cfg.Slot, r.cfg.Plugin); err != nil {
+			return fmt.Errorf("failed to create replication slot: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *replication) position() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.confirmed
+}
+
+func (r *replication) advance(lsn uint64) {
+	r.mu.Lock()
+	if lsn > r.confirmed {
+		r.confirmed = lsn
+	}
+	r.mu.Unlock()
+}
+
+func (r *replication) setSlot(s *SlotInfo) {
+	r.mu.Lock()
+	r.slot = s
+	r.mu.Unlock()
+}
+
+func (r *replication) lastSlot() *SlotInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.slot
+}
+
+func (r *replication) loadCheckpoint(ctx context.Context) error {
+	if r.cfg.Checkpoints == nil {
+		return nil
+	}
+	if init, ok := r.cfg.Checkpoints.(interface{ Init(context.Context) error }); ok {
+		if err := init.Init(ctx); err != nil {
+			return err
+		}
+	}
+	lsn, err := r.cfg.Checkpoints.Load(ctx, r.cfg.Slot)
+	if err != nil {
+		return err
+	}
+	r.advance(uint64(lsn))
+	return nil
+}
+
+// saveCheckpoint saves the confirmed LSN if it moved since the last save.
+func (r *replication) saveCheckpoint(ctx context.Context) error {
+	if r.cfg.Checkpoints == nil {
+		return nil
+	}
+	r.mu.Lock()
+	lsn := r.confirmed
+	if lsn == r.saved {
+		r.mu.Unlock()
+		return nil
+	}
+	r.mu.Unlock()
+
+	if err := r.cfg.Checkpoints.Save(ctx, r.cfg.Slot, int64(lsn)); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.saved = lsn
+	r.mu.Unlock()
+	return nil
+}
+
+// complete marks the change at lsn processed.
+func (r *replication) complete(lsn int64) {
+	r.advance(uint64(r.progress.complete(lsn)))
+}
+
+// ReplicationSource captures changes from a logical replication slot,
+// which needs no triggers and keeps changes on the server until they are
+// processed: the confirmed position only moves past events once they are
+// acknowledged. The events carry decoded notifications whose id is the
+// LSN of the change. It supports a single channel, which labels the
+// notifications.
+type ReplicationSource struct {
+	db      *sql.DB
+	connStr string
+	r       *replication
+	opts    connOptions
+
+	logger       Logger
+	hooks        *connHooks
+	metrics      Metrics
+	minReconnect time.Duration
+	maxReconnect time.Duration
+	// grouped holds back the changes of each transaction until its commit,
+	// then sends them followed by a commit marker, for a listener with a
+	// TransactionHandler.
+	grouped bool
+
+	events    chan Event
+	reconnect chan struct{}
+	connected atomic.Bool
+	started   atomic.Bool
+	stopping  chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+	err       error
+
+	// pending holds the changes of the transaction being streamed while
+	// grouped; only used by the stream.
+	pending []*ChangeNotification
+}
+
+// NewReplicationSource streams the slot described by cfg over a
+// replication connection opened with connStr. db creates the slot and
+// publication when missing and checks the slot's WAL retention.
+func NewReplicationSource(db *sql.DB, connStr string, cfg ReplicationConfig) *ReplicationSource {
+	return &ReplicationSource{
+		db:           db,
+		connStr:      connStr,
+		r:            newReplication(cfg),
+		logger:       defaultLogger{},
+		metrics:      NopMetrics{},
+		minReconnect: DefaultMinReconnectInterval,
+		maxReconnect: DefaultMaxReconnectInterval,
+		events:       make(chan Event),
+		reconnect:    make(chan struct{}, 1),
+		stopping:     make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+func (s *ReplicationSource) attach(dl *DataListener) {
+	s.logger = dl.logger
+	s.hooks = dl.hooks
+	s.metrics = dl.metrics
+	s.minReconnect = dl.minReconnect
+	s.maxReconnect = dl.maxReconnect
+	s.grouped = dl.transactions != nil
+	s.opts = dl.connOptions()
+}
+
+// Start creates the slot when missing and starts streaming it from the
+// confirmed position, so changes made from now on are not lost.
+func (s *ReplicationSource) Start(ctx context.Context, channels []string) error {
+	if len(channels) != 1 {
+		return errors.New("replication mode supports a single channel")
+	}
+
+	cfg, err := pgconn.ParseConfig(s.connStr)
+	if err != nil {
+		return fmt.Errorf("failed to parse connection string: %w", err)
+	}
+	cfg.RuntimeParams["replication"] = "database"
+	if err := requireSessionAttrs(cfg, s.connStr); err != nil {
+		return err
+	}
+	s.opts.tls.configure(cfg)
+
+	if err := s.r.init(ctx, s.db); err != nil {
+		return err
+	}
+	if err := s.r.loadCheckpoint(ctx); err != nil {
+		return err
+	}
+	s.started.Store(true)
+	go s.run(cfg, channels[0])
+	return nil
+}
+
+func (s *ReplicationSource) Notifications() <-chan Event {
+	return s.events
+}
+
+// Ack marks the change at LSN id processed.
+func (s *ReplicationSource) Ack(id int64) {
+	s.r.complete(id)
+}
+
+// Reconnect replaces the replication stream with a new one, confirming
+// the processed position first.
+func (s *ReplicationSource) Reconnect() error {
+	select {
+	case s.reconnect <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *ReplicationSource) Connected() bool {
+	return s.connected.Load()
+}
+
+// Slot returns the last check of the replication slot.
+func (s *ReplicationSource) Slot() *SlotInfo {
+	return s.r.lastSlot()
+}
+
+// Stop ends the stream after confirming the position of every
+// acknowledged change.
+func (s *ReplicationSource) Stop() error {
+	s.stopOnce.Do(func() { close(s.stopping) })
+	if !s.started.Load() {
+		return nil
+	}
+	<-s.done
+	return s.err
+}
+
+// run streams changes until Stop, reconnecting with backoff on errors.
+func (s *ReplicationSource) run(cfg *pgconn.Config, channel string) {
+	defer close(s.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	monitorDone := make(chan struct{})
+	defer close(monitorDone)
+	go s.monitorSlot(ctx, monitorDone)
+
+	backoff := s.minReconnect
+	for reconnected := false; ; reconnected = true {
+		stopped, err := s.stream(ctx, cfg, channel, reconnected)
+		if stopped {
+			s.hooks.stop()
+			s.err = err
+			return
+		}
+		s.connected.Store(false)
+		if errors.Is(err, errReconnect) {
+			s.logger.Info("reconnecting on request", "slot", s.r.cfg.Slot)
+			s.hooks.disconnect(nil)
+			backoff = s.minReconnect
+			continue
+		}
+		s.logger.Warn("replication stream failed", "slot", s.r.cfg.Slot, "error", err)
+		s.hooks.disconnect(err)
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-s.stopping:
+			t.Stop()
+			return
+		}
+		backoff *= 2
+		if backoff > s.maxReconnect {
+			backoff = s.maxReconnect
+		}
+	}
+}
+
+// stream runs one replication connection until it fails or the source is
+// stopped, in which case the processed position is confirmed.
+func (s *ReplicationSource) stream(ctx context.Context, cfg *pgconn.Config, channel string, reconnected bool) (stopped bool, err error) {
+	r := s.r
+	decoder, err := r.decoder()
+	if err != nil {
+		return true, err
+	}
+
+	if s.opts.password != nil {
+		cfg = cfg.Copy()
+		if err := s.opts.configure(ctx, cfg); err != nil {
+			return s.isStopping(), err
+		}
+	}
+	conn, err := pgconn.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return s.isStopping(), err
+	}
+	defer conn.Close(context.Background())
+
+	query := fmt.Sprintf("START_REPLICATION SLOT %s LOGICAL %s", pq.QuoteIdentifier(r.cfg.Slot), formatLSN(r.position()))
+	if args := decoder.pluginArgs(); len(args) > 0 {
+		query += " (" + strings.Join(args, ", ") + ")"
+	}
+	conn.Frontend().Send(&pgproto3.Query{String: query})
+	if err := conn.Frontend().Flush(); err != nil {
+		return false, err
+	}
+	for started := false; !started; {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return s.isStopping(), err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			started = true
+		case *pgproto3.ErrorResponse:
+			return false, fmt.Errorf("failed to start replication: %w", pgconn.ErrorResponseToPgError(msg))
+		}
+	}
+	s.connected.Store(true)
+	s.hooks.connect()
+	// Transactions cut off by a previous stream are sent again in full.
+	s.pending = nil
+	r.txid = 0
+	s.logger.Info("streaming replication slot", "slot", r.cfg.Slot, "plugin", r.cfg.Plugin)
+
+	status := time.NewTicker(r.cfg.StatusInterval)
+	defer status.Stop()
+	if reconnected {
+		if err := s.emit(conn, status, Event{Reconnected: true}); err != nil {
+			return s.stopped(conn, err)
+		}
+	}
+
+	// Receive in the background so the stop channel and status updates
+	// are served while waiting for WAL.
+	msgs := make(chan pgproto3.BackendMessage)
+	errs := make(chan error, 1)
+	recvCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var recv sync.WaitGroup
+	recv.Add(1)
+	go func() {
+		defer recv.Done()
+		for {
+			msg, err := conn.ReceiveMessage(recvCtx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			// The frontend reuses its messages on the next receive.
+			if data, ok := msg.(*pgproto3.CopyData); ok {
+				msg = &pgproto3.CopyData{Data: bytes.Clone(data.Data)}
+			}
+			select {
+			case msgs <- msg:
+			case <-recvCtx.Done():
+				return
+			}
+		}
+	}()
+	// The receiver is done with conn before it is used again.
+	finish := func() {
+		cancel()
+		recv.Wait()
+	}
+
+	for {
+		select {
+		case <-s.stopping:
+			finish()
+			return true, s.stopStream(conn)
+		case <-s.reconnect:
+			finish()
+			if err := s.sendStatus(conn); err != nil {
+				s.logger.Warn("failed to confirm replication position", "error", err)
+			}
+			return false, errReconnect
+		case err := <-errs:
+			if s.isStopping() {
+				return true, s.stopStream(conn)
+			}
+			return false, err
+		case <-status.C:
+			if err := s.sendStatus(conn); err != nil {
+				finish()
+				return false, err
+			}
+		case msg := <-msgs:
+			switch msg := msg.(type) {
+			case *pgproto3.ErrorResponse:
+				finish()
+				return false, pgconn.ErrorResponseToPgError(msg)
+			case *pgproto3.CopyDone:
+				finish()
+				return false, errors.New("server ended the replication stream")
+			case *pgproto3.CopyData:
+				reply, err := s.handleCopyData(conn, status, decoder, channel, msg.Data)
+				if err != nil {
+					finish()
+					return s.stopped(conn, err)
+				}
+				if reply {
+					if err := s.sendStatus(conn); err != nil {
+						finish()
+						return false, err
+					}
+				}
+			}
+		}
+	}
+}
+
+func (s *ReplicationSource) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopped ends the stream after err, which is errStopped when the source
+// was stopped while sending an event.
+func (s *ReplicationSource) stopped(conn *pgconn.PgConn, err error) (bool, error) {
+	if errors.Is(err, errStopped) {
+		return true, s.stopStream(conn)
+	}
+	return false, err
+}
+
+// emit sends ev, keeping the status updates going while the listener is
+// busy, e.g. running the snapshot or draining.
+func (s *ReplicationSource) emit(conn *pgconn.PgConn, status *time.Ticker, ev Event) error {
+	for {
+		select {
+		case s.events <- ev:
+			return nil
+		case <-status.C:
+			if err := s.sendStatus(conn); err != nil {
+				return err
+			}
+		case <-s.stopping:
+			return errStopped
+		}
+	}
+}
+
+// handleCopyData handles a keepalive or WAL data message, reporting
+// whether the server asked for a status update.
+func (s *ReplicationSource) handleCopyData(conn *pgconn.PgConn, status *time.Ticker, decoder walDecoder, channel string, data []byte) (bool, error) {
+	r := s.r
+	in := &msgReader{buf: data}
+	switch in.byte() {
+	case 'k':
+		end := in.uint64()
+		in.uint64() // server time
+		reply := in.byte() == 1
+		if in.err != nil {
+			return false, fmt.Errorf("failed to parse keepalive: %w", in.err)
+		}
+		// Without pending changes, nothing before the server's position
+		// needs to be replayed, so the slot need not retain it.
+		if !decoder.inTransaction() && r.progress.idle() {
+			r.advance(end)
+		}
+		return reply, nil
+	case 'w':
+		start := in.uint64()
+		in.uint64() // server WAL end
+		in.uint64() // server time
+		if in.err != nil {
+			return false, fmt.Errorf("failed to parse WAL data: %w", in.err)
+		}
+		n, commit, err := decoder.decode(start, in.buf)
+		if err != nil {
+			s.metrics.Dropped(channel, DropMalformed)
+			return false, fmt.Errorf("failed to decode WAL data at %s: %w", formatLSN(start), err)
+		}
+		if commit != 0 {
+			if err := s.commit(conn, status, channel, commit); err != nil {
+				return false, err
+			}
+		}
+		if n != nil {
+			n.ID = int64(start)
+			n.Channel = channel
+			if s.grouped && n.TxID != 0 {
+				// Sent once committed, so a broken stream leaves nothing
+				// pending.
+				r.txid = n.TxID
+				s.pending = append(s.pending, n)
+				return false, nil
+			}
+			r.progress.track(n.ID)
+			if err := s.emit(conn, status, Event{Channel: channel, Notification: n, ID: n.ID}); err != nil {
+				// The change stays pending, so it is not confirmed and is
+				// streamed again after a restart.
+				return false, err
+			}
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+// commit sends the held back changes of the transaction that just
+// committed and its commit marker, whose acknowledgement confirms the
+// commit position. Otherwise the commit position is confirmed once every
+// change before it is.
+func (s *ReplicationSource) commit(conn *pgconn.PgConn, status *time.Ticker, channel string, lsn uint64) error {
+	r := s.r
+	txid, changes := r.txid, s.pending
+	r.txid, s.pending = 0, nil
+	r.progress.track(int64(lsn))
+	if len(changes) == 0 {
+		r.complete(int64(lsn))
+		return nil
+	}
+
+	for _, c := range changes {
+		r.progress.track(c.ID)
+	}
+	for _, c := range changes {
+		if err := s.emit(conn, status, Event{Channel: channel, Notification: c, ID: c.ID}); err != nil {
+			return err
+		}
+	}
+	marker := &ChangeNotification{ID: int64(lsn), Channel: channel, TxID: txid, Commit: true, Changes: len(changes)}
+	return s.emit(conn, status, Event{Channel: channel, Notification: marker, ID: marker.ID})
+}
+
+// stopStream confirms the processed position before the connection is
+// closed.
+func (s *ReplicationSource) stopStream(conn *pgconn.PgConn) error {
+	s.logger.Info("stopping replication")
+	s.connected.Store(false)
+	return s.sendStatus(conn)
+}
+
+// sendStatus sends a standby status update confirming the processed
+// position, which lets the server discard WAL before it.
+func (s *ReplicationSource) sendStatus(conn *pgconn.PgConn) error {
+	lsn := s.r.position()
+	buf := make([]byte, 0, 34)
+	buf = append(buf, 'r')
+	buf = binary.BigEndian.AppendUint64(buf, lsn) // written
+	buf = binary.BigEndian.AppendUint64(buf, lsn) // flushed
+	buf = binary.BigEndian.AppendUint64(buf, lsn) // applied
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Since(pgEpoch).Microseconds()))
+	buf = append(buf, 0) // no reply requested
+	conn.Frontend().Send(&pgproto3.CopyData{Data: buf})
+	if err := conn.Frontend().Flush(); err != nil {
+		return fmt.Errorf("failed to send standby status: %w", err)
+	}
+	if err := s.r.saveCheckpoint(context.Background()); err != nil {
+		s.logger.Warn("failed to save replication checkpoint", "slot", s.r.cfg.Slot, "error", err)
+	}
+	return nil
+}
+
+// formatLSN formats lsn as PostgreSQL does, e.g. "16/B374D848".
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
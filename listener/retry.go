@@ -0,0 +1,152 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy controls how failed handler calls are retried. MaxAttempts
+// counts the first call, so 1 disables retries.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomizes each backoff by up to this fraction, e.g. 0.2 for
+	// ±20%.
+	Jitter float64
+}
+
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// DefaultRetryPolicy is a reasonable policy for transient downstream
+// failures; it is not applied unless configured.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// SetRetryPolicy replaces the policy of handlers without their own, e.g. on
+// a configuration reload. Calls already retrying keep their policy.
+func (dl *DataListener) SetRetryPolicy(p RetryPolicy) {
+	dl.mu.Lock()
+	dl.retry = p
+	dl.mu.Unlock()
+}
+
+func (dl *DataListener) retryPolicy() RetryPolicy {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.retry
+}
+
+// SetErrorHandler replaces the callback of WithErrorHandler, e.g. on a
+// configuration reload; nil logs the failures.
+func (dl *DataListener) SetErrorHandler(fn ErrorHandler) {
+	dl.mu.Lock()
+	dl.onError = fn
+	dl.mu.Unlock()
+}
+
+func (dl *DataListener) errorHandler() ErrorHandler {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.onError
+}
+
+// Backoff returns the delay before the given retry (1 for the first retry).
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(retry-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		d = 0
+	}
+	return time.Duration(d)
+}
+
+// PermanentError marks an error that must not be retried.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps err so the listener does not retry it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+func isPermanent(err error) bool {
+	var pe *PermanentError
+	var de *DecodeError
+	var panicErr *PanicError
+	return errors.As(err, &pe) || errors.As(err, &de) || errors.As(err, &panicErr) ||
+		errors.Is(err, errRowGone) || errors.Is(err, ErrHandlerDisabled)
+}
+
+// Failure describes a notification whose handler failed permanently, after
+// all retries were exhausted.
+type Failure struct {
+	Notification *ChangeNotification
+	Err          error
+	Attempts     int
+	FirstAttempt time.Time
+	LastAttempt  time.Time
+}
+
+// ErrorHandler is called for every permanently failed notification.
+type ErrorHandler func(ctx context.Context, f *Failure)
+
+// callWithRetry runs fn until it succeeds, returns a permanent error, or the
+// policy's attempts are exhausted.
+func callWithRetry(ctx context.Context, p RetryPolicy, n *ChangeNotification, fn func() error) *Failure {
+	first := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.MaxAttempts || isPermanent(err) {
+			return &Failure{
+				Notification: n,
+				Err:          err,
+				Attempts:     attempt,
+				FirstAttempt: first,
+				LastAttempt:  time.Now(),
+			}
+		}
+
+		t := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return &Failure{
+				Notification: n,
+				Err:          err,
+				Attempts:     attempt,
+				FirstAttempt: first,
+				LastAttempt:  time.Now(),
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// RouteHandler delivers each notification to the handlers named by expr,
+// which evaluates to a name or a list of names, e.g.
+//
+//	data.amount > 1000 ? ["audit", "alerts"] : "audit"
+//
+// A notification routed to no handler (an empty list or null) is
+// acknowledged. Unknown names and evaluation errors fail permanently, so
+// misrouted notifications reach the dead letter queue. As with several
+// handlers registered for one table, a failing handler fails the
+// notification and the retry delivers it to all routed handlers again.
+func RouteHandler(expr *Expr, handlers map[string]NotificationHandler) NotificationHandler {
+	return HandlerFunc(func(ctx context.Context, n *ChangeNotification) error {
+		names, err := routeNames(expr, n)
+		if err != nil {
+			return Permanent(fmt.Errorf("failed to route notification: %w", err))
+		}
+		var errs []error
+		parts, done := Parts(ctx, len(names))
+		for i, name := range names {
+			h, ok := handlers[name]
+			if !ok {
+				errs = append(errs, Permanent(fmt.Errorf("route %q is not a handler", name)))
+				done(i)
+				continue
+			}
+			if err := h.HandleNotification(parts[i], n); err != nil {
+				errs = append(errs, err)
+			}
+			done(i)
+		}
+		return errors.Join(errs...)
+	})
+}
+
+func routeNames(expr *Expr, n *ChangeNotification) ([]string, error) {
+	v, err := expr.Eval(n)
+	if err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		names := make([]string, len(v))
+		for i, name := range v {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("route evaluates to a list of %s, not string", celType(name))
+			}
+			names[i] = s
+		}
+		return names, nil
+	}
+	return nil, fmt.Errorf("route evaluates to %s, not string or list", celType(v))
+}
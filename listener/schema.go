@@ -0,0 +1,701 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DropInvalid counts notifications whose row failed their schema.
+const DropInvalid = "invalid"
+
+// ErrInvalidPayload is reported for a notification whose row failed its
+// schema when it could not be quarantined.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// WithSchema validates the rows a handler receives against schema: the
+// new row, or the old one of a DELETE. Notifications failing it are not
+// passed to the handler but to the quarantine, see WithQuarantine, with
+// ValidationErrors set.
+func WithSchema(schema *Schema) HandlerOption {
+	return func(r *registration) {
+		r.schema = schema
+	}
+}
+
+// WithQuarantine receives the notifications failing their handler's
+// schema, e.g. a sink writing them to a quarantine topic. Without it they
+// are logged and dropped. When the quarantine fails the notification is
+// reported as failed with ErrInvalidPayload, so it is dead-lettered.
+func WithQuarantine(h NotificationHandler) Option {
+	return func(dl *DataListener) {
+		dl.quarantine = h
+	}
+}
+
+// SetQuarantine replaces the quarantine, e.g. on a configuration reload;
+// nil drops invalid notifications.
+func (dl *DataListener) SetQuarantine(h NotificationHandler) {
+	dl.mu.Lock()
+	dl.quarantine = h
+	dl.mu.Unlock()
+}
+
+// validate checks n against the schema of reg, quarantining it when it
+// fails. It reports whether n may be passed to the handler.
+func (dl *DataListener) validate(ctx context.Context, n *ChangeNotification, reg *registration) (bool, *Failure) {
+	row := n.New
+	if n.Operation == OpDelete || row == nil {
+		row = n.Old
+	}
+	if row == nil {
+		row = n.Data
+	}
+	errs := reg.schema.Validate(row)
+	if len(errs) == 0 {
+		return true, nil
+	}
+	n.ValidationErrors = errs
+	dl.logger.Warn("notification failed its schema",
+		"channel", n.Channel, "table", n.Table, "operation", n.Operation, "errors", errs)
+	dl.metrics.Dropped(n.Channel, DropInvalid)
+	dl.tenantDropped(n, DropInvalid)
+
+	dl.mu.Lock()
+	quarantine := dl.quarantine
+	dl.mu.Unlock()
+	if quarantine == nil {
+		return false, nil
+	}
+	if err := quarantine.HandleNotification(ctx, n); err != nil {
+		now := time.Now()
+		return false, &Failure{
+			Notification: n,
+			Err:          fmt.Errorf("%w: failed to quarantine: %w", ErrInvalidPayload, err),
+			Attempts:     1,
+			FirstAttempt: now,
+			LastAttempt:  now,
+		}
+	}
+	return false, nil
+}
+
+// Schema is a compiled JSON Schema the rows of a table are validated
+// against, see WithSchema. It supports the validation keywords of draft
+// 2020-12 and draft-07: type, enum, const, the numeric, string, array and
+// object constraints, format (date-time, date, time, email, uuid, ipv4,
+// ipv6, uri), allOf, anyOf, oneOf, not and $ref within the document.
+// Other keywords are ignored.
+type Schema struct {
+	root *schemaNode
+}
+
+type schemaNode struct {
+	// always is set for the boolean schemas true and false.
+	always *bool
+
+	types    []string
+	enum     []any
+	hasConst bool
+	konst    any
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	multipleOf                         *float64
+
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	format               string
+
+	items                *schemaNode
+	prefixItems          []*schemaNode
+	minItems, maxItems   *int
+	uniqueItems          bool
+	properties           map[string]*schemaNode
+	patternProperties    []patternSchema
+	additionalProperties *schemaNode
+	required             []string
+	minProperties        *int
+	maxProperties        *int
+
+	allOf, anyOf, oneOf []*schemaNode
+	not                 *schemaNode
+	ref                 *schemaNode
+}
+
+type patternSchema struct {
+	re     *regexp.Regexp
+	schema *schemaNode
+}
+
+// CompileSchema compiles a JSON Schema document.
+func CompileSchema(document []byte) (*Schema, error) {
+	doc, err := decodeJSON(document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	c := &schemaCompiler{doc: doc, nodes: make(map[string]*schemaNode)}
+	root, err := c.compile(doc, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &Schema{root: root}, nil
+}
+
+// Validate checks a JSON document, returning a message per violation
+// prefixed with the JSON pointer of the offending value.
+func (s *Schema) Validate(document json.RawMessage) []string {
+	v, err := decodeJSON(document)
+	if err != nil {
+		return []string{"/: " + err.Error()}
+	}
+	var errs []string
+	s.root.validate(v, "", &errs)
+	return errs
+}
+
+func decodeJSON(data []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// schemaCompiler compiles the subschemas of doc, once per JSON pointer so
+// recursive references terminate.
+type schemaCompiler struct {
+	doc   any
+	nodes map[string]*schemaNode
+}
+
+func (c *schemaCompiler) compile(v any, ptr string) (*schemaNode, error) {
+	if n, ok := c.nodes[ptr]; ok {
+		return n, nil
+	}
+	n := &schemaNode{}
+	c.nodes[ptr] = n
+
+	switch s := v.(type) {
+	case bool:
+		n.always = &s
+		return n, nil
+	case map[string]any:
+		return n, c.fill(n, s, ptr)
+	default:
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", pointerOrRoot(ptr))
+	}
+}
+
+func (c *schemaCompiler) fill(n *schemaNode, s map[string]any, ptr string) error {
+	var err error
+	sub := func(key string) (*schemaNode, error) {
+		v, ok := s[key]
+		if !ok {
+			return nil, nil
+		}
+		return c.compile(v, ptr+"/"+escapePointer(key))
+	}
+	list := func(key string) ([]*schemaNode, error) {
+		v, ok := s[key]
+		if !ok {
+			return nil, nil
+		}
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/%s: must be an array", ptr, key)
+		}
+		nodes := make([]*schemaNode, len(arr))
+		for i, item := range arr {
+			if nodes[i], err = c.compile(item, ptr+"/"+key+"/"+strconv.Itoa(i)); err != nil {
+				return nil, err
+			}
+		}
+		return nodes, nil
+	}
+	number := func(key string) (*float64, error) {
+		v, ok := s[key]
+		if !ok {
+			return nil, nil
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			return nil, fmt.Errorf("%s/%s: must be a number", ptr, key)
+		}
+		return &f, nil
+	}
+	count := func(key string) (*int, error) {
+		f, err := number(key)
+		if err != nil || f == nil {
+			return nil, err
+		}
+		if *f < 0 || *f != math.Trunc(*f) {
+			return nil, fmt.Errorf("%s/%s: must be a non-negative integer", ptr, key)
+		}
+		i := int(*f)
+		return &i, nil
+	}
+
+	switch t := s["type"].(type) {
+	case nil:
+	case string:
+		n.types = []string{t}
+	case []any:
+		for _, v := range t {
+			name, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s/type: must be a string or an array of strings", ptr)
+			}
+			n.types = append(n.types, name)
+		}
+	default:
+		return fmt.Errorf("%s/type: must be a string or an array of strings", ptr)
+	}
+	if v, ok := s["enum"]; ok {
+		if n.enum, ok = v.([]any); !ok {
+			return fmt.Errorf("%s/enum: must be an array", ptr)
+		}
+	}
+	n.konst, n.hasConst = s["const"]
+
+	if n.minimum, err = number("minimum"); err != nil {
+		return err
+	}
+	if n.maximum, err = number("maximum"); err != nil {
+		return err
+	}
+	if n.exclusiveMinimum, err = number("exclusiveMinimum"); err != nil {
+		return err
+	}
+	if n.exclusiveMaximum, err = number("exclusiveMaximum"); err != nil {
+		return err
+	}
+	if n.multipleOf, err = number("multipleOf"); err != nil {
+		return err
+	}
+	if n.multipleOf != nil && *n.multipleOf <= 0 {
+		return fmt.Errorf("%s/multipleOf: must be positive", ptr)
+	}
+
+	if n.minLength, err = count("minLength"); err != nil {
+		return err
+	}
+	if n.maxLength, err = count("maxLength"); err != nil {
+		return err
+	}
+	if p, ok := s["pattern"].(string); ok {
+		if n.pattern, err = regexp.Compile(p); err != nil {
+			return fmt.Errorf("%s/pattern: %w", ptr, err)
+		}
+	}
+	n.format, _ = s["format"].(string)
+
+	// Draft-07 spells prefixItems as an items array.
+	if _, tuple := s["items"].([]any); tuple {
+		if n.prefixItems, err = list("items"); err != nil {
+			return err
+		}
+	} else {
+		if n.items, err = sub("items"); err != nil {
+			return err
+		}
+		if n.prefixItems, err = list("prefixItems"); err != nil {
+			return err
+		}
+	}
+	if n.minItems, err = count("minItems"); err != nil {
+		return err
+	}
+	if n.maxItems, err = count("maxItems"); err != nil {
+		return err
+	}
+	n.uniqueItems, _ = s["uniqueItems"].(bool)
+
+	if props, ok := s["properties"].(map[string]any); ok {
+		n.properties = make(map[string]*schemaNode, len(props))
+		for name, v := range props {
+			if n.properties[name], err = c.compile(v, ptr+"/properties/"+escapePointer(name)); err != nil {
+				return err
+			}
+		}
+	}
+	if props, ok := s["patternProperties"].(map[string]any); ok {
+		for p, v := range props {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("%s/patternProperties: %w", ptr, err)
+			}
+			node, err := c.compile(v, ptr+"/patternProperties/"+escapePointer(p))
+			if err != nil {
+				return err
+			}
+			n.patternProperties = append(n.patternProperties, patternSchema{re, node})
+		}
+	}
+	if n.additionalProperties, err = sub("additionalProperties"); err != nil {
+		return err
+	}
+	if req, ok := s["required"].([]any); ok {
+		for _, v := range req {
+			name, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("%s/required: must be an array of strings", ptr)
+			}
+			n.required = append(n.required, name)
+		}
+	}
+	if n.minProperties, err = count("minProperties"); err != nil {
+		return err
+	}
+	if n.maxProperties, err = count("maxProperties"); err != nil {
+		return err
+	}
+
+	if n.allOf, err = list("allOf"); err != nil {
+		return err
+	}
+	if n.anyOf, err = list("anyOf"); err != nil {
+		return err
+	}
+	if n.oneOf, err = list("oneOf"); err != nil {
+		return err
+	}
+	if n.not, err = sub("not"); err != nil {
+		return err
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		if n.ref, err = c.resolve(ref); err != nil {
+			return fmt.Errorf("%s/$ref: %w", ptr, err)
+		}
+	}
+	return nil
+}
+
+// resolve compiles the subschema a reference within the document points
+// to.
+func (c *schemaCompiler) resolve(ref string) (*schemaNode, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported reference %q, only references within the document are", ref)
+	}
+	ptr := strings.TrimPrefix(ref, "#")
+	v := c.doc
+	for _, token := range strings.Split(ptr, "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+		switch p := v.(type) {
+		case map[string]any:
+			var ok bool
+			if v, ok = p[token]; !ok {
+				return nil, fmt.Errorf("reference %q not found", ref)
+			}
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(p) {
+				return nil, fmt.Errorf("reference %q not found", ref)
+			}
+			v = p[i]
+		default:
+			return nil, fmt.Errorf("reference %q not found", ref)
+		}
+	}
+	return c.compile(v, ptr)
+}
+
+func (n *schemaNode) validate(v any, path string, errs *[]string) {
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, pointerOrRoot(path)+": "+fmt.Sprintf(format, args...))
+	}
+	if n.always != nil {
+		if !*n.always {
+			fail("no value is allowed")
+		}
+		return
+	}
+	if n.ref != nil {
+		n.ref.validate(v, path, errs)
+	}
+
+	if len(n.types) > 0 && !slices.ContainsFunc(n.types, func(t string) bool { return hasType(v, t) }) {
+		fail("must be of type %s, not %s", strings.Join(n.types, " or "), typeName(v))
+		return
+	}
+	if n.enum != nil && !slices.ContainsFunc(n.enum, func(e any) bool { return jsonEqual(e, v) }) {
+		fail("must be one of the enum values")
+	}
+	if n.hasConst && !jsonEqual(n.konst, v) {
+		fail("must equal the const value")
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		n.validateNumber(v, fail)
+	case string:
+		n.validateString(v, fail)
+	case []any:
+		n.validateArray(v, path, errs, fail)
+	case map[string]any:
+		n.validateObject(v, path, errs, fail)
+	}
+
+	for _, s := range n.allOf {
+		s.validate(v, path, errs)
+	}
+	if len(n.anyOf) > 0 {
+		if !slices.ContainsFunc(n.anyOf, func(s *schemaNode) bool { return s.matches(v) }) {
+			fail("must match a schema of anyOf")
+		}
+	}
+	if len(n.oneOf) > 0 {
+		matched := 0
+		for _, s := range n.oneOf {
+			if s.matches(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("must match exactly one schema of oneOf, matches %d", matched)
+		}
+	}
+	if n.not != nil && n.not.matches(v) {
+		fail("must not match the schema of not")
+	}
+}
+
+func (n *schemaNode) matches(v any) bool {
+	var errs []string
+	n.validate(v, "", &errs)
+	return len(errs) == 0
+}
+
+func (n *schemaNode) validateNumber(v json.Number, fail func(string, ...any)) {
+	f, _ := toFloat(v)
+	if n.minimum != nil && f < *n.minimum {
+		fail("must be >= %v", *n.minimum)
+	}
+	if n.maximum != nil && f > *n.maximum {
+		fail("must be <= %v", *n.maximum)
+	}
+	if n.exclusiveMinimum != nil && f <= *n.exclusiveMinimum {
+		fail("must be > %v", *n.exclusiveMinimum)
+	}
+	if n.exclusiveMaximum != nil && f >= *n.exclusiveMaximum {
+		fail("must be < %v", *n.exclusiveMaximum)
+	}
+	if n.multipleOf != nil {
+		if q := f / *n.multipleOf; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %v", *n.multipleOf)
+		}
+	}
+}
+
+func (n *schemaNode) validateString(v string, fail func(string, ...any)) {
+	length := utf8.RuneCountInString(v)
+	if n.minLength != nil && length < *n.minLength {
+		fail("must be at least %d characters long", *n.minLength)
+	}
+	if n.maxLength != nil && length > *n.maxLength {
+		fail("must be at most %d characters long", *n.maxLength)
+	}
+	if n.pattern != nil && !n.pattern.MatchString(v) {
+		fail("must match pattern %s", n.pattern)
+	}
+	if n.format != "" && !validFormat(n.format, v) {
+		fail("must be a valid %s", n.format)
+	}
+}
+
+func (n *schemaNode) validateArray(v []any, path string, errs *[]string, fail func(string, ...any)) {
+	if n.minItems != nil && len(v) < *n.minItems {
+		fail("must have at least %d items", *n.minItems)
+	}
+	if n.maxItems != nil && len(v) > *n.maxItems {
+		fail("must have at most %d items", *n.maxItems)
+	}
+	if n.uniqueItems {
+		for i := range v {
+			for j := i + 1; j < len(v); j++ {
+				if jsonEqual(v[i], v[j]) {
+					fail("items %d and %d must be unique", i, j)
+				}
+			}
+		}
+	}
+	for i, item := range v {
+		itemPath := path + "/" + strconv.Itoa(i)
+		switch {
+		case i < len(n.prefixItems):
+			n.prefixItems[i].validate(item, itemPath, errs)
+		case n.items != nil:
+			n.items.validate(item, itemPath, errs)
+		}
+	}
+}
+
+func (n *schemaNode) validateObject(v map[string]any, path string, errs *[]string, fail func(string, ...any)) {
+	for _, name := range n.required {
+		if _, ok := v[name]; !ok {
+			fail("missing required property %q", name)
+		}
+	}
+	if n.minProperties != nil && len(v) < *n.minProperties {
+		fail("must have at least %d properties", *n.minProperties)
+	}
+	if n.maxProperties != nil && len(v) > *n.maxProperties {
+		fail("must have at most %d properties", *n.maxProperties)
+	}
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	// Sorted, so the errors come in a stable order.
+	slices.Sort(names)
+	for _, name := range names {
+		propPath := path + "/" + escapePointer(name)
+		matched := false
+		if s, ok := n.properties[name]; ok {
+			s.validate(v[name], propPath, errs)
+			matched = true
+		}
+		for _, p := range n.patternProperties {
+			if p.re.MatchString(name) {
+				p.schema.validate(v[name], propPath, errs)
+				matched = true
+			}
+		}
+		if !matched && n.additionalProperties != nil {
+			if a := n.additionalProperties.always; a != nil && !*a {
+				fail("property %q is not allowed", name)
+				continue
+			}
+			n.additionalProperties.validate(v[name], propPath, errs)
+		}
+	}
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "integer":
+		num, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		f, _ := toFloat(num)
+		return f == math.Trunc(f)
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	}
+	return typeName(v) == t
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func toFloat(v any) (float64, bool) {
+	num, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := num.Float64()
+	return f, err == nil
+}
+
+// jsonEqual compares decoded JSON values, numbers by value.
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		fa, _ := toFloat(a)
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	case []any:
+		b, ok := b.([]any)
+		return ok && slices.EqualFunc(a, b, jsonEqual)
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, va := range a {
+			vb, ok := b[k]
+			if !ok || !jsonEqual(va, vb) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// validFormat checks the formats Schema knows; others always pass.
+func validFormat(format, v string) bool {
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339Nano, v)
+		return err == nil
+	case "date":
+		_, err := time.Parse(time.DateOnly, v)
+		return err == nil
+	case "time":
+		_, err := time.Parse("15:04:05.999999999Z07:00", v)
+		return err == nil
+	case "email":
+		addr, err := mail.ParseAddress(v)
+		return err == nil && addr.Address == v
+	case "uuid":
+		return uuidPattern.MatchString(v)
+	case "ipv4":
+		ip := net.ParseIP(v)
+		return ip != nil && ip.To4() != nil && !strings.Contains(v, ":")
+	case "ipv6":
+		ip := net.ParseIP(v)
+		return ip != nil && strings.Contains(v, ":")
+	case "uri":
+		u, err := url.Parse(v)
+		return err == nil && u.Scheme != ""
+	}
+	return true
+}
+
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func pointerOrRoot(ptr string) string {
+	if ptr == "" {
+		return "/"
+	}
+	return ptr
+}
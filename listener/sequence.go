@@ -0,0 +1,73 @@
+package listener
+
+import (
+	"context"
+	"sync"
+)
+
+// Gap describes notifications missing between two sequence numbers of a
+// channel.
+type Gap struct {
+	Channel string
+	// Last is the last sequence number received before Next.
+	Last int64
+	Next int64
+}
+
+// Missed returns the number of notifications in the gap.
+func (g Gap) Missed() int64 {
+	return g.Next - g.Last - 1
+}
+
+// GapHandler is called for every detected gap.
+type GapHandler func(ctx context.Context, g Gap)
+
+// sequenceTracker remembers the last sequence number seen per channel.
+type sequenceTracker struct {
+	mu   sync.Mutex
+	last map[string]int64
+}
+
+func newSequenceTracker() *sequenceTracker {
+	return &sequenceTracker{last: make(map[string]int64)}
+}
+
+// observe records seq and reports a gap before it. The first number seen
+// on a channel starts tracking; older numbers, e.g. from a catch-up, are
+// ignored.
+func (t *sequenceTracker) observe(channel string, seq int64) (Gap, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	last, ok := t.last[channel]
+	if seq > last {
+		t.last[channel] = seq
+	}
+	if !ok || seq <= last+1 {
+		return Gap{}, false
+	}
+	return Gap{Channel: channel, Last: last, Next: seq}, true
+}
+
+// checkSequence reports a gap before n and, when configured, replays the
+// missed notifications from the outbox.
+func (dl *DataListener) checkSequence(ctx context.Context, n *ChangeNotification) {
+	if n.Seq == 0 {
+		return
+	}
+	gap, ok := dl.sequences.observe(n.Channel, n.Seq)
+	if !ok {
+		return
+	}
+
+	dl.logger.Warn("missed notifications", "channel", gap.Channel, "after", gap.Last, "next", gap.Next, "missed", gap.Missed())
+	dl.metrics.SequenceGap(gap.Channel, gap.Missed())
+	if dl.onGap != nil {
+		dl.onGap(ctx, gap)
+	}
+	if dl.gapCatchUp && dl.outbox != nil {
+		if err := dl.catchUp(ctx); err != nil {
+			dl.logger.Error("outbox catch-up failed", "error", err)
+		}
+	}
+}
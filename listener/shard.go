@@ -0,0 +1,308 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultShardTable    = "data_listener_shard_members"
+	DefaultShardInterval = 5 * time.Second
+)
+
+// ShardKey selects what the changes are sharded by.
+type ShardKey int
+
+const (
+	// ShardByTable spreads the tables across the instances.
+	ShardByTable ShardKey = iota
+	// ShardByChannel spreads the channels across the instances.
+	ShardByChannel
+)
+
+func (k ShardKey) String() string {
+	switch k {
+	case ShardByTable:
+		return "table"
+	case ShardByChannel:
+		return "channel"
+	default:
+		return fmt.Sprintf("ShardKey(%d)", int(k))
+	}
+}
+
+// ShardConfig spreads the changes across several instances listening on
+// the same channels. Every table, or channel, belongs to one of Shards
+// shards, by hash unless Assign pins it, and every instance processes the
+// changes of the shards it claims. The instances claim them through a
+// shared table in which each one keeps a heartbeat: the shards are split
+// among the live instances by rendezvous hashing, so an instance joining
+// or leaving only moves its share.
+type ShardConfig struct {
+	// Shards is the number of shards; it must be the same on every
+	// instance.
+	Shards int
+	By     ShardKey
+	// Assign pins tables, by qualified name, or channels to shards.
+	Assign map[string]int
+	// Claim fixes the shards of this instance instead of coordinating
+	// through Table.
+	Claim []int
+	// Instance names this instance in Table, the host name and process
+	// id by default.
+	Instance string
+	Table    string
+	// Interval is how often the heartbeat is written; an instance missing
+	// it for TTL, three intervals by default, loses its shards.
+	Interval time.Duration
+	TTL      time.Duration
+}
+
+func (c ShardConfig) withDefaults() ShardConfig {
+	if c.Instance == "" {
+		host, _ := os.Hostname()
+		c.Instance = host + "-" + strconv.Itoa(os.Getpid())
+	}
+	if c.Table == "" {
+		c.Table = DefaultShardTable
+	}
+	if c.Interval <= 0 {
+		c.Interval = DefaultShardInterval
+	}
+	if c.TTL <= 0 {
+		c.TTL = 3 * c.Interval
+	}
+	return c
+}
+
+func (c ShardConfig) validate() error {
+	if c.Shards <= 0 {
+		return errors.New("sharding: shards must be positive")
+	}
+	for name, shard := range c.Assign {
+		if shard < 0 || shard >= c.Shards {
+			return fmt.Errorf("sharding: %s assigned to shard %d of %d", name, shard, c.Shards)
+		}
+	}
+	for _, shard := range c.Claim {
+		if shard < 0 || shard >= c.Shards {
+			return fmt.Errorf("sharding: claimed shard %d of %d", shard, c.Shards)
+		}
+	}
+	return nil
+}
+
+// WithSharding makes the listener process only the changes of its shards,
+// so several instances can share a high change volume. Changes of other
+// shards are dropped without calling a handler. While the shards move
+// between instances, changes can be handled twice for up to an interval;
+// the shards of an instance that died are taken over after the TTL, and
+// changes of them sent meanwhile are lost. It is not supported with the
+// outbox, replication or transaction grouping.
+func WithSharding(cfg ShardConfig) Option {
+	return func(dl *DataListener) {
+		dl.shards = &sharder{cfg: cfg.withDefaults()}
+	}
+}
+
+// sharder tracks the shards the listener owns.
+type sharder struct {
+	cfg    ShardConfig
+	db     *sql.DB
+	logger Logger
+
+	owned atomic.Pointer[[]bool]
+	// lastBeat is when the heartbeat last succeeded.
+	lastBeat time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// shard returns the shard of n.
+func (s *sharder) shard(n *ChangeNotification) int {
+	key := n.Channel
+	if s.cfg.By == ShardByTable {
+		key = n.QualifiedTable()
+	}
+	if shard, ok := s.cfg.Assign[key]; ok {
+		return shard
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(s.cfg.Shards))
+}
+
+// owns reports whether the listener processes n. A nil *sharder owns
+// every change.
+func (s *sharder) owns(n *ChangeNotification) bool {
+	if s == nil {
+		return true
+	}
+	owned := s.owned.Load()
+	return owned != nil && (*owned)[s.shard(n)]
+}
+
+// claimed returns the shards the listener processes.
+func (s *sharder) claimed() []int {
+	if s == nil {
+		return nil
+	}
+	var shards []int
+	if owned := s.owned.Load(); owned != nil {
+		for shard, ok := range *owned {
+			if ok {
+				shards = append(shards, shard)
+			}
+		}
+	}
+	return shards
+}
+
+// start claims the first shards and keeps claiming them until close.
+func (s *sharder) start(ctx context.Context, db *sql.DB, logger Logger) error {
+	if err := s.cfg.validate(); err != nil {
+		return err
+	}
+	s.db, s.logger = db, logger
+	if s.cfg.Claim != nil {
+		s.set(s.cfg.Claim)
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    instance TEXT PRIMARY KEY,
+    seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, quoteName(s.cfg.Table))); err != nil {
+		return fmt.Errorf("failed to create shard table %s: %w", s.cfg.Table, err)
+	}
+	if err := s.beat(ctx); err != nil {
+		return err
+	}
+
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go s.run()
+	return nil
+}
+
+func (s *sharder) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Interval)
+		err := s.beat(ctx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		s.logger.Warn("shard heartbeat failed", "error", err)
+		// The others take over the shards once the heartbeat expired.
+		if time.Since(s.lastBeat) >= s.cfg.TTL && len(s.claimed()) > 0 {
+			s.logger.Error("shard heartbeat expired, releasing shards")
+			s.set(nil)
+		}
+	}
+}
+
+// beat writes the heartbeat and claims the shards of this instance among
+// the live ones.
+func (s *sharder) beat(ctx context.Context) error {
+	table := quoteName(s.cfg.Table)
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s (instance) VALUES ($1)
+ON CONFLICT (instance) DO UPDATE SET seen_at = CURRENT_TIMESTAMP`, table), s.cfg.Instance); err != nil {
+		return fmt.Errorf("failed to write shard heartbeat: %w", err)
+	}
+	ttl := s.cfg.TTL.Seconds()
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE seen_at < CURRENT_TIMESTAMP - make_interval(secs => $1)", table), ttl); err != nil {
+		return fmt.Errorf("failed to expire shard members: %w", err)
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf("SELECT instance FROM %s", table))
+	if err != nil {
+		return fmt.Errorf("failed to read shard members: %w", err)
+	}
+	defer rows.Close()
+	var members []string
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err != nil {
+			return err
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.lastBeat = time.Now()
+
+	var shards []int
+	for shard := range s.cfg.Shards {
+		if rendezvous(members, shard) == s.cfg.Instance {
+			shards = append(shards, shard)
+		}
+	}
+	s.set(shards)
+	return nil
+}
+
+// set replaces the owned shards, logging changes.
+func (s *sharder) set(shards []int) {
+	owned := make([]bool, s.cfg.Shards)
+	for _, shard := range shards {
+		owned[shard] = true
+	}
+	if prev := s.owned.Swap(&owned); prev == nil || !slices.Equal(*prev, owned) {
+		s.logger.Info("claimed shards", "instance", s.cfg.Instance, "shards", shards)
+	}
+}
+
+// close stops the heartbeat and leaves the table, so the others take over
+// the shards without waiting for the TTL.
+func (s *sharder) close() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Interval)
+	defer cancel()
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE instance = $1", quoteName(s.cfg.Table)), s.cfg.Instance); err != nil {
+		s.logger.Warn("failed to leave shard table", "error", err)
+	}
+}
+
+// rendezvous returns the member with the highest hash for shard.
+func rendezvous(members []string, shard int) string {
+	var best string
+	var top uint64
+	for _, m := range members {
+		h := fnv.New64a()
+		h.Write([]byte(m))
+		h.Write([]byte{0})
+		h.Write([]byte(strconv.Itoa(shard)))
+		// The finalizer of MurmurHash3 spreads the similar keys.
+		sum := h.Sum64()
+		sum ^= sum >> 33
+		sum *= 0xff51afd7ed558ccd
+		sum ^= sum >> 33
+		if best == "" || sum > top || (sum == top && m < best) {
+			best, top = m, sum
+		}
+	}
+	return best
+}
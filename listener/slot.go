@@ -0,0 +1,145 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const DefaultSlotCheckInterval = time.Minute
+
+// SlotInfo describes a replication slot and how much WAL it holds back.
+type SlotInfo struct {
+	Name              string `json:"name"`
+	Plugin            string `json:"plugin,omitempty"`
+	Active            bool   `json:"active"`
+	RestartLSN        string `json:"restart_lsn,omitempty"`
+	ConfirmedFlushLSN string `json:"confirmed_flush_lsn,omitempty"`
+	// RetainedBytes is the WAL the server keeps for the slot; LagBytes is
+	// how far the confirmed position trails the current WAL position.
+	RetainedBytes int64 `json:"retained_bytes"`
+	LagBytes      int64 `json:"lag_bytes"`
+	// WALStatus is "reserved", "extended", "unreserved" or "lost"; a lost
+	// slot can no longer stream.
+	WALStatus string `json:"wal_status,omitempty"`
+}
+
+const slotQuery = `SELECT slot_name, COALESCE(plugin, ''), active,
+    COALESCE(restart_lsn::text, ''), COALESCE(confirmed_flush_lsn::text, ''),
+    COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn), 0)::bigint,
+    COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn), 0)::bigint,
+    COALESCE(wal_status, '')
+FROM pg_replication_slots`
+
+func scanSlot(row interface{ Scan(...any) error }) (*SlotInfo, error) {
+	var s SlotInfo
+	err := row.Scan(&s.Name, &s.Plugin, &s.Active, &s.RestartLSN, &s.ConfirmedFlushLSN,
+		&s.RetainedBytes, &s.LagBytes, &s.WALStatus)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ReplicationSlots lists the replication slots of the database server.
+func (dl *DataListener) ReplicationSlots(ctx context.Context) ([]*SlotInfo, error) {
+	rows, err := dl.db.QueryContext(ctx, slotQuery+` ORDER BY slot_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replication slots: %w", err)
+	}
+	defer rows.Close()
+
+	var slots []*SlotInfo
+	for rows.Next() {
+		s, err := scanSlot(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan replication slot: %w", err)
+		}
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+// ReplicationSlot returns the named slot, or nil when it does not exist.
+func (dl *DataListener) ReplicationSlot(ctx context.Context, name string) (*SlotInfo, error) {
+	return lookupSlot(ctx, dl.db, name)
+}
+
+func lookupSlot(ctx context.Context, db *sql.DB, name string) (*SlotInfo, error) {
+	s, err := scanSlot(db.QueryRowContext(ctx, slotQuery+` WHERE slot_name = $1`, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up replication slot: %w", err)
+	}
+	return s, nil
+}
+
+// CreateReplicationSlot creates a logical replication slot using plugin
+// (PluginPgoutput when empty). The slot retains WAL from now on, even while
+// no listener is streaming it.
+func (dl *DataListener) CreateReplicationSlot(ctx context.Context, name, plugin string) error {
+	if plugin == "" {
+		plugin = PluginPgoutput
+	}
+	if _, err := dl.db.ExecContext(ctx, `SELECT pg_create_logical_replication_slot($1, $2)`, name, plugin); err != nil {
+		return fmt.Errorf("failed to create replication slot: %w", err)
+	}
+	return nil
+}
+
+// DropReplicationSlot drops the named slot so the server can recycle the
+// WAL it retained. Slots in use by a connection cannot be dropped.
+func (dl *DataListener) DropReplicationSlot(ctx context.Context, name string) error {
+	if _, err := dl.db.ExecContext(ctx, `SELECT pg_drop_replication_slot($1)`, name); err != nil {
+		return fmt.Errorf("failed to drop replication slot: %w", err)
+	}
+	return nil
+}
+
+// monitorSlot periodically reports the streamed slot's WAL retention,
+// warning once it exceeds the configured limit.
+func (s *ReplicationSource) monitorSlot(ctx context.Context, done <-chan struct{}) {
+	t := time.NewTicker(s.r.cfg.SlotCheckInterval)
+	defer t.Stop()
+	for {
+		s.checkSlot(ctx)
+		select {
+		case <-t.C:
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *ReplicationSource) checkSlot(ctx context.Context) {
+	r := s.r
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.SlotCheckInterval)
+	defer cancel()
+
+	slot, err := lookupSlot(ctx, s.db, r.cfg.Slot)
+	if err != nil {
+		s.logger.Warn("failed to check replication slot", "slot", r.cfg.Slot, "error", err)
+		return
+	}
+	if slot == nil {
+		return
+	}
+	r.setSlot(slot)
+	s.metrics.ReplicationSlot(slot)
+
+	switch {
+	case slot.WALStatus == "lost":
+		s.logger.Error("replication slot lost its WAL and must be recreated", "slot", slot.Name)
+	case slot.WALStatus == "unreserved":
+		s.logger.Warn("replication slot is about to lose its WAL", "slot", slot.Name, "retained_bytes", slot.RetainedBytes)
+	case r.cfg.MaxRetainedBytes > 0 && slot.RetainedBytes > r.cfg.MaxRetainedBytes:
+		s.logger.Warn("replication slot retains too much WAL",
+			"slot", slot.Name, "retained_bytes", slot.RetainedBytes, "lag_bytes", slot.LagBytes, "limit", r.cfg.MaxRetainedBytes)
+	}
+}
@@ -0,0 +1,234 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultSnapshotTable     = "data_listener_snapshots"
+	DefaultSnapshotBatchSize = 1000
+)
+
+// SnapshotConfig streams the current rows of the listened tables through
+// the handlers, as INSERTs with Snapshot set, before live changes are
+// processed. Progress is saved per consumer after every page, so an
+// interrupted snapshot resumes where it stopped and a finished one is not
+// repeated.
+type SnapshotConfig struct {
+	// Tables defaults to the tables registered by exact name.
+	Tables    []string
+	BatchSize int
+	Table     string
+	Consumer  string
+}
+
+func (c SnapshotConfig) withDefaults() SnapshotConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultSnapshotBatchSize
+	}
+	if c.Table == "" {
+		c.Table = DefaultSnapshotTable
+	}
+	if c.Consumer == "" {
+		c.Consumer = DefaultConsumerName
+	}
+	return c
+}
+
+// snapshot runs the configured snapshot of every channel's tables.
+func (dl *DataListener) snapshot(ctx context.Context) error {
+	cfg := dl.snapshotCfg.withDefaults()
+	_, err := dl.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    consumer TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    last_key JSONB,
+    done BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (consumer, channel, table_name)
+)`, quoteName(cfg.Table)))
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot table %s: %w", cfg.Table, err)
+	}
+
+	for _, channel := range dl.Channels() {
+		set, ok := dl.handlerSet(channel)
+		if !ok {
+			continue
+		}
+		tables := cfg.Tables
+		if len(tables) == 0 {
+			tables = set.Tables()
+		}
+		for _, table := range tables {
+			if _, ok := set.Handler(table); !ok {
+				continue
+			}
+			if err := dl.snapshotTable(ctx, cfg, channel, table); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (dl *DataListener) snapshotTable(ctx context.Context, cfg SnapshotConfig, channel, table string) error {
+	schema, name := DefaultSchema, table
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		schema, name = table[:i], table[i+1:]
+	}
+	qualified := schema + "." + name
+
+	var lastKey []byte
+	var done bool
+	err := dl.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT last_key, done FROM %s WHERE consumer = $1 AND channel = $2 AND table_name = $3", quoteName(cfg.Table)),
+		cfg.Consumer, channel, qualified).Scan(&lastKey, &done)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return fmt.Errorf("failed to load snapshot progress for %s: %w", qualified, err)
+	case done:
+		return nil
+	}
+
+	keys, err := dl.primaryKey(ctx, qualified)
+	if err != nil {
+		return err
+	}
+
+	page := dl.snapshotQuery(qualified, keys)
+	rows := 0
+	start := time.Now()
+	dl.logger.Info("starting snapshot", "channel", channel, "table", qualified)
+	for {
+		select {
+		case <-dl.stop:
+			return errStopped
+		default:
+		}
+
+		batch, err := dl.snapshotPage(ctx, page, lastKey, cfg.BatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", qualified, err)
+		}
+		for _, n := range batch {
+			n.Channel, n.Schema, n.Table = channel, schema, name
+			dl.fillTenant(n)
+			dl.metrics.NotificationReceived(n)
+			dl.tenantReceived(n)
+			ctx, span := dl.startNotificationSpan(ctx, "snapshot "+channel, channel)
+			setNotificationAttributes(span, n)
+			if err := dl.enqueue(ctx, n); err != nil {
+				return err
+			}
+		}
+		// Only rows that were processed count as progress.
+		dl.inflight.Wait()
+
+		finished := len(batch) < cfg.BatchSize
+		if len(batch) > 0 {
+			lastKey = batch[len(batch)-1].Key
+		}
+		rows += len(batch)
+		var saved any
+		if lastKey != nil {
+			saved = lastKey
+		}
+		_, err = dl.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (consumer, channel, table_name, last_key, done, updated_at)
+VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+ON CONFLICT (consumer, channel, table_name) DO UPDATE
+SET last_key = EXCLUDED.last_key, done = EXCLUDED.done, updated_at = EXCLUDED.updated_at`, quoteName(cfg.Table)),
+			cfg.Consumer, channel, qualified, saved, finished)
+		if err != nil {
+			return fmt.Errorf("failed to save snapshot progress for %s: %w", qualified, err)
+		}
+		if finished {
+			break
+		}
+	}
+	dl.logger.Info("finished snapshot", "channel", channel, "table", qualified, "rows", rows, "duration", time.Since(start))
+	return nil
+}
+
+// snapshotQuery builds the keyset pagination query of a table: rows after
+// the key given as JSON in $1, or from the start when it is NULL.
+func (dl *DataListener) snapshotQuery(table string, keys []string) string {
+	cols := make([]string, len(keys))
+	after := make([]string, len(keys))
+	build := make([]string, len(keys))
+	for i, k := range keys {
+		q := pq.QuoteIdentifier(k)
+		cols[i] = "t." + q
+		after[i] = "k." + q
+		build[i] = pq.QuoteLiteral(k) + ", t." + q
+	}
+	quoted := quoteName(table)
+	return fmt.Sprintf(`SELECT row_to_json(t), json_build_object(%[2]s)
+FROM %[1]s AS t
+WHERE $1::jsonb IS NULL OR (%[3]s) > (SELECT %[4]s FROM jsonb_populate_record(NULL::%[1]s, $1::jsonb) AS k)
+ORDER BY %[3]s
+LIMIT $2`, quoted, strings.Join(build, ", "), strings.Join(cols, ", "), strings.Join(after, ", "))
+}
+
+func (dl *DataListener) snapshotPage(ctx context.Context, query string, after []byte, limit int) ([]*ChangeNotification, error) {
+	var from any
+	if after != nil {
+		from = after
+	}
+	rows, err := dl.db.QueryContext(ctx, query, from, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var batch []*ChangeNotification
+	for rows.Next() {
+		n := &ChangeNotification{Operation: OpInsert, Timestamp: now, Snapshot: true}
+		var data, key []byte
+		if err := rows.Scan(&data, &key); err != nil {
+			return nil, err
+		}
+		n.Data, n.Key = data, key
+		n.fillImages()
+		batch = append(batch, n)
+	}
+	return batch, rows.Err()
+}
+
+// primaryKey returns the primary key columns of a table in index order.
+func (dl *DataListener) primaryKey(ctx context.Context, table string) ([]string, error) {
+	rows, err := dl.db.QueryContext(ctx, `SELECT a.attname
+FROM pg_index i
+JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+WHERE i.indrelid = $1::regclass AND i.indisprimary
+ORDER BY array_position(i.indkey::int2[], a.attnum)`, quoteName(table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up primary key of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("cannot snapshot %s: table has no primary key", table)
+	}
+	return keys, nil
+}
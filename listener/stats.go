@@ -0,0 +1,115 @@
+package listener
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HandlerStats describes a handler registration and what it has processed
+// since it was registered.
+type HandlerStats struct {
+	// Name is the table name, glob pattern, regular expression source or
+	// CatchAll the handler is registered for.
+	Name        string    `json:"name"`
+	Delivered   uint64    `json:"delivered"`
+	Failed      uint64    `json:"failed"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure"`
+	// Disabled is set while the panic breaker keeps the handler disabled.
+	Disabled bool `json:"disabled,omitempty"`
+	// Circuit is the circuit breaker state, closed, open or half-open, or
+	// empty without a breaker.
+	Circuit string `json:"circuit,omitempty"`
+	// Held counts the notifications held back by the circuit breaker, the
+	// rate limiter and debouncing.
+	Held int `json:"held"`
+}
+
+// handlerStats counts the outcomes of a registration.
+type handlerStats struct {
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+
+	mu          sync.Mutex
+	lastError   string
+	lastFailure time.Time
+}
+
+func (s *handlerStats) record(failure *Failure) {
+	if failure == nil {
+		s.delivered.Add(1)
+		return
+	}
+	s.failed.Add(1)
+	s.mu.Lock()
+	s.lastError = failure.Err.Error()
+	s.lastFailure = failure.LastAttempt
+	s.mu.Unlock()
+}
+
+// Stats returns the stats of every registration in the set, by name.
+func (hs *HandlerSet) Stats() []HandlerStats {
+	regs := hs.named()
+	stats := make([]HandlerStats, 0, len(regs))
+	now := time.Now()
+	for name, reg := range regs {
+		stats = append(stats, reg.snapshot(name, now))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// named returns every registration of the set by the name it was
+// registered under.
+func (hs *HandlerSet) named() map[string]*registration {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	regs := make(map[string]*registration, len(hs.handlers)+len(hs.globs)+len(hs.regexps)+1)
+	for name, reg := range hs.handlers {
+		regs[name] = reg
+	}
+	for _, p := range hs.globs {
+		regs[p.source] = p.reg
+	}
+	for _, p := range hs.regexps {
+		regs[p.source] = p.reg
+	}
+	if hs.catchAll != nil {
+		regs[CatchAll] = hs.catchAll
+	}
+	return regs
+}
+
+func (r *registration) snapshot(name string, now time.Time) HandlerStats {
+	st := HandlerStats{
+		Name:      name,
+		Delivered: r.stats.delivered.Load(),
+		Failed:    r.stats.failed.Load(),
+		Disabled:  r.panics.disabled(now),
+	}
+	r.stats.mu.Lock()
+	st.LastError = r.stats.lastError
+	st.LastFailure = r.stats.lastFailure
+	r.stats.mu.Unlock()
+
+	if c := r.circuit; c != nil {
+		c.mu.Lock()
+		st.Circuit = c.state.String()
+		st.Held += len(c.held)
+		c.mu.Unlock()
+	}
+	if l := r.limiter; l != nil {
+		l.mu.Lock()
+		st.Held += len(l.order)
+		l.mu.Unlock()
+	}
+	if d := r.debouncer; d != nil {
+		d.mu.Lock()
+		st.Held += len(d.pending)
+		d.mu.Unlock()
+	}
+	return st
+}
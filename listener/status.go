@@ -0,0 +1,75 @@
+package listener
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Status is a snapshot of the listener's connection and processing state.
+type Status struct {
+	Running          bool      `json:"running"`
+	Connected        bool      `json:"connected"`
+	LastPing         time.Time `json:"last_ping"`
+	LastNotification time.Time `json:"last_notification"`
+	QueueDepth       int       `json:"queue_depth"`
+	QueueCapacity    int       `json:"queue_capacity"`
+	Channels         []string  `json:"channels"`
+	// Paused is set between Pause and Resume; Held counts the
+	// notifications received meanwhile.
+	Paused bool `json:"paused"`
+	Held   int  `json:"held"`
+	// Slot is the last check of the replication slot in replication mode.
+	Slot *SlotInfo `json:"slot,omitempty"`
+	// Standby is set while Start waits for the leader lock, see
+	// WithLeaderElection.
+	Standby bool `json:"standby,omitempty"`
+	// Shards lists the shards the listener processes, see WithSharding.
+	Shards []int `json:"shards,omitempty"`
+}
+
+type connState struct {
+	lastPing         atomic.Int64
+	lastNotification atomic.Int64
+}
+
+func (cs *connState) pinged() {
+	cs.lastPing.Store(time.Now().UnixNano())
+}
+
+func (cs *connState) notified() {
+	cs.lastNotification.Store(time.Now().UnixNano())
+}
+
+func unixTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (dl *DataListener) Status() Status {
+	dl.mu.Lock()
+	src := dl.source
+	running := dl.running && src != nil
+	dl.mu.Unlock()
+
+	var slot *SlotInfo
+	if s, ok := dl.capture.(interface{ Slot() *SlotInfo }); ok {
+		slot = s.Slot()
+	}
+	paused, held := dl.Paused()
+	return Status{
+		Running:          running,
+		Connected:        running && dl.sourceConnected(src),
+		LastPing:         unixTime(dl.conn.lastPing.Load()),
+		LastNotification: unixTime(dl.conn.lastNotification.Load()),
+		QueueDepth:       dl.QueueDepth(),
+		QueueCapacity:    dl.QueueCapacity(),
+		Channels:         dl.Channels(),
+		Slot:             slot,
+		Paused:           paused,
+		Held:             held,
+		Standby:          dl.leader != nil && dl.leader.standby.Load(),
+		Shards:           dl.shards.claimed(),
+	}
+}
@@ -0,0 +1,133 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Supervisor runs the listeners of several databases in one process. Each
+// listener keeps its own connection, channels and handlers; the supervisor
+// starts them together and stops them together, so a listener failing
+// stops the others instead of leaving the process half running.
+type Supervisor struct {
+	mu        sync.Mutex
+	names     []string
+	listeners map[string]*DataListener
+	running   bool
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{listeners: make(map[string]*DataListener)}
+}
+
+// Add registers dl under name, before Start.
+func (s *Supervisor) Add(name string, dl *DataListener) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrAlreadyStarted
+	}
+	if _, ok := s.listeners[name]; ok {
+		return fmt.Errorf("listener %s already added", name)
+	}
+	s.listeners[name] = dl
+	s.names = append(s.names, name)
+	return nil
+}
+
+// Listener returns the listener added under name.
+func (s *Supervisor) Listener(name string) (*DataListener, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dl, ok := s.listeners[name]
+	return dl, ok
+}
+
+// Names returns the names of the listeners in the order they were added.
+func (s *Supervisor) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.names)
+}
+
+// Start runs every listener until ctx is canceled, Shutdown is called or
+// one of them fails, in which case the others are shut down and drained
+// too. The errors are returned with the listeners' names.
+func (s *Supervisor) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return ErrAlreadyStarted
+	}
+	s.running = true
+	names, listeners := s.snapshot()
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		dl := listeners[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := dl.Start(ctx); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", name, err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Shutdown stops every listener and waits for them to drain, giving up
+// when ctx is done.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	return s.each(func(dl *DataListener) error { return dl.Shutdown(ctx) })
+}
+
+// Status returns the status of every listener by name.
+func (s *Supervisor) Status() map[string]Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := make(map[string]Status, len(s.listeners))
+	for name, dl := range s.listeners {
+		st[name] = dl.Status()
+	}
+	return st
+}
+
+// Close closes the query connection pools.
+func (s *Supervisor) Close() error {
+	return s.each((*DataListener).Close)
+}
+
+func (s *Supervisor) each(fn func(*DataListener) error) error {
+	s.mu.Lock()
+	names, listeners := s.snapshot()
+	s.mu.Unlock()
+
+	var errs []error
+	for i, name := range names {
+		if err := fn(listeners[i]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// snapshot returns the names and listeners in order. It is called with
+// s.mu held.
+func (s *Supervisor) snapshot() ([]string, []*DataListener) {
+	listeners := make([]*DataListener, len(s.names))
+	for i, name := range s.names {
+		listeners[i] = s.listeners[name]
+	}
+	return slices.Clone(s.names), listeners
+}
@@ -0,0 +1,167 @@
+package listener
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// tail keeps the position of a consumer in a table whose ids are drawn from
+// a sequence, like the outbox. Transactions draw ids in one order and
+// commit in another, so an event may become visible after events with
+// higher ids; the checkpoint therefore only moves past ids that completed
+// and up to the settled frontier, below which no transaction can commit ids
+// anymore.
+type tail struct {
+	db          *sql.DB
+	table       string
+	idColumn    string
+	checkpoints CheckpointStore
+	consumer    string
+	// sequence is the sequence the ids are drawn from.
+	sequence string
+
+	mu sync.Mutex
+	// committed is the persisted checkpoint.
+	committed int64
+	progress  *watermark
+	// delivered holds the ids after committed that were delivered, so they
+	// are not delivered again.
+	delivered idSet
+	// settled is the id up to which every id drawn is either visible or
+	// rolled back; probe is the candidate for the next one.
+	settled int64
+	probe   *tailProbe
+}
+
+// tailProbe is a position of the sequence, with the end of the transaction
+// ids that may have drawn ids up to it.
+type tailProbe struct {
+	lastID int64
+	xmax   int64
+}
+
+func newTail(db *sql.DB, table, idColumn string, checkpoints CheckpointStore, consumer string) *tail {
+	return &tail{
+		db:          db,
+		table:       table,
+		idColumn:    idColumn,
+		checkpoints: checkpoints,
+		consumer:    consumer,
+		progress:    newWatermark(),
+	}
+}
+
+// init finds the sequence and loads the checkpoint.
+func (t *tail) init(ctx context.Context) error {
+	if init, ok := t.checkpoints.(interface{ Init(context.Context) error }); ok {
+		if err := init.Init(ctx); err != nil {
+			return err
+		}
+	}
+
+	var sequence sql.NullString
+	if err := t.db.QueryRowContext(ctx, `SELECT pg_get_serial_sequence($1, $2)`, quoteName(t.table), t.idColumn).Scan(&sequence); err != nil {
+		return fmt.Errorf("failed to find the id sequence of %s: %w", t.table, err)
+	}
+	if !sequence.Valid {
+		return fmt.Errorf("%s.%s is not drawn from a sequence", t.table, t.idColumn)
+	}
+	t.sequence = sequence.String
+
+	committed, err := t.checkpoints.Load(ctx, t.consumer)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.committed = committed
+	t.settled = committed
+	t.mu.Unlock()
+	return nil
+}
+
+// checkpoint returns the persisted checkpoint.
+func (t *tail) checkpoint() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.committed
+}
+
+// frontier returns the settled frontier so far and the candidate for the
+// next one, the id up to which no transaction can still commit ids now. It
+// starts a new probe once the last one settled.
+//
+// A probe reads the sequence, then the running transactions: every id up
+// to the sequence's value was drawn by a transaction that started before
+// the snapshot, as the trigger writing the row has a transaction id
+// already, and once the oldest running transaction is newer than the
+// snapshot's xmax none of them can commit more ids up to it. A long
+// running transaction anywhere in the cluster holds the frontier back.
+func (t *tail) frontier(ctx context.Context) (settled, next int64, err error) {
+	var lastID, xmin, xmax int64
+	if err := t.db.QueryRowContext(ctx, `SELECT COALESCE(pg_sequence_last_value($1::regclass), 0)`, t.sequence).Scan(&lastID); err != nil {
+		return 0, 0, fmt.Errorf("failed to read sequence %s: %w", t.sequence, err)
+	}
+	if err := t.db.QueryRowContext(ctx, `SELECT txid_snapshot_xmin(s), txid_snapshot_xmax(s) FROM txid_current_snapshot() s`).Scan(&xmin, &xmax); err != nil {
+		return 0, 0, fmt.Errorf("failed to read transaction snapshot: %w", err)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	next = t.settled
+	if p := t.probe; p != nil && xmin >= p.xmax {
+		next = max(next, p.lastID)
+		t.probe = nil
+	}
+	if t.probe == nil {
+		t.probe = &tailProbe{lastID: lastID, xmax: xmax}
+	}
+	return t.settled, next, nil
+}
+
+// seen reports whether an id was delivered already.
+func (t *tail) seen(id int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return id <= t.committed || t.delivered.contains(id)
+}
+
+// track records an id that is about to be processed.
+func (t *tail) track(id int64) {
+	t.progress.track(id)
+	t.mu.Lock()
+	t.delivered.add(id)
+	t.mu.Unlock()
+}
+
+// complete records a processed id and persists the high-water mark once
+// every earlier tracked id has completed too.
+func (t *tail) complete(ctx context.Context, id int64) error {
+	return t.advance(ctx, t.progress.complete(id))
+}
+
+// settle moves the settled frontier once every id up to it was delivered,
+// and the checkpoint with it as far as processing allows.
+func (t *tail) settle(ctx context.Context, settled int64) error {
+	t.mu.Lock()
+	t.settled = max(t.settled, settled)
+	t.mu.Unlock()
+	return t.advance(ctx, t.progress.mark())
+}
+
+// advance persists mark, capped by the settled frontier, if it moves the
+// checkpoint.
+func (t *tail) advance(ctx context.Context, mark int64) error {
+	t.mu.Lock()
+	mark = min(mark, t.settled)
+	if mark <= t.committed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.committed = mark
+	t.delivered.prune(mark)
+	t.mu.Unlock()
+
+	return t.checkpoints.Save(ctx, t.consumer, mark)
+}
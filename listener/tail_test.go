@@ -0,0 +1,270 @@
+package listener
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB answers the queries of a tail and of a PollingSource from its
+// fields.
+type fakeDB struct {
+	mu         sync.Mutex
+	lastID     int64
+	xmin, xmax int64
+	// outbox are the visible rows of the outbox.
+	outbox []Event
+}
+
+func (f *fakeDB) set(lastID, xmin, xmax int64) {
+	f.mu.Lock()
+	f.lastID, f.xmin, f.xmax = lastID, xmin, xmax
+	f.mu.Unlock()
+}
+
+// commit makes rows of the outbox visible.
+func (f *fakeDB) commit(rows ...Event) {
+	f.mu.Lock()
+	f.outbox = append(f.outbox, rows...)
+	f.mu.Unlock()
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	f := c.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.Contains(query, "pg_sequence_last_value"):
+		return &fakeRows{cols: []string{"last_value"}, rows: [][]driver.Value{{f.lastID}}}, nil
+	case strings.Contains(query, "txid_current_snapshot"):
+		return &fakeRows{cols: []string{"xmin", "xmax"}, rows: [][]driver.Value{{f.xmin, f.xmax}}}, nil
+	case strings.Contains(query, "ORDER BY"):
+		// The scan of a PollingSource: after, up to, channels and limit.
+		after, upTo, limit := args[0].Value.(int64), args[1].Value.(int64), args[3].Value.(int64)
+		channels := strings.Split(strings.Trim(args[2].Value.(string), "{}"), ",")
+		rows := &fakeRows{cols: []string{"id", "channel", "payload"}}
+		for _, ev := range slices.SortedFunc(slices.Values(f.outbox), func(a, b Event) int { return cmp.Compare(a.ID, b.ID) }) {
+			if ev.ID > after && ev.ID <= upTo && slices.Contains(channels, strconv.Quote(ev.Channel)) && len(rows.rows) < int(limit) {
+				rows.rows = append(rows.rows, []driver.Value{ev.ID, ev.Channel, ev.Payload})
+			}
+		}
+		return rows, nil
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// memoryCheckpoints is a CheckpointStore in memory.
+type memoryCheckpoints struct {
+	mu sync.Mutex
+	id int64
+}
+
+func (m *memoryCheckpoints) Load(context.Context, string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.id, nil
+}
+
+func (m *memoryCheckpoints) Save(_ context.Context, _ string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.id = id
+	return nil
+}
+
+func TestTailFrontier(t *testing.T) {
+	type poll struct {
+		lastID, xmin, xmax int64
+		settled, next      int64
+	}
+	tests := []struct {
+		name  string
+		polls []poll
+	}{
+		{
+			name: "settles once older transactions end",
+			polls: []poll{
+				{lastID: 10, xmin: 100, xmax: 105, settled: 0, next: 0},
+				{lastID: 12, xmin: 103, xmax: 108, settled: 0, next: 0},
+				{lastID: 15, xmin: 105, xmax: 110, settled: 0, next: 10},
+				{lastID: 15, xmin: 110, xmax: 111, settled: 10, next: 15},
+			},
+		},
+		{
+			name: "long running transaction holds it back",
+			polls: []poll{
+				{lastID: 10, xmin: 50, xmax: 60, settled: 0, next: 0},
+				{lastID: 20, xmin: 50, xmax: 70, settled: 0, next: 0},
+				{lastID: 30, xmin: 50, xmax: 80, settled: 0, next: 0},
+				{lastID: 30, xmin: 81, xmax: 81, settled: 0, next: 10},
+				{lastID: 30, xmin: 81, xmax: 81, settled: 10, next: 30},
+			},
+		},
+		{
+			name: "does not move back",
+			polls: []poll{
+				{lastID: 10, xmin: 1, xmax: 2, settled: 0, next: 0},
+				{lastID: 5, xmin: 2, xmax: 3, settled: 0, next: 10},
+				{lastID: 5, xmin: 3, xmax: 4, settled: 10, next: 10},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fdb := &fakeDB{}
+			db := sql.OpenDB(fdb)
+			defer db.Close()
+			tl := newTail(db, "outbox", "id", &memoryCheckpoints{}, "test")
+			tl.sequence = "outbox_id_seq"
+
+			for i, p := range tt.polls {
+				fdb.set(p.lastID, p.xmin, p.xmax)
+				settled, next, err := tl.frontier(ctx)
+				if err != nil {
+					t.Fatalf("poll %d: %v", i, err)
+				}
+				if settled != p.settled || next != p.next {
+					t.Fatalf("poll %d: frontier = %d, %d, want %d, %d", i, settled, next, p.settled, p.next)
+				}
+				if err := tl.settle(ctx, next); err != nil {
+					t.Fatalf("poll %d: settle: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTailCheckpoint(t *testing.T) {
+	type step struct {
+		track    int64
+		complete int64
+		settle   int64
+		// committed is the checkpoint after the step.
+		committed int64
+	}
+	tests := []struct {
+		name  string
+		start int64
+		steps []step
+		// seen and unseen are checked after the steps.
+		seen, unseen []int64
+	}{
+		{
+			name: "waits for the settled frontier",
+			steps: []step{
+				{track: 1},
+				{track: 2},
+				{complete: 1},
+				{complete: 2},
+				{settle: 1, committed: 1},
+				{settle: 5, committed: 2},
+			},
+			seen:   []int64{1, 2},
+			unseen: []int64{3},
+		},
+		{
+			name: "waits for earlier ids",
+			steps: []step{
+				{settle: 10},
+				{track: 3},
+				{track: 4},
+				{complete: 4, committed: 2},
+				{complete: 3, committed: 4},
+			},
+			seen:   []int64{3, 4},
+			unseen: []int64{5},
+		},
+		{
+			name:  "remembers ids delivered past the checkpoint",
+			start: 2,
+			steps: []step{
+				{settle: 3, committed: 2},
+				{track: 5, committed: 2},
+				{complete: 5, committed: 3},
+			},
+			seen:   []int64{1, 3, 5},
+			unseen: []int64{4, 6},
+		},
+		{
+			name:  "settling releases the remembered ids",
+			start: 2,
+			steps: []step{
+				{settle: 3, committed: 2},
+				{track: 5, committed: 2},
+				{complete: 5, committed: 3},
+				{settle: 10, committed: 5},
+			},
+			seen:   []int64{5},
+			unseen: []int64{6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			tl := newTail(nil, "outbox", "id", &memoryCheckpoints{}, "test")
+			tl.committed, tl.settled = tt.start, tt.start
+			for i, s := range tt.steps {
+				var err error
+				switch {
+				case s.track != 0:
+					tl.track(s.track)
+				case s.complete != 0:
+					err = tl.complete(ctx, s.complete)
+				default:
+					err = tl.settle(ctx, s.settle)
+				}
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if got := tl.checkpoint(); got != s.committed {
+					t.Fatalf("step %d: checkpoint = %d, want %d", i, got, s.committed)
+				}
+			}
+			for _, id := range tt.seen {
+				if !tl.seen(id) {
+					t.Errorf("seen(%d) = false", id)
+				}
+			}
+			for _, id := range tt.unseen {
+				if tl.seen(id) {
+					t.Errorf("seen(%d) = true", id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package listener
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// TenantMetrics is implemented by Metrics that also count by tenant. The
+// listener reports the notifications carrying a tenant through it in
+// addition to the Metrics events.
+type TenantMetrics interface {
+	TenantReceived(n *ChangeNotification)
+	TenantHandled(n *ChangeNotification, err error)
+	TenantDropped(n *ChangeNotification, reason string)
+}
+
+// WithTenants serves a multi-tenant database: the tenant of each change is
+// read from the tenant field of the payload or, when the trigger did not
+// set it, from column of the row. Tenants can get their own handlers with
+// SetTenantHandlers and their own rate limits with RateLimit.PerTenant.
+func WithTenants(column string) Option {
+	return func(dl *DataListener) {
+		dl.tenantColumn = column
+	}
+}
+
+// WithTenantHandlers routes the notifications of tenant to set, see
+// SetTenantHandlers.
+func WithTenantHandlers(tenant string, set *HandlerSet) Option {
+	return func(dl *DataListener) {
+		dl.tenants[tenant] = set
+	}
+}
+
+// SetTenantHandlers routes the notifications of tenant to set, whatever
+// their channel. Tables without a handler in set fall back to the handler
+// set of the channel. It may be called while Start is running.
+func (dl *DataListener) SetTenantHandlers(tenant string, set *HandlerSet) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	dl.tenants[tenant] = set
+}
+
+// RemoveTenantHandlers routes the notifications of tenant by channel again.
+func (dl *DataListener) RemoveTenantHandlers(tenant string) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	delete(dl.tenants, tenant)
+}
+
+// Tenants returns the sorted tenants with their own handler set.
+func (dl *DataListener) Tenants() []string {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return slices.Sorted(maps.Keys(dl.tenants))
+}
+
+// fillTenant sets the tenant of n from the tenant column of its row.
+func (dl *DataListener) fillTenant(n *ChangeNotification) {
+	if n.Tenant != "" || dl.tenantColumn == "" {
+		return
+	}
+	for _, image := range []json.RawMessage{n.New, n.Old, n.Key} {
+		if isNull(image) {
+			continue
+		}
+		var row map[string]json.RawMessage
+		if json.Unmarshal(image, &row) != nil {
+			continue
+		}
+		if v, ok := row[dl.tenantColumn]; ok && !isNull(v) {
+			n.Tenant = tenantID(v)
+			return
+		}
+	}
+}
+
+// tenantID returns a tenant column value as a string, so text, numeric and
+// uuid ids all work.
+func tenantID(v json.RawMessage) string {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(v, &n) == nil {
+		return n.String()
+	}
+	return strconv.Quote(string(v))
+}
+
+// tenantRegistration returns the registration of the tenant's own handler
+// set for n, if it has one.
+func (dl *DataListener) tenantRegistration(n *ChangeNotification) (*registration, bool) {
+	if n.Tenant == "" {
+		return nil, false
+	}
+	dl.mu.Lock()
+	set, ok := dl.tenants[n.Tenant]
+	dl.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	return set.registration(n)
+}
+
+func (dl *DataListener) tenantReceived(n *ChangeNotification) {
+	if m, ok := dl.metrics.(TenantMetrics); ok && n.Tenant != "" {
+		m.TenantReceived(n)
+	}
+}
+
+func (dl *DataListener) tenantHandled(n *ChangeNotification, err error) {
+	if m, ok := dl.metrics.(TenantMetrics); ok && n.Tenant != "" {
+		m.TenantHandled(n, err)
+	}
+}
+
+func (dl *DataListener) tenantDropped(n *ChangeNotification, reason string) {
+	if m, ok := dl.metrics.(TenantMetrics); ok && n.Tenant != "" {
+		m.TenantDropped(n, reason)
+	}
+}
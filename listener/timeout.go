@@ -0,0 +1,38 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrHandlerTimeout fails a handler call that exceeded its timeout. It is
+// retried like any handler error.
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// callWithTimeout runs fn with a context canceled after d, recovering
+// panics. A handler that ignores the cancellation keeps running in the
+// background, but the listener stops waiting for it. d <= 0 disables the
+// timeout.
+func callWithTimeout(ctx context.Context, d time.Duration, fn func(context.Context) error) error {
+	if d <= 0 {
+		return recoverCall(func() error { return fn(ctx) })
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- recoverCall(func() error { return fn(ctx) })
+	}()
+	select {
+	case err := <-done:
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %s: %w", ErrHandlerTimeout, d, err)
+		}
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w after %s", ErrHandlerTimeout, d)
+	}
+}
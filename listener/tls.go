@@ -0,0 +1,237 @@
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	SSLModeRequire    = "require"
+	SSLModeVerifyCA   = "verify-ca"
+	SSLModeVerifyFull = "verify-full"
+)
+
+// TLSConfig configures TLS for the database connections, replacing the ssl
+// settings of the connection strings; see WithTLS.
+type TLSConfig struct {
+	// Mode is SSLModeRequire, SSLModeVerifyCA or SSLModeVerifyFull, the
+	// default. As with libpq, require also verifies the server certificate
+	// when RootCert is set.
+	Mode string
+	// RootCert, Cert and Key hold PEM data or the path of a PEM file.
+	// RootCert defaults to the system roots; Cert and Key authenticate the
+	// client with a certificate.
+	RootCert string
+	Cert     string
+	Key      string
+	// ServerName is verified in verify-full mode instead of the host
+	// connected to, e.g. when connecting through an IP address or tunnel.
+	ServerName string
+}
+
+// WithTLS secures the query pool, the LISTEN connection and the
+// replication stream with cfg. The files it names are read by New.
+func WithTLS(cfg TLSConfig) Option {
+	return func(dl *DataListener) {
+		dl.tlsCfg = &cfg
+	}
+}
+
+// clientTLS is a loaded TLSConfig.
+type clientTLS struct {
+	config *tls.Config
+}
+
+func (c TLSConfig) build() (*clientTLS, error) {
+	if c.Mode == "" {
+		c.Mode = SSLModeVerifyFull
+	}
+	config := &tls.Config{ServerName: c.ServerName}
+
+	var roots *x509.CertPool
+	if c.RootCert != "" {
+		data, err := readPEM(c.RootCert)
+		if err != nil {
+			return nil, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates found in root cert")
+		}
+	}
+	if c.Cert != "" || c.Key != "" {
+		if c.Cert == "" || c.Key == "" {
+			return nil, errors.New("both cert and key are required")
+		}
+		cert, err := readPEM(c.Cert)
+		if err != nil {
+			return nil, err
+		}
+		key, err := readPEM(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	switch c.Mode {
+	case SSLModeVerifyFull:
+		config.RootCAs = roots
+	case SSLModeRequire, SSLModeVerifyCA:
+		// The chain is verified without the host name, which crypto/tls
+		// only supports through a custom verification.
+		config.InsecureSkipVerify = true
+		if c.Mode == SSLModeRequire && roots == nil {
+			break
+		}
+		config.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(raw, roots)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sslmode %q", c.Mode)
+	}
+	return &clientTLS{config: config}, nil
+}
+
+// readPEM returns s if it holds PEM data, or else the contents of the file
+// it names.
+func readPEM(s string) ([]byte, error) {
+	if strings.Contains(s, "-----BEGIN") {
+		return []byte(s), nil
+	}
+	data, err := os.ReadFile(s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", s, err)
+	}
+	return data, nil
+}
+
+// verifyChain verifies the server certificate chain against roots, the
+// system roots when nil.
+func verifyChain(raw [][]byte, roots *x509.CertPool) error {
+	if len(raw) == 0 {
+		return errors.New("server sent no certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse server certificate: %w", err)
+		}
+		certs[i] = cert
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// forHost returns the TLS config of a connection to host.
+func (t *clientTLS) forHost(host string) *tls.Config {
+	config := t.config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// configure replaces the TLS settings cfg was parsed with. Unix sockets
+// stay unencrypted, as with libpq.
+func (t *clientTLS) configure(cfg *pgconn.Config) {
+	if t == nil {
+		return
+	}
+	hostTLS := func(host string) *tls.Config {
+		if strings.HasPrefix(host, "/") {
+			return nil
+		}
+		return t.forHost(host)
+	}
+	cfg.TLSConfig = hostTLS(cfg.Host)
+	// The fallbacks also hold the plaintext attempts of sslmode prefer and
+	// allow; only one attempt per host remains.
+	seen := map[string]bool{net.JoinHostPort(cfg.Host, fmt.Sprint(cfg.Port)): true}
+	var fallbacks []*pgconn.FallbackConfig
+	for _, fb := range cfg.Fallbacks {
+		addr := net.JoinHostPort(fb.Host, fmt.Sprint(fb.Port))
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		fb.TLSConfig = hostTLS(fb.Host)
+		fallbacks = append(fallbacks, fb)
+	}
+	cfg.Fallbacks = fallbacks
+}
+
+// tlsDialer connects lib/pq over TLS, negotiated with an SSLRequest as
+// the server expects.
+type tlsDialer struct {
+	tls *clientTLS
+}
+
+// sslRequest is the SSLRequest message: its length and request code.
+var sslRequest = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), 80877103)
+
+func (d tlsDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d tlsDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
+
+func (d tlsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil || network == "unix" {
+		return conn, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(sslRequest); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if reply[0] != 'S' {
+		conn.Close()
+		return nil, errors.New("server does not support TLS")
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	tlsConn := tls.Client(conn, d.tls.forHost(host))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,59 @@
+package listener
+
+import (
+	"context"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/force-c/pg-data-listener/listener"
+
+var (
+	attrChannel   = attribute.Key("messaging.destination.name")
+	attrTable     = attribute.Key("db.sql.table")
+	attrOperation = attribute.Key("db.operation.name")
+	attrEventID   = attribute.Key("messaging.message.id")
+	attrAttempt   = attribute.Key("pg_data_listener.attempt")
+	attrTenant    = attribute.Key("pg_data_listener.tenant")
+)
+
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+// startNotificationSpan starts the span covering a notification from receipt
+// to the end of processing. process ends it.
+func (dl *DataListener) startNotificationSpan(ctx context.Context, name, channel string) (context.Context, trace.Span) {
+	return dl.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "postgresql"),
+			attrChannel.String(channel),
+		))
+}
+
+func setNotificationAttributes(span trace.Span, n *ChangeNotification) {
+	span.SetAttributes(attrTable.String(n.QualifiedTable()), attrOperation.String(n.Operation))
+	if n.ID != 0 {
+		span.SetAttributes(attrEventID.String(strconv.FormatInt(n.ID, 10)))
+	}
+	if n.Tenant != "" {
+		span.SetAttributes(attrTenant.String(n.Tenant))
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,238 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const DefaultTransactionTimeout = time.Minute
+
+// Transaction holds all changes a database transaction made on one
+// channel, in the order they were made.
+type Transaction struct {
+	TxID    int64
+	Channel string
+	Changes []*ChangeNotification
+}
+
+// TransactionHandler receives the changes of each transaction together, so
+// they can be applied atomically. Changes from triggers installed with
+// trigger.WithTransactions are buffered until their commit marker and are
+// not passed to the table handlers; changes without a transaction id, such
+// as snapshot rows, are dispatched as usual.
+type TransactionHandler interface {
+	HandleTransaction(ctx context.Context, tx *Transaction) error
+}
+
+type TransactionHandlerFunc func(ctx context.Context, tx *Transaction) error
+
+func (f TransactionHandlerFunc) HandleTransaction(ctx context.Context, tx *Transaction) error {
+	return f(ctx, tx)
+}
+
+type txKey struct {
+	channel string
+	txid    int64
+}
+
+type pendingTx struct {
+	tx      *Transaction
+	started time.Time
+}
+
+// txBuffer collects the changes of open transactions. It is only used from
+// the receive loop.
+type txBuffer struct {
+	timeout time.Duration
+	pending map[txKey]*pendingTx
+}
+
+func newTxBuffer(timeout time.Duration) *txBuffer {
+	return &txBuffer{timeout: timeout, pending: make(map[txKey]*pendingTx)}
+}
+
+func (b *txBuffer) add(n *ChangeNotification) {
+	key := txKey{n.Channel, n.TxID}
+	p, ok := b.pending[key]
+	if !ok {
+		p = &pendingTx{tx: &Transaction{TxID: n.TxID, Channel: n.Channel}, started: time.Now()}
+		b.pending[key] = p
+	}
+	p.tx.Changes = append(p.tx.Changes, n)
+}
+
+// commit removes and returns the buffered transaction, or nil when none of
+// its changes were buffered.
+func (b *txBuffer) commit(channel string, txid int64) *Transaction {
+	key := txKey{channel, txid}
+	p, ok := b.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(b.pending, key)
+	return p.tx
+}
+
+func (b *txBuffer) reset() {
+	clear(b.pending)
+}
+
+// expire removes and returns transactions still waiting for their commit
+// marker after the timeout.
+func (b *txBuffer) expire(now time.Time) []*Transaction {
+	var expired []*Transaction
+	for key, p := range b.pending {
+		if now.Sub(p.started) > b.timeout {
+			delete(b.pending, key)
+			expired = append(expired, p.tx)
+		}
+	}
+	return expired
+}
+
+// group buffers a change that belongs to a transaction and, on its commit
+// marker, enqueues the transaction. It reports whether it consumed n; the
+// caller has already tracked n's event id.
+func (dl *DataListener) group(ctx context.Context, n *ChangeNotification) (bool, error) {
+	span := trace.SpanFromContext(ctx)
+	if n.Commit {
+		var tx *Transaction
+		if dl.transactions != nil {
+			tx = dl.txs.commit(n.Channel, n.TxID)
+		}
+		if tx == nil {
+			span.End()
+			if n.ID != 0 {
+				dl.complete(ctx, n.ID)
+			}
+			return true, nil
+		}
+		if n.Changes != 0 && n.Changes != len(tx.Changes) {
+			dl.logger.Warn("transaction incomplete", "channel", n.Channel, "txid", n.TxID,
+				"changes", n.Changes, "received", len(tx.Changes))
+		}
+		return true, dl.enqueueTransaction(ctx, tx, n)
+	}
+	if dl.transactions == nil || n.TxID == 0 {
+		return false, nil
+	}
+	dl.txs.add(n)
+	span.End()
+	return true, nil
+}
+
+// enqueueTransaction queues tx for the transaction handler, carried by its
+// commit marker.
+func (dl *DataListener) enqueueTransaction(ctx context.Context, tx *Transaction, marker *ChangeNotification) error {
+	marker.tx = tx
+	return dl.enqueue(ctx, marker)
+}
+
+// expireTransactions hands over transactions whose commit marker did not
+// arrive in time, so their events are not held back indefinitely.
+func (dl *DataListener) expireTransactions(ctx context.Context) {
+	for _, tx := range dl.txs.expire(time.Now()) {
+		dl.logger.Warn("transaction commit marker missing, delivering buffered changes",
+			"channel", tx.Channel, "txid", tx.TxID, "changes", len(tx.Changes))
+		ctx, _ := dl.startNotificationSpan(ctx, "transaction "+tx.Channel, tx.Channel)
+		marker := &ChangeNotification{Channel: tx.Channel, TxID: tx.TxID, Commit: true}
+		if err := dl.enqueueTransaction(ctx, tx, marker); err != nil {
+			dl.logger.Error("failed to enqueue transaction", "channel", tx.Channel, "txid", tx.TxID, "error", err)
+		}
+	}
+}
+
+// processTransaction calls the transaction handler, retrying per the
+// listener's policy. When it fails permanently every change counts as
+// failed.
+func (dl *DataListener) processTransaction(ctx context.Context, marker *ChangeNotification) {
+	tx := marker.tx
+	changes := tx.Changes[:0]
+	for _, c := range tx.Changes {
+		if c.Ref && !dl.resolve(ctx, c) {
+			if c.ID != 0 {
+				dl.complete(ctx, c.ID)
+			}
+			continue
+		}
+		changes = append(changes, c)
+	}
+	tx.Changes = changes
+	dl.handleTransaction(ctx, marker)
+}
+
+// handleTransaction calls the transaction handler of a resolved
+// transaction. A nack delivers the whole transaction again.
+func (dl *DataListener) handleTransaction(ctx context.Context, marker *ChangeNotification) {
+	tx := marker.tx
+	policy, first := dl.retryPolicy(), time.Now()
+	ack := &ackState{}
+	hctx, span := dl.tracer.Start(context.WithValue(ctx, ackKey{}, ack), "handle transaction")
+	attempt := 0
+	failure := callWithRetry(hctx, policy, marker, func() error {
+		attempt++
+		span.SetAttributes(attrAttempt.Int(attempt))
+		start := time.Now()
+		err := callWithTimeout(hctx, dl.timeout, func(ctx context.Context) error {
+			return dl.transactions.HandleTransaction(ctx, tx)
+		})
+		elapsed := time.Since(start)
+		var pe *PanicError
+		if errors.As(err, &pe) {
+			dl.handlerPanicked(marker, pe)
+		}
+		if errors.Is(err, ErrHandlerTimeout) {
+			dl.metrics.HandlerTimedOut(marker)
+		}
+		if err != nil {
+			span.RecordError(err)
+			dl.logger.Warn("transaction handler failed",
+				"channel", tx.Channel, "txid", tx.TxID, "changes", len(tx.Changes), "duration", elapsed, "error", err)
+		} else {
+			dl.logger.Debug("handled transaction",
+				"channel", tx.Channel, "txid", tx.TxID, "changes", len(tx.Changes), "duration", elapsed)
+		}
+		return err
+	})
+	failAll := func(failure *Failure) {
+		for _, c := range tx.Changes {
+			f := *failure
+			f.Notification = c
+			dl.fail(ctx, &f)
+		}
+	}
+	if failure != nil {
+		span.SetStatus(codes.Error, failure.Err.Error())
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, failure.Err.Error())
+		failAll(failure)
+	}
+	span.End()
+
+	complete := func() {
+		for _, c := range tx.Changes {
+			if c.ID != 0 {
+				dl.complete(ctx, c.ID)
+			}
+		}
+		if marker.ID != 0 {
+			dl.complete(ctx, marker.ID)
+		}
+	}
+	if failure != nil {
+		ack.finish(complete)
+		return
+	}
+	ack.settle(complete, func(nk *nack) {
+		giveUp := func(f *Failure) {
+			failAll(f)
+			complete()
+		}
+		dl.nacked(ctx, marker, nk, policy, first, giveUp, func(ctx context.Context) {
+			dl.handleTransaction(ctx, marker)
+		})
+	})
+}
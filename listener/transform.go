@@ -0,0 +1,143 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Transform reshapes the rows of a table before they reach the handler,
+// see WithTransform. Compute expressions see the row as it was received;
+// then Drop and Rename apply and the computed columns are appended in
+// name order.
+type Transform struct {
+	// Rename maps column names to new names.
+	Rename map[string]string
+	// Drop lists the columns to remove.
+	Drop []string
+	// Compute maps the names of derived columns to expressions computing
+	// them, see CompileTransformExpr.
+	Compute map[string]*Expr
+}
+
+// CompileTransformExpr parses a Compute expression. Besides the variables
+// of Expr it can refer to row, the row image being transformed, e.g.
+// `row.price * row.quantity`.
+func CompileTransformExpr(expr string) (*Expr, error) {
+	return compileExpr(expr, "row")
+}
+
+// WithTransform applies t to the row images of the notifications of one
+// handler before it is called, after WithRedaction and before the rows
+// are validated against WithSchema. Rename and Drop apply to the key too.
+// Notifications whose rows cannot be transformed, e.g. because a Compute
+// expression fails, are dropped as malformed.
+func WithTransform(t Transform) HandlerOption {
+	return func(reg *registration) {
+		reg.transform = &t
+	}
+}
+
+// transform applies the handler's transform to n.
+func (dl *DataListener) transform(n *ChangeNotification, reg *registration) bool {
+	if n.transformed {
+		return true
+	}
+	env := &exprEnv{n: n}
+	computed := *n
+	var err error
+	for _, row := range []struct {
+		dst     *json.RawMessage
+		compute bool
+	}{{&computed.Key, false}, {&computed.Data, true}, {&computed.Old, true}, {&computed.New, true}} {
+		if isNull(*row.dst) {
+			continue
+		}
+		if *row.dst, err = reg.transform.apply(env, *row.dst, row.compute); err != nil {
+			dl.logger.Error("failed to transform notification",
+				"channel", n.Channel, "table", n.Table, "error", err)
+			dl.metrics.Dropped(n.Channel, DropMalformed)
+			dl.tenantDropped(n, DropMalformed)
+			return false
+		}
+	}
+	n.Key, n.Data, n.Old, n.New = computed.Key, computed.Data, computed.Old, computed.New
+	n.transformed = true
+	return true
+}
+
+// apply returns row transformed, keeping the column order. Expressions
+// are evaluated with env, which holds the rows as received.
+func (t *Transform) apply(env *exprEnv, row json.RawMessage, compute bool) (json.RawMessage, error) {
+	var values map[string]json.RawMessage
+	if compute && len(t.Compute) > 0 {
+		doc, err := decodeJSON(row)
+		if err != nil {
+			return nil, err
+		}
+		env.scope = []exprBinding{{"row", exprValue(doc)}}
+		values = make(map[string]json.RawMessage, len(t.Compute))
+		for col, expr := range t.Compute {
+			v, err := expr.evalWith(env)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col, err)
+			}
+			if values[col], err = json.Marshal(v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", col, err)
+			}
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(row))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, errors.New("row is not a JSON object")
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	write := func(col string, v json.RawMessage) {
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		col := tok.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		if slices.Contains(t.Drop, col) {
+			continue
+		}
+		if to, ok := t.Rename[col]; ok {
+			col = to
+		}
+		if _, ok := values[col]; ok {
+			// A computed column replaces the one of the same name.
+			continue
+		}
+		write(col, v)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	cols := make([]string, 0, len(values))
+	for col := range values {
+		cols = append(cols, col)
+	}
+	slices.Sort(cols)
+	for _, col := range cols {
+		write(col, values[col])
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
@@ -0,0 +1,66 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// DecodeError reports a row that could not be unmarshaled into the typed
+// handler's row type.
+type DecodeError struct {
+	Table     string
+	Operation string
+	Type      string
+	Data      json.RawMessage
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s row of %s into %s: %v", e.Operation, e.Table, e.Type, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TypedHandler decodes the row into T before calling its function with
+// the handler's context. INSERT and UPDATE receive the new row, DELETE the
+// deleted one.
+type TypedHandler[T any] struct {
+	fn func(ctx context.Context, op string, row T) error
+}
+
+func Typed[T any](fn func(ctx context.Context, op string, row T) error) *TypedHandler[T] {
+	return &TypedHandler[T]{fn: fn}
+}
+
+func (th *TypedHandler[T]) HandleChange(ctx context.Context, operation string, data json.RawMessage) error {
+	return th.handle(ctx, "", operation, data)
+}
+
+func (th *TypedHandler[T]) HandleNotification(ctx context.Context, n *ChangeNotification) error {
+	data := n.Data
+	switch {
+	case n.Operation == OpDelete && n.Old != nil:
+		data = n.Old
+	case n.New != nil:
+		data = n.New
+	}
+	return th.handle(ctx, n.Table, n.Operation, data)
+}
+
+func (th *TypedHandler[T]) handle(ctx context.Context, table, operation string, data json.RawMessage) error {
+	var row T
+	if err := json.Unmarshal(data, &row); err != nil {
+		return &DecodeError{
+			Table:     table,
+			Operation: operation,
+			Type:      reflect.TypeOf(&row).Elem().String(),
+			Data:      data,
+			Err:       err,
+		}
+	}
+	return th.fn(ctx, operation, row)
+}
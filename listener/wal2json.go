@@ -0,0 +1,122 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const wal2jsonTimeLayout = "2006-01-02 15:04:05.999999-07"
+
+// wal2json decodes the wal2json plugin with format-version 2, which sends
+// one message per change.
+type wal2json struct {
+	commitTime time.Time
+	xid        int64
+	inTx       bool
+}
+
+type wal2jsonMessage struct {
+	Action    string           `json:"action"`
+	XID       int64            `json:"xid"`
+	Schema    string           `json:"schema"`
+	Table     string           `json:"table"`
+	Timestamp string           `json:"timestamp"`
+	Columns   []wal2jsonColumn `json:"columns"`
+	Identity  []wal2jsonColumn `json:"identity"`
+	PK        []wal2jsonColumn `json:"pk"`
+}
+
+type wal2jsonColumn struct {
+	Name  string          `json:"name"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (d *wal2json) pluginArgs() []string {
+	return []string{`"format-version" '2'`, `"include-timestamp" '1'`, `"include-pk" '1'`, `"include-xids" '1'`}
+}
+
+func (d *wal2json) inTransaction() bool { return d.inTx }
+
+func (d *wal2json) decode(lsn uint64, data []byte) (*ChangeNotification, uint64, error) {
+	var m wal2jsonMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse wal2json message: %w", err)
+	}
+
+	switch m.Action {
+	case "B":
+		d.inTx = true
+		d.xid = m.XID
+		d.commitTime = time.Time{}
+		if t, err := time.Parse(wal2jsonTimeLayout, m.Timestamp); err == nil {
+			d.commitTime = t
+		}
+		return nil, 0, nil
+	case "C":
+		d.inTx = false
+		return nil, lsn + uint64(len(data)), nil
+	case "I", "U", "D":
+	default:
+		// Truncates and logical messages are not delivered.
+		return nil, 0, nil
+	}
+
+	keys := make(map[string]bool, len(m.PK))
+	for _, c := range m.PK {
+		keys[c.Name] = true
+	}
+	n := &ChangeNotification{Schema: m.Schema, Table: m.Table, Timestamp: d.commitTime, TxID: d.xid}
+	switch m.Action {
+	case "I":
+		n.Operation = OpInsert
+		n.New = columnsRow(m.Columns, nil)
+		n.Key = columnsRow(m.Columns, keys)
+		n.Data = n.New
+	case "U":
+		n.Operation = OpUpdate
+		n.New = columnsRow(m.Columns, nil)
+		n.Key = columnsRow(m.Columns, keys)
+		if len(m.Identity) > len(keys) {
+			n.Old = columnsRow(m.Identity, nil)
+		}
+		n.Data = n.New
+	case "D":
+		n.Operation = OpDelete
+		n.Old = columnsRow(m.Identity, nil)
+		n.Key = columnsRow(m.Identity, keys)
+		n.Data = n.Old
+	}
+	return n, 0, nil
+}
+
+// columnsRow encodes columns as a JSON object, restricted to only when it
+// is not nil.
+func columnsRow(columns []wal2jsonColumn, only map[string]bool) json.RawMessage {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	first := true
+	for _, c := range columns {
+		if only != nil && !only[c.Name] {
+			continue
+		}
+		if !first {
+			b.WriteByte(',')
+		}
+		first = false
+		name, _ := json.Marshal(c.Name)
+		b.Write(name)
+		b.WriteByte(':')
+		if len(c.Value) == 0 {
+			b.WriteString("null")
+		} else {
+			b.Write(c.Value)
+		}
+	}
+	if first {
+		return nil
+	}
+	b.WriteByte('}')
+	return b.Bytes()
+}
@@ -0,0 +1,131 @@
+package listener
+
+import (
+	"container/heap"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// watermark tracks event ids that are in flight and reports the highest id
+// below which every tracked event has completed. Events complete out of
+// order once a worker pool is used, so the last completed id is not a safe
+// resume point on its own.
+type watermark struct {
+	mu      sync.Mutex
+	pending map[int64]int
+	ids     idHeap
+	maxSeen int64
+}
+
+func newWatermark() *watermark {
+	return &watermark{pending: make(map[int64]int)}
+}
+
+func (w *watermark) track(id int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending[id] == 0 {
+		heap.Push(&w.ids, id)
+	}
+	w.pending[id]++
+	if id > w.maxSeen {
+		w.maxSeen = id
+	}
+}
+
+// complete marks id done and returns the current safe high-water mark.
+func (w *watermark) complete(id int64) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending[id] > 1 {
+		w.pending[id]--
+	} else {
+		delete(w.pending, id)
+	}
+	for w.ids.Len() > 0 {
+		if _, ok := w.pending[w.ids[0]]; ok {
+			break
+		}
+		heap.Pop(&w.ids)
+	}
+	return w.markLocked()
+}
+
+// mark returns the current safe high-water mark.
+func (w *watermark) mark() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.markLocked()
+}
+
+func (w *watermark) markLocked() int64 {
+	if w.ids.Len() == 0 {
+		return w.maxSeen
+	}
+	return w.ids[0] - 1
+}
+
+// idle reports whether no tracked event is in flight.
+func (w *watermark) idle() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pending) == 0
+}
+
+type idHeap []int64
+
+func (h idHeap) Len() int           { return len(h) }
+func (h idHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h idHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *idHeap) Push(x any)        { *h = append(*h, x.(int64)) }
+
+func (h *idHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// idSet is a set of ids kept as sorted, disjoint ranges, so it stays small
+// while the ids are mostly consecutive.
+type idSet []idRange
+
+type idRange struct{ lo, hi int64 }
+
+func (s idSet) contains(id int64) bool {
+	i := sort.Search(len(s), func(i int) bool { return s[i].hi >= id })
+	return i < len(s) && s[i].lo <= id
+}
+
+func (s *idSet) add(id int64) {
+	r := *s
+	// r[i] is the first range that contains id or could be extended to.
+	i := sort.Search(len(r), func(i int) bool { return r[i].hi >= id-1 })
+	switch {
+	case i == len(r) || r[i].lo > id+1:
+		r = slices.Insert(r, i, idRange{id, id})
+	case r[i].hi == id-1:
+		r[i].hi = id
+		if i+1 < len(r) && r[i+1].lo == id+1 {
+			r[i].hi = r[i+1].hi
+			r = slices.Delete(r, i+1, i+2)
+		}
+	case r[i].lo == id+1:
+		r[i].lo = id
+	}
+	*s = r
+}
+
+// prune removes the ids up to and including below.
+func (s *idSet) prune(below int64) {
+	r := *s
+	i := sort.Search(len(r), func(i int) bool { return r[i].hi > below })
+	r = r[i:]
+	if len(r) > 0 && r[0].lo <= below {
+		r[0].lo = below + 1
+	}
+	*s = r
+}
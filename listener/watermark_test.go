@@ -0,0 +1,117 @@
+package listener
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWatermark(t *testing.T) {
+	type step struct {
+		track    int64
+		complete int64
+		want     int64
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "in order",
+			steps: []step{
+				{track: 1, want: 0},
+				{track: 2, want: 0},
+				{complete: 1, want: 1},
+				{complete: 2, want: 2},
+			},
+		},
+		{
+			name: "out of order",
+			steps: []step{
+				{track: 1, want: 0},
+				{track: 2, want: 0},
+				{track: 3, want: 0},
+				{complete: 3, want: 0},
+				{complete: 2, want: 0},
+				{complete: 1, want: 3},
+			},
+		},
+		{
+			name: "gap in ids",
+			steps: []step{
+				{track: 5, want: 4},
+				{track: 9, want: 4},
+				{complete: 5, want: 8},
+				{complete: 9, want: 9},
+			},
+		},
+		{
+			name: "id tracked twice",
+			steps: []step{
+				{track: 1, want: 0},
+				{track: 1, want: 0},
+				{complete: 1, want: 0},
+				{complete: 1, want: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWatermark()
+			for i, s := range tt.steps {
+				var got int64
+				if s.track != 0 {
+					w.track(s.track)
+					got = w.mark()
+				} else {
+					got = w.complete(s.complete)
+				}
+				if got != s.want {
+					t.Fatalf("step %d: mark = %d, want %d", i, got, s.want)
+				}
+			}
+			if !w.idle() {
+				t.Errorf("idle = false after every id completed")
+			}
+		})
+	}
+}
+
+func TestIDSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		add   []int64
+		prune int64
+		want  idSet
+	}{
+		{name: "empty", want: nil},
+		{name: "single", add: []int64{5}, want: idSet{{5, 5}}},
+		{name: "consecutive", add: []int64{1, 2, 3}, want: idSet{{1, 3}}},
+		{name: "descending", add: []int64{3, 2, 1}, want: idSet{{1, 3}}},
+		{name: "disjoint", add: []int64{1, 5, 9}, want: idSet{{1, 1}, {5, 5}, {9, 9}}},
+		{name: "fills gap", add: []int64{1, 3, 2}, want: idSet{{1, 3}}},
+		{name: "duplicate", add: []int64{4, 4, 5, 4}, want: idSet{{4, 5}}},
+		{name: "insert before", add: []int64{10, 2}, want: idSet{{2, 2}, {10, 10}}},
+		{name: "prune below", add: []int64{1, 2, 5, 6}, prune: 0, want: idSet{{1, 2}, {5, 6}}},
+		{name: "prune range", add: []int64{1, 2, 5, 6}, prune: 2, want: idSet{{5, 6}}},
+		{name: "prune within range", add: []int64{1, 2, 5, 6, 7}, prune: 5, want: idSet{{6, 7}}},
+		{name: "prune all", add: []int64{1, 2, 5}, prune: 9, want: idSet{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s idSet
+			for _, id := range tt.add {
+				s.add(id)
+			}
+			s.prune(tt.prune)
+			if len(s) != len(tt.want) || !slices.Equal(s, tt.want) {
+				t.Fatalf("set = %v, want %v", s, tt.want)
+			}
+			for id := int64(0); id <= 12; id++ {
+				want := id > tt.prune && slices.Contains(tt.add, id)
+				if got := s.contains(id); got != want {
+					t.Errorf("contains(%d) = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,131 +1,120 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
-	"time"
+	"log/slog"
+	"os"
+	"strings"
 
-	"github.com/lib/pq"
+	"github.com/force-c/pg-data-listener/config"
 )
 
-type ChangeNotification struct {
-	Table     string          `json:"table"`
-	Operation string          `json:"operation"`
-	Data      json.RawMessage `json:"data"`
-	Timestamp time.Time       `json:"timestamp"`
-}
-
-type TableChangeHandler interface {
-	HandleChange(operation string, data json.RawMessage) error
-}
-
 type ConfigManager struct{}
 
-func (cm *ConfigManager) HandleChange(operation string, data json.RawMessage) error {
+func (cm *ConfigManager) HandleChange(_ context.Context, operation string, data json.RawMessage) error {
 	log.Printf("[s_config] %s: %s", operation, string(data))
 	return nil
 }
 
 type UserManager struct{}
 
-func (um *UserManager) HandleChange(operation string, data json.RawMessage) error {
+func (um *UserManager) HandleChange(_ context.Context, operation string, data json.RawMessage) error {
 	log.Printf("[s_user] %s: %s", operation, string(data))
 	return nil
 }
 
-type DataListener struct {
-	db       *sql.DB
-	handlers map[string]TableChangeHandler
-}
+// Exit codes.
+const (
+	exitOK      = 0 // stopped by a signal after draining
+	exitFailure = 1 // the listener failed or did not drain in time
+	exitConfig  = 2 // invalid flags or configuration
+)
 
-func NewDataListener(connStr string) (*DataListener, error) {
-	db, err := sql.Open("postgres", connStr)
-	if err != nil {
-		return nil, err
-	}
+const usage = `Usage: pg-data-listener <command> [flags]
 
-	if err := db.Ping(); err != nil {
-		return nil, err
-	}
+Commands:
+  listen               run the listener (default)
+  install-triggers     install the notify triggers on tables
+  uninstall-triggers   remove the notify triggers
+  replay               re-deliver events from the outbox or audit table to the sinks
+  status               show the status of a running instance
+  sink-types           list the sink types configs can use
 
-	return &DataListener{
-		db:       db,
-		handlers: make(map[string]TableChangeHandler),
-	}, nil
-}
+Run "pg-data-listener <command> -h" for the flags of a command.
+`
 
-func (dl *DataListener) RegisterHandler(tableName string, handler TableChangeHandler) {
-	dl.handlers[tableName] = handler
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
 
-func (dl *DataListener) handleNotification(payload string) error {
-	var notification ChangeNotification
-	if err := json.Unmarshal([]byte(payload), &notification); err != nil {
-		return fmt.Errorf("failed to parse notification: %v", err)
+func run(args []string) int {
+	cmd := "listen"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
 
-	handler, ok := dl.handlers[notification.Table]
-	if !ok {
-		return nil
+	switch cmd {
+	case "listen":
+		return listen(args)
+	case "install-triggers":
+		return installTriggers(args)
+	case "uninstall-triggers":
+		return uninstallTriggers(args)
+	case "replay":
+		return replay(args)
+	case "status":
+		return status(args)
+	case "sink-types":
+		return sinkTypes(args)
+	case "help":
+		fmt.Print(usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		return exitConfig
 	}
-
-	return handler.HandleChange(notification.Operation, notification.Data)
 }
 
-func (dl *DataListener) Start(connStr string) error {
-	eventCallback := func(ev pq.ListenerEventType, err error) {
-		if err != nil {
-			log.Printf("Listener event: %s, error: %v", ev, err)
-		}
-	}
-
-	listener := pq.NewListener(connStr, 10*time.Second, time.Minute, eventCallback)
-	defer listener.Close()
-
-	if err := listener.Listen("data_changes"); err != nil {
-		return err
-	}
-
-	log.Println("Listening on channel: data_changes")
-
-	for {
-		select {
-		case notification := <-listener.Notify:
-			if notification != nil {
-				if err := dl.handleNotification(notification.Extra); err != nil {
-					log.Printf("Error: %v", err)
-				}
-			}
-		case <-time.After(15 * time.Second):
-			err := listener.Ping()
-			if err != nil {
-
-				return err
-			}
-		}
+// commandUsage returns a usage function printing synopsis and the flags of
+// a command.
+func commandUsage(fs *flag.FlagSet, synopsis string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: pg-data-listener %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
 	}
 }
 
-func (dl *DataListener) Close() error {
-	return dl.db.Close()
+// configFlags defines -config and the config overrides on fs.
+func configFlags(fs *flag.FlagSet) (path *string, flags *config.Flags) {
+	path = fs.String("config", "", "path to a YAML or TOML config file")
+	return path, config.RegisterFlags(fs)
 }
 
-func main() {
-	connStr := "host=localhost port=5433 user=postgres password=post123 dbname=data_listener sslmode=disable"
-
-	listener, err := NewDataListener(connStr)
+// loadConfig resolves the config and sets up the default logger from it.
+// Errors are logged; ok is false if there was one.
+func loadConfig(path string, flags *config.Flags) (cfg *config.Config, logger *slog.Logger, level *slog.LevelVar, ok bool) {
+	cfg, err := config.Resolve(path, flags)
 	if err != nil {
-		log.Fatalf("Failed to create listener: %v", err)
+		log.Printf("Failed to load config: %v", err)
+		return nil, nil, nil, false
 	}
-	defer listener.Close()
-
-	listener.RegisterHandler("s_config", &ConfigManager{})
-	listener.RegisterHandler("s_user", &UserManager{})
+	l, err := logLevel(cfg.Log.Level)
+	if err != nil {
+		log.Print(err)
+		return nil, nil, nil, false
+	}
+	level = new(slog.LevelVar)
+	level.Set(l)
 
-	log.Println("Starting listener...")
-	if err := listener.Start(connStr); err != nil {
-		log.Fatalf("Failed to start: %v", err)
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	if cfg.Log.Format == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level})
 	}
+	logger = slog.New(handler)
+	slog.SetDefault(logger)
+	return cfg, logger, level, true
 }
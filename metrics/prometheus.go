@@ -0,0 +1,252 @@
+// Package metrics exports listener instrumentation to Prometheus.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const namespace = "pg_data_listener"
+
+type Prometheus struct {
+	listener.NopMetrics
+
+	received        *prometheus.CounterVec
+	lag             *prometheus.HistogramVec
+	handlerDuration *prometheus.HistogramVec
+	handlerErrors   *prometheus.CounterVec
+	reconnects      prometheus.Counter
+	queueDepth      prometheus.Gauge
+	queueBlocked    prometheus.Counter
+	dropped         *prometheus.CounterVec
+	slotRetained    *prometheus.GaugeVec
+	slotLag         *prometheus.GaugeVec
+	slotActive      *prometheus.GaugeVec
+	sequenceGaps    *prometheus.CounterVec
+	missed          *prometheus.CounterVec
+	handlerPanics   *prometheus.CounterVec
+	handlerTimeouts *prometheus.CounterVec
+	circuitOpen     *prometheus.GaugeVec
+	paused          prometheus.Gauge
+	tenantReceived  *prometheus.CounterVec
+	tenantCalls     *prometheus.CounterVec
+	tenantErrors    *prometheus.CounterVec
+	tenantDropped   *prometheus.CounterVec
+}
+
+// NewPrometheus creates the collectors and registers them with reg
+// (prometheus.DefaultRegisterer when nil).
+func NewPrometheus(reg prometheus.Registerer) *Prometheus {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	p := &Prometheus{
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "notifications_received_total",
+			Help:      "Notifications received, by channel, table and operation.",
+		}, []string{"channel", "table", "operation"}),
+		lag: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "notification_lag_seconds",
+			Help:      "Delay between the change timestamp and its receipt.",
+			Buckets:   []float64{.005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+		}, []string{"table"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Handler call latency, per attempt.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"table", "operation"}),
+		handlerErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "handler_errors_total",
+			Help:      "Handler calls that returned an error, per attempt.",
+		}, []string{"table", "operation"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Times the LISTEN connection was re-established.",
+		}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Notifications waiting for a worker.",
+		}),
+		queueBlocked: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "queue_blocked_seconds_total",
+			Help:      "Time the receive loop waited for room in a full queue.",
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "dropped_total",
+			Help:      "Notifications dropped without being handled, by reason.",
+		}, []string{"channel", "reason"}),
+		slotRetained: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "replication_slot_retained_bytes",
+			Help:      "WAL retained by the replication slot.",
+		}, []string{"slot"}),
+		slotLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "replication_slot_lag_bytes",
+			Help:      "Distance between the current WAL position and the slot's confirmed position.",
+		}, []string{"slot"}),
+		slotActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "replication_slot_active",
+			Help:      "Whether a connection is streaming the replication slot.",
+		}, []string{"slot"}),
+		sequenceGaps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sequence_gaps_total",
+			Help:      "Gaps detected in notification sequence numbers, by channel.",
+		}, []string{"channel"}),
+		missed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "missed_notifications_total",
+			Help:      "Notifications missing from sequence gaps, by channel.",
+		}, []string{"channel"}),
+		handlerPanics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "handler_panics_total",
+			Help:      "Recovered handler panics, by table and operation.",
+		}, []string{"table", "operation"}),
+		handlerTimeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "handler_timeouts_total",
+			Help:      "Handler calls that exceeded their timeout, by table and operation.",
+		}, []string{"table", "operation"}),
+		circuitOpen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "circuit_open",
+			Help:      "Whether the circuit breaker of a table's handler is open.",
+		}, []string{"table"}),
+		paused: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "paused",
+			Help:      "Whether processing is paused.",
+		}),
+		tenantReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tenant_notifications_received_total",
+			Help:      "Notifications received, by tenant.",
+		}, []string{"tenant"}),
+		tenantCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tenant_handler_calls_total",
+			Help:      "Handler calls, per attempt, by tenant.",
+		}, []string{"tenant"}),
+		tenantErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tenant_handler_errors_total",
+			Help:      "Handler calls that returned an error, per attempt, by tenant.",
+		}, []string{"tenant"}),
+		tenantDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tenant_dropped_total",
+			Help:      "Notifications dropped without being handled, by tenant and reason.",
+		}, []string{"tenant", "reason"}),
+	}
+
+	reg.MustRegister(p.received, p.lag, p.handlerDuration, p.handlerErrors, p.reconnects, p.queueDepth, p.queueBlocked, p.dropped,
+		p.slotRetained, p.slotLag, p.slotActive, p.sequenceGaps, p.missed, p.handlerPanics, p.handlerTimeouts, p.circuitOpen, p.paused,
+		p.tenantReceived, p.tenantCalls, p.tenantErrors, p.tenantDropped)
+	return p
+}
+
+func (p *Prometheus) NotificationReceived(n *listener.ChangeNotification) {
+	p.received.WithLabelValues(n.Channel, n.Table, n.Operation).Inc()
+	if !n.Timestamp.IsZero() {
+		p.lag.WithLabelValues(n.Table).Observe(time.Since(n.Timestamp).Seconds())
+	}
+}
+
+func (p *Prometheus) HandlerCompleted(n *listener.ChangeNotification, d time.Duration, err error) {
+	p.handlerDuration.WithLabelValues(n.Table, n.Operation).Observe(d.Seconds())
+	if err != nil {
+		p.handlerErrors.WithLabelValues(n.Table, n.Operation).Inc()
+	}
+}
+
+func (p *Prometheus) Reconnected() {
+	p.reconnects.Inc()
+}
+
+func (p *Prometheus) QueueBlocked(d time.Duration) {
+	p.queueBlocked.Add(d.Seconds())
+}
+
+func (p *Prometheus) QueueDepth(depth int) {
+	p.queueDepth.Set(float64(depth))
+}
+
+func (p *Prometheus) Dropped(channel, reason string) {
+	p.dropped.WithLabelValues(channel, reason).Inc()
+}
+
+func (p *Prometheus) ReplicationSlot(s *listener.SlotInfo) {
+	p.slotRetained.WithLabelValues(s.Name).Set(float64(s.RetainedBytes))
+	p.slotLag.WithLabelValues(s.Name).Set(float64(s.LagBytes))
+	active := 0.0
+	if s.Active {
+		active = 1
+	}
+	p.slotActive.WithLabelValues(s.Name).Set(active)
+}
+
+func (p *Prometheus) SequenceGap(channel string, missed int64) {
+	p.sequenceGaps.WithLabelValues(channel).Inc()
+	p.missed.WithLabelValues(channel).Add(float64(missed))
+}
+
+func (p *Prometheus) HandlerPanicked(n *listener.ChangeNotification) {
+	p.handlerPanics.WithLabelValues(n.Table, n.Operation).Inc()
+}
+
+func (p *Prometheus) HandlerTimedOut(n *listener.ChangeNotification) {
+	p.handlerTimeouts.WithLabelValues(n.Table, n.Operation).Inc()
+}
+
+func (p *Prometheus) CircuitChanged(n *listener.ChangeNotification, open bool) {
+	v := 0.0
+	if open {
+		v = 1
+	}
+	p.circuitOpen.WithLabelValues(n.Table).Set(v)
+}
+
+func (p *Prometheus) Paused(paused bool) {
+	v := 0.0
+	if paused {
+		v = 1
+	}
+	p.paused.Set(v)
+}
+
+func (p *Prometheus) TenantReceived(n *listener.ChangeNotification) {
+	p.tenantReceived.WithLabelValues(n.Tenant).Inc()
+}
+
+func (p *Prometheus) TenantHandled(n *listener.ChangeNotification, err error) {
+	p.tenantCalls.WithLabelValues(n.Tenant).Inc()
+	if err != nil {
+		p.tenantErrors.WithLabelValues(n.Tenant).Inc()
+	}
+}
+
+func (p *Prometheus) TenantDropped(n *listener.ChangeNotification, reason string) {
+	p.tenantDropped.WithLabelValues(n.Tenant, reason).Inc()
+}
+
+// Handler serves the metrics registered with the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
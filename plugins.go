@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// Sink types beyond the built-in ones register themselves with
+// sink.Register from the init function of their package, so a binary
+// gains them by importing the package. Keep such imports in files of
+// their own behind a build tag, e.g. plugin_slack.go:
+//
+//	//go:build slack
+//
+//	package main
+//
+//	import _ "example.com/pgdl-slack"
+//
+// and build with go build -tags slack.
+
+// sinkTypes lists the sink types configs can use.
+func sinkTypes(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "sink-types takes no arguments")
+		return exitConfig
+	}
+	for _, typ := range sink.Types() {
+		fmt.Println(typ)
+	}
+	return exitOK
+}
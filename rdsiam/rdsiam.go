@@ -0,0 +1,112 @@
+// Package rdsiam authenticates listener connections to Amazon RDS and
+// Aurora PostgreSQL with IAM authentication tokens instead of a stored
+// password.
+package rdsiam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// TokenLifetime is how long RDS accepts a token for new connections.
+// Established connections are not affected when it expires.
+const TokenLifetime = 15 * time.Minute
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Config configures Password.
+type Config struct {
+	// Region of the database, taken from an RDS host name such as
+	// db.abc123.eu-west-1.rds.amazonaws.com when empty, or else from the
+	// SDK's default config.
+	Region string
+	// Credentials sign the tokens, e.g. the credentials of an aws.Config,
+	// and those of the SDK's default chain when nil. They need the
+	// rds-db:connect permission for the database user.
+	Credentials aws.CredentialsProvider
+}
+
+// Password returns a listener.PasswordFunc creating a new token for every
+// connection, so reconnects never use an expired one. The default config
+// is loaded for the first token that needs it.
+func Password(cfg Config) listener.PasswordFunc {
+	defaults := sync.OnceValues(func() (aws.Config, error) {
+		c, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+		return c, nil
+	})
+	return func(ctx context.Context, host string, port uint16, user string) (string, error) {
+		region, creds := cfg.Region, cfg.Credentials
+		if region == "" {
+			region = regionFromHost(host)
+		}
+		if region == "" || creds == nil {
+			c, err := defaults()
+			if err != nil {
+				return "", err
+			}
+			if region == "" {
+				region = c.Region
+			}
+			if creds == nil {
+				creds = c.Credentials
+			}
+		}
+		if region == "" {
+			return "", fmt.Errorf("no region configured for host %s", host)
+		}
+		return AuthToken(ctx, net.JoinHostPort(host, strconv.Itoa(int(port))), region, user, creds)
+	}
+}
+
+// AuthToken creates an authentication token for user on the database at
+// endpoint, its host and port, valid for TokenLifetime.
+func AuthToken(ctx context.Context, endpoint, region, user string, creds aws.CredentialsProvider) (string, error) {
+	c, err := creds.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"Action":        {"connect"},
+		"DBUser":        {user},
+		"X-Amz-Expires": {strconv.Itoa(int(TokenLifetime.Seconds()))},
+	}
+	req.URL.RawQuery = query.Encode()
+
+	signed, _, err := v4.NewSigner().PresignHTTP(ctx, c, req, emptyPayloadHash, "rds-db", region, time.Now().UTC())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign authentication token: %w", err)
+	}
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
+// regionFromHost returns the region of an RDS endpoint, or "".
+func regionFromHost(host string) string {
+	parts := strings.Split(host, ".")
+	for i := len(parts) - 3; i >= 1; i-- {
+		if parts[i+1] == "rds" && parts[i+2] == "amazonaws" {
+			return parts[i]
+		}
+	}
+	return ""
+}
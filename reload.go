@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/force-c/pg-data-listener/config"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/secrets"
+	"github.com/force-c/pg-data-listener/sink/notify"
+)
+
+// service applies a config to the listeners, one per source database, and
+// reapplies it on reload without restarting their LISTEN connections.
+// Sinks and tables whose settings did not change are kept, so their
+// connections, circuit breakers and rate limiters survive a reload.
+type service struct {
+	path   string
+	flags  *config.Flags
+	logger *slog.Logger
+	level  *slog.LevelVar
+
+	// secrets resolves the references of the config.
+	secrets *secrets.Resolver
+
+	// names lists the sources in the order they were added.
+	names   []string
+	sources map[string]*source
+
+	mu    sync.Mutex
+	cfg   *config.Config
+	sinks map[string]*builtSink
+}
+
+// source is the listener of a source database with the routing applied
+// to it.
+type source struct {
+	dl *listener.DataListener
+	// password serves a referenced database password, nil otherwise.
+	password *rotatingPassword
+	sets     map[string]*listener.HandlerSet
+	// tenants are the sets serving the tables routed by tenant.
+	tenants map[string]*listener.HandlerSet
+	tables  map[tableKey]config.Table
+}
+
+type tableKey struct {
+	channel string
+	tenant  string
+	name    string
+}
+
+func newService(path string, flags *config.Flags, logger *slog.Logger, level *slog.LevelVar, r *secrets.Resolver) *service {
+	return &service{
+		path:    path,
+		flags:   flags,
+		logger:  logger,
+		level:   level,
+		secrets: r,
+		sources: make(map[string]*source),
+		sinks:   make(map[string]*builtSink),
+	}
+}
+
+// addSource routes the tables of the named source, see config.Source, to
+// dl. It is called before the first apply.
+func (s *service) addSource(name string, dl *listener.DataListener, password *rotatingPassword) {
+	s.names = append(s.names, name)
+	s.sources[name] = &source{
+		dl:       dl,
+		password: password,
+		sets:     make(map[string]*listener.HandlerSet),
+		tenants:  make(map[string]*listener.HandlerSet),
+		tables:   make(map[tableKey]config.Table),
+	}
+}
+
+// listener returns the listener of the named source.
+func (s *service) listener(name string) (*listener.DataListener, bool) {
+	src, ok := s.sources[name]
+	if !ok {
+		return nil, false
+	}
+	return src.dl, true
+}
+
+// reload resolves the config again and applies it. An invalid config
+// leaves the running one in place.
+func (s *service) reload() error {
+	cfg, err := config.Resolve(s.path, s.flags)
+	if err != nil {
+		return err
+	}
+	if err := s.apply(cfg); err != nil {
+		return err
+	}
+	s.logger.Info("configuration reloaded")
+	return nil
+}
+
+// apply brings sinks, channels, table registrations, the log level, the
+// retry policy and the quarantine in line with cfg. The database, HTTP and remaining listener
+// settings, and added or removed sources, only take effect on restart.
+// Secret references of the sinks and redact keys are fetched again; sinks
+// whose secrets changed are recreated, tables whose keys changed
+// re-registered. Replaced sinks are closed once the calls still
+// delivering to them returned.
+func (s *service) apply(cfg *config.Config) error {
+	level, err := logLevel(cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+	resolved := make(map[string]config.Sink, len(cfg.Sinks))
+	for name, sc := range cfg.Sinks {
+		if resolved[name], err = sc.ResolveSecrets(ctx, s.secrets); err != nil {
+			return fmt.Errorf("sinks.%s: %w", name, err)
+		}
+	}
+	resolvedTables := make(map[string][]config.Table, len(s.names))
+	for _, name := range s.names {
+		src := cfg.Source(name)
+		tables := make([]config.Table, len(src.Tables))
+		for i, t := range src.Tables {
+			if tables[i], err = t.ResolveSecrets(ctx, s.secrets); err != nil {
+				return fmt.Errorf("%s: tables[%d].%w", name, i, err)
+			}
+		}
+		resolvedTables[name] = tables
+	}
+
+	s.mu.Lock()
+
+	// Create new and changed sinks first, so a failure leaves the running
+	// config untouched.
+	sinks := make(map[string]*builtSink, len(cfg.Sinks))
+	var created []*builtSink
+	for name, sc := range resolved {
+		if old, ok := s.sinks[name]; ok && reflect.DeepEqual(old.cfg, sc) {
+			sinks[name] = old
+			continue
+		}
+		b, err := buildSink(sc, s.logger)
+		if err != nil {
+			for _, b := range created {
+				b.Close()
+			}
+			s.mu.Unlock()
+			return fmt.Errorf("failed to create sink %s: %w", name, err)
+		}
+		sinks[name] = b
+		created = append(created, b)
+	}
+
+	if s.cfg != nil && restartRequired(s.cfg, cfg) {
+		s.logger.Warn("sources, database, http, log format, secrets backend, incidents and listener settings other than channels take effect on restart")
+	}
+
+	var errs []error
+	retry := listener.NoRetry
+	if cfg.Retry != nil {
+		retry = cfg.Retry.Policy()
+	}
+	var quarantine listener.NotificationHandler
+	if b, ok := sinks[cfg.Quarantine]; ok {
+		quarantine = b.handler
+	}
+	var onError listener.ErrorHandler
+	if b, ok := sinks[cfg.Failures]; ok {
+		report := notify.Failures(b.handler)
+		onError = func(ctx context.Context, f *listener.Failure) {
+			n := f.Notification
+			s.logger.Error("giving up on notification",
+				"channel", n.Channel, "table", n.Table, "operation", n.Operation,
+				"attempts", f.Attempts, "duration", f.LastAttempt.Sub(f.FirstAttempt), "error", f.Err)
+			report(ctx, f)
+		}
+	}
+	var alerts *listener.RateAlertConfig
+	if cfg.Alerts != nil {
+		alerts = cfg.Alerts.RateAlerts(sinks[cfg.Alerts.Sink].handler)
+	}
+	for _, name := range s.names {
+		// A source removed from the config keeps its routing until
+		// restart.
+		if _, ok := cfg.Sources[name]; !ok && name != config.DefaultSource {
+			continue
+		}
+		src := s.sources[name]
+		srcCfg := cfg.Source(name)
+		srcCfg.Tables = resolvedTables[name]
+		errs = append(errs, src.apply(srcCfg, s.sinks, sinks)...)
+		src.dl.SetRetryPolicy(retry)
+		src.dl.SetQuarantine(quarantine)
+		src.dl.SetRateAlerts(alerts)
+		src.dl.SetErrorHandler(onError)
+	}
+
+	retired := make(map[string]*builtSink)
+	for name, old := range s.sinks {
+		if sinks[name] != old {
+			retired[name] = old
+		}
+	}
+	s.level.Set(level)
+	s.cfg = cfg
+	s.sinks = sinks
+	s.mu.Unlock()
+
+	// The replaced registrations may still be delivering to the retired
+	// sinks; Close waits for those calls, without holding up lookups.
+	for name, old := range retired {
+		if err := old.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close sink %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// apply routes the tables of cfg to the sinks, re-registering tables whose
+// sinks were recreated, and listens on its channels.
+func (src *source) apply(cfg config.Source, old, sinks map[string]*builtSink) []error {
+	var errs []error
+	tables := make(map[tableKey]config.Table, len(cfg.Tables))
+	for _, t := range cfg.Tables {
+		tables[tableKey{t.Channel, t.Tenant, t.Name}] = t
+	}
+	for key := range src.tables {
+		if _, ok := tables[key]; !ok {
+			src.tableSet(key).UnregisterHandler(key.name)
+		}
+	}
+	for key, t := range tables {
+		if prev, ok := src.tables[key]; ok && reflect.DeepEqual(prev, t) && !replaced(t.Sinks, old, sinks) {
+			continue
+		}
+		handler, err := tableHandler(t, sinks)
+		var opts []listener.HandlerOption
+		if err == nil {
+			opts, err = t.HandlerOptions()
+		}
+		if err != nil {
+			// The previous registration stays, and is retried on the next
+			// reload.
+			errs = append(errs, err)
+			if prev, ok := src.tables[key]; ok {
+				tables[key] = prev
+			} else {
+				delete(tables, key)
+			}
+			continue
+		}
+		src.tableSet(key).Handle(t.Name, handler, opts...)
+	}
+	src.tables = tables
+	src.applyTenants(cfg)
+	return append(errs, src.applyChannels(cfg)...)
+}
+
+// applyTenants routes the tenants of the tables routed by tenant to their
+// sets and the other tenants by channel again.
+func (src *source) applyTenants(cfg config.Source) {
+	want := make(map[string]bool)
+	for _, t := range cfg.Tables {
+		if t.Tenant != "" {
+			want[t.Tenant] = true
+			src.dl.SetTenantHandlers(t.Tenant, src.tableSet(tableKey{tenant: t.Tenant}))
+		}
+	}
+	for tenant := range src.tenants {
+		if !want[tenant] {
+			src.dl.RemoveTenantHandlers(tenant)
+			delete(src.tenants, tenant)
+		}
+	}
+}
+
+// tableSet returns the set serving the table of key: the set of its
+// tenant, created on first use, or else of its channel.
+func (src *source) tableSet(key tableKey) *listener.HandlerSet {
+	if key.tenant == "" {
+		return src.handlerSet(key.channel)
+	}
+	set, ok := src.tenants[key.tenant]
+	if !ok {
+		set = listener.NewHandlerSet()
+		src.tenants[key.tenant] = set
+	}
+	return set
+}
+
+// applyChannels listens on the configured channels and stops listening on
+// the others. Channels of tables routed by channel get their own handler
+// set; the rest use the default set.
+func (src *source) applyChannels(cfg config.Source) []error {
+	want := make(map[string]*listener.HandlerSet)
+	for _, ch := range cfg.Listener.Channels {
+		want[ch] = nil
+	}
+	for _, t := range cfg.Tables {
+		if t.Channel != "" {
+			want[t.Channel] = src.handlerSet(t.Channel)
+		}
+	}
+	if len(want) == 0 {
+		want[listener.DefaultChannel] = nil
+	}
+
+	var errs []error
+	for _, ch := range src.dl.Channels() {
+		if _, ok := want[ch]; !ok {
+			if err := src.dl.RemoveChannel(ch); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for ch, set := range want {
+		if err := src.dl.AddChannel(ch, set); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for ch := range src.sets {
+		if want[ch] == nil {
+			delete(src.sets, ch)
+		}
+	}
+	return errs
+}
+
+// handlerSet returns the set serving tables routed to channel, creating
+// it on first use. The empty channel is the default set.
+func (src *source) handlerSet(channel string) *listener.HandlerSet {
+	if channel == "" {
+		return src.dl.Handlers()
+	}
+	set, ok := src.sets[channel]
+	if !ok {
+		set = listener.NewHandlerSet()
+		src.sets[channel] = set
+	}
+	return set
+}
+
+// handler returns the handler n is routed to by the applied config of the
+// default source.
+func (s *service) handler(n *listener.ChangeNotification) (listener.NotificationHandler, bool) {
+	src := s.sources[config.DefaultSource]
+	s.mu.Lock()
+	tenant, byTenant := src.tenants[n.Tenant]
+	set, ok := src.sets[n.Channel]
+	s.mu.Unlock()
+	if byTenant && n.Tenant != "" {
+		if h, ok := tenant.Handler(n.QualifiedTable()); ok {
+			return h, true
+		}
+	}
+	if !ok {
+		set = src.dl.Handlers()
+	}
+	return set.Handler(n.QualifiedTable())
+}
+
+// sink returns the handler of the named sink.
+func (s *service) sink(name string) (listener.NotificationHandler, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.sinks[name]
+	if !ok {
+		return nil, false
+	}
+	return b.handler, true
+}
+
+func (s *service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	for _, b := range s.sinks {
+		if err := b.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// replaced reports whether any of the named sinks was recreated.
+func replaced(names []string, old, cur map[string]*builtSink) bool {
+	for _, name := range names {
+		if old[name] != cur[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func restartRequired(old, cur *config.Config) bool {
+	if !slices.Equal(old.SourceNames(), cur.SourceNames()) {
+		return true
+	}
+	for _, name := range cur.SourceNames() {
+		a, b := old.Source(name), cur.Source(name)
+		a.Listener.Channels, b.Listener.Channels = nil, nil
+		if !reflect.DeepEqual(a.Database, b.Database) || !reflect.DeepEqual(a.Listener, b.Listener) {
+			return true
+		}
+	}
+	return old.HTTP != cur.HTTP || old.Log.Format != cur.Log.Format || old.Secrets != cur.Secrets ||
+		!reflect.DeepEqual(old.Incidents, cur.Incidents)
+}
+
+func logLevel(s string) (slog.Level, error) {
+	level := slog.LevelInfo
+	if s == "" {
+		return level, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log level: %w", err)
+	}
+	return level, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/config"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink/audit"
+)
+
+// replay re-delivers stored events to the sinks their tables are routed to
+// by the config, or to one sink with -target, or prints them as JSON lines
+// with -print. Events are read in id order from the outbox on the
+// configured channels, or from the table of an audit sink with -audit; the
+// consumer offset is not changed.
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "replay [flags]")
+	configPath, flags := configFlags(fs)
+	table := fs.String("outbox-table", listener.DefaultOutboxTable, "outbox table to read")
+	auditSink := fs.String("audit", "", "read the history of this audit sink instead of the outbox")
+	from := fs.String("from", "", "replay events after this outbox id, or changed from this time (2006-01-02, RFC 3339)")
+	to := fs.String("to", "", "replay events up to this outbox id, or changed before this time; now or empty replays to the end")
+	tables := fs.String("table", "", "replay only these comma-separated tables or patterns")
+	target := fs.String("target", "", "deliver to this sink instead of the ones the tables are routed to")
+	rate := fs.Float64("rate", 0, "deliver at most this many events per second; 0 is unlimited")
+	dryRun := fs.Bool("dry-run", false, "log the events and where they would go without delivering them")
+	printOnly := fs.Bool("print", false, "print the events as JSON lines instead of delivering them")
+	fs.Parse(args)
+
+	cfg, logger, level, ok := loadConfig(*configPath, flags)
+	if !ok {
+		return exitConfig
+	}
+	lower, err := parseReplayBound(*from)
+	if err != nil {
+		logger.Error("invalid -from", "error", err)
+		return exitConfig
+	}
+	upper, err := parseReplayBound(*to)
+	if err != nil {
+		logger.Error("invalid -to", "error", err)
+		return exitConfig
+	}
+	if *auditSink != "" && (lower.id > 0 || upper.id > 0) {
+		logger.Error("-from and -to take times with -audit")
+		return exitConfig
+	}
+	if *target != "" {
+		if _, ok := cfg.Sinks[*target]; !ok {
+			logger.Error("unknown -target sink", "sink", *target)
+			return exitConfig
+		}
+	}
+
+	resolver := cfg.Secrets.Resolver()
+	db, err := resolveDatabase(cfg.Database, resolver)
+	if err != nil {
+		logger.Error("failed to resolve secrets", "error", err)
+		return exitConfig
+	}
+
+	opts := append(cfg.Options(), listener.WithLogger(logger), listener.WithOutbox(listener.OutboxConfig{Table: *table}))
+	dl, err := listener.New(db.ConnString(), opts...)
+	if err != nil {
+		logger.Error("failed to create listener", "error", err)
+		return exitFailure
+	}
+	defer dl.Close()
+
+	read := func(ctx context.Context, after int64) ([]*listener.ChangeNotification, error) {
+		return dl.ReadOutbox(ctx, after, listener.DefaultOutboxBatchSize)
+	}
+	if *auditSink != "" {
+		s, ok := cfg.Sinks[*auditSink]
+		if !ok || s.Type != "audit" {
+			logger.Error("-audit does not name an audit sink", "sink", *auditSink)
+			return exitConfig
+		}
+		adb, err := sql.Open("postgres", s.URL)
+		if err != nil {
+			logger.Error("failed to open audit database", "error", err)
+			return exitFailure
+		}
+		defer adb.Close()
+		var aopts []audit.Option
+		if s.Target != "" {
+			aopts = append(aopts, audit.WithTable(s.Target))
+		}
+		history := audit.New(adb, aopts...)
+		read = func(ctx context.Context, after int64) ([]*listener.ChangeNotification, error) {
+			return history.Read(ctx, audit.Query{From: lower.time, To: upper.time, AfterID: after, Limit: listener.DefaultOutboxBatchSize})
+		}
+	}
+
+	svc := newService(*configPath, flags, logger, level, resolver)
+	svc.addSource(config.DefaultSource, dl, nil)
+	if !*printOnly && !*dryRun {
+		if err := svc.apply(cfg); err != nil {
+			logger.Error("failed to apply config", "error", err)
+			svc.Close()
+			return exitConfig
+		}
+		defer svc.Close()
+	}
+	route := func(n *listener.ChangeNotification) (listener.NotificationHandler, bool) {
+		if *target != "" {
+			return svc.sink(*target)
+		}
+		return svc.handler(n)
+	}
+
+	match := tableFilter(*tables)
+	routes := configRoutes(cfg)
+	var pace <-chan time.Time
+	if *rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+		defer t.Stop()
+		pace = t.C
+	}
+
+	ctx := context.Background()
+	enc := json.NewEncoder(os.Stdout)
+	var replayed, failed int
+loop:
+	for after := lower.id; ; {
+		events, err := read(ctx, after)
+		if err != nil {
+			logger.Error("failed to read events", "error", err)
+			return exitFailure
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, n := range events {
+			if upper.id > 0 && n.ID > upper.id {
+				break loop
+			}
+			if n.Commit || !match(n) || !lower.admits(n) || !upper.precedes(n) {
+				continue
+			}
+			if *printOnly {
+				enc.Encode(n)
+				replayed++
+				continue
+			}
+			if *dryRun {
+				sinks := *target
+				if sinks == "" {
+					sinks = strings.Join(routes(n), ",")
+				}
+				logger.Info("would replay event", "id", n.ID, "table", n.QualifiedTable(), "operation", n.Operation,
+					"timestamp", n.Timestamp, "sinks", sinks)
+				replayed++
+				continue
+			}
+			h, ok := route(n)
+			if !ok {
+				continue
+			}
+			if pace != nil {
+				<-pace
+			}
+			if err := h.HandleNotification(ctx, n); err != nil {
+				logger.Error("failed to replay event", "id", n.ID, "table", n.QualifiedTable(), "error", err)
+				failed++
+				continue
+			}
+			replayed++
+		}
+		after = events[len(events)-1].ID
+	}
+
+	logger.Info("replay finished", "replayed", replayed, "failed", failed, "dry_run", *dryRun)
+	if failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// replayBound is a -from or -to flag: an outbox id or a time.
+type replayBound struct {
+	id   int64
+	time time.Time
+}
+
+func parseReplayBound(v string) (replayBound, error) {
+	if v == "" || v == "now" {
+		return replayBound{}, nil
+	}
+	if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return replayBound{id: id}, nil
+	}
+	t, err := parseTime(v)
+	if err != nil {
+		return replayBound{}, fmt.Errorf("%q is neither an id nor a time", v)
+	}
+	return replayBound{time: t}, nil
+}
+
+// parseTime parses a point in time given as RFC 3339, or as a date with
+// an optional time of day in the local time zone.
+func parseTime(v string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, want RFC 3339 or 2006-01-02 [15:04:05]", v)
+}
+
+// admits reports whether n changed at or after the lower bound b.
+func (b replayBound) admits(n *listener.ChangeNotification) bool {
+	return b.time.IsZero() || !n.Timestamp.Before(b.time)
+}
+
+// precedes reports whether n changed before the upper bound b.
+func (b replayBound) precedes(n *listener.ChangeNotification) bool {
+	return b.time.IsZero() || n.Timestamp.Before(b.time)
+}
+
+// routedSinks stands in for the handler of a config table in configRoutes.
+type routedSinks []string
+
+func (routedSinks) HandleNotification(context.Context, *listener.ChangeNotification) error {
+	return nil
+}
+
+// configRoutes returns the sinks of the config table a notification is
+// routed to, by the precedence of handler registrations, without building
+// the sinks. Tenants are not considered.
+func configRoutes(cfg *config.Config) func(*listener.ChangeNotification) []string {
+	set := listener.NewHandlerSet()
+	for _, t := range cfg.Tables {
+		if t.Tenant == "" {
+			set.Handle(t.Name, routedSinks(t.Sinks))
+		}
+	}
+	return func(n *listener.ChangeNotification) []string {
+		h, ok := set.Handler(n.QualifiedTable())
+		if !ok {
+			return nil
+		}
+		sinks, _ := h.(routedSinks)
+		return sinks
+	}
+}
+
+// tableFilter matches the notifications of the comma-separated tables or
+// glob patterns in list, all of them when list is empty. Like handler
+// registrations, names without a schema are in listener.DefaultSchema.
+func tableFilter(list string) func(*listener.ChangeNotification) bool {
+	var patterns []string
+	for p := range strings.SplitSeq(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.Contains(p, ".") {
+			p = listener.DefaultSchema + "." + p
+		}
+		patterns = append(patterns, p)
+	}
+	return func(n *listener.ChangeNotification) bool {
+		if len(patterns) == 0 {
+			return true
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, n.QualifiedTable()); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -6,24 +6,36 @@ RETURNS TRIGGER AS $$
 DECLARE
     payload JSON;
     row_data JSON;
+    old_data JSON;
+    channel TEXT := 'data_changes';
 BEGIN
-    -- 根据操作类型选择 OLD 或 NEW
+    -- 触发器参数可指定 channel，例如 generic_table_notify('tenant_a')
+    IF TG_NARGS > 0 THEN
+        channel = TG_ARGV[0];
+    END IF;
+
+    -- 根据操作类型选择 OLD 或 NEW；UPDATE 额外携带变更前的 OLD
     IF TG_OP = 'DELETE' THEN
         row_data = row_to_json(OLD);
     ELSE
         row_data = row_to_json(NEW);
     END IF;
+    IF TG_OP = 'UPDATE' THEN
+        old_data = row_to_json(OLD);
+    END IF;
     
     -- 构建通知 payload
     payload = json_build_object(
+        'schema', TG_TABLE_SCHEMA,
         'table', TG_TABLE_NAME,
         'operation', TG_OP,
         'data', row_data,
+        'old', old_data,
         'timestamp', CURRENT_TIMESTAMP
     );
     
-    -- 发送到统一 channel
-    PERFORM pg_notify('data_changes', payload::text);
+    -- 发送到指定 channel（默认 data_changes）
+    PERFORM pg_notify(channel, payload::text);
     RETURN NULL;
 END;
 $$ LANGUAGE plpgsql;
@@ -0,0 +1,447 @@
+// Package script filters, transforms and routes change notifications with
+// small Lua scripts, so light custom logic is configured rather than
+// compiled in. A script defines
+//
+//	function handle(change)
+//		if change.data.status == "draft" then
+//			return false
+//		end
+//		change.data.email = nil
+//		if change.data.amount > 1000 then
+//			return {"audit", "alerts"}
+//		end
+//	end
+//
+// change holds the fields of the notification JSON, with the rows key,
+// data, old and new as tables. The script may modify the rows; data is the
+// same table as new for inserts and updates and as old for deletes. handle
+// returns nil or true to pass the change on to every handler, false to
+// drop it, or a handler name or list of names to route it.
+//
+// Scripts run sandboxed: only the base, string, table and math libraries
+// are available, without the functions loading code or printing, and a
+// call is aborted once its ctx is done, e.g. on the handler timeout.
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// Script is a compiled script. It is safe for concurrent use; each call
+// runs in a Lua state of its own.
+type Script struct {
+	proto  *lua.FunctionProto
+	states sync.Pool
+}
+
+// Compile parses the script src and checks that it defines handle.
+func Compile(src string) (*Script, error) {
+	chunk, err := parse.Parse(strings.NewReader(src), "script")
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, "script")
+	if err != nil {
+		return nil, err
+	}
+	s := &Script{proto: proto}
+	L, err := s.newState()
+	if err != nil {
+		return nil, err
+	}
+	s.states.Put(L)
+	return s, nil
+}
+
+// sandbox lists the libraries scripts can use.
+var sandbox = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.StringLibName, lua.OpenString},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// newState returns a sandboxed state that ran the script.
+func (s *Script) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range sandbox {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "print", "module", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if L.GetGlobal("handle").Type() != lua.LTFunction {
+		L.Close()
+		return nil, errors.New("script does not define function handle")
+	}
+	return L, nil
+}
+
+// Result is what the script decided for a notification.
+type Result struct {
+	// Drop is set when the notification should not be delivered.
+	Drop bool
+	// Routes are the names of the handlers chosen, or nil for all.
+	Routes []string
+	// Notification is the notification with the rows the script
+	// modified.
+	Notification *listener.ChangeNotification
+}
+
+// Run calls handle with n. ctx being done aborts the call.
+func (s *Script) Run(ctx context.Context, n *listener.ChangeNotification) (Result, error) {
+	L, _ := s.states.Get().(*lua.LState)
+	if L == nil {
+		var err error
+		if L, err = s.newState(); err != nil {
+			return Result{}, err
+		}
+	}
+	L.SetContext(ctx)
+
+	change, rows, err := toChange(L, n)
+	if err != nil {
+		L.Close()
+		return Result{}, err
+	}
+	err = L.CallByParam(lua.P{Fn: L.GetGlobal("handle"), NRet: 1, Protect: true}, change)
+	if err != nil {
+		// A canceled state cannot run again.
+		L.Close()
+		if ctx.Err() != nil {
+			return Result{}, ctx.Err()
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			err = errors.New(apiErr.Object.String())
+		}
+		return Result{}, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	L.RemoveContext()
+	s.states.Put(L)
+
+	res := Result{Notification: n}
+	switch ret := ret.(type) {
+	case *lua.LNilType:
+	case lua.LBool:
+		res.Drop = !bool(ret)
+	case lua.LString:
+		res.Routes = []string{string(ret)}
+	case *lua.LTable:
+		res.Drop = ret.Len() == 0
+		for i := 1; i <= ret.Len(); i++ {
+			name, ok := ret.RawGetInt(i).(lua.LString)
+			if !ok {
+				return Result{}, fmt.Errorf("handle returned a list of %s, not string", ret.RawGetInt(i).Type())
+			}
+			res.Routes = append(res.Routes, string(name))
+		}
+	default:
+		return Result{}, fmt.Errorf("handle returned %s, not nil, boolean, string or list", ret.Type())
+	}
+	if !res.Drop {
+		if res.Notification, err = fromChange(change, n, rows); err != nil {
+			return Result{}, err
+		}
+	}
+	return res, nil
+}
+
+// rowFields are the fields of change holding rows, which a script may
+// modify.
+var rowFields = []string{"key", "data", "old", "new"}
+
+// toChange converts n to the table the script receives, returning the row
+// tables by field.
+func toChange(L *lua.LState, n *listener.ChangeNotification) (*lua.LTable, map[string]lua.LValue, error) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, nil, err
+	}
+	change := L.NewTable()
+	rows := make(map[string]lua.LValue)
+	for k, v := range doc {
+		lv := toLua(L, v)
+		change.RawSetString(k, lv)
+		if _, ok := v.(map[string]any); ok && slices.Contains(rowFields, k) {
+			rows[k] = lv
+		}
+	}
+	// The images equal to data share its table, so a script changing
+	// data changes them too.
+	for _, image := range []struct {
+		name string
+		raw  json.RawMessage
+	}{{"new", n.New}, {"old", n.Old}} {
+		if rows["data"] != nil && rows[image.name] != nil && string(image.raw) == string(n.Data) {
+			rows[image.name] = rows["data"]
+			change.RawSetString(image.name, rows["data"])
+		}
+	}
+	return change, rows, nil
+}
+
+// fromChange returns a copy of n with the rows of change.
+func fromChange(change *lua.LTable, n *listener.ChangeNotification, rows map[string]lua.LValue) (*listener.ChangeNotification, error) {
+	m := *n
+	for _, field := range []struct {
+		name string
+		dst  *json.RawMessage
+	}{{"key", &m.Key}, {"data", &m.Data}, {"old", &m.Old}, {"new", &m.New}} {
+		lv := change.RawGetString(field.name)
+		if _, ok := rows[field.name]; !ok && lv == lua.LNil {
+			continue
+		}
+		if lv == lua.LNil {
+			*field.dst = nil
+			continue
+		}
+		v, err := fromLua(lv)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		row, err := encodeRow(*field.dst, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.dst = row
+	}
+	return &m, nil
+}
+
+// encodeRow encodes v, the row orig converted to Lua and back. Columns
+// keep their order and, unless modified, their encoding, e.g. the digits
+// of numbers a Lua number cannot hold exactly; null columns are kept. New
+// columns come last.
+func encodeRow(orig json.RawMessage, v any) (json.RawMessage, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return json.Marshal(v)
+	}
+	var cols []string
+	values := make(map[string]json.RawMessage)
+	dec := json.NewDecoder(bytes.NewReader(orig))
+	if tok, err := dec.Token(); err == nil && tok == json.Delim('{') {
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				break
+			}
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				break
+			}
+			cols = append(cols, tok.(string))
+			values[tok.(string)] = raw
+		}
+	}
+	for _, col := range slices.Sorted(maps.Keys(obj)) {
+		if _, ok := values[col]; !ok {
+			cols = append(cols, col)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for _, col := range cols {
+		var value json.RawMessage
+		if e, ok := obj[col]; ok {
+			var err error
+			if value, err = json.Marshal(e); err != nil {
+				return nil, err
+			}
+			if raw, ok := values[col]; ok && string(value) == canonical(raw) {
+				value = raw
+			}
+		} else if raw := values[col]; string(raw) == "null" {
+			// Lua has no null, so the column reads as nil either way.
+			value = raw
+		} else {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(col)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// canonical returns value encoded as a value converted from Lua is.
+func canonical(value json.RawMessage) string {
+	var v any
+	if json.Unmarshal(value, &v) != nil {
+		return ""
+	}
+	body, _ := json.Marshal(integers(v))
+	return string(body)
+}
+
+// integers converts the integral numbers in v to int64, as fromLua does.
+func integers(v any) any {
+	switch v := v.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = integers(e)
+		}
+	case map[string]any:
+		for k, e := range v {
+			v[k] = integers(e)
+		}
+	}
+	return v
+}
+
+func toLua(L *lua.LState, v any) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []any:
+		t := L.CreateTable(len(v), 0)
+		for _, e := range v {
+			t.Append(toLua(L, e))
+		}
+		return t
+	case map[string]any:
+		t := L.CreateTable(0, len(v))
+		for k, e := range v {
+			t.RawSetString(k, toLua(L, e))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// fromLua converts a value back to JSON values. Tables with the keys 1 to
+// n are arrays, others objects; an empty table is an empty object.
+func fromLua(lv lua.LValue) (any, error) {
+	switch lv := lv.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(lv), nil
+	case lua.LNumber:
+		f := float64(lv)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("number %v is not valid JSON", f)
+		}
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f), nil
+		}
+		return f, nil
+	case lua.LString:
+		return string(lv), nil
+	case *lua.LTable:
+		if n := lv.Len(); n > 0 {
+			arr := make([]any, n)
+			for i := range arr {
+				v, err := fromLua(lv.RawGetInt(i + 1))
+				if err != nil {
+					return nil, err
+				}
+				arr[i] = v
+			}
+			return arr, nil
+		}
+		obj := make(map[string]any)
+		var err error
+		lv.ForEach(func(k, v lua.LValue) {
+			if err != nil {
+				return
+			}
+			key, ok := k.(lua.LString)
+			if !ok {
+				err = fmt.Errorf("table key %s is not a string", k.Type())
+				return
+			}
+			obj[string(key)], err = fromLua(v)
+		})
+		return obj, err
+	}
+	return nil, fmt.Errorf("%s is not a JSON value", lv.Type())
+}
+
+// Handler runs s on each notification and delivers the result to
+// handlers: every one of them unless the script routes it. Dropped
+// notifications are acknowledged. Script errors and routes to unknown
+// names fail permanently, so the notification reaches the dead letter
+// queue. As with listener.RouteHandler, a failing handler fails the
+// notification and the retry delivers it to all chosen handlers again.
+func Handler(s *Script, handlers map[string]listener.NotificationHandler) listener.NotificationHandler {
+	all := slices.Sorted(maps.Keys(handlers))
+	return listener.HandlerFunc(func(ctx context.Context, n *listener.ChangeNotification) error {
+		res, err := s.Run(ctx, n)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("script failed: %w", err)
+			}
+			return listener.Permanent(fmt.Errorf("script failed: %w", err))
+		}
+		if res.Drop {
+			return nil
+		}
+		names := res.Routes
+		if names == nil {
+			names = all
+		}
+		var errs []error
+		parts, done := listener.Parts(ctx, len(names))
+		for i, name := range names {
+			h, ok := handlers[name]
+			if !ok {
+				errs = append(errs, listener.Permanent(fmt.Errorf("script routed to %q, which is not a handler", name)))
+				done(i)
+				continue
+			}
+			if err := h.HandleNotification(parts[i], res.Notification); err != nil {
+				errs = append(errs, err)
+			}
+			done(i)
+		}
+		return errors.Join(errs...)
+	})
+}
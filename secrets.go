@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/force-c/pg-data-listener/config"
+	"github.com/force-c/pg-data-listener/secrets"
+)
+
+// secretsTimeout bounds fetching the referenced secrets.
+const secretsTimeout = 30 * time.Second
+
+// resolveDatabase returns d with a referenced password fetched.
+func resolveDatabase(d config.Database, r *secrets.Resolver) (config.Database, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+	return d.ResolveSecrets(ctx, r)
+}
+
+// rotatingPassword serves a referenced database password to new
+// connections, so they use the latest one fetched.
+type rotatingPassword struct {
+	value atomic.Pointer[string]
+}
+
+func newRotatingPassword(v string) *rotatingPassword {
+	p := &rotatingPassword{}
+	p.value.Store(&v)
+	return p
+}
+
+func (p *rotatingPassword) get(context.Context, string, uint16, string) (string, error) {
+	return *p.value.Load(), nil
+}
+
+// set stores v, reporting whether it changed.
+func (p *rotatingPassword) set(v string) bool {
+	return *p.value.Swap(&v) != v
+}
+
+// refreshSecrets fetches the referenced secrets again. A rotated database
+// password reconnects the capture source, rotated sink secrets recreate
+// the sinks using them.
+func (s *service) refreshSecrets() error {
+	s.mu.Lock()
+	cfg := s.cfg
+	s.mu.Unlock()
+	if cfg == nil || !cfg.UsesSecrets(s.secrets) {
+		return nil
+	}
+
+	var errs []error
+	for _, name := range s.names {
+		src := s.sources[name]
+		if src.password == nil {
+			continue
+		}
+		if d, err := resolveDatabase(cfg.Source(name).Database, s.secrets); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		} else if src.password.set(d.Password) {
+			s.logger.Info("database password rotated, reconnecting", "source", name)
+			src.dl.Reconnect()
+		}
+	}
+	if err := s.apply(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
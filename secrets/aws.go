@@ -0,0 +1,57 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// SecretsManagerAPI is the part of *secretsmanager.Client the backend
+// uses.
+type SecretsManagerAPI interface {
+	GetSecretValue(ctx context.Context, in *secretsmanager.GetSecretValueInput, opts ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager. The name is
+// the secret's name or ARN; binary secrets are returned decoded.
+type AWSSecretsManager struct {
+	client SecretsManagerAPI
+}
+
+// NewAWSSecretsManager reads secrets in region with the SDK's default
+// config: credentials from its default chain, refreshed before they
+// expire, and its region, e.g. AWS_REGION, when region is empty.
+func NewAWSSecretsManager(ctx context.Context, region string) (*AWSSecretsManager, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("region is required (set AWS_REGION)")
+	}
+	return NewAWSSecretsManagerWithClient(secretsmanager.NewFromConfig(cfg)), nil
+}
+
+// NewAWSSecretsManagerWithClient reads secrets with client.
+func NewAWSSecretsManagerWithClient(client SecretsManagerAPI) *AWSSecretsManager {
+	return &AWSSecretsManager{client: client}
+}
+
+func (m *AWSSecretsManager) Fetch(ctx context.Context, name string) ([]byte, error) {
+	out, err := m.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	if out.SecretString != nil {
+		return []byte(*out.SecretString), nil
+	}
+	return out.SecretBinary, nil
+}
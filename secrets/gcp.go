@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// GCPSecretManager reads secrets from Google Cloud Secret Manager. The
+// name is a secret of the project, optionally with a version, as in
+// pgdl-password or pgdl-password/versions/3, or a full resource name
+// starting with projects/. Without a version the latest one is read.
+type GCPSecretManager struct {
+	project string
+	client  *http.Client
+}
+
+// NewGCPSecretManager reads the secrets of project with the application
+// default credentials.
+func NewGCPSecretManager(ctx context.Context, project string) (*GCPSecretManager, error) {
+	ts, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default credentials: %w", err)
+	}
+	return NewGCPSecretManagerWithTokenSource(project, ts), nil
+}
+
+// NewGCPSecretManagerWithTokenSource authenticates with tokens from ts.
+func NewGCPSecretManagerWithTokenSource(project string, ts oauth2.TokenSource) *GCPSecretManager {
+	return &GCPSecretManager{project: project, client: oauth2.NewClient(context.Background(), ts)}
+}
+
+func (m *GCPSecretManager) Fetch(ctx context.Context, name string) ([]byte, error) {
+	if !strings.HasPrefix(name, "projects/") {
+		if m.project == "" {
+			return nil, errors.New("project is required for secret " + name)
+		}
+		name = "projects/" + m.project + "/secrets/" + name
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://secretmanager.googleapis.com/v1/"+name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secret manager returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse secret manager response: %w", err)
+	}
+	return out.Payload.Data, nil
+}
@@ -0,0 +1,126 @@
+// Package secrets resolves credentials kept in a secrets backend:
+// HashiCorp Vault, AWS Secrets Manager or GCP Secret Manager. A reference
+// names the backend, the secret and, for secrets holding a JSON object, a
+// field:
+//
+//	vault://secret/data/pgdl#password
+//	awssm://prod/pgdl#password
+//	gcpsm://pgdl-webhook-secret
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Backend fetches the current value of a secret by name.
+type Backend interface {
+	Fetch(ctx context.Context, name string) ([]byte, error)
+}
+
+// Schemes of the built-in backends.
+const (
+	SchemeVault = "vault"
+	SchemeAWS   = "awssm"
+	SchemeGCP   = "gcpsm"
+)
+
+// Resolver resolves references with the backends registered for their
+// schemes. Backends are created on first use, so only the referenced ones
+// need to be configured.
+type Resolver struct {
+	mu       sync.Mutex
+	backends map[string]func() (Backend, error)
+	created  map[string]Backend
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{backends: make(map[string]func() (Backend, error)), created: make(map[string]Backend)}
+}
+
+// Register serves the references of scheme with the backend newBackend
+// creates.
+func (r *Resolver) Register(scheme string, newBackend func() (Backend, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backends[scheme] = newBackend
+	delete(r.created, scheme)
+}
+
+// IsRef reports whether v is a reference to a registered backend.
+func (r *Resolver) IsRef(v string) bool {
+	scheme, _, ok := strings.Cut(v, "://")
+	if !ok {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok = r.backends[scheme]
+	return ok
+}
+
+// Resolve returns the secret v references, or v itself when it is not a
+// reference.
+func (r *Resolver) Resolve(ctx context.Context, v string) (string, error) {
+	if !r.IsRef(v) {
+		return v, nil
+	}
+	scheme, rest, _ := strings.Cut(v, "://")
+	name, field, _ := strings.Cut(rest, "#")
+	if name == "" {
+		return "", fmt.Errorf("secret reference %s names no secret", v)
+	}
+
+	backend, err := r.backend(scheme)
+	if err != nil {
+		return "", fmt.Errorf("%s backend: %w", scheme, err)
+	}
+	data, err := backend.Fetch(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch secret %s: %w", name, err)
+	}
+	if field == "" {
+		return string(data), nil
+	}
+	value, err := jsonField(data, field)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", name, err)
+	}
+	return value, nil
+}
+
+func (r *Resolver) backend(scheme string) (Backend, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.created[scheme]; ok {
+		return b, nil
+	}
+	b, err := r.backends[scheme]()
+	if err != nil {
+		return nil, err
+	}
+	r.created[scheme] = b
+	return b, nil
+}
+
+// jsonField returns a field of a JSON object; strings are returned
+// unquoted.
+func jsonField(data []byte, field string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", errors.New("not a JSON object")
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("no field %q", field)
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Vault reads secrets from HashiCorp Vault's HTTP API. The name is the
+// API path below /v1, e.g. secret/data/pgdl for the KV version 2 secret
+// pgdl of the secret mount; the secret's data is returned as a JSON
+// object.
+type Vault struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// NewVault connects to the Vault server at addr with token, VAULT_ADDR
+// and VAULT_TOKEN when empty. client is http.DefaultClient when nil.
+func NewVault(addr, token string, client *http.Client) (*Vault, error) {
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if addr == "" || token == "" {
+		return nil, errors.New("address and token are required (set VAULT_ADDR and VAULT_TOKEN)")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Vault{addr: strings.TrimSuffix(addr, "/"), token: token, client: client}, nil
+}
+
+func (v *Vault) Fetch(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.addr+"/v1/"+strings.TrimPrefix(name, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var out struct {
+		Data struct {
+			// Data and Metadata are set by KV version 2.
+			Data     json.RawMessage `json:"data"`
+			Metadata json.RawMessage `json:"metadata"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse vault response: %w", err)
+	}
+	if out.Data.Data != nil && out.Data.Metadata != nil {
+		return out.Data.Data, nil
+	}
+	// KV version 1 and other engines return the data directly.
+	var v1 struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &v1); err != nil {
+		return nil, fmt.Errorf("failed to parse vault response: %w", err)
+	}
+	return v1.Data, nil
+}
@@ -0,0 +1,281 @@
+// Package archive buffers change notifications and writes them to object
+// storage as newline-delimited JSON or Parquet files, partitioned by table
+// and date, for long-term retention and replay.
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/force-c/pg-data-listener/encrypt"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	// DefaultPartition lays files out Hive style, one directory per table
+	// and day.
+	DefaultPartition     = "{schema}.{table}/date={date}"
+	DefaultFileRecords   = 50000
+	DefaultFileBytes     = 64 << 20
+	DefaultFlushInterval = 5 * time.Minute
+
+	// hiveDefaultPartition names the partition of null values, as Hive
+	// does.
+	hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+)
+
+// Sink writes one file per partition each time the buffer is flushed,
+// whichever of the record count, byte size or flush interval comes first.
+// Handler calls return once the notification is buffered; files that fail
+// to upload are reported to the WithErrorHandler callback.
+type Sink struct {
+	store     Store
+	format    Format
+	partition string
+	// columns are the row columns partition names.
+	columns []string
+	prefix  string
+	cfg     sink.BatchConfig
+	cipher  encrypt.Cipher
+	seq     atomic.Uint64
+	batcher *sink.Batcher
+}
+
+type Option func(*Sink)
+
+func WithFormat(f Format) Option {
+	return func(s *Sink) {
+		s.format = f
+	}
+}
+
+// WithPartition sets the directory template. Besides the sink.Template
+// placeholders it supports {date} (YYYY-MM-DD) and {hour} (HH) of the
+// change timestamp in UTC, and any other {column} with the value of the
+// row's column, e.g. "{schema}.{table}/region={region}/date={date}"; a
+// missing or null value is __HIVE_DEFAULT_PARTITION__.
+func WithPartition(pattern string) Option {
+	return func(s *Sink) {
+		s.partition = pattern
+	}
+}
+
+// WithPrefix prepends prefix to every object name.
+func WithPrefix(prefix string) Option {
+	return func(s *Sink) {
+		s.prefix = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithFileLimits caps the records and approximate bytes per flush.
+func WithFileLimits(records, bytes int) Option {
+	return func(s *Sink) {
+		s.cfg.Size = records
+		s.cfg.MaxBytes = bytes
+	}
+}
+
+// WithEncryption encrypts every file with c before it is written; the
+// names get the cipher's extension. To encrypt only some columns, wrap
+// the sink with encrypt.Handler instead.
+func WithEncryption(c encrypt.Cipher) Option {
+	return func(s *Sink) {
+		s.cipher = c
+	}
+}
+
+func WithFlushInterval(d time.Duration) Option {
+	return func(s *Sink) {
+		s.cfg.Linger = d
+	}
+}
+
+// WithErrorHandler receives the notifications that could not be archived.
+func WithErrorHandler(fn func(n *listener.ChangeNotification, err error)) Option {
+	return func(s *Sink) {
+		s.cfg.OnError = func(item *sink.BatchItem) {
+			fn(item.Notification, item.Err)
+		}
+	}
+}
+
+func New(store Store, opts ...Option) *Sink {
+	s := &Sink{
+		store:     store,
+		format:    NDJSON{},
+		partition: DefaultPartition,
+		cfg: sink.BatchConfig{
+			Size:     DefaultFileRecords,
+			MaxBytes: DefaultFileBytes,
+			Linger:   DefaultFlushInterval,
+			Async:    true,
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.columns = partitionColumns(s.partition)
+	if p, ok := s.format.(Parquet); ok {
+		p.omit = s.columns
+		s.format = p
+	}
+	s.batcher = sink.NewBatcher(s.cfg, s.flush)
+	return s
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := encode(n)
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	return s.batcher.Add(ctx, n, body)
+}
+
+// HandleBatch writes batch as one file per partition, for
+// listener.NewBatching.
+func (s *Sink) HandleBatch(ctx context.Context, batch []*listener.ChangeNotification) error {
+	return sink.FlushBatch(ctx, batch, encode, s.flush)
+}
+
+// encode returns the JSON of n. The encoded size only drives the file
+// size limit; formats encode the notifications themselves.
+func encode(n *listener.ChangeNotification) ([]byte, error) {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode notification: %w", err)
+	}
+	return body, nil
+}
+
+func (s *Sink) dir(n *listener.ChangeNotification) string {
+	ts := n.Timestamp.UTC()
+	replace := []string{
+		"{date}", ts.Format("2006-01-02"),
+		"{hour}", ts.Format("15"),
+	}
+	if len(s.columns) > 0 {
+		row := partitionRow(n)
+		for _, col := range s.columns {
+			replace = append(replace, "{"+col+"}", partitionValue(row[col]))
+		}
+	}
+	pattern := strings.NewReplacer(replace...).Replace(s.partition)
+	dir := sink.Template(pattern)(n)
+	if s.prefix != "" {
+		dir = s.prefix + "/" + dir
+	}
+	return dir
+}
+
+// partitionColumns returns the column placeholders of pattern.
+func partitionColumns(pattern string) []string {
+	var cols []string
+	for {
+		open := strings.IndexByte(pattern, '{')
+		if open < 0 {
+			return cols
+		}
+		end := strings.IndexByte(pattern[open:], '}')
+		if end < 0 {
+			return cols
+		}
+		name := pattern[open+1 : open+end]
+		switch name {
+		case "", "schema", "table", "op", "channel", "date", "hour":
+		default:
+			if !slices.Contains(cols, name) {
+				cols = append(cols, name)
+			}
+		}
+		pattern = pattern[open+end+1:]
+	}
+}
+
+// partitionRow decodes the row of n, the old one for deletes.
+func partitionRow(n *listener.ChangeNotification) map[string]json.RawMessage {
+	data := n.Data
+	if n.Operation == listener.OpDelete && n.Old != nil {
+		data = n.Old
+	}
+	var row map[string]json.RawMessage
+	json.Unmarshal(data, &row)
+	return row
+}
+
+func partitionValue(raw json.RawMessage) string {
+	if isNull(raw) {
+		return hiveDefaultPartition
+	}
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		s = string(bytes.TrimSpace(raw))
+	}
+	if s == "" {
+		return hiveDefaultPartition
+	}
+	return url.PathEscape(s)
+}
+
+func (s *Sink) flush(ctx context.Context, items []*sink.BatchItem) {
+	partitions := make(map[string][]*sink.BatchItem)
+	var order []string
+	for _, item := range items {
+		dir := s.dir(item.Notification)
+		if _, ok := partitions[dir]; !ok {
+			order = append(order, dir)
+		}
+		partitions[dir] = append(partitions[dir], item)
+	}
+
+	for _, dir := range order {
+		part := partitions[dir]
+		if err := s.write(ctx, dir, part); err != nil {
+			for _, item := range part {
+				item.Err = err
+			}
+		}
+	}
+}
+
+func (s *Sink) write(ctx context.Context, dir string, items []*sink.BatchItem) error {
+	ns := make([]*listener.ChangeNotification, len(items))
+	for i, item := range items {
+		ns[i] = item.Notification
+	}
+	body, err := s.format.Encode(ns)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", dir, err)
+	}
+
+	ext, contentType := s.format.Extension(), s.format.ContentType()
+	if s.cipher != nil {
+		if body, err = s.cipher.Encrypt(ctx, body); err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", dir, err)
+		}
+		ext += s.cipher.Extension()
+		contentType = "application/octet-stream"
+	}
+
+	// Names sort by flush time; the sequence keeps them unique within a
+	// process.
+	name := fmt.Sprintf("%s/part-%s-%06d%s", dir,
+		time.Now().UTC().Format("20060102T150405.000Z"), s.seq.Add(1), ext)
+	if err := s.store.Put(ctx, name, body, contentType); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Close writes the buffered notifications.
+func (s *Sink) Close() error {
+	s.batcher.Close()
+	return nil
+}
@@ -0,0 +1,256 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// ColumnType is the type of a row column in a Parquet file.
+type ColumnType int
+
+const (
+	// StringColumn holds strings; other JSON values are stored as their
+	// JSON text.
+	StringColumn ColumnType = iota
+	Int64Column
+	DoubleColumn
+	BoolColumn
+	// JSONColumn holds arrays and objects as JSON text of the JSON
+	// logical type.
+	JSONColumn
+	// TimestampColumn holds timestamps sent as RFC 3339 strings, dates or
+	// timestamps without time zone as PostgreSQL renders them in JSON, in
+	// microseconds since the epoch, UTC.
+	TimestampColumn
+)
+
+// unknownColumn is the type of a column that was null so far.
+const unknownColumn ColumnType = -1
+
+// Schema maps the columns of a table to their types.
+type Schema map[string]ColumnType
+
+// metadataColumns are the columns of every record in Columns mode.
+var metadataColumns = []string{"_id", "_operation", "_schema", "_table", "_timestamp"}
+
+// timestampLayouts are the forms of timestamps TimestampColumn reads.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	time.DateOnly,
+}
+
+func (f Parquet) encodeColumns(ns []*listener.ChangeNotification) ([]byte, error) {
+	rows := make([]map[string]json.RawMessage, len(ns))
+	types := make(map[string]ColumnType)
+	fixed := make(map[string]bool)
+	for i, n := range ns {
+		data := n.Data
+		if n.Operation == listener.OpDelete && n.Old != nil {
+			data = n.Old
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &rows[i]); err != nil {
+				return nil, fmt.Errorf("failed to decode %s row: %w", n.QualifiedTable(), err)
+			}
+		}
+		schema, ok := f.Schemas[n.QualifiedTable()]
+		if !ok && n.QualifiedTable() == listener.DefaultSchema+"."+n.Table {
+			schema = f.Schemas[n.Table]
+		}
+		for col, v := range rows[i] {
+			if slices.Contains(f.omit, col) || slices.Contains(metadataColumns, col) {
+				delete(rows[i], col)
+				continue
+			}
+			if fixed[col] {
+				continue
+			}
+			if typ, ok := schema[col]; ok {
+				types[col], fixed[col] = typ, true
+				continue
+			}
+			types[col] = mergeType(types, col, v)
+		}
+	}
+
+	group := parquet.Group{
+		"_id":        parquet.Leaf(parquet.Int64Type),
+		"_schema":    parquet.String(),
+		"_table":     parquet.String(),
+		"_operation": parquet.String(),
+		"_timestamp": parquet.Timestamp(parquet.Microsecond),
+	}
+	for col, typ := range types {
+		group[col] = parquet.Optional(columnNode(typ))
+	}
+	schema := parquet.NewSchema("change", group)
+	fields := group.Fields()
+
+	out := make([]parquet.Row, len(ns))
+	for i, n := range ns {
+		row := make(parquet.Row, len(fields))
+		for j, field := range fields {
+			name := field.Name()
+			var v parquet.Value
+			switch name {
+			case "_id":
+				v = parquet.Int64Value(n.ID)
+			case "_schema":
+				v = parquet.ByteArrayValue([]byte(sink.Attributes(n)["schema"]))
+			case "_table":
+				v = parquet.ByteArrayValue([]byte(n.Table))
+			case "_operation":
+				v = parquet.ByteArrayValue([]byte(n.Operation))
+			case "_timestamp":
+				v = parquet.Int64Value(n.Timestamp.UnixMicro())
+			default:
+				raw, ok := rows[i][name]
+				if !ok || isNull(raw) {
+					row[j] = parquet.NullValue().Level(0, 0, j)
+					continue
+				}
+				val, err := columnValue(types[name], raw)
+				if err != nil {
+					return nil, fmt.Errorf("column %s of %s: %w", name, n.QualifiedTable(), err)
+				}
+				row[j] = val.Level(0, 1, j)
+				continue
+			}
+			row[j] = v.Level(0, 0, j)
+		}
+		out[i] = row
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewWriter(&buf, append(f.writerOptions(), schema)...)
+	if _, err := w.WriteRows(out); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mergeType widens the type inferred for col so far with the value v:
+// integers and floats make a double column, and any other mix a string
+// column.
+func mergeType(types map[string]ColumnType, col string, v json.RawMessage) ColumnType {
+	prev, seen := types[col]
+	if isNull(v) {
+		if !seen {
+			return unknownColumn
+		}
+		return prev
+	}
+	typ := inferColumnType(v)
+	switch {
+	case !seen || prev == unknownColumn || prev == typ:
+		return typ
+	case (prev == Int64Column || prev == DoubleColumn) && (typ == Int64Column || typ == DoubleColumn):
+		return DoubleColumn
+	}
+	return StringColumn
+}
+
+func inferColumnType(v json.RawMessage) ColumnType {
+	v = bytes.TrimSpace(v)
+	switch v[0] {
+	case '"':
+		return StringColumn
+	case 't', 'f':
+		return BoolColumn
+	case '{', '[':
+		return JSONColumn
+	}
+	var num json.Number
+	if json.Unmarshal(v, &num) == nil {
+		if _, err := num.Int64(); err == nil {
+			return Int64Column
+		}
+	}
+	return DoubleColumn
+}
+
+func columnNode(typ ColumnType) parquet.Node {
+	switch typ {
+	case Int64Column:
+		return parquet.Leaf(parquet.Int64Type)
+	case DoubleColumn:
+		return parquet.Leaf(parquet.DoubleType)
+	case BoolColumn:
+		return parquet.Leaf(parquet.BooleanType)
+	case JSONColumn:
+		return parquet.JSON()
+	case TimestampColumn:
+		return parquet.Timestamp(parquet.Microsecond)
+	}
+	// Columns that were null in every row are strings.
+	return parquet.String()
+}
+
+func columnValue(typ ColumnType, raw json.RawMessage) (parquet.Value, error) {
+	raw = bytes.TrimSpace(raw)
+	switch typ {
+	case Int64Column, DoubleColumn:
+		var num json.Number
+		if err := json.Unmarshal(raw, &num); err != nil {
+			return parquet.Value{}, fmt.Errorf("%s is not a number", raw)
+		}
+		if typ == DoubleColumn {
+			f, err := num.Float64()
+			if err != nil {
+				return parquet.Value{}, err
+			}
+			return parquet.DoubleValue(f), nil
+		}
+		i, err := num.Int64()
+		if err != nil {
+			return parquet.Value{}, fmt.Errorf("%s is not a 64-bit integer", raw)
+		}
+		return parquet.Int64Value(i), nil
+	case BoolColumn:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return parquet.Value{}, fmt.Errorf("%s is not a boolean", raw)
+		}
+		return parquet.BooleanValue(b), nil
+	case TimestampColumn:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return parquet.Value{}, fmt.Errorf("%s is not a timestamp string", raw)
+		}
+		for _, layout := range timestampLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return parquet.Int64Value(t.UnixMicro()), nil
+			}
+		}
+		return parquet.Value{}, fmt.Errorf("cannot parse timestamp %q", s)
+	case StringColumn:
+		var s string
+		if raw[0] == '"' && json.Unmarshal(raw, &s) == nil {
+			return parquet.ByteArrayValue([]byte(s)), nil
+		}
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return parquet.Value{}, err
+	}
+	return parquet.ByteArrayValue(buf.Bytes()), nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+}
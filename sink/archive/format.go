@@ -0,0 +1,136 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// Format encodes a file of notifications.
+type Format interface {
+	Encode(ns []*listener.ChangeNotification) ([]byte, error)
+	Extension() string
+	ContentType() string
+}
+
+// NDJSON writes one notification per line, as received from the trigger,
+// so files can be decoded back into ChangeNotifications for replay.
+type NDJSON struct {
+	Gzip bool
+}
+
+func (f NDJSON) Encode(ns []*listener.ChangeNotification) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if f.Gzip {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	enc := json.NewEncoder(w)
+	for _, n := range ns {
+		if err := enc.Encode(n); err != nil {
+			return nil, err
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (f NDJSON) Extension() string {
+	if f.Gzip {
+		return ".ndjson.gz"
+	}
+	return ".ndjson"
+}
+
+func (f NDJSON) ContentType() string {
+	if f.Gzip {
+		return "application/gzip"
+	}
+	return "application/x-ndjson"
+}
+
+// Parquet writes a columnar file. By default each record holds the change
+// metadata and the row images as JSON strings (see parquetRecord). With
+// Columns the columns of the row, the new one or the old for deletes,
+// become columns of the file next to the metadata columns _id, _schema,
+// _table, _operation and _timestamp, so query engines read them directly.
+// Their types are inferred from the rows of the file, unless Schemas has
+// the table. Columns the sink partitions by are left out, as readers take
+// them from the path.
+type Parquet struct {
+	Columns bool
+	// Schemas gives the column types of tables ("schema.table" or a table
+	// in the default schema), e.g. to store timestamps, which payloads
+	// carry as strings. Columns missing from a schema are inferred.
+	Schemas map[string]Schema
+	// RowGroupRows caps the rows per row group; small groups let readers
+	// skip more data, large ones compress better. Zero leaves the default
+	// of parquet-go.
+	RowGroupRows int64
+
+	// omit holds the partition columns.
+	omit []string
+}
+
+type parquetRecord struct {
+	ID        int64  `parquet:"id"`
+	Schema    string `parquet:"schema,dict"`
+	Table     string `parquet:"table,dict"`
+	Operation string `parquet:"operation,dict"`
+	Key       string `parquet:"key,optional"`
+	Data      string `parquet:"data,optional"`
+	Old       string `parquet:"old,optional"`
+	Timestamp int64  `parquet:"timestamp,timestamp(microsecond)"`
+}
+
+func (f Parquet) Encode(ns []*listener.ChangeNotification) ([]byte, error) {
+	if f.Columns {
+		return f.encodeColumns(ns)
+	}
+	rows := make([]parquetRecord, len(ns))
+	for i, n := range ns {
+		attrs := sink.Attributes(n)
+		rows[i] = parquetRecord{
+			ID:        n.ID,
+			Schema:    attrs["schema"],
+			Table:     n.Table,
+			Operation: n.Operation,
+			Key:       string(sink.Key(n)),
+			Data:      string(n.Data),
+			Old:       string(n.Old),
+			Timestamp: n.Timestamp.UnixMicro(),
+		}
+	}
+
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[parquetRecord](&buf, f.writerOptions()...)
+	if _, err := w.Write(rows); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (f Parquet) writerOptions() []parquet.WriterOption {
+	if f.RowGroupRows > 0 {
+		return []parquet.WriterOption{parquet.MaxRowsPerRowGroup(f.RowGroupRows)}
+	}
+	return nil
+}
+
+func (Parquet) Extension() string   { return ".parquet" }
+func (Parquet) ContentType() string { return "application/vnd.apache.parquet" }
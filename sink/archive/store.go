@@ -0,0 +1,82 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"cloud.google.com/go/storage"
+	sdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Store writes archived files.
+type Store interface {
+	Put(ctx context.Context, name string, body []byte, contentType string) error
+}
+
+// S3API is the part of *s3.Client S3Store uses.
+type S3API interface {
+	PutObject(ctx context.Context, in *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+type S3Store struct {
+	client S3API
+	bucket string
+}
+
+func NewS3Store(client S3API, bucket string) *S3Store {
+	return &S3Store{client: client, bucket: bucket}
+}
+
+func (st *S3Store) Put(ctx context.Context, name string, body []byte, contentType string) error {
+	_, err := st.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      sdk.String(st.bucket),
+		Key:         sdk.String(name),
+		Body:        bytes.NewReader(body),
+		ContentType: sdk.String(contentType),
+	})
+	return err
+}
+
+type GCSStore struct {
+	bucket *storage.BucketHandle
+}
+
+func NewGCSStore(client *storage.Client, bucket string) *GCSStore {
+	return &GCSStore{bucket: client.Bucket(bucket)}
+}
+
+func (st *GCSStore) Put(ctx context.Context, name string, body []byte, contentType string) error {
+	w := st.bucket.Object(name).NewWriter(ctx)
+	w.ContentType = contentType
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// DirStore writes files below a local directory, e.g. for tests or a
+// mounted volume.
+type DirStore struct {
+	root string
+}
+
+func NewDirStore(root string) *DirStore {
+	return &DirStore{root: root}
+}
+
+func (st *DirStore) Put(_ context.Context, name string, body []byte, _ string) error {
+	path := filepath.Join(st.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
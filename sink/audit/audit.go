@@ -0,0 +1,462 @@
+// Package audit writes every change into a PostgreSQL audit table, range
+// partitioned by the time of the change: who changed what and when, with
+// the row before and after. Partitions are created ahead of time and
+// dropped once they are older than the retention.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	DefaultTable = "data_listener_audit"
+	// DefaultPremake is how many partitions are created ahead of the
+	// current one.
+	DefaultPremake = 3
+	// DefaultMaintenanceInterval is how often Run creates and drops
+	// partitions.
+	DefaultMaintenanceInterval = time.Hour
+)
+
+// Partitioning is the time range of a partition.
+type Partitioning int
+
+const (
+	Daily Partitioning = iota
+	Monthly
+)
+
+// suffix is the layout of the partition name suffixes.
+func (p Partitioning) suffix() string {
+	if p == Monthly {
+		return "200601"
+	}
+	return "20060102"
+}
+
+// start returns the start of the partition t falls into.
+func (p Partitioning) start(t time.Time) time.Time {
+	t = t.UTC()
+	if p == Monthly {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// next returns the start of the partition following the one starting at
+// start.
+func (p Partitioning) next(start time.Time) time.Time {
+	if p == Monthly {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Audit records inserted, updated and deleted rows; other notifications
+// are ignored. The actor is set for triggers installed with
+// trigger.WithActor. Call Init before the first change and Run, or
+// Maintain regularly, to keep the partitions in place.
+type Audit struct {
+	db        *sql.DB
+	table     string
+	partition Partitioning
+	retention time.Duration
+	premake   int
+	interval  time.Duration
+	logger    listener.Logger
+
+	// mu serializes creating partitions.
+	mu sync.Mutex
+}
+
+type Option func(*Audit)
+
+// WithTable sets the (optionally schema-qualified) audit table,
+// DefaultTable by default. Its partitions are named after it with the
+// date appended, e.g. data_listener_audit_20260131.
+func WithTable(name string) Option {
+	return func(a *Audit) {
+		a.table = name
+	}
+}
+
+// WithPartitioning sets the time range of a partition, Daily by default.
+func WithPartitioning(p Partitioning) Option {
+	return func(a *Audit) {
+		a.partition = p
+	}
+}
+
+// WithRetention drops partitions whose changes are all older than d. The
+// default keeps them forever.
+func WithRetention(d time.Duration) Option {
+	return func(a *Audit) {
+		a.retention = d
+	}
+}
+
+// WithPremake sets how many partitions are created ahead of the current
+// one (DefaultPremake).
+func WithPremake(n int) Option {
+	return func(a *Audit) {
+		a.premake = n
+	}
+}
+
+// WithMaintenanceInterval sets how often Run maintains the partitions
+// (DefaultMaintenanceInterval).
+func WithMaintenanceInterval(d time.Duration) Option {
+	return func(a *Audit) {
+		a.interval = d
+	}
+}
+
+// WithLogger sets the logger of Run, slog.Default by default.
+func WithLogger(l listener.Logger) Option {
+	return func(a *Audit) {
+		a.logger = l
+	}
+}
+
+func New(db *sql.DB, opts ...Option) *Audit {
+	a := &Audit{
+		db:       db,
+		table:    DefaultTable,
+		premake:  DefaultPremake,
+		interval: DefaultMaintenanceInterval,
+		logger:   slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// TableSQL returns the DDL of the audit table.
+func (a *Audit) TableSQL() string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id BIGSERIAL,
+    schema_name TEXT NOT NULL,
+    table_name TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    actor TEXT,
+    tenant TEXT,
+    row_key JSONB,
+    old_data JSONB,
+    new_data JSONB,
+    txid BIGINT,
+    changed_at TIMESTAMPTZ NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id, changed_at)
+) PARTITION BY RANGE (changed_at)`, quoteName(a.table))
+}
+
+// Init creates the audit table if needed, then maintains its partitions.
+func (a *Audit) Init(ctx context.Context) error {
+	if _, err := a.db.ExecContext(ctx, a.TableSQL()); err != nil {
+		return fmt.Errorf("failed to create audit table %s: %w", a.table, err)
+	}
+	_, name := a.schemaAndName()
+	if _, err := a.db.ExecContext(ctx, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (schema_name, table_name, changed_at)`,
+		pq.QuoteIdentifier(name+"_table_idx"), quoteName(a.table))); err != nil {
+		return fmt.Errorf("failed to index audit table %s: %w", a.table, err)
+	}
+	return a.Maintain(ctx)
+}
+
+// Run maintains the partitions every interval until ctx is canceled,
+// logging failures.
+func (a *Audit) Run(ctx context.Context) {
+	t := time.NewTicker(a.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := a.Maintain(ctx); err != nil {
+				a.logger.Error("failed to maintain audit partitions", "table", a.table, "error", err)
+			}
+		}
+	}
+}
+
+// Maintain creates the current and upcoming partitions and drops those
+// past the retention. Only partitions named like the ones it creates are
+// dropped.
+func (a *Audit) Maintain(ctx context.Context) error {
+	now := time.Now()
+	start := a.partition.start(now)
+	for i := 0; i <= a.premake; i++ {
+		if err := a.createPartition(ctx, start); err != nil {
+			return err
+		}
+		start = a.partition.next(start)
+	}
+	if a.retention <= 0 {
+		return nil
+	}
+	return a.dropPartitions(ctx, now.Add(-a.retention))
+}
+
+func (a *Audit) createPartition(ctx context.Context, start time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err := a.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM (%s) TO (%s)`,
+		a.partitionName(start), quoteName(a.table),
+		pq.QuoteLiteral(start.Format(time.RFC3339)), pq.QuoteLiteral(a.partition.next(start).Format(time.RFC3339))))
+	if err != nil {
+		return fmt.Errorf("failed to create audit partition for %s: %w", start.Format(time.DateOnly), err)
+	}
+	return nil
+}
+
+// dropPartitions drops the partitions ending before cutoff.
+func (a *Audit) dropPartitions(ctx context.Context, cutoff time.Time) error {
+	rows, err := a.db.QueryContext(ctx, `SELECT n.nspname, c.relname
+FROM pg_inherits i
+JOIN pg_class c ON c.oid = i.inhrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE i.inhparent = $1::regclass`, quoteName(a.table))
+	if err != nil {
+		return fmt.Errorf("failed to list audit partitions: %w", err)
+	}
+	_, name := a.schemaAndName()
+	prefix := name + "_"
+	var expired []string
+	for rows.Next() {
+		var schema, rel string
+		if err := rows.Scan(&schema, &rel); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to list audit partitions: %w", err)
+		}
+		suffix, ok := strings.CutPrefix(rel, prefix)
+		if !ok {
+			continue
+		}
+		start, err := time.Parse(a.partition.suffix(), suffix)
+		if err != nil {
+			continue
+		}
+		if !a.partition.next(start).After(cutoff) {
+			expired = append(expired, pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(rel))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list audit partitions: %w", err)
+	}
+	for _, p := range expired {
+		if _, err := a.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+p); err != nil {
+			return fmt.Errorf("failed to drop audit partition %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (a *Audit) schemaAndName() (string, string) {
+	if i := strings.LastIndex(a.table, "."); i >= 0 {
+		return a.table[:i], a.table[i+1:]
+	}
+	return "", a.table
+}
+
+// partitionName returns the quoted name of the partition starting at
+// start, in the schema of the audit table.
+func (a *Audit) partitionName(start time.Time) string {
+	schema, name := a.schemaAndName()
+	name = pq.QuoteIdentifier(name + "_" + start.Format(a.partition.suffix()))
+	if schema != "" {
+		return quoteName(schema) + "." + name
+	}
+	return name
+}
+
+func (a *Audit) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	return a.HandleBatch(ctx, []*listener.ChangeNotification{n})
+}
+
+// columns are the columns an insert writes, in the order of entry.
+const columns = "schema_name, table_name, operation, actor, tenant, row_key, old_data, new_data, txid, changed_at"
+
+// HandleBatch inserts the changes of batch with one statement. A change
+// without a partition, e.g. one older than the oldest partition created,
+// gets its partition created before the insert is retried.
+func (a *Audit) HandleBatch(ctx context.Context, batch []*listener.ChangeNotification) error {
+	var values []string
+	var args []any
+	var times []time.Time
+	for _, n := range batch {
+		switch n.Operation {
+		case listener.OpInsert, listener.OpUpdate, listener.OpDelete:
+		default:
+			continue
+		}
+		row := entry(n)
+		params := make([]string, len(row))
+		for i := range row {
+			params[i] = fmt.Sprintf("$%d", len(args)+i+1)
+		}
+		values = append(values, "("+strings.Join(params, ", ")+")")
+		args = append(args, row...)
+		times = append(times, row[len(row)-1].(time.Time))
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", quoteName(a.table), columns, strings.Join(values, ", "))
+
+	_, err := a.db.ExecContext(ctx, query, args...)
+	if isMissingPartition(err) {
+		for _, t := range times {
+			if err := a.createPartition(ctx, a.partition.start(t)); err != nil {
+				return err
+			}
+		}
+		_, err = a.db.ExecContext(ctx, query, args...)
+	}
+	if err != nil {
+		return classify(fmt.Errorf("failed to write %d audit entries: %w", len(values), err))
+	}
+	return nil
+}
+
+// entry returns the values of columns for n.
+func entry(n *listener.ChangeNotification) []any {
+	schema := n.Schema
+	if schema == "" {
+		schema = listener.DefaultSchema
+	}
+	changedAt := n.Timestamp
+	if changedAt.IsZero() {
+		changedAt = time.Now()
+	}
+	var txid sql.NullInt64
+	if n.TxID != 0 {
+		txid = sql.NullInt64{Int64: n.TxID, Valid: true}
+	}
+	before, after := n.Old, n.New
+	if n.Operation == listener.OpInsert {
+		before = nil
+	}
+	if n.Operation == listener.OpDelete {
+		after = nil
+	}
+	return []any{schema, n.Table, n.Operation, nullString(n.Actor), nullString(n.Tenant),
+		jsonb(sink.Key(n)), jsonb(before), jsonb(after), txid, changedAt.UTC()}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// jsonb passes a row image as JSON text, or NULL when it is absent.
+func jsonb(row json.RawMessage) any {
+	if len(row) == 0 {
+		return nil
+	}
+	return string(row)
+}
+
+// Query selects audit entries for Read; zero fields do not restrict.
+type Query struct {
+	// From and To bound changed_at, To excluded.
+	From, To time.Time
+	// AfterID reads entries with a greater id, for paging.
+	AfterID int64
+	Limit   int
+}
+
+// Read returns the entries matching q in id order as notifications, with
+// ID set to the entry id, e.g. to replay them. Entries carry no channel.
+func (a *Audit) Read(ctx context.Context, q Query) ([]*listener.ChangeNotification, error) {
+	conds := []string{"id > $1"}
+	args := []any{q.AfterID}
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		conds = append(conds, fmt.Sprintf("changed_at >= $%d", len(args)))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		conds = append(conds, fmt.Sprintf("changed_at < $%d", len(args)))
+	}
+	query := fmt.Sprintf("SELECT id, %s FROM %s WHERE %s ORDER BY id", columns, quoteName(a.table), strings.Join(conds, " AND "))
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit table %s: %w", a.table, err)
+	}
+	defer rows.Close()
+	var entries []*listener.ChangeNotification
+	for rows.Next() {
+		n := &listener.ChangeNotification{}
+		var actor, tenant sql.NullString
+		var key, before, after []byte
+		var txid sql.NullInt64
+		if err := rows.Scan(&n.ID, &n.Schema, &n.Table, &n.Operation, &actor, &tenant,
+			&key, &before, &after, &txid, &n.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to read audit table %s: %w", a.table, err)
+		}
+		n.Actor, n.Tenant, n.TxID = actor.String, tenant.String, txid.Int64
+		n.Key, n.Old, n.New = rawJSON(key), rawJSON(before), rawJSON(after)
+		n.Data = n.New
+		if n.Operation == listener.OpDelete {
+			n.Data = n.Old
+		}
+		entries = append(entries, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit table %s: %w", a.table, err)
+	}
+	return entries, nil
+}
+
+func rawJSON(b []byte) json.RawMessage {
+	if b == nil {
+		return nil
+	}
+	return json.RawMessage(b)
+}
+
+// isMissingPartition reports whether err is the check violation of a row
+// no partition accepts.
+func isMissingPartition(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23514" && strings.Contains(pqErr.Message, "no partition")
+}
+
+// classify marks errors that retrying cannot fix: data exceptions and
+// undefined tables or columns.
+func classify(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "22", "42":
+			return listener.Permanent(err)
+		}
+	}
+	return err
+}
+
+func quoteName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
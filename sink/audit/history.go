@@ -0,0 +1,108 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// RowState is a row as of a point in time, reconstructed from the audit
+// entry that changed it last.
+type RowState struct {
+	Key json.RawMessage `json:"key"`
+	// Data is the row, nil when it was deleted.
+	Data json.RawMessage `json:"data"`
+	// Exists is false when the row was deleted, or its key changed, by
+	// the last entry.
+	Exists bool `json:"exists"`
+	// EntryID, Operation, Actor and ChangedAt describe the last entry.
+	EntryID   int64     `json:"entry_id"`
+	Operation string    `json:"operation"`
+	Actor     string    `json:"actor,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// RowAt reconstructs the row of table ("schema.table" or a table in the
+// default schema) with the primary key key, a JSON object such as
+// {"id": 42}, as of at. It returns nil when no entry up to at changed the
+// row: the row did not exist then, or was last changed before the history
+// began or past the retention.
+func (a *Audit) RowAt(ctx context.Context, table string, key json.RawMessage, at time.Time) (*RowState, error) {
+	schema, name := splitTable(table)
+	// An update changing the key moved the row away from key; its old
+	// image holds the key.
+	row := a.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT id, operation, actor, row_key = $3::jsonb, new_data, changed_at
+FROM %s
+WHERE schema_name = $1 AND table_name = $2 AND changed_at <= $4
+  AND (row_key = $3::jsonb OR (operation = 'UPDATE' AND old_data @> $3::jsonb))
+ORDER BY changed_at DESC, id DESC
+LIMIT 1`, quoteName(a.table)), schema, name, string(key), at)
+	st := &RowState{Key: key}
+	var actor sql.NullString
+	var same bool
+	var data []byte
+	err := row.Scan(&st.EntryID, &st.Operation, &actor, &same, &data, &st.ChangedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", table, err)
+	}
+	st.Actor = actor.String
+	if same && st.Operation != listener.OpDelete {
+		st.Exists, st.Data = true, rawJSON(data)
+	}
+	return st, nil
+}
+
+// TableAt reconstructs the rows of table that existed as of at, by key,
+// up to limit rows when limit is positive. Only rows changed since the
+// history began are known, and rows whose key an update changed are still
+// listed under their old key; both make it a view of the captured changes
+// rather than of the whole table.
+func (a *Audit) TableAt(ctx context.Context, table string, at time.Time, limit int) ([]*RowState, error) {
+	schema, name := splitTable(table)
+	query := fmt.Sprintf(`SELECT id, operation, actor, row_key, new_data, changed_at FROM (
+    SELECT DISTINCT ON (row_key) id, operation, actor, row_key, new_data, changed_at
+    FROM %s
+    WHERE schema_name = $1 AND table_name = $2 AND changed_at <= $3 AND row_key IS NOT NULL
+    ORDER BY row_key, changed_at DESC, id DESC
+) last
+WHERE operation <> 'DELETE'
+ORDER BY row_key`, quoteName(a.table))
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := a.db.QueryContext(ctx, query, schema, name, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", table, err)
+	}
+	defer rows.Close()
+	states := []*RowState{}
+	for rows.Next() {
+		st := &RowState{Exists: true}
+		var actor sql.NullString
+		var key, data []byte
+		if err := rows.Scan(&st.EntryID, &st.Operation, &actor, &key, &data, &st.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to read history of %s: %w", table, err)
+		}
+		st.Actor, st.Key, st.Data = actor.String, rawJSON(key), rawJSON(data)
+		states = append(states, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history of %s: %w", table, err)
+	}
+	return states, nil
+}
+
+func splitTable(table string) (string, string) {
+	if i := strings.LastIndexByte(table, '.'); i >= 0 {
+		return table[:i], table[i+1:]
+	}
+	return listener.DefaultSchema, table
+}
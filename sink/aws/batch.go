@@ -0,0 +1,102 @@
+package aws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	// MaxBatchSize is the SQS and SNS limit on entries per batch call.
+	MaxBatchSize = 10
+	// maxBatchBytes is the limit on the total payload of a batch call.
+	maxBatchBytes = 256 << 10
+)
+
+type options struct {
+	encoder   sink.Encoder
+	fifo      bool
+	batchSize int
+	linger    time.Duration
+}
+
+type Option func(*options)
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(o *options) {
+		o.encoder = e
+	}
+}
+
+// WithFIFO sets a message group ID derived from the table and primary key,
+// so changes to one row stay ordered, and a deduplication ID from the
+// outbox ID (or the body hash without an outbox). Required for FIFO queues
+// and topics.
+func WithFIFO() Option {
+	return func(o *options) {
+		o.fifo = true
+	}
+}
+
+// WithBatchSize caps the entries per batch call (at most MaxBatchSize).
+// Batches only fill up when several handler calls run concurrently, i.e.
+// with listener.WithWorkers.
+func WithBatchSize(n int) Option {
+	return func(o *options) {
+		o.batchSize = n
+	}
+}
+
+// WithLinger sets how long a partial batch waits for more entries.
+func WithLinger(d time.Duration) Option {
+	return func(o *options) {
+		o.linger = d
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		encoder:   sink.JSON{},
+		batchSize: MaxBatchSize,
+		linger:    sink.DefaultLinger,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize <= 0 || o.batchSize > MaxBatchSize {
+		o.batchSize = MaxBatchSize
+	}
+	return o
+}
+
+func (o options) batchConfig() sink.BatchConfig {
+	return sink.BatchConfig{Size: o.batchSize, MaxBytes: maxBatchBytes, Linger: o.linger}
+}
+
+// groupID keeps the value within the 128 character limit.
+func groupID(item *sink.BatchItem) string {
+	id := sink.RowKey(item.Notification)
+	if len(id) > 128 {
+		sum := sha256.Sum256([]byte(id))
+		id = hex.EncodeToString(sum[:])
+	}
+	return id
+}
+
+func dedupID(item *sink.BatchItem) string {
+	if item.Notification.ID > 0 {
+		return strconv.FormatInt(item.Notification.ID, 10)
+	}
+	sum := sha256.Sum256(item.Body)
+	return hex.EncodeToString(sum[:])
+}
+
+// failAll marks every item with err.
+func failAll(items []*sink.BatchItem, err error) {
+	for _, item := range items {
+		item.Err = err
+	}
+}
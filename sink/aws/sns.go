@@ -0,0 +1,81 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	sdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// SNSAPI is the part of *sns.Client the sink uses.
+type SNSAPI interface {
+	PublishBatch(ctx context.Context, in *sns.PublishBatchInput, opts ...func(*sns.Options)) (*sns.PublishBatchOutput, error)
+}
+
+type SNS struct {
+	client   SNSAPI
+	topicARN string
+	opts     options
+	batcher  *sink.Batcher
+}
+
+func NewSNS(client SNSAPI, topicARN string, opts ...Option) *SNS {
+	s := &SNS{client: client, topicARN: topicARN, opts: newOptions(opts)}
+	s.batcher = sink.NewBatcher(s.opts.batchConfig(), s.send)
+	return s
+}
+
+func (s *SNS) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.opts.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+	return s.batcher.Add(ctx, n, body)
+}
+
+func (s *SNS) send(ctx context.Context, items []*sink.BatchItem) {
+	in := &sns.PublishBatchInput{TopicArn: sdk.String(s.topicARN)}
+	for i, item := range items {
+		attrs := map[string]types.MessageAttributeValue{
+			"content-type": {DataType: sdk.String("String"), StringValue: sdk.String(s.opts.encoder.ContentType())},
+		}
+		for k, v := range sink.Headers(s.opts.encoder, item.Notification) {
+			attrs[k] = types.MessageAttributeValue{DataType: sdk.String("String"), StringValue: sdk.String(v)}
+		}
+		req := types.PublishBatchRequestEntry{
+			Id:                sdk.String(strconv.Itoa(i)),
+			Message:           sdk.String(string(item.Body)),
+			MessageAttributes: attrs,
+		}
+		if s.opts.fifo {
+			req.MessageGroupId = sdk.String(groupID(item))
+			req.MessageDeduplicationId = sdk.String(dedupID(item))
+		}
+		in.PublishBatchRequestEntries = append(in.PublishBatchRequestEntries, req)
+	}
+
+	out, err := s.client.PublishBatch(ctx, in)
+	if err != nil {
+		failAll(items, fmt.Errorf("failed to publish to sns: %w", err))
+		return
+	}
+	for _, f := range out.Failed {
+		i, _ := strconv.Atoi(sdk.ToString(f.Id))
+		if i < 0 || i >= len(items) {
+			continue
+		}
+		items[i].Err = batchError("sns", sdk.ToString(f.Code), sdk.ToString(f.Message), f.SenderFault)
+	}
+}
+
+// Close publishes the pending batch.
+func (s *SNS) Close() error {
+	s.batcher.Close()
+	return nil
+}
@@ -0,0 +1,93 @@
+// Package aws forwards change notifications to Amazon SQS queues and SNS
+// topics, using the batch APIs.
+package aws
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	sdk "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// SQSAPI is the part of *sqs.Client the sink uses.
+type SQSAPI interface {
+	SendMessageBatch(ctx context.Context, in *sqs.SendMessageBatchInput, opts ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+type SQS struct {
+	client   SQSAPI
+	queueURL string
+	opts     options
+	batcher  *sink.Batcher
+}
+
+func NewSQS(client SQSAPI, queueURL string, opts ...Option) *SQS {
+	s := &SQS{client: client, queueURL: queueURL, opts: newOptions(opts)}
+	s.batcher = sink.NewBatcher(s.opts.batchConfig(), s.send)
+	return s
+}
+
+func (s *SQS) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.opts.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+	return s.batcher.Add(ctx, n, body)
+}
+
+func (s *SQS) send(ctx context.Context, items []*sink.BatchItem) {
+	in := &sqs.SendMessageBatchInput{QueueUrl: sdk.String(s.queueURL)}
+	for i, item := range items {
+		attrs := map[string]types.MessageAttributeValue{
+			"content-type": {DataType: sdk.String("String"), StringValue: sdk.String(s.opts.encoder.ContentType())},
+		}
+		for k, v := range sink.Headers(s.opts.encoder, item.Notification) {
+			attrs[k] = types.MessageAttributeValue{DataType: sdk.String("String"), StringValue: sdk.String(v)}
+		}
+		req := types.SendMessageBatchRequestEntry{
+			Id:                sdk.String(strconv.Itoa(i)),
+			MessageBody:       sdk.String(string(item.Body)),
+			MessageAttributes: attrs,
+		}
+		if s.opts.fifo {
+			req.MessageGroupId = sdk.String(groupID(item))
+			req.MessageDeduplicationId = sdk.String(dedupID(item))
+		}
+		in.Entries = append(in.Entries, req)
+	}
+
+	out, err := s.client.SendMessageBatch(ctx, in)
+	if err != nil {
+		failAll(items, fmt.Errorf("failed to send to sqs: %w", err))
+		return
+	}
+	for _, f := range out.Failed {
+		i, _ := strconv.Atoi(sdk.ToString(f.Id))
+		if i < 0 || i >= len(items) {
+			continue
+		}
+		items[i].Err = batchError("sqs", sdk.ToString(f.Code), sdk.ToString(f.Message), f.SenderFault)
+	}
+}
+
+// Close sends the pending batch.
+func (s *SQS) Close() error {
+	s.batcher.Close()
+	return nil
+}
+
+// batchError turns a failed batch entry into an error; sender faults are
+// not worth retrying.
+func batchError(service, code, message string, senderFault bool) error {
+	err := fmt.Errorf("%s rejected message: %s: %s", service, code, message)
+	if senderFault {
+		return listener.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const (
+	DefaultLinger       = 10 * time.Millisecond
+	DefaultFlushTimeout = 30 * time.Second
+)
+
+var ErrClosed = errors.New("sink closed")
+
+// BatchItem is one encoded notification waiting in a batch. Flush
+// functions set Err on the items that failed.
+type BatchItem struct {
+	Notification *listener.ChangeNotification
+	Body         []byte
+	Err          error
+
+	done chan struct{}
+	ack  *listener.Acknowledgement
+}
+
+// BatchConfig bounds a batch by entries, total body bytes (0 for no limit)
+// and how long a partial batch waits for more entries.
+type BatchConfig struct {
+	Size     int
+	MaxBytes int
+	Linger   time.Duration
+	// Async makes Add return once the item is queued, so batches fill up
+	// without concurrent handler calls. The notification is acknowledged
+	// once its batch was written, see listener.Acknowledge; failed items
+	// are reported to OnError and nacked, so listener retries still apply.
+	Async   bool
+	OnError func(item *BatchItem)
+}
+
+// Batcher groups concurrent handler calls into batched writes. Each call
+// blocks until its batch was flushed, so handler errors still reflect the
+// outcome of the write. Batches therefore only fill up when several
+// handler calls run concurrently, i.e. with listener.WithWorkers.
+type Batcher struct {
+	cfg   BatchConfig
+	flush func(ctx context.Context, items []*BatchItem)
+
+	in       chan *BatchItem
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func NewBatcher(cfg BatchConfig, flush func(ctx context.Context, items []*BatchItem)) *Batcher {
+	if cfg.Size <= 0 {
+		cfg.Size = 1
+	}
+	if cfg.Linger <= 0 {
+		cfg.Linger = DefaultLinger
+	}
+	b := &Batcher{
+		cfg:   cfg,
+		flush: flush,
+		in:    make(chan *BatchItem),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues body and, unless Async is set, waits until its batch was
+// flushed. Add blocks while a flush is running.
+func (b *Batcher) Add(ctx context.Context, n *listener.ChangeNotification, body []byte) error {
+	item := &BatchItem{Notification: n, Body: body, done: make(chan struct{})}
+	if b.cfg.Async {
+		item.ack = listener.Acknowledge(ctx)
+	}
+	select {
+	case b.in <- item:
+	case <-b.stop:
+		item.settle(ErrClosed)
+		return ErrClosed
+	case <-ctx.Done():
+		item.settle(ctx.Err())
+		return ctx.Err()
+	}
+	if b.cfg.Async {
+		return nil
+	}
+	select {
+	case <-item.done:
+		return item.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// settle acknowledges the notification of an asynchronously added item,
+// or nacks it with err.
+func (item *BatchItem) settle(err error) {
+	switch {
+	case item.ack == nil:
+	case err != nil:
+		item.ack.Nack(err)
+	default:
+		item.ack.Ack()
+	}
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+
+	var batch []*BatchItem
+	var size int
+	timer := time.NewTimer(b.cfg.Linger)
+	timer.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultFlushTimeout)
+		b.flush(ctx, batch)
+		cancel()
+		for _, item := range batch {
+			if b.cfg.Async && item.Err != nil && b.cfg.OnError != nil {
+				b.cfg.OnError(item)
+			}
+			item.settle(item.Err)
+			close(item.done)
+		}
+		batch, size = nil, 0
+	}
+
+	for {
+		select {
+		case item := <-b.in:
+			if b.cfg.MaxBytes > 0 && size+len(item.Body) > b.cfg.MaxBytes {
+				flush()
+			}
+			if len(batch) == 0 {
+				timer.Reset(b.cfg.Linger)
+			}
+			batch = append(batch, item)
+			size += len(item.Body)
+			if len(batch) >= b.cfg.Size {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		case <-b.stop:
+			timer.Stop()
+			flush()
+			return
+		}
+	}
+}
+
+// Close flushes the pending batch and stops the batcher.
+func (b *Batcher) Close() {
+	b.stopOnce.Do(func() { close(b.stop) })
+	<-b.done
+}
+
+// FlushBatch encodes batch and writes it with flush, the function a sink
+// passes to NewBatcher, so the sink implements listener.BatchHandler on
+// top of its bulk writes. Notifications failing to encode or write are
+// reported in a listener.BatchErrors.
+func FlushBatch(ctx context.Context, batch []*listener.ChangeNotification, encode func(n *listener.ChangeNotification) ([]byte, error), flush func(ctx context.Context, items []*BatchItem)) error {
+	errs := make(listener.BatchErrors, len(batch))
+	items := make([]*BatchItem, 0, len(batch))
+	index := make([]int, 0, len(batch))
+	for i, n := range batch {
+		body, err := encode(n)
+		if err != nil {
+			errs[i] = listener.Permanent(err)
+			continue
+		}
+		items = append(items, &BatchItem{Notification: n, Body: body})
+		index = append(index, i)
+	}
+	if len(items) > 0 {
+		flush(ctx, items)
+	}
+	for j, item := range items {
+		errs[index[j]] = item.Err
+	}
+	if slices.ContainsFunc(errs, func(err error) bool { return err != nil }) {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+// Package clickhouse streams change events into ClickHouse through its
+// HTTP interface, for change-history analytics.
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	DefaultTable         = "data_changes"
+	DefaultBatchSize     = 10000
+	DefaultFlushInterval = time.Second
+
+	timestampFormat = "2006-01-02 15:04:05.000000"
+)
+
+// RowFunc maps a notification to a row of the target table. The keys are
+// column names.
+type RowFunc func(n *listener.ChangeNotification) (map[string]any, error)
+
+// Sink buffers rows and inserts them in the background once the batch size
+// or flush interval is reached. Handler calls return as soon as the row is
+// buffered; failed inserts are reported to the WithErrorHandler callback.
+type Sink struct {
+	url     string
+	client  *http.Client
+	table   sink.Namer
+	row     RowFunc
+	header  http.Header
+	cfg     sink.BatchConfig
+	batcher *sink.Batcher
+}
+
+type Option func(*Sink)
+
+// WithTable sets the target table template (see sink.Template), which may
+// be database qualified.
+func WithTable(pattern string) Option {
+	return func(s *Sink) {
+		s.table = sink.Template(pattern)
+	}
+}
+
+// WithRow maps notifications to rows of a custom table. The default writes
+// rows of HistoryTableSQL.
+func WithRow(fn RowFunc) Option {
+	return func(s *Sink) {
+		s.row = fn
+	}
+}
+
+func WithAuth(user, password string) Option {
+	return func(s *Sink) {
+		s.header.Set("X-ClickHouse-User", user)
+		s.header.Set("X-ClickHouse-Key", password)
+	}
+}
+
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *Sink) {
+		s.client = c
+	}
+}
+
+func WithBatchSize(n int) Option {
+	return func(s *Sink) {
+		s.cfg.Size = n
+	}
+}
+
+func WithFlushInterval(d time.Duration) Option {
+	return func(s *Sink) {
+		s.cfg.Linger = d
+	}
+}
+
+// WithErrorHandler receives the notifications whose insert failed.
+func WithErrorHandler(fn func(n *listener.ChangeNotification, err error)) Option {
+	return func(s *Sink) {
+		s.cfg.OnError = func(item *sink.BatchItem) {
+			fn(item.Notification, item.Err)
+		}
+	}
+}
+
+// New creates a sink for the server at url, e.g. "http://localhost:8123".
+func New(url string, opts ...Option) *Sink {
+	s := &Sink{
+		url:    strings.TrimRight(url, "/"),
+		client: http.DefaultClient,
+		table:  sink.Template(DefaultTable),
+		row:    historyRow,
+		header: http.Header{},
+		cfg: sink.BatchConfig{
+			Size:   DefaultBatchSize,
+			Linger: DefaultFlushInterval,
+			Async:  true,
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.batcher = sink.NewBatcher(s.cfg, s.insert)
+	return s
+}
+
+// HistoryTableSQL returns DDL for the table the default rows are written
+// to.
+func HistoryTableSQL(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id          UInt64,
+    "schema"    LowCardinality(String),
+    "table"     LowCardinality(String),
+    operation   LowCardinality(String),
+    key         String,
+    data        String,
+    old         String,
+    timestamp   DateTime64(6, 'UTC')
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(timestamp)
+ORDER BY ("schema", "table", timestamp)`, table)
+}
+
+func historyRow(n *listener.ChangeNotification) (map[string]any, error) {
+	schema := n.Schema
+	if schema == "" {
+		schema = listener.DefaultSchema
+	}
+	return map[string]any{
+		"id":        n.ID,
+		"schema":    schema,
+		"table":     n.Table,
+		"operation": n.Operation,
+		"key":       string(sink.Key(n)),
+		"data":      string(n.Data),
+		"old":       string(n.Old),
+		"timestamp": n.Timestamp.UTC().Format(timestampFormat),
+	}, nil
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	line, err := s.encode(n)
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	return s.batcher.Add(ctx, n, line)
+}
+
+// HandleBatch inserts batch with one INSERT per target table, for
+// listener.NewBatching.
+func (s *Sink) HandleBatch(ctx context.Context, batch []*listener.ChangeNotification) error {
+	return sink.FlushBatch(ctx, batch, s.encode, s.insert)
+}
+
+// encode renders the JSONEachRow line for n.
+func (s *Sink) encode(n *listener.ChangeNotification) ([]byte, error) {
+	row, err := s.row(n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map row: %w", err)
+	}
+	line, err := json.Marshal(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode row: %w", err)
+	}
+	return append(line, '\n'), nil
+}
+
+// insert sends one INSERT per target table.
+func (s *Sink) insert(ctx context.Context, items []*sink.BatchItem) {
+	tables := make(map[string][]*sink.BatchItem)
+	var order []string
+	for _, item := range items {
+		table := s.table(item.Notification)
+		if _, ok := tables[table]; !ok {
+			order = append(order, table)
+		}
+		tables[table] = append(tables[table], item)
+	}
+
+	for _, table := range order {
+		rows := tables[table]
+		var body bytes.Buffer
+		for _, item := range rows {
+			body.Write(item.Body)
+		}
+		if err := s.post(ctx, table, body.Bytes()); err != nil {
+			for _, item := range rows {
+				item.Err = err
+			}
+		}
+	}
+}
+
+func (s *Sink) post(ctx context.Context, table string, body []byte) error {
+	query := url.Values{"query": {"INSERT INTO " + table + " FORMAT JSONEachRow"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create insert request: %w", err)
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to insert into %s: %w", table, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("insert into %s: %s: %s", table, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Close inserts the buffered rows.
+func (s *Sink) Close() error {
+	s.batcher.Close()
+	return nil
+}
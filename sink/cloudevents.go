@@ -0,0 +1,144 @@
+package sink
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const (
+	CloudEventsSpecVersion = "1.0"
+	CloudEventsContentType = "application/cloudevents+json"
+
+	DefaultCloudEventsSource = "/pg-data-listener/{schema}/{table}"
+	DefaultCloudEventsType   = "pg-data-listener.change.{op}"
+)
+
+// HeaderEncoder is implemented by encoders that carry metadata beside the
+// body. Sinks send the headers with each message (see Headers).
+type HeaderEncoder interface {
+	Encoder
+	Headers(n *listener.ChangeNotification) map[string]string
+}
+
+// Headers returns the message metadata for n: the Attributes, plus the
+// encoder's headers if it has any.
+func Headers(e Encoder, n *listener.ChangeNotification) map[string]string {
+	headers := Attributes(n)
+	if he, ok := e.(HeaderEncoder); ok {
+		for k, v := range he.Headers(n) {
+			headers[k] = v
+		}
+	}
+	return headers
+}
+
+// CloudEvents encodes notifications as CloudEvents 1.0 with the
+// notification as JSON data. In structured mode (the default) the whole
+// event is the body; in binary mode the body is the data and the context
+// attributes travel as headers, which sinks without headers (MQTT, Redis
+// pub/sub) drop.
+type CloudEvents struct {
+	// Source and Type are templates (see Template), defaulting to
+	// DefaultCloudEventsSource and DefaultCloudEventsType.
+	Source string
+	Type   string
+	Binary bool
+	// HeaderPrefix prefixes the binary mode headers, "ce-" by default; the
+	// Kafka binding uses "ce_".
+	HeaderPrefix string
+}
+
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+func (c CloudEvents) event(n *listener.ChangeNotification) cloudEvent {
+	source, typ := c.Source, c.Type
+	if source == "" {
+		source = DefaultCloudEventsSource
+	}
+	if typ == "" {
+		typ = DefaultCloudEventsType
+	}
+	ev := cloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              eventID(n),
+		Source:          Template(source)(n),
+		Type:            Template(typ)(n),
+		Subject:         string(Key(n)),
+		DataContentType: "application/json",
+	}
+	if !n.Timestamp.IsZero() {
+		ev.Time = n.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return ev
+}
+
+// eventID uses the outbox id, which is stable across replays so consumers
+// can deduplicate on source and id.
+func eventID(n *listener.ChangeNotification) string {
+	if n.ID > 0 {
+		return strconv.FormatInt(n.ID, 10)
+	}
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (c CloudEvents) Encode(n *listener.ChangeNotification) ([]byte, error) {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	if c.Binary {
+		return data, nil
+	}
+	ev := c.event(n)
+	ev.Data = data
+	return json.Marshal(ev)
+}
+
+func (c CloudEvents) ContentType() string {
+	if c.Binary {
+		return "application/json"
+	}
+	return CloudEventsContentType
+}
+
+// Headers returns the context attributes in binary mode. The data content
+// type is conveyed by the sink's content type header.
+func (c CloudEvents) Headers(n *listener.ChangeNotification) map[string]string {
+	if !c.Binary {
+		return nil
+	}
+	prefix := c.HeaderPrefix
+	if prefix == "" {
+		prefix = "ce-"
+	}
+	ev := c.event(n)
+	headers := map[string]string{
+		prefix + "specversion": ev.SpecVersion,
+		prefix + "id":          ev.ID,
+		prefix + "source":      ev.Source,
+		prefix + "type":        ev.Type,
+	}
+	if ev.Subject != "" {
+		headers[prefix+"subject"] = ev.Subject
+	}
+	if ev.Time != "" {
+		headers[prefix+"time"] = ev.Time
+	}
+	return headers
+}
@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const DefaultDebeziumName = "pg-data-listener"
+
+// Debezium encodes notifications as Debezium PostgreSQL change events, so
+// consumers written for Debezium can read them unchanged. The payload is
+// sent without the Kafka Connect schema, as with the JSON converter's
+// schemas.enable=false.
+type Debezium struct {
+	// Name is the logical server name reported in source.name, by default
+	// DefaultDebeziumName. Debezium topics are named "{name}.{schema}.{table}".
+	Name string
+	// Database is reported in source.db.
+	Database string
+}
+
+type debeziumEvent struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+	Source debeziumSource  `json:"source"`
+	Op     string          `json:"op"`
+	TsMs   int64           `json:"ts_ms"`
+}
+
+type debeziumSource struct {
+	Version   string `json:"version"`
+	Connector string `json:"connector"`
+	Name      string `json:"name"`
+	TsMs      int64  `json:"ts_ms"`
+	Snapshot  string `json:"snapshot"`
+	DB        string `json:"db"`
+	Schema    string `json:"schema"`
+	Table     string `json:"table"`
+	// Sequence carries the outbox id, which orders events like Debezium's
+	// LSN does.
+	Sequence *string `json:"sequence,omitempty"`
+}
+
+var debeziumOps = map[string]string{
+	listener.OpInsert: "c",
+	listener.OpUpdate: "u",
+	listener.OpDelete: "d",
+}
+
+func (d Debezium) Encode(n *listener.ChangeNotification) ([]byte, error) {
+	name := d.Name
+	if name == "" {
+		name = DefaultDebeziumName
+	}
+	schema := n.Schema
+	if schema == "" {
+		schema = listener.DefaultSchema
+	}
+	ts := n.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	ev := debeziumEvent{
+		Before: nullable(n.Old),
+		After:  nullable(n.New),
+		Source: debeziumSource{
+			Version:   "pg-data-listener",
+			Connector: "postgresql",
+			Name:      name,
+			TsMs:      ts.UnixMilli(),
+			Snapshot:  "false",
+			DB:        d.Database,
+			Schema:    schema,
+			Table:     n.Table,
+		},
+		Op:   debeziumOps[n.Operation],
+		TsMs: time.Now().UnixMilli(),
+	}
+	if n.Snapshot {
+		ev.Op = "r"
+		ev.Source.Snapshot = "true"
+	}
+	if n.ID > 0 {
+		seq := strconv.FormatInt(n.ID, 10)
+		ev.Source.Sequence = &seq
+	}
+	return json.Marshal(ev)
+}
+
+func (Debezium) ContentType() string { return "application/json" }
+
+func nullable(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("null")
+	}
+	return raw
+}
@@ -0,0 +1,270 @@
+// Package elasticsearch indexes changed rows into Elasticsearch or
+// OpenSearch through the bulk API: inserts and updates index the new row,
+// deletes remove the document.
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	// DefaultIndex uses one index per table.
+	DefaultIndex     = "{schema}.{table}"
+	DefaultBatchSize = 500
+	defaultMaxBytes  = 5 << 20
+)
+
+// DocumentFunc maps a notification to the indexed document. It is only
+// called for inserts and updates.
+type DocumentFunc func(n *listener.ChangeNotification) (json.RawMessage, error)
+
+// Sink batches concurrent handler calls into bulk requests. Documents are
+// identified by the primary key, so triggers must be installed with key
+// columns; use listener.ByKey ordering with workers so changes to one
+// document are applied in order.
+type Sink struct {
+	url      string
+	client   *http.Client
+	index    sink.Namer
+	document DocumentFunc
+	header   http.Header
+	cfg      sink.BatchConfig
+	batcher  *sink.Batcher
+}
+
+type Option func(*Sink)
+
+// WithIndex sets the index name template (see sink.Template).
+func WithIndex(pattern string) Option {
+	return func(s *Sink) {
+		s.index = sink.Template(pattern)
+	}
+}
+
+func WithIndexFunc(fn sink.Namer) Option {
+	return func(s *Sink) {
+		s.index = fn
+	}
+}
+
+// WithDocument maps rows to documents, e.g. to drop or rename columns. The
+// default indexes the new row image as is.
+func WithDocument(fn DocumentFunc) Option {
+	return func(s *Sink) {
+		s.document = fn
+	}
+}
+
+func WithBasicAuth(username, password string) Option {
+	return func(s *Sink) {
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		s.header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+}
+
+func WithAPIKey(key string) Option {
+	return func(s *Sink) {
+		s.header.Set("Authorization", "ApiKey "+key)
+	}
+}
+
+func WithHTTPClient(c *http.Client) Option {
+	return func(s *Sink) {
+		s.client = c
+	}
+}
+
+// WithBatchSize caps the actions per bulk request.
+func WithBatchSize(n int) Option {
+	return func(s *Sink) {
+		s.cfg.Size = n
+	}
+}
+
+// WithFlushInterval sets how long a partial bulk request waits for more
+// actions.
+func WithFlushInterval(d time.Duration) Option {
+	return func(s *Sink) {
+		s.cfg.Linger = d
+	}
+}
+
+// New creates a sink for the cluster at url, e.g. "http://localhost:9200".
+func New(url string, opts ...Option) *Sink {
+	s := &Sink{
+		url:      strings.TrimRight(url, "/"),
+		client:   http.DefaultClient,
+		index:    sink.Template(DefaultIndex),
+		document: func(n *listener.ChangeNotification) (json.RawMessage, error) { return n.New, nil },
+		header:   http.Header{},
+		cfg:      sink.BatchConfig{Size: DefaultBatchSize, MaxBytes: defaultMaxBytes},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.batcher = sink.NewBatcher(s.cfg, s.bulk)
+	return s
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	action, err := s.action(n)
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	return s.batcher.Add(ctx, n, action)
+}
+
+// HandleBatch writes batch with one bulk request, for listener.NewBatching.
+func (s *Sink) HandleBatch(ctx context.Context, batch []*listener.ChangeNotification) error {
+	return sink.FlushBatch(ctx, batch, s.action, s.bulk)
+}
+
+// action renders the bulk lines for n.
+func (s *Sink) action(n *listener.ChangeNotification) ([]byte, error) {
+	id, err := documentID(n)
+	if err != nil {
+		return nil, err
+	}
+	meta := map[string]any{"_index": s.index(n), "_id": id}
+
+	var buf bytes.Buffer
+	if n.Operation == listener.OpDelete {
+		line, _ := json.Marshal(map[string]any{"delete": meta})
+		buf.Write(line)
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	}
+
+	doc, err := s.document(n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map document: %w", err)
+	}
+	line, _ := json.Marshal(map[string]any{"index": meta})
+	buf.Write(line)
+	buf.WriteByte('\n')
+	if err := json.Compact(&buf, doc); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// documentID uses a single key column's value as is, and the compact JSON
+// key for composite keys.
+func documentID(n *listener.ChangeNotification) (string, error) {
+	key := sink.Key(n)
+	if key == nil {
+		return "", fmt.Errorf("%s: notification has no primary key", n.QualifiedTable())
+	}
+	var cols map[string]any
+	if err := json.Unmarshal(key, &cols); err != nil || len(cols) != 1 {
+		return string(key), nil
+	}
+	for _, v := range cols {
+		if str, ok := v.(string); ok {
+			return str, nil
+		}
+		b, _ := json.Marshal(v)
+		return string(b), nil
+	}
+	return string(key), nil
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func (s *Sink) bulk(ctx context.Context, items []*sink.BatchItem) {
+	var body bytes.Buffer
+	for _, item := range items {
+		body.Write(item.Body)
+	}
+
+	resp, err := s.post(ctx, body.Bytes())
+	if err != nil {
+		for _, item := range items {
+			item.Err = err
+		}
+		return
+	}
+	if !resp.Errors {
+		return
+	}
+	for i, result := range resp.Items {
+		if i >= len(items) {
+			break
+		}
+		for action, r := range result {
+			items[i].Err = itemError(action, r.Status, r.Error)
+		}
+	}
+}
+
+func (s *Sink) post(ctx context.Context, body []byte) (*bulkResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bulk request: %w", err)
+	}
+	for k, v := range s.header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		err := fmt.Errorf("bulk request: %s: %s", resp.Status, bytes.TrimSpace(msg))
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return nil, listener.Permanent(err)
+		}
+		return nil, err
+	}
+
+	var br bulkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return nil, fmt.Errorf("failed to parse bulk response: %w", err)
+	}
+	return &br, nil
+}
+
+// itemError classifies a bulk item result. Deleting a missing document is
+// not an error; rejections other than 429 will not succeed on retry.
+func itemError(action string, status int, reason json.RawMessage) error {
+	switch {
+	case status < 300:
+		return nil
+	case action == "delete" && status == http.StatusNotFound:
+		return nil
+	}
+	err := fmt.Errorf("bulk %s: status %d: %s", action, status, reason)
+	if status == http.StatusTooManyRequests || status >= 500 {
+		return err
+	}
+	return listener.Permanent(err)
+}
+
+// Close flushes the pending bulk request.
+func (s *Sink) Close() error {
+	s.batcher.Close()
+	return nil
+}
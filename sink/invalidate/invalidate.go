@@ -0,0 +1,186 @@
+// Package invalidate deletes cache entries when the rows they were built
+// from change, in Redis, memcached or an in-process cache.
+package invalidate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultKey invalidates the entry of a row named after its table and id
+// column, e.g. "s_user:42".
+const DefaultKey = "{table}:{id}"
+
+// Deleter removes entries from a cache. Deleting a missing key is not an
+// error.
+type Deleter interface {
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// DeleterFunc adapts an in-process cache, e.g. a sync.Map or an LRU.
+type DeleterFunc func(ctx context.Context, keys ...string) error
+
+func (f DeleterFunc) Delete(ctx context.Context, keys ...string) error {
+	return f(ctx, keys...)
+}
+
+// Redis deletes keys with UNLINK, which frees their memory in the
+// background.
+func Redis(client redis.UniversalClient) Deleter {
+	return DeleterFunc(func(ctx context.Context, keys ...string) error {
+		if err := client.Unlink(ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("failed to unlink %d keys: %w", len(keys), err)
+		}
+		return nil
+	})
+}
+
+// Invalidator renders key templates for the old and new image of each
+// changed row, so an update moving a row to another key invalidates both,
+// and deletes the keys. Templates substitute {schema}, {table}, {op} and
+// {channel} like sink.Template, and any other {column} with the column of
+// the row; a key naming a column the row lacks, or whose value is null, is
+// skipped.
+type Invalidator struct {
+	deleter Deleter
+	keys    []keyTemplate
+}
+
+// New invalidates the keys of templates, DefaultKey when none are given.
+func New(d Deleter, templates ...string) (*Invalidator, error) {
+	if len(templates) == 0 {
+		templates = []string{DefaultKey}
+	}
+	inv := &Invalidator{deleter: d}
+	for _, t := range templates {
+		k, err := parseKey(t)
+		if err != nil {
+			return nil, err
+		}
+		inv.keys = append(inv.keys, k)
+	}
+	return inv, nil
+}
+
+func (inv *Invalidator) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	return inv.HandleBatch(ctx, []*listener.ChangeNotification{n})
+}
+
+// HandleBatch deletes the keys of all changes of batch at once.
+func (inv *Invalidator) HandleBatch(ctx context.Context, batch []*listener.ChangeNotification) error {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, n := range batch {
+		for _, key := range inv.Keys(n) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return inv.deleter.Delete(ctx, keys...)
+}
+
+// Keys returns the keys a change invalidates.
+func (inv *Invalidator) Keys(n *listener.ChangeNotification) []string {
+	var images []json.RawMessage
+	switch n.Operation {
+	case listener.OpInsert, listener.OpUpdate, listener.OpDelete:
+		for _, img := range []json.RawMessage{n.Old, n.New, n.Data} {
+			if img != nil {
+				images = append(images, img)
+			}
+		}
+	default:
+		return nil
+	}
+	var keys []string
+	for _, img := range images {
+		var row map[string]json.RawMessage
+		if json.Unmarshal(img, &row) != nil {
+			continue
+		}
+		for _, k := range inv.keys {
+			if key, ok := k.render(n, row); ok && !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// keyTemplate is a parsed template: literal text alternating with
+// placeholder names.
+type keyTemplate struct {
+	literals []string
+	names    []string
+}
+
+func parseKey(t string) (keyTemplate, error) {
+	var k keyTemplate
+	rest := t
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			k.literals = append(k.literals, rest)
+			return k, nil
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return k, fmt.Errorf("unclosed { in key template %q", t)
+		}
+		name := rest[open+1 : open+end]
+		if name == "" {
+			return k, fmt.Errorf("empty placeholder in key template %q", t)
+		}
+		k.literals = append(k.literals, rest[:open])
+		k.names = append(k.names, name)
+		rest = rest[open+end+1:]
+	}
+}
+
+func (k keyTemplate) render(n *listener.ChangeNotification, row map[string]json.RawMessage) (string, bool) {
+	var b strings.Builder
+	for i, name := range k.names {
+		b.WriteString(k.literals[i])
+		v, ok := placeholder(n, row, name)
+		if !ok {
+			return "", false
+		}
+		b.WriteString(v)
+	}
+	b.WriteString(k.literals[len(k.literals)-1])
+	return b.String(), true
+}
+
+func placeholder(n *listener.ChangeNotification, row map[string]json.RawMessage, name string) (string, bool) {
+	switch name {
+	case "schema", "table", "op", "channel":
+		return sink.Template("{" + name + "}")(n), true
+	}
+	raw, ok := row[name]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		return "", false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, true
+	}
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) != nil {
+		return string(raw), true
+	}
+	return buf.String(), true
+}
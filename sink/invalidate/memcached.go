@@ -0,0 +1,111 @@
+package invalidate
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// DefaultMemcachedTimeout bounds a delete when the context has no
+// deadline.
+const DefaultMemcachedTimeout = 5 * time.Second
+
+// Memcached deletes keys from memcached servers over the text protocol.
+// Keys are spread over the servers by the CRC-32 of the key, as by the
+// common clients, so entries are deleted from the server they were stored
+// on.
+func Memcached(addrs ...string) Deleter {
+	m := &memcached{servers: make([]*memcachedConn, len(addrs))}
+	for i, addr := range addrs {
+		m.servers[i] = &memcachedConn{addr: addr}
+	}
+	return m
+}
+
+type memcached struct {
+	servers []*memcachedConn
+}
+
+func (m *memcached) Delete(ctx context.Context, keys ...string) error {
+	if len(m.servers) == 0 {
+		return listener.Permanent(errors.New("no memcached servers"))
+	}
+	byServer := make(map[*memcachedConn][]string)
+	for _, key := range keys {
+		if len(key) > 250 || strings.ContainsAny(key, " \r\n\t") {
+			return listener.Permanent(fmt.Errorf("invalid memcached key %q", key))
+		}
+		s := m.servers[crc32.ChecksumIEEE([]byte(key))%uint32(len(m.servers))]
+		byServer[s] = append(byServer[s], key)
+	}
+	for s, keys := range byServer {
+		if err := s.delete(ctx, keys); err != nil {
+			return fmt.Errorf("failed to delete from memcached %s: %w", s.addr, err)
+		}
+	}
+	return nil
+}
+
+// memcachedConn is the connection to one server, redialed after errors.
+type memcachedConn struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+func (c *memcachedConn) delete(ctx context.Context, keys []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", c.addr)
+		if err != nil {
+			return err
+		}
+		c.conn, c.rw = conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultMemcachedTimeout)
+	}
+	c.conn.SetDeadline(deadline)
+
+	err := c.pipeline(keys)
+	if err != nil {
+		c.conn.Close()
+		c.conn, c.rw = nil, nil
+	}
+	return err
+}
+
+// pipeline writes the deletes, then reads their replies.
+func (c *memcachedConn) pipeline(keys []string) error {
+	for _, key := range keys {
+		fmt.Fprintf(c.rw, "delete %s\r\n", key)
+	}
+	if err := c.rw.Flush(); err != nil {
+		return err
+	}
+	for range keys {
+		line, err := c.rw.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch reply := strings.TrimRight(line, "\r\n"); reply {
+		case "DELETED", "NOT_FOUND":
+		default:
+			return fmt.Errorf("memcached replied %q", reply)
+		}
+	}
+	return nil
+}
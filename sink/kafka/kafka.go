@@ -0,0 +1,153 @@
+// Package kafka publishes change notifications to Kafka.
+package kafka
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultTopic sends each table to its own topic.
+const DefaultTopic = "{schema}.{table}"
+
+// DeliveryFunc reports the outcome of an asynchronous write.
+type DeliveryFunc func(n *listener.ChangeNotification, err error)
+
+type Sink struct {
+	writer  *kafka.Writer
+	topic   sink.Namer
+	key     func(n *listener.ChangeNotification) []byte
+	encoder sink.Encoder
+}
+
+type Option func(*Sink)
+
+// WithTopic sets the topic name template (see sink.Template).
+func WithTopic(pattern string) Option {
+	return func(s *Sink) {
+		s.topic = sink.Template(pattern)
+	}
+}
+
+// WithTopicFunc derives the topic with a custom function.
+func WithTopicFunc(fn sink.Namer) Option {
+	return func(s *Sink) {
+		s.topic = fn
+	}
+}
+
+// WithKey overrides the message key, which defaults to sink.Key so all
+// changes to a row land on the same partition.
+func WithKey(fn func(n *listener.ChangeNotification) []byte) Option {
+	return func(s *Sink) {
+		s.key = fn
+	}
+}
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.encoder = e
+	}
+}
+
+// WithAsync makes HandleNotification return once the message is queued.
+// The notification is acknowledged once the brokers confirm the write, see
+// listener.Acknowledge, so checkpoints only move past delivered messages; a
+// failed write is nacked and retried per the handler's retry policy. fn, if
+// not nil, is also told the outcome of every write.
+func WithAsync(fn DeliveryFunc) Option {
+	return func(s *Sink) {
+		s.writer.Async = true
+		s.writer.Completion = func(messages []kafka.Message, err error) {
+			for _, m := range messages {
+				d, ok := m.WriterData.(delivery)
+				if !ok {
+					continue
+				}
+				if err != nil {
+					d.ack.Nack(err)
+				} else {
+					d.ack.Ack()
+				}
+				if fn != nil {
+					fn(d.n, err)
+				}
+			}
+		}
+	}
+}
+
+// delivery is the notification of an asynchronous write, settled once the
+// write completes.
+type delivery struct {
+	n   *listener.ChangeNotification
+	ack *listener.Acknowledgement
+}
+
+// WithWriter configures the underlying writer, e.g. for TLS, SASL or
+// batching. Addr must not be changed; Topic must be left empty.
+func WithWriter(fn func(w *kafka.Writer)) Option {
+	return func(s *Sink) {
+		fn(s.writer)
+	}
+}
+
+func New(brokers []string, opts ...Option) *Sink {
+	s := &Sink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+		topic:   sink.Template(DefaultTopic),
+		key:     sink.Key,
+		encoder: sink.JSON{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	headers := []kafka.Header{{Key: "content-type", Value: []byte(s.encoder.ContentType())}}
+	for k, v := range sink.Headers(s.encoder, n) {
+		headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	msg := kafka.Message{
+		Topic:      s.topic(n),
+		Key:        s.key(n),
+		Value:      body,
+		Headers:    headers,
+		Time:       n.Timestamp,
+		WriterData: n,
+	}
+	if s.writer.Async {
+		d := delivery{n: n, ack: listener.Acknowledge(ctx)}
+		msg.WriterData = d
+		if err := s.writer.WriteMessages(ctx, msg); err != nil {
+			d.ack.Nack(err)
+			return fmt.Errorf("failed to write to kafka: %w", err)
+		}
+		return nil
+	}
+	if err := s.writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to write to kafka: %w", err)
+	}
+	return nil
+}
+
+// Close flushes pending messages and closes the writer.
+func (s *Sink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,95 @@
+// Package mqtt publishes change notifications to an MQTT broker.
+package mqtt
+
+import (
+	"context"
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultTopic lets clients subscribe per table ("db/public/s_user/+") or
+// per operation ("db/+/+/delete").
+const DefaultTopic = "db/{schema}/{table}/{op}"
+
+type Sink struct {
+	client   mqtt.Client
+	topic    sink.Namer
+	qos      byte
+	retained bool
+	encoder  sink.Encoder
+}
+
+type Option func(*Sink)
+
+// WithTopic sets the topic template (see sink.Template).
+func WithTopic(pattern string) Option {
+	return func(s *Sink) {
+		s.topic = sink.Template(pattern)
+	}
+}
+
+func WithTopicFunc(fn sink.Namer) Option {
+	return func(s *Sink) {
+		s.topic = fn
+	}
+}
+
+// WithQoS sets the publish QoS (0, 1 or 2; 1 by default). With QoS 0 the
+// handler returns once the message is written to the connection.
+func WithQoS(qos byte) Option {
+	return func(s *Sink) {
+		s.qos = qos
+	}
+}
+
+// WithRetained publishes retained messages, so clients subscribing later
+// immediately get the latest change on each topic.
+func WithRetained() Option {
+	return func(s *Sink) {
+		s.retained = true
+	}
+}
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.encoder = e
+	}
+}
+
+// New publishes through client, which the caller configures and connects;
+// enable its auto-reconnect to ride out broker restarts.
+func New(client mqtt.Client, opts ...Option) *Sink {
+	s := &Sink{
+		client:  client,
+		topic:   sink.Template(DefaultTopic),
+		qos:     1,
+		encoder: sink.JSON{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	topic := s.topic(n)
+	token := s.client.Publish(topic, s.qos, s.retained, body)
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
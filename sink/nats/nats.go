@@ -0,0 +1,143 @@
+// Package nats republishes change notifications to NATS subjects, either
+// fire-and-forget or through JetStream with publish acks.
+package nats
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultSubject publishes each change to db.<schema>.<table>.<op>.
+const DefaultSubject = "db.{schema}.{table}.{op}"
+
+var placeholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+type Sink struct {
+	nc        *nats.Conn
+	js        jetstream.JetStream
+	subject   sink.Namer
+	pattern   string
+	encoder   sink.Encoder
+	jetStream bool
+	stream    *jetstream.StreamConfig
+}
+
+type Option func(*Sink)
+
+// WithSubject sets the subject template (see sink.Template).
+func WithSubject(pattern string) Option {
+	return func(s *Sink) {
+		s.subject = sink.Template(pattern)
+		s.pattern = pattern
+	}
+}
+
+// WithSubjectFunc derives the subject with a custom function. Streams
+// created by WithStream then need explicit subjects.
+func WithSubjectFunc(fn sink.Namer) Option {
+	return func(s *Sink) {
+		s.subject = fn
+		s.pattern = ""
+	}
+}
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.encoder = e
+	}
+}
+
+// WithJetStream publishes through JetStream and waits for the ack, so a
+// change is only considered handled once the stream has stored it.
+// Outbox IDs are sent as message IDs, letting the stream drop replays.
+func WithJetStream() Option {
+	return func(s *Sink) {
+		s.jetStream = true
+	}
+}
+
+// WithStream makes Init create or update the stream and implies
+// WithJetStream. Without subjects, the stream captures the subject
+// template with each placeholder replaced by a wildcard.
+func WithStream(cfg jetstream.StreamConfig) Option {
+	return func(s *Sink) {
+		s.jetStream = true
+		s.stream = &cfg
+	}
+}
+
+func New(nc *nats.Conn, opts ...Option) (*Sink, error) {
+	s := &Sink{
+		nc:      nc,
+		subject: sink.Template(DefaultSubject),
+		pattern: DefaultSubject,
+		encoder: sink.JSON{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.jetStream {
+		js, err := jetstream.New(nc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+		}
+		s.js = js
+	}
+	return s, nil
+}
+
+// Init creates the stream configured with WithStream.
+func (s *Sink) Init(ctx context.Context) error {
+	if s.stream == nil {
+		return nil
+	}
+	cfg := *s.stream
+	if len(cfg.Subjects) == 0 {
+		if s.pattern == "" {
+			return fmt.Errorf("stream %s needs subjects when a subject func is used", cfg.Name)
+		}
+		cfg.Subjects = []string{placeholder.ReplaceAllString(s.pattern, "*")}
+	}
+	if _, err := s.js.CreateOrUpdateStream(ctx, cfg); err != nil {
+		return fmt.Errorf("failed to create stream %s: %w", cfg.Name, err)
+	}
+	return nil
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	msg := nats.NewMsg(s.subject(n))
+	msg.Data = body
+	msg.Header.Set("Content-Type", s.encoder.ContentType())
+	for k, v := range sink.Headers(s.encoder, n) {
+		msg.Header.Set(k, v)
+	}
+
+	if !s.jetStream {
+		if err := s.nc.PublishMsg(msg); err != nil {
+			return fmt.Errorf("failed to publish to %s: %w", msg.Subject, err)
+		}
+		return nil
+	}
+
+	var opts []jetstream.PublishOpt
+	if n.ID > 0 {
+		opts = append(opts, jetstream.WithMsgID(strconv.FormatInt(n.ID, 10)))
+	}
+	if _, err := s.js.PublishMsg(ctx, msg, opts...); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", msg.Subject, err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Package notify posts human-readable messages about changes, and about
+// notifications the listener gave up on, to Slack, Microsoft Teams or
+// e-mail.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// OpFailure is the operation of the notifications of Failures.
+const OpFailure = "FAILURE"
+
+const (
+	DefaultSubject = `{{.Operation}} on {{.Schema}}.{{.Table}}`
+	DefaultBody    = `{{.Timestamp.Format "2006-01-02 15:04:05Z07:00"}}
+{{if .Data}}{{json .Data}}{{else if .New}}{{json .New}}{{else}}{{json .Old}}{{end}}`
+)
+
+// Message is what a Sender delivers.
+type Message struct {
+	Subject string
+	Text    string
+}
+
+// Sender delivers messages to one service. Failures worth retrying are
+// returned as is, others as listener.Permanent.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Sink struct {
+	sender   Sender
+	subject  sink.Encoder
+	body     sink.Encoder
+	throttle time.Duration
+
+	mu        sync.Mutex
+	throttled map[string]*throttled
+}
+
+type throttled struct {
+	last       time.Time
+	suppressed int
+}
+
+type Option func(*Sink)
+
+// WithSubject renders the subjects, DefaultSubject by default. Slack
+// messages have no subject and show it as their first line.
+func WithSubject(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.subject = e
+	}
+}
+
+// WithBody renders the message texts, DefaultBody by default.
+func WithBody(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.body = e
+	}
+}
+
+// WithThrottle sends at most one message per table and interval. The
+// changes in between are dropped and counted in the next message.
+func WithThrottle(interval time.Duration) Option {
+	return func(s *Sink) {
+		s.throttle = interval
+	}
+}
+
+func New(sender Sender, opts ...Option) *Sink {
+	s := &Sink{
+		sender:    sender,
+		subject:   mustRender(DefaultSubject),
+		body:      mustRender(DefaultBody),
+		throttled: make(map[string]*throttled),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func mustRender(text string) *sink.Render {
+	r, err := sink.NewRender(text, "")
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	suppressed, ok := s.allow(n)
+	if !ok {
+		return nil
+	}
+	subject, err := s.subject.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to render subject: %w", err))
+	}
+	body, err := s.body.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to render message: %w", err))
+	}
+	msg := Message{Subject: string(subject), Text: string(body)}
+	if suppressed > 0 {
+		msg.Text += fmt.Sprintf("\n(%d more on %s suppressed)", suppressed, n.QualifiedTable())
+	}
+	return s.sender.Send(ctx, msg)
+}
+
+// allow reports whether a message about n may be sent now, and how many
+// were suppressed since the last one.
+func (s *Sink) allow(n *listener.ChangeNotification) (int, bool) {
+	if s.throttle <= 0 {
+		return 0, true
+	}
+	key := n.QualifiedTable()
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.throttled[key]
+	if !ok {
+		s.throttled[key] = &throttled{last: now}
+		return 0, true
+	}
+	if now.Sub(t.last) < s.throttle {
+		t.suppressed++
+		return 0, false
+	}
+	suppressed := t.suppressed
+	t.last, t.suppressed = now, 0
+	return suppressed, true
+}
+
+// failure is the data of the notifications of Failures.
+type failure struct {
+	Operation string          `json:"operation"`
+	Error     string          `json:"error"`
+	Attempts  int             `json:"attempts"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Failures returns an ErrorHandler passing h a notification with
+// Operation OpFailure for each notification the listener gave up on, with
+// the operation, error, attempts and row of the failed notification as
+// Data. Errors of h are logged with slog.Default.
+func Failures(h listener.NotificationHandler) listener.ErrorHandler {
+	return func(ctx context.Context, f *listener.Failure) {
+		n := f.Notification
+		row := n.Data
+		if row == nil {
+			row = n.New
+		}
+		if row == nil {
+			row = n.Old
+		}
+		data, err := json.Marshal(failure{Operation: n.Operation, Error: f.Err.Error(), Attempts: f.Attempts, Data: row})
+		if err == nil {
+			err = h.HandleNotification(ctx, &listener.ChangeNotification{
+				ID:        n.ID,
+				Channel:   n.Channel,
+				Schema:    n.Schema,
+				Table:     n.Table,
+				Tenant:    n.Tenant,
+				Operation: OpFailure,
+				Data:      data,
+				Timestamp: f.LastAttempt,
+			})
+		}
+		if err != nil {
+			slog.Default().Error("failed to report failed notification",
+				"channel", n.Channel, "table", n.Table, "error", err)
+		}
+	}
+}
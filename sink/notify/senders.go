@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// DefaultTimeout bounds each request of the webhook senders.
+const DefaultTimeout = 10 * time.Second
+
+// Slack posts to a Slack incoming webhook.
+func Slack(url string) Sender {
+	return &webhook{url: url, client: http.DefaultClient, payload: func(msg Message) any {
+		text := msg.Text
+		if msg.Subject != "" {
+			text = "*" + msg.Subject + "*\n" + text
+		}
+		return map[string]string{"text": text}
+	}}
+}
+
+// Teams posts a message card to a Microsoft Teams incoming webhook or
+// workflow.
+func Teams(url string) Sender {
+	return &webhook{url: url, client: http.DefaultClient, payload: func(msg Message) any {
+		return map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  msg.Subject,
+			"title":    msg.Subject,
+			"text":     msg.Text,
+		}
+	}}
+}
+
+type webhook struct {
+	url     string
+	client  *http.Client
+	payload func(Message) any
+}
+
+func (w *webhook) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(w.payload(msg))
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, DefaultTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(detail))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return listener.Permanent(err)
+}
+
+// SMTP is the server and envelope of Email.
+type SMTP struct {
+	// Addr is host:port of the server, which must offer STARTTLS to
+	// authenticate.
+	Addr string
+	From string
+	To   []string
+	// Username and Password authenticate with PLAIN when Username is set.
+	Username string
+	Password string
+}
+
+// Email sends plain text e-mails through an SMTP server.
+func Email(cfg SMTP) Sender {
+	return &email{cfg: cfg}
+}
+
+type email struct {
+	cfg SMTP
+}
+
+func (e *email) Send(_ context.Context, msg Message) error {
+	if len(e.cfg.To) == 0 {
+		return listener.Permanent(errors.New("no recipients"))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", e.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.cfg.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerLine(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.cfg.Username != "" {
+		host, _, _ := strings.Cut(e.cfg.Addr, ":")
+		auth = smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, host)
+	}
+	// net/smtp takes no context; the listener's handler timeout does not
+	// interrupt a hanging server.
+	if err := smtp.SendMail(e.cfg.Addr, auth, e.cfg.From, e.cfg.To, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to send e-mail: %w", err)
+	}
+	return nil
+}
+
+// headerLine keeps a subject to its first line.
+func headerLine(s string) string {
+	s, _, _ = strings.Cut(s, "\n")
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,244 @@
+// Package postgres applies change notifications to a second PostgreSQL
+// database, mirroring tables by primary key.
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultTable mirrors into a table of the same name.
+const DefaultTable = "{schema}.{table}"
+
+// Mirror upserts inserted and updated rows and deletes removed ones. Only
+// the columns present in the payload are written, so the target table may
+// have extra columns but must have the source's primary key as primary key
+// or unique constraint. Use listener.ByKey ordering with workers so changes
+// to one row are applied in order.
+type Mirror struct {
+	db    *sql.DB
+	table sink.Namer
+	keys  map[string][]string
+}
+
+type Option func(*Mirror)
+
+// WithTable maps source tables to target tables (see sink.Template).
+func WithTable(pattern string) Option {
+	return func(m *Mirror) {
+		m.table = sink.Template(pattern)
+	}
+}
+
+func WithTableFunc(fn sink.Namer) Option {
+	return func(m *Mirror) {
+		m.table = fn
+	}
+}
+
+// WithKeyColumns sets the key columns of a source table ("schema.table" or
+// a table in the default schema) for triggers installed without them.
+func WithKeyColumns(table string, columns ...string) Option {
+	return func(m *Mirror) {
+		if !strings.Contains(table, ".") {
+			table = listener.DefaultSchema + "." + table
+		}
+		m.keys[table] = columns
+	}
+}
+
+func New(db *sql.DB, opts ...Option) *Mirror {
+	m := &Mirror{
+		db:    db,
+		table: sink.Template(DefaultTable),
+		keys:  make(map[string][]string),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Mirror) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	keyCols, err := m.keyColumns(n)
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	table := quoteName(m.table(n))
+
+	var stmts []statement
+	switch n.Operation {
+	case listener.OpInsert, listener.OpUpdate:
+		row, err := decodeRow(n.New)
+		if err != nil {
+			return listener.Permanent(fmt.Errorf("failed to decode %s row: %w", n.QualifiedTable(), err))
+		}
+		// A changed primary key moves the row; remove the old one first.
+		if n.Operation == listener.OpUpdate && n.Old != nil {
+			if old, err := decodeRow(n.Old); err == nil && !sameKey(old, row, keyCols) {
+				stmts = append(stmts, deleteStatement(table, keyCols, n.Old))
+			}
+		}
+		stmts = append(stmts, upsertStatement(table, keyCols, row, n.New))
+	case listener.OpDelete:
+		key := n.Key
+		if key == nil {
+			key = n.Old
+		}
+		stmts = append(stmts, deleteStatement(table, keyCols, key))
+	default:
+		return nil
+	}
+	return m.exec(ctx, n, stmts)
+}
+
+type statement struct {
+	query string
+	arg   []byte
+}
+
+func (m *Mirror) exec(ctx context.Context, n *listener.ChangeNotification, stmts []statement) error {
+	if len(stmts) == 1 {
+		if _, err := m.db.ExecContext(ctx, stmts[0].query, stmts[0].arg); err != nil {
+			return classify(fmt.Errorf("failed to apply %s %s: %w", n.Operation, n.QualifiedTable(), err))
+		}
+		return nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, st := range stmts {
+		if _, err := tx.ExecContext(ctx, st.query, st.arg); err != nil {
+			return classify(fmt.Errorf("failed to apply %s %s: %w", n.Operation, n.QualifiedTable(), err))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// keyColumns prefers the key carried by the notification over configured
+// columns.
+func (m *Mirror) keyColumns(n *listener.ChangeNotification) ([]string, error) {
+	if n.Key != nil {
+		key, err := decodeRow(n.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key of %s: %w", n.QualifiedTable(), err)
+		}
+		if len(key) > 0 {
+			return sortedColumns(key), nil
+		}
+	}
+	if cols, ok := m.keys[n.QualifiedTable()]; ok && len(cols) > 0 {
+		return cols, nil
+	}
+	return nil, fmt.Errorf("no key columns for %s", n.QualifiedTable())
+}
+
+// jsonb_populate_record converts the payload to the target's column types,
+// which also lets the key lookups use the primary key index.
+func upsertStatement(table string, keyCols []string, row map[string]json.RawMessage, data []byte) statement {
+	cols := sortedColumns(row)
+	quoted := make([]string, len(cols))
+	for i, col := range cols {
+		quoted[i] = pq.QuoteIdentifier(col)
+	}
+
+	isKey := make(map[string]bool, len(keyCols))
+	conflict := make([]string, len(keyCols))
+	for i, col := range keyCols {
+		isKey[col] = true
+		conflict[i] = pq.QuoteIdentifier(col)
+	}
+	var sets []string
+	for i, col := range cols {
+		if !isKey[col] {
+			sets = append(sets, fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", quoted[i]))
+		}
+	}
+	action := "DO NOTHING"
+	if len(sets) > 0 {
+		action = "DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+
+	list := strings.Join(quoted, ", ")
+	return statement{
+		query: fmt.Sprintf(`INSERT INTO %[1]s (%[2]s) SELECT %[2]s FROM jsonb_populate_record(NULL::%[1]s, $1::jsonb) ON CONFLICT (%[3]s) %[4]s`,
+			table, list, strings.Join(conflict, ", "), action),
+		arg: data,
+	}
+}
+
+func deleteStatement(table string, keyCols []string, key []byte) statement {
+	conds := make([]string, len(keyCols))
+	for i, col := range keyCols {
+		conds[i] = fmt.Sprintf("t.%[1]s = k.%[1]s", pq.QuoteIdentifier(col))
+	}
+	return statement{
+		query: fmt.Sprintf(`DELETE FROM %[1]s AS t USING jsonb_populate_record(NULL::%[1]s, $1::jsonb) AS k WHERE %[2]s`,
+			table, strings.Join(conds, " AND ")),
+		arg: key,
+	}
+}
+
+// classify marks errors that retrying cannot fix: data exceptions and
+// undefined tables or columns.
+func classify(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "22", "42":
+			return listener.Permanent(err)
+		}
+	}
+	return err
+}
+
+func sameKey(a, b map[string]json.RawMessage, cols []string) bool {
+	for _, col := range cols {
+		if !bytes.Equal(a[col], b[col]) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeRow(data []byte) (map[string]json.RawMessage, error) {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func sortedColumns(row map[string]json.RawMessage) []string {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+func quoteName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,144 @@
+// Package pubsub publishes change notifications to Google Cloud Pub/Sub.
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub/v2"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultTopic sends each table to its own topic.
+const DefaultTopic = "{schema}.{table}"
+
+// Sink keeps one publisher per topic, so batching and flow control apply
+// per topic as configured with WithPublishSettings.
+type Sink struct {
+	client     *pubsub.Client
+	topic      sink.Namer
+	attributes func(n *listener.ChangeNotification) map[string]string
+	encoder    sink.Encoder
+	settings   *pubsub.PublishSettings
+	ordering   bool
+
+	mu         sync.Mutex
+	publishers map[string]*pubsub.Publisher
+}
+
+type Option func(*Sink)
+
+// WithTopic sets the topic ID template (see sink.Template).
+func WithTopic(pattern string) Option {
+	return func(s *Sink) {
+		s.topic = sink.Template(pattern)
+	}
+}
+
+func WithTopicFunc(fn sink.Namer) Option {
+	return func(s *Sink) {
+		s.topic = fn
+	}
+}
+
+// WithAttributes replaces the message attributes, which default to
+// sink.Headers.
+func WithAttributes(fn func(n *listener.ChangeNotification) map[string]string) Option {
+	return func(s *Sink) {
+		s.attributes = fn
+	}
+}
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.encoder = e
+	}
+}
+
+// WithOrdering sets sink.RowKey as ordering key, keeping changes to a row
+// in order for subscriptions with message ordering enabled.
+func WithOrdering() Option {
+	return func(s *Sink) {
+		s.ordering = true
+	}
+}
+
+// WithPublishSettings sets batching (DelayThreshold, CountThreshold,
+// ByteThreshold) and flow control for every publisher.
+func WithPublishSettings(settings pubsub.PublishSettings) Option {
+	return func(s *Sink) {
+		s.settings = &settings
+	}
+}
+
+func New(client *pubsub.Client, opts ...Option) *Sink {
+	s := &Sink{
+		client:     client,
+		topic:      sink.Template(DefaultTopic),
+		encoder:    sink.JSON{},
+		publishers: make(map[string]*pubsub.Publisher),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Sink) publisher(topic string) *pubsub.Publisher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.publishers[topic]
+	if !ok {
+		p = s.client.Publisher(topic)
+		if s.settings != nil {
+			p.PublishSettings = *s.settings
+		}
+		p.EnableMessageOrdering = s.ordering
+		s.publishers[topic] = p
+	}
+	return p
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	msg := &pubsub.Message{Data: body}
+	if s.attributes != nil {
+		msg.Attributes = s.attributes(n)
+	} else {
+		msg.Attributes = sink.Headers(s.encoder, n)
+	}
+	if s.ordering {
+		msg.OrderingKey = sink.RowKey(n)
+	}
+
+	topic := s.topic(n)
+	p := s.publisher(topic)
+	if _, err := p.Publish(ctx, msg).Get(ctx); err != nil {
+		if s.ordering {
+			// A failed ordered publish pauses its key until resumed; the
+			// listener retries the change itself.
+			p.ResumePublish(msg.OrderingKey)
+		}
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// Close flushes and stops all publishers.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for topic, p := range s.publishers {
+		p.Stop()
+		delete(s.publishers, topic)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Package rabbitmq publishes change notifications to a RabbitMQ exchange.
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultRoutingKey suits a topic exchange, e.g. bindings like
+// "public.s_user.*" or "*.*.delete".
+const DefaultRoutingKey = "{schema}.{table}.{op}"
+
+// Sink publishes with publisher confirms. The connection is opened on first
+// use and reopened on the next publish after it breaks, so a broker outage
+// surfaces as handler errors that the listener retries.
+type Sink struct {
+	url          string
+	exchange     string
+	exchangeKind string
+	routingKey   sink.Namer
+	encoder      sink.Encoder
+	config       amqp.Config
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+type Option func(*Sink)
+
+// WithRoutingKey sets the routing key template (see sink.Template).
+func WithRoutingKey(pattern string) Option {
+	return func(s *Sink) {
+		s.routingKey = sink.Template(pattern)
+	}
+}
+
+func WithRoutingKeyFunc(fn sink.Namer) Option {
+	return func(s *Sink) {
+		s.routingKey = fn
+	}
+}
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.encoder = e
+	}
+}
+
+// WithExchangeDeclare declares the exchange as durable of the given kind
+// (e.g. amqp.ExchangeTopic) on every connect.
+func WithExchangeDeclare(kind string) Option {
+	return func(s *Sink) {
+		s.exchangeKind = kind
+	}
+}
+
+// WithConfig sets the dial config, e.g. for TLS or heartbeats.
+func WithConfig(cfg amqp.Config) Option {
+	return func(s *Sink) {
+		s.config = cfg
+	}
+}
+
+func New(url, exchange string, opts ...Option) *Sink {
+	s := &Sink{
+		url:        url,
+		exchange:   exchange,
+		routingKey: sink.Template(DefaultRoutingKey),
+		encoder:    sink.JSON{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Sink) channel() (*amqp.Channel, error) {
+	if s.ch != nil && !s.ch.IsClosed() {
+		return s.ch, nil
+	}
+	s.reset()
+
+	conn, err := amqp.DialConfig(s.url, s.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to rabbitmq: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	if s.exchangeKind != "" {
+		if err := ch.ExchangeDeclare(s.exchange, s.exchangeKind, true, false, false, false, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to declare exchange %s: %w", s.exchange, err)
+		}
+	}
+	s.conn, s.ch = conn, ch
+	return ch, nil
+}
+
+func (s *Sink) reset() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.ch = nil, nil
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	headers := amqp.Table{}
+	for k, v := range sink.Headers(s.encoder, n) {
+		headers[k] = v
+	}
+	msg := amqp.Publishing{
+		Headers:      headers,
+		ContentType:  s.encoder.ContentType(),
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    n.Timestamp,
+		Body:         body,
+	}
+	if n.ID > 0 {
+		msg.MessageId = strconv.FormatInt(n.ID, 10)
+	}
+	key := s.routingKey(n)
+
+	// Confirms are tracked per channel, so publishes are serialized.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, err := s.channel()
+	if err != nil {
+		return err
+	}
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, s.exchange, key, false, false, msg)
+	if err != nil {
+		s.reset()
+		return fmt.Errorf("failed to publish to %s: %w", s.exchange, err)
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		s.reset()
+		return fmt.Errorf("failed to confirm publish to %s: %w", s.exchange, err)
+	}
+	if !acked {
+		return fmt.Errorf("publish to %s with key %s was nacked", s.exchange, key)
+	}
+	return nil
+}
+
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.ch = nil, nil
+	return err
+}
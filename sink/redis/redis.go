@@ -0,0 +1,115 @@
+// Package redis mirrors change notifications into Redis pub/sub channels
+// and streams.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	DefaultChannel = "db:{schema}.{table}"
+	DefaultStream  = "db:{schema}.{table}"
+)
+
+// Sink PUBLISHes each change and, when a stream is configured, XADDs it
+// too. Both commands go out in one pipeline.
+type Sink struct {
+	client  redis.UniversalClient
+	channel sink.Namer
+	stream  sink.Namer
+	maxLen  int64
+	encoder sink.Encoder
+}
+
+type Option func(*Sink)
+
+// WithChannel sets the pub/sub channel template (see sink.Template); an
+// empty pattern disables PUBLISH.
+func WithChannel(pattern string) Option {
+	return func(s *Sink) {
+		s.channel = namer(pattern)
+	}
+}
+
+// WithStream enables XADD into the stream named by pattern, e.g.
+// DefaultStream for one stream per table.
+func WithStream(pattern string) Option {
+	return func(s *Sink) {
+		s.stream = namer(pattern)
+	}
+}
+
+// WithMaxLen caps streams at approximately n entries (MAXLEN ~).
+func WithMaxLen(n int64) Option {
+	return func(s *Sink) {
+		s.maxLen = n
+	}
+}
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.encoder = e
+	}
+}
+
+func namer(pattern string) sink.Namer {
+	if pattern == "" {
+		return nil
+	}
+	return sink.Template(pattern)
+}
+
+func New(client redis.UniversalClient, opts ...Option) *Sink {
+	s := &Sink{
+		client:  client,
+		channel: sink.Template(DefaultChannel),
+		encoder: sink.JSON{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	if s.channel == nil && s.stream == nil {
+		return nil
+	}
+	body, err := s.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	_, err = s.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		if s.channel != nil {
+			p.Publish(ctx, s.channel(n), body)
+		}
+		if s.stream != nil {
+			values := map[string]any{"payload": body}
+			for k, v := range sink.Headers(s.encoder, n) {
+				values[k] = v
+			}
+			if n.ID > 0 {
+				values["id"] = strconv.FormatInt(n.ID, 10)
+			}
+			p.XAdd(ctx, &redis.XAddArgs{
+				Stream: s.stream(n),
+				MaxLen: s.maxLen,
+				Approx: s.maxLen > 0,
+				Values: values,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write to redis: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// Config is what a Factory creates a sink from: the fields of a sink in
+// the config file, the encoder chosen by its encoding or template and the
+// logger.
+type Config struct {
+	Type     string
+	URL      string
+	Brokers  []string
+	Target   string
+	Exchange string
+	Secret   string
+	// Options holds the settings of sink types without a field of their own.
+	Options map[string]string
+	Encoder Encoder
+	Logger  *slog.Logger
+}
+
+// Factory creates a sink of a registered type. The closer, which may be
+// nil, releases its clients when the sink is removed.
+type Factory func(cfg Config) (listener.NotificationHandler, io.Closer, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a sink type available to configs by name. It is meant to
+// be called from the init function of the package implementing the type,
+// so a binary gains the type by importing that package:
+//
+//	func init() {
+//		sink.Register("slack", newSlack)
+//	}
+//
+// Register panics when typ is registered twice or f is nil.
+func Register(typ string, f Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if f == nil {
+		panic("sink: Register factory is nil")
+	}
+	if _, dup := registry[typ]; dup {
+		panic("sink: Register called twice for type " + typ)
+	}
+	registry[typ] = f
+}
+
+// Lookup returns the factory of a registered sink type.
+func Lookup(typ string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[typ]
+	return f, ok
+}
+
+// Types returns the registered sink types in order.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return slices.Sorted(maps.Keys(registry))
+}
@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// DefaultRenderContentType is the content type of Render without one.
+const DefaultRenderContentType = "text/plain; charset=utf-8"
+
+// Render encodes notifications by executing a text/template, so message
+// formats such as Slack or e-mail bodies are set in configuration:
+//
+//	{"text": {{printf "%s on %s" .Operation .Table | json}}, "id": {{json .Data.id}}}
+//
+// The template executes on a RenderData. Besides the text/template
+// builtins it can call json, which writes a value as JSON (use it for
+// every value in JSON templates), lower and upper. With a JSON content
+// type the output must be valid JSON.
+type Render struct {
+	tmpl        *template.Template
+	contentType string
+}
+
+// RenderData is what a Render template executes on. The rows are decoded
+// from JSON, with numbers as json.Number; they are nil when absent.
+type RenderData struct {
+	ID        int64
+	Seq       int64
+	Channel   string
+	Schema    string
+	Table     string
+	Operation string
+	Tenant    string
+	Actor     string
+	Timestamp time.Time
+	TxID      int64
+	Snapshot  bool
+	Key       any
+	Data      any
+	Old       any
+	New       any
+	// Notification is the notification as received.
+	Notification *listener.ChangeNotification
+}
+
+var renderFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// NewRender parses text as a template producing bodies of contentType,
+// DefaultRenderContentType when empty.
+func NewRender(text, contentType string) (*Render, error) {
+	tmpl, err := template.New("body").Funcs(renderFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = DefaultRenderContentType
+	}
+	return &Render{tmpl: tmpl, contentType: contentType}, nil
+}
+
+func (r *Render) Encode(n *listener.ChangeNotification) ([]byte, error) {
+	data := RenderData{
+		ID:           n.ID,
+		Seq:          n.Seq,
+		Channel:      n.Channel,
+		Schema:       n.Schema,
+		Table:        n.Table,
+		Operation:    n.Operation,
+		Tenant:       n.Tenant,
+		Actor:        n.Actor,
+		Timestamp:    n.Timestamp,
+		TxID:         n.TxID,
+		Snapshot:     n.Snapshot,
+		Notification: n,
+	}
+	if data.Schema == "" {
+		data.Schema = listener.DefaultSchema
+	}
+	for _, row := range []struct {
+		dst *any
+		raw json.RawMessage
+	}{{&data.Key, n.Key}, {&data.Data, n.Data}, {&data.Old, n.Old}, {&data.New, n.New}} {
+		if len(row.raw) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(row.raw))
+		dec.UseNumber()
+		if err := dec.Decode(row.dst); err != nil {
+			return nil, fmt.Errorf("failed to decode row: %w", err)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := r.tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	if strings.Contains(r.contentType, "json") && !json.Valid(b.Bytes()) {
+		return nil, errors.New("template output is not valid JSON")
+	}
+	return b.Bytes(), nil
+}
+
+func (r *Render) ContentType() string { return r.contentType }
@@ -0,0 +1,96 @@
+package search
+
+import (
+	"context"
+	"errors"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// BleveIndex is an Index and Searcher persisted on disk by Bleve.
+// Queries use the Bleve query string syntax, e.g. "name:keyboard +price:>100".
+// Safe for concurrent use.
+type BleveIndex struct {
+	index bleve.Index
+}
+
+// OpenBleve opens the Bleve index at path, creating it if it does not
+// exist, or creates an in-memory one when path is empty. New indexes
+// analyze text with the CJK analyzer, which matches Chinese, Japanese and
+// Korean text by pairs of characters and other text by words, and store
+// the fields hits return.
+func OpenBleve(path string) (*BleveIndex, error) {
+	if path != "" {
+		idx, err := bleve.Open(path)
+		if err == nil {
+			return &BleveIndex{index: idx}, nil
+		}
+		if !errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+			return nil, err
+		}
+	}
+
+	m := bleve.NewIndexMapping()
+	m.DefaultAnalyzer = cjk.AnalyzerName
+	m.StoreDynamic = true
+	table := bleve.NewKeywordFieldMapping()
+	m.DefaultMapping.AddFieldMappingsAt(TableField, table)
+
+	var idx bleve.Index
+	var err error
+	if path == "" {
+		idx, err = bleve.NewMemOnly(m)
+	} else {
+		idx, err = bleve.New(path, m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BleveIndex{index: idx}, nil
+}
+
+// Bleve returns the underlying index, e.g. for queries Search cannot
+// express.
+func (b *BleveIndex) Bleve() bleve.Index {
+	return b.index
+}
+
+func (b *BleveIndex) Index(id string, doc any) error {
+	return b.index.Index(id, doc)
+}
+
+func (b *BleveIndex) Delete(id string) error {
+	return b.index.Delete(id)
+}
+
+// Search runs q.Text as a query string, restricted to q.Table if set.
+func (b *BleveIndex) Search(ctx context.Context, q Query) ([]Hit, error) {
+	var match query.Query = bleve.NewQueryStringQuery(q.Text)
+	if q.Table != "" {
+		table := bleve.NewTermQuery(qualify(q.Table))
+		table.SetField(TableField)
+		match = bleve.NewConjunctionQuery(match, table)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	req := bleve.NewSearchRequestOptions(match, limit, 0, false)
+	req.Fields = []string{"*"}
+	res, err := b.index.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]Hit, len(res.Hits))
+	for i, h := range res.Hits {
+		hits[i] = Hit{ID: h.ID, Score: h.Score, Fields: h.Fields}
+	}
+	return hits, nil
+}
+
+// Close closes the index.
+func (b *BleveIndex) Close() error {
+	return b.index.Close()
+}
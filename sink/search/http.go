@@ -0,0 +1,59 @@
+package search
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultLimit is the number of hits returned when a request names
+	// none.
+	DefaultLimit = 20
+	// MaxLimit caps the limit of a request.
+	MaxLimit = 1000
+)
+
+// Handler serves GET ?q=<words>[&table=<table>][&limit=<n>] with the hits
+// of s as JSON: {"hits": [{"id": ..., "score": ..., "fields": {...}}]}.
+// Hits of other tables are dropped when table is given, for searchers that
+// do not restrict them themselves.
+func Handler(s Searcher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		q := Query{Text: r.URL.Query().Get("q"), Table: r.URL.Query().Get("table"), Limit: DefaultLimit}
+		if q.Text == "" {
+			http.Error(w, "missing q", http.StatusBadRequest)
+			return
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			q.Limit = min(n, MaxLimit)
+		}
+		hits, err := s.Search(r.Context(), q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if q.Table != "" {
+			prefix := qualify(q.Table) + ":"
+			hits = slices.DeleteFunc(hits, func(h Hit) bool { return !strings.HasPrefix(h.ID, prefix) })
+		}
+		if hits == nil {
+			hits = []Hit{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Hits []Hit `json:"hits"`
+		}{hits})
+	})
+}
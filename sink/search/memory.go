@@ -0,0 +1,197 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// MemoryIndex is an in-memory Index and Searcher for tables small enough
+// to index on every start, e.g. after a snapshot. It matches documents
+// holding all words of the query in any string field, scored by TF-IDF.
+// Words are runs of letters and digits compared case-insensitively, and
+// each Han character is a word on its own, so Chinese text is matched by
+// characters. Safe for concurrent use.
+type MemoryIndex struct {
+	mu   sync.RWMutex
+	docs map[string]*memoryDoc
+	// terms maps each word to the documents holding it, with its count.
+	terms map[string]map[string]int
+}
+
+type memoryDoc struct {
+	fields map[string]any
+	terms  map[string]int
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{docs: make(map[string]*memoryDoc), terms: make(map[string]map[string]int)}
+}
+
+// Index adds or replaces the document id. doc is a map or a value that
+// encodes to a JSON object.
+func (m *MemoryIndex) Index(id string, doc any) error {
+	fields, ok := doc.(map[string]any)
+	if !ok {
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to encode document %s: %w", id, err)
+		}
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return fmt.Errorf("document %s is not an object: %w", id, err)
+		}
+	}
+	d := &memoryDoc{fields: fields, terms: make(map[string]int)}
+	for name, v := range fields {
+		if name == TableField {
+			continue
+		}
+		for _, t := range words(v) {
+			d.terms[t]++
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	m.docs[id] = d
+	for t, count := range d.terms {
+		if m.terms[t] == nil {
+			m.terms[t] = make(map[string]int)
+		}
+		m.terms[t][id] = count
+	}
+	return nil
+}
+
+func (m *MemoryIndex) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	return nil
+}
+
+// remove drops the document id; m.mu is held.
+func (m *MemoryIndex) remove(id string) {
+	d, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	for t := range d.terms {
+		delete(m.terms[t], id)
+		if len(m.terms[t]) == 0 {
+			delete(m.terms, t)
+		}
+	}
+	delete(m.docs, id)
+}
+
+func (m *MemoryIndex) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.docs)
+}
+
+// Search returns the documents matching q, best first; hits of equal
+// score are ordered by id. A query without words matches nothing. The
+// Fields of hits are shared with the index and must not be modified.
+func (m *MemoryIndex) Search(_ context.Context, q Query) ([]Hit, error) {
+	terms := words(q.Text)
+	if len(terms) == 0 {
+		return []Hit{}, nil
+	}
+	table := ""
+	if q.Table != "" {
+		table = qualify(q.Table)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	// Start from the rarest word, which has the fewest candidates.
+	slices.SortFunc(terms, func(a, b string) int {
+		return cmp.Compare(len(m.terms[a]), len(m.terms[b]))
+	})
+	hits := []Hit{}
+	for id := range m.terms[terms[0]] {
+		d := m.docs[id]
+		if table != "" && d.fields[TableField] != table {
+			continue
+		}
+		score := 0.0
+		for _, t := range terms {
+			count, ok := m.terms[t][id]
+			if !ok {
+				score = -1
+				break
+			}
+			idf := math.Log(1 + float64(len(m.docs))/float64(len(m.terms[t])))
+			score += float64(count) * idf
+		}
+		if score < 0 {
+			continue
+		}
+		hits = append(hits, Hit{ID: id, Score: score, Fields: d.fields})
+	}
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
+
+// words returns the words of the strings in v, lowercased, repeated as
+// often as they occur.
+func words(v any) []string {
+	switch v := v.(type) {
+	case string:
+		var out []string
+		start := -1
+		for i, r := range v {
+			switch {
+			case unicode.Is(unicode.Han, r):
+				if start >= 0 {
+					out = append(out, strings.ToLower(v[start:i]))
+					start = -1
+				}
+				out = append(out, string(r))
+			case unicode.IsLetter(r) || unicode.IsDigit(r):
+				if start < 0 {
+					start = i
+				}
+			default:
+				if start >= 0 {
+					out = append(out, strings.ToLower(v[start:i]))
+					start = -1
+				}
+			}
+		}
+		if start >= 0 {
+			out = append(out, strings.ToLower(v[start:]))
+		}
+		return out
+	case []any:
+		var out []string
+		for _, e := range v {
+			out = append(out, words(e)...)
+		}
+		return out
+	case map[string]any:
+		var out []string
+		for _, e := range v {
+			out = append(out, words(e)...)
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Package search keeps a local full-text index of table rows up to date,
+// for applications that want embedded search without Elasticsearch, and
+// serves queries over HTTP.
+//
+// BleveIndex keeps the index on disk with Bleve; MemoryIndex is a small
+// in-memory index for tables rebuilt on every start:
+//
+//	idx, err := search.OpenBleve("users.bleve")
+//	dl.Handle("s_user", search.New(idx))
+//	http.Handle("/search", search.Handler(idx))
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// TableField is the field of every document holding the qualified table
+// of the row.
+const TableField = "_table"
+
+// Index stores documents by id.
+type Index interface {
+	Index(id string, doc any) error
+	Delete(id string) error
+}
+
+// Query is a search. Table restricts the hits to one table, "schema.table"
+// or a table in the default schema.
+type Query struct {
+	Text  string
+	Table string
+	Limit int
+}
+
+// Hit is a matching document with the indexed fields of its row.
+type Hit struct {
+	ID     string         `json:"id"`
+	Score  float64        `json:"score"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+// Searcher answers queries, best hits first.
+type Searcher interface {
+	Search(ctx context.Context, q Query) ([]Hit, error)
+}
+
+// Indexer indexes inserted and updated rows and removes deleted ones.
+// Documents are identified by DocumentID and hold the row's columns, or
+// those chosen with WithFields, plus TableField.
+type Indexer struct {
+	index  Index
+	fields map[string][]string
+}
+
+type Option func(*Indexer)
+
+// WithFields indexes only columns of table ("schema.table" or a table in
+// the default schema).
+func WithFields(table string, columns ...string) Option {
+	return func(ix *Indexer) {
+		ix.fields[qualify(table)] = columns
+	}
+}
+
+func New(index Index, opts ...Option) *Indexer {
+	ix := &Indexer{index: index, fields: make(map[string][]string)}
+	for _, opt := range opts {
+		opt(ix)
+	}
+	return ix
+}
+
+func (ix *Indexer) HandleNotification(_ context.Context, n *listener.ChangeNotification) error {
+	switch n.Operation {
+	case listener.OpInsert, listener.OpUpdate:
+	case listener.OpDelete:
+		id, err := DocumentID(n, image(n, n.Old))
+		if err != nil {
+			return listener.Permanent(err)
+		}
+		return ix.index.Delete(id)
+	default:
+		return nil
+	}
+
+	id, err := DocumentID(n, image(n, n.New))
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	// An update changing the key moves the document.
+	if n.Operation == listener.OpUpdate && n.Old != nil {
+		if old, err := DocumentID(n, n.Old); err == nil && old != id {
+			if err := ix.index.Delete(old); err != nil {
+				return err
+			}
+		}
+	}
+	doc, err := ix.document(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to decode %s row: %w", n.QualifiedTable(), err))
+	}
+	return ix.index.Index(id, doc)
+}
+
+func (ix *Indexer) document(n *listener.ChangeNotification) (map[string]any, error) {
+	var row map[string]any
+	if err := json.Unmarshal(image(n, n.New), &row); err != nil {
+		return nil, err
+	}
+	doc := row
+	if cols, ok := ix.fields[n.QualifiedTable()]; ok {
+		doc = make(map[string]any, len(cols)+1)
+		for _, col := range cols {
+			if v, ok := row[col]; ok {
+				doc[col] = v
+			}
+		}
+	}
+	doc[TableField] = n.QualifiedTable()
+	return doc, nil
+}
+
+// DocumentID identifies a row image of a change, "schema.table:" followed
+// by its primary key: the value of a single key column, the key as JSON
+// otherwise. The key columns are those of the notification's key, their
+// values those of image when it holds them, so the old image of an update
+// changing the key yields the old id.
+func DocumentID(n *listener.ChangeNotification, image json.RawMessage) (string, error) {
+	key := sink.Key(n)
+	if key != nil && image != nil {
+		key = imageKey(key, image)
+	}
+	if key == nil {
+		return "", fmt.Errorf("%s: notification has no primary key", n.QualifiedTable())
+	}
+	var cols map[string]any
+	if err := json.Unmarshal(key, &cols); err != nil || len(cols) != 1 {
+		return n.QualifiedTable() + ":" + string(key), nil
+	}
+	for _, v := range cols {
+		if s, ok := v.(string); ok {
+			return n.QualifiedTable() + ":" + s, nil
+		}
+		b, _ := json.Marshal(v)
+		return n.QualifiedTable() + ":" + string(b), nil
+	}
+	return n.QualifiedTable() + ":" + string(key), nil
+}
+
+// imageKey takes the key columns of key from image.
+func imageKey(key, image json.RawMessage) json.RawMessage {
+	var cols map[string]json.RawMessage
+	var row map[string]json.RawMessage
+	if json.Unmarshal(key, &cols) != nil || json.Unmarshal(image, &row) != nil {
+		return key
+	}
+	for col := range cols {
+		v, ok := row[col]
+		if !ok {
+			return key
+		}
+		cols[col] = v
+	}
+	b, err := json.Marshal(cols)
+	if err != nil {
+		return key
+	}
+	return b
+}
+
+// image returns data, or the row of a payload without images.
+func image(n *listener.ChangeNotification, data json.RawMessage) json.RawMessage {
+	if data == nil {
+		return n.Data
+	}
+	return data
+}
+
+func qualify(table string) string {
+	if !strings.Contains(table, ".") {
+		return listener.DefaultSchema + "." + table
+	}
+	return table
+}
@@ -0,0 +1,90 @@
+// Package sink holds what the built-in sinks share: encoding notifications
+// and deriving destination names, keys and attributes from them.
+//
+// Each sink lives in a subpackage and implements
+// listener.NotificationHandler, so it is registered like any handler:
+//
+//	dl.Handle(listener.CatchAll, kafkaSink)
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// Encoder serializes a notification into a message body.
+type Encoder interface {
+	Encode(n *listener.ChangeNotification) ([]byte, error)
+	ContentType() string
+}
+
+// JSON encodes the notification as it was received from the trigger.
+type JSON struct{}
+
+func (JSON) Encode(n *listener.ChangeNotification) ([]byte, error) {
+	return json.Marshal(n)
+}
+
+func (JSON) ContentType() string { return "application/json" }
+
+// Namer derives a destination (topic, subject, routing key...) from a
+// notification.
+type Namer func(n *listener.ChangeNotification) string
+
+// Template returns a Namer substituting {schema}, {table}, {op} (lower
+// case operation) and {channel} in pattern.
+func Template(pattern string) Namer {
+	return func(n *listener.ChangeNotification) string {
+		schema := n.Schema
+		if schema == "" {
+			schema = listener.DefaultSchema
+		}
+		return strings.NewReplacer(
+			"{schema}", schema,
+			"{table}", n.Table,
+			"{op}", strings.ToLower(n.Operation),
+			"{channel}", n.Channel,
+		).Replace(pattern)
+	}
+}
+
+// Key returns the notification's primary key as compact JSON, or nil when
+// the trigger was installed without key columns.
+func Key(n *listener.ChangeNotification) []byte {
+	if len(n.Key) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, n.Key); err != nil {
+		return n.Key
+	}
+	return buf.Bytes()
+}
+
+// RowKey identifies the changed row across tables: the qualified table
+// name, followed by the primary key when the notification carries one.
+// Sinks use it as ordering or group key.
+func RowKey(n *listener.ChangeNotification) string {
+	key := n.QualifiedTable()
+	if k := Key(n); k != nil {
+		key += ":" + string(k)
+	}
+	return key
+}
+
+// Attributes returns the metadata sinks attach as headers or message
+// attributes.
+func Attributes(n *listener.ChangeNotification) map[string]string {
+	schema := n.Schema
+	if schema == "" {
+		schema = listener.DefaultSchema
+	}
+	return map[string]string{
+		"schema":    schema,
+		"table":     n.Table,
+		"operation": n.Operation,
+	}
+}
@@ -0,0 +1,455 @@
+// Package sqlite keeps a local SQLite copy of tables, so edge deployments
+// can query data locally while PostgreSQL remains the source of truth.
+//
+// The package works on a *sql.DB of any SQLite driver (3.24 or later, for
+// upserts), which the application imports, e.g. modernc.org/sqlite or
+// github.com/mattn/go-sqlite3:
+//
+//	db, err := sql.Open("sqlite", "file:edge.db?_pragma=journal_mode(WAL)")
+//	m := sqlite.New(db)
+//	dl.Handle("s_user", listener.NewBatching(m, listener.BatchConfig{}))
+//
+// Combined with listener.WithSnapshot the copy starts from the current
+// rows.
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// DefaultTable mirrors into a table of the same name; SQLite has no
+// schemas, so tables of different schemas need a pattern with {schema}.
+const DefaultTable = "{table}"
+
+// Mirror upserts inserted and updated rows and deletes removed ones. A
+// missing target table is created from the first row, with untyped
+// columns and the source's primary key, and columns appearing in later
+// rows are added; existing tables are used as they are. Values are stored
+// as integers, reals or text, booleans as 0 or 1, and arrays and objects
+// as JSON text. Writes are serialized, as SQLite allows one writer.
+type Mirror struct {
+	db    *sql.DB
+	table sink.Namer
+	keys  map[string][]string
+
+	mu sync.Mutex
+	// columns caches the columns of target tables.
+	columns map[string]map[string]bool
+}
+
+type Option func(*Mirror)
+
+// WithTable maps source tables to target tables (see sink.Template).
+func WithTable(pattern string) Option {
+	return func(m *Mirror) {
+		m.table = sink.Template(pattern)
+	}
+}
+
+func WithTableFunc(fn sink.Namer) Option {
+	return func(m *Mirror) {
+		m.table = fn
+	}
+}
+
+// WithKeyColumns sets the key columns of a source table ("schema.table" or
+// a table in the default schema) for triggers installed without them.
+func WithKeyColumns(table string, columns ...string) Option {
+	return func(m *Mirror) {
+		if !strings.Contains(table, ".") {
+			table = listener.DefaultSchema + "." + table
+		}
+		m.keys[table] = columns
+	}
+}
+
+func New(db *sql.DB, opts ...Option) *Mirror {
+	m := &Mirror{
+		db:      db,
+		table:   sink.Template(DefaultTable),
+		keys:    make(map[string][]string),
+		columns: make(map[string]map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Mirror) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	err := m.HandleBatch(ctx, []*listener.ChangeNotification{n})
+	if errs, ok := err.(listener.BatchErrors); ok {
+		return errs[0]
+	}
+	return err
+}
+
+// change is a notification turned into statements.
+type change struct {
+	n     *listener.ChangeNotification
+	table string
+	keys  []string
+	// row is the row to upsert, nil for deletes.
+	row map[string]json.RawMessage
+	// old is the key image to delete: the deleted row, or the old row of
+	// an update that changed the key.
+	old map[string]json.RawMessage
+}
+
+// HandleBatch applies batch in one transaction, which is much faster than
+// one per change. A change that fails is rolled back alone and reported in
+// listener.BatchErrors.
+func (m *Mirror) HandleBatch(ctx context.Context, batch []*listener.ChangeNotification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make(listener.BatchErrors, len(batch))
+	failed := false
+	changes := make([]*change, len(batch))
+	pending := 0
+	for i, n := range batch {
+		c, err := m.prepare(ctx, n)
+		if err != nil {
+			errs[i], failed = err, true
+			continue
+		}
+		if c != nil {
+			changes[i] = c
+			pending++
+		}
+	}
+	if pending == 0 {
+		if failed {
+			return errs
+		}
+		return nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for i, c := range changes {
+		if c == nil {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT change"); err != nil {
+			return fmt.Errorf("failed to create savepoint: %w", err)
+		}
+		if err := apply(ctx, tx, c); err != nil {
+			if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO change"); rbErr != nil {
+				return fmt.Errorf("failed to roll back to savepoint: %w", rbErr)
+			}
+			errs[i], failed = fmt.Errorf("failed to apply %s %s: %w", c.n.Operation, c.n.QualifiedTable(), err), true
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE change"); err != nil {
+			return fmt.Errorf("failed to release savepoint: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+// prepare decodes a change and creates or extends its target table.
+func (m *Mirror) prepare(ctx context.Context, n *listener.ChangeNotification) (*change, error) {
+	keys, err := m.keyColumns(n)
+	if err != nil {
+		return nil, listener.Permanent(err)
+	}
+	c := &change{n: n, table: m.table(n), keys: keys}
+	switch n.Operation {
+	case listener.OpInsert, listener.OpUpdate:
+		if c.row, err = decodeRow(image(n, n.New)); err != nil {
+			return nil, listener.Permanent(fmt.Errorf("failed to decode %s row: %w", n.QualifiedTable(), err))
+		}
+		for _, col := range keys {
+			if _, ok := c.row[col]; !ok {
+				return nil, listener.Permanent(fmt.Errorf("%s row lacks key column %s", n.QualifiedTable(), col))
+			}
+		}
+		// A changed primary key moves the row; remove the old one first.
+		if n.Operation == listener.OpUpdate && n.Old != nil {
+			if old, err := decodeRow(n.Old); err == nil && !sameKey(old, c.row, keys) {
+				c.old = old
+			}
+		}
+		if err := m.ensureTable(ctx, c.table, keys, c.row); err != nil {
+			return nil, err
+		}
+	case listener.OpDelete:
+		key := n.Key
+		if key == nil {
+			key = image(n, n.Old)
+		}
+		if c.old, err = decodeRow(key); err != nil {
+			return nil, listener.Permanent(fmt.Errorf("failed to decode %s key: %w", n.QualifiedTable(), err))
+		}
+		cols, err := m.tableColumns(ctx, c.table)
+		if err != nil {
+			return nil, err
+		}
+		if len(cols) == 0 {
+			// The table was never created: nothing to delete.
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	return c, nil
+}
+
+func apply(ctx context.Context, tx *sql.Tx, c *change) error {
+	if c.old != nil {
+		query, args, err := deleteStatement(c.table, c.keys, c.old)
+		if err != nil {
+			return listener.Permanent(err)
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	if c.row != nil {
+		query, args, err := upsertStatement(c.table, c.keys, c.row)
+		if err != nil {
+			return listener.Permanent(err)
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureTable creates the target table or adds the columns of row it
+// lacks; m.mu is held.
+func (m *Mirror) ensureTable(ctx context.Context, table string, keys []string, row map[string]json.RawMessage) error {
+	cols, err := m.tableColumns(ctx, table)
+	if err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		defs := make([]string, 0, len(row))
+		for _, col := range sortedColumns(row) {
+			defs = append(defs, quoteIdent(col))
+		}
+		quoted := make([]string, len(keys))
+		for i, col := range keys {
+			quoted[i] = quoteIdent(col)
+		}
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s, PRIMARY KEY (%s))",
+			quoteIdent(table), strings.Join(defs, ", "), strings.Join(quoted, ", "))
+		if _, err := m.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to create table %s: %w", table, err)
+		}
+		delete(m.columns, table)
+		return nil
+	}
+	for _, col := range sortedColumns(row) {
+		if cols[col] {
+			continue
+		}
+		if _, err := m.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quoteIdent(table), quoteIdent(col))); err != nil {
+			return fmt.Errorf("failed to add column %s to %s: %w", col, table, err)
+		}
+		cols[col] = true
+	}
+	return nil
+}
+
+// tableColumns returns the columns of table, none when it does not exist;
+// m.mu is held.
+func (m *Mirror) tableColumns(ctx context.Context, table string) (map[string]bool, error) {
+	if cols, ok := m.columns[table]; ok {
+		return cols, nil
+	}
+	rows, err := m.db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+	cols := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		cols[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	// Only existing tables are cached, so a table created meanwhile is
+	// found.
+	if len(cols) > 0 {
+		m.columns[table] = cols
+	}
+	return cols, nil
+}
+
+// keyColumns prefers the key carried by the notification over configured
+// columns.
+func (m *Mirror) keyColumns(n *listener.ChangeNotification) ([]string, error) {
+	if n.Key != nil {
+		key, err := decodeRow(n.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key of %s: %w", n.QualifiedTable(), err)
+		}
+		if len(key) > 0 {
+			return sortedColumns(key), nil
+		}
+	}
+	if cols, ok := m.keys[n.QualifiedTable()]; ok && len(cols) > 0 {
+		return cols, nil
+	}
+	return nil, fmt.Errorf("no key columns for %s", n.QualifiedTable())
+}
+
+func upsertStatement(table string, keys []string, row map[string]json.RawMessage) (string, []any, error) {
+	cols := sortedColumns(row)
+	quoted := make([]string, len(cols))
+	args := make([]any, len(cols))
+	for i, col := range cols {
+		quoted[i] = quoteIdent(col)
+		v, err := value(row[col])
+		if err != nil {
+			return "", nil, fmt.Errorf("column %s: %w", col, err)
+		}
+		args[i] = v
+	}
+
+	isKey := make(map[string]bool, len(keys))
+	conflict := make([]string, len(keys))
+	for i, col := range keys {
+		isKey[col] = true
+		conflict[i] = quoteIdent(col)
+	}
+	var sets []string
+	for i, col := range cols {
+		if !isKey[col] {
+			sets = append(sets, fmt.Sprintf("%[1]s = excluded.%[1]s", quoted[i]))
+		}
+	}
+	action := "DO NOTHING"
+	if len(sets) > 0 {
+		action = "DO UPDATE SET " + strings.Join(sets, ", ")
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		quoteIdent(table), strings.Join(quoted, ", "), placeholders, strings.Join(conflict, ", "), action), args, nil
+}
+
+func deleteStatement(table string, keys []string, key map[string]json.RawMessage) (string, []any, error) {
+	conds := make([]string, len(keys))
+	args := make([]any, len(keys))
+	for i, col := range keys {
+		raw, ok := key[col]
+		if !ok {
+			return "", nil, fmt.Errorf("key lacks column %s", col)
+		}
+		v, err := value(raw)
+		if err != nil {
+			return "", nil, fmt.Errorf("column %s: %w", col, err)
+		}
+		conds[i] = quoteIdent(col) + " = ?"
+		args[i] = v
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdent(table), strings.Join(conds, " AND ")), args, nil
+}
+
+// value converts a JSON value to the SQLite value stored for it.
+func value(raw json.RawMessage) (any, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	switch raw[0] {
+	case 'n':
+		return nil, nil
+	case 't':
+		return int64(1), nil
+	case 'f':
+		return int64(0), nil
+	case '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case '{', '[':
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return nil, err
+		}
+		return buf.String(), nil
+	}
+	var num json.Number
+	if err := json.Unmarshal(raw, &num); err != nil {
+		return nil, err
+	}
+	if i, err := num.Int64(); err == nil {
+		return i, nil
+	}
+	if strings.ContainsAny(num.String(), ".eE") {
+		if f, err := num.Float64(); err == nil {
+			return f, nil
+		}
+	}
+	// Integers beyond 64 bits keep their digits as text.
+	return num.String(), nil
+}
+
+// image returns data, or the row of a payload without images.
+func image(n *listener.ChangeNotification, data json.RawMessage) json.RawMessage {
+	if data == nil {
+		return n.Data
+	}
+	return data
+}
+
+func sameKey(a, b map[string]json.RawMessage, cols []string) bool {
+	for _, col := range cols {
+		if !bytes.Equal(a[col], b[col]) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeRow(data []byte) (map[string]json.RawMessage, error) {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func sortedColumns(row map[string]json.RawMessage) []string {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// quoteIdent quotes a name as one identifier, so a "schema.table" target
+// names a table with a dot rather than an attached database.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
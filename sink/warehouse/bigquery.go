@@ -0,0 +1,201 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+const bigQueryEndpoint = "https://bigquery.googleapis.com/bigquery/v2"
+
+// BigQuery runs statements through the jobs.query REST API. Unqualified
+// tables are in the default dataset; "dataset.table" and
+// "project.dataset.table" name others.
+type BigQuery struct {
+	project  string
+	dataset  string
+	client   *http.Client
+	endpoint string
+}
+
+// NewBigQuery writes to dataset of project with the application default
+// credentials.
+func NewBigQuery(ctx context.Context, project, dataset string) (*BigQuery, error) {
+	ts, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/bigquery")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default credentials: %w", err)
+	}
+	return NewBigQueryWithTokenSource(project, dataset, ts), nil
+}
+
+// NewBigQueryWithTokenSource authenticates with tokens from ts.
+func NewBigQueryWithTokenSource(project, dataset string, ts oauth2.TokenSource) *BigQuery {
+	return &BigQuery{
+		project:  project,
+		dataset:  dataset,
+		client:   oauth2.NewClient(context.Background(), ts),
+		endpoint: bigQueryEndpoint,
+	}
+}
+
+type bigQueryResponse struct {
+	JobComplete  bool `json:"jobComplete"`
+	JobReference struct {
+		JobID    string `json:"jobId"`
+		Location string `json:"location"`
+	} `json:"jobReference"`
+	Rows []struct {
+		F []struct {
+			V any `json:"v"`
+		} `json:"f"`
+	} `json:"rows"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (b *BigQuery) Exec(ctx context.Context, query string) error {
+	_, err := b.query(ctx, query)
+	return err
+}
+
+// query runs query and waits for its rows.
+func (b *BigQuery) query(ctx context.Context, query string) ([][]string, error) {
+	body, err := json.Marshal(map[string]any{
+		"query":          query,
+		"useLegacySql":   false,
+		"timeoutMs":      60000,
+		"defaultDataset": map[string]string{"projectId": b.project, "datasetId": b.dataset},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var resp bigQueryResponse
+	if err := b.do(ctx, http.MethodPost, b.endpoint+"/projects/"+url.PathEscape(b.project)+"/queries", body, &resp); err != nil {
+		return nil, err
+	}
+	for !resp.JobComplete {
+		job := resp.JobReference
+		q := url.Values{"timeoutMs": {"60000"}, "location": {job.Location}}
+		path := b.endpoint + "/projects/" + url.PathEscape(b.project) + "/queries/" + url.PathEscape(job.JobID) + "?" + q.Encode()
+		resp = bigQueryResponse{}
+		if err := b.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+			return nil, err
+		}
+		resp.JobReference = job
+	}
+	if len(resp.Errors) > 0 {
+		return nil, fmt.Errorf("bigquery: %s", resp.Errors[0].Message)
+	}
+	rows := make([][]string, len(resp.Rows))
+	for i, r := range resp.Rows {
+		rows[i] = make([]string, len(r.F))
+		for j, f := range r.F {
+			if s, ok := f.V.(string); ok {
+				rows[i][j] = s
+			}
+		}
+	}
+	return rows, nil
+}
+
+func (b *BigQuery) do(ctx context.Context, method, path string, body []byte, out any) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("bigquery request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read bigquery response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+			msg = e.Error.Message
+		}
+		err := fmt.Errorf("bigquery returned %s: %s", resp.Status, msg)
+		// Invalid queries fail again; quota and server errors may not.
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotFound {
+			return listener.Permanent(err)
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse bigquery response: %w", err)
+	}
+	return nil
+}
+
+func (b *BigQuery) Columns(ctx context.Context, table string) (map[string]string, error) {
+	project, dataset, name := b.project, b.dataset, table
+	switch parts := strings.Split(table, "."); len(parts) {
+	case 2:
+		dataset, name = parts[0], parts[1]
+	case 3:
+		project, dataset, name = parts[0], parts[1], parts[2]
+	}
+	rows, err := b.query(ctx, fmt.Sprintf("SELECT column_name, data_type FROM %s.%s.INFORMATION_SCHEMA.COLUMNS WHERE table_name = %s",
+		b.Quote(project), b.Quote(dataset), quoteString(name)))
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]string, len(rows))
+	for _, r := range rows {
+		if len(r) == 2 {
+			cols[r[0]] = r[1]
+		}
+	}
+	return cols, nil
+}
+
+// Quote quotes each part of name in backticks.
+func (b *BigQuery) Quote(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = "`" + strings.ReplaceAll(p, "`", "\\`") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+func (b *BigQuery) Type(t Type) string {
+	switch t {
+	case Int:
+		return "INT64"
+	case Float:
+		return "FLOAT64"
+	case Bool:
+		return "BOOL"
+	case JSON:
+		return "JSON"
+	case Timestamp:
+		return "TIMESTAMP"
+	}
+	return "STRING"
+}
@@ -0,0 +1,89 @@
+package warehouse
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Snowflake runs statements on a *sql.DB of the Snowflake driver, which
+// the application imports:
+//
+//	import _ "github.com/snowflakedb/gosnowflake"
+//
+//	db, err := sql.Open("snowflake", "user:password@account/db/schema?warehouse=wh")
+//	l := warehouse.New(warehouse.NewSnowflake(db), warehouse.WithMode(warehouse.State))
+//
+// Unqualified tables are in the schema of the connection. Names are
+// quoted, so they keep their case.
+type Snowflake struct {
+	db *sql.DB
+}
+
+func NewSnowflake(db *sql.DB) *Snowflake {
+	return &Snowflake{db: db}
+}
+
+func (s *Snowflake) Exec(ctx context.Context, query string) error {
+	if _, err := s.db.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Snowflake) Columns(ctx context.Context, table string) (map[string]string, error) {
+	columns, schema, name := "information_schema.columns", "CURRENT_SCHEMA()", table
+	switch parts := strings.Split(table, "."); len(parts) {
+	case 2:
+		schema, name = quoteString(parts[0]), parts[1]
+	case 3:
+		columns = s.Quote(parts[0]) + ".information_schema.columns"
+		schema, name = quoteString(parts[1]), parts[2]
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT column_name, data_type, numeric_precision, numeric_scale FROM %s WHERE table_schema = %s AND table_name = %s",
+		columns, schema, quoteString(name)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := make(map[string]string)
+	for rows.Next() {
+		var col, typ string
+		var precision, scale sql.NullInt64
+		if err := rows.Scan(&col, &typ, &precision, &scale); err != nil {
+			return nil, err
+		}
+		if typ == "NUMBER" && precision.Valid {
+			typ = fmt.Sprintf("NUMBER(%d,%d)", precision.Int64, scale.Int64)
+		}
+		cols[col] = typ
+	}
+	return cols, rows.Err()
+}
+
+// Quote quotes each part of name in double quotes.
+func (s *Snowflake) Quote(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func (s *Snowflake) Type(t Type) string {
+	switch t {
+	case Int:
+		return "NUMBER(38,0)"
+	case Float:
+		return "FLOAT"
+	case Bool:
+		return "BOOLEAN"
+	case JSON:
+		return "VARIANT"
+	case Timestamp:
+		return "TIMESTAMP_TZ"
+	}
+	return "VARCHAR"
+}
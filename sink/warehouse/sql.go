@@ -0,0 +1,406 @@
+package warehouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+// timestampFormat is accepted by casts to timestamps in both warehouses.
+const timestampFormat = "2006-01-02 15:04:05.000000-07:00"
+
+type column struct {
+	name string
+	typ  Type
+}
+
+// append inserts the change rows of items into table; l.mu is held.
+func (l *Loader) append(ctx context.Context, table string, items []*sink.BatchItem) error {
+	cols := make([]column, len(changeColumns))
+	names := make([]string, len(changeColumns))
+	for i, c := range changeColumns {
+		cols[i] = column{c.name, c.typ}
+		names[i] = c.name
+	}
+	types, err := l.ensure(ctx, table, cols, nil)
+	if err != nil {
+		return err
+	}
+
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = l.wh.Quote(name)
+	}
+	head := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", l.wh.Quote(table), strings.Join(quoted, ", "))
+	var rows []string
+	var chunk []*sink.BatchItem
+	size := 0
+	flush := func() {
+		if len(rows) == 0 {
+			return
+		}
+		if err := l.wh.Exec(ctx, head+strings.Join(rows, ",\n")); err != nil {
+			err = fmt.Errorf("failed to insert into %s: %w", table, err)
+			for _, item := range chunk {
+				item.Err = err
+			}
+		}
+		rows, chunk, size = nil, nil, 0
+	}
+	for _, item := range items {
+		rec, err := decodeRecord(item)
+		if err != nil {
+			item.Err = err
+			continue
+		}
+		vals := make([]string, len(names))
+		for i, name := range names {
+			if vals[i], err = l.literal(rec.Rows[0].Values[name], types[name]); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			item.Err = listener.Permanent(err)
+			continue
+		}
+		row := "(" + strings.Join(vals, ", ") + ")"
+		if size+len(row) > maxStatementBytes {
+			flush()
+		}
+		rows, chunk, size = append(rows, row), append(chunk, item), size+len(row)
+	}
+	flush()
+	return nil
+}
+
+// mergeRow is the last row of a key in a batch.
+type mergeRow struct {
+	stateRow
+	// cols is the sorted column set of the row.
+	cols string
+}
+
+// merge applies the state rows of items to table, the last row of each
+// key winning; l.mu is held.
+func (l *Loader) merge(ctx context.Context, table string, items []*sink.BatchItem) error {
+	var keys []string
+	rows := make(map[string]*mergeRow)
+	var order []string
+	var cols []string
+	inferred := make(map[string]Type)
+	typed := make(map[string]bool)
+	for _, item := range items {
+		rec, err := decodeRecord(item)
+		if err != nil {
+			item.Err = err
+			continue
+		}
+		if keys == nil {
+			keys = rec.Keys
+		} else if !slices.Equal(keys, rec.Keys) {
+			item.Err = listener.Permanent(fmt.Errorf("key columns %v of %s differ from %v", rec.Keys, item.Notification.QualifiedTable(), keys))
+			continue
+		}
+		for _, r := range rec.Rows {
+			k, _ := keyOf(r.Values, keys)
+			id, _ := json.Marshal(k)
+			if _, ok := rows[string(id)]; !ok {
+				order = append(order, string(id))
+			}
+			names := sortedColumns(r.Values)
+			rows[string(id)] = &mergeRow{stateRow: r, cols: strings.Join(names, "\x00")}
+			for _, col := range names {
+				if _, ok := inferred[col]; !ok {
+					cols = append(cols, col)
+					inferred[col] = String
+				}
+				if v := r.Values[col]; !typed[col] && !isNull(v) {
+					inferred[col], typed[col] = inferType(v), true
+				}
+			}
+		}
+	}
+	if len(order) == 0 {
+		return nil
+	}
+	want := make([]column, len(cols))
+	for i, col := range cols {
+		want[i] = column{col, inferred[col]}
+	}
+	types, err := l.ensure(ctx, table, want, keys)
+	if err != nil {
+		return err
+	}
+
+	// Rows of a statement share their columns, so that a partial row does
+	// not null the columns it lacks; deleted rows join any statement.
+	groups := make(map[string][]*mergeRow)
+	var groupOrder []string
+	var deleted []*mergeRow
+	for _, id := range order {
+		r := rows[id]
+		if r.Deleted {
+			deleted = append(deleted, r)
+			continue
+		}
+		if _, ok := groups[r.cols]; !ok {
+			groupOrder = append(groupOrder, r.cols)
+		}
+		groups[r.cols] = append(groups[r.cols], r)
+	}
+	if len(groupOrder) == 0 {
+		groupOrder = []string{strings.Join(keys, "\x00")}
+	}
+	groups[groupOrder[0]] = append(groups[groupOrder[0]], deleted...)
+	for _, g := range groupOrder {
+		if err := l.mergeRows(ctx, table, keys, strings.Split(g, "\x00"), types, groups[g]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeRows merges rows sharing the columns cols in statements of bounded
+// size.
+func (l *Loader) mergeRows(ctx context.Context, table string, keys, cols []string, types map[string]string, rows []*mergeRow) error {
+	var selects []string
+	size := 0
+	flush := func() error {
+		if len(selects) == 0 {
+			return nil
+		}
+		query := l.mergeStatement(table, keys, cols, selects)
+		selects, size = nil, 0
+		if err := l.wh.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to merge into %s: %w", table, err)
+		}
+		return nil
+	}
+	for _, r := range rows {
+		vals := make([]string, 0, len(cols)+1)
+		for _, col := range cols {
+			v, err := l.literal(r.Values[col], types[col])
+			if err != nil {
+				return listener.Permanent(fmt.Errorf("column %s: %w", col, err))
+			}
+			vals = append(vals, v+" AS "+l.wh.Quote(col))
+		}
+		vals = append(vals, strings.ToUpper(strconv.FormatBool(r.Deleted))+" AS "+l.wh.Quote(deletedColumn))
+		sel := "SELECT " + strings.Join(vals, ", ")
+		if size+len(sel) > maxStatementBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		selects, size = append(selects, sel), size+len(sel)
+	}
+	return flush()
+}
+
+func (l *Loader) mergeStatement(table string, keys, cols []string, selects []string) string {
+	q := l.wh.Quote
+	isKey := make(map[string]bool, len(keys))
+	on := make([]string, len(keys))
+	for i, col := range keys {
+		isKey[col] = true
+		on[i] = fmt.Sprintf("target.%[1]s = source.%[1]s", q(col))
+	}
+	var sets, names, values []string
+	for _, col := range cols {
+		names = append(names, q(col))
+		values = append(values, "source."+q(col))
+		if !isKey[col] {
+			sets = append(sets, fmt.Sprintf("%[1]s = source.%[1]s", q(col)))
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "MERGE INTO %s AS target\nUSING (\n%s\n) AS source\nON %s\n",
+		q(table), strings.Join(selects, "\nUNION ALL\n"), strings.Join(on, " AND "))
+	fmt.Fprintf(&b, "WHEN MATCHED AND source.%s THEN DELETE\n", q(deletedColumn))
+	if len(sets) > 0 {
+		fmt.Fprintf(&b, "WHEN MATCHED THEN UPDATE SET %s\n", strings.Join(sets, ", "))
+	}
+	fmt.Fprintf(&b, "WHEN NOT MATCHED AND NOT source.%s THEN INSERT (%s) VALUES (%s)",
+		q(deletedColumn), strings.Join(names, ", "), strings.Join(values, ", "))
+	return b.String()
+}
+
+// ensure creates table with cols, or adds the columns it lacks, and
+// returns the native types of its columns; l.mu is held. keys become the
+// primary key of a created table, which neither warehouse enforces.
+func (l *Loader) ensure(ctx context.Context, table string, cols []column, keys []string) (map[string]string, error) {
+	types, ok := l.columns[table]
+	if !ok {
+		var err error
+		if types, err = l.wh.Columns(ctx, table); err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+	}
+	if len(types) == 0 {
+		types = make(map[string]string, len(cols))
+		defs := make([]string, len(cols))
+		for i, c := range cols {
+			types[c.name] = l.wh.Type(c.typ)
+			defs[i] = l.wh.Quote(c.name) + " " + types[c.name]
+		}
+		if len(keys) > 0 {
+			quoted := make([]string, len(keys))
+			for i, col := range keys {
+				quoted[i] = l.wh.Quote(col)
+			}
+			defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s) NOT ENFORCED", strings.Join(quoted, ", ")))
+		}
+		query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n    %s\n)", l.wh.Quote(table), strings.Join(defs, ",\n    "))
+		if err := l.wh.Exec(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to create table %s: %w", table, err)
+		}
+		l.columns[table] = types
+		return types, nil
+	}
+	for _, c := range cols {
+		if _, ok := types[c.name]; ok {
+			continue
+		}
+		typ := l.wh.Type(c.typ)
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", l.wh.Quote(table), l.wh.Quote(c.name), typ)
+		if err := l.wh.Exec(ctx, query); err != nil {
+			return nil, fmt.Errorf("failed to add column %s to %s: %w", c.name, table, err)
+		}
+		types[c.name] = typ
+	}
+	l.columns[table] = types
+	return types, nil
+}
+
+// literal renders a JSON value as a literal of the native type typ.
+func (l *Loader) literal(raw json.RawMessage, typ string) (string, error) {
+	if isNull(raw) {
+		return "CAST(NULL AS " + typ + ")", nil
+	}
+	raw = bytes.TrimSpace(raw)
+	if isJSONType(typ) {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return "", err
+		}
+		return "PARSE_JSON(" + quoteString(buf.String()) + ")", nil
+	}
+	var lit string
+	switch raw[0] {
+	case 't', 'f':
+		lit = strings.ToUpper(string(raw))
+	case '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", err
+		}
+		lit = quoteString(s)
+	case '{', '[':
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, raw); err != nil {
+			return "", err
+		}
+		lit = quoteString(buf.String())
+	default:
+		var num json.Number
+		if err := json.Unmarshal(raw, &num); err != nil {
+			return "", err
+		}
+		lit = num.String()
+	}
+	return "CAST(" + lit + " AS " + typ + ")", nil
+}
+
+// quoteString renders s as a string literal; both warehouses read
+// backslash escapes.
+func quoteString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return "'" + r.Replace(s) + "'"
+}
+
+func isJSONType(typ string) bool {
+	switch strings.ToUpper(typ) {
+	case "JSON", "VARIANT", "OBJECT", "ARRAY":
+		return true
+	}
+	return false
+}
+
+func inferType(raw json.RawMessage) Type {
+	raw = bytes.TrimSpace(raw)
+	switch raw[0] {
+	case '"':
+		return String
+	case 't', 'f':
+		return Bool
+	case '{', '[':
+		return JSON
+	}
+	if bytes.ContainsAny(raw, ".eE") {
+		return Float
+	}
+	if _, err := strconv.ParseInt(string(raw), 10, 64); err != nil {
+		return Float
+	}
+	return Int
+}
+
+func isNull(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) == 0 || bytes.Equal(raw, []byte("null"))
+}
+
+// keyOf returns the key columns of row.
+func keyOf(row map[string]json.RawMessage, keys []string) (map[string]json.RawMessage, error) {
+	k := make(map[string]json.RawMessage, len(keys))
+	for _, col := range keys {
+		v, ok := row[col]
+		if !ok || isNull(v) {
+			return nil, fmt.Errorf("row lacks key column %s", col)
+		}
+		k[col] = v
+	}
+	return k, nil
+}
+
+// image returns data, or the row of a payload without images.
+func image(n *listener.ChangeNotification, data json.RawMessage) json.RawMessage {
+	if data == nil {
+		return n.Data
+	}
+	return data
+}
+
+func sameKey(a, b map[string]json.RawMessage, cols []string) bool {
+	for _, col := range cols {
+		if !bytes.Equal(a[col], b[col]) {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeRow(data []byte) (map[string]json.RawMessage, error) {
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func sortedColumns(row map[string]json.RawMessage) []string {
+	cols := make([]string, 0, len(row))
+	for col := range row {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	return cols
+}
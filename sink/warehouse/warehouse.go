@@ -0,0 +1,372 @@
+// Package warehouse loads change events, or the current state of rows,
+// into BigQuery or Snowflake in scheduled batches, for analytics
+// pipelines. Target tables are created and extended as rows arrive.
+package warehouse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	DefaultChangeTable   = "data_changes"
+	DefaultStateTable    = "{schema}_{table}"
+	DefaultBatchSize     = 5000
+	DefaultFlushInterval = time.Minute
+
+	// maxStatementBytes keeps statements below the query size limits of
+	// the warehouses; larger batches are split.
+	maxStatementBytes = 512 << 10
+	// deletedColumn flags the deleted rows of a merge source.
+	deletedColumn = "_pgdl_deleted"
+)
+
+// Mode selects what a Loader writes.
+type Mode int
+
+const (
+	// Changes appends one row per change to a change table with the
+	// columns id, schema_name, table_name, operation, row_key, data,
+	// old_data, actor, tenant and changed_at.
+	Changes Mode = iota
+	// State merges rows into one table per source table by primary key,
+	// so the table holds the current rows, and deletes removed ones.
+	State
+)
+
+// Type is a portable column type.
+type Type int
+
+const (
+	String Type = iota
+	Int
+	Float
+	Bool
+	JSON
+	Timestamp
+)
+
+// Warehouse runs the statements of a Loader. BigQuery and Snowflake
+// implement it.
+type Warehouse interface {
+	Exec(ctx context.Context, query string) error
+	// Columns returns the native types of the columns of table by name,
+	// none when the table does not exist.
+	Columns(ctx context.Context, table string) (map[string]string, error)
+	// Quote quotes a table name, which may be qualified, or a column name.
+	Quote(name string) string
+	// Type returns the native type of t.
+	Type(t Type) string
+}
+
+// Loader buffers changes and writes them every flush interval, or once the
+// batch size is reached. Handler calls return as soon as the change is
+// buffered; failed writes are reported to the WithErrorHandler callback.
+// Wrap the Loader with listener.NewBatching instead to have failed changes
+// retried and checkpoints wait for the writes.
+//
+// Column types of created tables follow the first non-null value: JSON
+// strings become string columns, numbers integer or float columns,
+// booleans boolean ones and arrays and objects JSON columns. Timestamps
+// arrive as strings, so create the table beforehand to type them; values
+// are cast to the types of existing columns.
+type Loader struct {
+	wh      Warehouse
+	mode    Mode
+	table   sink.Namer
+	keys    map[string][]string
+	cfg     sink.BatchConfig
+	batcher *sink.Batcher
+
+	mu sync.Mutex
+	// columns caches the column types of target tables.
+	columns map[string]map[string]string
+}
+
+type Option func(*Loader)
+
+func WithMode(m Mode) Option {
+	return func(l *Loader) {
+		l.mode = m
+	}
+}
+
+// WithTable sets the target table template (see sink.Template), which may
+// be qualified with a dataset or schema. The default is DefaultChangeTable
+// for Changes and DefaultStateTable for State.
+func WithTable(pattern string) Option {
+	return func(l *Loader) {
+		l.table = sink.Template(pattern)
+	}
+}
+
+func WithTableFunc(fn sink.Namer) Option {
+	return func(l *Loader) {
+		l.table = fn
+	}
+}
+
+// WithKeyColumns sets the key columns of a source table ("schema.table" or
+// a table in the default schema) for triggers installed without them.
+func WithKeyColumns(table string, columns ...string) Option {
+	return func(l *Loader) {
+		if !strings.Contains(table, ".") {
+			table = listener.DefaultSchema + "." + table
+		}
+		l.keys[table] = columns
+	}
+}
+
+func WithBatchSize(n int) Option {
+	return func(l *Loader) {
+		l.cfg.Size = n
+	}
+}
+
+func WithFlushInterval(d time.Duration) Option {
+	return func(l *Loader) {
+		l.cfg.Linger = d
+	}
+}
+
+// WithErrorHandler receives the notifications whose write failed.
+func WithErrorHandler(fn func(n *listener.ChangeNotification, err error)) Option {
+	return func(l *Loader) {
+		l.cfg.OnError = func(item *sink.BatchItem) {
+			fn(item.Notification, item.Err)
+		}
+	}
+}
+
+func New(wh Warehouse, opts ...Option) *Loader {
+	l := &Loader{
+		wh:   wh,
+		keys: make(map[string][]string),
+		cfg: sink.BatchConfig{
+			Size:   DefaultBatchSize,
+			Linger: DefaultFlushInterval,
+			Async:  true,
+		},
+		columns: make(map[string]map[string]string),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.table == nil {
+		if l.mode == State {
+			l.table = sink.Template(DefaultStateTable)
+		} else {
+			l.table = sink.Template(DefaultChangeTable)
+		}
+	}
+	l.batcher = sink.NewBatcher(l.cfg, l.write)
+	return l
+}
+
+func (l *Loader) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := l.encode(n)
+	if err != nil {
+		return listener.Permanent(err)
+	}
+	if body == nil {
+		return nil
+	}
+	return l.batcher.Add(ctx, n, body)
+}
+
+// HandleBatch writes batch at once, for listener.NewBatching.
+func (l *Loader) HandleBatch(ctx context.Context, batch []*listener.ChangeNotification) error {
+	return sink.FlushBatch(ctx, batch, l.encode, l.write)
+}
+
+// Close writes the buffered changes.
+func (l *Loader) Close() error {
+	l.batcher.Close()
+	return nil
+}
+
+// record is a change as the rows it writes: a change row, or the rows a
+// merge deletes and upserts, in order, with the key columns.
+type record struct {
+	Keys []string   `json:"keys,omitempty"`
+	Rows []stateRow `json:"rows"`
+}
+
+// stateRow is a row of a merge, whose key columns identify the target
+// row. Deleted rows only hold the key columns.
+type stateRow struct {
+	Values  map[string]json.RawMessage `json:"values"`
+	Deleted bool                       `json:"deleted,omitempty"`
+}
+
+// encode turns n into the rows it writes, nil when there are none, e.g.
+// for a TRUNCATE in State mode.
+func (l *Loader) encode(n *listener.ChangeNotification) ([]byte, error) {
+	var rec record
+	if l.mode == State {
+		keys, err := l.keyColumns(n)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := stateRows(n, keys)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		rec.Keys, rec.Rows = keys, rows
+	} else {
+		rec.Rows = []stateRow{{Values: changeRow(n)}}
+	}
+	return json.Marshal(rec)
+}
+
+func stateRows(n *listener.ChangeNotification, keys []string) ([]stateRow, error) {
+	switch n.Operation {
+	case listener.OpInsert, listener.OpUpdate:
+		row, err := decodeRow(image(n, n.New))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s row: %w", n.QualifiedTable(), err)
+		}
+		if _, err := keyOf(row, keys); err != nil {
+			return nil, fmt.Errorf("%s: %w", n.QualifiedTable(), err)
+		}
+		var rows []stateRow
+		// A changed primary key moves the row; remove the old one first.
+		if n.Operation == listener.OpUpdate && n.Old != nil {
+			if old, err := decodeRow(n.Old); err == nil {
+				if k, err := keyOf(old, keys); err == nil && !sameKey(old, row, keys) {
+					rows = append(rows, stateRow{Values: k, Deleted: true})
+				}
+			}
+		}
+		return append(rows, stateRow{Values: row}), nil
+	case listener.OpDelete:
+		key := n.Key
+		if key == nil {
+			key = image(n, n.Old)
+		}
+		row, err := decodeRow(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s key: %w", n.QualifiedTable(), err)
+		}
+		k, err := keyOf(row, keys)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n.QualifiedTable(), err)
+		}
+		return []stateRow{{Values: k, Deleted: true}}, nil
+	}
+	return nil, nil
+}
+
+// keyColumns prefers the key carried by the notification over configured
+// columns.
+func (l *Loader) keyColumns(n *listener.ChangeNotification) ([]string, error) {
+	if n.Key != nil {
+		key, err := decodeRow(n.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key of %s: %w", n.QualifiedTable(), err)
+		}
+		if len(key) > 0 {
+			return sortedColumns(key), nil
+		}
+	}
+	if cols, ok := l.keys[n.QualifiedTable()]; ok && len(cols) > 0 {
+		return cols, nil
+	}
+	return nil, fmt.Errorf("no key columns for %s", n.QualifiedTable())
+}
+
+// changeColumns are the columns of a change table, in order.
+var changeColumns = []struct {
+	name string
+	typ  Type
+}{
+	{"id", Int},
+	{"schema_name", String},
+	{"table_name", String},
+	{"operation", String},
+	{"row_key", JSON},
+	{"data", JSON},
+	{"old_data", JSON},
+	{"actor", String},
+	{"tenant", String},
+	{"changed_at", Timestamp},
+}
+
+func changeRow(n *listener.ChangeNotification) map[string]json.RawMessage {
+	schema := n.Schema
+	if schema == "" {
+		schema = listener.DefaultSchema
+	}
+	str := func(s string) json.RawMessage {
+		if s == "" {
+			return nil
+		}
+		b, _ := json.Marshal(s)
+		return b
+	}
+	return map[string]json.RawMessage{
+		"id":          json.RawMessage(fmt.Sprint(n.ID)),
+		"schema_name": str(schema),
+		"table_name":  str(n.Table),
+		"operation":   str(n.Operation),
+		"row_key":     json.RawMessage(sink.Key(n)),
+		"data":        n.Data,
+		"old_data":    n.Old,
+		"actor":       str(n.Actor),
+		"tenant":      str(n.Tenant),
+		"changed_at":  str(n.Timestamp.UTC().Format(timestampFormat)),
+	}
+}
+
+// write writes the items of each target table, with one statement per
+// table unless the batch exceeds the statement size.
+func (l *Loader) write(ctx context.Context, items []*sink.BatchItem) {
+	tables := make(map[string][]*sink.BatchItem)
+	var order []string
+	for _, item := range items {
+		table := l.table(item.Notification)
+		if _, ok := tables[table]; !ok {
+			order = append(order, table)
+		}
+		tables[table] = append(tables[table], item)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, table := range order {
+		var err error
+		if l.mode == State {
+			err = l.merge(ctx, table, tables[table])
+		} else {
+			err = l.append(ctx, table, tables[table])
+		}
+		if err != nil {
+			for _, item := range tables[table] {
+				if item.Err == nil {
+					item.Err = err
+				}
+			}
+		}
+	}
+}
+
+func decodeRecord(item *sink.BatchItem) (record, error) {
+	var rec record
+	if err := json.Unmarshal(item.Body, &rec); err != nil {
+		return rec, listener.Permanent(fmt.Errorf("failed to decode buffered change: %w", err))
+	}
+	if len(rec.Rows) == 0 {
+		return rec, listener.Permanent(fmt.Errorf("buffered change has no rows"))
+	}
+	return rec, nil
+}
@@ -0,0 +1,201 @@
+// Package webhook POSTs change notifications to HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/sink"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second
+
+	SignatureHeader = "X-Signature-256"
+	IDHeader        = "X-Event-Id"
+)
+
+// DefaultRetryPolicy retries 5xx responses and transport errors a few times
+// within a handler call, before the listener's own retry policy applies.
+var DefaultRetryPolicy = listener.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+type Endpoint struct {
+	URL string
+	// Secret signs the body with HMAC-SHA256 in SignatureHeader; empty
+	// disables signing.
+	Secret string
+	// Timeout bounds each request (DefaultTimeout when zero).
+	Timeout time.Duration
+	Header  http.Header
+}
+
+type Sink struct {
+	endpoints []Endpoint
+	client    *http.Client
+	encoder   sink.Encoder
+	retry     listener.RetryPolicy
+}
+
+type Option func(*Sink)
+
+func WithClient(c *http.Client) Option {
+	return func(s *Sink) {
+		s.client = c
+	}
+}
+
+func WithEncoder(e sink.Encoder) Option {
+	return func(s *Sink) {
+		s.encoder = e
+	}
+}
+
+func WithRetryPolicy(p listener.RetryPolicy) Option {
+	return func(s *Sink) {
+		s.retry = p
+	}
+}
+
+func New(endpoints []Endpoint, opts ...Option) *Sink {
+	s := &Sink{
+		endpoints: endpoints,
+		client:    http.DefaultClient,
+		encoder:   sink.JSON{},
+		retry:     DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// HandleNotification delivers to every endpoint. If any delivery fails the
+// error covers all failed endpoints; a listener retry will also resend to
+// the ones that succeeded.
+func (s *Sink) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	body, err := s.encoder.Encode(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	var errs []error
+	permanent := true
+	for _, ep := range s.endpoints {
+		if err := s.deliver(ctx, ep, n, body); err != nil {
+			var pe *listener.PermanentError
+			if !errors.As(err, &pe) {
+				permanent = false
+			}
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	if permanent {
+		return listener.Permanent(errors.Join(errs...))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Sink) deliver(ctx context.Context, ep Endpoint, n *listener.ChangeNotification, body []byte) error {
+	attempts := max(s.retry.MaxAttempts, 1)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(s.retry.Backoff(attempt - 1)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		var retryable bool
+		retryable, err = s.post(ctx, ep, n, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable {
+			return listener.Permanent(err)
+		}
+	}
+	return err
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (s *Sink) post(ctx context.Context, ep Endpoint, n *listener.ChangeNotification, body []byte) (bool, error) {
+	timeout := ep.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request for %s: %w", ep.URL, err)
+	}
+	for k, v := range ep.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", s.encoder.ContentType())
+	for k, v := range sink.Attributes(n) {
+		req.Header.Set("X-"+strings.ToUpper(k[:1])+k[1:], v)
+	}
+	if he, ok := s.encoder.(sink.HeaderEncoder); ok {
+		for k, v := range he.Headers(n) {
+			req.Header.Set(k, v)
+		}
+	}
+	if n.ID > 0 {
+		req.Header.Set(IDHeader, strconv.FormatInt(n.ID, 10))
+	}
+	if ep.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(ep.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to post to %s: %w", ep.URL, err)
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("post to %s: %s", ep.URL, resp.Status)
+	default:
+		return false, fmt.Errorf("post to %s: %s", ep.URL, resp.Status)
+	}
+}
+
+// Sign returns the SignatureHeader value for body: "sha256=" followed by
+// the hex HMAC-SHA256.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a SignatureHeader value in constant time, for receivers.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
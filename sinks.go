@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/force-c/pg-data-listener/config"
+	"github.com/force-c/pg-data-listener/encrypt"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/script"
+	"github.com/force-c/pg-data-listener/sink"
+	"github.com/force-c/pg-data-listener/sink/audit"
+	"github.com/force-c/pg-data-listener/sink/clickhouse"
+	"github.com/force-c/pg-data-listener/sink/elasticsearch"
+	"github.com/force-c/pg-data-listener/sink/invalidate"
+	"github.com/force-c/pg-data-listener/sink/kafka"
+	natssink "github.com/force-c/pg-data-listener/sink/nats"
+	"github.com/force-c/pg-data-listener/sink/notify"
+	"github.com/force-c/pg-data-listener/sink/rabbitmq"
+	"github.com/force-c/pg-data-listener/sink/redis"
+	"github.com/force-c/pg-data-listener/sink/search"
+	"github.com/force-c/pg-data-listener/sink/webhook"
+	"github.com/force-c/pg-data-listener/wasm"
+)
+
+// builtSink is a sink created from its config, with the clients to close
+// when it is removed.
+type builtSink struct {
+	cfg     config.Sink
+	handler listener.NotificationHandler
+	closers []io.Closer
+	// audit is the writer of an audit sink, which serves its history.
+	audit *audit.Audit
+	// search is the index of a search sink, which serves its queries.
+	search search.Searcher
+	// calls is read-locked by the handler calls in flight, so Close
+	// waits for them.
+	calls sync.RWMutex
+}
+
+func buildSink(cfg config.Sink, logger *slog.Logger) (*builtSink, error) {
+	s := &builtSink{cfg: cfg}
+	h, err := s.build(logger)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.handler = listener.HandlerFunc(func(ctx context.Context, n *listener.ChangeNotification) error {
+		s.calls.RLock()
+		defer s.calls.RUnlock()
+		return h.HandleNotification(ctx, n)
+	})
+	return s, nil
+}
+
+// build creates the sink, rendering its messages when it has a template
+// and encrypting them or the configured columns when it has encryption.
+func (s *builtSink) build(logger *slog.Logger) (listener.NotificationHandler, error) {
+	cfg := s.cfg
+	var encoder sink.Encoder = sink.JSON{}
+	switch cfg.Encoding {
+	case "cloudevents":
+		encoder = sink.CloudEvents{}
+	case "debezium":
+		encoder = sink.Debezium{}
+	case "msgpack":
+		encoder = listener.MessagePackCodec
+	case "protobuf":
+		encoder = listener.ProtobufCodec
+	}
+	if t := cfg.Template; t != nil {
+		r, err := t.NewRender()
+		if err != nil {
+			return nil, err
+		}
+		encoder = r
+	}
+	if e := cfg.Encrypt; e != nil {
+		c, err := e.NewCipher()
+		if err != nil {
+			return nil, err
+		}
+		if len(e.Fields) > 0 {
+			h, err := s.buildType(logger, encoder)
+			if err != nil {
+				return nil, err
+			}
+			return encrypt.Handler(h, c, e.Fields...), nil
+		}
+		encoder = encrypt.Encoder(encoder, c)
+	}
+	return s.buildType(logger, encoder)
+}
+
+func (s *builtSink) buildType(logger *slog.Logger, encoder sink.Encoder) (listener.NotificationHandler, error) {
+	cfg := s.cfg
+	factory, ok := sink.Lookup(cfg.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+	h, closer, err := factory(sink.Config{
+		Type:     cfg.Type,
+		URL:      cfg.URL,
+		Brokers:  cfg.Brokers,
+		Target:   cfg.Target,
+		Exchange: cfg.Exchange,
+		Secret:   cfg.Secret,
+		Options:  cfg.Options,
+		Encoder:  encoder,
+		Logger:   logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := h.(*audit.Audit); ok {
+		s.audit = a
+	}
+	if si, ok := h.(searchIndexer); ok {
+		s.search = si.index
+	}
+	if closer != nil {
+		s.closers = append(s.closers, closer)
+	}
+	if b := cfg.Batch; b != nil {
+		bh, ok := h.(listener.BatchHandler)
+		if !ok {
+			return nil, fmt.Errorf("%s sinks do not support batch", cfg.Type)
+		}
+		batchCfg := listener.BatchConfig{
+			Size:   b.Size,
+			Linger: time.Duration(b.Linger),
+			OnError: func(_ context.Context, f *listener.Failure) {
+				n := f.Notification
+				logger.Error("giving up on notification",
+					"sink", cfg.Type, "table", n.QualifiedTable(), "operation", n.Operation,
+					"attempts", f.Attempts, "error", f.Err)
+			},
+		}
+		if b.Retry != nil {
+			batchCfg.Retry = b.Retry.Policy()
+		}
+		batching := listener.NewBatching(bh, batchCfg)
+		// The pending batch is written before the sink closes.
+		s.closers = append([]io.Closer{batching}, s.closers...)
+		return batching, nil
+	}
+	return h, nil
+}
+
+// The built-in sink types; others register themselves from the packages
+// imported in plugins.go.
+func init() {
+	sink.Register("log", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		return listener.HandlerFunc(func(_ context.Context, n *listener.ChangeNotification) error {
+			cfg.Logger.Info("change", "table", n.QualifiedTable(), "operation", n.Operation, "data", string(n.Data))
+			return nil
+		}), nil, nil
+	})
+	sink.Register("webhook", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		return webhook.New([]webhook.Endpoint{{URL: cfg.URL, Secret: cfg.Secret}}, webhook.WithEncoder(cfg.Encoder)), nil, nil
+	})
+	sink.Register("kafka", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		opts := []kafka.Option{kafka.WithEncoder(cfg.Encoder)}
+		if cfg.Target != "" {
+			opts = append(opts, kafka.WithTopic(cfg.Target))
+		}
+		k := kafka.New(cfg.Brokers, opts...)
+		return k, k, nil
+	})
+	sink.Register("nats", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		nc, err := nats.Connect(cfg.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		closer := closerFunc(func() error { nc.Close(); return nil })
+		opts := []natssink.Option{natssink.WithEncoder(cfg.Encoder)}
+		if cfg.Target != "" {
+			opts = append(opts, natssink.WithSubject(cfg.Target))
+		}
+		h, err := natssink.New(nc, opts...)
+		if err != nil {
+			closer.Close()
+			return nil, nil, err
+		}
+		return h, closer, nil
+	})
+	sink.Register("redis", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		ropts, err := goredis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		client := goredis.NewClient(ropts)
+		opts := []redis.Option{redis.WithEncoder(cfg.Encoder)}
+		if cfg.Target != "" {
+			opts = append(opts, redis.WithChannel(cfg.Target))
+		}
+		return redis.New(client, opts...), client, nil
+	})
+	sink.Register("rabbitmq", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		opts := []rabbitmq.Option{rabbitmq.WithEncoder(cfg.Encoder)}
+		if cfg.Target != "" {
+			opts = append(opts, rabbitmq.WithRoutingKey(cfg.Target))
+		}
+		r := rabbitmq.New(cfg.URL, cfg.Exchange, opts...)
+		return r, r, nil
+	})
+	sink.Register("elasticsearch", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		var opts []elasticsearch.Option
+		if cfg.Target != "" {
+			opts = append(opts, elasticsearch.WithIndex(cfg.Target))
+		}
+		es := elasticsearch.New(cfg.URL, opts...)
+		return es, es, nil
+	})
+	sink.Register("clickhouse", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		var opts []clickhouse.Option
+		if cfg.Target != "" {
+			opts = append(opts, clickhouse.WithTable(cfg.Target))
+		}
+		ch := clickhouse.New(cfg.URL, opts...)
+		return ch, ch, nil
+	})
+	sink.Register("audit", auditSink)
+	sink.Register("invalidate", invalidateSink)
+	sink.Register("search", searchSink)
+	sink.Register("wasm", wasmSink)
+	sink.Register("slack", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		return notifySink(cfg, notify.Slack(cfg.URL))
+	})
+	sink.Register("teams", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		return notifySink(cfg, notify.Teams(cfg.URL))
+	})
+	sink.Register("email", func(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+		var to []string
+		for addr := range strings.SplitSeq(cfg.Options["to"], ",") {
+			to = append(to, strings.TrimSpace(addr))
+		}
+		return notifySink(cfg, notify.Email(notify.SMTP{
+			Addr:     cfg.Options["addr"],
+			From:     cfg.Options["from"],
+			To:       to,
+			Username: cfg.Options["username"],
+			Password: cfg.Secret,
+		}))
+	})
+}
+
+// auditSink writes into the audit table target of the database at the
+// url, creating it, and maintains its partitions until the sink closes:
+// the partition option is day or month, retention_days drops older ones.
+func auditSink(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+	db, err := sql.Open("postgres", cfg.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []audit.Option{audit.WithLogger(cfg.Logger)}
+	if cfg.Target != "" {
+		opts = append(opts, audit.WithTable(cfg.Target))
+	}
+	if cfg.Options["partition"] == "month" {
+		opts = append(opts, audit.WithPartitioning(audit.Monthly))
+	}
+	if v := cfg.Options["retention_days"]; v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("retention_days: %w", err)
+		}
+		opts = append(opts, audit.WithRetention(time.Duration(days)*24*time.Hour))
+	}
+	a := audit.New(db, opts...)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = a.Init(ctx)
+	cancel()
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go a.Run(ctx)
+	return a, closerFunc(func() error { cancel(); return db.Close() }), nil
+}
+
+// invalidateSink deletes the cache keys of the comma-separated keys option
+// from the Redis (redis:// or rediss://) or memcached
+// (memcached://host:port,host:port) url.
+func invalidateSink(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+	var templates []string
+	for t := range strings.SplitSeq(cfg.Options["keys"], ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			templates = append(templates, t)
+		}
+	}
+	var d invalidate.Deleter
+	var closer io.Closer
+	if servers, ok := strings.CutPrefix(cfg.URL, "memcached://"); ok {
+		d = invalidate.Memcached(strings.Split(strings.TrimSuffix(servers, "/"), ",")...)
+	} else {
+		ropts, err := goredis.ParseURL(cfg.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		client := goredis.NewClient(ropts)
+		d, closer = invalidate.Redis(client), client
+	}
+	inv, err := invalidate.New(d, templates...)
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, nil, err
+	}
+	return inv, closer, nil
+}
+
+// searchIndexer is the handler of a search sink, keeping its index.
+type searchIndexer struct {
+	*search.Indexer
+	index *search.BleveIndex
+}
+
+// searchSink indexes the rows in the Bleve index at the path option, or
+// in memory without it; GET /admin/search queries it.
+func searchSink(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+	idx, err := search.OpenBleve(cfg.Options["path"])
+	if err != nil {
+		return nil, nil, err
+	}
+	return searchIndexer{Indexer: search.New(idx), index: idx}, idx, nil
+}
+
+// wasmSink runs the plugin module in the file at the path option, see
+// package wasm; the instances and memory_mb options size it.
+func wasmSink(cfg sink.Config) (listener.NotificationHandler, io.Closer, error) {
+	var wc wasm.Config
+	if v := cfg.Options["instances"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("instances: %w", err)
+		}
+		wc.Instances = n
+	}
+	if v := cfg.Options["memory_mb"]; v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("memory_mb: %w", err)
+		}
+		wc.MemoryLimit = mb << 20
+	}
+	p, err := wasm.LoadFile(context.Background(), cfg.Options["path"], wc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, p, nil
+}
+
+// notifySink posts the messages of a slack, teams or email sink: its
+// template renders the text, the subject and throttle options set the
+// subject and the interval per table.
+func notifySink(cfg sink.Config, sender notify.Sender) (listener.NotificationHandler, io.Closer, error) {
+	var opts []notify.Option
+	if r, ok := cfg.Encoder.(*sink.Render); ok {
+		opts = append(opts, notify.WithBody(r))
+	}
+	if text := cfg.Options["subject"]; text != "" {
+		r, err := sink.NewRender(text, "")
+		if err != nil {
+			return nil, nil, fmt.Errorf("subject: %w", err)
+		}
+		opts = append(opts, notify.WithSubject(r))
+	}
+	if v := cfg.Options["throttle"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("throttle: %w", err)
+		}
+		opts = append(opts, notify.WithThrottle(d))
+	}
+	return notify.New(sender, opts...), nil, nil
+}
+
+// Close waits for the handler calls in flight, e.g. of the registrations
+// a reload replaced, and closes the clients of the sink. Calls made later
+// fail on the closed clients.
+func (s *builtSink) Close() error {
+	s.calls.Lock()
+	defer s.calls.Unlock()
+
+	var errs []error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tableHandler delivers the changes of t, or their summaries when t is
+// aggregated, to its sinks, all of them or those its route or script
+// chooses.
+func tableHandler(t config.Table, sinks map[string]*builtSink) (listener.NotificationHandler, error) {
+	var h listener.NotificationHandler
+	switch {
+	case t.Script != "":
+		s, err := script.Compile(t.Script)
+		if err != nil {
+			return nil, fmt.Errorf("script of table %s: %w", t.Name, err)
+		}
+		handlers := make(map[string]listener.NotificationHandler, len(t.Sinks))
+		for _, name := range t.Sinks {
+			handlers[name] = sinks[name].handler
+		}
+		h = script.Handler(s, handlers)
+	case t.Route == "":
+		handlers := make([]listener.NotificationHandler, len(t.Sinks))
+		for i, name := range t.Sinks {
+			handlers[i] = sinks[name].handler
+		}
+		h = fanout(handlers)
+	default:
+		expr, err := listener.CompileExpr(t.Route)
+		if err != nil {
+			return nil, fmt.Errorf("route of table %s: %w", t.Name, err)
+		}
+		handlers := make(map[string]listener.NotificationHandler, len(t.Sinks))
+		for _, name := range t.Sinks {
+			handlers[name] = sinks[name].handler
+		}
+		h = listener.RouteHandler(expr, handlers)
+	}
+	if a := t.Aggregate; a != nil {
+		// A replaced aggregator still emits its current window when the
+		// window ends.
+		h = listener.NewAggregator(h, listener.AggregateConfig{Window: time.Duration(a.Window), Sum: a.Sum})
+	}
+	return h, nil
+}
+
+// fanout delivers to every handler in order, failing if any fails. A
+// notification an asynchronous sink acknowledges later is done once every
+// sink acknowledged it.
+func fanout(hs []listener.NotificationHandler) listener.NotificationHandler {
+	if len(hs) == 1 {
+		return hs[0]
+	}
+	return listener.HandlerFunc(func(ctx context.Context, n *listener.ChangeNotification) error {
+		var errs []error
+		parts, done := listener.Parts(ctx, len(hs))
+		for i, h := range hs {
+			if err := h.HandleNotification(parts[i], n); err != nil {
+				errs = append(errs, err)
+			}
+			done(i)
+		}
+		return errors.Join(errs...)
+	})
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
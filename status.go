@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// status queries GET /admin/status of a running instance.
+func status(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "status [flags]")
+	addr := fs.String("addr", "localhost:9090", "HTTP address of the running instance")
+	raw := fs.Bool("json", false, "print the raw JSON response")
+	token := fs.String("token", os.Getenv("PGDL_ADMIN_TOKEN"), "admin API bearer token, by default $PGDL_ADMIN_TOKEN")
+	timeout := fs.Duration("timeout", 5*time.Second, "request timeout")
+	fs.Parse(args)
+
+	base := *addr
+	if strings.HasPrefix(base, ":") {
+		base = "localhost" + base
+	}
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(base, "/")+"/admin/status", nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid address %s: %v\n", base, err)
+		return exitConfig
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to query %s: %v\n", base, err)
+		return exitFailure
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "failed to query %s: %s\n", base, resp.Status)
+		return exitFailure
+	}
+
+	var st adminStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to decode status: %v\n", err)
+		return exitFailure
+	}
+	if *raw {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(st)
+		return exitOK
+	}
+
+	l := st.Listener
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "running:\t%t\n", l.Running)
+	if l.Standby {
+		fmt.Fprintf(w, "standby:\twaiting for leadership\n")
+	}
+	fmt.Fprintf(w, "connected:\t%t\n", l.Connected)
+	fmt.Fprintf(w, "last ping:\t%s\n", since(l.LastPing))
+	fmt.Fprintf(w, "last notification:\t%s\n", since(l.LastNotification))
+	fmt.Fprintf(w, "queue:\t%d/%d\n", l.QueueDepth, l.QueueCapacity)
+	if l.Paused {
+		fmt.Fprintf(w, "paused:\t%d held\n", l.Held)
+	}
+	fmt.Fprintf(w, "channels:\t%s\n", strings.Join(l.Channels, ", "))
+	if len(l.Shards) > 0 {
+		fmt.Fprintf(w, "shards:\t%s\n", joinInts(l.Shards))
+	}
+	if l.Slot != nil {
+		fmt.Fprintf(w, "slot:\t%s (active %t)\n", l.Slot.Name, l.Slot.Active)
+	}
+	fmt.Fprintf(w, "sinks:\t%s\n", strings.Join(st.Sinks, ", "))
+	for _, t := range st.Tables {
+		name := t.Name
+		if t.Channel != "" {
+			name += " (" + t.Channel + ")"
+		}
+		if t.Tenant != "" {
+			name += " (tenant " + t.Tenant + ")"
+		}
+		if t.Source != "" {
+			name = t.Source + "." + name
+		}
+		fmt.Fprintf(w, "table %s:\t%s\n", name, strings.Join(t.Sinks, ", "))
+	}
+	running := l.Running || l.Standby
+	for _, name := range slices.Sorted(maps.Keys(st.Sources)) {
+		src := st.Sources[name]
+		fmt.Fprintf(w, "source %s:\trunning %t, connected %t, queue %d/%d, channels %s\n",
+			name, src.Running, src.Connected, src.QueueDepth, src.QueueCapacity, strings.Join(src.Channels, ", "))
+		running = running && (src.Running || src.Standby)
+	}
+	w.Flush()
+
+	if !running {
+		return exitFailure
+	}
+	return exitOK
+}
+
+func since(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
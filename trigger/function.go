@@ -0,0 +1,273 @@
+package trigger
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// PayloadMode selects what the trigger function puts in the NOTIFY payload.
+type PayloadMode int
+
+const (
+	// PayloadInline sends the full row data.
+	PayloadInline PayloadMode = iota
+	// PayloadReference sends only schema, table, operation and primary
+	// key; the listener fetches the row before dispatching.
+	PayloadReference
+	// PayloadReferenceOversized sends the full row unless the payload
+	// would exceed MaxNotifyPayload, and a reference otherwise.
+	PayloadReferenceOversized
+	// PayloadChunked sends the full row, splitting payloads exceeding
+	// MaxNotifyPayload into several base64-encoded chunk notifications
+	// that the listener reassembles.
+	PayloadChunked
+)
+
+// MaxNotifyPayload is the largest payload sent inline. Postgres rejects
+// NOTIFY payloads of 8000 bytes or more; the margin leaves room for the
+// outbox id and sequence number.
+const MaxNotifyPayload = 7900
+
+// ChunkSize is the number of base64 characters per chunk notification.
+const ChunkSize = 7000
+
+// CompressMinSize is the payload size from which WithCompression
+// compresses payloads; smaller ones gain little.
+const CompressMinSize = 1024
+
+var functionBody = template.Must(template.New("body").Parse(`
+DECLARE
+    payload JSON;
+    row_data JSON;
+    old_data JSON;
+    key_data JSONB;
+    channel TEXT := {{.Channel}};
+{{- if .Compress}}
+    message TEXT;
+{{- end}}
+{{- if .Chunked}}
+    encoded TEXT;
+    chunk_id TEXT;
+    chunk_total INT;
+{{- end}}
+{{- if .Outbox}}
+    event_id BIGINT;
+{{- end}}
+{{- if .Sequence}}
+    seq_no BIGINT;
+{{- end}}
+{{- if .Transactions}}
+    tx_counter TEXT;
+    tx_changes BIGINT;
+{{- end}}
+BEGIN
+    IF TG_NARGS > 0 THEN
+        channel = TG_ARGV[0];
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        row_data = row_to_json(OLD);
+    ELSE
+        row_data = row_to_json(NEW);
+    END IF;
+    IF TG_OP = 'UPDATE' THEN
+        old_data = row_to_json(OLD);
+    END IF;
+
+    IF TG_NARGS > 1 THEN
+        key_data = '{}';
+        FOR i IN 1..TG_NARGS - 1 LOOP
+            key_data = key_data || jsonb_build_object(TG_ARGV[i], row_data::jsonb -> TG_ARGV[i]);
+        END LOOP;
+    END IF;
+
+    payload = json_build_object(
+        'schema', TG_TABLE_SCHEMA,
+        'table', TG_TABLE_NAME,
+        'operation', TG_OP,
+        'key', key_data,
+        'data', row_data,
+        'old', old_data,
+        'timestamp', CURRENT_TIMESTAMP{{if .Transactions}},
+        'txid', txid_current(){{end}}{{if .Actor}},
+        'actor', {{.Actor}}{{end}}
+    );
+{{- if .Transactions}}
+
+    -- Counts the transaction's changes on this channel for the commit
+    -- marker; the setting is local to the transaction.
+    tx_counter = 'pg_data_listener.tx_' || md5(channel);
+    tx_changes = COALESCE(NULLIF(NULLIF(current_setting(tx_counter, true), ''), 'done'), '0')::bigint + 1;
+    PERFORM set_config(tx_counter, tx_changes::text, true);
+{{- end}}
+
+{{- if .Sequence}}
+
+    -- The row lock is held until commit, so numbers follow commit order
+    -- and a rolled-back change leaves no gap.
+    INSERT INTO {{.Sequence}} AS s (channel, seq) VALUES (channel, 1)
+    ON CONFLICT (channel) DO UPDATE SET seq = s.seq + 1
+    RETURNING s.seq INTO seq_no;
+    payload = (payload::jsonb || jsonb_build_object('seq', seq_no))::json;
+{{- end}}
+
+{{- if .Outbox}}
+    INSERT INTO {{.Outbox}} (channel, payload) VALUES (channel, payload::jsonb)
+    RETURNING id INTO event_id;
+{{- end}}
+
+{{- if .Reference}}
+{{if .Oversized}}
+    IF key_data IS NOT NULL AND octet_length(payload::text) > {{.MaxPayload}} THEN
+{{- else}}
+    IF key_data IS NOT NULL THEN
+{{- end}}
+        payload = json_build_object(
+            'schema', TG_TABLE_SCHEMA,
+            'table', TG_TABLE_NAME,
+            'operation', TG_OP,
+            'key', key_data,
+            'ref', true,
+            'timestamp', CURRENT_TIMESTAMP{{if .Transactions}},
+            'txid', txid_current(){{end}}{{if .Actor}},
+            'actor', {{.Actor}}{{end}}
+        );
+    END IF;
+{{- end}}
+
+{{- if .Outbox}}
+    payload = (payload::jsonb || jsonb_build_object('id', event_id))::json;
+{{- end}}
+
+{{- if and .Sequence .Reference}}
+    payload = (payload::jsonb || jsonb_build_object('seq', seq_no))::json;
+{{- end}}
+
+{{- if .Compress}}
+
+    message = payload::text;
+    IF octet_length(message) > {{.CompressMin}} THEN
+        message = 'z:{{.Algorithm}}:' || replace(encode({{.Compress}}(convert_to(message, 'UTF8')), 'base64'), E'\n', '');
+    END IF;
+{{- end}}
+
+{{- if .Chunked}}
+
+    IF octet_length({{.Message}}) > {{.MaxPayload}} THEN
+        encoded = replace(encode(convert_to({{.Message}}, 'UTF8'), 'base64'), E'\n', '');
+        chunk_id = gen_random_uuid()::text;
+        chunk_total = ceil(length(encoded)::numeric / {{.ChunkSize}})::int;
+        FOR i IN 0..chunk_total - 1 LOOP
+            PERFORM pg_notify(channel, json_build_object(
+                'chunk', json_build_object('id', chunk_id, 'index', i, 'total', chunk_total),
+                'part', substr(encoded, i * {{.ChunkSize}} + 1, {{.ChunkSize}})
+            )::text);
+        END LOOP;
+        RETURN NULL;
+    END IF;
+{{- end}}
+
+    PERFORM pg_notify(channel, {{.Message}});
+    RETURN NULL;
+END;
+`))
+
+// commitBody is the function of the deferred constraint trigger that sends
+// one commit marker per transaction and channel once all row triggers have
+// fired. Notifications are delivered in the order they were sent, so the
+// marker follows the transaction's changes.
+var commitBody = template.Must(template.New("commit").Parse(`
+DECLARE
+    channel TEXT := {{.Channel}};
+    tx_counter TEXT;
+    marker JSON;
+{{- if .Outbox}}
+    event_id BIGINT;
+{{- end}}
+BEGIN
+    IF TG_NARGS > 0 THEN
+        channel = TG_ARGV[0];
+    END IF;
+
+    tx_counter = 'pg_data_listener.tx_' || md5(channel);
+    IF COALESCE(current_setting(tx_counter, true), '') IN ('', 'done') THEN
+        RETURN NULL;
+    END IF;
+
+    marker = json_build_object(
+        'commit', true,
+        'txid', txid_current(),
+        'changes', current_setting(tx_counter)::bigint,
+        'timestamp', CURRENT_TIMESTAMP
+    );
+    PERFORM set_config(tx_counter, 'done', true);
+
+{{- if .Outbox}}
+    INSERT INTO {{.Outbox}} (channel, payload) VALUES (channel, marker::jsonb)
+    RETURNING id INTO event_id;
+    marker = (marker::jsonb || jsonb_build_object('id', event_id))::json;
+{{- end}}
+
+    PERFORM pg_notify(channel, marker::text);
+    RETURN NULL;
+END;
+`))
+
+type functionParams struct {
+	Channel      string
+	Outbox       string
+	Sequence     string
+	Transactions bool
+	Actor        string
+	Reference    bool
+	Oversized    bool
+	Chunked      bool
+	MaxPayload   int
+	ChunkSize    int
+	Compress     string
+	Algorithm    string
+	CompressMin  int
+	Message      string
+}
+
+func (in *Installer) functionBody() string {
+	return in.render(functionBody)
+}
+
+func (in *Installer) commitBody() string {
+	return in.render(commitBody)
+}
+
+func (in *Installer) render(body *template.Template) string {
+	var b strings.Builder
+	params := functionParams{
+		Channel:      quoteLiteral(in.channel),
+		Reference:    in.payloadMode == PayloadReference || in.payloadMode == PayloadReferenceOversized,
+		Oversized:    in.payloadMode == PayloadReferenceOversized,
+		Chunked:      in.payloadMode == PayloadChunked,
+		MaxPayload:   MaxNotifyPayload,
+		ChunkSize:    ChunkSize,
+		Transactions: in.transactions,
+		Message:      "payload::text",
+	}
+	if in.compression != "" {
+		params.Compress = quoteName(in.compressFunction)
+		params.Algorithm = in.compression
+		params.CompressMin = CompressMinSize
+		params.Message = "message"
+	}
+	if in.actor {
+		params.Actor = fmt.Sprintf("COALESCE(NULLIF(current_setting(%s, true), ''), session_user)", quoteLiteral(ActorSetting))
+	}
+	if in.outbox != "" {
+		params.Outbox = quoteName(in.outbox)
+	}
+	if in.sequence != "" {
+		params.Sequence = quoteName(in.sequence)
+	}
+	if err := body.Execute(&b, params); err != nil {
+		panic(err)
+	}
+	return b.String()
+}
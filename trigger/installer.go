@@ -0,0 +1,443 @@
+package trigger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultFunctionName  = "generic_table_notify"
+	DefaultChannel       = "data_changes"
+	DefaultOutboxTable   = "data_listener_outbox"
+	DefaultSequenceTable = "data_listener_sequences"
+)
+
+// ActorSetting is the setting an application sets, with SET LOCAL or
+// set_config, to name the user behind a change for WithActor.
+const ActorSetting = "pg_data_listener.actor"
+
+// Installer creates the notify trigger function and the per-table triggers
+// that emit the payload expected by the listener package.
+type Installer struct {
+	db           *sql.DB
+	functionName string
+	channel      string
+	outbox       string
+	sequence     string
+	transactions bool
+	actor        bool
+	payloadMode  PayloadMode
+
+	compression      string
+	compressFunction string
+}
+
+type Option func(*Installer)
+
+// WithFunctionName sets the (optionally schema-qualified) trigger function
+// name.
+func WithFunctionName(name string) Option {
+	return func(in *Installer) {
+		in.functionName = name
+	}
+}
+
+// WithChannel sets the NOTIFY channel installed triggers publish to.
+func WithChannel(channel string) Option {
+	return func(in *Installer) {
+		in.channel = channel
+	}
+}
+
+// WithOutbox makes the trigger function also insert every change into an
+// outbox table, so the listener can catch up on events missed while it was
+// disconnected. Install creates the table if needed.
+func WithOutbox(table string) Option {
+	return func(in *Installer) {
+		if table == "" {
+			table = DefaultOutboxTable
+		}
+		in.outbox = table
+	}
+}
+
+// WithSequence numbers the notifications of each channel consecutively, so
+// the listener can detect missed ones. The counters live in table, one row
+// per channel; changes on the same channel serialize on that row until they
+// commit. Transactions that write to several channels in different orders
+// can deadlock on those rows, and PostgreSQL aborts one of them. Install
+// creates the table if needed.
+func WithSequence(table string) Option {
+	return func(in *Installer) {
+		if table == "" {
+			table = DefaultSequenceTable
+		}
+		in.sequence = table
+	}
+}
+
+// WithTransactions adds the transaction id to every payload and installs a
+// deferred constraint trigger per table that sends a commit marker after
+// each transaction's changes, so the listener can group them. With the
+// outbox enabled the markers are stored there too.
+func WithTransactions() Option {
+	return func(in *Installer) {
+		in.transactions = true
+	}
+}
+
+// WithActor adds who made each change to the payload: the value of
+// ActorSetting when the application set it, the session user otherwise.
+func WithActor() Option {
+	return func(in *Installer) {
+		in.actor = true
+	}
+}
+
+// WithPayloadMode selects inline or by-reference payloads. Reference
+// payloads require every table to have a primary key.
+func WithPayloadMode(mode PayloadMode) Option {
+	return func(in *Installer) {
+		in.payloadMode = mode
+	}
+}
+
+// WithCompression compresses payloads larger than CompressMinSize with
+// algorithm, "gzip" or "zstd", and sends them base64 encoded behind a short
+// header the listener recognizes, so larger rows fit into a notification.
+// Postgres has no compression function built in: function names one taking
+// and returning bytea, such as gzip from the pgsql-gzip extension, the
+// default for "gzip". With PayloadReferenceOversized the size limit still
+// applies to the uncompressed payload; PayloadChunked splits what remains
+// too large after compression.
+func WithCompression(algorithm, function string) Option {
+	return func(in *Installer) {
+		if function == "" && algorithm == "gzip" {
+			function = "gzip"
+		}
+		in.compression = algorithm
+		in.compressFunction = function
+	}
+}
+
+func NewInstaller(db *sql.DB, opts ...Option) *Installer {
+	in := &Installer{
+		db:           db,
+		functionName: DefaultFunctionName,
+		channel:      DefaultChannel,
+	}
+	for _, opt := range opts {
+		opt(in)
+	}
+	return in
+}
+
+// FunctionSQL returns the CREATE FUNCTION statement for the trigger function.
+func (in *Installer) FunctionSQL() string {
+	return fmt.Sprintf("CREATE OR REPLACE FUNCTION %s()\nRETURNS TRIGGER AS $body$%s$body$ LANGUAGE plpgsql",
+		quoteName(in.functionName), in.functionBody())
+}
+
+// CommitFunctionSQL returns the CREATE FUNCTION statement for the commit
+// marker function, or "" when transactions are disabled.
+func (in *Installer) CommitFunctionSQL() string {
+	if !in.transactions {
+		return ""
+	}
+	return fmt.Sprintf("CREATE OR REPLACE FUNCTION %s()\nRETURNS TRIGGER AS $body$%s$body$ LANGUAGE plpgsql",
+		quoteName(in.commitFunctionName()), in.commitBody())
+}
+
+func (in *Installer) commitFunctionName() string {
+	return in.functionName + "_commit"
+}
+
+// OutboxSQL returns the statements that create the outbox table, or nil when
+// the outbox is disabled.
+func (in *Installer) OutboxSQL() []string {
+	if in.outbox == "" {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    id BIGSERIAL PRIMARY KEY,
+    channel TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`, quoteName(in.outbox)),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (channel, id)",
+			pq.QuoteIdentifier(baseName(in.outbox)+"_channel_id_idx"), quoteName(in.outbox)),
+	}
+}
+
+// SequenceSQL returns the statement that creates the sequence table, or nil
+// when sequence numbers are disabled.
+func (in *Installer) SequenceSQL() []string {
+	if in.sequence == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    channel TEXT PRIMARY KEY,
+    seq BIGINT NOT NULL
+)`, quoteName(in.sequence))}
+}
+
+// TriggerSQL returns the statements that (re)create the trigger on table.
+// The key columns are passed to the function so that payloads carry the
+// row's primary key.
+func (in *Installer) TriggerSQL(table string, keyColumns ...string) []string {
+	name := pq.QuoteIdentifier(TriggerName(table))
+	args := []string{quoteLiteral(in.channel)}
+	for _, col := range keyColumns {
+		args = append(args, quoteLiteral(col))
+	}
+	stmts := []string{
+		fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", name, quoteName(table)),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s FOR EACH ROW EXECUTE FUNCTION %s(%s)",
+			name, quoteName(table), quoteName(in.functionName), strings.Join(args, ", ")),
+	}
+	if in.transactions {
+		commit := pq.QuoteIdentifier(CommitTriggerName(table))
+		stmts = append(stmts,
+			fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", commit, quoteName(table)),
+			fmt.Sprintf("CREATE CONSTRAINT TRIGGER %s AFTER INSERT OR UPDATE OR DELETE ON %s DEFERRABLE INITIALLY DEFERRED FOR EACH ROW EXECUTE FUNCTION %s(%s)",
+				commit, quoteName(table), quoteName(in.commitFunctionName()), quoteLiteral(in.channel)))
+	}
+	return stmts
+}
+
+// PrimaryKey returns the primary key columns of table, in key order.
+func (in *Installer) PrimaryKey(ctx context.Context, table string) ([]string, error) {
+	return primaryKey(ctx, in.db, table)
+}
+
+func primaryKey(ctx context.Context, q queryer, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = to_regclass($1) AND i.indisprimary
+		ORDER BY array_position(i.indkey::int2[], a.attnum)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up primary key of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var cols []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, rows.Err()
+}
+
+// Install creates or replaces the trigger function and installs a trigger on
+// every table, all in one transaction.
+func (in *Installer) Install(ctx context.Context, tables ...string) error {
+	switch {
+	case in.compression != "" && in.compression != "gzip" && in.compression != "zstd":
+		return fmt.Errorf("unknown compression %q", in.compression)
+	case in.compression != "" && in.compressFunction == "":
+		return fmt.Errorf("compression %s requires a compression function", in.compression)
+	}
+	return in.inTx(ctx, func(tx *sql.Tx) error {
+		for _, stmt := range in.OutboxSQL() {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to create outbox %s: %w", in.outbox, err)
+			}
+		}
+		for _, stmt := range in.SequenceSQL() {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to create sequence table %s: %w", in.sequence, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, in.FunctionSQL()); err != nil {
+			return fmt.Errorf("failed to create function %s: %w", in.functionName, err)
+		}
+		if stmt := in.CommitFunctionSQL(); stmt != "" {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("failed to create function %s: %w", in.commitFunctionName(), err)
+			}
+		}
+		for _, table := range tables {
+			key, err := primaryKey(ctx, tx, table)
+			if err != nil {
+				return err
+			}
+			if len(key) == 0 && (in.payloadMode == PayloadReference || in.payloadMode == PayloadReferenceOversized) {
+				return fmt.Errorf("table %s has no primary key, required for reference payloads", table)
+			}
+			for _, stmt := range in.TriggerSQL(table, key...) {
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("failed to install trigger on %s: %w", table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Uninstall drops the triggers on the given tables. With no tables it drops
+// every trigger using the function, and the function itself.
+func (in *Installer) Uninstall(ctx context.Context, tables ...string) error {
+	return in.inTx(ctx, func(tx *sql.Tx) error {
+		dropFunction := len(tables) == 0
+		if dropFunction {
+			installed, err := in.installedTables(ctx, tx)
+			if err != nil {
+				return err
+			}
+			tables = installed
+		}
+
+		for _, table := range tables {
+			for _, name := range []string{TriggerName(table), CommitTriggerName(table)} {
+				stmt := fmt.Sprintf("DROP TRIGGER IF EXISTS %s ON %s", pq.QuoteIdentifier(name), quoteName(table))
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("failed to drop trigger on %s: %w", table, err)
+				}
+			}
+		}
+
+		if dropFunction {
+			for _, fn := range []string{in.functionName, in.commitFunctionName()} {
+				stmt := fmt.Sprintf("DROP FUNCTION IF EXISTS %s()", quoteName(fn))
+				if _, err := tx.ExecContext(ctx, stmt); err != nil {
+					return fmt.Errorf("failed to drop function %s: %w", fn, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// VerifyError lists everything Verify found wrong with the installation.
+type VerifyError struct {
+	Problems []string
+}
+
+func (e *VerifyError) Error() string {
+	return "trigger verification failed: " + strings.Join(e.Problems, "; ")
+}
+
+// Verify checks that the trigger function exists and matches the installed
+// version, and that every table has an enabled trigger calling it. With no
+// tables only the function is checked.
+func (in *Installer) Verify(ctx context.Context, tables ...string) error {
+	var problems []string
+
+	functions := map[string]string{in.functionName: in.functionBody()}
+	if in.transactions {
+		functions[in.commitFunctionName()] = in.commitBody()
+	}
+	for _, name := range []string{in.functionName, in.commitFunctionName()} {
+		want, ok := functions[name]
+		if !ok {
+			continue
+		}
+		var body sql.NullString
+		err := in.db.QueryRowContext(ctx,
+			`SELECT prosrc FROM pg_proc WHERE oid = to_regproc($1)`, name).Scan(&body)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			problems = append(problems, fmt.Sprintf("function %s does not exist", name))
+		case err != nil:
+			return fmt.Errorf("failed to look up function %s: %w", name, err)
+		case strings.TrimSpace(body.String) != strings.TrimSpace(want):
+			problems = append(problems, fmt.Sprintf("function %s is out of date", name))
+		}
+	}
+
+	for _, table := range tables {
+		triggers := []string{TriggerName(table)}
+		if in.transactions {
+			triggers = append(triggers, CommitTriggerName(table))
+		}
+		for _, name := range triggers {
+			var enabled string
+			err := in.db.QueryRowContext(ctx, `
+				SELECT t.tgenabled
+				FROM pg_trigger t
+				WHERE t.tgrelid = to_regclass($1) AND t.tgname = $2 AND NOT t.tgisinternal`,
+				table, name).Scan(&enabled)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				problems = append(problems, fmt.Sprintf("trigger %s missing on %s", name, table))
+			case err != nil:
+				return fmt.Errorf("failed to look up trigger on %s: %w", table, err)
+			case enabled == "D":
+				problems = append(problems, fmt.Sprintf("trigger %s disabled on %s", name, table))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &VerifyError{Problems: problems}
+	}
+	return nil
+}
+
+// InstalledTables returns the schema-qualified tables that have a trigger
+// calling the function.
+func (in *Installer) InstalledTables(ctx context.Context) ([]string, error) {
+	return in.installedTables(ctx, in.db)
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (in *Installer) installedTables(ctx context.Context, q queryer) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT n.nspname, c.relname
+		FROM pg_trigger t
+		JOIN pg_class c ON c.oid = t.tgrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE t.tgfoid = to_regproc($1) AND NOT t.tgisinternal
+		ORDER BY n.nspname, c.relname`, in.functionName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list triggers: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, schema+"."+table)
+	}
+	return tables, rows.Err()
+}
+
+func (in *Installer) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := in.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// TriggerName returns the name of the trigger installed on table.
+func TriggerName(table string) string {
+	return baseName(table) + "_change_trigger"
+}
+
+// CommitTriggerName returns the name of the commit marker trigger installed
+// on table with WithTransactions.
+func CommitTriggerName(table string) string {
+	return baseName(table) + "_commit_trigger"
+}
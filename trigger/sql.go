@@ -0,0 +1,28 @@
+package trigger
+
+import (
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// quoteName quotes a possibly schema-qualified name such as "public.s_user".
+func quoteName(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// baseName returns the unqualified part of a possibly schema-qualified name.
+func baseName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func quoteLiteral(s string) string {
+	return pq.QuoteLiteral(s)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/force-c/pg-data-listener/config"
+	"github.com/force-c/pg-data-listener/listener"
+	"github.com/force-c/pg-data-listener/trigger"
+)
+
+var payloadModes = map[string]trigger.PayloadMode{
+	"inline":              trigger.PayloadInline,
+	"reference":           trigger.PayloadReference,
+	"reference-oversized": trigger.PayloadReferenceOversized,
+	"chunked":             trigger.PayloadChunked,
+}
+
+// installerFlags defines the trigger.Installer options on fs.
+func installerFlags(fs *flag.FlagSet) func() ([]trigger.Option, error) {
+	function := fs.String("function", trigger.DefaultFunctionName, "trigger function name")
+	channel := fs.String("channel", trigger.DefaultChannel, "channel the triggers notify")
+	outbox := fs.String("outbox", "", "also write changes to this outbox table")
+	sequence := fs.String("sequence", "", "number notifications using this sequence table")
+	transactions := fs.Bool("transactions", false, "send commit markers for transaction grouping")
+	actor := fs.Bool("actor", false, "add who made each change, from "+trigger.ActorSetting+" or the session user")
+	payload := fs.String("payload", "inline", "payload mode: inline, reference, reference-oversized or chunked")
+	compress := fs.String("compress", "", "compress large payloads: gzip or zstd")
+	compressFunction := fs.String("compress-function", "", "SQL function compressing bytea, gzip by default for gzip")
+
+	return func() ([]trigger.Option, error) {
+		mode, ok := payloadModes[*payload]
+		if !ok {
+			return nil, fmt.Errorf("unknown payload mode %q", *payload)
+		}
+		opts := []trigger.Option{
+			trigger.WithFunctionName(*function),
+			trigger.WithChannel(*channel),
+			trigger.WithPayloadMode(mode),
+		}
+		if *outbox != "" {
+			opts = append(opts, trigger.WithOutbox(*outbox))
+		}
+		if *sequence != "" {
+			opts = append(opts, trigger.WithSequence(*sequence))
+		}
+		if *transactions {
+			opts = append(opts, trigger.WithTransactions())
+		}
+		if *actor {
+			opts = append(opts, trigger.WithActor())
+		}
+		if *compress != "" {
+			opts = append(opts, trigger.WithCompression(*compress, *compressFunction))
+		}
+		return opts, nil
+	}
+}
+
+// installTriggers installs the triggers on the tables given as arguments,
+// or on the tables of the config that are not patterns.
+func installTriggers(args []string) int {
+	fs := flag.NewFlagSet("install-triggers", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "install-triggers [flags] [table...]")
+	configPath, flags := configFlags(fs)
+	installerOptions := installerFlags(fs)
+	fs.Parse(args)
+
+	cfg, logger, _, ok := loadConfig(*configPath, flags)
+	if !ok {
+		return exitConfig
+	}
+	opts, err := installerOptions()
+	if err != nil {
+		logger.Error("invalid flags", "error", err)
+		return exitConfig
+	}
+	tables := fs.Args()
+	if len(tables) == 0 {
+		tables = configTables(cfg)
+	}
+	if len(tables) == 0 {
+		logger.Error("no tables given")
+		return exitConfig
+	}
+
+	d, err := resolveDatabase(cfg.Database, cfg.Secrets.Resolver())
+	if err != nil {
+		logger.Error("failed to resolve secrets", "error", err)
+		return exitConfig
+	}
+	db, err := listener.OpenDB(d.ConnString(), d.ConnConfig())
+	if err != nil {
+		logger.Error("failed to open database", "error", err)
+		return exitFailure
+	}
+	defer db.Close()
+
+	if err := trigger.NewInstaller(db, opts...).Install(context.Background(), tables...); err != nil {
+		logger.Error("failed to install triggers", "error", err)
+		return exitFailure
+	}
+	logger.Info("installed triggers", "tables", tables)
+	return exitOK
+}
+
+// uninstallTriggers drops the triggers on the tables given as arguments,
+// or every trigger using the function and the function itself.
+func uninstallTriggers(args []string) int {
+	fs := flag.NewFlagSet("uninstall-triggers", flag.ExitOnError)
+	fs.Usage = commandUsage(fs, "uninstall-triggers [flags] [table...]")
+	configPath, flags := configFlags(fs)
+	installerOptions := installerFlags(fs)
+	fs.Parse(args)
+
+	cfg, logger, _, ok := loadConfig(*configPath, flags)
+	if !ok {
+		return exitConfig
+	}
+	opts, err := installerOptions()
+	if err != nil {
+		logger.Error("invalid flags", "error", err)
+		return exitConfig
+	}
+
+	d, err := resolveDatabase(cfg.Database, cfg.Secrets.Resolver())
+	if err != nil {
+		logger.Error("failed to resolve secrets", "error", err)
+		return exitConfig
+	}
+	db, err := listener.OpenDB(d.ConnString(), d.ConnConfig())
+	if err != nil {
+		logger.Error("failed to open database", "error", err)
+		return exitFailure
+	}
+	defer db.Close()
+
+	if err := trigger.NewInstaller(db, opts...).Uninstall(context.Background(), fs.Args()...); err != nil {
+		logger.Error("failed to uninstall triggers", "error", err)
+		return exitFailure
+	}
+	logger.Info("uninstalled triggers", "tables", fs.Args())
+	return exitOK
+}
+
+// configTables returns the table names of cfg that are not patterns.
+func configTables(cfg *config.Config) []string {
+	var tables []string
+	for _, t := range cfg.Tables {
+		if !strings.ContainsAny(t.Name, "*?[") {
+			tables = append(tables, t.Name)
+		}
+	}
+	return tables
+}
@@ -0,0 +1,159 @@
+package wasm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// DefaultMemoryLimit caps the linear memory of a plugin instance, in bytes.
+const DefaultMemoryLimit = 16 << 20
+
+// pageSize is the size of a WebAssembly memory page.
+const pageSize = 64 << 10
+
+// Config configures how a plugin is run.
+type Config struct {
+	// Instances is the number of module instances, so the number of
+	// notifications handled concurrently. The default is 1.
+	Instances int
+	// MemoryLimit caps the linear memory of each instance in bytes,
+	// rounded up to whole pages. The default is DefaultMemoryLimit.
+	MemoryLimit int
+}
+
+// Plugin is a plugin module run with wazero. Each notification is handled
+// by an idle instance; a call that ctx cancels, e.g. on the handler
+// timeout, aborts the instance, which is replaced by a new one.
+type Plugin struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	idle     chan *instance
+}
+
+type instance struct {
+	mod api.Module
+	h   *handler
+}
+
+// LoadFile loads the plugin module in the file at path, see Load.
+func LoadFile(ctx context.Context, path string, cfg Config) (*Plugin, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(ctx, code, cfg)
+}
+
+// Load compiles the plugin module code and instantiates it. The module may
+// import WASI, e.g. as compiled by TinyGo or Rust's wasm32-wasip1 target,
+// but has no preopened directories, environment variables or arguments,
+// and its output is discarded. A reactor module's _initialize export runs
+// once per instance.
+func Load(ctx context.Context, code []byte, cfg Config) (*Plugin, error) {
+	if cfg.Instances <= 0 {
+		cfg.Instances = 1
+	}
+	if cfg.MemoryLimit <= 0 {
+		cfg.MemoryLimit = DefaultMemoryLimit
+	}
+	pages := uint32((cfg.MemoryLimit + pageSize - 1) / pageSize)
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true))
+
+	p := &Plugin{runtime: rt, idle: make(chan *instance, cfg.Instances)}
+	if err := p.compile(ctx, code); err != nil {
+		rt.Close(ctx)
+		return nil, err
+	}
+	for range cfg.Instances {
+		inst, err := p.instantiate(ctx)
+		if err != nil {
+			rt.Close(ctx)
+			return nil, err
+		}
+		p.idle <- inst
+	}
+	return p, nil
+}
+
+func (p *Plugin) compile(ctx context.Context, code []byte) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+		return fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	compiled, err := p.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return fmt.Errorf("failed to compile plugin: %w", err)
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		return errors.New("plugin does not export memory")
+	}
+	for _, name := range []string{"alloc", "handle"} {
+		if _, ok := compiled.ExportedFunctions()[name]; !ok {
+			return fmt.Errorf("plugin does not export %s", name)
+		}
+	}
+	p.compiled = compiled
+	return nil
+}
+
+func (p *Plugin) instantiate(ctx context.Context) (*instance, error) {
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate plugin: %w", err)
+	}
+	return &instance{mod: mod, h: &handler{m: wazeroModule{mod}}}, nil
+}
+
+// HandleNotification calls the plugin's handle export on an idle
+// instance, waiting for one while all are busy.
+func (p *Plugin) HandleNotification(ctx context.Context, n *listener.ChangeNotification) error {
+	var inst *instance
+	select {
+	case inst = <-p.idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { p.idle <- inst }()
+
+	if inst.mod.IsClosed() {
+		fresh, err := p.instantiate(context.WithoutCancel(ctx))
+		if err != nil {
+			return err
+		}
+		inst = fresh
+	}
+	return inst.h.handle(ctx, n)
+}
+
+// Close closes every instance and releases the compiled module.
+func (p *Plugin) Close() error {
+	return p.runtime.Close(context.Background())
+}
+
+// wazeroModule adapts a wazero module to Module.
+type wazeroModule struct {
+	api.Module
+}
+
+func (m wazeroModule) Call(ctx context.Context, name string, params ...uint64) ([]uint64, error) {
+	f := m.ExportedFunction(name)
+	if f == nil {
+		return nil, ErrNotExported
+	}
+	return f.Call(ctx, params...)
+}
+
+func (m wazeroModule) Memory() Memory {
+	return m.Module.Memory()
+}
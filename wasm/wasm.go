@@ -0,0 +1,122 @@
+// Package wasm runs handlers compiled to WebAssembly, so handlers written
+// in any language are loaded at runtime and sandboxed by the runtime.
+//
+// A plugin module exports its linear memory as "memory" and:
+//
+//	alloc(size i32) i32            // returns a buffer of size bytes
+//	handle(ptr i32, len i32) i32   // handles the notification JSON at ptr
+//
+// handle returns StatusOK, StatusRetry for failures worth retrying or
+// StatusPermanent. After a failure the host calls the optional export
+//
+//	error_message() i64            // ptr << 32 | len of a UTF-8 message
+//
+// Load compiles a module and runs it with wazero, sandboxed: it can only
+// import WASI, gets no file system, environment or network, and its memory
+// is capped. Handler adapts modules run by another runtime.
+//
+//	p, err := wasm.LoadFile(ctx, "orders.wasm", wasm.Config{Instances: 4})
+//	defer p.Close()
+//	dl.Handle("orders", p)
+package wasm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/force-c/pg-data-listener/listener"
+)
+
+// The results of the handle export.
+const (
+	StatusOK        = 0
+	StatusRetry     = 1
+	StatusPermanent = 2
+)
+
+// Module is an instantiated plugin module.
+type Module interface {
+	// Call calls the exported function name with WebAssembly values
+	// encoded as uint64, as wazero's api.Function does. It returns
+	// ErrNotExported when there is no such export.
+	Call(ctx context.Context, name string, params ...uint64) ([]uint64, error)
+	Memory() Memory
+}
+
+// Memory is the linear memory of a Module.
+type Memory interface {
+	Read(offset, size uint32) ([]byte, bool)
+	Write(offset uint32, data []byte) bool
+}
+
+// ErrNotExported is returned by Module.Call for missing exports.
+var ErrNotExported = errors.New("function not exported")
+
+// Handler calls the plugin's handle export with each notification as
+// JSON. A module instance is not reentrant, so calls are serialized; use
+// one Handler per instance to handle notifications concurrently.
+func Handler(m Module) listener.NotificationHandler {
+	h := &handler{m: m}
+	return listener.HandlerFunc(h.handle)
+}
+
+type handler struct {
+	mu sync.Mutex
+	m  Module
+}
+
+func (h *handler) handle(ctx context.Context, n *listener.ChangeNotification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return listener.Permanent(fmt.Errorf("failed to encode notification: %w", err))
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res, err := h.m.Call(ctx, "alloc", uint64(len(payload)))
+	if err != nil {
+		return fmt.Errorf("plugin alloc failed: %w", err)
+	}
+	if len(res) != 1 {
+		return listener.Permanent(errors.New("plugin alloc returned no pointer"))
+	}
+	ptr := uint32(res[0])
+	if !h.m.Memory().Write(ptr, payload) {
+		return listener.Permanent(fmt.Errorf("plugin alloc returned %d bytes out of memory", len(payload)))
+	}
+	res, err = h.m.Call(ctx, "handle", uint64(ptr), uint64(len(payload)))
+	if err != nil {
+		// Traps such as unreachable or out of bounds accesses.
+		return fmt.Errorf("plugin handle failed: %w", err)
+	}
+	if len(res) != 1 {
+		return listener.Permanent(errors.New("plugin handle returned no status"))
+	}
+
+	switch status := uint32(res[0]); status {
+	case StatusOK:
+		return nil
+	case StatusRetry:
+		return fmt.Errorf("plugin failed: %s", h.errorMessage(ctx))
+	case StatusPermanent:
+		return listener.Permanent(fmt.Errorf("plugin failed: %s", h.errorMessage(ctx)))
+	default:
+		return listener.Permanent(fmt.Errorf("plugin returned unknown status %d", status))
+	}
+}
+
+// errorMessage reads the message of the failure the plugin reported.
+func (h *handler) errorMessage(ctx context.Context) string {
+	res, err := h.m.Call(ctx, "error_message")
+	if err != nil || len(res) != 1 || res[0] == 0 {
+		return "no error message"
+	}
+	msg, ok := h.m.Memory().Read(uint32(res[0]>>32), uint32(res[0]))
+	if !ok {
+		return "error message out of memory"
+	}
+	return string(msg)
+}